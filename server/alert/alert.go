@@ -0,0 +1,133 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var logger = log.New("module", "alert")
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "critical"
+	}
+}
+
+func ParseSeverity(s string) (Severity, error) {
+	switch strings.ToLower(s) {
+	case "info":
+		return SeverityInfo, nil
+	case "warning", "warn":
+		return SeverityWarning, nil
+	case "critical", "crit":
+		return SeverityCritical, nil
+	default:
+		return SeverityInfo, fmt.Errorf("unknown severity: %s", s)
+	}
+}
+
+type Alert struct {
+	Severity Severity  `json:"-"`
+	Level    string    `json:"severity"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("[%s] %s: %s", strings.ToUpper(a.Level), a.Title, a.Message)
+}
+
+// Transport delivers alerts to the operator
+type Transport interface {
+	Send(ctx context.Context, alert Alert) error
+}
+
+type route struct {
+	name        string
+	transport   Transport
+	minSeverity Severity
+}
+
+// Alerter routes operator alerts to every transport whose minimum severity
+// is met. A nil Alerter drops all alerts.
+type Alerter struct {
+	routes []route
+}
+
+// MessageSender sends a direct message, it's implemented by the nostr client
+type MessageSender interface {
+	SendMessage(ctx context.Context, sk, receiverPub, msg string) error
+}
+
+// NewAlerter builds the transports configured for the operator. Nostr DMs
+// are sent from the bot's key with the given sender.
+func NewAlerter(config *types.Config, sender MessageSender) *Alerter {
+	a := &Alerter{}
+	for _, tc := range config.Operator.Transports {
+		minSeverity, err := ParseSeverity(tc.MinSeverity)
+		if err != nil {
+			logger.Warn("Invalid min severity of transport, fallback to warning", "type", tc.Type, "err", err)
+			minSeverity = SeverityWarning
+		}
+
+		var transport Transport
+		switch tc.Type {
+		case "nostr":
+			transport = NewNostrTransport(sender, config.Bot.SK, tc.Pubkey)
+		case "webhook":
+			transport = NewWebhookTransport(tc.URL)
+		case "email":
+			transport = NewEmailTransport(tc.SMTPHost, tc.SMTPUser, tc.SMTPPassword, tc.From, tc.To)
+		case "stdout":
+			transport = NewStdoutTransport()
+		default:
+			logger.Warn("Unknown alert transport", "type", tc.Type)
+			continue
+		}
+
+		a.routes = append(a.routes, route{name: tc.Type, transport: transport, minSeverity: minSeverity})
+	}
+	return a
+}
+
+// Notify sends the alert to all transports routed for its severity
+func (a *Alerter) Notify(ctx context.Context, severity Severity, title, message string) {
+	if a == nil {
+		return
+	}
+
+	alert := Alert{
+		Severity: severity,
+		Level:    severity.String(),
+		Title:    title,
+		Message:  message,
+		Time:     time.Now(),
+	}
+	for _, r := range a.routes {
+		if severity < r.minSeverity {
+			continue
+		}
+		if err := r.transport.Send(ctx, alert); err != nil {
+			logger.Warn("Failed to send alert", "transport", r.name, "title", title, "err", err)
+		}
+	}
+}
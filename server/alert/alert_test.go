@@ -0,0 +1,53 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTransport struct {
+	alerts []Alert
+}
+
+func (t *recordingTransport) Send(ctx context.Context, alert Alert) error {
+	t.alerts = append(t.alerts, alert)
+	return nil
+}
+
+func TestSeverityRouting(t *testing.T) {
+	all := &recordingTransport{}
+	critical := &recordingTransport{}
+	a := &Alerter{routes: []route{
+		{name: "all", transport: all, minSeverity: SeverityInfo},
+		{name: "critical", transport: critical, minSeverity: SeverityCritical},
+	}}
+
+	a.Notify(context.Background(), SeverityInfo, "started", "")
+	a.Notify(context.Background(), SeverityCritical, "database unavailable", "")
+
+	assert.Len(t, all.alerts, 2)
+	assert.Len(t, critical.alerts, 1)
+	assert.Equal(t, "database unavailable", critical.alerts[0].Title)
+
+	// nil alerter drops alerts
+	var none *Alerter
+	none.Notify(context.Background(), SeverityCritical, "ignored", "")
+}
+
+func TestWebhookTransport(t *testing.T) {
+	var received Alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	err := NewWebhookTransport(server.URL).Send(context.Background(), Alert{Level: "warning", Title: "slow relay"})
+	assert.NoError(t, err)
+	assert.Equal(t, "warning", received.Level)
+	assert.Equal(t, "slow relay", received.Title)
+}
@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// NostrTransport sends alerts as NIP-04 direct messages
+type NostrTransport struct {
+	sender      MessageSender
+	sk          string
+	receiverPub string
+}
+
+func NewNostrTransport(sender MessageSender, sk, receiverPub string) *NostrTransport {
+	return &NostrTransport{sender: sender, sk: sk, receiverPub: receiverPub}
+}
+
+func (t *NostrTransport) Send(ctx context.Context, alert Alert) error {
+	return t.sender.SendMessage(ctx, t.sk, t.receiverPub, alert.String())
+}
+
+// WebhookTransport posts alerts as JSON
+type WebhookTransport struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookTransport(url string) *WebhookTransport {
+	return &WebhookTransport{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (t *WebhookTransport) Send(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailTransport sends alerts through an SMTP server with PLAIN auth
+type EmailTransport struct {
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func NewEmailTransport(host, user, password, from string, to []string) *EmailTransport {
+	return &EmailTransport{host: host, user: user, password: password, from: from, to: to}
+}
+
+func (t *EmailTransport) Send(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if t.user != "" {
+		hostname, _, err := net.SplitHostPort(t.host)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", t.user, t.password, hostname)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [nossence] %s\r\n\r\n%s\r\n",
+		t.from, strings.Join(t.to, ", "), alert.Title, alert.String())
+	return smtp.SendMail(t.host, auth, t.from, t.to, []byte(msg))
+}
+
+// StdoutTransport prints alerts, mostly useful for development
+type StdoutTransport struct{}
+
+func NewStdoutTransport() *StdoutTransport {
+	return &StdoutTransport{}
+}
+
+func (t *StdoutTransport) Send(ctx context.Context, alert Alert) error {
+	_, err := fmt.Fprintf(os.Stdout, "%s %s\n", alert.Time.Format(time.RFC3339), alert.String())
+	return err
+}
@@ -0,0 +1,354 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+var logger = log.New("module", "archive")
+
+const (
+	dayLayout     = "2006/01/02"
+	segmentSuffix = ".jsonl.gz"
+	indexSuffix   = ".idx.json"
+)
+
+// Archiver writes raw events as gzip-compressed JSONL segments to an object
+// store. Events are partitioned by the day they were created, so a segment
+// key looks like 'events/2023/03/22/1679443200000000000-1000.jsonl.gz'.
+type Archiver struct {
+	config types.ArchiveConfig
+	store  Store
+
+	mu       sync.Mutex
+	segments map[string]*segment
+	// failed holds closed segments whose upload failed, they're retried on
+	// the next flush
+	failed []*segment
+}
+
+type segment struct {
+	day     string
+	startAt time.Time
+	count   int
+	authors map[string]int
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	closed  bool
+}
+
+// segmentIndex is uploaded alongside each segment so that lookups by author
+// can skip segments without downloading them
+type segmentIndex struct {
+	Count   int            `json:"count"`
+	Authors map[string]int `json:"authors"`
+}
+
+func NewArchiver(config *types.Config, store Store) *Archiver {
+	return &Archiver{
+		config:   config.Archive,
+		store:    store,
+		segments: make(map[string]*segment),
+	}
+}
+
+// Start flushes segments periodically until ctx is done
+func (a *Archiver) Start(ctx context.Context) {
+	interval, err := time.ParseDuration(a.config.FlushInterval)
+	if err != nil {
+		logger.Error("Invalid flush interval, fallback to 5m", "interval", a.config.FlushInterval, "err", err)
+		interval = 5 * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.Flush(ctx); err != nil {
+					logger.Error("Failed to flush archive segments", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Append adds an event to the segment of its day, the segment is uploaded
+// once it's full
+func (a *Archiver) Append(ctx context.Context, event *nostr.Event) error {
+	raw, err := event.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	day := event.CreatedAt.UTC().Format(dayLayout)
+
+	a.mu.Lock()
+	seg, ok := a.segments[day]
+	if !ok {
+		seg = newSegment(day)
+		a.segments[day] = seg
+	}
+	if err := seg.append(event.PubKey, raw); err != nil {
+		a.mu.Unlock()
+		return err
+	}
+
+	var full *segment
+	if seg.count >= a.config.SegmentSize {
+		full = seg
+		delete(a.segments, day)
+	}
+	a.mu.Unlock()
+
+	if full != nil {
+		if err := a.upload(ctx, full); err != nil {
+			a.retryLater(full)
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush uploads all pending segments along with those failed before. A
+// segment is only dropped after it's been uploaded.
+func (a *Archiver) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	pending := a.failed
+	for _, seg := range a.segments {
+		pending = append(pending, seg)
+	}
+	a.segments = make(map[string]*segment)
+	a.failed = nil
+	a.mu.Unlock()
+
+	var lastErr error
+	for _, seg := range pending {
+		if err := a.upload(ctx, seg); err != nil {
+			a.retryLater(seg)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (a *Archiver) retryLater(seg *segment) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failed = append(a.failed, seg)
+}
+
+// Replay reads archived events created in [from, to] day by day and feeds
+// them to fn in segment order
+func (a *Archiver) Replay(ctx context.Context, from, to time.Time, fn func(*nostr.Event) error) (int, error) {
+	total := 0
+	err := a.scan(ctx, from, to, nil, func(ev *nostr.Event) {
+		if err := fn(ev); err != nil {
+			logger.Warn("Failed to replay event", "id", ev.ID, "err", err)
+			return
+		}
+		total++
+	})
+	return total, err
+}
+
+// Query returns archived events created in [from, to], optionally restricted
+// to the given authors and kinds
+func (a *Archiver) Query(ctx context.Context, from, to time.Time, authors []string, kinds []int) ([]*nostr.Event, error) {
+	events := []*nostr.Event{}
+	err := a.scan(ctx, from, to, authors, func(ev *nostr.Event) {
+		if len(authors) > 0 && !slices.Contains(authors, ev.PubKey) {
+			return
+		}
+		if len(kinds) > 0 && !slices.Contains(kinds, ev.Kind) {
+			return
+		}
+		events = append(events, ev)
+	})
+	return events, err
+}
+
+// Find looks up a single archived event, createdAt is used to locate the day
+func (a *Archiver) Find(ctx context.Context, id string, createdAt time.Time) (*nostr.Event, error) {
+	var found *nostr.Event
+	err := a.scan(ctx, createdAt, createdAt, nil, func(ev *nostr.Event) {
+		if ev.ID == id {
+			found = ev
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+// scan walks through segments of the days covering [from, to]. If authors is
+// given, segments whose index contains none of them are skipped.
+func (a *Archiver) scan(ctx context.Context, from, to time.Time, authors []string, fn func(*nostr.Event)) error {
+	for day := truncateDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		keys, err := a.store.List(ctx, segmentPrefix(day.Format(dayLayout)))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if !strings.HasSuffix(key, segmentSuffix) {
+				continue
+			}
+
+			if len(authors) > 0 && !a.segmentHasAuthors(ctx, key, authors) {
+				continue
+			}
+
+			events, err := a.ReadSegment(ctx, key)
+			if err != nil {
+				return err
+			}
+
+			for _, ev := range events {
+				if ev.CreatedAt.Before(from) || ev.CreatedAt.After(to) {
+					continue
+				}
+				fn(ev)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Archiver) segmentHasAuthors(ctx context.Context, key string, authors []string) bool {
+	body, err := a.store.Get(ctx, strings.TrimSuffix(key, segmentSuffix)+indexSuffix)
+	if err != nil {
+		// segments without index have to be read
+		return true
+	}
+
+	var index segmentIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return true
+	}
+
+	for _, author := range authors {
+		if index.Authors[author] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSegment downloads and decodes a segment
+func (a *Archiver) ReadSegment(ctx context.Context, key string) ([]*nostr.Event, error) {
+	body, err := a.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	events := []*nostr.Event{}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		ev := new(nostr.Event)
+		if err := ev.UnmarshalJSON(line); err != nil {
+			logger.Warn("Skip malformed archived event", "key", key, "err", err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+func (a *Archiver) upload(ctx context.Context, seg *segment) error {
+	if err := seg.close(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s%d-%d", segmentPrefix(seg.day), seg.startAt.UnixNano(), seg.count)
+	if err := a.store.Put(ctx, name+segmentSuffix, seg.buf.Bytes()); err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(segmentIndex{Count: seg.count, Authors: seg.authors})
+	if err != nil {
+		return err
+	}
+	if err := a.store.Put(ctx, name+indexSuffix, index); err != nil {
+		logger.Warn("Failed to upload segment index", "key", name+indexSuffix, "err", err)
+	}
+
+	logger.Debug("Uploaded archive segment", "key", name+segmentSuffix, "events", seg.count, "bytes", seg.buf.Len())
+	return nil
+}
+
+func newSegment(day string) *segment {
+	seg := &segment{
+		day:     day,
+		startAt: time.Now(),
+		authors: make(map[string]int),
+	}
+	seg.gz = gzip.NewWriter(&seg.buf)
+	return seg
+}
+
+func (seg *segment) append(author string, raw []byte) error {
+	if _, err := seg.gz.Write(raw); err != nil {
+		return err
+	}
+	if _, err := seg.gz.Write([]byte("\n")); err != nil {
+		return err
+	}
+	seg.authors[author]++
+	seg.count++
+	return nil
+}
+
+// close finishes the gzip stream, it's a no-op for segments closed before so
+// failed uploads can be retried
+func (seg *segment) close() error {
+	if seg.closed {
+		return nil
+	}
+	if err := seg.gz.Close(); err != nil {
+		return err
+	}
+	seg.closed = true
+	return nil
+}
+
+func segmentPrefix(day string) string {
+	return "events/" + strings.TrimSuffix(day, "/") + "/"
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
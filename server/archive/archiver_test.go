@@ -0,0 +1,122 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveAndReplay(t *testing.T) {
+	ctx := context.Background()
+	config := &types.Config{
+		Archive: types.ArchiveConfig{
+			Enabled:     true,
+			SegmentSize: 2,
+		},
+	}
+	archiver := NewArchiver(config, NewFileStore(t.TempDir()))
+
+	sk := nostr.GeneratePrivateKey()
+	day := time.Date(2023, 3, 22, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ev := nostr.Event{
+			Kind:      1,
+			Content:   "hello",
+			CreatedAt: day.Add(time.Duration(i) * time.Hour),
+		}
+		ev.Sign(sk)
+		assert.NoError(t, archiver.Append(ctx, &ev))
+	}
+	assert.NoError(t, archiver.Flush(ctx))
+
+	keys, err := archiver.store.List(ctx, "events/2023/03/22/")
+	assert.NoError(t, err)
+	// two segments, each with an index
+	assert.Len(t, keys, 4)
+
+	replayed := []string{}
+	total, err := archiver.Replay(ctx, day, day.Add(90*time.Minute), func(ev *nostr.Event) error {
+		replayed = append(replayed, ev.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Len(t, replayed, 2)
+}
+
+func TestQueryByAuthor(t *testing.T) {
+	ctx := context.Background()
+	config := &types.Config{
+		Archive: types.ArchiveConfig{
+			Enabled:     true,
+			SegmentSize: 1,
+		},
+	}
+	archiver := NewArchiver(config, NewFileStore(t.TempDir()))
+
+	alice, bob := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	alicePub, _ := nostr.GetPublicKey(alice)
+	day := time.Date(2023, 3, 22, 12, 0, 0, 0, time.UTC)
+	for _, sk := range []string{alice, bob, bob} {
+		pub, _ := nostr.GetPublicKey(sk)
+		ev := nostr.Event{PubKey: pub, Kind: 1, Content: "gm", CreatedAt: day}
+		ev.Sign(sk)
+		assert.NoError(t, archiver.Append(ctx, &ev))
+	}
+
+	events, err := archiver.Query(ctx, day.Add(-time.Hour), day.Add(time.Hour), []string{alicePub}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, alicePub, events[0].PubKey)
+
+	found, err := archiver.Find(ctx, events[0].ID, day)
+	assert.NoError(t, err)
+	assert.Equal(t, events[0].ID, found.ID)
+}
+
+// flakyStore fails every Put while down
+type flakyStore struct {
+	Store
+	down bool
+}
+
+func (s *flakyStore) Put(ctx context.Context, key string, body []byte) error {
+	if s.down {
+		return errors.New("store is down")
+	}
+	return s.Store.Put(ctx, key, body)
+}
+
+func TestRetryFailedUpload(t *testing.T) {
+	ctx := context.Background()
+	config := &types.Config{
+		Archive: types.ArchiveConfig{
+			Enabled:     true,
+			SegmentSize: 1,
+		},
+	}
+	store := &flakyStore{Store: NewFileStore(t.TempDir()), down: true}
+	archiver := NewArchiver(config, store)
+
+	sk := nostr.GeneratePrivateKey()
+	day := time.Date(2023, 3, 22, 12, 0, 0, 0, time.UTC)
+	ev := nostr.Event{Kind: 1, Content: "hello", CreatedAt: day}
+	ev.Sign(sk)
+	assert.Error(t, archiver.Append(ctx, &ev))
+	assert.Error(t, archiver.Flush(ctx))
+
+	// the segment is kept until the store is back
+	store.down = false
+	assert.NoError(t, archiver.Flush(ctx))
+	assert.Empty(t, archiver.failed)
+
+	events, err := archiver.Query(ctx, day, day, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, ev.ID, events[0].ID)
+}
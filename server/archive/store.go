@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Store is a minimal object storage abstraction used to keep archived segments
+type Store interface {
+	Put(ctx context.Context, key string, body []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+func NewStore(config *types.Config) Store {
+	conf := config.Archive
+	switch conf.Backend {
+	case "s3":
+		return NewS3Store(conf.S3)
+	default:
+		return NewFileStore(filepath.Join(config.Objects.Root, "archive"))
+	}
+}
+
+// FileStore keeps objects on local disk, keys are mapped to relative paths
+type FileStore struct {
+	root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+func (fs *FileStore) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(fs.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temp file first so that readers never see partial segments
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (fs *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(fs.root, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return body, err
+}
+
+func (fs *FileStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys := []string{}
+	err := filepath.Walk(fs.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(fs.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
@@ -2,12 +2,15 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
+	"github.com/dyng/nosdaily/correlation"
 	n "github.com/dyng/nosdaily/nostr"
 	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/supervisor"
 	"github.com/dyng/nosdaily/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/nbd-wtf/go-nostr"
@@ -25,8 +28,18 @@ const (
 
 type BotApplication struct {
 	Bot    *Bot
+	Client n.IClient
 	config *types.Config
 	Worker *Worker
+	// restarts the listener, the cron scheduler and relays stopping
+	// unexpectedly
+	Supervisor *supervisor.Supervisor
+	// nil unless handover is enabled
+	handover   *handover
+	drainGrace time.Duration
+	drainOnce  sync.Once
+	draining   chan struct{}
+	drained    chan struct{}
 }
 
 type Bot struct {
@@ -35,6 +48,8 @@ type Bot struct {
 	config  *types.Config
 	SK      string
 	pub     string
+	// new subscribers waiting for a welcome message
+	welcomes *welcomeQueue
 }
 
 func NewBotApplication(config *types.Config, service *service.Service) *BotApplication {
@@ -44,6 +59,31 @@ func NewBotApplication(config *types.Config, service *service.Service) *BotAppli
 	if err != nil {
 		panic(err)
 	}
+	client.Fanout = config.Bot.PublishFanout
+	if config.Bot.PublishProxy != "" {
+		if err := client.SetProxy(ctx, config.Bot.PublishProxy); err != nil {
+			logger.Error("failed to connect to proxy relay, publishing to relays directly", "uri", config.Bot.PublishProxy, "err", err)
+		} else {
+			logger.Info("publishing through proxy relay", "uri", config.Bot.PublishProxy)
+		}
+	}
+	if err := client.SetPayments(config.Bot.Payments, config.Objects.Root); err != nil {
+		panic(err)
+	}
+	client.SetRemoteSigner(config.Bot.Signer)
+	sup := supervisor.New(config.Supervisor)
+	client.SetSupervisor(sup)
+	if config.Bot.Delegation.Enabled {
+		validity, err := time.ParseDuration(config.Bot.Delegation.Validity)
+		if err != nil {
+			panic(err)
+		}
+		if err := client.SetDelegator(config.Bot.SK, validity); err != nil {
+			panic(err)
+		}
+		logger.Info("publishing channels under delegation", "validity", validity)
+	}
+	client.StartLatencyProbe(ctx, 10*time.Minute)
 
 	bot, err := NewBot(ctx, client, service, config)
 	if err != nil {
@@ -55,133 +95,480 @@ func NewBotApplication(config *types.Config, service *service.Service) *BotAppli
 		panic(err)
 	}
 
+	drainGrace, err := time.ParseDuration(config.Handover.DrainGrace)
+	if err != nil {
+		panic(err)
+	}
+
+	var h *handover
+	if config.Handover.Enabled {
+		if h, err = newHandover(config, service); err != nil {
+			panic(err)
+		}
+	}
+
 	return &BotApplication{
-		Bot:    bot,
-		config: config,
-		Worker: worker,
+		Bot:        bot,
+		Client:     client,
+		config:     config,
+		Worker:     worker,
+		Supervisor: sup,
+		handover:   h,
+		drainGrace: drainGrace,
+		draining:   make(chan struct{}),
+		drained:    make(chan struct{}),
+	}
+}
+
+// Drain stops the bot from running cron jobs and handling new events, then
+// releases the bot lease for the next instance. A run in progress stops after
+// its current batch of subscribers. It returns once drained.
+func (ba *BotApplication) Drain(ctx context.Context) error {
+	ba.drainOnce.Do(func() {
+		ba.Worker.Stop()
+		close(ba.draining)
+	})
+
+	select {
+	case <-ba.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// Run runs the bot until ctx is done or it's drained. The bot is supervised,
+// it's restarted with backoff if it stops otherwise, e.g. on losing its lease.
 func (ba *BotApplication) Run(ctx context.Context) error {
-	c, err := ba.Bot.Listen(ctx)
+	defer close(ba.drained)
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	drained := false
+	<-ba.Supervisor.Go(ctx, "bot", func(ctx context.Context) error {
+		err := ba.run(ctx)
+		if err == nil {
+			drained = true
+			stop()
+		}
+		return err
+	})
+	if drained {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// run listens to and handles events, and runs cron jobs, until ctx is done
+// or it's drained, in which case it returns nil
+func (ba *BotApplication) run(ctx context.Context) error {
+	// wait for the instance being replaced to hand over
+	since := time.Now()
+	if ba.handover != nil {
+		cursor, err := ba.handover.acquire(ctx, ba.draining)
+		if err != nil {
+			select {
+			case <-ba.draining:
+				// drained before taking over
+				return nil
+			default:
+				return err
+			}
+		}
+		since = cursor
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := make(chan struct{})
+	if ba.handover != nil {
+		go ba.handover.keep(ctx, func() { close(lost) })
+	}
+
+	if client, ok := ba.Client.(*n.Client); ok {
+		client.AdmitPaidRelays(ctx)
+	}
+
+	c, err := ba.Bot.Listen(ctx, since)
 	if err != nil {
-		logger.Crit("cannot listen to subscribe messages", "err", err)
+		return fmt.Errorf("cannot listen to subscribe messages: %w", err)
 	}
 
-	schedule := "0 * * * *"
-	logger.Info("register worker cron job", "schedule", schedule)
+	ba.Supervisor.Go(ctx, "welcomes", func(ctx context.Context) error {
+		return ba.Bot.RunWelcomes(ctx, ba.onboard)
+	})
 
-	cr := cron.New()
-	defer cr.Stop()
-	cr.AddFunc(schedule, func() {
-		logger.Info("running cron job")
-		ba.Worker.Run(ctx)
+	if ba.jobEnabled(JobDigest) {
+		if err := ba.Worker.Resume(ctx, time.Now()); err != nil {
+			logger.Error("failed to resume interrupted run", "err", err)
+		}
+		if err := ba.Worker.CatchUp(ctx, time.Now()); err != nil {
+			logger.Error("failed to catch up missed runs", "err", err)
+		}
+	}
+
+	// the scheduler is stopped on drain, while running jobs keep ctx
+	cronCtx, stopCron := context.WithCancel(ctx)
+	defer stopCron()
+	cronDone := ba.Supervisor.Go(cronCtx, "cron", func(cronCtx context.Context) error {
+		return ba.runScheduler(cronCtx, ctx)
 	})
+
+	logger.Info("start listening to subscribe messages...", "since", since)
+
+	var drainAt time.Time
+	var grace <-chan time.Time
+	draining := ba.draining
+	seen := newSeenEvents(seenEventsSize)
+	for {
+		select {
+		case ev, ok := <-c:
+			if !ok {
+				return errors.New("subscription closed")
+			}
+			if !handles(ev.CreatedAt, since, drainAt) {
+				logger.Debug("skip event handled by another instance", "id", ev.ID, "createdAt", ev.CreatedAt)
+				continue
+			}
+			if !seen.add(ev.ID) {
+				logger.Debug("skip event handled already", "id", ev.ID)
+				continue
+			}
+			ba.handleEvent(ctx, ev)
+		case <-draining:
+			draining = nil
+			drainAt = time.Now().Truncate(time.Second)
+			if ba.handover != nil {
+				drainAt = ba.handover.drain(drainAt)
+			}
+			logger.Info("draining bot, waiting for running cron jobs", "cursor", drainAt)
+			stopCron()
+			<-cronDone
+			// relays may still deliver events created before the cursor
+			grace = time.After(ba.drainGrace)
+		case <-grace:
+			if ba.handover != nil {
+				if err := ba.handover.release(ctx, drainAt); err != nil {
+					logger.Error("failed to release bot lease", "err", err)
+				}
+			}
+			logger.Info("bot drained", "cursor", drainAt)
+			return nil
+		case <-lost:
+			return errLeaseLost
+		case <-ctx.Done():
+			logger.Info("bot exiting...")
+			return ctx.Err()
+		}
+	}
+}
+
+// runScheduler runs the cron jobs until ctx is done, waiting for running jobs
+// then. It fails if the scheduler stalls, so that it's restarted. Jobs are
+// run with jobCtx.
+func (ba *BotApplication) runScheduler(ctx context.Context, jobCtx context.Context) error {
+	cr := ba.newScheduler(jobCtx)
 	cr.Start()
 
-	logger.Info("start listening to subscribe messages...")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if stalled(cr.Entries(), now) {
+				cr.Stop()
+				return errors.New("scheduler stalled")
+			}
+		case <-ctx.Done():
+			<-cr.Stop().Done()
+			return nil
+		}
+	}
+}
 
-	done := make(chan struct{})
-	defer close(done)
+// stalled tells if a job is overdue, which the scheduler should have run
+func stalled(entries []cron.Entry, now time.Time) bool {
+	for _, entry := range entries {
+		if !entry.Next.IsZero() && now.Sub(entry.Next) > 2*time.Minute {
+			return true
+		}
+	}
+	return false
+}
 
-	go func(c <-chan nostr.Event) {
-		for ev := range c {
-			logger.Info("received mentioning event", "event", ev.Content)
-			if strings.Contains(ev.Content, "#subscribe") {
-				logger.Info("preparing channel", "pubkey", ev.PubKey)
-				channelSK, new, err := ba.Bot.GetOrCreateSubscription(ctx, ev.PubKey)
-				if err != nil {
-					logger.Warn("failed to create channel", "pubkey", ev.PubKey, "err", err)
-					continue
-				}
+// recoverJob records panicking jobs as incidents, without stopping the
+// scheduler
+func (ba *BotApplication) recoverJob(job cron.Job) cron.Job {
+	return cron.FuncJob(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ba.Supervisor.Record(supervisor.Incident{Component: "cron", Reason: fmt.Sprintf("job panicked: %v", r), At: time.Now()})
+			}
+		}()
+		job.Run()
+	})
+}
 
-				if new {
-					err := ba.Bot.SendWelcomeMessage(ctx, channelSK, ev.PubKey)
-					if err != nil {
-						logger.Error("failed to send welcome message", "pubkey", ev.PubKey, "err", err)
-					} else {
-						logger.Info("sent welcome message to new subscriber", "pubkey", ev.PubKey)
-					}
-				} else {
-					restored, err := ba.Bot.RestoreSubscription(ctx, ev.PubKey)
-					if err != nil {
-						logger.Warn("failed to restore subscription", "pubkey", ev.PubKey, "err", err)
-					}
-
-					if restored {
-						logger.Info("sending welcome message to returning subscriber", "pubkey", ev.PubKey)
-						err := ba.Bot.SendWelcomeMessage(ctx, channelSK, ev.PubKey)
-						if err != nil {
-							logger.Warn("failed to send welcome message returning subscriber", "pubkey", ev.PubKey, "err", err)
-						}
-					} else {
-						logger.Info("skip welcome message for existing subscriber", "pubkey", ev.PubKey)
-					}
-				}
+// newScheduler registers the cron jobs enabled, which are run with ctx
+func (ba *BotApplication) newScheduler(ctx context.Context) *cron.Cron {
+	cr := cron.New(cron.WithChain(ba.recoverJob))
 
-				// prepare initial content for first subscription
-				err = ba.Worker.Push(ctx, ev.PubKey, channelSK, PushInterval, PushSize)
-				if err != nil {
-					logger.Error("failed to prepare initial content", "pubkey", ev.PubKey, "err", err)
-				}
-			} else if strings.Contains(ev.Content, "#unsubscribe") {
-				logger.Warn("unsubscribing", "pubkey", ev.PubKey)
-				ba.Bot.TerminateSubscription(ctx, ev.PubKey)
+	ba.addJob(cr, JobDigest, ba.config.Bot.Jobs.Digest, func() {
+		logger.Info("running cron job")
+		ba.Worker.RunScheduled(ctx)
+	})
+
+	if ba.config.Tuning.Enabled {
+		ba.addJob(cr, "tuning", ba.config.Tuning.Schedule, func() {
+			if _, err := ba.Bot.service.ProposeWeights(ctx); err != nil {
+				logger.Error("failed to propose scoring weights", "err", err)
 			}
+		})
+	}
+
+	if ba.config.Bot.Trending.Enabled {
+		ba.addJob(cr, "trending", ba.config.Bot.Trending.Schedule, func() {
+			if err := ba.Worker.UpdateTrending(ctx, time.Now()); err != nil {
+				logger.Error("failed to update trending channel", "err", err)
+			}
+		})
+	}
+
+	for _, channel := range ba.config.Bot.TopicChannels {
+		if channel.Schedule == "" {
+			continue
 		}
+		channel := channel
+		ba.addJob(cr, "topic/"+channel.Topic, channel.Schedule, func() {
+			if err := ba.Worker.UpdateTopic(ctx, channel, time.Now()); err != nil {
+				logger.Error("failed to update topic channel", "topic", channel.Topic, "err", err)
+			}
+		})
+	}
 
-		done <- struct{}{}
-	}(c)
+	if ba.config.Bot.Leaderboard.Enabled {
+		ba.addJob(cr, "leaderboard", ba.config.Bot.Leaderboard.Schedule, func() {
+			if err := ba.Worker.UpdateLeaderboard(ctx, time.Now()); err != nil {
+				logger.Error("failed to update leaderboard", "err", err)
+			}
+		})
+	}
 
-	<-done
-	cr.Stop()
-	logger.Info("bot exiting...")
-	return nil
+	if ba.config.Nudge.Enabled {
+		ba.addJob(cr, "nudge", ba.config.Nudge.Schedule, func() {
+			if err := ba.Worker.Nudge(ctx, time.Now()); err != nil {
+				logger.Error("failed to send follow reminders", "err", err)
+			}
+		})
+	}
+
+	if ba.config.Retention.Enabled {
+		ba.addJob(cr, "retention", ba.config.Retention.Schedule, func() {
+			now := time.Now()
+			if _, err := ba.Bot.service.PruneRelations(ctx, now); err != nil {
+				logger.Error("failed to prune relations", "err", err)
+			}
+			if _, err := ba.Bot.service.PrunePosts(ctx, now); err != nil {
+				logger.Error("failed to prune posts", "err", err)
+			}
+		})
+	}
+
+	if ba.config.Survey.Enabled {
+		ba.addJob(cr, "survey", ba.config.Survey.Schedule, func() {
+			if err := ba.Worker.Survey(ctx, time.Now()); err != nil {
+				logger.Error("failed to send satisfaction surveys", "err", err)
+			}
+		})
+	}
+
+	if ba.config.Churn.Enabled {
+		ba.addJob(cr, "churn", ba.config.Churn.Schedule, func() {
+			if err := ba.Worker.Reengage(ctx, time.Now()); err != nil {
+				logger.Error("failed to reengage subscribers", "err", err)
+			}
+		})
+	}
+
+	if ba.config.Discovery.Enabled {
+		ba.addJob(cr, "discovery", ba.config.Discovery.Schedule, func() {
+			if err := ba.Worker.PublishRisingAuthors(ctx, time.Now()); err != nil {
+				logger.Error("failed to publish rising authors", "err", err)
+			}
+		})
+	}
+
+	if ba.config.Recap.Enabled {
+		ba.addJob(cr, JobRecap, ba.config.Bot.Jobs.Recap, func() {
+			logger.Info("running recap job")
+			if err := ba.Worker.Recap(ctx); err != nil {
+				logger.Error("failed to publish recaps", "err", err)
+			}
+		})
+	}
+	return cr
+}
+
+// handleEvent handles an event mentioning the bot, or sent to it
+func (ba *BotApplication) handleEvent(ctx context.Context, ev nostr.Event) {
+	ctx = correlation.With(ctx, correlation.New())
+	logger := correlation.Logger(ctx, logger)
+
+	if ev.Kind == 9735 {
+		logger.Info("received zap receipt", "id", ev.ID)
+		if err := ba.Bot.HandleZap(ctx, ev); err != nil {
+			logger.Warn("failed to handle zap", "id", ev.ID, "err", err)
+		}
+		return
+	}
+
+	if ev.Kind == 4 {
+		logger.Info("received direct message", "id", ev.ID, "pubkey", ev.PubKey)
+		if err := ba.Bot.HandleSurveyReply(ctx, ev); err != nil {
+			logger.Warn("failed to handle direct message", "id", ev.ID, "err", err)
+		}
+		return
+	}
+
+	if ev.Kind == types.PreferencesKind {
+		logger.Info("received preferences", "id", ev.ID, "pubkey", ev.PubKey)
+		if err := ba.Bot.HandlePreferences(ctx, ev); err != nil {
+			logger.Warn("failed to handle preferences", "id", ev.ID, "err", err)
+		}
+		return
+	}
+
+	logger.Info("received mentioning event", "kind", ev.Kind, "event", ev.Content)
+	switch cmd := ba.Bot.ParseCommand(ctx, ev); cmd {
+	case CommandSubscribe:
+		logger.Info("preparing channel", "pubkey", ev.PubKey)
+		channelSK, new, err := ba.Bot.GetOrCreateSubscription(ctx, ev.PubKey)
+		if err != nil {
+			logger.Warn("failed to create channel", "pubkey", ev.PubKey, "err", err)
+			return
+		}
+
+		if new {
+			if err := ba.Bot.QueueWelcome(ctx, ev.PubKey); err != nil {
+				logger.Error("failed to queue welcome message", "pubkey", ev.PubKey, "err", err)
+			}
+			return
+		}
+
+		restored, err := ba.Bot.RestoreSubscription(ctx, ev.PubKey)
+		if err != nil {
+			logger.Warn("failed to restore subscription", "pubkey", ev.PubKey, "err", err)
+		}
+		if restored {
+			logger.Info("welcoming returning subscriber", "pubkey", ev.PubKey)
+			if err := ba.Bot.QueueWelcome(ctx, ev.PubKey); err != nil {
+				logger.Warn("failed to queue welcome message for returning subscriber", "pubkey", ev.PubKey, "err", err)
+			}
+			return
+		}
+
+		logger.Info("skip welcome message for existing subscriber", "pubkey", ev.PubKey)
+		err = ba.Worker.Push(ctx, ev.PubKey, channelSK, PushInterval, PushSize)
+		if err != nil {
+			logger.Error("failed to prepare initial content", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandUnsubscribe:
+		logger.Warn("unsubscribing", "pubkey", ev.PubKey)
+		ba.Bot.TerminateSubscription(ctx, ev.PubKey)
+	case CommandOptOut, CommandOptIn:
+		optOut := cmd == CommandOptOut
+		logger.Info("updating featured notification preference", "pubkey", ev.PubKey, "optOut", optOut)
+		if err := ba.Bot.service.SetNotificationOptOut(ctx, ev.PubKey, optOut); err != nil {
+			logger.Warn("failed to update notification preference", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandInterested, CommandUninterested:
+		topics := parseTopics(ev)
+		if len(topics) == 0 {
+			return
+		}
+		interested := cmd == CommandInterested
+		logger.Info("updating interests", "pubkey", ev.PubKey, "topics", topics, "interested", interested)
+		if err := ba.Bot.service.SetInterests(ctx, ev.PubKey, topics, interested); err != nil {
+			logger.Warn("failed to update interests", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandLess:
+		less := parseLess(ev)
+		if less.PostId == "" && less.Author == "" && len(less.Topics) == 0 {
+			return
+		}
+		logger.Info("recording negative feedback", "pubkey", ev.PubKey, "feedback", less)
+		if err := ba.Bot.service.RecordLess(ctx, ev.PubKey, less); err != nil {
+			logger.Warn("failed to record negative feedback", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandBrand:
+		logger.Info("updating channel branding", "pubkey", ev.PubKey)
+		if err := ba.Bot.HandleBranding(ctx, ev); err != nil {
+			logger.Warn("failed to update channel branding", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandClaim:
+		logger.Info("claiming channel", "pubkey", ev.PubKey)
+		if err := ba.Bot.HandleClaim(ctx, ev); err != nil {
+			logger.Warn("failed to send channel key", "pubkey", ev.PubKey, "err", err)
+		}
+	case CommandConfirmClaim:
+		logger.Info("confirming channel claim", "pubkey", ev.PubKey)
+		if err := ba.Bot.HandleConfirmClaim(ctx, ev); err != nil {
+			logger.Warn("failed to hand over channel", "pubkey", ev.PubKey, "err", err)
+		}
+	}
 }
 
 func NewBot(ctx context.Context, client n.IClient, service service.IService, config *types.Config) (*Bot, error) {
 	sk := config.Bot.SK
-	pub, err := nostr.GetPublicKey(sk)
+	pub, err := n.PublicKey(sk)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Bot{
-		client:  client,
-		config:  config,
-		SK:      sk,
-		pub:     pub,
-		service: service,
+		client:   client,
+		config:   config,
+		SK:       sk,
+		pub:      pub,
+		service:  service,
+		welcomes: newWelcomeQueue(),
 	}, nil
 }
 
-func (b *Bot) Listen(ctx context.Context) (<-chan nostr.Event, error) {
+// Listen subscribes to events sent to the bot since the given time
+func (b *Bot) Listen(ctx context.Context, since time.Time) (<-chan nostr.Event, error) {
 	// set user metadata
-	logger.Info("Create account metadata", "pubkey", b.pub)
-	metadata := b.config.Bot.Metadata
-	relays := b.recommendedRelayList(*b.config)
-	err := b.client.Metadata(ctx, b.SK, metadata.Name, metadata.About, metadata.Picture, metadata.Nip05, relays)
+	err := b.PublishProfile(ctx)
 	if err != nil {
 		logger.Error("failed to set account metadata", "err", err)
 	}
 
-	// listen to subscription message
+	// listen to subscription message, including reposts and quotes of bot
+	// notes, zaps paying for premium, replies to surveys, and preferences
+	// published by subscribers
 	logger.Info("Listen to subscription message", "pubkey", b.pub)
-	now := time.Now()
 	filters := nostr.Filters{
 		nostr.Filter{
-			Kinds: []int{1},
-			Since: &now,
+			Kinds: []int{1, 4, 6, 9735},
+			Since: &since,
 			Tags: nostr.TagMap{
 				"p": []string{b.pub},
 			},
 		},
+		nostr.Filter{
+			Kinds: []int{types.PreferencesKind},
+			Since: &since,
+			Tags: nostr.TagMap{
+				"d": []string{types.PreferencesIdentifier},
+			},
+		},
 	}
 	return b.client.Subscribe(ctx, filters), nil
 }
 
 func (b *Bot) GetOrCreateSubscription(ctx context.Context, subscriberPub string) (string, bool, error) {
-	subscriber := b.service.GetSubscriber(subscriberPub)
+	subscriber := b.service.GetSubscriber(ctx, subscriberPub)
 	if subscriber != nil {
 		logger.Info("found existing subscriber", "pubkey", subscriberPub)
 		return subscriber.ChannelSecret, false, nil
@@ -197,36 +584,109 @@ func (b *Bot) GetOrCreateSubscription(ctx context.Context, subscriberPub string)
 }
 
 func (b *Bot) createSubscription(ctx context.Context, subscriberPub string) (string, error) {
-	metadata := b.config.Bot.Metadata
 	channelSK := nostr.GeneratePrivateKey()
 
 	// save secret key to db
-	err := b.service.CreateSubscriber(subscriberPub, channelSK, time.Now())
+	err := b.service.CreateSubscriber(ctx, subscriberPub, channelSK, time.Now())
 	if err != nil {
 		return "", err
 	}
 
 	// send set_metadata event
-	npub, _ := nip19.EncodePublicKey(subscriberPub)
+	if err := b.publishChannelMetadata(ctx, channelSK, types.Subscriber{Pubkey: subscriberPub}); err != nil {
+		return "", err
+	}
+
+	return channelSK, nil
+}
+
+// publishChannelMetadata publishes the channel's profile, with the name and
+// picture chosen by the subscriber if any
+func (b *Bot) publishChannelMetadata(ctx context.Context, channelSK string, subscriber types.Subscriber) error {
+	metadata := b.config.Bot.Metadata
+	npub, _ := nip19.EncodePublicKey(subscriber.Pubkey)
 	mainNpub, _ := nip19.EncodePublicKey(b.pub)
 	relays := b.recommendedRelayList(*b.config)
-	err = b.client.Metadata(ctx, channelSK,
-		metadata.ChannelName,
+
+	name, picture := metadata.ChannelName, metadata.ChannelPicture
+	if subscriber.ChannelName != "" {
+		name = subscriber.ChannelName
+	}
+	if subscriber.ChannelPicture != "" {
+		picture = subscriber.ChannelPicture
+	}
+	return b.client.Metadata(ctx, channelSK,
+		name,
 		fmt.Sprintf(metadata.ChannelAbout, npub, mainNpub),
-		metadata.ChannelPicture, "", relays)
+		picture, "", relays)
+}
+
+// RotateChannel moves the subscriber's channel to a new key and revokes the
+// delegation of the old one
+func (b *Bot) RotateChannel(ctx context.Context, subscriberPub string) (string, error) {
+	subscriber := b.service.GetSubscriber(ctx, subscriberPub)
+	if subscriber == nil {
+		return "", fmt.Errorf("subscriber %s not found", subscriberPub)
+	}
+	if subscriber.HandedOverAt != nil {
+		return "", fmt.Errorf("channel of subscriber %s was handed over", subscriberPub)
+	}
+	oldPub, err := nostr.GetPublicKey(subscriber.ChannelSecret)
 	if err != nil {
 		return "", err
 	}
 
-	return channelSK, nil
+	channelSK := nostr.GeneratePrivateKey()
+	if err := b.service.SetChannelSecret(ctx, subscriberPub, channelSK); err != nil {
+		return "", err
+	}
+	b.client.RevokeDelegation(oldPub)
+
+	if err := b.publishChannelMetadata(ctx, channelSK, *subscriber); err != nil {
+		return "", err
+	}
+
+	channelPub, _ := nostr.GetPublicKey(channelSK)
+	logger.Info("rotated channel key", "pubkey", subscriberPub, "old", oldPub, "new", channelPub)
+
+	// tell the subscriber which channel to follow from now on
+	if err := b.SendWelcomeMessage(ctx, channelSK, subscriberPub); err != nil {
+		return "", fmt.Errorf("rotated channel to %s but failed to notify subscriber: %w", channelPub, err)
+	}
+	return channelPub, nil
 }
 
 func (b *Bot) TerminateSubscription(ctx context.Context, subscriberPub string) error {
-	return b.service.DeleteSubscriber(subscriberPub, time.Now())
+	return b.service.DeleteSubscriber(ctx, subscriberPub, time.Now())
 }
 
 func (b *Bot) RestoreSubscription(ctx context.Context, subscriberPub string) (bool, error) {
-	return b.service.RestoreSubscriber(subscriberPub, time.Now())
+	return b.service.RestoreSubscriber(ctx, subscriberPub, time.Now())
+}
+
+// onboard welcomes a queued subscriber and prepares initial content for the
+// channel
+func (ba *BotApplication) onboard(ctx context.Context, pubkey string) error {
+	subscriber := ba.Bot.service.GetSubscriber(ctx, pubkey)
+	if subscriber == nil || subscriber.UnsubscribedAt != nil {
+		logger.Info("skip welcome message for unsubscribed", "pubkey", pubkey)
+		return nil
+	}
+
+	// returning subscribers whose channel was handed over get their digests
+	// privately, without a channel to welcome them to
+	if subscriber.HandedOverAt == nil {
+		if err := ba.Bot.SendWelcomeMessage(ctx, subscriber.ChannelSecret, pubkey); err != nil {
+			return err
+		}
+		logger.Info("sent welcome message to new subscriber", "pubkey", pubkey)
+	}
+
+	// failing to push is not retried, so the welcome isn't sent twice
+	if err := ba.Worker.Push(ctx, pubkey, subscriber.ChannelSecret, PushInterval, PushSize); err != nil {
+		logger.Error("failed to prepare initial content", "pubkey", pubkey, "err", err)
+	}
+	return nil
 }
 
 func (b *Bot) SendWelcomeMessage(ctx context.Context, channelSK, receiverPub string) error {
@@ -3,12 +3,16 @@ package bot
 import (
 	"context"
 	"testing"
+	"time"
 
 	n "github.com/dyng/nosdaily/nostr"
 	"github.com/dyng/nosdaily/service"
 	"github.com/dyng/nosdaily/types"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/robfig/cron/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 var botSK = nostr.GeneratePrivateKey()
@@ -48,7 +52,7 @@ func TestListen(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	c, err := bot.Listen(ctx)
+	c, err := bot.Listen(ctx, time.Now())
 	assert.NoError(t, err)
 
 	// botPub, err := nostr.GetPublicKey(botSK)
@@ -118,3 +122,166 @@ func TestSendWelcomeMessage(t *testing.T) {
 	// assert.NotNil(t, ev)
 	// TODO: should check welcome message mentions the right person
 }
+
+// bot should recognize subscribe requests from mentions, reposts and quotes
+func TestParseCommand(t *testing.T) {
+	mockClient := new(n.MockClient)
+	mockService := new(service.MockService)
+
+	bot, err := NewBot(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	botPub, err := nostr.GetPublicKey(botSK)
+	assert.NoError(t, err)
+
+	note := nostr.Event{
+		Content:   "Post '@nossence #subscribe' to get your own feed!",
+		CreatedAt: time.Now(),
+		Kind:      1,
+		PubKey:    botPub,
+	}
+	note.Sign(botSK)
+	raw, err := note.MarshalJSON()
+	assert.NoError(t, err)
+
+	mockClient.On("Query", mock.Anything, mock.Anything).Return([]nostr.Event{note})
+
+	mention := nostr.Event{Kind: 1, Content: "#[0] #subscribe"}
+	assert.Equal(t, CommandSubscribe, bot.ParseCommand(context.Background(), mention))
+
+	leave := nostr.Event{Kind: 1, Content: "#[0] #unsubscribe"}
+	assert.Equal(t, CommandUnsubscribe, bot.ParseCommand(context.Background(), leave))
+
+	repost := nostr.Event{
+		Kind:    6,
+		Content: string(raw),
+		Tags:    nostr.Tags{nostr.Tag{"e", note.ID}, nostr.Tag{"p", botPub}},
+	}
+	assert.Equal(t, CommandSubscribe, bot.ParseCommand(context.Background(), repost))
+
+	quote := nostr.Event{
+		Kind:    1,
+		Content: "nice bot nostr:note1...",
+		Tags:    nostr.Tags{nostr.Tag{"q", note.ID}, nostr.Tag{"p", botPub}},
+	}
+	assert.Equal(t, CommandSubscribe, bot.ParseCommand(context.Background(), quote))
+
+	chat := nostr.Event{Kind: 1, Content: "gm"}
+	assert.Equal(t, CommandNone, bot.ParseCommand(context.Background(), chat))
+}
+
+func TestParseHashtagCommand(t *testing.T) {
+	assert.Equal(t, CommandLess, parseHashtagCommand("@nossence #less, please"))
+	assert.Equal(t, CommandInterested, parseHashtagCommand("#Interested #art"))
+	assert.Equal(t, CommandConfirmClaim, parseHashtagCommand("#confirmclaim"))
+
+	// hashtags merely starting with a command aren't commands
+	assert.Equal(t, CommandNone, parseHashtagCommand("notes from my #lessons on #interestedparties"))
+	assert.Equal(t, CommandNone, parseHashtagCommand("#branding #claims #subscribers"))
+}
+
+func TestParseTopics(t *testing.T) {
+	ev := nostr.Event{Content: "@nossence #interested #Bitcoin #art!"}
+	assert.Equal(t, []string{"bitcoin", "art"}, parseTopics(ev))
+
+	ev = nostr.Event{
+		Content: "@nossence #uninterested #nsfw",
+		Tags:    nostr.Tags{{"t", "uninterested"}, {"t", "nsfw"}},
+	}
+	assert.Equal(t, []string{"nsfw"}, parseTopics(ev))
+	assert.Equal(t, CommandUninterested, parseHashtagCommand(ev.Content))
+
+	// deprecated index mentions are not topics
+	ev = nostr.Event{
+		Content: "#[0] #interested #art",
+		Tags:    nostr.Tags{{"p", "32e1827635450ebb3c5a7d12c1f8e7b2b514439ac10a67eef3d9fd9c5c68e245"}},
+	}
+	assert.Equal(t, []string{"art"}, parseTopics(ev))
+}
+
+func TestParseLess(t *testing.T) {
+	id := "d0b3c0a7f1c6ad8f7b5e0f9b8c3e9d8a7f6e5d4c3b2a19080706050403020100"
+	note, _ := nip19.EncodeNote(id)
+	ev := nostr.Event{Content: "@nossence #less nostr:" + note}
+	assert.Equal(t, CommandLess, parseHashtagCommand(ev.Content))
+	assert.Equal(t, types.LessFeedback{PostId: id, Topics: []string{}}, parseLess(ev))
+
+	npub, _ := nip19.EncodePublicKey(id)
+	ev = nostr.Event{Content: "@nossence #less " + npub + " #memes"}
+	assert.Equal(t, types.LessFeedback{Author: id, Topics: []string{"memes"}}, parseLess(ev))
+}
+
+func TestParsePreferences(t *testing.T) {
+	subscriberPub, err := nostr.GetPublicKey(subscriberSK)
+	assert.NoError(t, err)
+
+	ev := nostr.Event{
+		Kind:      types.PreferencesKind,
+		PubKey:    subscriberPub,
+		CreatedAt: time.Now(),
+		Tags:      nostr.Tags{{"d", types.PreferencesIdentifier}},
+		Content:   `{"interests":["art"],"notification_opt_out":true}`,
+	}
+	ev.Sign(subscriberSK)
+
+	prefs, err := parsePreferences(ev)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"art"}, prefs.Interests)
+	assert.True(t, *prefs.NotificationOptOut)
+
+	// preferences of other apps
+	other := ev
+	other.Tags = nostr.Tags{{"d", "other"}}
+	other.Sign(subscriberSK)
+	_, err = parsePreferences(other)
+	assert.Error(t, err)
+
+	// tampered content
+	forged := ev
+	forged.Content = `{"interests":["spam"]}`
+	_, err = parsePreferences(forged)
+	assert.Error(t, err)
+}
+
+func TestParseRating(t *testing.T) {
+	assert.Equal(t, 4, parseRating("4", 5))
+	assert.Equal(t, 5, parseRating(" 5/5, love it", 5))
+	assert.Equal(t, 1, parseRating("I'd say 1", 5))
+	assert.Equal(t, 0, parseRating("10", 5))
+	assert.Equal(t, 0, parseRating("0", 5))
+	assert.Equal(t, 0, parseRating("great", 5))
+}
+
+func TestParseBranding(t *testing.T) {
+	ev := nostr.Event{Content: "#[0] #brand Bitcoin reads https://nostr.build/i/pic.png"}
+	assert.Equal(t, CommandBrand, parseHashtagCommand(ev.Content))
+	name, picture, reset := parseBranding(ev)
+	assert.Equal(t, "Bitcoin reads", name)
+	assert.Equal(t, "https://nostr.build/i/pic.png", picture)
+	assert.False(t, reset)
+
+	_, _, reset = parseBranding(nostr.Event{Content: "@nossence #brand reset"})
+	assert.True(t, reset)
+}
+
+func TestValidateBranding(t *testing.T) {
+	conf := types.BrandingConfig{
+		MaxNameLength: 10,
+		BlockedWords:  []string{"official"},
+		PictureHosts:  []string{"nostr.build"},
+	}
+	assert.NoError(t, validateBranding(conf, "My feed", "https://nostr.build/i/pic.png"))
+	assert.NoError(t, validateBranding(conf, "", ""))
+	assert.Error(t, validateBranding(conf, "a much too long name", ""))
+	assert.Error(t, validateBranding(conf, "Official", ""))
+	assert.Error(t, validateBranding(conf, "", "http://nostr.build/i/pic.png"))
+	assert.Error(t, validateBranding(conf, "", "https://example.com/pic.png"))
+}
+
+func TestStalled(t *testing.T) {
+	now := time.Now()
+	assert.False(t, stalled(nil, now))
+	assert.False(t, stalled([]cron.Entry{{}, {Next: now.Add(time.Hour)}}, now))
+	assert.False(t, stalled([]cron.Entry{{Next: now.Add(-time.Minute)}}, now))
+	assert.True(t, stalled([]cron.Entry{{Next: now.Add(time.Hour)}, {Next: now.Add(-5 * time.Minute)}}, now))
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+// HandleBranding applies the channel name and picture a subscriber asked for
+// with '#brand', e.g. '@nossence #brand Bitcoin reads https://host/pic.png'.
+// '#brand reset' restores the defaults. Rejected requests are explained to
+// the subscriber by direct message.
+func (b *Bot) HandleBranding(ctx context.Context, ev nostr.Event) error {
+	conf := b.config.Bot.Branding
+	if !conf.Enabled {
+		return nil
+	}
+
+	subscriber := b.service.GetSubscriber(ctx, ev.PubKey)
+	if subscriber == nil || subscriber.UnsubscribedAt != nil {
+		logger.Info("branding from non subscriber", "pubkey", ev.PubKey)
+		return nil
+	}
+
+	if subscriber.HandedOverAt != nil {
+		return b.rejectBranding(ctx, ev.PubKey, fmt.Errorf("your channel was handed over to you"))
+	}
+
+	name, picture, reset := parseBranding(ev)
+	if reset {
+		name, picture = "", ""
+	} else {
+		if name == "" && picture == "" {
+			return nil
+		}
+		if conf.PremiumOnly && subscriber.EffectiveTier(time.Now()) != types.TierPremium {
+			return b.rejectBranding(ctx, ev.PubKey, fmt.Errorf("channel branding is available to premium subscribers"))
+		}
+		if err := validateBranding(conf, name, picture); err != nil {
+			return b.rejectBranding(ctx, ev.PubKey, err)
+		}
+
+		// keep what the subscriber didn't change
+		if name == "" {
+			name = subscriber.ChannelName
+		}
+		if picture == "" {
+			picture = subscriber.ChannelPicture
+		}
+	}
+
+	if err := b.service.SetChannelBranding(ctx, ev.PubKey, name, picture); err != nil {
+		return err
+	}
+	subscriber.ChannelName, subscriber.ChannelPicture = name, picture
+	return b.publishChannelMetadata(ctx, subscriber.ChannelSecret, *subscriber)
+}
+
+func (b *Bot) rejectBranding(ctx context.Context, pubkey string, reason error) error {
+	logger.Info("rejected channel branding", "pubkey", pubkey, "reason", reason)
+	return b.client.SendMessage(ctx, b.SK, pubkey, fmt.Sprintf("Your channel branding was not applied: %s.", reason))
+}
+
+// parseBranding reads the picture URL and the name from the words of a
+// '#brand' command, leaving out mentions and hashtags
+func parseBranding(ev nostr.Event) (name string, picture string, reset bool) {
+	words := []string{}
+	for _, word := range strings.Fields(ev.Content) {
+		switch {
+		case strings.HasPrefix(word, "https://") || strings.HasPrefix(word, "http://"):
+			picture = word
+		case strings.HasPrefix(word, "#") || strings.HasPrefix(word, "@") || strings.HasPrefix(word, "nostr:"):
+			continue
+		default:
+			words = append(words, word)
+		}
+	}
+
+	name = strings.Join(words, " ")
+	if strings.EqualFold(name, "reset") && picture == "" {
+		return "", "", true
+	}
+	return name, picture, false
+}
+
+// validateBranding checks a requested name and picture against the
+// operator's policy, empty values are not checked
+func validateBranding(conf types.BrandingConfig, name, picture string) error {
+	if name != "" {
+		if conf.MaxNameLength > 0 && utf8.RuneCountInString(name) > conf.MaxNameLength {
+			return fmt.Errorf("name is longer than %d characters", conf.MaxNameLength)
+		}
+		if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+			return fmt.Errorf("name contains control characters")
+		}
+		lower := strings.ToLower(name)
+		for _, word := range conf.BlockedWords {
+			if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+				return fmt.Errorf("name contains a blocked word")
+			}
+		}
+	}
+
+	if picture != "" {
+		u, err := url.Parse(picture)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("picture must be an https URL")
+		}
+		if len(conf.PictureHosts) > 0 && !slices.Contains(conf.PictureHosts, strings.ToLower(u.Hostname())) {
+			return fmt.Errorf("pictures must be hosted on %s", strings.Join(conf.PictureHosts, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	n "github.com/dyng/nosdaily/nostr"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	CatchUpSkip     = "skip"
+	CatchUpCombined = "combined"
+	CatchUpAll      = "all"
+
+	// at most this many missed runs are sent with the "all" policy
+	maxCatchUpRuns = 24
+)
+
+// CatchUp handles the digest runs missed while nossence was down, according
+// to the configured policy. The last run is taken from the digests recorded
+// for the main channel.
+func (w *Worker) CatchUp(ctx context.Context, now time.Time) error {
+	mainPub, err := n.PublicKey(w.config.Bot.SK)
+	if err != nil {
+		return err
+	}
+
+	last, err := w.service.LastDigestAt(ctx, mainPub)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+
+	missed := missedRuns(w.schedule(), *last, now)
+	if len(missed) == 0 {
+		return nil
+	}
+
+	policy := w.config.Digest.CatchUp
+	logger.Info("catching up missed runs", "policy", policy, "missed", len(missed), "last", last)
+
+	switch policy {
+	case CatchUpCombined:
+		window := now.Sub(*last)
+		if maxWindow, err := time.ParseDuration(w.config.Digest.MaxWindow); err == nil && window > maxWindow {
+			window = maxWindow
+		}
+		return w.RunAt(ctx, now, window)
+	case CatchUpAll:
+		if len(missed) > maxCatchUpRuns {
+			missed = missed[len(missed)-maxCatchUpRuns:]
+		}
+		for _, end := range missed {
+			if err := w.RunAt(ctx, end, w.window(end)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		logger.Info("skipping missed runs", "missed", len(missed))
+		return nil
+	}
+}
+
+// RunScheduled runs the worker unless the latest activation of the schedule
+// has been covered already, e.g. by a catch-up right before the cron fires
+func (w *Worker) RunScheduled(ctx context.Context) error {
+	now := time.Now()
+	if last := w.lastRunEnd(); last != nil && !last.Before(lastActivation(w.schedule(), now)) {
+		logger.Info("skipping run already covered", "last", last)
+		return nil
+	}
+	return w.Run(ctx)
+}
+
+// missedRuns returns the activations of the schedule after last, up to now
+func missedRuns(sched cron.Schedule, last, now time.Time) []time.Time {
+	runs := []time.Time{}
+	for end := sched.Next(last); !end.IsZero() && !end.After(now); end = sched.Next(end) {
+		runs = append(runs, end)
+	}
+	return runs
+}
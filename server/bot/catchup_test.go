@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMissedRuns(t *testing.T) {
+	last := time.Date(2023, 3, 22, 10, 0, 5, 0, time.UTC)
+	now := time.Date(2023, 3, 22, 13, 20, 0, 0, time.UTC)
+
+	runs := missedRuns(parseSchedule("0 * * * *"), last, now)
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 3, 22, 11, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 22, 12, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC),
+	}, runs)
+
+	assert.Empty(t, missedRuns(parseSchedule("0 * * * *"), now, now.Add(time.Minute)))
+
+	// daily runs end at the scheduled hour
+	last = time.Date(2023, 3, 20, 8, 0, 5, 0, time.UTC)
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 3, 21, 8, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 22, 8, 0, 0, 0, time.UTC),
+	}, missedRuns(parseSchedule("0 8 * * *"), last, now))
+
+	// weekly runs on Sundays
+	last = time.Date(2023, 3, 5, 0, 0, 5, 0, time.UTC)
+	assert.Equal(t, []time.Time{
+		time.Date(2023, 3, 12, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 3, 19, 0, 0, 0, 0, time.UTC),
+	}, missedRuns(parseSchedule("@weekly"), last, now))
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2023, 3, 22, 13, 20, 0, 0, time.UTC)
+	last := now.Add(-3 * time.Hour)
+
+	for policy, runs := range map[string]int{CatchUpSkip: 0, CatchUpCombined: 1, CatchUpAll: 3} {
+		mockService := new(service.MockService)
+		mockService.On("LastDigestAt", mock.Anything, mock.Anything).Return(&last, nil)
+		mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{})
+		mockService.On("ListSubscribersAfter", mock.Anything, mock.Anything, mock.Anything).Return([]types.Subscriber{}, nil)
+		mockService.On("GetCheckpoint", mock.Anything, mock.Anything).Return((*types.Checkpoint)(nil), nil)
+		mockService.On("SaveCheckpoint", mock.Anything, mock.Anything).Return(nil)
+		mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+
+		conf := *config
+		conf.Digest.CatchUp = policy
+		worker, err := NewWorker(context.Background(), new(nostr.MockClient), mockService, &conf)
+		assert.NoError(t, err)
+
+		assert.NoError(t, worker.CatchUp(context.Background(), now))
+		mockService.AssertNumberOfCalls(t, "ListSubscribersAfter", runs)
+		// runs with an empty main feed are recorded too, not caught up again
+		mockService.AssertNumberOfCalls(t, "RecordDigest", runs)
+	}
+}
+
+func TestResume(t *testing.T) {
+	now := time.Date(2023, 3, 22, 13, 20, 0, 0, time.UTC)
+	end := time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC)
+
+	mockService := new(service.MockService)
+	mockService.On("GetCheckpoint", mock.Anything, "worker").Return(&types.Checkpoint{Name: "worker", End: end, Window: PushInterval, Offset: 20, After: "bob"}, nil)
+	mockService.On("ListSubscribersAfter", mock.Anything, "bob", 10).Return([]types.Subscriber{}, nil)
+	mockService.On("SaveCheckpoint", mock.Anything, types.Checkpoint{Name: "worker", End: end, Window: PushInterval, Offset: 20, After: "bob", Done: true}).Return(nil)
+
+	worker, err := NewWorker(context.Background(), new(nostr.MockClient), mockService, config)
+	assert.NoError(t, err)
+
+	// the interrupted run continues from its offset, skipping the main update
+	assert.NoError(t, worker.Resume(context.Background(), now))
+	mockService.AssertExpectations(t)
+	mockService.AssertNotCalled(t, "QueryFeed", mock.Anything, mock.Anything)
+	assert.Equal(t, end, *worker.lastRunEnd())
+
+	// runs older than the push interval are left to CatchUp
+	mockService = new(service.MockService)
+	mockService.On("GetCheckpoint", mock.Anything, "worker").Return(&types.Checkpoint{Name: "worker", End: end.Add(-2 * time.Hour), Offset: 20, After: "bob"}, nil)
+	worker, err = NewWorker(context.Background(), new(nostr.MockClient), mockService, config)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Resume(context.Background(), now))
+	mockService.AssertNotCalled(t, "ListSubscribersAfter", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestStopRun(t *testing.T) {
+	end := time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC)
+	unsubscribedAt := end.Add(-time.Hour)
+	page := make([]types.Subscriber, 10)
+	for i := range page {
+		page[i] = types.Subscriber{Pubkey: fmt.Sprintf("subscriber_%d", i), UnsubscribedAt: &unsubscribedAt}
+	}
+
+	mockService := new(service.MockService)
+	mockService.On("GetCheckpoint", mock.Anything, "worker").Return(&types.Checkpoint{Name: "worker", End: end, Window: PushInterval, Offset: 20, After: "bob"}, nil)
+	mockService.On("ListSubscribersAfter", mock.Anything, "bob", 10).Return(page, nil)
+	// the next run resumes after the last subscriber of the batch
+	mockService.On("SaveCheckpoint", mock.Anything, types.Checkpoint{Name: "worker", End: end, Window: PushInterval, Offset: 30, After: "subscriber_9"}).Return(nil)
+
+	worker, err := NewWorker(context.Background(), new(nostr.MockClient), mockService, config)
+	assert.NoError(t, err)
+
+	// a stopped run finishes its current batch and is left to be resumed
+	worker.Stop()
+	assert.ErrorIs(t, worker.RunAt(context.Background(), end, PushInterval), errRunStopped)
+	mockService.AssertExpectations(t)
+	mockService.AssertNumberOfCalls(t, "ListSubscribersAfter", 1)
+	assert.Nil(t, worker.lastRunEnd())
+}
@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+// Reengage updates the churn risk of subscribers and messages those likely
+// to leave, at most once per ReengageInterval
+func (w *Worker) Reengage(ctx context.Context, now time.Time) error {
+	conf := w.config.Churn
+	interval, err := time.ParseDuration(conf.ReengageInterval)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.service.UpdateChurnRisk(ctx, now); err != nil {
+		return err
+	}
+
+	limit := 10
+	for skip := 0; ; skip += limit {
+		subscribers, err := w.service.ListSubscribers(ctx, limit, skip)
+		if err != nil {
+			return err
+		}
+
+		for _, subscriber := range subscribers {
+			if !w.config.Sharding.Serves(subscriber.ShardKey) || !dueForReengagement(subscriber, conf.ReengageRisk, interval, now) {
+				continue
+			}
+
+			if err := w.reengage(ctx, subscriber, now); err != nil {
+				logger.Warn("failed to reengage subscriber", "pubkey", subscriber.Pubkey, "err", err)
+			}
+		}
+
+		if len(subscribers) < limit {
+			break
+		}
+	}
+	return nil
+}
+
+func (w *Worker) reengage(ctx context.Context, subscriber types.Subscriber, now time.Time) error {
+	msg := "We haven't seen you around lately, is your nossence digest still worth reading? Reply with #interested and a few hashtags to get more of what you like, #less to get less of something, or #unsubscribe to stop it."
+	if err := w.client.SendMessage(ctx, w.config.Bot.SK, subscriber.Pubkey, msg); err != nil {
+		return err
+	}
+
+	logger.Info("sent re-engagement message", "pubkey", subscriber.Pubkey, "risk", subscriber.ChurnRisk)
+	return w.service.MarkReengaged(ctx, subscriber.Pubkey, now)
+}
+
+func dueForReengagement(subscriber types.Subscriber, risk float64, interval time.Duration, now time.Time) bool {
+	if subscriber.UnsubscribedAt != nil || subscriber.ChurnRisk < risk {
+		return false
+	}
+	return subscriber.LastReengagedAt == nil || now.Sub(*subscriber.LastReengagedAt) >= interval
+}
+
+// slowedTier spaces the digests of subscribers likely to leave, so that
+// those who stopped reading aren't flooded
+func slowedTier(conf types.ChurnConfig, subscriber types.Subscriber, tier types.TierConfig) types.TierConfig {
+	if subscriber.ChurnRisk < conf.SlowdownRisk || conf.SlowdownFactor <= 1 {
+		return tier
+	}
+
+	interval, err := time.ParseDuration(tier.MinInterval)
+	if err != nil {
+		return tier
+	}
+	tier.MinInterval = time.Duration(float64(interval) * conf.SlowdownFactor).String()
+	return tier
+}
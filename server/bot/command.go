@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	n "github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+type Command string
+
+const (
+	CommandNone         Command = ""
+	CommandSubscribe    Command = "subscribe"
+	CommandUnsubscribe  Command = "unsubscribe"
+	CommandOptOut       Command = "optout"
+	CommandOptIn        Command = "optin"
+	CommandInterested   Command = "interested"
+	CommandUninterested Command = "uninterested"
+	CommandLess         Command = "less"
+	CommandBrand        Command = "brand"
+	CommandClaim        Command = "claim"
+	CommandConfirmClaim Command = "confirmclaim"
+)
+
+// ParseCommand extracts the bot command carried by a mentioning event.
+//
+// Besides plain mentions, users sometimes boost (kind 6) or quote (q tag)
+// one of the bot's notes advertising '#subscribe', which is treated as a
+// subscribe request as well.
+func (b *Bot) ParseCommand(ctx context.Context, ev nostr.Event) Command {
+	switch ev.Kind {
+	case 1:
+		if cmd := parseHashtagCommand(ev.Content); cmd != CommandNone {
+			return cmd
+		}
+
+		// quoted bot note
+		quote := ev.Tags.GetFirst([]string{"q"})
+		if quote != nil && b.isSubscribeNote(ctx, quote.Value(), nil) {
+			return CommandSubscribe
+		}
+	case 6:
+		// reposted bot note, the origin event is usually embedded in content
+		ref := ev.Tags.GetFirst([]string{"e"})
+		if ref == nil {
+			return CommandNone
+		}
+
+		var embedded *nostr.Event
+		if ev.Content != "" {
+			embedded = new(nostr.Event)
+			if err := embedded.UnmarshalJSON([]byte(ev.Content)); err != nil {
+				logger.Debug("failed to parse embedded repost", "id", ev.ID, "err", err)
+				embedded = nil
+			}
+		}
+
+		if b.isSubscribeNote(ctx, ref.Value(), embedded) {
+			return CommandSubscribe
+		}
+	}
+
+	return CommandNone
+}
+
+// isSubscribeNote checks whether the referenced event is a bot note
+// containing '#subscribe'. If the event is not given, it's fetched from relays.
+func (b *Bot) isSubscribeNote(ctx context.Context, id string, ev *nostr.Event) bool {
+	if ev == nil || ev.ID != id {
+		events := b.client.Query(ctx, nostr.Filter{
+			IDs:     []string{id},
+			Authors: []string{b.pub},
+			Limit:   1,
+		})
+		if len(events) == 0 {
+			logger.Debug("referenced note not found", "id", id)
+			return false
+		}
+		ev = &events[0]
+	}
+
+	return ev.PubKey == b.pub && parseHashtagCommand(ev.Content) == CommandSubscribe
+}
+
+// hashtagPattern matches a whole hashtag, so that e.g. '#lessons' isn't
+// taken for '#less'
+var hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+// commandOrder is the order commands are looked for in, when a note carries
+// several of them
+var commandOrder = []Command{
+	CommandSubscribe,
+	CommandUnsubscribe,
+	CommandOptOut,
+	CommandOptIn,
+	CommandInterested,
+	CommandUninterested,
+	CommandLess,
+	CommandBrand,
+	CommandClaim,
+	CommandConfirmClaim,
+}
+
+func parseHashtagCommand(content string) Command {
+	hashtags := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(content, -1) {
+		hashtags[strings.ToLower(match[1])] = true
+	}
+	for _, cmd := range commandOrder {
+		if hashtags[string(cmd)] {
+			return cmd
+		}
+	}
+	return CommandNone
+}
+
+// parseTopics returns the hashtags of a command event other than the command
+// itself, e.g. 'bitcoin' and 'art' for '#interested #bitcoin #art'
+func parseTopics(ev nostr.Event) []string {
+	hashtags := []string{}
+	for _, tag := range ev.Tags.GetAll([]string{"t"}) {
+		hashtags = append(hashtags, tag.Value())
+	}
+	if len(hashtags) == 0 {
+		for _, word := range strings.Fields(n.MigrateMentions(ev.Content, ev.Tags)) {
+			if strings.HasPrefix(word, "#") && len(word) > 1 {
+				hashtags = append(hashtags, strings.TrimRight(word[1:], ".,!?"))
+			}
+		}
+	}
+
+	topics := []string{}
+	for _, hashtag := range hashtags {
+		hashtag = strings.ToLower(hashtag)
+		if parseHashtagCommand("#"+hashtag) != CommandNone || hashtag == "" {
+			continue
+		}
+		topics = append(topics, hashtag)
+	}
+	return topics
+}
+
+// parseLess extracts what a '#less' command refers to: a note or nevent, an
+// npub or nprofile, and/or topics
+func parseLess(ev nostr.Event) types.LessFeedback {
+	less := types.LessFeedback{Topics: parseTopics(ev)}
+	for _, word := range strings.Fields(n.MigrateMentions(ev.Content, ev.Tags)) {
+		word = strings.TrimPrefix(strings.TrimRight(word, ".,!?"), "nostr:")
+		prefix, value, err := nip19.Decode(word)
+		if err != nil {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			if prefix == "note" {
+				less.PostId = v
+			} else if prefix == "npub" {
+				less.Author = v
+			}
+		case nostr.EventPointer:
+			less.PostId = v.ID
+		case nostr.ProfilePointer:
+			less.Author = v.PublicKey
+		}
+	}
+	return less
+}
@@ -0,0 +1,95 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// HandleClaim sends subscribers asking with '#claim' the secret key of their
+// channel, so that they can take the channel into self-custody. The key is
+// kept until the subscriber confirms with '#confirmclaim' they saved it.
+func (b *Bot) HandleClaim(ctx context.Context, ev nostr.Event) error {
+	if !b.config.Bot.Custody.Enabled {
+		return nil
+	}
+
+	subscriber := b.service.GetSubscriber(ctx, ev.PubKey)
+	if subscriber == nil || subscriber.UnsubscribedAt != nil {
+		logger.Info("claim from non subscriber", "pubkey", ev.PubKey)
+		return nil
+	}
+	if subscriber.HandedOverAt != nil {
+		return b.client.SendMessage(ctx, b.SK, ev.PubKey, "Your channel was already handed over to you.")
+	}
+
+	nsec, err := nip19.EncodePrivateKey(subscriber.ChannelSecret)
+	if err != nil {
+		return err
+	}
+	if err := b.service.RequestChannelClaim(ctx, ev.PubKey, time.Now()); err != nil {
+		return err
+	}
+
+	logger.Info("sent channel key to subscriber", "pubkey", ev.PubKey)
+	return b.client.GiftWrap(ctx, b.SK, ev.PubKey, fmt.Sprintf(
+		"This is the secret key of your channel, keep it safe:\n\n%s\n\n"+
+			"Reply '#confirmclaim' once you saved it. I'll then forget the key and stop posting to the channel, "+
+			"your digests will be sent to you privately instead.", nsec))
+}
+
+// HandleConfirmClaim hands the channel over to a subscriber who received its
+// key: its secret is forgotten and the delegation of the channel is revoked
+func (b *Bot) HandleConfirmClaim(ctx context.Context, ev nostr.Event) error {
+	conf := b.config.Bot.Custody
+	if !conf.Enabled {
+		return nil
+	}
+	within, err := time.ParseDuration(conf.ConfirmWithin)
+	if err != nil {
+		return err
+	}
+
+	subscriber := b.service.GetSubscriber(ctx, ev.PubKey)
+	if subscriber == nil || subscriber.UnsubscribedAt != nil || subscriber.HandedOverAt != nil {
+		logger.Info("claim confirmation from non subscriber", "pubkey", ev.PubKey)
+		return nil
+	}
+	now := time.Now()
+	if subscriber.ClaimRequestedAt == nil || now.Sub(*subscriber.ClaimRequestedAt) > within {
+		return b.client.SendMessage(ctx, b.SK, ev.PubKey, "There's no pending claim of your channel, send '#claim' to get its key first.")
+	}
+
+	channelPub, err := nostr.GetPublicKey(subscriber.ChannelSecret)
+	if err != nil {
+		return err
+	}
+	if err := b.service.HandOverChannel(ctx, ev.PubKey, channelPub, now); err != nil {
+		return err
+	}
+	b.client.RevokeDelegation(channelPub)
+
+	logger.Info("handed over channel", "pubkey", ev.PubKey, "channelPub", channelPub)
+	return b.client.SendMessage(ctx, b.SK, ev.PubKey, "Your channel is now in your custody alone. Your digests will be sent to you privately from now on.")
+}
+
+// channelKey returns the key content for the subscriber is signed with, the
+// bot's own once their channel was handed over
+func channelKey(config *types.Config, subscriber types.Subscriber) string {
+	if subscriber.HandedOverAt != nil {
+		return config.Bot.SK
+	}
+	return subscriber.ChannelSecret
+}
+
+// subscriberChannelPub returns the public key of the subscriber's channel
+func subscriberChannelPub(subscriber types.Subscriber) (string, error) {
+	if subscriber.HandedOverAt != nil {
+		return subscriber.ChannelPub, nil
+	}
+	return nostr.GetPublicKey(subscriber.ChannelSecret)
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	n "github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClaimChannel(t *testing.T) {
+	subscriberPub := "0000000000000000000000000000000000000000000000000000000000000001"
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000002"
+	channelPub, _ := nostr.GetPublicKey(channelSK)
+	nsec, _ := nip19.EncodePrivateKey(channelSK)
+	joined := time.Now().AddDate(0, 0, -30)
+
+	assert.Equal(t, CommandClaim, parseHashtagCommand("#[0] #claim"))
+	assert.Equal(t, CommandConfirmClaim, parseHashtagCommand("#[0] #confirmclaim"))
+
+	conf := *config
+	conf.Bot.Custody = types.CustodyConfig{Enabled: true, ConfirmWithin: "24h"}
+	subscriber := &types.Subscriber{Pubkey: subscriberPub, ChannelSecret: channelSK, SubscribedAt: &joined}
+
+	mockClient := new(n.MockClient)
+	mockClient.On("GiftWrap", mock.Anything, botSK, subscriberPub, mock.Anything).Return(nil)
+	mockClient.On("SendMessage", mock.Anything, botSK, subscriberPub, mock.Anything).Return(nil)
+	mockClient.On("RevokeDelegation", channelPub).Return()
+	mockService := new(service.MockService)
+	mockService.On("GetSubscriber", mock.Anything, subscriberPub).Return(subscriber)
+	mockService.On("RequestChannelClaim", mock.Anything, subscriberPub, mock.Anything).Return(nil)
+	mockService.On("HandOverChannel", mock.Anything, subscriberPub, channelPub, mock.Anything).Return(nil)
+
+	bot, err := NewBot(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	// confirming without a claim is refused
+	ev := nostr.Event{PubKey: subscriberPub, Content: "#confirmclaim"}
+	assert.NoError(t, bot.HandleConfirmClaim(context.Background(), ev))
+	mockService.AssertNotCalled(t, "HandOverChannel", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// the key is sent privately
+	assert.NoError(t, bot.HandleClaim(context.Background(), nostr.Event{PubKey: subscriberPub, Content: "#claim"}))
+	mockClient.AssertCalled(t, "GiftWrap", mock.Anything, botSK, subscriberPub, mock.MatchedBy(func(msg string) bool {
+		return strings.Contains(msg, nsec)
+	}))
+	mockService.AssertCalled(t, "RequestChannelClaim", mock.Anything, subscriberPub, mock.Anything)
+
+	requested := time.Now().Add(-time.Hour)
+	subscriber.ClaimRequestedAt = &requested
+	assert.NoError(t, bot.HandleConfirmClaim(context.Background(), ev))
+	mockService.AssertCalled(t, "HandOverChannel", mock.Anything, subscriberPub, channelPub, mock.Anything)
+	mockClient.AssertCalled(t, "RevokeDelegation", channelPub)
+}
+
+func TestChannelKey(t *testing.T) {
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000002"
+	channelPub, _ := nostr.GetPublicKey(channelSK)
+	subscriber := types.Subscriber{ChannelSecret: channelSK}
+	assert.Equal(t, channelSK, channelKey(config, subscriber))
+	pub, err := subscriberChannelPub(subscriber)
+	assert.NoError(t, err)
+	assert.Equal(t, channelPub, pub)
+
+	handedOver := time.Now()
+	subscriber = types.Subscriber{ChannelPub: channelPub, HandedOverAt: &handedOver}
+	assert.Equal(t, botSK, channelKey(config, subscriber))
+	pub, err = subscriberChannelPub(subscriber)
+	assert.NoError(t, err)
+	assert.Equal(t, channelPub, pub)
+}
+
+func TestRotateChannel(t *testing.T) {
+	subscriberPub := "0000000000000000000000000000000000000000000000000000000000000001"
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000002"
+	channelPub, _ := nostr.GetPublicKey(channelSK)
+	subscriber := &types.Subscriber{Pubkey: subscriberPub, ChannelSecret: channelSK}
+
+	var rotatedSK string
+	mockClient := new(n.MockClient)
+	mockClient.On("RevokeDelegation", channelPub).Return()
+	mockClient.On("Metadata", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Mention", mock.Anything, botSK, mock.Anything, mock.Anything).Return(nil)
+	mockService := new(service.MockService)
+	mockService.On("GetSubscriber", mock.Anything, subscriberPub).Return(subscriber)
+	mockService.On("SetChannelSecret", mock.Anything, subscriberPub, mock.Anything).Run(func(args mock.Arguments) {
+		rotatedSK = args.String(2)
+	}).Return(nil)
+
+	bot, err := NewBot(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	rotatedPub, err := bot.RotateChannel(context.Background(), subscriberPub)
+	assert.NoError(t, err)
+	assert.NotEqual(t, channelPub, rotatedPub)
+	mockClient.AssertCalled(t, "RevokeDelegation", channelPub)
+	// the subscriber is told about the new channel
+	mockClient.AssertCalled(t, "Mention", mock.Anything, botSK, mock.Anything, []string{subscriberPub, rotatedPub})
+	pub, _ := nostr.GetPublicKey(rotatedSK)
+	assert.Equal(t, rotatedPub, pub)
+}
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// rising authors ranked for each one featured, as subscribers may already
+// follow some of them
+const risingCandidateFactor = 10
+
+// PublishRisingAuthors publishes to every active subscriber's channel the
+// authors whose engagement grows fastest among those the subscriber doesn't
+// follow yet, as a NIP-23 long-form note linking their profiles.
+func (w *Worker) PublishRisingAuthors(ctx context.Context, now time.Time) error {
+	conf := w.config.Discovery
+	period, err := time.ParseDuration(conf.Period)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := w.service.GetRisingAuthors(ctx, now, period, conf.MinEngagement, conf.Size*risingCandidateFactor)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		logger.Warn("no rising authors", "period", period)
+		return nil
+	}
+	pubkeys := make([]string, 0, len(candidates))
+	for _, author := range candidates {
+		pubkeys = append(pubkeys, author.Pubkey)
+	}
+
+	limit := 10
+	for skip := 0; ; skip += limit {
+		subscribers, err := w.service.ListSubscribers(ctx, limit, skip)
+		if err != nil {
+			return err
+		}
+
+		for _, subscriber := range subscribers {
+			if subscriber.UnsubscribedAt != nil || !w.config.Sharding.Serves(subscriber.ShardKey) {
+				continue
+			}
+
+			followed, err := w.service.GetFollowed(ctx, subscriber.Pubkey, pubkeys)
+			if err != nil {
+				logger.Warn("failed to get followed authors", "pubkey", subscriber.Pubkey, "err", err)
+				continue
+			}
+			authors := unfollowedAuthors(candidates, subscriber.Pubkey, followed, conf.Size)
+			if len(authors) == 0 {
+				continue
+			}
+
+			identifier := fmt.Sprintf("rising-%s", now.Format("2006-01"))
+			title := fmt.Sprintf("Rising authors of %s", now.Format("January 2006"))
+			summary := fmt.Sprintf("%d authors you don't follow yet, engaged with more and more", len(authors))
+			err = w.client.LongForm(ctx, channelKey(w.config, subscriber), identifier, title, summary, renderRisingAuthors(authors), []string{subscriber.Pubkey})
+			if err != nil {
+				logger.Warn("failed to publish rising authors", "pubkey", subscriber.Pubkey, "err", err)
+			}
+		}
+
+		if len(subscribers) < limit {
+			break
+		}
+	}
+
+	logger.Info("rising authors published", "candidates", len(candidates))
+	return nil
+}
+
+// unfollowedAuthors returns the first authors other than the subscriber and
+// those they follow
+func unfollowedAuthors(candidates []types.RisingAuthor, subscriberPub string, followed map[string]bool, size int) []types.RisingAuthor {
+	authors := []types.RisingAuthor{}
+	for _, author := range candidates {
+		if len(authors) >= size {
+			break
+		}
+		if author.Pubkey == subscriberPub || followed[author.Pubkey] {
+			continue
+		}
+		authors = append(authors, author)
+	}
+	return authors
+}
+
+// renderRisingAuthors formats rising authors as markdown, their profiles
+// linked so that they can be followed from the note
+func renderRisingAuthors(authors []types.RisingAuthor) string {
+	var sb strings.Builder
+	sb.WriteString("Authors you don't follow yet, engaged with more and more on nostr this month.\n\n")
+	for _, author := range authors {
+		npub, err := nip19.EncodePublicKey(author.Pubkey)
+		if err != nil {
+			continue
+		}
+		growth := fmt.Sprintf("up %d%%", int64(math.Round(author.Growth*100)))
+		if author.PreviousEngagement == 0 {
+			growth = "new"
+		}
+		if author.Label != "" {
+			fmt.Fprintf(&sb, "- **%s** nostr:%s (engaged by %d, %s)\n", author.Label, npub, author.Engagement, growth)
+		} else {
+			fmt.Fprintf(&sb, "- nostr:%s (engaged by %d, %s)\n", npub, author.Engagement, growth)
+		}
+	}
+	return sb.String()
+}
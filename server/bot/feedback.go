@@ -0,0 +1,27 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+
+	n "github.com/dyng/nosdaily/nostr"
+)
+
+// askFeedback ends a channel digest with a note asking to rate it, and
+// returns the id of the note, empty if it couldn't be published. Reactions
+// and replies to the note are recorded by the service as it ingests them.
+func (w *Worker) askFeedback(ctx context.Context, channelSK string) string {
+	conf := w.config.Digest.Feedback
+	if !conf.Enabled {
+		return ""
+	}
+
+	msg := fmt.Sprintf("Rate this digest: react with 👍 or 👎, or reply with a number from 1 (not useful) to %d (great).", conf.Scale)
+	id, err := w.client.Note(ctx, channelSK, msg)
+	if err != nil {
+		channelPub, _ := n.PublicKey(channelSK)
+		logger.Warn("failed to ask for digest feedback", "channelPub", channelPub, "err", err)
+		return ""
+	}
+	return id
+}
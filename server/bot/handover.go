@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+)
+
+var errLeaseLost = errors.New("bot lease was taken over by another instance")
+
+// handover hands the bot over between instances on rolling deploys. Only
+// the instance holding the bot lease listens to mentions and runs cron jobs.
+// The lease cursor splits events by creation time: those created before it
+// are handled by the previous owner, later ones by the next.
+type handover struct {
+	service service.IService
+	name    string
+	owner   string
+	ttl     time.Duration
+
+	mu sync.Mutex
+	// cursor frozen by draining, zero until then
+	drainAt time.Time
+}
+
+func newHandover(config *types.Config, service service.IService) (*handover, error) {
+	conf := config.Handover
+	ttl, err := time.ParseDuration(conf.LeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &handover{
+		service: service,
+		name:    leaseName(config.Sharding),
+		owner:   conf.InstanceName(),
+		ttl:     ttl,
+	}, nil
+}
+
+// leaseName names the bot lease, instances serving other shards run their
+// own bot
+func leaseName(conf types.ShardingConfig) string {
+	return shardedName("bot", conf)
+}
+
+// shardedName suffixes the name of a job with the shards this instance
+// serves, as instances serving other shards run the job as well
+func shardedName(name string, conf types.ShardingConfig) string {
+	if len(conf.Serve) == 0 {
+		return name
+	}
+	shards := make([]string, len(conf.Serve))
+	for i, shard := range conf.Serve {
+		shards[i] = strconv.Itoa(shard)
+	}
+	return name + "/" + strings.Join(shards, ",")
+}
+
+// acquire waits until this instance holds the lease, and returns the cursor
+// from which it handles events
+func (h *handover) acquire(ctx context.Context, stop <-chan struct{}) (time.Time, error) {
+	for {
+		now := time.Now()
+		lease, err := h.service.AcquireLease(ctx, h.name, h.owner, time.Time{}, h.ttl, now)
+		if err != nil {
+			logger.Warn("failed to acquire bot lease", "name", h.name, "err", err)
+		} else if lease.Owner == h.owner {
+			cursor := lease.Cursor
+			if cursor.IsZero() {
+				cursor = now
+			}
+			logger.Info("acquired bot lease", "name", h.name, "owner", h.owner, "cursor", cursor)
+			return cursor, nil
+		} else {
+			logger.Info("waiting for bot lease", "name", h.name, "holder", lease.Owner, "expiresAt", lease.ExpiresAt)
+		}
+
+		select {
+		case <-time.After(h.ttl / 3):
+		case <-stop:
+			return time.Time{}, context.Canceled
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// keep renews the lease until ctx is done. It calls lost once the lease
+// can't be renewed before it expires, as another instance may take it.
+func (h *handover) keep(ctx context.Context, lost func()) {
+	renewedAt := time.Now()
+	ticker := time.NewTicker(h.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			lease, err := h.service.AcquireLease(ctx, h.name, h.owner, h.cursor(now), h.ttl, now)
+			if err == nil && lease.Owner == h.owner {
+				renewedAt = now
+				continue
+			}
+
+			if err != nil {
+				logger.Warn("failed to renew bot lease", "name", h.name, "err", err)
+			}
+			if (err == nil || now.Sub(renewedAt) >= h.ttl) && ctx.Err() == nil {
+				logger.Error("lost bot lease", "name", h.name, "owner", h.owner)
+				lost()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cursor is the time up to which this instance handles events
+func (h *handover) cursor(now time.Time) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.drainAt.IsZero() {
+		return h.drainAt
+	}
+	return now
+}
+
+// drain freezes the cursor, so that events created from now on are left to
+// the next owner. It's truncated to the precision of event timestamps.
+func (h *handover) drain(now time.Time) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.drainAt.IsZero() {
+		h.drainAt = now.Truncate(time.Second)
+	}
+	return h.drainAt
+}
+
+func (h *handover) release(ctx context.Context, cursor time.Time) error {
+	return h.service.ReleaseLease(ctx, h.name, h.owner, cursor)
+}
+
+// handles tells if an event belongs to the instance that took over at since
+// and started draining at drainAt, if it did
+func handles(createdAt, since, drainAt time.Time) bool {
+	return !createdAt.Before(since) && (drainAt.IsZero() || createdAt.Before(drainAt))
+}
@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandles(t *testing.T) {
+	since := time.Date(2023, 3, 22, 10, 0, 0, 0, time.UTC)
+	drainAt := since.Add(time.Hour)
+
+	assert.False(t, handles(since.Add(-time.Second), since, time.Time{}))
+	assert.True(t, handles(since, since, time.Time{}))
+	assert.True(t, handles(drainAt.Add(-time.Second), since, drainAt))
+	// left to the next owner
+	assert.False(t, handles(drainAt, since, drainAt))
+}
+
+func TestLeaseName(t *testing.T) {
+	assert.Equal(t, "bot", leaseName(types.ShardingConfig{Shards: 4}))
+	assert.Equal(t, "bot/0,2", leaseName(types.ShardingConfig{Shards: 4, Serve: []int{0, 2}}))
+}
+
+func TestAcquire(t *testing.T) {
+	cursor := time.Date(2023, 3, 22, 10, 0, 0, 0, time.UTC)
+	mockService := new(service.MockService)
+	mockService.On("AcquireLease", mock.Anything, "bot", "b", time.Time{}, time.Minute, mock.Anything).
+		Return(&types.Lease{Name: "bot", Owner: "b", Cursor: cursor}, nil)
+
+	h := &handover{service: mockService, name: "bot", owner: "b", ttl: time.Minute}
+	since, err := h.acquire(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, since)
+
+	// the drain cursor is kept by renewals
+	drainAt := h.drain(cursor.Add(90 * time.Minute))
+	assert.Equal(t, drainAt, h.cursor(cursor.Add(2*time.Hour)))
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	JobDigest = "digest"
+	JobRecap  = "recap"
+)
+
+// jobEnabled tells whether the named job is run by this deployment
+func (ba *BotApplication) jobEnabled(name string) bool {
+	return !slices.Contains(ba.config.Bot.Jobs.Disabled, name)
+}
+
+// addJob registers the named job unless it's disabled. Jobs with an invalid
+// schedule are logged and left out.
+func (ba *BotApplication) addJob(cr *cron.Cron, name, schedule string, run func()) {
+	if !ba.jobEnabled(name) {
+		logger.Info("cron job disabled", "name", name)
+		return
+	}
+	logger.Info("register cron job", "name", name, "schedule", schedule)
+	if _, err := cr.AddFunc(schedule, run); err != nil {
+		logger.Error("invalid cron job schedule", "name", name, "schedule", schedule, "err", err)
+	}
+}
+
+// defaultDigestSchedule runs a digest every PushInterval, for deployments
+// without a valid digest schedule
+const defaultDigestSchedule = "0 * * * *"
+
+// longest time searched back for the last activation of a schedule, enough
+// for yearly schedules
+const maxScheduleLookback = 2 * 366 * 24 * time.Hour
+
+// parseSchedule parses the cron schedule, or the default digest schedule if
+// it's empty or invalid
+func parseSchedule(spec string) cron.Schedule {
+	if sched, err := cron.ParseStandard(spec); err == nil {
+		return sched
+	}
+	sched, _ := cron.ParseStandard(defaultDigestSchedule)
+	return sched
+}
+
+// lastActivation returns the latest activation of the schedule at or before
+// t, or the zero time if there was none within maxScheduleLookback
+func lastActivation(sched cron.Schedule, t time.Time) time.Time {
+	for back := time.Minute; back <= maxScheduleLookback; back *= 2 {
+		run := sched.Next(t.Add(-back))
+		if run.IsZero() || run.After(t) {
+			continue
+		}
+		for next := sched.Next(run); !next.After(t); next = sched.Next(next) {
+			run = next
+		}
+		return run
+	}
+	return time.Time{}
+}
+
+// scheduleWindow returns the time covered by a run ending at end: since the
+// activation before the latest one at or before end. A run of a schedule
+// skipping days, e.g. on weekdays only, covers the days skipped too.
+func scheduleWindow(sched cron.Schedule, end time.Time) time.Duration {
+	current := lastActivation(sched, end)
+	if current.IsZero() {
+		return PushInterval
+	}
+	previous := lastActivation(sched, current.Add(-time.Second))
+	if previous.IsZero() {
+		return PushInterval
+	}
+	return end.Sub(previous)
+}
+
+// schedule is the schedule of digest runs
+func (w *Worker) schedule() cron.Schedule {
+	return parseSchedule(w.config.Bot.Jobs.Digest)
+}
+
+// window is the time covered by a digest run ending at end
+func (w *Worker) window(end time.Time) time.Duration {
+	return scheduleWindow(w.schedule(), end)
+}
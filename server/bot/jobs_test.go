@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastActivation(t *testing.T) {
+	now := time.Date(2023, 3, 22, 13, 20, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC), lastActivation(parseSchedule("0 * * * *"), now))
+	assert.Equal(t, time.Date(2023, 3, 22, 8, 0, 0, 0, time.UTC), lastActivation(parseSchedule("0 8 * * *"), now))
+	assert.Equal(t, time.Date(2023, 3, 19, 0, 0, 0, 0, time.UTC), lastActivation(parseSchedule("@weekly"), now))
+	assert.Equal(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), lastActivation(parseSchedule("@yearly"), now))
+	// an activation at t is the latest
+	assert.Equal(t, now, lastActivation(parseSchedule("20 13 * * *"), now))
+}
+
+func TestScheduleWindow(t *testing.T) {
+	now := time.Date(2023, 3, 22, 13, 20, 0, 0, time.UTC)
+	assert.Equal(t, time.Hour+20*time.Minute, scheduleWindow(parseSchedule("0 * * * *"), now))
+	assert.Equal(t, 24*time.Hour, scheduleWindow(parseSchedule("0 8 * * *"), time.Date(2023, 3, 22, 8, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 7*24*time.Hour, scheduleWindow(parseSchedule("@weekly"), time.Date(2023, 3, 19, 0, 0, 0, 0, time.UTC)))
+
+	// Monday's run of a weekday schedule covers the weekend
+	weekdays := parseSchedule("0 9 * * 1-5")
+	assert.Equal(t, 72*time.Hour, scheduleWindow(weekdays, time.Date(2023, 3, 20, 9, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 24*time.Hour, scheduleWindow(weekdays, time.Date(2023, 3, 21, 9, 0, 0, 0, time.UTC)))
+
+	// empty or invalid schedules run every PushInterval
+	assert.Equal(t, PushInterval, scheduleWindow(parseSchedule(""), time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC)))
+	assert.Equal(t, PushInterval, scheduleWindow(parseSchedule("every hour"), time.Date(2023, 3, 22, 13, 0, 0, 0, time.UTC)))
+}
+
+func TestNewScheduler(t *testing.T) {
+	conf := &types.Config{}
+	conf.Bot.Jobs.Digest = "0 8 * * *"
+	conf.Bot.Jobs.Recap = "0 0 * * 1"
+	conf.Recap.Enabled = true
+	conf.Bot.Trending = types.TrendingConfig{Enabled: true, Schedule: "*/30 * * * *"}
+	conf.Bot.TopicChannels = []types.TopicChannel{{Topic: "bitcoin", Schedule: "0 */6 * * *"}, {Topic: "nostr"}}
+
+	ba := &BotApplication{config: conf}
+	assert.Len(t, ba.newScheduler(context.Background()).Entries(), 4)
+
+	// recaps are only published when enabled
+	conf.Recap.Enabled = false
+	assert.Len(t, ba.newScheduler(context.Background()).Entries(), 3)
+
+	conf.Recap.Enabled = true
+	conf.Bot.Jobs.Disabled = []string{JobRecap, "topic/bitcoin"}
+	assert.Len(t, ba.newScheduler(context.Background()).Entries(), 2)
+
+	// jobs with an invalid schedule are left out
+	conf.Bot.Jobs.Digest = "daily"
+	assert.Len(t, ba.newScheduler(context.Background()).Entries(), 1)
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// UpdateLeaderboard computes the leaderboards of the last week and publishes
+// them as a note mentioning the new authors, if a publishing key is set
+func (w *Worker) UpdateLeaderboard(ctx context.Context, now time.Time) error {
+	board, err := w.service.ComputeLeaderboard(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	conf := w.config.Bot.Leaderboard
+	if conf.SK == "" {
+		return nil
+	}
+
+	mentions := []string{}
+	for _, author := range board.NewAuthors {
+		mentions = append(mentions, author.Key)
+	}
+	if err := w.client.Mention(ctx, conf.SK, renderLeaderboard(board), mentions); err != nil {
+		return err
+	}
+
+	logger.Info("published leaderboard", "start", board.Start, "end", board.End)
+	return nil
+}
+
+// renderLeaderboard formats a leaderboard as a note
+func renderLeaderboard(board *types.Leaderboard) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "nossence leaderboard for the week of %s\n", board.Start.Format("Jan 2, 2006"))
+
+	if len(board.ZappedPosts) > 0 {
+		sb.WriteString("\n⚡ Most zapped posts\n")
+		for i, post := range board.ZappedPosts {
+			note, err := nip19.EncodeNote(post.Key)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&sb, "%d. nostr:%s (%d sats)\n", i+1, note, post.Count)
+		}
+	}
+
+	if len(board.NewAuthors) > 0 {
+		sb.WriteString("\n🌱 Most appreciated new authors\n")
+		for i, author := range board.NewAuthors {
+			npub, err := nip19.EncodePublicKey(author.Key)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&sb, "%d. nostr:%s (%d reactions)\n", i+1, npub, author.Count)
+		}
+	}
+
+	if len(board.TopTopics) > 0 {
+		sb.WriteString("\n🔥 Top topics\n")
+		for i, topic := range board.TopTopics {
+			fmt.Fprintf(&sb, "%d. #%s (%d posts)\n", i+1, topic.Key, topic.Count)
+		}
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	n "github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const featuredMessage = "Hi #[0], your note nostr:%s was featured in the nossence digest! Mention me with #optout if you don't want to be notified again."
+
+// FeaturedNotifier tells authors that their post has been featured in the
+// public digest. Notifications are globally rate limited, and authors who
+// opted out are never notified.
+type FeaturedNotifier struct {
+	config  types.NotifyConfig
+	client  n.IClient
+	service service.IService
+	sk      string
+
+	mu     sync.Mutex
+	sentAt []time.Time
+}
+
+func NewFeaturedNotifier(client n.IClient, service service.IService, config *types.Config) *FeaturedNotifier {
+	return &FeaturedNotifier{
+		config:  config.Bot.Notify,
+		client:  client,
+		service: service,
+		sk:      config.Bot.SK,
+	}
+}
+
+func (fn *FeaturedNotifier) NotifyFeatured(ctx context.Context, feed []types.FeedEntry) {
+	if !fn.config.Enabled {
+		return
+	}
+
+	for _, post := range feed {
+		optedOut, err := fn.service.IsNotificationOptedOut(ctx, post.Pubkey)
+		if err != nil {
+			logger.Warn("failed to check notification opt-out", "pubkey", post.Pubkey, "err", err)
+			continue
+		}
+		if optedOut {
+			logger.Debug("skip notifying opted-out author", "pubkey", post.Pubkey)
+			continue
+		}
+
+		if !fn.acquire() {
+			logger.Info("featured notification rate limit reached, skipping the rest", "limit", fn.config.MaxPerHour)
+			return
+		}
+
+		if err := fn.notify(ctx, post); err != nil {
+			logger.Warn("failed to notify featured author", "pubkey", post.Pubkey, "id", post.Id, "err", err)
+		}
+	}
+}
+
+func (fn *FeaturedNotifier) notify(ctx context.Context, post types.FeedEntry) error {
+	note, err := nip19.EncodeNote(post.Id)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf(featuredMessage, note)
+	if fn.config.Mode == "dm" {
+		return fn.client.SendMessage(ctx, fn.sk, post.Pubkey, msg)
+	}
+	return fn.client.Mention(ctx, fn.sk, msg, []string{post.Pubkey})
+}
+
+// acquire takes a slot of the hourly budget, shared by all authors
+func (fn *FeaturedNotifier) acquire() bool {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour)
+	recent := fn.sentAt[:0]
+	for _, t := range fn.sentAt {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	fn.sentAt = recent
+
+	if len(fn.sentAt) >= fn.config.MaxPerHour {
+		return false
+	}
+	fn.sentAt = append(fn.sentAt, time.Now())
+	return true
+}
@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Nudge reminds active subscribers who don't follow their channel, as the
+// digest never shows up in their home feed otherwise. Subscribers get at
+// most MaxReminders reminders, one per Interval.
+func (w *Worker) Nudge(ctx context.Context, now time.Time) error {
+	conf := w.config.Nudge
+	interval, err := time.ParseDuration(conf.Interval)
+	if err != nil {
+		return err
+	}
+
+	limit := 10
+	for skip := 0; ; skip += limit {
+		subscribers, err := w.service.ListSubscribers(ctx, limit, skip)
+		if err != nil {
+			return err
+		}
+
+		for _, subscriber := range subscribers {
+			if !w.config.Sharding.Serves(subscriber.ShardKey) || !dueForNudge(subscriber, conf.MaxReminders, interval, now) {
+				continue
+			}
+
+			if err := w.nudge(ctx, subscriber, now); err != nil {
+				logger.Warn("failed to nudge subscriber", "pubkey", subscriber.Pubkey, "err", err)
+			}
+		}
+
+		if len(subscribers) < limit {
+			break
+		}
+	}
+	return nil
+}
+
+func (w *Worker) nudge(ctx context.Context, subscriber types.Subscriber, now time.Time) error {
+	channelPub, err := nostr.GetPublicKey(subscriber.ChannelSecret)
+	if err != nil {
+		return err
+	}
+
+	// users whose contact list is unknown may well follow the channel
+	follows, err := w.service.FollowsChannel(ctx, subscriber.Pubkey, channelPub)
+	if errors.Is(err, service.ErrContactsUnknown) {
+		logger.Debug("skip nudging subscriber of unknown contacts", "pubkey", subscriber.Pubkey)
+		return nil
+	}
+	if err != nil || follows {
+		return err
+	}
+
+	npub, err := nip19.EncodePublicKey(channelPub)
+	if err != nil {
+		return err
+	}
+
+	msg := "Your nossence digest is published by your own channel, but it seems you haven't followed it yet. Follow nostr:" + npub + " to see it in your feed."
+	if err := w.client.SendMessage(ctx, w.config.Bot.SK, subscriber.Pubkey, msg); err != nil {
+		return err
+	}
+
+	logger.Info("sent follow reminder", "pubkey", subscriber.Pubkey, "reminders", subscriber.FollowReminders+1)
+	return w.service.MarkReminded(ctx, subscriber.Pubkey, now)
+}
+
+func dueForNudge(subscriber types.Subscriber, maxReminders int, interval time.Duration, now time.Time) bool {
+	if subscriber.UnsubscribedAt != nil || subscriber.HandedOverAt != nil || subscriber.FollowReminders >= maxReminders {
+		return false
+	}
+
+	// give new subscribers some time to follow the channel
+	if subscriber.SubscribedAt != nil && now.Sub(*subscriber.SubscribedAt) < interval {
+		return false
+	}
+	return subscriber.LastRemindedAt == nil || now.Sub(*subscriber.LastRemindedAt) >= interval
+}
@@ -0,0 +1,53 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HandlePreferences syncs the preferences a subscriber published in a
+// NIP-78 application data event
+func (b *Bot) HandlePreferences(ctx context.Context, ev nostr.Event) error {
+	prefs, err := parsePreferences(ev)
+	if err != nil {
+		return err
+	}
+
+	if b.service.GetSubscriber(ctx, ev.PubKey) == nil {
+		logger.Info("preferences from non subscriber", "pubkey", ev.PubKey)
+		return nil
+	}
+
+	synced, err := b.service.SyncPreferences(ctx, ev.PubKey, *prefs, ev.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if synced {
+		logger.Info("synced preferences", "pubkey", ev.PubKey, "preferences", prefs)
+	} else {
+		logger.Info("skip outdated preferences", "pubkey", ev.PubKey, "id", ev.ID)
+	}
+	return nil
+}
+
+func parsePreferences(ev nostr.Event) (*types.Preferences, error) {
+	if ev.Kind != types.PreferencesKind {
+		return nil, fmt.Errorf("unexpected kind %d", ev.Kind)
+	}
+	if d := ev.Tags.GetFirst([]string{"d", ""}); d == nil || d.Value() != types.PreferencesIdentifier {
+		return nil, fmt.Errorf("not a nossence preferences event")
+	}
+	if ok, err := ev.CheckSignature(); !ok {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+
+	prefs := &types.Preferences{}
+	if err := json.Unmarshal([]byte(ev.Content), prefs); err != nil {
+		return nil, fmt.Errorf("malformed preferences: %w", err)
+	}
+	return prefs, nil
+}
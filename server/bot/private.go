@@ -0,0 +1,28 @@
+package bot
+
+import (
+	"strings"
+
+	"github.com/dyng/nosdaily/enrich"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// renderPrivateDigest lists the posts of a digest sent privately, as the
+// posts can't be reposted without revealing what the subscriber reads. Posts
+// are followed by their annotations, if any.
+func renderPrivateDigest(feed []types.FeedEntry, annotations map[string][]enrich.Annotation) string {
+	var sb strings.Builder
+	sb.WriteString("Your nossence digest:\n")
+	for _, post := range feed {
+		note, err := nip19.EncodeNote(post.Id)
+		if err != nil {
+			continue
+		}
+		sb.WriteString("\nnostr:" + note)
+		for _, annotation := range annotations[post.Id] {
+			sb.WriteString("\n  " + annotation.Step + ": " + annotation.Text)
+		}
+	}
+	return sb.String()
+}
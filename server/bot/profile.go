@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// PublishProfile publishes the bot's own kind 0 profile, NIP-65 relay list
+// and NIP-89 handler information from config, so that every deployment
+// presents consistent branding.
+func (b *Bot) PublishProfile(ctx context.Context) error {
+	logger.Info("Create account metadata", "pubkey", b.pub)
+	metadata := b.config.Bot.Metadata
+	relays := b.recommendedRelayList(*b.config)
+	err := b.client.Metadata(ctx, b.SK, metadata.Name, metadata.About, metadata.Picture, metadata.Nip05, relays)
+	if err != nil {
+		return err
+	}
+	if err := b.publishTopicProfiles(ctx); err != nil {
+		return err
+	}
+
+	if len(metadata.HandlerKinds) == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(map[string]string{
+		"name":    metadata.Name,
+		"about":   metadata.About,
+		"picture": metadata.Picture,
+		"nip05":   metadata.Nip05,
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Publish handler information", "pubkey", b.pub, "kinds", metadata.HandlerKinds)
+	return b.client.HandlerInformation(ctx, b.SK, metadata.HandlerIdentifier, string(content), metadata.HandlerKinds)
+}
@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Recap publishes a "year so far" retrospective to the channel of every
+// active subscriber this instance serves, as a NIP-23 long-form note.
+func (w *Worker) Recap(ctx context.Context) error {
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	limit := 10
+	for after := ""; ; {
+		subscribers, err := w.service.ListSubscribersAfter(ctx, after, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, subscriber := range subscribers {
+			if subscriber.UnsubscribedAt != nil || !w.config.Sharding.Serves(subscriber.ShardKey) {
+				continue
+			}
+
+			err := w.publishRecap(ctx, subscriber, since, now)
+			if err != nil {
+				logger.Warn("failed to publish recap", "pubkey", subscriber.Pubkey, "err", err)
+			}
+		}
+
+		if len(subscribers) < limit {
+			break
+		}
+		after = subscribers[len(subscribers)-1].Pubkey
+	}
+
+	logger.Info("recap finished", "since", since)
+	return nil
+}
+
+func (w *Worker) publishRecap(ctx context.Context, subscriber types.Subscriber, since, now time.Time) error {
+	recap, err := w.service.GetRecap(ctx, subscriber.Pubkey, since)
+	if err != nil {
+		return err
+	}
+	if recap.TotalFeatured == 0 {
+		logger.Debug("skip empty recap", "pubkey", subscriber.Pubkey)
+		return nil
+	}
+
+	identifier := fmt.Sprintf("recap-%s", now.Format("2006-01"))
+	title := fmt.Sprintf("Your %d so far on nossence", now.Year())
+	summary := fmt.Sprintf("%d posts featured for you since %s", recap.TotalFeatured, since.Format("Jan 2"))
+	return w.client.LongForm(ctx, channelKey(w.config, subscriber), identifier, title, summary, renderRecap(recap), []string{subscriber.Pubkey})
+}
+
+// renderRecap formats a recap as markdown
+func renderRecap(recap *types.Recap) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "nossence featured **%d** posts for you this year.\n", recap.TotalFeatured)
+
+	if len(recap.TopTopics) > 0 {
+		sb.WriteString("\n## Your most engaged topics\n\n")
+		for _, topic := range recap.TopTopics {
+			fmt.Fprintf(&sb, "- #%s (%d posts)\n", topic.Key, topic.Count)
+		}
+	}
+
+	if len(recap.TopAuthors) > 0 {
+		sb.WriteString("\n## Authors you discovered\n\n")
+		for _, author := range recap.TopAuthors {
+			npub, err := nip19.EncodePublicKey(author.Key)
+			if err != nil {
+				continue
+			}
+			if author.Label != "" {
+				fmt.Fprintf(&sb, "- **%s** nostr:%s (%d posts)\n", author.Label, npub, author.Count)
+			} else {
+				fmt.Fprintf(&sb, "- nostr:%s (%d posts)\n", npub, author.Count)
+			}
+		}
+	}
+
+	return sb.String()
+}
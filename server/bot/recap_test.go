@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRecap(t *testing.T) {
+	left := time.Now().AddDate(0, -1, 0)
+	since := time.Date(time.Now().UTC().Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("LongForm", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribersAfter", mock.Anything, "", 10).Return([]types.Subscriber{
+		{Pubkey: "reader", ChannelSecret: "reader_secret"},
+		{Pubkey: "quiet", ChannelSecret: "quiet_secret"},
+		{Pubkey: "gone", ChannelSecret: "gone_secret", UnsubscribedAt: &left},
+		{Pubkey: "elsewhere", ChannelSecret: "elsewhere_secret", ShardKey: otherShard},
+	}, nil)
+	mockService.On("GetRecap", mock.Anything, "reader", since).Return(&types.Recap{Pubkey: "reader", Since: since, TotalFeatured: 3}, nil)
+	mockService.On("GetRecap", mock.Anything, "quiet", since).Return(&types.Recap{Pubkey: "quiet", Since: since}, nil)
+
+	conf := *config
+	conf.Sharding = servedShard
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	// only subscribers with something featured get a recap, from the
+	// instance serving them
+	assert.NoError(t, worker.Recap(context.Background()))
+	mockService.AssertNotCalled(t, "GetRecap", mock.Anything, "gone", mock.Anything)
+	mockService.AssertNotCalled(t, "GetRecap", mock.Anything, "elsewhere", mock.Anything)
+	mockClient.AssertNumberOfCalls(t, "LongForm", 1)
+	identifier := fmt.Sprintf("recap-%s", time.Now().UTC().Format("2006-01"))
+	mockClient.AssertCalled(t, "LongForm", mock.Anything, "reader_secret", identifier, mock.Anything, mock.Anything, mock.Anything, []string{"reader"})
+}
+
+func TestRenderRecap(t *testing.T) {
+	named := "0000000000000000000000000000000000000000000000000000000000000001"
+	unnamed := "0000000000000000000000000000000000000000000000000000000000000002"
+	namedNpub, _ := nip19.EncodePublicKey(named)
+	unnamedNpub, _ := nip19.EncodePublicKey(unnamed)
+
+	content := renderRecap(&types.Recap{
+		TotalFeatured: 42,
+		TopTopics:     []types.RecapItem{{Key: "nostr", Count: 7}},
+		TopAuthors: []types.RecapItem{
+			{Key: named, Count: 5, Label: "alice"},
+			{Key: unnamed, Count: 2},
+			{Key: "invalid", Count: 1},
+		},
+	})
+	assert.Contains(t, content, "**42** posts")
+	assert.Contains(t, content, "- #nostr (7 posts)\n")
+	assert.Contains(t, content, "- **alice** nostr:"+namedNpub+" (5 posts)\n")
+	assert.Contains(t, content, "- nostr:"+unnamedNpub+" (2 posts)\n")
+	assert.NotContains(t, content, "(1 posts)")
+
+	// sections without items are left out
+	content = renderRecap(&types.Recap{TotalFeatured: 1})
+	assert.NotContains(t, content, "##")
+}
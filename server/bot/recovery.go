@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const recoveryPageSize = 500
+
+type RecoveryReport struct {
+	Scanned    int      `json:"scanned"`
+	Consistent int      `json:"consistent"`
+	Missing    []string `json:"missing"`
+	Mismatched []string `json:"mismatched"`
+	Recreated  []string `json:"recreated"`
+	Skipped    []string `json:"skipped"`
+}
+
+type channelMapping struct {
+	channelPub   string
+	subscribedAt time.Time
+}
+
+// RecoverSubscriptions rebuilds the channel<->subscriber mapping from the
+// welcome messages the bot has published on relays, and compares it with the
+// Subscriber graph.
+//
+// Channel secrets never leave the database, so a missing subscriber cannot be
+// restored with its original channel. If recreate is true, a new channel is
+// created for each missing subscriber who hasn't unsubscribed since.
+func (b *Bot) RecoverSubscriptions(ctx context.Context, recreate bool) *RecoveryReport {
+	logger.Info("recovering subscriptions from relays", "pubkey", b.pub, "recreate", recreate)
+
+	mappings := b.scanWelcomeMessages(ctx)
+	report := &RecoveryReport{
+		Scanned:    len(mappings),
+		Missing:    []string{},
+		Mismatched: []string{},
+		Recreated:  []string{},
+		Skipped:    []string{},
+	}
+
+	for subscriberPub, mapping := range mappings {
+		subscriber := b.service.GetSubscriber(ctx, subscriberPub)
+		if subscriber == nil {
+			report.Missing = append(report.Missing, subscriberPub)
+			continue
+		}
+
+		channelPub, err := subscriberChannelPub(*subscriber)
+		if err != nil || channelPub != mapping.channelPub {
+			logger.Warn("channel of subscriber mismatches published welcome message", "pubkey", subscriberPub, "expected", mapping.channelPub, "actual", channelPub)
+			report.Mismatched = append(report.Mismatched, subscriberPub)
+			continue
+		}
+
+		report.Consistent++
+	}
+
+	if !recreate {
+		return report
+	}
+
+	for _, subscriberPub := range report.Missing {
+		if b.hasUnsubscribedSince(ctx, subscriberPub, mappings[subscriberPub].subscribedAt) {
+			logger.Info("skip recreating subscription for unsubscribed user", "pubkey", subscriberPub)
+			report.Skipped = append(report.Skipped, subscriberPub)
+			continue
+		}
+
+		channelSK, err := b.createSubscription(ctx, subscriberPub)
+		if err != nil {
+			logger.Error("failed to recreate subscription", "pubkey", subscriberPub, "err", err)
+			continue
+		}
+
+		err = b.SendWelcomeMessage(ctx, channelSK, subscriberPub)
+		if err != nil {
+			logger.Warn("failed to send welcome message to recovered subscriber", "pubkey", subscriberPub, "err", err)
+		}
+		report.Recreated = append(report.Recreated, subscriberPub)
+	}
+
+	logger.Info("recovered subscriptions", "scanned", report.Scanned, "consistent", report.Consistent,
+		"missing", len(report.Missing), "mismatched", len(report.Mismatched), "recreated", len(report.Recreated))
+	return report
+}
+
+// scanWelcomeMessages pages backward through the bot's notes and collects the
+// latest channel announced to each subscriber. A welcome message tags the
+// subscriber first and the channel second.
+func (b *Bot) scanWelcomeMessages(ctx context.Context) map[string]channelMapping {
+	mappings := map[string]channelMapping{}
+
+	until := time.Now()
+	for {
+		events := b.client.Query(ctx, nostr.Filter{
+			Kinds:   []int{1},
+			Authors: []string{b.pub},
+			Until:   &until,
+			Limit:   recoveryPageSize,
+		})
+		if len(events) == 0 {
+			break
+		}
+
+		oldest := until
+		for _, ev := range events {
+			if ev.CreatedAt.Before(oldest) {
+				oldest = ev.CreatedAt
+			}
+
+			mentions := ev.Tags.GetAll([]string{"p"})
+			if len(mentions) != 2 {
+				continue
+			}
+
+			subscriberPub, channelPub := mentions[0].Value(), mentions[1].Value()
+			if existing, ok := mappings[subscriberPub]; ok && existing.subscribedAt.After(ev.CreatedAt) {
+				continue
+			}
+			mappings[subscriberPub] = channelMapping{
+				channelPub:   channelPub,
+				subscribedAt: ev.CreatedAt,
+			}
+		}
+
+		if !oldest.Before(until) {
+			break
+		}
+		until = oldest.Add(-time.Second)
+	}
+
+	return mappings
+}
+
+func (b *Bot) hasUnsubscribedSince(ctx context.Context, subscriberPub string, since time.Time) bool {
+	events := b.client.Query(ctx, nostr.Filter{
+		Kinds:   []int{1},
+		Authors: []string{subscriberPub},
+		Since:   &since,
+		Tags: nostr.TagMap{
+			"p": []string{b.pub},
+		},
+	})
+
+	var latest *nostr.Event
+	for i, ev := range events {
+		if parseHashtagCommand(ev.Content) == CommandNone {
+			continue
+		}
+		if latest == nil || ev.CreatedAt.After(latest.CreatedAt) {
+			latest = &events[i]
+		}
+	}
+
+	return latest != nil && parseHashtagCommand(latest.Content) == CommandUnsubscribe
+}
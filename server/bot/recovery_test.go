@@ -0,0 +1,79 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	n "github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRecoverSubscriptions(t *testing.T) {
+	botPub, _ := nostr.GetPublicKey(botSK)
+	aliceSK, bobSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	alicePub, _ := nostr.GetPublicKey(aliceSK)
+	welcomedAt := time.Now().Add(-24 * time.Hour)
+
+	welcome := func(subscriberPub, channelPub string, at time.Time) nostr.Event {
+		return nostr.Event{PubKey: botPub, Kind: 1, CreatedAt: at, Tags: nostr.Tags{{"p", subscriberPub}, {"p", channelPub}}}
+	}
+	welcomes := []nostr.Event{
+		// alice got a new channel since her first welcome
+		welcome("alice", "stale", welcomedAt.Add(-time.Hour)),
+		welcome("alice", alicePub, welcomedAt),
+		welcome("bob", "announced", welcomedAt),
+		welcome("carol", "carol-channel", welcomedAt),
+		welcome("dave", "dave-channel", welcomedAt),
+		{PubKey: botPub, Kind: 1, CreatedAt: welcomedAt, Content: "daily digest"},
+	}
+
+	mockClient := new(n.MockClient)
+	mockClient.On("Query", mock.Anything, mock.MatchedBy(func(f nostr.Filter) bool {
+		return f.Authors[0] == botPub && f.Until.After(welcomedAt)
+	})).Return(welcomes)
+	mockClient.On("Query", mock.Anything, mock.MatchedBy(func(f nostr.Filter) bool {
+		return f.Authors[0] == botPub && !f.Until.After(welcomedAt)
+	})).Return([]nostr.Event{})
+	mockClient.On("Query", mock.Anything, mock.MatchedBy(func(f nostr.Filter) bool {
+		return f.Authors[0] == "carol"
+	})).Return([]nostr.Event{
+		{PubKey: "carol", Kind: 1, CreatedAt: welcomedAt.Add(time.Hour), Content: "#[0] #subscribe"},
+		{PubKey: "carol", Kind: 1, CreatedAt: welcomedAt.Add(2 * time.Hour), Content: "#[0] #unsubscribe"},
+	})
+	mockClient.On("Query", mock.Anything, mock.MatchedBy(func(f nostr.Filter) bool {
+		return f.Authors[0] == "dave"
+	})).Return([]nostr.Event{})
+
+	mockService := new(service.MockService)
+	mockService.On("GetSubscriber", mock.Anything, "alice").Return(&types.Subscriber{Pubkey: "alice", ChannelSecret: aliceSK})
+	mockService.On("GetSubscriber", mock.Anything, "bob").Return(&types.Subscriber{Pubkey: "bob", ChannelSecret: bobSK})
+	mockService.On("GetSubscriber", mock.Anything, "carol").Return((*types.Subscriber)(nil))
+	mockService.On("GetSubscriber", mock.Anything, "dave").Return((*types.Subscriber)(nil))
+	mockService.On("CreateSubscriber", mock.Anything, "dave", mock.Anything, mock.Anything).Return(errors.New("database is down"))
+
+	bot, err := NewBot(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	report := bot.RecoverSubscriptions(context.Background(), false)
+	sort.Strings(report.Missing)
+	assert.Equal(t, 4, report.Scanned)
+	assert.Equal(t, 1, report.Consistent)
+	assert.Equal(t, []string{"bob"}, report.Mismatched)
+	assert.Equal(t, []string{"carol", "dave"}, report.Missing)
+	mockService.AssertNotCalled(t, "CreateSubscriber", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// users who unsubscribed since are left alone, failures aren't reported
+	// as recreated
+	report = bot.RecoverSubscriptions(context.Background(), true)
+	assert.Equal(t, []string{"carol"}, report.Skipped)
+	assert.Empty(t, report.Recreated)
+	mockService.AssertNotCalled(t, "CreateSubscriber", mock.Anything, "carol", mock.Anything, mock.Anything)
+	mockService.AssertCalled(t, "CreateSubscriber", mock.Anything, "dave", mock.Anything, mock.Anything)
+}
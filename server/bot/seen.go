@@ -0,0 +1,38 @@
+package bot
+
+import "container/list"
+
+// events remembered by the bot to skip those delivered again
+const seenEventsSize = 10000
+
+// seenEvents remembers the ids of the events handled recently, as relays
+// deliver the same event more than once, e.g. once resubscribed after a
+// reconnect. The least recently seen are forgotten beyond its size.
+type seenEvents struct {
+	size  int
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+func newSeenEvents(size int) *seenEvents {
+	return &seenEvents{
+		size:  size,
+		order: list.New(),
+		ids:   map[string]*list.Element{},
+	}
+}
+
+// add records the id, and tells if it wasn't seen before
+func (s *seenEvents) add(id string) bool {
+	if e, ok := s.ids[id]; ok {
+		s.order.MoveToFront(e)
+		return false
+	}
+	s.ids[id] = s.order.PushFront(id)
+	if s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.ids, oldest.Value.(string))
+	}
+	return true
+}
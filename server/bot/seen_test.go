@@ -0,0 +1,19 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeenEvents(t *testing.T) {
+	seen := newSeenEvents(2)
+	assert.True(t, seen.add("a"))
+	assert.False(t, seen.add("a"))
+	assert.True(t, seen.add("b"))
+	assert.True(t, seen.add("c"))
+
+	// the least recently seen is forgotten
+	assert.True(t, seen.add("a"))
+	assert.False(t, seen.add("c"))
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Survey asks active subscribers how satisfied they are with the digest,
+// once per Interval. Subscribers answer by replying with a number.
+func (w *Worker) Survey(ctx context.Context, now time.Time) error {
+	conf := w.config.Survey
+	interval, err := time.ParseDuration(conf.Interval)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("How satisfied are you with your nossence digest? Reply with a number from 1 (not at all) to %d (very).", conf.Scale)
+
+	limit := 10
+	for skip := 0; ; skip += limit {
+		subscribers, err := w.service.ListSubscribers(ctx, limit, skip)
+		if err != nil {
+			return err
+		}
+
+		for _, subscriber := range subscribers {
+			if !w.config.Sharding.Serves(subscriber.ShardKey) || !dueForSurvey(subscriber, interval, now) {
+				continue
+			}
+
+			if err := w.client.SendMessage(ctx, w.config.Bot.SK, subscriber.Pubkey, msg); err != nil {
+				logger.Warn("failed to send survey", "pubkey", subscriber.Pubkey, "err", err)
+				continue
+			}
+
+			logger.Info("sent satisfaction survey", "pubkey", subscriber.Pubkey)
+			if err := w.service.MarkSurveyed(ctx, subscriber.Pubkey, now); err != nil {
+				logger.Warn("failed to mark subscriber surveyed", "pubkey", subscriber.Pubkey, "err", err)
+			}
+		}
+
+		if len(subscribers) < limit {
+			break
+		}
+	}
+	return nil
+}
+
+func dueForSurvey(subscriber types.Subscriber, interval time.Duration, now time.Time) bool {
+	if subscriber.UnsubscribedAt != nil {
+		return false
+	}
+
+	// let new subscribers receive a few digests first
+	if subscriber.SubscribedAt != nil && now.Sub(*subscriber.SubscribedAt) < interval {
+		return false
+	}
+	return subscriber.LastSurveyedAt == nil || now.Sub(*subscriber.LastSurveyedAt) >= interval
+}
+
+// HandleSurveyReply records a rating a subscriber sent back by direct
+// message. Messages which are not a rating of a pending survey are ignored.
+func (b *Bot) HandleSurveyReply(ctx context.Context, ev nostr.Event) error {
+	if ok, err := ev.CheckSignature(); !ok {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	subscriber := b.service.GetSubscriber(ctx, ev.PubKey)
+	if subscriber == nil || subscriber.LastSurveyedAt == nil {
+		return nil
+	}
+
+	window, err := time.ParseDuration(b.config.Survey.AnswerWindow)
+	if err != nil {
+		return err
+	}
+	if ev.CreatedAt.Before(*subscriber.LastSurveyedAt) || ev.CreatedAt.Sub(*subscriber.LastSurveyedAt) > window {
+		return nil
+	}
+
+	msg, err := b.client.Decrypt(ctx, b.SK, ev.PubKey, ev.Content)
+	if err != nil {
+		return err
+	}
+
+	score := parseRating(msg, b.config.Survey.Scale)
+	if score == 0 {
+		logger.Info("direct message is not a survey rating", "pubkey", ev.PubKey, "id", ev.ID)
+		return nil
+	}
+
+	recorded, err := b.service.RecordSurveyResponse(ctx, ev.PubKey, score, ev.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if recorded {
+		logger.Info("recorded survey response", "pubkey", ev.PubKey, "score", score)
+	} else {
+		logger.Info("skip repeated survey response", "pubkey", ev.PubKey, "id", ev.ID)
+	}
+	return nil
+}
+
+// parseRating takes the first number of a reply as the rating, 0 if there
+// is none within 1 to scale
+func parseRating(msg string, scale int) int {
+	field := strings.FieldsFunc(msg, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	if len(field) == 0 {
+		return 0
+	}
+
+	score, err := strconv.Atoi(field[0])
+	if err != nil || score < 1 || score > scale {
+		return 0
+	}
+	return score
+}
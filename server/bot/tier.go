@@ -0,0 +1,60 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// HandleZap upgrades subscribers who zapped the bot at least the premium
+// price. Subsequent zaps extend the premium period. Only receipts signed by
+// the bot's zapper count, and each of them once however many relays send it.
+func (b *Bot) HandleZap(ctx context.Context, ev nostr.Event) error {
+	receipt, err := service.ParseZapReceipt(&ev)
+	if err != nil {
+		return err
+	}
+
+	conf := b.config.Tiers
+	if receipt.Recipient != b.pub || receipt.EventId != "" {
+		return nil
+	}
+	if receipt.Amount < conf.PremiumPrice {
+		logger.Info("zap below premium price", "sender", receipt.Sender, "amount", receipt.Amount)
+		return nil
+	}
+	if err := service.VerifyZapReceipt(&ev, conf.ZapperPubkey); err != nil {
+		logger.Warn("ignoring unverified zap receipt", "id", ev.ID, "sender", receipt.Sender, "err", err)
+		return nil
+	}
+	if first, err := b.service.MarkHandled(ctx, ev.ID); err != nil {
+		return err
+	} else if !first {
+		logger.Debug("zap receipt already handled", "id", ev.ID)
+		return nil
+	}
+
+	subscriber := b.service.GetSubscriber(ctx, receipt.Sender)
+	if subscriber == nil {
+		logger.Info("zap from non subscriber", "sender", receipt.Sender, "amount", receipt.Amount)
+		return nil
+	}
+
+	now := time.Now()
+	start := now
+	if subscriber.EffectiveTier(now) == types.TierPremium && subscriber.TierExpiresAt != nil {
+		start = *subscriber.TierExpiresAt
+	}
+	expiresAt := start.AddDate(0, 0, conf.PremiumDays)
+
+	err = b.service.GrantTier(ctx, receipt.Sender, types.TierPremium, &expiresAt)
+	if err != nil {
+		return err
+	}
+
+	msg := "Thank you #[0]! Your nossence premium is active until " + expiresAt.UTC().Format("2006-01-02") + "."
+	return b.client.Mention(ctx, b.SK, msg, []string{receipt.Sender})
+}
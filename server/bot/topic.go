@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// UpdateTopic reposts the top posts of the topic created within the window
+// of the channel, for channels on their own schedule
+func (w *Worker) UpdateTopic(ctx context.Context, channel types.TopicChannel, now time.Time) error {
+	window, err := time.ParseDuration(channel.Window)
+	if err != nil {
+		window = PushInterval
+	}
+	return w.updateTopic(ctx, channel, now, window)
+}
+
+// updateTopic reposts the top posts of the topic to its channel
+func (w *Worker) updateTopic(ctx context.Context, channel types.TopicChannel, end time.Time, window time.Duration) error {
+	size := channel.Size
+	if size <= 0 {
+		size = PushSize
+	}
+
+	start := end.Add(-window)
+	feed := w.service.GetFeedByTopic(ctx, channel.Topic, start, end, size)
+	if len(feed) == 0 {
+		logger.Info("got empty topic feed", "topic", channel.Topic)
+		return nil
+	}
+
+	channelPub, err := nostr.GetPublicKey(channel.SK)
+	if err != nil {
+		return err
+	}
+	reposted := w.repost(ctx, channel.SK, feed)
+	logger.Info("reposted topic feed", "topic", channel.Topic, "channelPub", channelPub, "size", len(reposted))
+
+	return w.service.RecordDigest(ctx, types.DigestMeta{
+		Channel:  channelPub,
+		PushedAt: time.Now(),
+		Start:    start,
+		End:      end,
+		Size:     len(reposted),
+	})
+}
+
+// publishTopicProfiles publishes the kind 0 profile of every topic channel,
+// so that they can be found and followed like the bot itself
+func (b *Bot) publishTopicProfiles(ctx context.Context) error {
+	metadata := b.config.Bot.Metadata
+	relays := b.recommendedRelayList(*b.config)
+	for _, channel := range b.config.Bot.TopicChannels {
+		name := channel.Name
+		if name == "" {
+			name = fmt.Sprintf("%s-%s", metadata.Name, channel.Topic)
+		}
+		about := channel.About
+		if about == "" {
+			about = fmt.Sprintf("Top posts tagged #%s, curated by %s", channel.Topic, metadata.Name)
+		}
+
+		logger.Info("Publish topic channel metadata", "topic", channel.Topic, "name", name)
+		if err := b.client.Metadata(ctx, channel.SK, name, about, channel.Picture, "", relays); err != nil {
+			return fmt.Errorf("topic %s: %w", channel.Topic, err)
+		}
+	}
+	return nil
+}
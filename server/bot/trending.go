@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// UpdateTrending reposts the posts rising fastest to the trending channel
+func (w *Worker) UpdateTrending(ctx context.Context, now time.Time) error {
+	conf := w.config.Bot.Trending
+	window, err := time.ParseDuration(conf.Window)
+	if err != nil {
+		return err
+	}
+
+	start := now.Add(-window)
+	feed := w.service.FilterReuse(types.OutputTrending, w.service.GetTrendingFeed(ctx, start, now, conf.Size))
+	if len(feed) == 0 {
+		logger.Warn("got empty trending feed", "window", window)
+		return nil
+	}
+
+	channelPub, err := nostr.GetPublicKey(conf.SK)
+	if err != nil {
+		return err
+	}
+	reposted := w.repost(ctx, conf.SK, feed)
+	logger.Info("reposted trending feed", "channelPub", channelPub, "size", len(reposted))
+
+	return w.service.RecordDigest(ctx, types.DigestMeta{
+		Channel:  channelPub,
+		PushedAt: time.Now(),
+		Start:    start,
+		End:      now,
+		Size:     len(reposted),
+	})
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// welcomeQueue holds the subscribers waiting to be onboarded. They're
+// onboarded one at a time by RunWelcomes, paced so that a burst of
+// subscriptions after a shout-out doesn't get the bot banned by relays.
+type welcomeQueue struct {
+	mu       sync.Mutex
+	pending  []string
+	queued   map[string]bool
+	attempts map[string]int
+	wake     chan struct{}
+}
+
+func newWelcomeQueue() *welcomeQueue {
+	return &welcomeQueue{
+		queued:   make(map[string]bool),
+		attempts: make(map[string]int),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (q *welcomeQueue) push(pubkey string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.queued[pubkey] {
+		return
+	}
+	q.queued[pubkey] = true
+	q.pending = append(q.pending, pubkey)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *welcomeQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return "", false
+	}
+	pubkey := q.pending[0]
+	q.pending = q.pending[1:]
+	return pubkey, true
+}
+
+// done takes the subscriber off the queue, or puts it back at the end to be
+// retried if onboarding failed fewer than maxAttempts times
+func (q *welcomeQueue) done(pubkey string, failed bool, maxAttempts int) (retry bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if failed {
+		q.attempts[pubkey]++
+		if q.attempts[pubkey] < maxAttempts {
+			q.pending = append(q.pending, pubkey)
+			return true
+		}
+	}
+	delete(q.queued, pubkey)
+	delete(q.attempts, pubkey)
+	return false
+}
+
+func (q *welcomeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// QueueWelcome queues a new or returning subscriber for onboarding
+func (b *Bot) QueueWelcome(ctx context.Context, pubkey string) error {
+	if err := b.service.QueueWelcome(ctx, pubkey, time.Now()); err != nil {
+		return err
+	}
+	b.welcomes.push(pubkey)
+	logger.Info("queued welcome message", "pubkey", pubkey, "pending", b.welcomes.len())
+	return nil
+}
+
+// RunWelcomes onboards queued subscribers until the context is done,
+// starting with those left pending by a previous run. Onboardings are spaced
+// by the configured interval, which backs off exponentially while they fail.
+func (b *Bot) RunWelcomes(ctx context.Context, onboard func(ctx context.Context, pubkey string) error) error {
+	conf := b.config.Bot.Welcome
+	interval, err := time.ParseDuration(conf.Interval)
+	if err != nil {
+		return err
+	}
+	maxBackoff, err := time.ParseDuration(conf.MaxBackoff)
+	if err != nil {
+		return err
+	}
+
+	pending, err := b.service.GetPendingWelcomes(ctx)
+	if err != nil {
+		logger.Error("failed to restore pending welcome messages", "err", err)
+	}
+	for _, pubkey := range pending {
+		b.welcomes.push(pubkey)
+	}
+	if len(pending) > 0 {
+		logger.Info("resuming pending welcome messages", "pending", len(pending))
+	}
+
+	backoff := interval
+	for {
+		pubkey, ok := b.welcomes.pop()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-b.welcomes.wake:
+				continue
+			}
+		}
+
+		err := onboard(ctx, pubkey)
+		if err == nil {
+			backoff = interval
+			if err := b.service.MarkWelcomed(ctx, pubkey, time.Now()); err != nil {
+				logger.Warn("failed to mark subscriber welcomed", "pubkey", pubkey, "err", err)
+			}
+		} else {
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if retry := b.welcomes.done(pubkey, err != nil, conf.MaxAttempts); err != nil {
+			logger.Warn("failed to welcome subscriber", "pubkey", pubkey, "retry", retry, "backoff", backoff, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
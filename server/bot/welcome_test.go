@@ -0,0 +1,85 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/nostr"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestWelcomeQueue(t *testing.T) {
+	q := newWelcomeQueue()
+	q.push("a")
+	q.push("b")
+	q.push("a")
+	assert.Equal(t, 2, q.len())
+
+	pubkey, _ := q.pop()
+	assert.Equal(t, "a", pubkey)
+	assert.True(t, q.done("a", true, 2))
+	pubkey, _ = q.pop()
+	assert.Equal(t, "b", pubkey)
+	assert.False(t, q.done("b", false, 2))
+	pubkey, _ = q.pop()
+	assert.Equal(t, "a", pubkey)
+	assert.False(t, q.done("a", true, 2))
+	_, ok := q.pop()
+	assert.False(t, ok)
+
+	// given up subscribers may be queued again
+	q.push("a")
+	assert.Equal(t, 1, q.len())
+}
+
+func TestRunWelcomes(t *testing.T) {
+	mockService := new(service.MockService)
+	mockService.On("GetPendingWelcomes", mock.Anything).Return([]string{"pending"}, nil)
+	mockService.On("QueueWelcome", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockService.On("MarkWelcomed", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	conf := *config
+	conf.Bot.Welcome = types.WelcomeConfig{Interval: "1ms", MaxBackoff: "4ms", MaxAttempts: 3}
+	bot, err := NewBot(context.Background(), new(nostr.MockClient), mockService, &conf)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	onboarded := []string{}
+	failures := 0
+	onboard := func(ctx context.Context, pubkey string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if pubkey == "flaky" && failures < 2 {
+			failures++
+			return errors.New("rate-limited")
+		}
+		onboarded = append(onboarded, pubkey)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, bot.RunWelcomes(ctx, onboard))
+		close(done)
+	}()
+	assert.NoError(t, bot.QueueWelcome(ctx, "flaky"))
+	assert.NoError(t, bot.QueueWelcome(ctx, "new"))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(onboarded) == 3
+	}, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.ElementsMatch(t, []string{"pending", "flaky", "new"}, onboarded)
+	mockService.AssertNumberOfCalls(t, "MarkWelcomed", 3)
+}
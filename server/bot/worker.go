@@ -2,98 +2,601 @@ package bot
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/enrich"
+	"github.com/dyng/nosdaily/metrics"
 	n "github.com/dyng/nosdaily/nostr"
 	"github.com/dyng/nosdaily/service"
 	"github.com/dyng/nosdaily/types"
-	"github.com/nbd-wtf/go-nostr"
 )
 
 type Worker struct {
-	config  *types.Config
-	client  n.IClient
-	service service.IService
+	config   *types.Config
+	client   n.IClient
+	service  service.IService
+	notifier *FeaturedNotifier
+	// nil unless enrichment is enabled
+	enricher *enrich.Pool
+
+	mu      sync.Mutex
+	lastEnd *time.Time
+	// closed to stop runs after their current batch
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
+// errRunStopped is returned by runs stopped before handling all subscribers
+var errRunStopped = errors.New("run stopped, it's resumed from its checkpoint")
+
 func NewWorker(ctx context.Context, client n.IClient, service service.IService, config *types.Config) (*Worker, error) {
 	return &Worker{
-		config:  config,
-		client:  client,
-		service: service,
+		config:   config,
+		client:   client,
+		service:  service,
+		notifier: NewFeaturedNotifier(client, service, config),
+		enricher: enrich.NewPool(ctx, config.Enrichment),
+		stopping: make(chan struct{}),
 	}, nil
 }
 
+// Stop makes the running and later runs stop after their current batch of
+// subscribers. Stopped runs are resumed from their checkpoint on restart.
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopping)
+	})
+}
+
 func (w *Worker) Run(ctx context.Context) error {
+	now := time.Now()
+	return w.RunAt(ctx, now, w.window(now))
+}
+
+// RunAt pushes digests covering the window ending at end. Progress is
+// checkpointed after each batch of subscribers, and a run checkpointed
+// before resumes after the last batch it handled.
+func (w *Worker) RunAt(ctx context.Context, end time.Time, window time.Duration) error {
+	ctx = correlation.Ensure(ctx)
+	logger := correlation.Logger(ctx, logger)
 	limit := 10
-	skip := 0
 	hasNext := true
-	var err error
 
-	err = w.UpdateMain(ctx)
-	if err != nil {
-		logger.Error("error occurs in main update", "err", err)
+	checkpoint := w.checkpoint(ctx, end, window)
+	if checkpoint.After != "" {
+		logger.Info("resuming run", "end", end, "after", checkpoint.After, "handled", checkpoint.Offset)
+	} else {
+		if err := w.updateMain(ctx, end, window); err != nil {
+			logger.Error("error occurs in main update", "err", err)
+		}
+
+		for _, channel := range w.config.Bot.TopicChannels {
+			// channels on their own schedule are updated by their cron job
+			if channel.Schedule != "" {
+				continue
+			}
+			if err := w.updateTopic(ctx, channel, end, window); err != nil {
+				logger.Error("error occurs in topic update", "topic", channel.Topic, "err", err)
+			}
+		}
 	}
 
-	for hasNext {
-		hasNext, err = w.Batch(ctx, limit, skip)
+	total := BatchSummary{}
+	for hasNext && !checkpoint.Done {
+		// subscribers are paged by pubkey, so that those subscribing or
+		// leaving meanwhile don't shift the pages
+		subscribers, err := w.service.ListSubscribersAfter(ctx, checkpoint.After, limit)
+		if err != nil {
+			logger.Error("failed to list subscribers", "err", err)
+			break
+		}
+		summary, err := w.pushPage(ctx, subscribers, limit, end, window, false)
 		if err != nil {
+			// the checkpoint is left at the failed batch
 			logger.Error("error occurs during batch execution", "err", err)
+			break
+		}
+		hasNext = summary.HasNext
+		total.Pushed += summary.Pushed
+		total.Skipped += summary.Skipped
+		total.Failed += summary.Failed
+
+		if len(subscribers) > 0 {
+			checkpoint.After = subscribers[len(subscribers)-1].Pubkey
+		}
+		checkpoint.Offset += len(subscribers)
+		checkpoint.Done = !hasNext
+		if err := w.service.SaveCheckpoint(ctx, checkpoint); err != nil {
+			logger.Warn("failed to save worker checkpoint", "after", checkpoint.After, "err", err)
+		}
+
+		select {
+		case <-w.stopping:
+			if hasNext {
+				logger.Info("run stopped", "end", end, "after", checkpoint.After, "pushed", total.Pushed)
+				return errRunStopped
+			}
+		default:
 		}
-		skip += limit
 	}
 
-	logger.Info("run finished")
+	w.mu.Lock()
+	w.lastEnd = &end
+	w.mu.Unlock()
+
+	logger.Info("run finished", "end", end, "window", window, "pushed", total.Pushed, "skipped", total.Skipped, "failed", total.Failed)
 	return nil
 }
 
+// checkpoint returns the checkpoint of the run ending at end, a new one
+// unless that run was checkpointed before
+func (w *Worker) checkpoint(ctx context.Context, end time.Time, window time.Duration) types.Checkpoint {
+	name := shardedName("worker", w.config.Sharding)
+	checkpoint, err := w.service.GetCheckpoint(ctx, name)
+	if err != nil {
+		logger.Warn("failed to get worker checkpoint", "err", err)
+	}
+	if checkpoint != nil && checkpoint.End.Unix() == end.Unix() {
+		return *checkpoint
+	}
+	return types.Checkpoint{Name: name, End: end, Window: window}
+}
+
+// Resume finishes the last run if it was interrupted, e.g. by a restart,
+// and the schedule hasn't fired since. Older runs are left to CatchUp.
+func (w *Worker) Resume(ctx context.Context, now time.Time) error {
+	checkpoint, err := w.service.GetCheckpoint(ctx, shardedName("worker", w.config.Sharding))
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil || checkpoint.Done || checkpoint.End.Before(lastActivation(w.schedule(), now)) {
+		return nil
+	}
+	return w.RunAt(ctx, checkpoint.End, checkpoint.Window)
+}
+
+func (w *Worker) lastRunEnd() *time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastEnd
+}
+
 func (w *Worker) UpdateMain(ctx context.Context) error {
+	return w.updateMain(ctx, time.Now(), PushInterval)
+}
+
+func (w *Worker) updateMain(ctx context.Context, end time.Time, window time.Duration) error {
+	logger := correlation.Logger(ctx, logger)
 	logger.Info("updating main channel")
 	mainSK := w.config.Bot.SK
-	return w.Push(ctx, "", mainSK, PushInterval, PushSize)
+	feed, pushed, err := w.push(ctx, "", mainSK, "", end, window, PushSize)
+	if err != nil {
+		return err
+	}
+	if !pushed {
+		// an empty digest is recorded still, so that CatchUp doesn't run the
+		// same window again
+		mainPub, _ := n.PublicKey(mainSK)
+		return w.service.RecordDigest(ctx, types.DigestMeta{
+			Channel:  mainPub,
+			PushedAt: time.Now(),
+			Start:    end.Add(-window),
+			End:      end,
+		})
+	}
+
+	w.notifier.NotifyFeatured(ctx, feed)
+	return nil
 }
 
-func (w *Worker) Batch(ctx context.Context, limit, skip int) (hasNext bool, err error) {
-	logger.Info("running batch", "limit", limit, "skip", skip)
+// BatchSummary counts the outcome of a batch of subscribers
+type BatchSummary struct {
+	Pushed  int `json:"pushed"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// errors by pubkey of the subscribers that failed
+	Failures map[string]string `json:"failures,omitempty"`
+	HasNext  bool              `json:"has_next"`
+	// ids of the posts of the digest computed for each subscriber in a dry
+	// run, where Pushed counts the digests that would have been pushed
+	Digests map[string][]string `json:"digests,omitempty"`
+}
+
+// Batch pushes digests to a page of subscribers. A dry run computes and logs
+// the digests instead, without publishing or recording anything.
+func (w *Worker) Batch(ctx context.Context, limit, skip int, dryRun bool) (*BatchSummary, error) {
+	return w.batch(ctx, limit, skip, time.Now(), PushInterval, dryRun)
+}
+
+// batch pushes digests to a page of subscribers on a pool of Digest.Workers
+// goroutines. Digests are started at most one per Digest.Pace across the
+// pool, so that relays don't rate limit the bot, and a subscriber failing
+// doesn't affect the others.
+func (w *Worker) batch(ctx context.Context, limit, skip int, now time.Time, window time.Duration, dryRun bool) (*BatchSummary, error) {
+	correlation.Logger(ctx, logger).Info("running batch", "limit", limit, "skip", skip, "dryRun", dryRun)
 	subscribers, err := w.service.ListSubscribers(ctx, limit, skip)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	return w.pushPage(ctx, subscribers, limit, now, window, dryRun)
+}
+
+// pushPage pushes digests to a page of at most limit subscribers, see batch
+func (w *Worker) pushPage(ctx context.Context, subscribers []types.Subscriber, limit int, now time.Time, window time.Duration, dryRun bool) (*BatchSummary, error) {
+	logger := correlation.Logger(ctx, logger)
+	summary := &BatchSummary{Failures: map[string]string{}, HasNext: len(subscribers) >= limit}
+	if dryRun {
+		summary.Digests = map[string][]string{}
+	}
+	var mu sync.Mutex
+	record := func(pubkey string, pushed bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			summary.Failed++
+			summary.Failures[pubkey] = err.Error()
+		case pushed:
+			summary.Pushed++
+		default:
+			summary.Skipped++
+		}
+	}
+
+	workers := w.config.Digest.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var pace <-chan time.Time
+	if gap, err := time.ParseDuration(w.config.Digest.Pace); err == nil && gap > 0 {
+		ticker := time.NewTicker(gap)
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	jobs := make(chan types.Subscriber)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for subscriber := range jobs {
+				if dryRun {
+					feed, err := w.preview(ctx, subscriber, now, window)
+					if err != nil {
+						logger.Warn("failed to preview digest for subscriber", "pubkey", subscriber.Pubkey, "err", err)
+					} else {
+						mu.Lock()
+						summary.Digests[subscriber.Pubkey] = entryIds(feed)
+						mu.Unlock()
+					}
+					record(subscriber.Pubkey, err == nil && len(feed) > 0, err)
+					continue
+				}
+
+				pushed, err := w.pushSubscriber(ctx, subscriber, now, window)
+				if err != nil {
+					logger.Warn("failed to run worker for subscriber", "pubkey", subscriber.Pubkey, "err", err)
+				}
+				record(subscriber.Pubkey, pushed, err)
+			}
+		}()
 	}
 
+dispatch:
 	for _, subscriber := range subscribers {
-		if subscriber.UnsubscribedAt != nil {
-			logger.Info("skipping non subscriber", "pubkey", subscriber.Pubkey)
+		if !w.dueSubscriber(subscriber, now) {
+			record(subscriber.Pubkey, false, nil)
 			continue
 		}
-		err = w.Push(ctx, subscriber.Pubkey, subscriber.ChannelSecret, PushInterval, PushSize)
-		if err != nil {
-			logger.Warn("failed to run worker for subscriber", "pubkey", subscriber.Pubkey, "err", err)
+		if pace != nil {
+			select {
+			case <-pace:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		select {
+		case jobs <- subscriber:
+		case <-ctx.Done():
+			break dispatch
 		}
 	}
+	close(jobs)
+	wg.Wait()
+
+	if !dryRun {
+		metrics.NewCounter("digests/pushed").Inc(int64(summary.Pushed))
+		metrics.NewCounter("digests/failed").Inc(int64(summary.Failed))
+	}
+	logger.Info("batch finished", "subscribers", len(subscribers), "pushed", summary.Pushed, "skipped", summary.Skipped, "failed", summary.Failed)
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// dueSubscriber tells whether a digest is to be pushed to the subscriber
+func (w *Worker) dueSubscriber(subscriber types.Subscriber, now time.Time) bool {
+	if subscriber.UnsubscribedAt != nil {
+		logger.Info("skipping non subscriber", "pubkey", subscriber.Pubkey)
+		return false
+	}
+
+	if !w.config.Sharding.Serves(subscriber.ShardKey) {
+		return false
+	}
 
-	return len(subscribers) >= limit, nil
+	tier := w.subscriberTier(subscriber, now)
+	if !dueForPush(subscriber, tier, now) {
+		logger.Debug("skipping subscriber not due for digest", "pubkey", subscriber.Pubkey, "tier", subscriber.Tier, "lastPushedAt", subscriber.LastPushedAt)
+		return false
+	}
+	return true
+}
+
+func (w *Worker) subscriberTier(subscriber types.Subscriber, now time.Time) types.TierConfig {
+	tier := w.config.Tiers.Of(&subscriber, now)
+	if w.config.Churn.Enabled {
+		tier = slowedTier(w.config.Churn, subscriber, tier)
+	}
+	return tier
+}
+
+// pushSubscriber pushes the digest of a subscriber due for it, and tells if
+// there was one to push. A panic is returned as an error so that it doesn't
+// take down the other subscribers.
+func (w *Worker) pushSubscriber(ctx context.Context, subscriber types.Subscriber, now time.Time, window time.Duration) (pushed bool, err error) {
+	logger := correlation.Logger(ctx, logger)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	tier := w.subscriberTier(subscriber, now)
+	feedPub := feedPubkey(subscriber, tier)
+	if feedPub != "" {
+		if err := w.service.InferInterests(ctx, subscriber.Pubkey); err != nil {
+			logger.Warn("failed to infer interests", "pubkey", subscriber.Pubkey, "err", err)
+		}
+	}
+
+	recipient := ""
+	if subscriber.PrivateDigest || subscriber.HandedOverAt != nil {
+		recipient = subscriber.Pubkey
+	}
+
+	channelSK := channelKey(w.config, subscriber)
+	feed, pushed, err := w.push(w.subscriberRelays(ctx, subscriber.Pubkey), feedPub, channelSK, recipient, now, window, tier.DigestSize)
+	w.recordReceipt(ctx, subscriber.Pubkey, channelSK, now, feed, err)
+	if err != nil || !pushed {
+		// not marked as pushed, so that it's retried on the next tick
+		return false, err
+	}
+
+	err = w.service.RecordDeliveries(ctx, subscriber.Pubkey, feed, now)
+	if err != nil {
+		logger.Warn("failed to record deliveries", "pubkey", subscriber.Pubkey, "err", err)
+	}
+
+	err = w.service.MarkPushed(ctx, subscriber.Pubkey, now)
+	if err != nil {
+		logger.Warn("failed to mark subscriber as pushed", "pubkey", subscriber.Pubkey, "err", err)
+	}
+	return true, nil
+}
+
+// preview computes and logs the digest of a subscriber due for it, without
+// publishing or recording anything. Interests aren't inferred again, so the
+// digest is ranked by those inferred by the last run.
+func (w *Worker) preview(ctx context.Context, subscriber types.Subscriber, now time.Time, window time.Duration) (feed []types.FeedEntry, err error) {
+	logger := correlation.Logger(ctx, logger)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	tier := w.subscriberTier(subscriber, now)
+	feed, window = w.widenedFeed(ctx, feedPubkey(subscriber, tier), now, window, tier.DigestSize)
+	for i, entry := range feed {
+		logger.Info("dry run digest entry", "pubkey", subscriber.Pubkey, "rank", i+1, "id", entry.Id, "author", entry.Pubkey, "score", entry.Score)
+	}
+	logger.Info("dry run digest", "pubkey", subscriber.Pubkey, "tier", subscriber.Tier, "window", window, "size", len(feed))
+	return feed, nil
+}
+
+// feedPubkey is the subscriber the feed is personalized for, empty for the
+// global feed if personalization is not part of the tier
+func feedPubkey(subscriber types.Subscriber, tier types.TierConfig) string {
+	if tier.PersonalizationDepth == 0 {
+		return ""
+	}
+	return subscriber.Pubkey
+}
+
+func entryIds(feed []types.FeedEntry) []string {
+	ids := make([]string, 0, len(feed))
+	for _, entry := range feed {
+		ids = append(ids, entry.Id)
+	}
+	return ids
+}
+
+// recordReceipt records whether the digest pushed in the run ending at end
+// was accepted by a relay, along with the correlation ID of the run. Empty
+// digests publish nothing and get no receipt.
+func (w *Worker) recordReceipt(ctx context.Context, subscriberPub, channelSK string, end time.Time, feed []types.FeedEntry, err error) {
+	if err == nil && len(feed) == 0 {
+		return
+	}
+	logger := correlation.Logger(ctx, logger)
+	channelPub, _ := n.PublicKey(channelSK)
+	receipt := types.DeliveryReceipt{
+		Subscriber: subscriberPub,
+		Channel:    channelPub,
+		Run:        end,
+		Accepted:   err == nil,
+		At:         time.Now(),
+	}
+	receipt.CorrelationID = correlation.ID(ctx)
+	if err != nil {
+		receipt.Error = err.Error()
+		metrics.NewCounter("digests/unaccepted").Inc(1)
+	}
+	if err := w.service.RecordReceipt(ctx, receipt); err != nil {
+		logger.Warn("failed to record delivery receipt", "pubkey", subscriberPub, "err", err)
+	}
 }
 
 func (w *Worker) Push(ctx context.Context, subscriberPub, channelSK string, timeRange time.Duration, limit int) error {
-	start := time.Now().Add(-1 * timeRange)
-	end := time.Now()
-	logger.Debug("start to repost feed", "userPub", subscriberPub, "start", start, "end", end, "limit", limit)
-	feed := w.service.GetFeed(subscriberPub, start, end, limit)
+	recipient := ""
+	if subscriber := w.service.GetSubscriber(ctx, subscriberPub); subscriber != nil {
+		if subscriber.PrivateDigest || subscriber.HandedOverAt != nil {
+			recipient = subscriberPub
+		}
+		// handed over channels are no longer posted to
+		channelSK = channelKey(w.config, *subscriber)
+	}
+
+	_, _, err := w.push(w.subscriberRelays(ctx, subscriberPub), subscriberPub, channelSK, recipient, time.Now(), timeRange, limit)
+	return err
+}
+
+// push reposts the feed to the channel, or sends it privately to the
+// recipient if given, and returns the delivered entries. Nothing is pushed
+// for an empty feed, which isn't an error.
+func (w *Worker) push(ctx context.Context, subscriberPub, channelSK, recipient string, end time.Time, timeRange time.Duration, limit int) (reposted []types.FeedEntry, pushed bool, err error) {
+	logger := correlation.Logger(ctx, logger)
+	feed, window := w.widenedFeed(ctx, subscriberPub, end, timeRange, limit)
+	start := end.Add(-window)
 	if len(feed) == 0 {
-		logger.Warn("got empty feed", "subscriberPub", subscriberPub)
-		return nil
+		logger.Warn("got empty feed", "subscriberPub", subscriberPub, "window", window)
+		return nil, false, nil
+	}
+
+	channelPub, _ := n.PublicKey(channelSK)
+	// private digests can't be rated publicly
+	feedbackNote := ""
+	if recipient != "" {
+		var annotations map[string][]enrich.Annotation
+		if w.enricher != nil {
+			annotations = w.enricher.Annotate(ctx, feed)
+		}
+		if err := w.client.GiftWrap(ctx, channelSK, recipient, renderPrivateDigest(feed, annotations)); err != nil {
+			return nil, false, err
+		}
+		reposted = feed
+		logger.Info("sent private digest", "recipient", recipient, "channelPub", channelPub, "window", window, "size", len(reposted))
+	} else {
+		reposted = w.repost(ctx, channelSK, feed)
+		if len(reposted) == 0 {
+			return nil, false, fmt.Errorf("no repost of the digest was accepted by a relay")
+		}
+		logger.Info("reposted feed", "subscriberPub", subscriberPub, "channelPub", channelPub, "window", window, "size", len(reposted))
+		feedbackNote = w.askFeedback(ctx, channelSK)
+	}
+
+	err = w.service.RecordDigest(ctx, types.DigestMeta{
+		Channel:      channelPub,
+		Subscriber:   subscriberPub,
+		PushedAt:     time.Now(),
+		Start:        start,
+		End:          end,
+		Widened:      window > timeRange,
+		Size:         len(reposted),
+		FeedbackNote: feedbackNote,
+	})
+	if err != nil {
+		logger.Warn("failed to record digest", "channelPub", channelPub, "err", err)
 	}
 
-	var eventIds []string
-	channelPub, _ := nostr.GetPublicKey(channelSK)
+	return reposted, true, nil
+}
+
+// subscriberRelays prefers reposting to the relays the subscriber reads
+// from, where they pick up their channel
+func (w *Worker) subscriberRelays(ctx context.Context, subscriberPub string) context.Context {
+	relays, err := w.service.GetReadRelays(ctx, subscriberPub)
+	if err != nil {
+		correlation.Logger(ctx, logger).Warn("failed to get read relays", "pubkey", subscriberPub, "err", err)
+		return ctx
+	}
+	if len(relays) == 0 {
+		return ctx
+	}
+	return n.WithPreferredRelays(ctx, relays)
+}
+
+// repost reposts the feed to the channel and returns the reposted entries
+func (w *Worker) repost(ctx context.Context, channelSK string, feed []types.FeedEntry) []types.FeedEntry {
+	logger := correlation.Logger(ctx, logger)
+	var reposted []types.FeedEntry
+	channelPub, _ := n.PublicKey(channelSK)
 	for _, post := range feed {
 		err := w.client.Repost(ctx, channelSK, post.Id, post.Pubkey, post.Raw)
 		if err != nil {
 			logger.Warn("failed to repost event", "channelPub", channelPub, "id", post.Id, "err", err)
+			continue
 		}
-		eventIds = append(eventIds, post.Id)
+		reposted = append(reposted, post)
 	}
+	return reposted
+}
 
-	logger.Info("reposted feed", "subscriberPub", subscriberPub, "channelPub", channelPub, "eventIds", eventIds)
-	return nil
+// widenedFeed doubles the window until it yields enough candidates or
+// reaches the configured maximum, and returns the window eventually used
+func (w *Worker) widenedFeed(ctx context.Context, subscriberPub string, end time.Time, window time.Duration, limit int) ([]types.FeedEntry, time.Duration) {
+	logger := correlation.Logger(ctx, logger)
+	minCandidates := w.config.Digest.MinCandidates
+	if minCandidates <= 0 || minCandidates > limit {
+		minCandidates = limit
+	}
+
+	maxWindow, err := time.ParseDuration(w.config.Digest.MaxWindow)
+	if err != nil {
+		maxWindow = window
+	}
+
+	for {
+		start := end.Add(-window)
+		logger.Debug("start to repost feed", "userPub", subscriberPub, "start", start, "end", end, "limit", limit)
+		feed := w.service.QueryFeed(ctx, types.FeedQuery{
+			Subscriber:   subscriberPub,
+			Start:        start,
+			End:          end,
+			Limit:        limit,
+			MaxPerAuthor: w.config.Digest.MaxPerAuthor,
+			Output:       types.OutputDigest,
+		})
+		if len(feed) >= minCandidates || window >= maxWindow {
+			return feed, window
+		}
+
+		window *= 2
+		if window > maxWindow {
+			window = maxWindow
+		}
+		logger.Info("widening window for lack of candidates", "subscriberPub", subscriberPub, "candidates", len(feed), "window", window)
+	}
+}
+
+// dueForPush checks the digest frequency limit of the subscriber's tier
+func dueForPush(subscriber types.Subscriber, tier types.TierConfig, now time.Time) bool {
+	if subscriber.LastPushedAt == nil {
+		return true
+	}
+
+	interval, err := time.ParseDuration(tier.MinInterval)
+	if err != nil {
+		return true
+	}
+
+	// tolerate cron jitter so that an hourly limit doesn't skip every other run
+	return now.Sub(*subscriber.LastPushedAt) >= interval-time.Minute
 }
@@ -2,9 +2,12 @@ package bot
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dyng/nosdaily/correlation"
 	"github.com/dyng/nosdaily/nostr"
 	"github.com/dyng/nosdaily/service"
 	"github.com/dyng/nosdaily/types"
@@ -12,12 +15,22 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+// servedShard serves the subscribers of shard key 0, but not of otherShard
+var servedShard = types.ShardingConfig{Shards: 2, Serve: []int{types.ShardOf(0, 2)}}
+var otherShard = func() uint64 {
+	key := uint64(1)
+	for servedShard.Serves(key) {
+		key++
+	}
+	return key
+}()
+
 func TestWorkerRun(t *testing.T) {
 	mockClient := new(nostr.MockClient)
 	mockClient.On("Repost", context.Background(), "channel_secret", "event_id", "author_pub", "raw_event").Return(nil)
 
 	mockService := new(service.MockService)
-	mockService.On("GetFeed").Return([]types.FeedEntry{
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
 		{
 			Id:     "event_id",
 			Pubkey: "author_pub",
@@ -25,10 +38,485 @@ func TestWorkerRun(t *testing.T) {
 		},
 	})
 
-	worker, err := NewWorker(context.Background(), mockClient, mockService, nil)
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+	mockService.On("GetSubscriber", mock.Anything, "subscriber_pub").Return((*types.Subscriber)(nil))
+
+	worker, err := NewWorker(context.Background(), mockClient, mockService, config)
 	assert.NoError(t, err)
 
 	worker.Push(context.Background(), "subscriber_pub", "channel_secret", time.Hour, 10)
-	mockService.AssertCalled(t, "GetFeed", "subscriber_pub", mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time"), 10)
+	mockService.AssertCalled(t, "QueryFeed", mock.Anything, mock.MatchedBy(func(q types.FeedQuery) bool {
+		return q.Subscriber == "subscriber_pub" && q.Limit == 10
+	}))
 	mockClient.AssertCalled(t, "Repost", context.Background(), "channel_secret", "event_id", "author_pub", "raw_event")
 }
+
+func TestDigestFeedback(t *testing.T) {
+	mockClient := new(nostr.MockClient)
+	mockClient.On("Repost", mock.Anything, "channel_secret", "event_id", "author_pub", "raw_event").Return(nil)
+	mockClient.On("Note", mock.Anything, "channel_secret", mock.Anything).Return("note_id", nil)
+
+	mockService := new(service.MockService)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
+		{Id: "event_id", Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+	mockService.On("GetSubscriber", mock.Anything, "subscriber_pub").Return((*types.Subscriber)(nil))
+
+	conf := *config
+	conf.Digest.Feedback = types.DigestFeedbackConfig{Enabled: true, Scale: 5}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	// the digest ends with a note asking to rate it
+	assert.NoError(t, worker.Push(context.Background(), "subscriber_pub", "channel_secret", time.Hour, 10))
+	mockClient.AssertCalled(t, "Note", mock.Anything, "channel_secret", mock.MatchedBy(func(msg string) bool {
+		return strings.Contains(msg, "from 1 (not useful) to 5")
+	}))
+	mockService.AssertCalled(t, "RecordDigest", mock.Anything, mock.MatchedBy(func(d types.DigestMeta) bool {
+		return d.FeedbackNote == "note_id"
+	}))
+}
+
+func TestNotifyFeatured(t *testing.T) {
+	mockClient := new(nostr.MockClient)
+	mockClient.On("Mention", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("IsNotificationOptedOut", mock.Anything, "opted_out_author").Return(true, nil)
+	mockService.On("IsNotificationOptedOut", mock.Anything, mock.Anything).Return(false, nil)
+
+	conf := *config
+	conf.Bot.Notify = types.NotifyConfig{Enabled: true, Mode: "mention", MaxPerHour: 1}
+	notifier := NewFeaturedNotifier(mockClient, mockService, &conf)
+
+	eventId := "c8436ce1b543ae7c9cabe2da4666cf566410c36d48886d732d2e19165130c652"
+	notifier.NotifyFeatured(context.Background(), []types.FeedEntry{
+		{Id: eventId, Pubkey: "opted_out_author"},
+		{Id: eventId, Pubkey: "author_a"},
+		{Id: eventId, Pubkey: "author_b"},
+	})
+
+	// opted-out author is skipped, and the hourly budget allows only one notification
+	mockClient.AssertNumberOfCalls(t, "Mention", 1)
+	mockClient.AssertCalled(t, "Mention", mock.Anything, botSK, mock.Anything, []string{"author_a"})
+}
+
+func TestWidenedFeed(t *testing.T) {
+	mockService := new(service.MockService)
+	mockService.On("QueryFeed", mock.Anything, mock.MatchedBy(func(q types.FeedQuery) bool { return q.Subscriber == "" && q.Limit == 5 })).Return([]types.FeedEntry{{Id: "a"}}).Times(2)
+	mockService.On("QueryFeed", mock.Anything, mock.MatchedBy(func(q types.FeedQuery) bool { return q.Subscriber == "" && q.Limit == 5 })).Return([]types.FeedEntry{{Id: "a"}, {Id: "b"}})
+
+	conf := *config
+	conf.Digest = types.DigestConfig{MinCandidates: 2, MaxWindow: "8h"}
+	worker, err := NewWorker(context.Background(), new(nostr.MockClient), mockService, &conf)
+	assert.NoError(t, err)
+
+	feed, window := worker.widenedFeed(context.Background(), "", time.Now(), time.Hour, 5)
+	assert.Len(t, feed, 2)
+	assert.Equal(t, 4*time.Hour, window)
+
+	// the window stops growing at the maximum
+	conf.Digest.MinCandidates = 3
+	_, window = worker.widenedFeed(context.Background(), "", time.Now(), time.Hour, 5)
+	assert.Equal(t, 8*time.Hour, window)
+}
+
+func TestDueForPush(t *testing.T) {
+	now := time.Now()
+	tier := types.TierConfig{MinInterval: "24h"}
+
+	assert.True(t, dueForPush(types.Subscriber{}, tier, now))
+
+	lastHour := now.Add(-time.Hour)
+	assert.False(t, dueForPush(types.Subscriber{LastPushedAt: &lastHour}, tier, now))
+
+	yesterday := now.Add(-24 * time.Hour)
+	assert.True(t, dueForPush(types.Subscriber{LastPushedAt: &yesterday}, tier, now))
+}
+
+func TestNudge(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	recently := now.AddDate(0, 0, -1)
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000001"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("SendMessage", mock.Anything, botSK, mock.Anything, mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "following", ChannelSecret: channelSK, SubscribedAt: &joined},
+		{Pubkey: "forgetful", ChannelSecret: channelSK, SubscribedAt: &joined, FollowReminders: 1},
+		{Pubkey: "reminded", ChannelSecret: channelSK, SubscribedAt: &joined, LastRemindedAt: &recently},
+		{Pubkey: "capped", ChannelSecret: channelSK, SubscribedAt: &joined, FollowReminders: 3},
+		{Pubkey: "new", ChannelSecret: channelSK, SubscribedAt: &recently},
+		{Pubkey: "unknown", ChannelSecret: channelSK, SubscribedAt: &joined},
+		{Pubkey: "elsewhere", ChannelSecret: channelSK, SubscribedAt: &joined, ShardKey: otherShard},
+	}, nil)
+	mockService.On("FollowsChannel", mock.Anything, "following", mock.Anything).Return(true, nil)
+	mockService.On("FollowsChannel", mock.Anything, "unknown", mock.Anything).Return(false, service.ErrContactsUnknown)
+	mockService.On("FollowsChannel", mock.Anything, "forgetful", mock.Anything).Return(false, nil)
+	mockService.On("MarkReminded", mock.Anything, "forgetful", now).Return(nil)
+
+	conf := *config
+	conf.Sharding = servedShard
+	conf.Nudge = types.NudgeConfig{Enabled: true, Interval: "168h", MaxReminders: 3}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	assert.NoError(t, worker.Nudge(context.Background(), now))
+	mockClient.AssertNumberOfCalls(t, "SendMessage", 1)
+	mockClient.AssertCalled(t, "SendMessage", mock.Anything, botSK, "forgetful", mock.Anything)
+	mockService.AssertCalled(t, "MarkReminded", mock.Anything, "forgetful", now)
+}
+
+func TestReengage(t *testing.T) {
+	now := time.Now()
+	recently := now.AddDate(0, 0, -1)
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("SendMessage", mock.Anything, botSK, mock.Anything, mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("UpdateChurnRisk", mock.Anything, now).Return(4, nil)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "active", ChurnRisk: 0.1},
+		{Pubkey: "idle", ChurnRisk: 0.9},
+		{Pubkey: "reengaged", ChurnRisk: 0.9, LastReengagedAt: &recently},
+		{Pubkey: "gone", ChurnRisk: 1, UnsubscribedAt: &recently},
+		{Pubkey: "elsewhere", ChurnRisk: 0.9, ShardKey: otherShard},
+	}, nil)
+	mockService.On("MarkReengaged", mock.Anything, "idle", now).Return(nil)
+
+	conf := *config
+	conf.Sharding = servedShard
+	conf.Churn = types.ChurnConfig{Enabled: true, ReengageRisk: 0.8, ReengageInterval: "720h"}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	assert.NoError(t, worker.Reengage(context.Background(), now))
+	mockClient.AssertNumberOfCalls(t, "SendMessage", 1)
+	mockClient.AssertCalled(t, "SendMessage", mock.Anything, botSK, "idle", mock.Anything)
+	mockService.AssertCalled(t, "MarkReengaged", mock.Anything, "idle", now)
+}
+
+func TestSlowedTier(t *testing.T) {
+	conf := types.ChurnConfig{SlowdownRisk: 0.5, SlowdownFactor: 2}
+	tier := types.TierConfig{MinInterval: "24h", DigestSize: 10}
+
+	assert.Equal(t, tier, slowedTier(conf, types.Subscriber{ChurnRisk: 0.2}, tier))
+
+	slowed := slowedTier(conf, types.Subscriber{ChurnRisk: 0.6}, tier)
+	assert.Equal(t, "48h0m0s", slowed.MinInterval)
+	assert.Equal(t, 10, slowed.DigestSize)
+
+	// tiers without limit stay so
+	assert.Equal(t, "", slowedTier(conf, types.Subscriber{ChurnRisk: 0.6}, types.TierConfig{}).MinInterval)
+}
+
+func TestUpdateTrending(t *testing.T) {
+	now := time.Now()
+	trendingSK := "0000000000000000000000000000000000000000000000000000000000000002"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("Repost", mock.Anything, trendingSK, "event_id", "author_pub", "raw_event").Return(nil)
+
+	mockService := new(service.MockService)
+	trending := []types.FeedEntry{
+		{Id: "event_id", Pubkey: "author_pub", Raw: "raw_event"},
+	}
+	mockService.On("GetTrendingFeed", mock.Anything, now.Add(-6*time.Hour), now, 10).Return(trending)
+	mockService.On("FilterReuse", types.OutputTrending, trending).Return(trending)
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+
+	conf := *config
+	conf.Bot.Trending = types.TrendingConfig{Enabled: true, SK: trendingSK, Window: "6h", Size: 10}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	assert.NoError(t, worker.UpdateTrending(context.Background(), now))
+	mockClient.AssertNumberOfCalls(t, "Repost", 1)
+	mockService.AssertCalled(t, "RecordDigest", mock.Anything, mock.MatchedBy(func(d types.DigestMeta) bool {
+		return d.Subscriber == "" && d.Size == 1
+	}))
+}
+
+func TestUpdateTopic(t *testing.T) {
+	end := time.Now()
+	topicSK := "0000000000000000000000000000000000000000000000000000000000000003"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("Repost", mock.Anything, topicSK, "event_id", "author_pub", "raw_event").Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("GetFeedByTopic", mock.Anything, "bitcoin", end.Add(-time.Hour), end, PushSize).Return([]types.FeedEntry{
+		{Id: "event_id", Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+
+	worker, err := NewWorker(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	err = worker.updateTopic(context.Background(), types.TopicChannel{Topic: "bitcoin", SK: topicSK}, end, time.Hour)
+	assert.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "Repost", 1)
+
+	// channels on their own schedule rank their own window and size
+	mockService.On("GetFeedByTopic", mock.Anything, "bitcoin", end.Add(-6*time.Hour), end, 3).Return([]types.FeedEntry{
+		{Id: "event_id", Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	err = worker.UpdateTopic(context.Background(), types.TopicChannel{Topic: "bitcoin", SK: topicSK, Schedule: "0 8 * * *", Window: "6h", Size: 3}, end)
+	assert.NoError(t, err)
+	mockClient.AssertNumberOfCalls(t, "Repost", 2)
+}
+
+func TestSurvey(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -60)
+	recently := now.AddDate(0, 0, -1)
+	left := now.AddDate(0, 0, -2)
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("SendMessage", mock.Anything, botSK, mock.Anything, mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "due", SubscribedAt: &joined},
+		{Pubkey: "surveyed", SubscribedAt: &joined, LastSurveyedAt: &recently},
+		{Pubkey: "new", SubscribedAt: &recently},
+		{Pubkey: "gone", SubscribedAt: &joined, UnsubscribedAt: &left},
+		{Pubkey: "elsewhere", SubscribedAt: &joined, ShardKey: otherShard},
+	}, nil)
+	mockService.On("MarkSurveyed", mock.Anything, "due", now).Return(nil)
+
+	conf := *config
+	conf.Sharding = servedShard
+	conf.Survey = types.SurveyConfig{Enabled: true, Interval: "720h", Scale: 5}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	assert.NoError(t, worker.Survey(context.Background(), now))
+	mockClient.AssertNumberOfCalls(t, "SendMessage", 1)
+	mockClient.AssertCalled(t, "SendMessage", mock.Anything, botSK, "due", mock.Anything)
+	mockService.AssertCalled(t, "MarkSurveyed", mock.Anything, "due", now)
+}
+
+func TestPrivateDigest(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000001"
+	eventId := "5c83da77af1dec6d7289834998ad7aafbd9e2191396d75ec3cc27f5a77226f36"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("GiftWrap", mock.Anything, channelSK, "private", mock.Anything).Return(nil)
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "private", ChannelSecret: channelSK, SubscribedAt: &joined, PrivateDigest: true},
+	}, nil)
+	mockService.On("InferInterests", mock.Anything, "private").Return(nil)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
+		{Id: eventId, Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+	mockService.On("RecordDeliveries", mock.Anything, "private", mock.Anything, now).Return(nil)
+	mockService.On("MarkPushed", mock.Anything, "private", now).Return(nil)
+	mockService.On("RecordReceipt", mock.Anything, mock.Anything).Return(nil)
+
+	worker, err := NewWorker(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	_, err = worker.batch(context.Background(), 10, 0, now, time.Hour, false)
+	assert.NoError(t, err)
+	mockClient.AssertNotCalled(t, "Repost", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertCalled(t, "GiftWrap", mock.Anything, channelSK, "private", mock.MatchedBy(func(msg string) bool {
+		return strings.Contains(msg, "nostr:note1")
+	}))
+	mockService.AssertCalled(t, "RecordDeliveries", mock.Anything, "private", mock.Anything, now)
+}
+
+func TestBatchPool(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	left := now.AddDate(0, 0, -1)
+	okSK := "0000000000000000000000000000000000000000000000000000000000000001"
+	failingSK := "0000000000000000000000000000000000000000000000000000000000000002"
+	eventId := "5c83da77af1dec6d7289834998ad7aafbd9e2191396d75ec3cc27f5a77226f36"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("GiftWrap", mock.Anything, okSK, mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("GiftWrap", mock.Anything, failingSK, mock.Anything, mock.Anything).Return(errors.New("relay down"))
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "a", ChannelSecret: okSK, SubscribedAt: &joined, PrivateDigest: true},
+		{Pubkey: "b", ChannelSecret: okSK, SubscribedAt: &joined, PrivateDigest: true},
+		{Pubkey: "failing", ChannelSecret: failingSK, SubscribedAt: &joined, PrivateDigest: true},
+		{Pubkey: "gone", ChannelSecret: okSK, SubscribedAt: &joined, UnsubscribedAt: &left},
+	}, nil)
+	mockService.On("InferInterests", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
+		{Id: eventId, Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	mockService.On("RecordDigest", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+	mockService.On("RecordDeliveries", mock.Anything, mock.Anything, mock.Anything, now).Return(nil)
+	mockService.On("MarkPushed", mock.Anything, mock.Anything, now).Return(nil)
+	mockService.On("RecordReceipt", mock.Anything, mock.Anything).Return(nil)
+
+	conf := *config
+	conf.Digest.Workers = 3
+	conf.Digest.Pace = "1ms"
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	// the failing subscriber doesn't keep the others from their digest
+	ctx := correlation.With(context.Background(), "run")
+	summary, err := worker.batch(ctx, 10, 0, now, time.Hour, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &BatchSummary{
+		Pushed:   2,
+		Skipped:  1,
+		Failed:   1,
+		Failures: map[string]string{"failing": "relay down"},
+	}, summary)
+	mockService.AssertCalled(t, "MarkPushed", mock.Anything, "a", now)
+	mockService.AssertCalled(t, "MarkPushed", mock.Anything, "b", now)
+	mockService.AssertNotCalled(t, "MarkPushed", mock.Anything, "failing", now)
+	mockService.AssertCalled(t, "RecordReceipt", mock.Anything, mock.MatchedBy(func(r types.DeliveryReceipt) bool {
+		return r.Subscriber == "failing" && !r.Accepted && r.Error == "relay down" && r.Run.Equal(now) && r.CorrelationID == "run"
+	}))
+}
+
+func TestBatchEmptyFeed(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000001"
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "a", ChannelSecret: channelSK, SubscribedAt: &joined},
+	}, nil)
+	mockService.On("InferInterests", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{})
+	mockService.On("GetReadRelays", mock.Anything, mock.Anything).Return([]string{}, nil)
+
+	worker, err := NewWorker(context.Background(), new(nostr.MockClient), mockService, config)
+	assert.NoError(t, err)
+
+	// an empty digest isn't pushed, so the subscriber is left due
+	summary, err := worker.batch(context.Background(), 10, 0, now, time.Hour, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &BatchSummary{Skipped: 1, Failures: map[string]string{}}, summary)
+	mockService.AssertNotCalled(t, "MarkPushed", mock.Anything, mock.Anything, mock.Anything)
+	mockService.AssertNotCalled(t, "RecordDeliveries", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBatchDryRun(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	left := now.AddDate(0, 0, -1)
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000001"
+
+	mockClient := new(nostr.MockClient)
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "a", ChannelSecret: channelSK, SubscribedAt: &joined},
+		{Pubkey: "gone", ChannelSecret: channelSK, SubscribedAt: &joined, UnsubscribedAt: &left},
+	}, nil)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
+		{Id: "post1", Pubkey: "author_pub", Score: 2},
+		{Id: "post2", Pubkey: "author_pub", Score: 1},
+	})
+
+	worker, err := NewWorker(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	summary, err := worker.batch(context.Background(), 10, 0, now, time.Hour, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &BatchSummary{
+		Pushed:   1,
+		Skipped:  1,
+		Failures: map[string]string{},
+		Digests:  map[string][]string{"a": {"post1", "post2"}},
+	}, summary)
+
+	// nothing is published nor recorded
+	mockClient.AssertNotCalled(t, "Repost", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "GiftWrap", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockService.AssertNotCalled(t, "InferInterests", mock.Anything, mock.Anything)
+	mockService.AssertNotCalled(t, "RecordDigest", mock.Anything, mock.Anything)
+	mockService.AssertNotCalled(t, "MarkPushed", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeliveryReceipt(t *testing.T) {
+	now := time.Now()
+	joined := now.AddDate(0, 0, -30)
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000001"
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("Repost", mock.Anything, channelSK, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("no relay accepted event"))
+
+	mockService := new(service.MockService)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "subscriber", ChannelSecret: channelSK, SubscribedAt: &joined},
+	}, nil)
+	mockService.On("InferInterests", mock.Anything, "subscriber").Return(nil)
+	mockService.On("QueryFeed", mock.Anything, mock.Anything).Return([]types.FeedEntry{
+		{Id: "event_id", Pubkey: "author_pub", Raw: "raw_event"},
+	})
+	mockService.On("RecordReceipt", mock.Anything, mock.Anything).Return(nil)
+	mockService.On("GetReadRelays", mock.Anything, "subscriber").Return([]string{"wss://read.example"}, nil)
+
+	worker, err := NewWorker(context.Background(), mockClient, mockService, config)
+	assert.NoError(t, err)
+
+	// a digest no relay accepted is not marked as pushed, so it's retried
+	summary, err := worker.batch(context.Background(), 10, 0, now, time.Hour, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Failed)
+	mockService.AssertNotCalled(t, "MarkPushed", mock.Anything, mock.Anything, mock.Anything)
+	mockService.AssertCalled(t, "RecordReceipt", mock.Anything, mock.MatchedBy(func(r types.DeliveryReceipt) bool {
+		return r.Subscriber == "subscriber" && !r.Accepted && r.Run.Equal(now)
+	}))
+}
+
+func TestPublishRisingAuthors(t *testing.T) {
+	now := time.Now()
+	channelSK := "0000000000000000000000000000000000000000000000000000000000000003"
+	rising := "0000000000000000000000000000000000000000000000000000000000000001"
+	followed := "0000000000000000000000000000000000000000000000000000000000000002"
+
+	mockService := new(service.MockService)
+	mockService.On("GetRisingAuthors", mock.Anything, now, 720*time.Hour, 20, 20).Return([]types.RisingAuthor{
+		{Pubkey: followed, Engagement: 90, PreviousEngagement: 30, Growth: 2},
+		{Pubkey: rising, Label: "alice", Engagement: 40, PreviousEngagement: 20, Growth: 1},
+	}, nil)
+	mockService.On("ListSubscribers", mock.Anything, 10, 0).Return([]types.Subscriber{
+		{Pubkey: "active", ChannelSecret: channelSK},
+		{Pubkey: "gone", ChannelSecret: channelSK, UnsubscribedAt: &now},
+		{Pubkey: "elsewhere", ChannelSecret: channelSK, ShardKey: otherShard},
+	}, nil)
+	mockService.On("GetFollowed", mock.Anything, "active", []string{followed, rising}).Return(map[string]bool{followed: true}, nil)
+
+	mockClient := new(nostr.MockClient)
+	mockClient.On("LongForm", mock.Anything, channelSK, "rising-"+now.Format("2006-01"), mock.Anything, mock.Anything,
+		mock.MatchedBy(func(content string) bool {
+			return strings.Contains(content, "**alice**") && strings.Contains(content, "up 100%") && !strings.Contains(content, "engaged by 90")
+		}), []string{"active"}).Return(nil)
+
+	conf := *config
+	conf.Sharding = servedShard
+	conf.Discovery = types.DiscoveryConfig{Enabled: true, Period: "720h", Size: 2, MinEngagement: 20}
+	worker, err := NewWorker(context.Background(), mockClient, mockService, &conf)
+	assert.NoError(t, err)
+
+	assert.NoError(t, worker.PublishRisingAuthors(context.Background(), now))
+	mockClient.AssertNumberOfCalls(t, "LongForm", 1)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// admin restricts an endpoint to the operator: requests carry the admin
+// bearer token, or come from localhost if none is configured
+func (app *Application) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAdmin(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			doResponse(w, false, "admin authentication required")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// adminPost leaves reads of an endpoint public, and restricts its POST
+// requests, which trigger work, to the operator
+func (app *Application) adminPost(handler http.HandlerFunc) http.HandlerFunc {
+	restricted := app.admin(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			restricted(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (app *Application) isAdmin(r *http.Request) bool {
+	token := app.config.Admin.Token
+	if token == "" {
+		return isLoopback(r.RemoteAddr)
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return given != "" && subtle.ConstantTimeCompare([]byte(token), []byte(given)) == 1
+}
+
+// isOutput tells if the request carries the token of the output
+func (app *Application) isOutput(r *http.Request, output string) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for _, o := range app.config.Licensing.Outputs {
+		if o.Output == output && o.Token != "" && given != "" {
+			return subtle.ConstantTimeCompare([]byte(o.Token), []byte(given)) == 1
+		}
+	}
+	return false
+}
+
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(clientIP(addr))
+	return ip != nil && ip.IsLoopback()
+}
+
+// clientIP returns the host of a remote address
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
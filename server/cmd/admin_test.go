@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	app := &Application{config: &types.Config{}}
+	handler := app.admin(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	status := func(remoteAddr, authorization string) int {
+		r := httptest.NewRequest(http.MethodPost, "/drain", nil)
+		r.RemoteAddr = remoteAddr
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	// without a token, only localhost is let through
+	assert.Equal(t, http.StatusNoContent, status("127.0.0.1:5000", ""))
+	assert.Equal(t, http.StatusNoContent, status("[::1]:5000", ""))
+	assert.Equal(t, http.StatusUnauthorized, status("203.0.113.7:5000", ""))
+
+	app.config.Admin.Token = "secret"
+	assert.Equal(t, http.StatusNoContent, status("203.0.113.7:5000", "Bearer secret"))
+	assert.Equal(t, http.StatusUnauthorized, status("203.0.113.7:5000", "Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, status("127.0.0.1:5000", ""))
+}
+
+func TestAdminPost(t *testing.T) {
+	app := &Application{config: &types.Config{Admin: types.AdminConfig{Token: "secret"}}}
+	handler := app.adminPost(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	status := func(method string) int {
+		r := httptest.NewRequest(method, "/leaderboards", nil)
+		r.RemoteAddr = "203.0.113.7:5000"
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, status(http.MethodGet))
+	assert.Equal(t, http.StatusUnauthorized, status(http.MethodPost))
+}
+
+func TestIsOutput(t *testing.T) {
+	app := &Application{config: &types.Config{Licensing: types.LicensingConfig{Outputs: []types.OutputPolicy{
+		{Output: "rss", Policy: "unrestricted", Token: "rss-token"},
+		{Output: "email", Policy: "licensed"},
+	}}}}
+	isOutput := func(output, authorization string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/v2/feed?output="+output, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		return app.isOutput(r, output)
+	}
+
+	assert.True(t, isOutput("rss", "Bearer rss-token"))
+	assert.False(t, isOutput("rss", "Bearer wrong"))
+	assert.False(t, isOutput("rss", ""))
+	// outputs without a token, or not configured, can't be picked
+	assert.False(t, isOutput("email", ""))
+	assert.False(t, isOutput("bridge", "Bearer rss-token"))
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7:5000"))
+	assert.Equal(t, "::1", clientIP("[::1]:5000"))
+	assert.Equal(t, "203.0.113.7", clientIP("203.0.113.7"))
+}
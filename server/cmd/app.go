@@ -8,13 +8,20 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/dyng/nosdaily/alert"
 	"github.com/dyng/nosdaily/bot"
+	"github.com/dyng/nosdaily/correlation"
 	"github.com/dyng/nosdaily/database"
+	"github.com/dyng/nosdaily/metrics"
 	"github.com/dyng/nosdaily/nostr"
 	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/supervisor"
 	"github.com/dyng/nosdaily/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/natefinch/lumberjack"
@@ -28,6 +35,14 @@ type Application struct {
 	crawler *nostr.Crawler
 	bot     *bot.BotApplication
 	nserver *nostr.NameServer
+	limiter *rateLimiter
+	alerter *alert.Alerter
+	server  *http.Server
+	// cancels the bot and the queries it runs
+	stopBot context.CancelFunc
+	crashes *crashCounter
+	// closed once shut down, the database is closed last
+	stopped chan struct{}
 }
 
 type response struct {
@@ -41,12 +56,47 @@ func NewApplication() *Application {
 	initLogger(config)
 	log.Debug("Loaded configuration", "config", config)
 
+	// fall back to digest delivery only when crashing in a loop
+	crashes := newCrashCounter(config)
+	crashed := crashes.start()
+	safeMode := config.SafeMode.CrashThreshold > 0 && crashed >= config.SafeMode.CrashThreshold
+	if safeMode {
+		log.Warn("Starting in safe mode after repeated crashes", "crashes", crashed)
+		config.EnterSafeMode()
+	}
+
 	// inject dependencies
 	neo4j := database.NewNeo4jDb(config)
 	service := service.NewService(config, neo4j)
 	crawler := nostr.NewCrawler(config, service)
 	bot := bot.NewBotApplication(config, service)
+	alerter := alert.NewAlerter(config, bot.Client)
+	service.SetAlerter(alerter)
+	if client, ok := bot.Client.(*nostr.Client); ok {
+		client.OnRelayUnusable(func(uri, reason string) {
+			alerter.Notify(context.Background(), alert.SeverityWarning, "relay unusable", fmt.Sprintf("%s: %s", uri, reason))
+		})
+	}
+	bot.Supervisor.OnIncident(func(incident supervisor.Incident) {
+		// a component restarting over and over won't recover by itself
+		severity := alert.SeverityInfo
+		if incident.Restarts >= 3 {
+			severity = alert.SeverityWarning
+		}
+		alerter.Notify(context.Background(), severity, incident.Component+" stopped", incident.Reason)
+	})
+	neo4j.OnCircuitChange(func(open bool, reason string) {
+		if open {
+			alerter.Notify(context.Background(), alert.SeverityCritical, "neo4j unreachable", reason)
+		} else {
+			alerter.Notify(context.Background(), alert.SeverityInfo, "neo4j recovered", reason)
+		}
+	})
 	nserver := nostr.NewNameServer(config, neo4j)
+	if safeMode {
+		alerter.Notify(context.Background(), alert.SeverityCritical, "nossence started in safe mode",
+			fmt.Sprintf("the last %d runs crashed, enrichment and heavy jobs are disabled until a run is stable", crashed))
+	}
 	return &Application{
 		config:  config,
 		neo4j:   neo4j,
@@ -54,70 +104,648 @@ func NewApplication() *Application {
 		crawler: crawler,
 		bot:     bot,
 		nserver: nserver,
+		limiter: newRateLimiter(time.Minute),
+		alerter: alerter,
+		crashes: crashes,
+		stopped: make(chan struct{}),
 	}
 }
 
 func (app *Application) Run() {
-	// connect to neo4j
-	err := app.neo4j.Connect()
-	if err != nil {
-		log.Crit("Failed to connect to neo4j", "err", err)
+	// connect to neo4j, unless an embedded store replaces it
+	if app.config.Database.Driver != types.DriverSQLite {
+		err := app.neo4j.Connect()
+		if err != nil {
+			log.Crit("Failed to connect to neo4j", "err", err)
+		}
 	}
 	app.service.Init()
 	defer app.neo4j.Close()
+	defer app.service.Close()
 
 	// start crawler
 	app.crawler.Run()
 
 	// start bot app
+	ctx, cancel := context.WithCancel(context.Background())
+	app.stopBot = cancel
 	go func() {
-		app.bot.Run(context.Background())
+		if err := app.bot.Run(ctx); err != nil {
+			log.Error("Bot stopped", "err", err)
+		}
 	}()
 
+	// hand the bot over before exiting
+	go app.shutdownOnSignal()
+	app.crashes.resetWhenStable(app.config.SafeMode)
+
+	app.alerter.Notify(context.Background(), alert.SeverityInfo, "nossence started", "server is listening on :8080")
+
 	// start http server
-	app.listenAndServe()
+	if err := app.listenAndServe(); errors.Is(err, http.ErrServerClosed) {
+		<-app.stopped
+	}
 }
 
-func (app *Application) listenAndServe() {
+func (app *Application) listenAndServe() error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/feed", app.handleFeed)
-	mux.HandleFunc("/push", app.handlePush)
-	mux.HandleFunc("/batch", app.handleBatch)
-	mux.HandleFunc("/run", app.handleRun)
+	handleVersioned(mux, []route{
+		{"/feed", app.handleFeed},
+		{"/trending", app.handleTrending},
+		{"/push", app.handlePush},
+		{"/batch", app.handleBatch},
+		{"/run", app.handleRun},
+		{"/recover", app.adminPost(app.handleRecover)},
+		{"/channels/rotate", app.admin(app.handleRotateChannel)},
+		{"/channels/handedover", app.admin(app.handleHandedOverChannels)},
+		{"/replay", app.adminPost(app.handleReplay)},
+		{"/events/backfill", app.admin(app.handleBackfill)},
+		{"/events", app.handleEvent},
+		{"/history", app.handleHistory},
+		{"/receipts", app.admin(app.handleReceipts)},
+		{"/incidents", app.handleIncidents},
+		{"/stats", app.handleStats},
+		{"/relays", app.handleRelays},
+		{"/profile", app.adminPost(app.handleProfile)},
+		{"/tier", app.admin(app.handleTier)},
+		{"/graph", app.admin(app.handleGraph)},
+		{"/interests", app.adminPost(app.handleInterests)},
+		{"/tuning", app.admin(app.handleTuning)},
+		{"/zaprings", app.adminPost(app.handleZapRings)},
+		{"/surveys", app.admin(app.handleSurveys)},
+		{"/feedback", app.admin(app.handleFeedback)},
+		{"/churn", app.admin(app.handleChurn)},
+		{"/leaderboards", app.adminPost(app.handleLeaderboards)},
+		{"/scores", app.admin(app.handleScores)},
+		{"/subscribers/export", app.admin(app.handleExportSubscribers)},
+		{"/subscribers/import", app.admin(app.handleImportSubscribers)},
+		{"/drain", app.admin(app.handleDrain)},
+	})
+	mux.HandleFunc("/v2/feed", app.handleFeedV2)
+	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/.well-known/nostr.json", app.nserver.Serve)
 
 	log.Info("Server started")
-	err := http.ListenAndServe(":8080", mux)
+	app.server = &http.Server{Addr: ":8080", Handler: correlate(mux)}
+	err := app.server.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
 		log.Info("Server closed")
 	} else {
 		log.Error("Server error", "err", err)
+		app.alerter.Notify(context.Background(), alert.SeverityCritical, "server stopped", err.Error())
 	}
+	return err
+}
+
+// correlate tags each request with the correlation ID of its header, or a
+// new one if it's missing or malformed, and returns it in the response
+func correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(correlation.Header)
+		if !correlation.Valid(id) {
+			id = correlation.New()
+		}
+		w.Header().Set(correlation.Header, id)
+		ctx := correlation.With(r.Context(), id)
+		correlation.Logger(ctx, log.Root()).Debug("Handling request", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// allow applies the feed API rate limit of the user's tier to the client, so
+// that rotating the pubkey doesn't reset the limit
+func (app *Application) allow(w http.ResponseWriter, r *http.Request, userPub string) bool {
+	tier := app.config.Tiers.Of(app.service.GetSubscriber(r.Context(), userPub), time.Now())
+	if !app.limiter.Allow(clientIP(r.RemoteAddr), tier.APIRateLimit) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		doResponse(w, false, "rate limit exceeded")
+		return false
+	}
+	return true
 }
 
 func (app *Application) handleFeed(w http.ResponseWriter, r *http.Request) {
 	userPub := r.URL.Query().Get("pubkey")
+	if !app.allow(w, r, userPub) {
+		return
+	}
+
+	maxPerAuthor, err := strconv.Atoi(r.URL.Query().Get("maxPerAuthor"))
+	if err != nil || maxPerAuthor < 0 {
+		maxPerAuthor = app.config.Digest.MaxPerAuthor
+	}
 
-	feed := app.service.GetFeed(userPub, time.Now().Add(-1*time.Hour), time.Now(), 10)
+	feed := app.service.QueryFeed(r.Context(), types.FeedQuery{
+		Subscriber:   userPub,
+		Start:        time.Now().Add(-1 * time.Hour),
+		End:          time.Now(),
+		Limit:        10,
+		MaxPerAuthor: maxPerAuthor,
+		Output:       types.OutputAPI,
+	})
 	doResponse(w, true, feed)
 }
 
+func (app *Application) handleTrending(w http.ResponseWriter, r *http.Request) {
+	hours, err := strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil || hours <= 0 {
+		hours = 6
+	}
+
+	end := time.Now()
+	feed := app.service.GetTrendingFeed(r.Context(), end.Add(-time.Duration(hours)*time.Hour), end, 10)
+	feed = app.service.FilterReuse(types.OutputAPI, feed)
+	doResponse(w, true, feed)
+}
+
+func (app *Application) handleHistory(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days <= 0 {
+		days = 7
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	end := time.Now()
+	start := end.AddDate(0, 0, -days)
+	history, err := app.service.GetAuthorHistory(r.Context(), pubkey, start, end, limit)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, history)
+}
+
+// handleReceipts lists whether recent digests of a subscriber were accepted
+// by a relay
+func (app *Application) handleReceipts(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+
+	receipts, err := app.service.GetReceipts(r.Context(), pubkey, limit)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, receipts)
+}
+
+// handleIncidents lists the recent components stopping unexpectedly
+func (app *Application) handleIncidents(w http.ResponseWriter, r *http.Request) {
+	doResponse(w, true, app.bot.Supervisor.Incidents())
+}
+
+func (app *Application) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.service.GetPublicStats(r.Context())
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, stats)
+}
+
+func (app *Application) handleRelays(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.service.GetRelayStats(r.Context(), time.Now().Add(-24*time.Hour))
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, stats)
+}
+
+func (app *Application) handleProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := app.bot.Bot.PublishProfile(r.Context())
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, "published")
+}
+
+func (app *Application) handleTier(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	tier := r.URL.Query().Get("tier")
+	if tier != types.TierFree && tier != types.TierPremium {
+		doResponse(w, false, "unknown tier")
+		return
+	}
+
+	var expiresAt *time.Time
+	if days, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && days > 0 {
+		t := time.Now().AddDate(0, 0, days)
+		expiresAt = &t
+	}
+
+	err := app.service.GrantTier(r.Context(), pubkey, tier, expiresAt)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, "granted")
+}
+
+func (app *Application) handleExportSubscribers(w http.ResponseWriter, r *http.Request) {
+	shard, _ := strconv.Atoi(r.URL.Query().Get("shard"))
+	shards, _ := strconv.Atoi(r.URL.Query().Get("shards"))
+
+	bundle, err := app.service.ExportSubscribers(r.Context(), shard, shards)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, bundle)
+}
+
+func (app *Application) handleImportSubscribers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var bundle types.SubscriberBundle
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	report, err := app.service.ImportSubscribers(r.Context(), &bundle, overwrite)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, report)
+}
+
+func (app *Application) handleTuning(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		switch r.URL.Query().Get("action") {
+		case "propose":
+			proposal, err := app.service.ProposeWeights(r.Context())
+			if err != nil {
+				doResponse(w, false, err.Error())
+				return
+			}
+			doResponse(w, true, proposal)
+		case "approve":
+			weights, err := app.service.ApproveWeights()
+			if err != nil {
+				doResponse(w, false, err.Error())
+				return
+			}
+			doResponse(w, true, weights)
+		case "reject":
+			app.service.RejectWeights()
+			doResponse(w, true, "rejected")
+		default:
+			doResponse(w, false, "unknown action")
+		}
+		return
+	}
+
+	doResponse(w, true, map[string]any{
+		"weights": app.service.Weights(),
+		"pending": app.service.PendingWeights(),
+	})
+}
+
+func (app *Application) handleZapRings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		rings, err := app.service.DetectZapRings(time.Now())
+		if err != nil {
+			doResponse(w, false, err.Error())
+			return
+		}
+		doResponse(w, true, rings)
+		return
+	}
+
+	rings, err := app.service.GetZapRings()
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, rings)
+}
+
+// handleSurveys lists the survey responses of subscribers, which only the
+// operator may read
+func (app *Application) handleSurveys(w http.ResponseWriter, r *http.Request) {
+	since := unixParam(r.URL.Query().Get("since"), time.Now().AddDate(0, 0, -30))
+	responses, err := app.service.GetSurveyResponses(since)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, responses)
+}
+
+// handleFeedback lists the ratings subscribers gave to their digests, which
+// only the operator may read
+func (app *Application) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	since := unixParam(r.URL.Query().Get("since"), time.Now().AddDate(0, 0, -30))
+	feedback, err := app.service.GetDigestFeedback(since)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, feedback)
+}
+
+func (app *Application) handleChurn(w http.ResponseWriter, r *http.Request) {
+	minRisk, err := strconv.ParseFloat(r.URL.Query().Get("min"), 64)
+	if err != nil {
+		minRisk = app.config.Churn.ReengageRisk
+	}
+
+	risks, err := app.service.GetChurnRisks(minRisk)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, risks)
+}
+
+// handleLeaderboards returns the leaderboard of the week, a POST by the
+// operator computes it again first
+func (app *Application) handleLeaderboards(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := app.bot.Worker.UpdateLeaderboard(r.Context(), time.Now()); err != nil {
+			doResponse(w, false, err.Error())
+			return
+		}
+	}
+
+	board, err := app.service.GetLeaderboard(r.Context(), unixParam(r.URL.Query().Get("at"), time.Now()))
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, board)
+}
+
+// handleScores lists recent samples of the feed score distribution. Samples
+// are only taken by the scheduled job, so that they're evenly spaced.
+func (app *Application) handleScores(w http.ResponseWriter, r *http.Request) {
+	doResponse(w, true, app.service.GetScoreDistributions())
+}
+
+func (app *Application) handleInterests(w http.ResponseWriter, r *http.Request) {
+	pubkey := r.URL.Query().Get("pubkey")
+	if r.Method == http.MethodPost {
+		topics := strings.Split(r.URL.Query().Get("topics"), ",")
+		interested := r.URL.Query().Get("action") != "remove"
+		if err := app.service.SetInterests(r.Context(), pubkey, topics, interested); err != nil {
+			doResponse(w, false, err.Error())
+			return
+		}
+	}
+
+	interests, err := app.service.GetInterests(r.Context(), pubkey)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, interests)
+}
+
+func (app *Application) handleGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	depth, err := strconv.Atoi(query.Get("depth"))
+	if err != nil || depth <= 0 {
+		depth = 1
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 500
+	}
+
+	graph, err := app.service.GetNeighborhood(r.Context(), query.Get("post"), query.Get("pubkey"), depth, limit)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+
+	if query.Get("format") == "graphml" {
+		w.Header().Set("Content-Type", "application/graphml+xml")
+		if err := writeGraphML(w, graph); err != nil {
+			log.Error("Failed to encode graphml", "err", err)
+		}
+		return
+	}
+	doResponse(w, true, graph)
+}
+
 func (app *Application) handleRun(w http.ResponseWriter, r *http.Request) {
 	app.bot.Worker.Run(r.Context())
 	doResponse(w, true, "dispatched")
 }
 
+// handleRecover compares subscriptions with the welcome messages on relays,
+// recreating missing ones only on POST
+func (app *Application) handleRecover(w http.ResponseWriter, r *http.Request) {
+	recreate, _ := strconv.ParseBool(r.URL.Query().Get("recreate"))
+	if recreate && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	report := app.bot.Bot.RecoverSubscriptions(r.Context(), recreate)
+	doResponse(w, true, report)
+}
+
+// drain hands the bot over to the next instance
+func (app *Application) drain() error {
+	timeout, err := time.ParseDuration(app.config.Handover.DrainTimeout)
+	if err != nil {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return app.bot.Drain(ctx)
+}
+
+// shutdownOnSignal shuts down on SIGTERM or interrupt: subscriptions are
+// closed first, then the bot finishes its current batch of digests and hands
+// over, and events being stored are drained. The database is closed last, by
+// Run.
+func (app *Application) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+
+	log.Info("Received signal, shutting down", "signal", sig)
+	defer close(app.stopped)
+	timeout, err := time.ParseDuration(app.config.Handover.ShutdownTimeout)
+	if err != nil {
+		timeout = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if err := app.crawler.Stop(ctx); err != nil {
+		log.Error("Failed to stop crawler", "err", err)
+	}
+	cancel()
+
+	if err := app.drain(); err != nil {
+		log.Error("Failed to drain bot", "err", err)
+	}
+	// cancel whatever the bot still runs, e.g. a digest that didn't drain,
+	// and wait for it to exit so that it doesn't write once the database is
+	// closed
+	app.stopBot()
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	if err := app.bot.Drain(ctx); err != nil {
+		log.Error("Bot didn't stop in time", "err", err)
+	}
+	cancel()
+	app.crashes.reset()
+
+	if app.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := app.server.Shutdown(ctx); err != nil {
+			log.Error("Failed to shut down server", "err", err)
+		}
+		cancel()
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := app.service.Drain(ctx); err != nil {
+		log.Error("Failed to drain events being stored", "err", err)
+	}
+}
+
+// handleDrain hands the bot over without stopping the server, so that a
+// deploy can wait for it before starting the next instance
+func (app *Application) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := app.drain(); err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, "drained")
+}
+
+func (app *Application) handleRotateChannel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	channelPub, err := app.bot.Bot.RotateChannel(r.Context(), r.URL.Query().Get("pubkey"))
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, channelPub)
+}
+
+// handleHandedOverChannels lists channels in the custody of their
+// subscribers, by subscriber
+func (app *Application) handleHandedOverChannels(w http.ResponseWriter, r *http.Request) {
+	channels, err := app.service.GetHandedOverChannels(r.Context())
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, channels)
+}
+
+func (app *Application) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		doResponse(w, false, "invalid 'from' parameter")
+		return
+	}
+	to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+	if err != nil {
+		to = time.Now()
+	}
+
+	total, err := app.service.ReplayArchive(r.Context(), from, to)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, total)
+}
+
+// handleEvent returns the raw event of the id, events are POSTed by
+// ingestion sources
+func (app *Application) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		app.handleIngest(w, r)
+		return
+	}
+
+	raw, err := app.service.GetRawEvent(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, json.RawMessage(raw))
+}
+
+func (app *Application) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	kinds := []int{}
+	for _, value := range strings.Split(r.URL.Query().Get("kinds"), ",") {
+		kind, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			doResponse(w, false, "invalid 'kinds' parameter")
+			return
+		}
+		kinds = append(kinds, kind)
+	}
+
+	report, err := app.service.BackfillRawEvents(r.Context(), kinds)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, report)
+}
+
 func (app *Application) handleBatch(w http.ResponseWriter, r *http.Request) {
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 	skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
-	app.bot.Worker.Batch(r.Context(), limit, skip)
-	doResponse(w, true, "dispatched")
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryrun"))
+	summary, err := app.bot.Worker.Batch(r.Context(), limit, skip, dryRun)
+	if err != nil {
+		doResponse(w, false, err.Error())
+		return
+	}
+	doResponse(w, true, summary)
 }
 
 func (app *Application) handlePush(w http.ResponseWriter, r *http.Request) {
 	subscriberPub := r.URL.Query().Get("pubkey")
 
-	subscriber := app.service.GetSubscriber(subscriberPub)
+	subscriber := app.service.GetSubscriber(r.Context(), subscriberPub)
 	if subscriber == nil {
 		doResponse(w, false, "subscriber not found")
 	}
@@ -161,6 +789,40 @@ func setDefaultValue(config *types.Config) {
 	if config.Bot.Metadata.ChannelAbout == "" {
 		config.Bot.Metadata.ChannelAbout = "nossence curated content for %s powered by %s"
 	}
+
+	if config.Tiers.Free.DigestSize == 0 {
+		config.Tiers.Free = types.TierConfig{
+			DigestSize:           5,
+			MinInterval:          "1h",
+			PersonalizationDepth: 1,
+			APIRateLimit:         10,
+		}
+	}
+
+	if config.Tiers.Premium.DigestSize == 0 {
+		config.Tiers.Premium = types.TierConfig{
+			DigestSize:           10,
+			MinInterval:          "1h",
+			PersonalizationDepth: 1,
+			APIRateLimit:         60,
+		}
+	}
+
+	for i := range config.Operator.Transports {
+		if config.Operator.Transports[i].MinSeverity == "" {
+			config.Operator.Transports[i].MinSeverity = "warning"
+		}
+	}
+
+	if config.Licensing.Restricted == nil {
+		config.Licensing.Restricted = []string{"all-rights-reserved", "nostr-only", "no-redistribution"}
+	}
+
+	for i := range config.Curation.Curators {
+		if config.Curation.Curators[i].Weight == 0 {
+			config.Curation.Curators[i].Weight = 50
+		}
+	}
 }
 
 func initLogger(config *types.Config) {
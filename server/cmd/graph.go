@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	Id          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Id     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// writeGraphML encodes the graph as GraphML so it can be opened in Gephi.
+// Node properties are exported as string attributes.
+func writeGraphML(w io.Writer, graph *types.Graph) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{Id: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{Id: "type", For: "edge", AttrName: "type", AttrType: "string"},
+		},
+		Graph: graphMLGraph{Id: "nossence", EdgeDefault: "directed"},
+	}
+
+	keys := map[string]bool{}
+	for _, node := range graph.Nodes {
+		n := graphMLNode{
+			Id:   node.Id,
+			Data: []graphMLData{{Key: "label", Value: node.Label}},
+		}
+
+		props := make([]string, 0, len(node.Properties))
+		for k := range node.Properties {
+			props = append(props, k)
+		}
+		sort.Strings(props)
+		for _, k := range props {
+			keys[k] = true
+			n.Data = append(n.Data, graphMLData{Key: "p_" + k, Value: fmt.Sprint(node.Properties[k])})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		doc.Keys = append(doc.Keys, graphMLKey{Id: "p_" + k, For: "node", AttrName: k, AttrType: "string"})
+	}
+
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Id:     edge.Id,
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   []graphMLData{{Key: "type", Value: edge.Type}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
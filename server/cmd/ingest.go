@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// bytes of an ingested event, on average, bounding the request body
+const maxIngestEventSize = 64 * 1024
+
+// ingestSource authenticates the source pushing events by its bearer token
+func (app *Application) ingestSource(r *http.Request) *types.IngestSource {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return nil
+	}
+	for i, source := range app.config.Ingest.Sources {
+		if source.Token != "" && subtle.ConstantTimeCompare([]byte(source.Token), []byte(token)) == 1 {
+			return &app.config.Ingest.Sources[i]
+		}
+	}
+	return nil
+}
+
+// handleIngest stores signed events pushed by an ingestion source, either a
+// single event or an array of them
+func (app *Application) handleIngest(w http.ResponseWriter, r *http.Request) {
+	conf := app.config.Ingest
+	if !conf.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	source := app.ingestSource(r)
+	if source == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		doResponse(w, false, "unknown ingestion source")
+		return
+	}
+
+	events, err := decodeEvents(http.MaxBytesReader(w, r.Body, int64(conf.MaxEvents)*maxIngestEventSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		doResponse(w, false, err.Error())
+		return
+	}
+	if len(events) > conf.MaxEvents {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		doResponse(w, false, fmt.Sprintf("at most %d events are accepted per request", conf.MaxEvents))
+		return
+	}
+
+	report := app.service.IngestEvents(r.Context(), *source, events, app.crawler.Matches)
+	doResponse(w, true, report)
+}
+
+func decodeEvents(body io.Reader) ([]*nostr.Event, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		var event nostr.Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		return []*nostr.Event{&event}, nil
+	}
+
+	var events []*nostr.Event
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event == nil {
+			return nil, fmt.Errorf("null event")
+		}
+	}
+	return events, nil
+}
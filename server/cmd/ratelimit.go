@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a fixed window limiter keyed by caller
+type rateLimiter struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	start   time.Time
+	counter map[string]int
+}
+
+func newRateLimiter(window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		window:  window,
+		start:   time.Now(),
+		counter: make(map[string]int),
+	}
+}
+
+// Allow records a request of key and reports whether it's within limit, a
+// non-positive limit means unlimited
+func (rl *rateLimiter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if time.Since(rl.start) >= rl.window {
+		rl.start = time.Now()
+		rl.counter = make(map[string]int)
+	}
+
+	if rl.counter[key] >= limit {
+		return false
+	}
+	rl.counter[key]++
+	return true
+}
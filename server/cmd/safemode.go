@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// crashCounter counts the runs in a row that exited before running stably,
+// in a file surviving restarts. A run counts itself as crashed when it
+// starts, and resets the count once it's stable or shuts down cleanly.
+type crashCounter struct {
+	path string
+}
+
+func newCrashCounter(config *types.Config) *crashCounter {
+	file := config.SafeMode.StateFile
+	if file == "" {
+		file = path.Join(config.Objects.Root, "crashes")
+	}
+	return &crashCounter{path: file}
+}
+
+// start returns the number of runs that crashed before this one, and counts
+// this one until it's stable
+func (c *crashCounter) start() int {
+	crashes := 0
+	if data, err := os.ReadFile(c.path); err == nil {
+		crashes, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	c.write(crashes + 1)
+	return crashes
+}
+
+// reset clears the count, the run didn't crash
+func (c *crashCounter) reset() {
+	c.write(0)
+}
+
+func (c *crashCounter) write(crashes int) {
+	os.MkdirAll(path.Dir(c.path), 0755)
+	if err := os.WriteFile(c.path, []byte(strconv.Itoa(crashes)), 0644); err != nil {
+		log.Warn("Failed to persist crash counter", "path", c.path, "err", err)
+	}
+}
+
+// resetWhenStable resets the count once the run has lasted long enough not
+// to be part of a crash loop
+func (c *crashCounter) resetWhenStable(config types.SafeModeConfig) {
+	stableAfter, err := time.ParseDuration(config.StableAfter)
+	if err != nil {
+		stableAfter = 10 * time.Minute
+	}
+	time.AfterFunc(stableAfter, func() {
+		log.Info("Running stably, clearing crash counter")
+		c.reset()
+	})
+}
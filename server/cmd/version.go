@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+type route struct {
+	path    string
+	handler http.HandlerFunc
+}
+
+// handleVersioned mounts v1 routes under /v1, and keeps the unversioned paths
+// as deprecated aliases pointing to their successor
+func handleVersioned(mux *http.ServeMux, v1 []route) {
+	for _, r := range v1 {
+		successor := "/v1" + r.path
+		mux.HandleFunc(successor, r.handler)
+		mux.HandleFunc(r.path, deprecated(successor, r.handler))
+	}
+}
+
+func deprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+		handler(w, r)
+	}
+}
+
+// handleFeedV2 takes the feed window, size, author limit and topic as
+// parameters: start and end are unix timestamps, defaulting to the last hour.
+// Windows longer than Digest.MaxWindow are shortened to end at end.
+func (app *Application) handleFeedV2(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	userPub := params.Get("pubkey")
+	if !app.allow(w, r, userPub) {
+		return
+	}
+
+	now := time.Now()
+	query := types.FeedQuery{
+		Subscriber:   userPub,
+		Start:        unixParam(params.Get("start"), now.Add(-time.Hour)),
+		End:          unixParam(params.Get("end"), now),
+		Limit:        10,
+		MaxPerAuthor: app.config.Digest.MaxPerAuthor,
+		Output:       types.OutputAPI,
+	}
+	// wide windows are costly to rank, they're clamped like digest windows
+	if maxWindow, err := time.ParseDuration(app.config.Digest.MaxWindow); err == nil && query.End.Sub(query.Start) > maxWindow {
+		query.Start = query.End.Add(-maxWindow)
+	}
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 && limit <= 100 {
+		query.Limit = limit
+	}
+	if maxPerAuthor, err := strconv.Atoi(params.Get("maxPerAuthor")); err == nil && maxPerAuthor >= 0 {
+		query.MaxPerAuthor = maxPerAuthor
+	}
+	if topic := params.Get("topic"); topic != "" {
+		query.Topic = strings.ToLower(strings.TrimPrefix(topic, "#"))
+	}
+	if output := params.Get("output"); output != "" {
+		// bridges re-publishing the feed elsewhere query as their output,
+		// authenticated so that they can't pick a laxer policy
+		if !app.isOutput(r, output) {
+			w.WriteHeader(http.StatusUnauthorized)
+			doResponse(w, false, "output authentication required")
+			return
+		}
+		query.Output = output
+	}
+
+	doResponse(w, true, app.service.QueryFeed(r.Context(), query))
+}
+
+func unixParam(value string, fallback time.Time) time.Time {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return time.Unix(ts, 0)
+}
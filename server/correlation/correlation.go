@@ -0,0 +1,84 @@
+// Package correlation tags the handling of an incoming event, command, API
+// request or worker run with an ID carried by its context, so that its logs
+// can be followed across the bot, service and database layers.
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Header carries the correlation ID of an API request, and is set on its
+// response
+const Header = "X-Correlation-ID"
+
+// key of the correlation ID in logs and transaction metadata
+const Key = "cid"
+
+type contextKey struct{}
+
+// New returns a random correlation ID
+func New() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Valid tells if an ID received from a client, e.g. in the request header,
+// is safe to log: up to 64 hex digits, or a UUID
+func Valid(id string) bool {
+	if len(id) == 36 && id[8] == '-' && id[13] == '-' && id[18] == '-' && id[23] == '-' {
+		id = id[:8] + id[9:13] + id[14:18] + id[19:23] + id[24:]
+	}
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns a copy of ctx carrying the correlation ID
+func With(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// Ensure returns ctx if it carries a correlation ID already, e.g. a run
+// started by an API request, or a copy carrying a new one
+func Ensure(ctx context.Context) context.Context {
+	if ID(ctx) != "" {
+		return ctx
+	}
+	return With(ctx, New())
+}
+
+// Detach returns a context carrying the correlation ID of ctx, but neither
+// its deadline nor its cancellation, e.g. for writes outliving a connection
+func Detach(ctx context.Context) context.Context {
+	return With(context.Background(), ID(ctx))
+}
+
+// ID returns the correlation ID carried by ctx, empty if there's none
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns l logging the correlation ID carried by ctx, if any
+func Logger(ctx context.Context, l log.Logger) log.Logger {
+	if id := ID(ctx); id != "" {
+		return l.New(Key, id)
+	}
+	return l
+}
@@ -0,0 +1,45 @@
+package correlation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsure(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, ID(ctx))
+
+	ctx = Ensure(ctx)
+	id := ID(ctx)
+	assert.Len(t, id, 16)
+
+	// an ID carried already is kept
+	assert.Equal(t, id, ID(Ensure(ctx)))
+	assert.NotEqual(t, id, ID(Ensure(context.Background())))
+
+	assert.Equal(t, "request", ID(With(ctx, "request")))
+	assert.Equal(t, id, ID(With(ctx, "")))
+}
+
+func TestValid(t *testing.T) {
+	assert.True(t, Valid(New()))
+	assert.True(t, Valid("6F9619FF-8B86-D011-B42D-00C04FC964FF"))
+	assert.True(t, Valid(strings.Repeat("a", 64)))
+
+	assert.False(t, Valid(""))
+	assert.False(t, Valid(strings.Repeat("a", 65)))
+	assert.False(t, Valid("request\nforged=1"))
+	assert.False(t, Valid("6f9619ff-8b86-d011-b42d-00c04fc964fg"))
+}
+
+func TestDetach(t *testing.T) {
+	ctx, cancel := context.WithCancel(With(context.Background(), "request"))
+	cancel()
+
+	detached := Detach(ctx)
+	assert.Equal(t, "request", ID(detached))
+	assert.NoError(t, detached.Err())
+}
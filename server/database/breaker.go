@@ -0,0 +1,59 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker opening after threshold consecutive failures.
+// While open, calls are refused for cooldown, then one is let through to try
+// the database again.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	open      bool
+	openUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow tells if a call may go through
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open || !now.Before(b.openUntil)
+}
+
+// success records a call that reached the database, and tells if it closed
+// the breaker
+func (b *breaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	closed := b.open
+	b.failures, b.open = 0, false
+	return closed
+}
+
+// failure records a call that failed to reach the database, and tells if it
+// opened the breaker
+func (b *breaker) failure(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false
+	}
+	opened := !b.open
+	b.open, b.openUntil = true, now.Add(b.cooldown)
+	return opened
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
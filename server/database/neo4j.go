@@ -2,64 +2,355 @@ package database
 
 import (
 	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/metrics"
 	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 )
 
+var logger = log.New("module", "database")
+
+// ErrNotConnected is returned by queries run before connecting, e.g. when
+// another store replaces Neo4j
+var ErrNotConnected = errors.New("neo4j is not connected")
+
+// ErrCircuitOpen is returned by queries refused while Neo4j is unreachable,
+// so that callers fail fast and keep their writes for later
+var ErrCircuitOpen = errors.New("neo4j circuit breaker is open")
+
+// backoff between retries never grows past this
+const maxRetryBackoff = 30 * time.Second
+
 type Neo4jDb struct {
 	config *types.Config
+
+	mu     sync.RWMutex
 	driver neo4j.DriverWithContext
+
+	breaker   *breaker
+	onCircuit func(open bool, reason string)
+	stop      chan struct{}
+	// closed on Close, cancelling the queries in flight
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
 func NewNeo4jDb(config *types.Config) *Neo4jDb {
+	conf := config.Neo4j
 	return &Neo4jDb{
-		config: config,
+		config:  config,
+		breaker: newBreaker(conf.BreakerThreshold, durationOr(conf.BreakerCooldown, 30*time.Second)),
+		closed:  make(chan struct{}),
 	}
 }
 
 func (db *Neo4jDb) Connect() error {
-	conf := db.config.Neo4j
-
-	driver, err := neo4j.NewDriverWithContext(conf.Url, neo4j.BasicAuth(conf.Username, conf.Password, ""))
+	driver, err := db.newDriver()
 	if err != nil {
 		return err
 	}
 
-	db.driver = driver
+	db.setDriver(driver)
+	db.startProbe()
 	return nil
 }
 
+func (db *Neo4jDb) newDriver() (neo4j.DriverWithContext, error) {
+	conf := db.config.Neo4j
+	return neo4j.NewDriverWithContext(conf.Url, neo4j.BasicAuth(conf.Username, conf.Password, ""))
+}
+
+func (db *Neo4jDb) setDriver(driver neo4j.DriverWithContext) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.driver = driver
+}
+
 func (db *Neo4jDb) GetDriver() neo4j.DriverWithContext {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return db.driver
 }
 
+// OnCircuitChange registers a handler called when the circuit breaker opens
+// or closes
+func (db *Neo4jDb) OnCircuitChange(handler func(open bool, reason string)) {
+	db.onCircuit = handler
+}
+
+// Healthy tells if queries are let through, i.e. Neo4j didn't fail recently
+func (db *Neo4jDb) Healthy() bool {
+	return db.GetDriver() != nil && !db.breaker.isOpen()
+}
+
+// Ping checks whether the database is reachable
+func (db *Neo4jDb) Ping(ctx context.Context) error {
+	driver := db.GetDriver()
+	if driver == nil {
+		return ErrNotConnected
+	}
+	return driver.VerifyConnectivity(ctx)
+}
+
+// Close cancels the queries in flight and closes the connection
 func (db *Neo4jDb) Close() error {
-	return db.driver.Close(context.Background())
+	db.closeOnce.Do(func() { close(db.closed) })
+	if db.stop != nil {
+		close(db.stop)
+		db.stop = nil
+	}
+
+	driver := db.GetDriver()
+	if driver == nil {
+		return nil
+	}
+	return driver.Close(context.Background())
+}
+
+// Work runs queries in a transaction. ctx is cancelled when the query
+// times out, the caller's context is done or the database is closed, and
+// should be passed to tx.Run and the results.
+type Work func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error)
+
+// ExecuteRead runs work in a read transaction within ReadTimeout
+func (db *Neo4jDb) ExecuteRead(ctx context.Context, work Work) (any, error) {
+	timeout := durationOr(db.config.Neo4j.ReadTimeout, 30*time.Second)
+	return db.withRetry(ctx, timeout, db.config.Neo4j.MaxRetries, func(ctx context.Context, driver neo4j.DriverWithContext) (any, error) {
+		session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
+		defer session.Close(context.Background())
+
+		return session.ExecuteRead(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+			return work(ctx, tx)
+		}, txConfig(ctx, timeout)...)
+	})
+}
+
+// ExecuteWrite runs work in a write transaction within WriteTimeout
+func (db *Neo4jDb) ExecuteWrite(ctx context.Context, work Work) (any, error) {
+	timeout := durationOr(db.config.Neo4j.WriteTimeout, time.Minute)
+	return db.withRetry(ctx, timeout, db.config.Neo4j.MaxRetries, func(ctx context.Context, driver neo4j.DriverWithContext) (any, error) {
+		session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+		defer session.Close(context.Background())
+
+		return session.ExecuteWrite(ctx, func(tx neo4j.ManagedTransaction) (any, error) {
+			return work(ctx, tx)
+		}, txConfig(ctx, timeout)...)
+	})
+}
+
+// Run runs a query in an auto-commit transaction within WriteTimeout. The
+// result is consumed before returning, as the query is cancelled then. It's
+// never retried, as a query failing may have been committed already, unlike
+// the work of a managed transaction.
+func (db *Neo4jDb) Run(ctx context.Context, cypher string, params map[string]any) (neo4j.ResultSummary, error) {
+	timeout := durationOr(db.config.Neo4j.WriteTimeout, time.Minute)
+	summary, err := db.withRetry(ctx, timeout, 0, func(ctx context.Context, driver neo4j.DriverWithContext) (any, error) {
+		session := driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+		defer session.Close(context.Background())
+
+		result, err := session.Run(ctx, cypher, params, txConfig(ctx, timeout)...)
+		if err != nil {
+			return nil, err
+		}
+		return result.Consume(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary.(neo4j.ResultSummary), nil
+}
+
+// txConfig bounds a transaction by timeout, and tags it with the correlation
+// ID of ctx so that it shows up in Neo4j's query log and transaction list
+func txConfig(ctx context.Context, timeout time.Duration) []func(*neo4j.TransactionConfig) {
+	configurers := []func(*neo4j.TransactionConfig){neo4j.WithTxTimeout(timeout)}
+	if id := correlation.ID(ctx); id != "" {
+		configurers = append(configurers, neo4j.WithTxMetadata(map[string]any{correlation.Key: id}))
+	}
+	return configurers
+}
+
+// withRetry runs op until it succeeds, fails for a reason retrying can't fix,
+// or it was retried the given number of times. Each attempt gets timeout to
+// complete, and none is made once ctx is done or the database closed.
+// Transient failures count against the circuit breaker, and no query is run
+// while it's open.
+func (db *Neo4jDb) withRetry(ctx context.Context, timeout time.Duration, retries int, op func(ctx context.Context, driver neo4j.DriverWithContext) (any, error)) (any, error) {
+	ctx, cancel := db.queryContext(ctx)
+	defer cancel()
+
+	logger := correlation.Logger(ctx, logger)
+	backoff := durationOr(db.config.Neo4j.RetryBackoff, 500*time.Millisecond)
+	for attempt := 1; ; attempt++ {
+		driver := db.GetDriver()
+		if driver == nil {
+			return nil, ErrNotConnected
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !db.breaker.allow(time.Now()) {
+			return nil, ErrCircuitOpen
+		}
+
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, timeout)
+		result, err := op(attemptCtx, driver)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancelAttempt()
+		if ctx.Err() != nil {
+			// cancelled by the caller or on shutdown, Neo4j isn't to blame
+			return nil, ctx.Err()
+		}
+		if timedOut {
+			metrics.NewCounter("neo4j/timeouts").Inc(1)
+			logger.Warn("Neo4j query timed out", "timeout", timeout)
+			return nil, context.DeadlineExceeded
+		}
+		// queries on a driver replaced meanwhile are retried on the new one
+		if err == nil || (!isTransient(err) && driver == db.GetDriver()) {
+			db.recordSuccess()
+			return result, err
+		}
+
+		db.recordFailure(err)
+		if attempt > retries {
+			return nil, err
+		}
+		metrics.NewCounter("neo4j/retries").Inc(1)
+		logger.Warn("Retrying neo4j query after transient error", "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// queryContext derives the context of a query from the caller's, cancelled
+// as well when the database is closed
+func (db *Neo4jDb) queryContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-db.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
-func (db *Neo4jDb) ExecuteRead(work func(tx neo4j.ManagedTransaction) (any, error)) (any, error) {
-	ctx := context.Background()
+func (db *Neo4jDb) recordSuccess() {
+	if db.breaker.success() {
+		logger.Info("Neo4j is reachable again, circuit breaker closed")
+		db.circuitChanged(false, "neo4j is reachable again")
+	}
+}
 
-	session := db.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
-	defer session.Close(ctx)
+func (db *Neo4jDb) recordFailure(err error) {
+	if db.breaker.failure(time.Now()) {
+		logger.Error("Neo4j is unreachable, circuit breaker opened", "err", err)
+		db.circuitChanged(true, err.Error())
+	}
+}
+
+func (db *Neo4jDb) circuitChanged(open bool, reason string) {
+	var state int64
+	if open {
+		state = 1
+	}
+	metrics.NewGauge("neo4j/circuit_open").Update(state)
+	if db.onCircuit != nil {
+		db.onCircuit(open, reason)
+	}
+}
 
-	return session.ExecuteRead(ctx, work)
+// startProbe periodically checks that Neo4j is reachable, and reconnects
+// when it's not
+func (db *Neo4jDb) startProbe() {
+	interval := durationOr(db.config.Neo4j.ProbeInterval, 10*time.Second)
+	stop := make(chan struct{})
+	db.stop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				db.probe(interval)
+			case <-stop:
+				return
+			}
+		}
+	}()
 }
 
-func (db *Neo4jDb) ExecuteWrite(work func(tx neo4j.ManagedTransaction) (any, error)) (any, error) {
-	ctx := context.Background()
+func (db *Neo4jDb) probe(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	session := db.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeRead})
-	defer session.Close(ctx)
+	err := db.Ping(ctx)
+	if err == nil {
+		db.recordSuccess()
+		return
+	}
 
-	return session.ExecuteWrite(ctx, work)
+	db.recordFailure(err)
+	logger.Warn("Neo4j liveness probe failed, reconnecting", "err", err)
+	if err := db.reconnect(ctx); err != nil {
+		logger.Warn("Failed to reconnect to neo4j", "err", err)
+		return
+	}
+	logger.Info("Reconnected to neo4j")
+	db.recordSuccess()
 }
 
-func (db *Neo4jDb) Run(cypher string, params map[string]any) (neo4j.ResultWithContext, error) {
-	ctx := context.Background()
+// reconnect replaces the driver by a new one, once it can reach Neo4j
+func (db *Neo4jDb) reconnect(ctx context.Context) error {
+	driver, err := db.newDriver()
+	if err != nil {
+		return err
+	}
+	if err := driver.VerifyConnectivity(ctx); err != nil {
+		driver.Close(ctx)
+		return err
+	}
+
+	db.mu.Lock()
+	old := db.driver
+	db.driver = driver
+	db.mu.Unlock()
 
-	session := db.driver.NewSession(ctx, neo4j.SessionConfig{AccessMode: neo4j.AccessModeWrite})
+	if old != nil {
+		old.Close(ctx)
+	}
+	metrics.NewCounter("neo4j/reconnects").Inc(1)
+	return nil
+}
 
-	return session.Run(ctx, cypher, params)
+// isTransient tells if an error may go away by retrying, e.g. while Neo4j
+// restarts or a cluster elects a new leader
+func isTransient(err error) bool {
+	if neo4j.IsRetryable(err) || neo4j.IsConnectivityError(err) || neo4j.IsTransactionExecutionLimit(err) {
+		return true
+	}
+	var neo4jErr *neo4j.Neo4jError
+	return errors.As(err, &neo4jErr) && strings.HasPrefix(neo4jErr.Code, "Neo.TransientError.")
+}
+
+func durationOr(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
 }
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(2, time.Minute)
+
+	assert.False(t, b.failure(now))
+	assert.True(t, b.allow(now))
+	assert.True(t, b.failure(now))
+	assert.False(t, b.allow(now.Add(time.Second)))
+
+	// a trial is let through after the cooldown, failing it opens again
+	assert.True(t, b.allow(now.Add(time.Minute)))
+	assert.False(t, b.failure(now.Add(time.Minute)))
+	assert.False(t, b.allow(now.Add(time.Minute+time.Second)))
+
+	assert.True(t, b.success())
+	assert.True(t, b.allow(now.Add(time.Minute+time.Second)))
+	assert.False(t, b.success())
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(&neo4j.Neo4jError{Code: "Neo.TransientError.General.DatabaseUnavailable"}))
+	assert.True(t, isTransient(&neo4j.TransactionExecutionLimit{}))
+	assert.False(t, isTransient(&neo4j.Neo4jError{Code: "Neo.ClientError.Statement.SyntaxError"}))
+	assert.False(t, isTransient(errors.New("boom")))
+}
+
+func TestQueryContext(t *testing.T) {
+	db := NewNeo4jDb(&types.Config{})
+	ctx, cancel := db.queryContext(context.Background())
+	defer cancel()
+	assert.NoError(t, ctx.Err())
+
+	// closing the database cancels the queries in flight
+	db.Close()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("query wasn't cancelled")
+	}
+	_, err := db.ExecuteRead(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestTxConfig(t *testing.T) {
+	config := &neo4j.TransactionConfig{}
+	for _, configure := range txConfig(context.Background(), time.Second) {
+		configure(config)
+	}
+	assert.Equal(t, time.Second, config.Timeout)
+	assert.Nil(t, config.Metadata)
+
+	config = &neo4j.TransactionConfig{}
+	for _, configure := range txConfig(correlation.With(context.Background(), "abc"), time.Second) {
+		configure(config)
+	}
+	assert.Equal(t, map[string]any{correlation.Key: "abc"}, config.Metadata)
+}
+
+func TestWithRetry(t *testing.T) {
+	db := NewNeo4jDb(&types.Config{Neo4j: types.Neo4jConfig{RetryBackoff: "1ms"}})
+	driver, err := neo4j.NewDriverWithContext("bolt://localhost:7687", neo4j.NoAuth())
+	assert.NoError(t, err)
+	db.setDriver(driver)
+	defer db.Close()
+
+	attempts := 0
+	op := func(ctx context.Context, driver neo4j.DriverWithContext) (any, error) {
+		attempts++
+		return nil, &neo4j.Neo4jError{Code: "Neo.TransientError.General.DatabaseUnavailable"}
+	}
+
+	_, err = db.withRetry(context.Background(), time.Second, 2, op)
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// auto-commit queries aren't retried
+	attempts = 0
+	_, err = db.withRetry(context.Background(), time.Second, 0, op)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
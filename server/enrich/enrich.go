@@ -0,0 +1,307 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var logger = log.New("module", "enrich")
+
+// cached annotations beyond this trigger a sweep of expired ones
+const maxCacheEntries = 10000
+
+// Step annotates a post using a third-party API
+type Step interface {
+	// Name labels the annotations of the step
+	Name() string
+	// Enrich returns an annotation of the post, empty if there's nothing to
+	// add
+	Enrich(ctx context.Context, post *nostr.Event) (string, error)
+}
+
+// Annotation is the output of a step for a post
+type Annotation struct {
+	Step string
+	Text string
+}
+
+type job struct {
+	step *guardedStep
+	post types.FeedEntry
+	key  string
+}
+
+type cached struct {
+	text      string
+	expiresAt time.Time
+}
+
+// Pool runs enrichment steps on its own workers, so that third-party APIs
+// never hold up digests: callers wait for annotations at most for a fixed
+// time, and steps finishing later are cached for the next digests.
+type Pool struct {
+	steps    []*guardedStep
+	jobs     chan job
+	wait     time.Duration
+	cacheTTL time.Duration
+
+	mu       sync.Mutex
+	cache    map[string]cached
+	inflight map[string]chan struct{}
+}
+
+// NewPool starts the workers of the configured steps. It returns nil if
+// enrichment is disabled or no step is.
+func NewPool(ctx context.Context, config types.EnrichmentConfig) *Pool {
+	if !config.Enabled {
+		return nil
+	}
+
+	var steps []*guardedStep
+	if conf := config.Summary; conf.Limits.Enabled {
+		steps = append(steps, guard(newSummary(conf), conf.Limits))
+	}
+	if conf := config.Translation; conf.Limits.Enabled {
+		steps = append(steps, guard(newTranslation(conf), conf.Limits))
+	}
+	if conf := config.Unfurl; conf.Enabled {
+		steps = append(steps, guard(newUnfurl(), conf))
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	p := newPool(steps, config.QueueSize, parseDurationOr(config.Wait, 2*time.Second), parseDurationOr(config.CacheTTL, 24*time.Hour))
+	workers := config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go p.work(ctx)
+	}
+	logger.Info("Started enrichment workers", "workers", workers, "steps", len(steps))
+	return p
+}
+
+func newPool(steps []*guardedStep, queueSize int, wait, cacheTTL time.Duration) *Pool {
+	return &Pool{
+		steps:    steps,
+		jobs:     make(chan job, queueSize),
+		wait:     wait,
+		cacheTTL: cacheTTL,
+		cache:    map[string]cached{},
+		inflight: map[string]chan struct{}{},
+	}
+}
+
+// Annotate returns the annotations of the posts by id. It waits for the
+// steps not cached yet until they finish, the configured wait is over or ctx
+// is done, whichever comes first.
+func (p *Pool) Annotate(ctx context.Context, feed []types.FeedEntry) map[string][]Annotation {
+	var pending []chan struct{}
+	for _, post := range feed {
+		for _, step := range p.steps {
+			if done := p.submit(step, post); done != nil {
+				pending = append(pending, done)
+			}
+		}
+	}
+
+	timeout := time.NewTimer(p.wait)
+	defer timeout.Stop()
+wait:
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-timeout.C:
+			metrics.NewCounter("enrich/late").Inc(1)
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	annotations := map[string][]Annotation{}
+	for _, post := range feed {
+		for _, step := range p.steps {
+			c, ok := p.cache[cacheKey(step, post)]
+			if ok && c.text != "" && now.Before(c.expiresAt) {
+				annotations[post.Id] = append(annotations[post.Id], Annotation{Step: step.Name(), Text: c.text})
+			}
+		}
+	}
+	return annotations
+}
+
+// submit queues the step for the post unless cached, and returns a channel
+// closed once it's done, or nil if there's nothing to wait for
+func (p *Pool) submit(step *guardedStep, post types.FeedEntry) chan struct{} {
+	key := cacheKey(step, post)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.cache[key]; ok && time.Now().Before(c.expiresAt) {
+		return nil
+	}
+	if done, ok := p.inflight[key]; ok {
+		return done
+	}
+
+	select {
+	case p.jobs <- job{step: step, post: post, key: key}:
+	default:
+		metrics.NewCounter("enrich/dropped").Inc(1)
+		logger.Debug("Enrichment queue is full, dropping step", "step", step.Name(), "id", post.Id)
+		return nil
+	}
+	done := make(chan struct{})
+	p.inflight[key] = done
+	return done
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for {
+		select {
+		case j := <-p.jobs:
+			text, ok := j.step.run(ctx, j.post)
+			p.finish(j.key, text, ok)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish caches the annotation of a step that ran, and wakes up those
+// waiting for it
+func (p *Pool) finish(key, text string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ok {
+		if len(p.cache) >= maxCacheEntries {
+			p.sweep(time.Now())
+		}
+		p.cache[key] = cached{text: text, expiresAt: time.Now().Add(p.cacheTTL)}
+	}
+	if done, found := p.inflight[key]; found {
+		close(done)
+		delete(p.inflight, key)
+	}
+}
+
+func (p *Pool) sweep(now time.Time) {
+	for key, c := range p.cache {
+		if !now.Before(c.expiresAt) {
+			delete(p.cache, key)
+		}
+	}
+}
+
+func cacheKey(step *guardedStep, post types.FeedEntry) string {
+	return step.Name() + ":" + post.Id
+}
+
+// guardedStep runs a step within its timeout, budget and circuit breaker
+type guardedStep struct {
+	Step
+	timeout time.Duration
+	budget  int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	calls       int
+	threshold   int
+	cooldown    time.Duration
+	failures    int
+	openUntil   time.Time
+}
+
+func guard(step Step, limits types.EnrichmentLimits) *guardedStep {
+	return &guardedStep{
+		Step:      step,
+		timeout:   parseDurationOr(limits.Timeout, 5*time.Second),
+		budget:    limits.Budget,
+		threshold: limits.BreakerThreshold,
+		cooldown:  parseDurationOr(limits.BreakerCooldown, 5*time.Minute),
+	}
+}
+
+// allow tells if the step may be called now, counting the call against the
+// budget of the hour
+func (g *guardedStep) allow(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Before(g.openUntil) {
+		return false
+	}
+	if now.Sub(g.windowStart) >= time.Hour {
+		g.windowStart, g.calls = now, 0
+	}
+	if g.budget > 0 && g.calls >= g.budget {
+		return false
+	}
+	g.calls++
+	return true
+}
+
+func (g *guardedStep) record(now time.Time, failed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !failed {
+		g.failures = 0
+		return
+	}
+	g.failures++
+	if g.threshold > 0 && g.failures >= g.threshold {
+		logger.Warn("Enrichment step keeps failing, pausing it", "step", g.Name(), "failures", g.failures, "cooldown", g.cooldown)
+		g.openUntil = now.Add(g.cooldown)
+		g.failures = 0
+	}
+}
+
+// run calls the step on the post, and tells if it did so successfully
+func (g *guardedStep) run(ctx context.Context, post types.FeedEntry) (string, bool) {
+	if !g.allow(time.Now()) {
+		metrics.NewCounter("enrich/" + g.Name() + "/skipped").Inc(1)
+		return "", false
+	}
+
+	var event nostr.Event
+	if err := json.Unmarshal([]byte(post.Raw), &event); err != nil {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	start := time.Now()
+	text, err := g.Enrich(ctx, &event)
+	metrics.NewHistogram("enrich/" + g.Name() + "/latency").Update(time.Since(start).Milliseconds())
+	g.record(time.Now(), err != nil)
+	if err != nil {
+		metrics.NewCounter("enrich/" + g.Name() + "/failed").Inc(1)
+		logger.Debug("Enrichment step failed", "step", g.Name(), "id", post.Id, "err", err)
+		return "", false
+	}
+	return text, true
+}
+
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
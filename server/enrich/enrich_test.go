@@ -0,0 +1,111 @@
+package enrich
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStep struct {
+	name  string
+	delay time.Duration
+	err   error
+	calls int
+}
+
+func (f *fakeStep) Name() string {
+	return f.name
+}
+
+func (f *fakeStep) Enrich(ctx context.Context, post *nostr.Event) (string, error) {
+	f.calls++
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return f.name + " of " + post.Content, f.err
+}
+
+func feedOf(content string) []types.FeedEntry {
+	raw, _ := json.Marshal(nostr.Event{ID: "id", Content: content})
+	return []types.FeedEntry{{Id: "id", Raw: string(raw)}}
+}
+
+func TestAnnotate(t *testing.T) {
+	limits := types.EnrichmentLimits{Timeout: "1s"}
+	fast := &fakeStep{name: "fast"}
+	slow := &fakeStep{name: "slow", delay: 200 * time.Millisecond}
+	p := newPool([]*guardedStep{guard(fast, limits), guard(slow, limits)}, 10, 50*time.Millisecond, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.work(ctx)
+	go p.work(ctx)
+
+	// the slow step doesn't hold up the digest
+	start := time.Now()
+	annotations := p.Annotate(ctx, feedOf("gm"))
+	assert.Less(t, time.Since(start), 150*time.Millisecond)
+	assert.Equal(t, []Annotation{{Step: "fast", Text: "fast of gm"}}, annotations["id"])
+
+	// but it's cached for the next digest
+	time.Sleep(250 * time.Millisecond)
+	annotations = p.Annotate(ctx, feedOf("gm"))
+	assert.Len(t, annotations["id"], 2)
+	assert.Equal(t, 1, slow.calls)
+}
+
+func TestGuardedStep(t *testing.T) {
+	failing := &fakeStep{name: "failing", err: errors.New("boom")}
+	g := guard(failing, types.EnrichmentLimits{Timeout: "1s", Budget: 3, BreakerThreshold: 2, BreakerCooldown: "1m"})
+	post := feedOf("gm")[0]
+
+	_, ok := g.run(context.Background(), post)
+	assert.False(t, ok)
+	g.run(context.Background(), post)
+	// the breaker is open
+	g.run(context.Background(), post)
+	assert.Equal(t, 2, failing.calls)
+
+	g.openUntil = time.Time{}
+	g.run(context.Background(), post)
+	// the budget of the hour is spent
+	g.openUntil = time.Time{}
+	g.run(context.Background(), post)
+	assert.Equal(t, 3, failing.calls)
+}
+
+func TestUnfurl(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>
+			Nostr &amp; friends
+		</title></head></html>`))
+	}))
+	defer server.Close()
+
+	u := &unfurl{client: server.Client()}
+	text, err := u.Enrich(context.Background(), &nostr.Event{Content: "read this " + server.URL + "/post"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Nostr & friends (127.0.0.1)", text)
+
+	// the sandboxed client refuses local addresses
+	_, err = newUnfurl().Enrich(context.Background(), &nostr.Event{Content: server.URL})
+	assert.ErrorIs(t, err, errPrivateAddress)
+}
+
+func TestPageTitle(t *testing.T) {
+	assert.Equal(t, "Open Graph", pageTitle([]byte(`<title>Title</title><meta property="og:title" content="Open Graph">`)))
+	assert.Equal(t, "", pageTitle([]byte(`<p>no title</p>`)))
+	assert.False(t, isPublic(net.ParseIP("10.0.0.1")))
+	assert.True(t, isPublic(net.ParseIP("1.1.1.1")))
+}
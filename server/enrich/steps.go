@@ -0,0 +1,246 @@
+package enrich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// bytes of a linked page read to find its title
+	maxPageSize = 256 * 1024
+	// longest annotation kept, in runes
+	maxAnnotationLength = 280
+)
+
+var (
+	linkPattern  = regexp.MustCompile(`https?://[^\s<>"]+`)
+	ogTitle      = regexp.MustCompile(`(?is)<meta[^>]+property=["']og:title["'][^>]+content=["']([^"']+)["']`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+	errPrivateAddress = errors.New("address is not public")
+)
+
+// summary summarizes long posts with an OpenAI compatible chat completions
+// API
+type summary struct {
+	conf   types.SummaryConfig
+	client *http.Client
+}
+
+func newSummary(conf types.SummaryConfig) *summary {
+	return &summary{conf: conf, client: http.DefaultClient}
+}
+
+func (s *summary) Name() string {
+	return "summary"
+}
+
+func (s *summary) Enrich(ctx context.Context, post *nostr.Event) (string, error) {
+	if len([]rune(post.Content)) < s.conf.MinLength {
+		return "", nil
+	}
+
+	req := map[string]any{
+		"model": s.conf.Model,
+		"messages": []map[string]string{
+			{"role": "system", "content": "Summarize the following note in a single sentence, in the language it's written in."},
+			{"role": "user", "content": post.Content},
+		},
+		"max_tokens": 100,
+	}
+	var resp struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := postJSON(ctx, s.client, s.conf.URL, s.conf.APIKey, req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("no summary returned")
+	}
+	return truncate(resp.Choices[0].Message.Content), nil
+}
+
+// translation translates posts not written in the target language with a
+// LibreTranslate compatible API
+type translation struct {
+	conf   types.TranslationConfig
+	client *http.Client
+}
+
+func newTranslation(conf types.TranslationConfig) *translation {
+	return &translation{conf: conf, client: http.DefaultClient}
+}
+
+func (t *translation) Name() string {
+	return "translation"
+}
+
+func (t *translation) Enrich(ctx context.Context, post *nostr.Event) (string, error) {
+	if strings.TrimSpace(post.Content) == "" {
+		return "", nil
+	}
+
+	req := map[string]string{
+		"q":       post.Content,
+		"source":  "auto",
+		"target":  t.conf.Target,
+		"format":  "text",
+		"api_key": t.conf.APIKey,
+	}
+	var resp struct {
+		TranslatedText   string `json:"translatedText"`
+		DetectedLanguage struct {
+			Language string `json:"language"`
+		} `json:"detectedLanguage"`
+	}
+	if err := postJSON(ctx, t.client, t.conf.URL, "", req, &resp); err != nil {
+		return "", err
+	}
+	if resp.DetectedLanguage.Language == t.conf.Target {
+		return "", nil
+	}
+	return truncate(resp.TranslatedText), nil
+}
+
+// unfurl previews the first link of posts by the title of the page. Links
+// are untrusted, so only public addresses are fetched.
+type unfurl struct {
+	client *http.Client
+}
+
+func newUnfurl() *unfurl {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	return &unfurl{
+		client: &http.Client{
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 3 {
+					return errors.New("too many redirects")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+func (u *unfurl) Name() string {
+	return "link"
+}
+
+func (u *unfurl) Enrich(ctx context.Context, post *nostr.Event) (string, error) {
+	link := linkPattern.FindString(post.Content)
+	if link == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return "", nil
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return "", err
+	}
+	title := pageTitle(page)
+	if title == "" {
+		return "", nil
+	}
+	return truncate(fmt.Sprintf("%s (%s)", title, parsed.Hostname())), nil
+}
+
+// pageTitle reads the Open Graph title of a page, or its title element
+func pageTitle(page []byte) string {
+	for _, pattern := range []*regexp.Regexp{ogTitle, titlePattern} {
+		if m := pattern.FindSubmatch(page); m != nil {
+			if title := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " "); title != "" {
+				return title
+			}
+		}
+	}
+	return ""
+}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+func postJSON(ctx context.Context, client *http.Client, endpoint, apiKey string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxPageSize)).Decode(out)
+}
+
+func truncate(text string) string {
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	if len(runes) <= maxAnnotationLength {
+		return text
+	}
+	return string(runes[:maxAnnotationLength-1]) + "…"
+}
@@ -0,0 +1,186 @@
+// Package fixtures generates synthetic users, follow graphs, posts and
+// engagement to validate ranking behavior at scale. Popularity follows a Zipf
+// distribution so that a few users attract most follows and engagement, as on
+// the real network. The same seed always generates the same graph.
+package fixtures
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type Config struct {
+	Seed  int64
+	Users int
+	Posts int
+	// average number of accounts each user follows
+	FollowsPerUser int
+	// average number of interactions each post gets
+	EngagementPerPost int
+	// Zipf exponent of user popularity, must be greater than 1. Higher values
+	// concentrate follows and engagement on fewer users.
+	Skew float64
+	// share of interactions that are replies and zaps, the rest are likes
+	ReplyShare float64
+	ZapShare   float64
+	// posts are created within (End - Span, End)
+	End  time.Time
+	Span time.Duration
+}
+
+// DefaultConfig is a small network suitable for unit tests
+func DefaultConfig() Config {
+	return Config{
+		Seed:              1,
+		Users:             200,
+		Posts:             1000,
+		FollowsPerUser:    20,
+		EngagementPerPost: 5,
+		Skew:              1.5,
+		ReplyShare:        0.2,
+		ZapShare:          0.1,
+		End:               time.Unix(1680000000, 0),
+		Span:              24 * time.Hour,
+	}
+}
+
+const (
+	Like  = "like"
+	Reply = "reply"
+	Zap   = "zap"
+)
+
+type Post struct {
+	Id        string
+	Author    string
+	CreatedAt time.Time
+}
+
+type Engagement struct {
+	Id     string
+	User   string
+	PostId string
+	Kind   string
+	// sats, for zaps only
+	Amount    int64
+	CreatedAt time.Time
+}
+
+type Graph struct {
+	// ordered by popularity, most popular first
+	Users       []string
+	Follows     map[string][]string
+	Posts       []Post
+	Engagements []Engagement
+
+	config Config
+}
+
+// Generate builds a synthetic graph. Authors of posts and targets of follows
+// and engagement are drawn by popularity, the acting users uniformly.
+func Generate(config Config) *Graph {
+	r := rand.New(rand.NewSource(config.Seed))
+	popular := rand.NewZipf(r, config.Skew, 1, uint64(config.Users-1))
+
+	g := &Graph{Follows: map[string][]string{}, config: config}
+	for i := 0; i < config.Users; i++ {
+		g.Users = append(g.Users, id("user", config.Seed, i))
+	}
+
+	for _, u := range g.Users {
+		followed := map[string]bool{}
+		n := r.Intn(2*config.FollowsPerUser + 1)
+		for tries := 0; len(followed) < n && tries < 4*n; tries++ {
+			f := g.Users[popular.Uint64()]
+			if f != u && !followed[f] {
+				followed[f] = true
+				g.Follows[u] = append(g.Follows[u], f)
+			}
+		}
+	}
+
+	start := config.End.Add(-config.Span)
+	for i := 0; i < config.Posts; i++ {
+		g.Posts = append(g.Posts, Post{
+			Id:        id("post", config.Seed, i),
+			Author:    g.Users[popular.Uint64()],
+			CreatedAt: start.Add(time.Duration(r.Int63n(int64(config.Span)))),
+		})
+	}
+
+	for i := 0; i < config.Posts*config.EngagementPerPost; i++ {
+		post := g.Posts[r.Intn(len(g.Posts))]
+		e := Engagement{
+			Id:        id("engagement", config.Seed, i),
+			User:      g.Users[r.Intn(len(g.Users))],
+			PostId:    post.Id,
+			Kind:      Like,
+			CreatedAt: post.CreatedAt.Add(time.Duration(r.Int63n(int64(config.End.Sub(post.CreatedAt)) + 1))),
+		}
+		switch p := r.Float64(); {
+		case p < config.ZapShare:
+			e.Kind = Zap
+			e.Amount = 1 + r.Int63n(1000)
+		case p < config.ZapShare+config.ReplyShare:
+			e.Kind = Reply
+		}
+		g.Engagements = append(g.Engagements, e)
+	}
+
+	return g
+}
+
+// Events renders contact lists, posts, likes and replies as nostr events,
+// e.g. to feed them through the service. Contact lists predate all posts.
+// Zaps are left out as receipts need a bolt11 invoice. Events are not signed.
+func (g *Graph) Events() []nostr.Event {
+	events := []nostr.Event{}
+	for i, u := range g.Users {
+		tags := nostr.Tags{}
+		for _, f := range g.Follows[u] {
+			tags = append(tags, nostr.Tag{"p", f})
+		}
+		events = append(events, nostr.Event{
+			ID:        id("contacts", g.config.Seed, i),
+			PubKey:    u,
+			Kind:      3,
+			Tags:      tags,
+			CreatedAt: g.config.End.Add(-g.config.Span),
+		})
+	}
+
+	authors := map[string]string{}
+	for _, p := range g.Posts {
+		authors[p.Id] = p.Author
+		events = append(events, nostr.Event{
+			ID:        p.Id,
+			PubKey:    p.Author,
+			Kind:      1,
+			Content:   fmt.Sprintf("synthetic post %s", p.Id[:8]),
+			Tags:      nostr.Tags{},
+			CreatedAt: p.CreatedAt,
+		})
+	}
+
+	for _, e := range g.Engagements {
+		tags := nostr.Tags{{"e", e.PostId}, {"p", authors[e.PostId]}}
+		switch e.Kind {
+		case Like:
+			events = append(events, nostr.Event{ID: e.Id, PubKey: e.User, Kind: 7, Content: "+", Tags: tags, CreatedAt: e.CreatedAt})
+		case Reply:
+			events = append(events, nostr.Event{ID: e.Id, PubKey: e.User, Kind: 1, Content: "synthetic reply", Tags: tags, CreatedAt: e.CreatedAt})
+		}
+	}
+	return events
+}
+
+// id derives a stable 32-byte hex id
+func id(prefix string, seed int64, i int) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s-%d-%d", prefix, seed, i)))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,40 @@
+package fixtures
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	config := DefaultConfig()
+	g := Generate(config)
+
+	assert.Len(t, g.Users, config.Users)
+	assert.Len(t, g.Posts, config.Posts)
+	assert.Len(t, g.Engagements, config.Posts*config.EngagementPerPost)
+	assert.Equal(t, g, Generate(config))
+
+	// popularity is skewed towards the first users
+	followers := map[string]int{}
+	for u, follows := range g.Follows {
+		assert.NotContains(t, follows, u)
+		for _, f := range follows {
+			followers[f]++
+		}
+	}
+	assert.Greater(t, followers[g.Users[0]], 10*followers[g.Users[len(g.Users)-1]]+1)
+
+	start := config.End.Add(-config.Span)
+	for _, p := range g.Posts {
+		assert.True(t, p.CreatedAt.After(start) || p.CreatedAt.Equal(start))
+		assert.True(t, p.CreatedAt.Before(config.End))
+	}
+
+	kinds := map[int]int{}
+	for _, ev := range g.Events() {
+		kinds[ev.Kind]++
+	}
+	assert.Equal(t, config.Users, kinds[3])
+	assert.Greater(t, kinds[7], kinds[1]-config.Posts)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+)
+
+// Registry holds all metrics exported by nossence
+var Registry = metrics.NewRegistry()
+
+func init() {
+	// metrics of go-ethereum are disabled unless --metrics is passed
+	metrics.Enabled = true
+}
+
+// Handler serves the registry in Prometheus text format
+func Handler() http.Handler {
+	return prometheus.Handler(Registry)
+}
+
+// NewHistogram registers a histogram backed by an exponentially decaying
+// sample, which favors recent observations
+func NewHistogram(name string) metrics.Histogram {
+	return metrics.GetOrRegisterHistogram(name, Registry, metrics.NewExpDecaySample(1028, 0.015))
+}
+
+func NewCounter(name string) metrics.Counter {
+	return metrics.GetOrRegisterCounter(name, Registry)
+}
+
+func NewGauge(name string) metrics.Gauge {
+	return metrics.GetOrRegisterGauge(name, Registry)
+}
+
+func NewGaugeFloat64(name string) metrics.GaugeFloat64 {
+	return metrics.GetOrRegisterGaugeFloat64(name, Registry)
+}
@@ -0,0 +1,205 @@
+package nostr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/service"
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// a page the relay hasn't finished sending by then is fetched again
+	backfillPageTimeout = 30 * time.Second
+	// reconnecting to a relay backs off up to this
+	maxBackfillBackoff = 5 * time.Minute
+)
+
+// Backfill pages backward through the history of a relay, from now to
+// Backfill.Since ago, and stores its events. Progress is checkpointed per
+// relay: the checkpoint ends at the oldest page fetched and its window spans
+// what's left, so an interrupted backfill resumes where it stopped and a
+// finished one isn't repeated by later deployments. The backfill is only
+// done once the relay sends an empty page, pages cut short by a dropped
+// connection are fetched again after reconnecting.
+func (c *Crawler) Backfill(ctx context.Context, url string, now time.Time) error {
+	conf := c.config.Crawler.Backfill
+	name := "backfill/" + url
+	checkpoint, err := c.service.GetCheckpoint(ctx, name)
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil {
+		checkpoint = &types.Checkpoint{Name: name, End: now, Window: -parseTimeOffset(conf.Since)}
+	}
+	if checkpoint.Done {
+		log.Debug("Relay already backfilled", "url", url)
+		return nil
+	}
+
+	pause, err := time.ParseDuration(conf.Pause)
+	if err != nil {
+		return err
+	}
+
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer func() { relay.Close() }()
+
+	since := checkpoint.End.Add(-checkpoint.Window)
+	backoff := pause
+	log.Info("Backfilling relay", "url", url, "since", since, "until", checkpoint.End)
+	for !checkpoint.Done {
+		until := checkpoint.End
+		filter := c.filter()
+		filter.Since, filter.Until, filter.Limit = &since, &until, conf.Limit
+		events, err := queryPage(ctx, relay, filter)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			log.Warn("Backfill page incomplete, reconnecting", "url", url, "until", until, "backoff", backoff, "err", err)
+			next, err := reconnect(ctx, relay, url, backoff)
+			if err != nil {
+				return err
+			}
+			relay = next
+			if backoff *= 2; backoff > maxBackfillBackoff {
+				backoff = maxBackfillBackoff
+			}
+			continue
+		}
+		backoff = pause
+
+		for _, ev := range events {
+			// the page is queried again once resumed
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !c.Matches(ev) {
+				continue
+			}
+			if err := c.rate.wait(ctx); err != nil {
+				return err
+			}
+			ctx := correlation.With(ctx, correlation.New())
+			logger := correlation.Logger(ctx, log.Root())
+			err := c.service.StoreEventFromRelay(ctx, ev, url)
+			if errors.Is(err, service.ErrEventLimit) {
+				logger.Debug("Dropped event over limits", "id", ev.ID, "url", url, "err", err)
+			} else if err != nil {
+				logger.Error("Failed to store event", "event", ev, "err", err)
+			}
+		}
+
+		next := nextUntil(events, until, since)
+		checkpoint.End, checkpoint.Window = next, next.Sub(since)
+		checkpoint.Offset += len(events)
+		checkpoint.Done = !next.After(since)
+		if err := c.service.SaveCheckpoint(ctx, *checkpoint); err != nil {
+			log.Warn("Failed to save backfill checkpoint", "url", url, "err", err)
+		}
+		log.Debug("Backfilled page", "url", url, "until", until, "events", len(events))
+
+		if !checkpoint.Done {
+			select {
+			case <-time.After(pause):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	log.Info("Relay backfilled", "url", url, "events", checkpoint.Offset)
+	return nil
+}
+
+// nextUntil returns the upper bound of the page after events, or since if
+// the page was the last one. Only an empty page is the last one, as relays
+// may send fewer events than asked for. Pages overlap by the second of their
+// oldest event, whose events may not all fit in one page, as duplicates are
+// dropped when stored. A page within a single second moves on to the second
+// before.
+func nextUntil(events []*nostr.Event, until, since time.Time) time.Time {
+	if len(events) == 0 {
+		return since
+	}
+
+	oldest := until
+	for _, ev := range events {
+		if ev.CreatedAt.Before(oldest) {
+			oldest = ev.CreatedAt
+		}
+	}
+	if !oldest.Before(until) {
+		oldest = until.Add(-time.Second)
+	}
+	if oldest.Before(since) {
+		return since
+	}
+	return oldest
+}
+
+// queryPage fetches the stored events matching filter. Unlike QuerySync, it
+// fails unless the relay ends the page with EOSE, so that a page cut short by
+// a dropped connection or a timeout isn't taken for the end of history.
+func queryPage(ctx context.Context, relay *nostr.Relay, filter nostr.Filter) ([]*nostr.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, backfillPageTimeout)
+	defer cancel()
+
+	sub := relay.PrepareSubscription(ctx)
+	sub.Filters = nostr.Filters{filter}
+	if err := sub.Fire(); err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+
+	events := []*nostr.Event{}
+	for {
+		select {
+		case ev := <-sub.Events:
+			if ev == nil {
+				if err := ctx.Err(); err != nil {
+					return events, err
+				}
+				return events, fmt.Errorf("connection closed: %v", relay.ConnectionError)
+			}
+			events = append(events, ev)
+		case <-sub.EndOfStoredEvents:
+			return events, nil
+		case <-relay.ConnectionContext.Done():
+			return events, fmt.Errorf("connection closed: %v", relay.ConnectionError)
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+}
+
+// reconnect replaces a failed connection to the relay, waiting between
+// attempts until ctx is done
+func reconnect(ctx context.Context, relay *nostr.Relay, url string, wait time.Duration) (*nostr.Relay, error) {
+	relay.Close()
+	for {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		next, err := nostr.RelayConnect(ctx, url)
+		if err == nil {
+			return next, nil
+		}
+		log.Warn("Failed to reconnect relay", "url", url, "err", err)
+		if wait *= 2; wait > maxBackfillBackoff {
+			wait = maxBackfillBackoff
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRelay answers each REQ with the event, then ends the page with EOSE or
+// drops the connection
+func fakeRelay(t *testing.T, event *nostr.Event, eose bool) string {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var req []json.RawMessage
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			var typ, id string
+			json.Unmarshal(req[0], &typ)
+			if typ != "REQ" {
+				continue
+			}
+			json.Unmarshal(req[1], &id)
+			conn.WriteJSON([]any{"EVENT", id, event})
+			if !eose {
+				return
+			}
+			conn.WriteJSON([]any{"EOSE", id})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestQueryPage(t *testing.T) {
+	ctx := context.Background()
+	sk := nostr.GeneratePrivateKey()
+	event := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: time.Unix(1000, 0), Tags: nostr.Tags{}}
+	event.PubKey, _ = nostr.GetPublicKey(sk)
+	assert.NoError(t, event.Sign(sk))
+
+	relay, err := nostr.RelayConnect(ctx, fakeRelay(t, event, true))
+	assert.NoError(t, err)
+	events, err := queryPage(ctx, relay, nostr.Filter{Kinds: []int{1}})
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, event.ID, events[0].ID)
+	}
+
+	// a page cut short by a dropped connection isn't complete
+	relay, err = nostr.RelayConnect(ctx, fakeRelay(t, event, false))
+	assert.NoError(t, err)
+	_, err = queryPage(ctx, relay, nostr.Filter{Kinds: []int{1}})
+	assert.Error(t, err)
+}
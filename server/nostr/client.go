@@ -4,26 +4,57 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/dyng/nosdaily/supervisor"
 	"github.com/dyng/nosdaily/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip04"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"golang.org/x/exp/slices"
 )
 
 var logger = log.New("module", "nostr")
 
 type Client struct {
 	Relays map[string]*nostr.Relay
+	// Fanout limits digest reposts to the given number of lowest-latency
+	// relays, at least minFanout, zero means publishing to all relays
+	Fanout  int
+	latency *latencyTable
+	// when set, all events are published to this relay only, which fans
+	// them out and keeps an archive of everything published
+	proxy *nostr.Relay
+	// limitations advertised by relays, REQs and publishes adapt to them
+	limits     map[string]relayLimits
+	pacer      *pacer
+	admissions *admissions
+	// issues delegations to channel keys if set
+	delegator *delegator
+	// restarts relay subscriptions when their connection drops
+	supervisor *supervisor.Supervisor
+	// remote signers of keys given as bunker URIs
+	signerConf types.RemoteSignerConfig
+	signersMu  sync.Mutex
+	signers    map[string]*remoteSigner
 }
 
 type IClient interface {
 	Subscribe(ctx context.Context, filters []nostr.Filter) <-chan nostr.Event
+	Query(ctx context.Context, filter nostr.Filter) []nostr.Event
 	Repost(ctx context.Context, sk, id, author, raw string) error
 	Mention(ctx context.Context, sk, msg string, mentions []string) error
+	Note(ctx context.Context, sk, msg string) (string, error)
+	SendMessage(ctx context.Context, sk, receiverPub, msg string) error
+	GiftWrap(ctx context.Context, sk, receiverPub, msg string) error
+	Decrypt(ctx context.Context, sk, senderPub, content string) (string, error)
+	RevokeDelegation(channelPub string)
 	Metadata(ctx context.Context, sk, name, about, picture, nip05 string, relays []types.RelayInfo) error
+	HandlerInformation(ctx context.Context, sk, identifier, content string, kinds []int) error
+	LongForm(ctx context.Context, sk, identifier, title, summary, content string, mentions []string) error
 }
 
 func DecodeNsec(nsec string) (string, error) {
@@ -62,6 +93,7 @@ func DecodeNpub(npub string) (string, error) {
 
 func NewClient(ctx context.Context, uris []string) (*Client, error) {
 	rs := map[string]*nostr.Relay{}
+	limits := map[string]relayLimits{}
 	for _, uri := range uris {
 		r, err := nostr.RelayConnect(ctx, uri)
 		if err != nil {
@@ -69,73 +101,312 @@ func NewClient(ctx context.Context, uris []string) (*Client, error) {
 			continue
 		}
 		rs[uri] = r
+
+		l, err := fetchLimits(ctx, uri)
+		if err != nil {
+			logger.Debug("failed to fetch relay information, assuming no limits", "uri", uri, "err", err)
+		} else {
+			logger.Info("fetched relay limits", "uri", uri, "limits", l)
+		}
+		limits[uri] = l
 	}
 
 	return &Client{
-		Relays: rs,
+		Relays:     rs,
+		latency:    newLatencyTable(),
+		limits:     limits,
+		pacer:      newPacer(),
+		admissions: newAdmissions(types.PaymentsConfig{}, nil, ""),
+		supervisor: supervisor.New(types.SupervisorConfig{}),
 	}, nil
 }
 
 func (c *Client) Subscribe(ctx context.Context, filters []nostr.Filter) <-chan nostr.Event {
 	ch := make(chan nostr.Event)
 	for uri, r := range c.Relays {
-		logger.Info("subscribing to relay", "uri", uri)
-		sub := r.Subscribe(ctx, filters)
-
-		// FIXME: fragile, need to refactor
-		go func(uri string, relay *nostr.Relay, subscription *nostr.Subscription) {
-			for {
-				select {
-				case ev := <-subscription.Events:
-					if ev == nil {
-						logger.Debug("received nil event, channel may closed", "uri", uri)
-					} else {
-						ch <- *ev
-					}
-				case notice := <-relay.Notices:
-					logger.Warn("relay notice", "uri", uri, "notice", notice)
-				case <-relay.ConnectionContext.Done():
-					err := relay.ConnectionError
-					logger.Error("relay connection error, try to reconnect", "uri", uri, "err", err)
-
-					// try to reconnect for at most 5 times
-					reconnected := c.reconnect(relay, 5)
-					if !reconnected {
-						logger.Error("failed to reconnect to relay, closing channel", "uri", uri)
-						return
-					}
-
-					subscription = relay.Subscribe(ctx, filters)
-					logger.Info("reconnected to relay", "uri", uri)
-				}
-			}
-		}(uri, r, sub)
+		c.subscribe(ctx, ch, uri, r, batchFilters(filters, c.limits[uri]))
 	}
 
 	return ch
 }
 
-// Publish a signed event to all relays
-func (c *Client) Publish(ctx context.Context, ev nostr.Event) error {
+// subscribe sends one REQ per batch of filters to the relay. The relay is
+// supervised: once its connection drops, it's reconnected with backoff and
+// all of them are subscribed again since the last event received from it, so
+// that events aren't delivered all over again. Events of the second of the
+// last one are delivered again, and left to consumers to de-duplicate.
+func (c *Client) subscribe(ctx context.Context, ch chan<- nostr.Event, uri string, r *nostr.Relay, batches []nostr.Filters) {
+	logger.Info("subscribing to relay", "uri", uri, "reqs", len(batches))
+	var mu sync.Mutex
+	var lastSeen time.Time
+	forward := func(subscription *nostr.Subscription) {
+		for ev := range subscription.Events {
+			if ev == nil {
+				logger.Debug("received nil event, channel may closed", "uri", uri)
+				continue
+			}
+			mu.Lock()
+			if ev.CreatedAt.After(lastSeen) {
+				lastSeen = ev.CreatedAt
+			}
+			mu.Unlock()
+			ch <- *ev
+		}
+	}
+
+	c.supervisor.Go(ctx, "relay "+uri, func(ctx context.Context) error {
+		if r.ConnectionContext.Err() != nil {
+			if err := r.Connect(context.Background()); err != nil {
+				return fmt.Errorf("failed to reconnect: %w", err)
+			}
+			logger.Info("reconnected to relay", "uri", uri)
+		}
+		// subscriptions of a stopped run are closed, the next one subscribes
+		// again
+		mu.Lock()
+		since := lastSeen
+		mu.Unlock()
+		for _, filters := range batches {
+			sub := r.Subscribe(ctx, resumeFilters(filters, since))
+			defer sub.Unsub()
+			go forward(sub)
+		}
+
+		for {
+			select {
+			case notice := <-r.Notices:
+				logger.Warn("relay notice", "uri", uri, "notice", notice)
+			case <-r.ConnectionContext.Done():
+				return fmt.Errorf("connection lost: %v", r.ConnectionError)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+}
+
+// resumeFilters returns copies of filters starting no earlier than since,
+// unless it's zero
+func resumeFilters(filters nostr.Filters, since time.Time) nostr.Filters {
+	if since.IsZero() {
+		return filters
+	}
+	resumed := make(nostr.Filters, len(filters))
+	for i, f := range filters {
+		if f.Since == nil || f.Since.Before(since) {
+			f.Since = &since
+		}
+		resumed[i] = f
+	}
+	return resumed
+}
+
+// Query all relays for stored events matching the filter, deduplicated by id
+func (c *Client) Query(ctx context.Context, filter nostr.Filter) []nostr.Event {
+	seen := map[string]bool{}
+	events := []nostr.Event{}
 	for uri, r := range c.Relays {
-		status, err := r.Publish(ctx, ev)
+		for _, ev := range r.QuerySync(ctx, capLimit(filter, c.limits[uri])) {
+			if ev == nil || seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			events = append(events, *ev)
+		}
+		logger.Debug("queried relay", "uri", uri, "total", len(events))
+	}
+	return events
+}
+
+// SetProxy routes all publishes through the relay at uri. Subscriptions and
+// queries still go to all relays.
+func (c *Client) SetProxy(ctx context.Context, uri string) error {
+	r, err := nostr.RelayConnect(ctx, uri)
+	if err != nil {
+		return err
+	}
+	c.proxy = r
+	return nil
+}
+
+// SetSupervisor makes relay subscriptions report their incidents to s
+func (c *Client) SetSupervisor(s *supervisor.Supervisor) {
+	c.supervisor = s
+}
+
+// SetPayments configures how admission to relays requiring payment is paid,
+// payments are recorded under root unless a file is configured
+func (c *Client) SetPayments(conf types.PaymentsConfig, root string) error {
+	var payer invoicePayer
+	if conf.Enabled {
+		wc, err := parseWalletConnect(conf.NWC)
 		if err != nil {
-			logger.Debug("failed to publish event to relay, try to reconnect and resend", "uri", uri, "id", ev.ID, "err", err)
-			c.reconnect(r, 1)
-			status, err = r.Publish(ctx, ev)
+			return err
 		}
-		switch status {
-		case nostr.PublishStatusSucceeded:
-			logger.Debug("published event to relay", "uri", uri, "id", ev.ID)
-		case nostr.PublishStatusFailed:
-			logger.Error("failed to publish event to relay, skip", "uri", uri, "id", ev.ID, "err", err)
-		case nostr.PublishStatusSent:
-			logger.Warn("event may or may not published to relay", "uri", uri, "id", ev.ID, "err", err)
+		payer = wc
+	}
+
+	file := conf.File
+	if file == "" {
+		file = filepath.Join(root, "payments", "admissions.json")
+	}
+
+	onUnusable := c.admissions.onUnusable
+	c.admissions = newAdmissions(conf, payer, file)
+	c.admissions.onUnusable = onUnusable
+	return nil
+}
+
+// OnRelayUnusable registers a handler called when a relay can't be
+// published to for lack of payment
+func (c *Client) OnRelayUnusable(handler func(uri, reason string)) {
+	c.admissions.onUnusable = handler
+}
+
+// AdmitPaidRelays pays admission of relays advertising that payment is
+// required, see SetPayments
+func (c *Client) AdmitPaidRelays(ctx context.Context) {
+	for uri := range c.Relays {
+		if !c.limits[uri].PaymentRequired {
+			continue
+		}
+		if err := c.admissions.admit(ctx, uri, c.limits[uri]); err != nil {
+			logger.Warn("failed to pay relay admission", "uri", uri, "err", err)
+		}
+	}
+}
+
+// Publish a signed event to all relays
+func (c *Client) Publish(ctx context.Context, ev nostr.Event) error {
+	if c.proxy != nil {
+		return c.publishToProxy(ctx, ev)
+	}
+
+	return c.publishTo(ctx, ev, c.writableRelays())
+}
+
+// a single relay would be a single point of failure of delivery
+const minFanout = 2
+
+type preferredRelaysKey struct{}
+
+// WithPreferredRelays makes PublishFastest publish to these relays only, if
+// it's connected to any of them, e.g. the relays a subscriber reads their
+// channel from
+func WithPreferredRelays(ctx context.Context, relays []string) context.Context {
+	return context.WithValue(ctx, preferredRelaysKey{}, relays)
+}
+
+// PublishFastest publishes a signed event to the lowest-latency relays,
+// limited by Fanout, among the preferred relays of ctx if connected to any
+func (c *Client) PublishFastest(ctx context.Context, ev nostr.Event) error {
+	if c.proxy != nil {
+		return c.publishToProxy(ctx, ev)
+	}
+
+	uris := c.writableRelays()
+	if preferred, _ := ctx.Value(preferredRelaysKey{}).([]string); len(preferred) > 0 {
+		carrying := make([]string, 0, len(uris))
+		for _, uri := range uris {
+			if slices.Contains(preferred, uri) {
+				carrying = append(carrying, uri)
+			}
+		}
+		if len(carrying) > 0 {
+			uris = carrying
+		}
+	}
+
+	uris = c.latency.rank(uris)
+	fanout := c.Fanout
+	if fanout > 0 && fanout < minFanout {
+		fanout = minFanout
+	}
+	if fanout > 0 && fanout < len(uris) {
+		uris = uris[:fanout]
+	}
+	return c.publishTo(ctx, ev, uris)
+}
+
+// writableRelays skips relays requiring authentication, or payment that
+// wasn't made, unless none is left
+func (c *Client) writableRelays() []string {
+	all := make([]string, 0, len(c.Relays))
+	uris := make([]string, 0, len(c.Relays))
+	for uri := range c.Relays {
+		all = append(all, uri)
+		if c.admissions.usable(uri, c.limits[uri]) {
+			uris = append(uris, uri)
+		}
+	}
+	if len(uris) == 0 {
+		return all
+	}
+	return uris
+}
+
+// publishTo fails unless at least one relay accepted the event with an OK
+// message
+func (c *Client) publishTo(ctx context.Context, ev nostr.Event, uris []string) error {
+	accepted := false
+	var lastErr error
+	for _, uri := range uris {
+		status, err := c.publishToRelay(ctx, ev, uri, c.Relays[uri])
+		if status == nostr.PublishStatusSucceeded {
+			accepted = true
+		} else if err != nil {
+			lastErr = err
 		}
 	}
+	if !accepted {
+		return fmt.Errorf("no relay accepted event %s: %v", ev.ID, lastErr)
+	}
+	return nil
+}
+
+// publishToProxy fails if the proxy rejected the event, as no other relay
+// receives it
+func (c *Client) publishToProxy(ctx context.Context, ev nostr.Event) error {
+	status, err := c.publishToRelay(ctx, ev, c.proxy.URL, c.proxy)
+	if status == nostr.PublishStatusFailed {
+		return fmt.Errorf("proxy rejected event %s: %v", ev.ID, err)
+	}
 	return nil
 }
 
+func (c *Client) publishToRelay(ctx context.Context, ev nostr.Event, uri string, r *nostr.Relay) (nostr.Status, error) {
+	if err := c.pacer.wait(ctx, uri); err != nil {
+		return nostr.PublishStatusFailed, err
+	}
+
+	start := time.Now()
+	status, err := r.Publish(ctx, ev)
+	if status == nostr.PublishStatusSucceeded {
+		c.latency.observe(uri, time.Since(start))
+	}
+	if status != nostr.PublishStatusSent {
+		c.pacer.observe(uri, isRateLimited(err))
+	}
+	if isPaymentRequired(err) {
+		if admitErr := c.admissions.admit(ctx, uri, c.limits[uri]); admitErr == nil {
+			status, err = r.Publish(ctx, ev)
+		}
+	} else if err != nil && !isRateLimited(err) {
+		logger.Debug("failed to publish event to relay, try to reconnect and resend", "uri", uri, "id", ev.ID, "err", err)
+		c.reconnect(r, 1)
+		status, err = r.Publish(ctx, ev)
+	}
+	switch status {
+	case nostr.PublishStatusSucceeded:
+		logger.Debug("published event to relay", "uri", uri, "id", ev.ID)
+	case nostr.PublishStatusFailed:
+		logger.Error("failed to publish event to relay, skip", "uri", uri, "id", ev.ID, "err", err)
+	case nostr.PublishStatusSent:
+		logger.Warn("event may or may not published to relay", "uri", uri, "id", ev.ID, "err", err)
+	}
+	return status, err
+}
+
 func (c *Client) reconnect(relay *nostr.Relay, retries int) bool {
 	for i := 0; i < retries; i++ {
 		err := relay.Connect(context.Background())
@@ -153,7 +424,7 @@ func (c *Client) reconnect(relay *nostr.Relay, retries int) bool {
 func (c *Client) Repost(ctx context.Context, sk, eventID, authorPub, raw string) error {
 	note, _ := nip19.EncodeNote(eventID)
 	logger.Debug("reposting event", "event_id", eventID, "note", note, "author_pub", authorPub, "raw", raw)
-	pub, err := nostr.GetPublicKey(sk)
+	pub, err := PublicKey(sk)
 	if err != nil {
 		return err
 	}
@@ -177,16 +448,29 @@ func (c *Client) Repost(ctx context.Context, sk, eventID, authorPub, raw string)
 		CreatedAt: time.Now(),
 	}
 
-	err = ev.Sign(sk)
+	// events other than notes, e.g. long-form articles, are reposted with a
+	// NIP-18 generic repost
+	var origin nostr.Event
+	if err := json.Unmarshal([]byte(raw), &origin); err == nil && origin.Kind != 1 {
+		ev.Kind = 16
+		ev.Tags = append(ev.Tags, nostr.Tag{"k", strconv.Itoa(origin.Kind)})
+		if d := origin.Tags.GetFirst([]string{"d", ""}); d != nil {
+			ev.Tags = append(ev.Tags, nostr.Tag{"a", fmt.Sprintf("%d:%s:%s", origin.Kind, origin.PubKey, d.Value())})
+		}
+	}
+
+	err = c.sign(ctx, &ev, sk)
 	if err != nil {
 		return err
 	}
 
-	return c.Publish(ctx, ev)
+	return c.PublishFastest(ctx, ev)
 }
 
+// Mention publishes a note tagging the mentioned users. '#[i]' in msg
+// refers to mentions[i] and is published as a NIP-27 reference.
 func (c *Client) Mention(ctx context.Context, sk, msg string, mentions []string) error {
-	senderPub, err := nostr.GetPublicKey(sk)
+	senderPub, err := PublicKey(sk)
 	if err != nil {
 		return err
 	}
@@ -207,10 +491,10 @@ func (c *Client) Mention(ctx context.Context, sk, msg string, mentions []string)
 		CreatedAt: time.Now(),
 		Kind:      1,
 		Tags:      mentionTags,
-		Content:   msg,
+		Content:   MigrateMentions(msg, mentionTags),
 	}
 
-	err = ev.Sign(sk)
+	err = c.signAs(ctx, &ev, sk)
 	if err != nil {
 		return err
 	}
@@ -218,8 +502,34 @@ func (c *Client) Mention(ctx context.Context, sk, msg string, mentions []string)
 	return c.Publish(ctx, ev)
 }
 
+// Note publishes a note and returns its id
+func (c *Client) Note(ctx context.Context, sk, msg string) (string, error) {
+	senderPub, err := PublicKey(sk)
+	if err != nil {
+		return "", err
+	}
+
+	ev := nostr.Event{
+		PubKey:    senderPub,
+		CreatedAt: time.Now(),
+		Kind:      1,
+		Tags:      nostr.Tags{},
+		Content:   msg,
+	}
+
+	err = c.sign(ctx, &ev, sk)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.Publish(ctx, ev); err != nil {
+		return "", err
+	}
+	return ev.ID, nil
+}
+
 func (c *Client) Metadata(ctx context.Context, sk, name, about, picture, nip05 string, relays []types.RelayInfo) error {
-	senderPub, err := nostr.GetPublicKey(sk)
+	senderPub, err := PublicKey(sk)
 	if err != nil {
 		return err
 	}
@@ -247,7 +557,7 @@ func (c *Client) Metadata(ctx context.Context, sk, name, about, picture, nip05 s
 		Content:   string(contentJson),
 	}
 
-	err = ev.Sign(sk)
+	err = c.signAs(ctx, &ev, sk)
 	if err != nil {
 		return err
 	}
@@ -277,7 +587,7 @@ func (c *Client) Metadata(ctx context.Context, sk, name, about, picture, nip05 s
 		Tags:      tags,
 	}
 
-	err = ev.Sign(sk)
+	err = c.signAs(ctx, &ev, sk)
 	if err != nil {
 		return err
 	}
@@ -285,22 +595,85 @@ func (c *Client) Metadata(ctx context.Context, sk, name, about, picture, nip05 s
 	return c.Publish(ctx, ev)
 }
 
-// Sends a NIP-04 message
-func (c *Client) SendMessage(ctx context.Context, sk, receiverPub, msg string) error {
-	senderPub, err := nostr.GetPublicKey(sk)
+// Publish a NIP-89 handler information event, content is a kind 0 style
+// metadata JSON describing the handler
+func (c *Client) HandlerInformation(ctx context.Context, sk, identifier, content string, kinds []int) error {
+	senderPub, err := PublicKey(sk)
 	if err != nil {
 		return err
 	}
 
-	sharedKey, err := nip04.ComputeSharedSecret(receiverPub, sk)
+	tags := nostr.Tags{
+		nostr.Tag{"d", identifier},
+	}
+	for _, k := range kinds {
+		tags = append(tags, nostr.Tag{"k", strconv.Itoa(k)})
+	}
+
+	ev := nostr.Event{
+		PubKey:    senderPub,
+		CreatedAt: time.Now(),
+		Kind:      31990,
+		Tags:      tags,
+		Content:   content,
+	}
+
+	err = c.signAs(ctx, &ev, sk)
+	if err != nil {
+		return err
+	}
+
+	return c.Publish(ctx, ev)
+}
+
+// Publish a NIP-23 long-form article, identifier makes it replaceable
+func (c *Client) LongForm(ctx context.Context, sk, identifier, title, summary, content string, mentions []string) error {
+	senderPub, err := PublicKey(sk)
+	if err != nil {
+		return err
+	}
+
+	tags := nostr.Tags{
+		nostr.Tag{"d", identifier},
+		nostr.Tag{"title", title},
+		nostr.Tag{"summary", summary},
+		nostr.Tag{"published_at", strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	for _, m := range mentions {
+		tags = append(tags, nostr.Tag{"p", m})
+	}
+
+	ev := nostr.Event{
+		PubKey:    senderPub,
+		CreatedAt: time.Now(),
+		Kind:      30023,
+		Tags:      tags,
+		Content:   content,
+	}
+
+	err = c.sign(ctx, &ev, sk)
+	if err != nil {
+		return err
+	}
+
+	return c.Publish(ctx, ev)
+}
+
+// Sends a NIP-04 message
+func (c *Client) SendMessage(ctx context.Context, sk, receiverPub, msg string) error {
+	senderPub, err := PublicKey(sk)
 	if err != nil {
-		return fmt.Errorf("invalid receiver public key: %s", receiverPub)
+		return err
 	}
 
-	content, err := nip04.Encrypt(msg, sharedKey)
+	s, err := c.signer(sk)
 	if err != nil {
 		return err
 	}
+	content, err := s.Encrypt04(ctx, receiverPub, msg)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message to %s: %w", receiverPub, err)
+	}
 
 	ev := nostr.Event{
 		PubKey:    senderPub,
@@ -314,7 +687,7 @@ func (c *Client) SendMessage(ctx context.Context, sk, receiverPub, msg string) e
 		Content: content,
 	}
 
-	err = ev.Sign(sk)
+	err = c.signAs(ctx, &ev, sk)
 	if err != nil {
 		return err
 	}
@@ -2,26 +2,52 @@ package nostr
 
 import (
 	"context"
+	"errors"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/metrics"
 	"github.com/dyng/nosdaily/service"
 	"github.com/dyng/nosdaily/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
 )
 
+// kinds stored by the service
+var crawledKinds = []int{0, 1, 3, 5, 6, 7, 16, 1984, 1985, 9735, 10000, 10001, 10002, 30000, 30001, 30023}
+
 type Crawler struct {
 	config      *types.Config
 	service     *service.Service
+	mu          sync.Mutex
 	connections map[string]*relayConnection
+	// authors crawled only, decoded from the config
+	authors   []string
+	rate      *crawlRate
+	expansion *expansion
+	// done once the crawler is stopped
+	ctx  context.Context
+	stop context.CancelFunc
+	// goroutines storing events
+	consumers sync.WaitGroup
 }
 
 func NewCrawler(config *types.Config, service *service.Service) *Crawler {
+	ctx, stop := context.WithCancel(context.Background())
 	return &Crawler{
 		config:      config,
 		service:     service,
 		connections: make(map[string]*relayConnection),
+		authors:     decodeAuthors(config.Crawler.Authors),
+		rate:        newCrawlRate(config.Crawler.RateLimit),
+		expansion:   &expansion{authors: map[string]string{}, unplaced: map[string]time.Time{}, watchers: map[string]context.CancelFunc{}},
+		ctx:         ctx,
+		stop:        stop,
 	}
 }
 
@@ -30,47 +56,119 @@ func (c *Crawler) Run() {
 	for _, url := range c.config.Crawler.Relays {
 		c.AddRelay(url)
 	}
+
+	if c.config.Crawler.Expansion.Enabled {
+		go c.runExpansion(c.ctx)
+	}
+
+	if c.config.Crawler.Backfill.Enabled {
+		for _, url := range c.config.Crawler.Relays {
+			c.consumers.Add(1)
+			go func(url string) {
+				defer c.consumers.Done()
+				if err := c.Backfill(c.ctx, url, time.Now()); err != nil && !errors.Is(err, context.Canceled) {
+					log.Error("Failed to backfill relay", "url", url, "err", err)
+				}
+			}(url)
+		}
+	}
+}
+
+// kinds returns the kinds to crawl, including those kept raw if enabled
+func (c *Crawler) kinds() []int {
+	if len(c.config.Crawler.Kinds) > 0 {
+		return c.config.Crawler.Kinds
+	}
+	if !c.config.RawEvents.Enabled {
+		return crawledKinds
+	}
+	kinds := append([]int{}, crawledKinds...)
+	for _, k := range c.config.RawEvents.Kinds {
+		if !slices.Contains(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// Accepts tells if events of a kind are crawled
+func (c *Crawler) Accepts(kind int) bool {
+	return slices.Contains(c.kinds(), kind)
 }
 
 func (c *Crawler) AddRelay(url string) {
+	go c.watch(c.ctx, url, c.filter())
+}
+
+// Stop closes all subscriptions, then waits for events being stored until
+// ctx is done
+func (c *Crawler) Stop(ctx context.Context) error {
+	log.Info("Stopping crawler")
+	c.stop()
+
+	done := make(chan struct{})
 	go func() {
-		log.Info("Adding a relay server", "url", url)
+		c.consumers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("Crawler stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-		since := time.Now().Add(parseTimeOffset(c.config.Crawler.Since))
-		limit := c.config.Crawler.Limit
-		conn, err := c.subscribe(url, since, limit)
-		if err != nil {
-			log.Error("Failed to subscribe to relay", "url", url, "err", err)
-			return
-		}
+// watch crawls events of the relay matching the filter, reconnecting when
+// the connection drops, until ctx is done
+func (c *Crawler) watch(ctx context.Context, url string, filter nostr.Filter) {
+	log.Info("Adding a relay server", "url", url)
 
-		for {
-			select {
-			case err := <-conn.error:
-				log.Info("Close & reconnect to relay", "url", url)
-				err = conn.Close()
-				if err != nil {
-					log.Error("Failed to close connection", "url", url, "err", err)
-				}
+	since := time.Now().Add(parseTimeOffset(c.config.Crawler.Since))
+	limit := c.config.Crawler.Limit
+	conn, err := c.subscribe(ctx, url, filter, since, limit)
+	if err != nil {
+		log.Error("Failed to subscribe to relay", "url", url, "err", err)
+		return
+	}
 
-				// wait for a while
-				waitPeriod := 30 * time.Second
-				time.Sleep(waitPeriod)
+	for {
+		select {
+		case err := <-conn.error:
+			log.Info("Close & reconnect to relay", "url", url)
+			err = conn.Close()
+			if err != nil {
+				log.Error("Failed to close connection", "url", url, "err", err)
+			}
 
-				// reconnect
-				conn, err = c.subscribe(url, time.Now().Add(-waitPeriod), 1000)
-				if err != nil {
-					log.Error("Failed to subscribe to relay", "url", url, "err", err)
-					return
-				}
-				log.Info("Reconnected to relay", "url", url)
+			// wait for a while
+			waitPeriod := 30 * time.Second
+			select {
+			case <-time.After(waitPeriod):
+			case <-ctx.Done():
+				return
 			}
+
+			// reconnect
+			conn, err = c.subscribe(ctx, url, filter, time.Now().Add(-waitPeriod), 1000)
+			if err != nil {
+				log.Error("Failed to subscribe to relay", "url", url, "err", err)
+				return
+			}
+			log.Info("Reconnected to relay", "url", url)
+		case <-ctx.Done():
+			log.Info("Stop crawling relay", "url", url)
+			if err := conn.Close(); err != nil {
+				log.Error("Failed to close connection", "url", url, "err", err)
+			}
+			return
 		}
-	}()
+	}
 }
 
-func (c *Crawler) subscribe(url string, since time.Time, limit int) (*relayConnection, error) {
-	ctx, cancel := context.WithCancel(context.Background())
+func (c *Crawler) subscribe(ctx context.Context, url string, filter nostr.Filter, since time.Time, limit int) (*relayConnection, error) {
+	ctx, cancel := context.WithCancel(ctx)
 
 	relay, err := nostr.RelayConnect(ctx, url)
 	if err != nil {
@@ -78,30 +176,24 @@ func (c *Crawler) subscribe(url string, since time.Time, limit int) (*relayConne
 		return nil, err
 	}
 
-	var filter nostr.Filter
-	if limit != 0 {
-		filter = nostr.Filter{
-			Kinds: []int{1, 3, 6, 7, 9735},
-			Since: &since,
-			Limit: limit,
-		}
-	} else {
-		filter = nostr.Filter{
-			Kinds: []int{1, 3, 6, 7, 9735},
-			Since: &since,
-		}
-	}
-	log.Debug("Subscribing to relay", "url", url, "filter", filter)
-	sub := relay.Subscribe(ctx, []nostr.Filter{filter})
+	req := filter
+	req.Since = &since
+	req.Limit = limit
+	log.Debug("Subscribing to relay", "url", url, "filter", req)
+	sub := relay.Subscribe(ctx, []nostr.Filter{req})
 
 	conn := relayConnection{
 		relay:  relay,
 		cancel: cancel,
 		error:  make(chan error),
 	}
+	c.mu.Lock()
 	c.connections[url] = &conn
+	c.mu.Unlock()
 
+	c.consumers.Add(1)
 	go func() {
+		defer c.consumers.Done()
 		for {
 			select {
 			case ev := <-sub.Events:
@@ -109,17 +201,31 @@ func (c *Crawler) subscribe(url string, since time.Time, limit int) (*relayConne
 					log.Debug("Channel is closed", "url", url)
 					return
 				}
-				log.Debug("Received event", "id", ev.ID, "kind", ev.Kind, "author", ev.PubKey, "created_at", ev.CreatedAt)
-				err := c.service.StoreEvent(ev)
-				if err != nil {
-					log.Error("Failed to store event", "event", ev, "err", err)
+				ctx := correlation.With(ctx, correlation.New())
+				logger := correlation.Logger(ctx, log.Root())
+				logger.Debug("Received event", "id", ev.ID, "kind", ev.Kind, "author", ev.PubKey, "created_at", ev.CreatedAt)
+				if !matches(filter, ev) {
+					continue
+				}
+				if err := c.rate.wait(ctx); err != nil {
+					return
+				}
+				err := c.service.StoreEventFromRelay(ctx, ev, url)
+				if errors.Is(err, service.ErrEventLimit) {
+					logger.Debug("Dropped event over limits", "id", ev.ID, "url", url, "err", err)
+				} else if err != nil {
+					logger.Error("Failed to store event", "event", ev, "err", err)
 				}
 			case notice := <-relay.Notices:
 				log.Warn("Received relay notice", "notice", notice)
 			case <-relay.ConnectionContext.Done():
 				err := relay.ConnectionError
 				log.Error("Connection error", "url", url, "err", err)
-				conn.error <- err
+				select {
+				case conn.error <- err:
+				case <-ctx.Done():
+				}
+				return
 			case <-ctx.Done():
 				log.Debug("Stop consuming events", "url", url)
 				return
@@ -130,6 +236,136 @@ func (c *Crawler) subscribe(url string, since time.Time, limit int) (*relayConne
 	return &conn, nil
 }
 
+// filter returns the configured filter of crawled events
+func (c *Crawler) filter() nostr.Filter {
+	filter := nostr.Filter{Kinds: c.kinds()}
+	if len(c.authors) > 0 {
+		filter.Authors = c.authors
+	}
+	if len(c.config.Crawler.Hashtags) > 0 {
+		filter.Tags = nostr.TagMap{"t": hashtagVariants(c.config.Crawler.Hashtags)}
+	}
+	return filter
+}
+
+// hashtagVariants returns the common spellings of the hashtags, as relays
+// match tags case-sensitively while authors tag '#nostr', '#Nostr' or '#NOSTR'
+// alike
+func hashtagVariants(hashtags []string) []string {
+	seen := map[string]bool{}
+	variants := []string{}
+	for _, tag := range hashtags {
+		tag = strings.TrimPrefix(tag, "#")
+		if tag == "" {
+			continue
+		}
+		lower := []rune(strings.ToLower(tag))
+		title := string(unicode.ToUpper(lower[0])) + string(lower[1:])
+		for _, variant := range []string{string(lower), title, strings.ToUpper(tag), tag} {
+			if !seen[variant] {
+				seen[variant] = true
+				variants = append(variants, variant)
+			}
+		}
+	}
+	return variants
+}
+
+// Matches tells if an event passes the configured filter, as relays may not
+// honor every part of it
+func (c *Crawler) Matches(ev *nostr.Event) bool {
+	return matches(c.filter(), ev)
+}
+
+func matches(filter nostr.Filter, ev *nostr.Event) bool {
+	// hashtags are compared regardless of case, other tags as they are
+	hashtags := filter.Tags["t"]
+	if len(hashtags) > 0 {
+		tags := nostr.TagMap{}
+		for name, values := range filter.Tags {
+			if name != "t" {
+				tags[name] = values
+			}
+		}
+		filter.Tags = tags
+	}
+
+	if !filter.Matches(ev) || !hasHashtag(ev, hashtags) {
+		metrics.NewCounter("crawler/filtered").Inc(1)
+		return false
+	}
+	return true
+}
+
+// hasHashtag tells if the event is tagged with one of the hashtags, ignoring
+// case, or if there are no hashtags to look for
+func hasHashtag(ev *nostr.Event, hashtags []string) bool {
+	if len(hashtags) == 0 {
+		return true
+	}
+	for _, tag := range ev.Tags.GetAll([]string{"t"}) {
+		for _, hashtag := range hashtags {
+			if strings.EqualFold(tag.Value(), hashtag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeAuthors(authors []string) []string {
+	var decoded []string
+	for _, author := range authors {
+		if strings.HasPrefix(author, "npub") {
+			pub, err := DecodeNpub(author)
+			if err != nil {
+				log.Error("Invalid author in crawler config", "author", author, "err", err)
+				continue
+			}
+			author = pub
+		}
+		decoded = append(decoded, author)
+	}
+	return decoded
+}
+
+// crawlRate paces crawled events to at most a number per second across all
+// relays, slowing down reading from relays instead of dropping events
+type crawlRate struct {
+	gap time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newCrawlRate(perSecond int) *crawlRate {
+	if perSecond <= 0 {
+		return &crawlRate{}
+	}
+	return &crawlRate{gap: time.Second / time.Duration(perSecond)}
+}
+
+func (r *crawlRate) wait(ctx context.Context) error {
+	if r.gap == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.gap)
+	r.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type relayConnection struct {
 	relay  *nostr.Relay
 	cancel context.CancelFunc
@@ -0,0 +1,115 @@
+package nostr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrawlerKinds(t *testing.T) {
+	config := &types.Config{RawEvents: types.RawEventsConfig{Kinds: []int{1, 42}}}
+	c := NewCrawler(config, nil)
+	assert.Equal(t, crawledKinds, c.kinds())
+
+	config.RawEvents.Enabled = true
+	assert.Equal(t, append(append([]int{}, crawledKinds...), 42), c.kinds())
+	assert.NotContains(t, crawledKinds, 42)
+	assert.True(t, c.Accepts(42))
+	assert.False(t, c.Accepts(4))
+}
+
+func TestCrawlerFilter(t *testing.T) {
+	pub := "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	npub := "npub180cvv07tjdrrgpa0j7j7tmnyl2yr6yr7l8j4s3evf6u64th6gkwsyjh6w6"
+	config := &types.Config{Crawler: types.CrawlerConfig{
+		Kinds:    []int{30023},
+		Authors:  []string{npub},
+		Hashtags: []string{"#Nostr"},
+	}}
+	c := NewCrawler(config, nil)
+
+	filter := c.filter()
+	assert.Equal(t, []int{30023}, filter.Kinds)
+	assert.Equal(t, []string{pub}, filter.Authors)
+	assert.Equal(t, []string{"nostr", "Nostr", "NOSTR"}, filter.Tags["t"])
+	assert.True(t, c.Accepts(30023))
+	assert.False(t, c.Accepts(1))
+
+	tagged := nostr.Tags{nostr.Tag{"t", "nostr"}}
+	assert.True(t, c.Matches(&nostr.Event{Kind: 30023, PubKey: pub, Tags: tagged}))
+	assert.False(t, c.Matches(&nostr.Event{Kind: 1, PubKey: pub, Tags: tagged}))
+	assert.False(t, c.Matches(&nostr.Event{Kind: 30023, PubKey: "other", Tags: tagged}))
+	assert.False(t, c.Matches(&nostr.Event{Kind: 30023, PubKey: pub}))
+	assert.True(t, c.Matches(&nostr.Event{Kind: 30023, PubKey: pub, Tags: nostr.Tags{nostr.Tag{"t", "NoStr"}}}))
+	assert.False(t, c.Matches(&nostr.Event{Kind: 30023, PubKey: pub, Tags: nostr.Tags{nostr.Tag{"t", "bitcoin"}}}))
+}
+
+func TestCrawlRate(t *testing.T) {
+	r := newCrawlRate(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, r.wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	assert.NoError(t, newCrawlRate(0).wait(context.Background()))
+}
+
+func TestNextUntil(t *testing.T) {
+	until := time.Unix(1000, 0)
+	since := time.Unix(100, 0)
+	page := func(createdAt ...int64) []*nostr.Event {
+		events := make([]*nostr.Event, len(createdAt))
+		for i, ts := range createdAt {
+			events[i] = &nostr.Event{CreatedAt: time.Unix(ts, 0)}
+		}
+		return events
+	}
+
+	// a page continues from its oldest event, even if it's short of the limit
+	assert.Equal(t, time.Unix(800, 0), nextUntil(page(990, 800, 900), until, since))
+	assert.Equal(t, time.Unix(800, 0), nextUntil(page(990, 800), until, since))
+	// only an empty page is the last one
+	assert.Equal(t, since, nextUntil(nil, until, since))
+	// a page within a single second moves on
+	assert.Equal(t, time.Unix(999, 0), nextUntil(page(1000, 1000), until, since))
+	assert.Equal(t, since, nextUntil(page(50, 60), until, since))
+}
+
+func TestPlanExpansion(t *testing.T) {
+	seeds := []string{"wss://seed.relay"}
+	assigned := map[string]string{"crawled": "wss://known.relay"}
+	candidates := []types.FollowedAuthor{
+		{Pubkey: "seeded", Relays: []string{"wss://seed.relay/", "wss://other.relay"}},
+		{Pubkey: "known", Relays: []string{"wss://new.relay", "wss://known.relay"}},
+		{Pubkey: "new", Relays: []string{"wss://new.relay"}},
+		{Pubkey: "norelays"},
+		{Pubkey: "full", Relays: []string{"wss://another.relay"}},
+	}
+
+	added, unplaced := planExpansion(candidates, seeds, assigned, 2, 10)
+	assert.Equal(t, map[string]string{
+		// covered by the seed relays
+		"seeded": "",
+		// relays crawled already are preferred
+		"known": "wss://known.relay",
+		"new":   "wss://new.relay",
+	}, added)
+	// without relays, or beyond the relays allowed, they're retried later
+	assert.Equal(t, []string{"norelays", "full"}, unplaced)
+
+	// no more authors are added beyond the limit
+	added, _ = planExpansion(candidates[2:], seeds, assigned, 2, 1)
+	assert.Empty(t, added)
+}
+
+func TestCrawlerStop(t *testing.T) {
+	c := NewCrawler(&types.Config{}, nil)
+
+	assert.NoError(t, c.Stop(context.Background()))
+	assert.Error(t, c.ctx.Err())
+}
@@ -0,0 +1,124 @@
+package nostr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip26"
+	"golang.org/x/exp/slices"
+)
+
+// kinds channels publish under delegation: reposts, generic reposts and
+// long-form recaps
+var delegatedKinds = []int{6, 16, 30023}
+
+// delegator issues NIP-26 delegation tokens from the master key to channel
+// keys. Tokens are short-lived and renewed as they near expiry, so that a
+// revoked channel key loses the delegation once its token expires.
+type delegator struct {
+	sk       string
+	pub      string
+	validity time.Duration
+
+	mu      sync.Mutex
+	tokens  map[string]*delegation
+	revoked map[string]bool
+}
+
+type delegation struct {
+	token *nip26.DelegationToken
+	until time.Time
+}
+
+func newDelegator(sk string, validity time.Duration) (*delegator, error) {
+	if IsBunkerURI(sk) {
+		return nil, fmt.Errorf("delegation requires a local master key, not a remote signer")
+	}
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &delegator{
+		sk:       sk,
+		pub:      pub,
+		validity: validity,
+		tokens:   make(map[string]*delegation),
+		revoked:  make(map[string]bool),
+	}, nil
+}
+
+// token returns a valid token for the delegatee, nil if it's revoked
+func (d *delegator) token(delegateePub string, now time.Time) (*nip26.DelegationToken, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.revoked[delegateePub] || delegateePub == d.pub {
+		return nil, nil
+	}
+
+	// renew tokens past half of their validity
+	if cached, ok := d.tokens[delegateePub]; ok && cached.until.Sub(now) > d.validity/2 {
+		return cached.token, nil
+	}
+
+	since := now.Add(-time.Minute)
+	until := now.Add(d.validity)
+	token, err := nip26.CreateToken(d.sk, delegateePub, delegatedKinds, &since, &until)
+	if err != nil {
+		return nil, err
+	}
+	d.tokens[delegateePub] = &delegation{token: token, until: until}
+	return token, nil
+}
+
+func (d *delegator) revoke(delegateePub string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.tokens, delegateePub)
+	d.revoked[delegateePub] = true
+}
+
+// SetDelegator makes channel keys publish under delegation of the master
+// key, see NIP-26
+func (c *Client) SetDelegator(masterSK string, validity time.Duration) error {
+	d, err := newDelegator(masterSK, validity)
+	if err != nil {
+		return err
+	}
+	c.delegator = d
+	return nil
+}
+
+// RevokeDelegation stops delegating to a channel key, e.g. after it was
+// rotated. Already issued tokens stay valid until they expire.
+func (c *Client) RevokeDelegation(channelPub string) {
+	if c.delegator != nil {
+		c.delegator.revoke(channelPub)
+	}
+}
+
+// sign signs the event, adding a delegation tag if the key is delegated to
+// for the event kind
+func (c *Client) sign(ctx context.Context, ev *nostr.Event, sk string) error {
+	if c.delegator == nil || IsBunkerURI(sk) || !slices.Contains(delegatedKinds, ev.Kind) {
+		return c.signAs(ctx, ev, sk)
+	}
+
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	token, err := c.delegator.token(pub, time.Now())
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return ev.Sign(sk)
+	}
+
+	return nip26.DelegatedSign(ev, token, sk)
+}
@@ -0,0 +1,73 @@
+package nostr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip26"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelegatorToken(t *testing.T) {
+	masterSK := nostr.GeneratePrivateKey()
+	masterPub, _ := nostr.GetPublicKey(masterSK)
+	channelPub, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	d, err := newDelegator(masterSK, 24*time.Hour)
+	assert.NoError(t, err)
+	now := time.Now()
+
+	token, err := d.token(channelPub, now)
+	assert.NoError(t, err)
+	assert.NotNil(t, token)
+
+	// cached until half of its validity
+	cached, _ := d.token(channelPub, now.Add(11*time.Hour))
+	assert.Same(t, token, cached)
+	renewed, _ := d.token(channelPub, now.Add(13*time.Hour))
+	assert.NotSame(t, token, renewed)
+
+	// the master key signs itself
+	self, _ := d.token(masterPub, now)
+	assert.Nil(t, self)
+
+	d.revoke(channelPub)
+	revoked, err := d.token(channelPub, now)
+	assert.NoError(t, err)
+	assert.Nil(t, revoked)
+}
+
+func TestDelegatedSign(t *testing.T) {
+	masterSK := nostr.GeneratePrivateKey()
+	masterPub, _ := nostr.GetPublicKey(masterSK)
+	channelSK := nostr.GeneratePrivateKey()
+	channelPub, _ := nostr.GetPublicKey(channelSK)
+	c := &Client{}
+	assert.NoError(t, c.SetDelegator(masterSK, time.Hour))
+
+	ev := nostr.Event{Kind: 6, CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	assert.NoError(t, c.sign(context.Background(), &ev, channelSK))
+	assert.Equal(t, channelPub, ev.PubKey)
+	tag := ev.Tags.GetFirst([]string{"delegation"})
+	assert.NotNil(t, tag)
+	assert.Equal(t, masterPub, (*tag)[1])
+	ok, err := nip26.CheckDelegation(&ev)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = ev.CheckSignature()
+	assert.True(t, ok)
+
+	// other kinds are signed as the channel only
+	meta := nostr.Event{Kind: 0, CreatedAt: time.Now(), Tags: nostr.Tags{}, PubKey: channelPub}
+	assert.NoError(t, c.sign(context.Background(), &meta, channelSK))
+	assert.Nil(t, meta.Tags.GetFirst([]string{"delegation"}))
+
+	// revoked channels publish undelegated
+	c.RevokeDelegation(channelPub)
+	ev = nostr.Event{Kind: 6, CreatedAt: time.Now(), Tags: nostr.Tags{}, PubKey: channelPub}
+	assert.NoError(t, c.sign(context.Background(), &ev, channelSK))
+	assert.Nil(t, ev.Tags.GetFirst([]string{"delegation"}))
+	ok, _ = ev.CheckSignature()
+	assert.True(t, ok)
+}
@@ -0,0 +1,180 @@
+package nostr
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// authors none of whose relays could be added are candidates again after this
+const unplacedRetry = 24 * time.Hour
+
+// expansion keeps the authors added to the crawl from the follow graph
+type expansion struct {
+	// relay each added author is crawled from, empty if the seed relays
+	// already cover them
+	authors map[string]string
+	// when authors none of whose relays could be added were last candidates
+	unplaced map[string]time.Time
+	// stops crawling an expansion relay
+	watchers map[string]context.CancelFunc
+}
+
+// runExpansion grows the crawl every Expansion.Interval with the most-followed
+// authors not crawled yet, see Expand
+func (c *Crawler) runExpansion(ctx context.Context) {
+	interval, err := time.ParseDuration(c.config.Crawler.Expansion.Interval)
+	if err != nil {
+		log.Error("Invalid expansion interval", "interval", c.config.Crawler.Expansion.Interval, "err", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Expand(ctx); err != nil {
+				log.Error("Failed to expand crawl", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Expand adds the most-followed authors not crawled yet. Authors writing to
+// a seed relay are crawled already, the others are crawled by author from
+// the relays they write to, which are subscribed to as needed. Authors none
+// of whose relays could be added are retried after unplacedRetry. With an
+// allowlist of authors, only these are added.
+func (c *Crawler) Expand(ctx context.Context) error {
+	conf := c.config.Crawler.Expansion
+	lookback, err := time.ParseDuration(conf.Lookback)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	exclude := make([]string, 0, len(c.expansion.authors)+len(c.expansion.unplaced))
+	for pubkey := range c.expansion.authors {
+		exclude = append(exclude, pubkey)
+	}
+	for pubkey, at := range c.expansion.unplaced {
+		if now.Sub(at) < unplacedRetry {
+			exclude = append(exclude, pubkey)
+		} else {
+			delete(c.expansion.unplaced, pubkey)
+		}
+	}
+	candidates, err := c.service.GetMostFollowed(exclude, append([]string{}, c.authors...), conf.MinFollowers, conf.Batch, now.Add(-lookback))
+	if err != nil {
+		return err
+	}
+
+	added, unplaced := planExpansion(candidates, c.config.Crawler.Relays, c.expansion.authors, conf.MaxRelays, conf.MaxAuthors)
+	for _, pubkey := range unplaced {
+		c.expansion.unplaced[pubkey] = now
+	}
+	changed := map[string]bool{}
+	for pubkey, relay := range added {
+		c.expansion.authors[pubkey] = relay
+		if relay != "" {
+			changed[relay] = true
+		}
+	}
+
+	// relays are subscribed to again with the authors added
+	for relay := range changed {
+		if cancel, ok := c.expansion.watchers[relay]; ok {
+			cancel()
+		}
+		filter := c.filter()
+		filter.Authors = c.expansion.crawledFrom(relay)
+		watchCtx, cancel := context.WithCancel(ctx)
+		c.expansion.watchers[relay] = cancel
+		go c.watch(watchCtx, relay, filter)
+	}
+
+	crawled := 0
+	for _, relay := range c.expansion.authors {
+		if relay != "" {
+			crawled++
+		}
+	}
+	metrics.NewGauge("crawler/expansion/authors").Update(int64(crawled))
+	metrics.NewGauge("crawler/expansion/relays").Update(int64(len(c.expansion.watchers)))
+	log.Info("Expanded crawl", "candidates", len(candidates), "added", len(added), "unplaced", len(unplaced), "relays", len(changed))
+	return nil
+}
+
+// crawledFrom returns the authors crawled from the relay
+func (e *expansion) crawledFrom(relay string) []string {
+	var authors []string
+	for pubkey, r := range e.authors {
+		if r == relay {
+			authors = append(authors, pubkey)
+		}
+	}
+	return authors
+}
+
+// planExpansion assigns the candidates to the relay they're crawled from,
+// preferring relays crawled already so that few connections are added, and
+// staying within maxRelays and maxAuthors. Candidates without relays or
+// whose relays can't be added are returned as unplaced.
+func planExpansion(candidates []types.FollowedAuthor, seeds []string, assigned map[string]string, maxRelays, maxAuthors int) (map[string]string, []string) {
+	seeded := map[string]bool{}
+	for _, url := range seeds {
+		seeded[nostr.NormalizeURL(url)] = true
+	}
+	relays := map[string]bool{}
+	authors := 0
+	for _, relay := range assigned {
+		if relay != "" {
+			relays[relay] = true
+			authors++
+		}
+	}
+
+	added := map[string]string{}
+	unplaced := []string{}
+	for _, candidate := range candidates {
+		urls := make([]string, 0, len(candidate.Relays))
+		covered := false
+		for _, url := range candidate.Relays {
+			url = nostr.NormalizeURL(url)
+			covered = covered || seeded[url]
+			urls = append(urls, url)
+		}
+		if covered {
+			added[candidate.Pubkey] = ""
+			continue
+		}
+		if authors >= maxAuthors {
+			break
+		}
+
+		pick := ""
+		for _, url := range urls {
+			if relays[url] {
+				pick = url
+				break
+			}
+		}
+		if pick == "" && len(urls) > 0 && len(relays) < maxRelays {
+			pick = urls[0]
+			relays[pick] = true
+		}
+		if pick == "" {
+			unplaced = append(unplaced, candidate.Pubkey)
+			continue
+		}
+		added[candidate.Pubkey] = pick
+		authors++
+	}
+	return added, unplaced
+}
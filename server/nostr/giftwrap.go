@@ -0,0 +1,95 @@
+package nostr
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-59 and NIP-17 kinds
+const (
+	sealKind        = 13
+	chatMessageKind = 14
+	giftWrapKind    = 1059
+)
+
+// timestamps of seals and wraps are randomized up to this far in the past,
+// so that they can't be correlated with the time of sending
+const giftWrapJitter = 2 * 24 * time.Hour
+
+// GiftWrap sends a private direct message (NIP-17) gift wrapped as defined in
+// NIP-59, so that relays learn neither the sender nor the content
+func (c *Client) GiftWrap(ctx context.Context, sk, receiverPub, msg string) error {
+	s, err := c.signer(sk)
+	if err != nil {
+		return err
+	}
+	wrap, err := giftWrapWith(ctx, s, receiverPub, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.Publish(ctx, *wrap)
+}
+
+func giftWrap(sk, receiverPub, msg string, now time.Time) (*nostr.Event, error) {
+	s, err := newLocalSigner(sk)
+	if err != nil {
+		return nil, err
+	}
+	return giftWrapWith(context.Background(), s, receiverPub, msg, now)
+}
+
+func giftWrapWith(ctx context.Context, s signer, receiverPub, msg string, now time.Time) (*nostr.Event, error) {
+	// the rumor is left unsigned, so that it can't be proven to be sent by
+	// the sender if leaked
+	rumor := nostr.Event{
+		PubKey:    s.PubKey(),
+		Kind:      chatMessageKind,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiverPub}},
+		Content:   msg,
+		CreatedAt: now,
+	}
+	rumor.ID = rumor.GetID()
+
+	seal, err := sealEvent(ctx, s, receiverPub, &rumor, sealKind, nostr.Tags{}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// wrapped with a throwaway key
+	throwaway, err := newLocalSigner(nostr.GeneratePrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	return sealEvent(ctx, throwaway, receiverPub, seal, giftWrapKind, nostr.Tags{nostr.Tag{"p", receiverPub}}, now)
+}
+
+// sealEvent encrypts the event into the content of a new event signed by s
+func sealEvent(ctx context.Context, s signer, receiverPub string, inner *nostr.Event, kind int, tags nostr.Tags, now time.Time) (*nostr.Event, error) {
+	raw, err := inner.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if inner.Sig == "" {
+		raw = bytes.Replace(raw, []byte(`,"sig":""`), nil, 1)
+	}
+	content, err := s.Encrypt44(ctx, receiverPub, string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &nostr.Event{
+		PubKey:    s.PubKey(),
+		Kind:      kind,
+		Tags:      tags,
+		Content:   content,
+		CreatedAt: now.Add(-time.Duration(rand.Int63n(int64(giftWrapJitter)))),
+	}
+	if err := s.SignEvent(ctx, ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
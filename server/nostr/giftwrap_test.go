@@ -0,0 +1,50 @@
+package nostr
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGiftWrap(t *testing.T) {
+	senderSK := nostr.GeneratePrivateKey()
+	senderPub, _ := nostr.GetPublicKey(senderSK)
+	receiverSK := nostr.GeneratePrivateKey()
+	receiverPub, _ := nostr.GetPublicKey(receiverSK)
+	now := time.Now()
+
+	wrap, err := giftWrap(senderSK, receiverPub, "your digest", now)
+	assert.NoError(t, err)
+	assert.Equal(t, giftWrapKind, wrap.Kind)
+	assert.NotEqual(t, senderPub, wrap.PubKey)
+	assert.Equal(t, receiverPub, wrap.Tags.GetFirst([]string{"p"}).Value())
+	assert.False(t, wrap.CreatedAt.After(now))
+	ok, _ := wrap.CheckSignature()
+	assert.True(t, ok)
+
+	// unwrap as the receiver
+	key, _ := nip44ConversationKey(wrap.PubKey, receiverSK)
+	raw, err := nip44Decrypt(wrap.Content, key)
+	assert.NoError(t, err)
+	var seal nostr.Event
+	assert.NoError(t, json.Unmarshal([]byte(raw), &seal))
+	assert.Equal(t, sealKind, seal.Kind)
+	assert.Equal(t, senderPub, seal.PubKey)
+	assert.Empty(t, seal.Tags)
+	ok, _ = seal.CheckSignature()
+	assert.True(t, ok)
+
+	key, _ = nip44ConversationKey(seal.PubKey, receiverSK)
+	raw, err = nip44Decrypt(seal.Content, key)
+	assert.NoError(t, err)
+	assert.NotContains(t, raw, `"sig"`)
+	var rumor nostr.Event
+	assert.NoError(t, json.Unmarshal([]byte(raw), &rumor))
+	assert.Equal(t, chatMessageKind, rumor.Kind)
+	assert.Equal(t, senderPub, rumor.PubKey)
+	assert.Equal(t, "your digest", rumor.Content)
+	assert.Equal(t, rumor.GetID(), rumor.ID)
+}
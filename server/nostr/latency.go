@@ -0,0 +1,108 @@
+package nostr
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// weight of the newest sample in the moving average
+const rttSmoothing = 0.3
+
+// latencyTable keeps an exponentially weighted moving average of the round
+// trip time measured for each relay.
+type latencyTable struct {
+	mu   sync.RWMutex
+	rtts map[string]time.Duration
+}
+
+func newLatencyTable() *latencyTable {
+	return &latencyTable{
+		rtts: make(map[string]time.Duration),
+	}
+}
+
+func (t *latencyTable) observe(uri string, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev, ok := t.rtts[uri]
+	if !ok {
+		t.rtts[uri] = rtt
+		return
+	}
+	t.rtts[uri] = time.Duration(rttSmoothing*float64(rtt) + (1-rttSmoothing)*float64(prev))
+}
+
+func (t *latencyTable) get(uri string) (time.Duration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rtt, ok := t.rtts[uri]
+	return rtt, ok
+}
+
+// rank sorts uris by measured latency in ascending order. Relays that haven't
+// been measured yet come last, as they may be down. The latency probe
+// measures them regardless of publishing.
+func (t *latencyTable) rank(uris []string) []string {
+	ranked := make([]string, len(uris))
+	copy(ranked, uris)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ri, oki := t.get(ranked[i])
+		rj, okj := t.get(ranked[j])
+		if oki != okj {
+			return oki
+		}
+		return ri < rj
+	})
+	return ranked
+}
+
+// Latencies returns the smoothed round trip time of each measured relay
+func (c *Client) Latencies() map[string]time.Duration {
+	result := map[string]time.Duration{}
+	for uri := range c.Relays {
+		if rtt, ok := c.latency.get(uri); ok {
+			result[uri] = rtt
+		}
+	}
+	return result
+}
+
+// StartLatencyProbe periodically measures the round trip time of every relay
+// with a cheap REQ that finishes at EOSE, so that relays excluded from fanout
+// keep being measured.
+func (c *Client) StartLatencyProbe(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			c.probeLatency(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Client) probeLatency(ctx context.Context) {
+	for uri, r := range c.Relays {
+		probeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		start := time.Now()
+		r.QuerySync(probeCtx, nostr.Filter{Kinds: []int{0}, Limit: 1})
+		if probeCtx.Err() == nil {
+			c.latency.observe(uri, time.Since(start))
+		} else {
+			logger.Debug("latency probe timed out", "uri", uri)
+		}
+		cancel()
+	}
+}
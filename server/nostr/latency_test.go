@@ -0,0 +1,22 @@
+package nostr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyRank(t *testing.T) {
+	table := newLatencyTable()
+	table.observe("wss://slow", 300*time.Millisecond)
+	table.observe("wss://fast", 50*time.Millisecond)
+
+	ranked := table.rank([]string{"wss://slow", "wss://fast", "wss://new"})
+	assert.Equal(t, []string{"wss://fast", "wss://slow", "wss://new"}, ranked)
+
+	// a single spike shouldn't reorder relays immediately
+	table.observe("wss://fast", 400*time.Millisecond)
+	rtt, _ := table.get("wss://fast")
+	assert.Equal(t, 155*time.Millisecond, rtt)
+}
@@ -0,0 +1,246 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	// publish pacing backs off up to this gap after being rate-limited
+	maxPublishGap = time.Minute
+	minPublishGap = 100 * time.Millisecond
+)
+
+// relayLimits are the limitations a relay advertises in its NIP-11
+// information document, zero values mean no limit
+type relayLimits struct {
+	MaxSubscriptions int  `json:"max_subscriptions"`
+	MaxFilters       int  `json:"max_filters"`
+	MaxLimit         int  `json:"max_limit"`
+	AuthRequired     bool `json:"auth_required"`
+	PaymentRequired  bool `json:"payment_required"`
+	RestrictedWrites bool `json:"restricted_writes"`
+	// msats to pay for admission, from the fees section
+	AdmissionFee int64 `json:"-"`
+}
+
+// writable tells if events can be published without authenticating or
+// paying for the relay
+func (l relayLimits) writable() bool {
+	return !l.AuthRequired && !l.PaymentRequired && !l.RestrictedWrites
+}
+
+// fetchLimits reads the limitations from the relay information document
+func fetchLimits(ctx context.Context, uri string) (relayLimits, error) {
+	ctx, cancel := context.WithTimeout(ctx, 7*time.Second)
+	defer cancel()
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return relayLimits{}, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return relayLimits{}, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return relayLimits{}, err
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Limitation relayLimits `json:"limitation"`
+		Fees       struct {
+			Admission []relayFee `json:"admission"`
+		} `json:"fees"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return relayLimits{}, err
+	}
+	info.Limitation.AdmissionFee = admissionFee(info.Fees.Admission)
+	return info.Limitation, nil
+}
+
+type relayFee struct {
+	Amount int64  `json:"amount"`
+	Unit   string `json:"unit"`
+}
+
+// admissionFee returns the lowest admission fee in msats, 0 if unknown
+func admissionFee(fees []relayFee) int64 {
+	var lowest int64
+	for _, fee := range fees {
+		amount := fee.Amount
+		switch fee.Unit {
+		case "msats", "":
+		case "sats":
+			amount *= 1000
+		default:
+			continue
+		}
+		if amount > 0 && (lowest == 0 || amount < lowest) {
+			lowest = amount
+		}
+	}
+	return lowest
+}
+
+// batchFilters splits filters into REQs of at most maxFilters filters. If
+// more REQs than maxSubscriptions are needed, filters differing only by
+// kinds are merged first. No filter is dropped: if they still don't fit,
+// the extra REQs are sent anyway, and may be refused by the relay.
+func batchFilters(filters nostr.Filters, limits relayLimits) []nostr.Filters {
+	batches := splitFilters(filters, limits.MaxFilters)
+	if limits.MaxSubscriptions > 0 && len(batches) > limits.MaxSubscriptions {
+		batches = splitFilters(mergeKinds(filters), limits.MaxFilters)
+		if len(batches) > limits.MaxSubscriptions {
+			logger.Warn("filters exceed the subscriptions allowed by relay", "reqs", len(batches), "max_subscriptions", limits.MaxSubscriptions)
+		}
+	}
+	return batches
+}
+
+// splitFilters splits filters into batches of at most size filters, or a
+// single batch if size is 0
+func splitFilters(filters nostr.Filters, size int) []nostr.Filters {
+	if size <= 0 || size > len(filters) {
+		size = len(filters)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	batches := []nostr.Filters{}
+	for start := 0; start < len(filters); start += size {
+		end := start + size
+		if end > len(filters) {
+			end = len(filters)
+		}
+		batches = append(batches, filters[start:end])
+	}
+	return batches
+}
+
+// mergeKinds merges filters that are equal but for their kinds into one
+// filter of all their kinds, keeping the order of first appearance
+func mergeKinds(filters nostr.Filters) nostr.Filters {
+	merged := nostr.Filters{}
+	index := map[string]int{}
+	for _, filter := range filters {
+		rest := filter
+		rest.Kinds = nil
+		key, err := json.Marshal(rest)
+		if err != nil || len(filter.Kinds) == 0 {
+			merged = append(merged, filter)
+			continue
+		}
+		if i, ok := index[string(key)]; ok {
+			kinds := append([]int{}, merged[i].Kinds...)
+			merged[i].Kinds = append(kinds, filter.Kinds...)
+			continue
+		}
+		index[string(key)] = len(merged)
+		merged = append(merged, filter)
+	}
+	return merged
+}
+
+// capLimit keeps the number of events requested within the relay maximum
+func capLimit(filter nostr.Filter, limits relayLimits) nostr.Filter {
+	if limits.MaxLimit > 0 && (filter.Limit == 0 || filter.Limit > limits.MaxLimit) {
+		filter.Limit = limits.MaxLimit
+	}
+	return filter
+}
+
+// pacer spaces out publishes to each relay. The gap doubles whenever the
+// relay rejects an event as rate-limited and halves on each success.
+type pacer struct {
+	mu   sync.Mutex
+	gaps map[string]time.Duration
+	next map[string]time.Time
+}
+
+func newPacer() *pacer {
+	return &pacer{
+		gaps: make(map[string]time.Duration),
+		next: make(map[string]time.Time),
+	}
+}
+
+// wait blocks until publishing to the relay is allowed
+func (p *pacer) wait(ctx context.Context, uri string) error {
+	p.mu.Lock()
+	now := time.Now()
+	at := p.next[uri]
+	if at.Before(now) {
+		at = now
+	}
+	p.next[uri] = at.Add(p.gaps[uri])
+	p.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *pacer) observe(uri string, rateLimited bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	gap := p.gaps[uri]
+	if rateLimited {
+		gap *= 2
+		if gap < minPublishGap {
+			gap = minPublishGap
+		}
+		if gap > maxPublishGap {
+			gap = maxPublishGap
+		}
+		logger.Warn("rate-limited by relay, slowing down", "uri", uri, "gap", gap)
+	} else {
+		gap /= 2
+		if gap < minPublishGap {
+			gap = 0
+		}
+	}
+	p.gaps[uri] = gap
+}
+
+func (p *pacer) gap(uri string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gaps[uri]
+}
+
+func isRateLimited(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "rate-limited")
+}
+
+// isPaymentRequired tells if a relay rejected an event until admission is
+// paid. There's no standard prefix, relays reply e.g. "blocked: pay at ...".
+func isPaymentRequired(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "payment-required") {
+		return true
+	}
+	return (strings.Contains(msg, "blocked") || strings.Contains(msg, "restricted")) && strings.Contains(msg, "pay")
+}
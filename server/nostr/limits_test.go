@@ -0,0 +1,99 @@
+package nostr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchFilters(t *testing.T) {
+	filters := nostr.Filters{{Kinds: []int{1}}, {Kinds: []int{3}}, {Kinds: []int{6}}, {Kinds: []int{7}}, {Kinds: []int{9735}}}
+
+	assert.Equal(t, []nostr.Filters{filters}, batchFilters(filters, relayLimits{}))
+
+	batches := batchFilters(filters, relayLimits{MaxFilters: 2})
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[2], 1)
+
+	// filters of kinds only are merged to fit the subscriptions allowed
+	batches = batchFilters(filters, relayLimits{MaxFilters: 2, MaxSubscriptions: 2})
+	assert.Equal(t, []nostr.Filters{{{Kinds: []int{1, 3, 6, 7, 9735}}}}, batches)
+
+	// others aren't dropped, even if they don't fit
+	authored := nostr.Filters{{Authors: []string{"a"}}, {Authors: []string{"b"}}, {Authors: []string{"c"}}}
+	batches = batchFilters(authored, relayLimits{MaxFilters: 1, MaxSubscriptions: 2})
+	assert.Len(t, batches, 3)
+
+	assert.Empty(t, batchFilters(nostr.Filters{}, relayLimits{MaxFilters: 2}))
+}
+
+func TestCapLimit(t *testing.T) {
+	assert.Equal(t, 100, capLimit(nostr.Filter{}, relayLimits{MaxLimit: 100}).Limit)
+	assert.Equal(t, 100, capLimit(nostr.Filter{Limit: 500}, relayLimits{MaxLimit: 100}).Limit)
+	assert.Equal(t, 50, capLimit(nostr.Filter{Limit: 50}, relayLimits{MaxLimit: 100}).Limit)
+	assert.Equal(t, 500, capLimit(nostr.Filter{Limit: 500}, relayLimits{}).Limit)
+}
+
+func TestResumeFilters(t *testing.T) {
+	earlier := time.Unix(100, 0)
+	later := time.Unix(300, 0)
+	since := time.Unix(200, 0)
+	filters := nostr.Filters{{Kinds: []int{1}}, {Kinds: []int{3}, Since: &earlier}, {Kinds: []int{7}, Since: &later}}
+
+	assert.Equal(t, filters, resumeFilters(filters, time.Time{}))
+
+	resumed := resumeFilters(filters, since)
+	assert.Equal(t, since, *resumed[0].Since)
+	assert.Equal(t, since, *resumed[1].Since)
+	assert.Equal(t, later, *resumed[2].Since)
+
+	// the original filters are left untouched
+	assert.Nil(t, filters[0].Since)
+	assert.Equal(t, earlier, *filters[1].Since)
+}
+
+func TestPacer(t *testing.T) {
+	p := newPacer()
+	uri := "wss://relay"
+
+	p.observe(uri, true)
+	assert.Equal(t, minPublishGap, p.gap(uri))
+	p.observe(uri, true)
+	assert.Equal(t, 2*minPublishGap, p.gap(uri))
+	for i := 0; i < 20; i++ {
+		p.observe(uri, true)
+	}
+	assert.Equal(t, maxPublishGap, p.gap(uri))
+
+	p.observe(uri, false)
+	assert.Equal(t, maxPublishGap/2, p.gap(uri))
+	for i := 0; i < 20; i++ {
+		p.observe(uri, false)
+	}
+	assert.Equal(t, time.Duration(0), p.gap(uri))
+
+	assert.True(t, isRateLimited(errors.New("msg: rate-limited: slow down")))
+	assert.False(t, isRateLimited(errors.New("msg: blocked")))
+	assert.False(t, isRateLimited(nil))
+}
+
+func TestFetchLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/nostr+json", r.Header.Get("Accept"))
+		w.Write([]byte(`{"name":"relay","limitation":{"max_subscriptions":20,"max_filters":10,"max_limit":500,"payment_required":true}}`))
+	}))
+	defer server.Close()
+
+	limits, err := fetchLimits(context.Background(), strings.Replace(server.URL, "http", "ws", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, relayLimits{MaxSubscriptions: 20, MaxFilters: 10, MaxLimit: 500, PaymentRequired: true}, limits)
+	assert.False(t, limits.writable())
+	assert.True(t, relayLimits{MaxLimit: 500}.writable())
+}
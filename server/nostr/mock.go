@@ -3,6 +3,7 @@ package nostr
 import (
 	"context"
 
+	"github.com/dyng/nosdaily/types"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/stretchr/testify/mock"
 )
@@ -12,8 +13,13 @@ type MockClient struct {
 }
 
 // Metadata implements IClient
-func (m *MockClient) Metadata(ctx context.Context, sk string, name string, about string, picture string, nip05 string) error {
-	args := m.Called(ctx, sk, name, about, picture, nip05)
+func (m *MockClient) Metadata(ctx context.Context, sk string, name string, about string, picture string, nip05 string, relays []types.RelayInfo) error {
+	args := m.Called(ctx, sk, name, about, picture, nip05, relays)
+	return args.Error(0)
+}
+
+func (m *MockClient) HandlerInformation(ctx context.Context, sk, identifier, content string, kinds []int) error {
+	args := m.Called(ctx, sk, identifier, content, kinds)
 	return args.Error(0)
 }
 
@@ -22,6 +28,11 @@ func (m *MockClient) Subscribe(ctx context.Context, filters []nostr.Filter) <-ch
 	return args.Get(0).(<-chan nostr.Event)
 }
 
+func (m *MockClient) Query(ctx context.Context, filter nostr.Filter) []nostr.Event {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]nostr.Event)
+}
+
 func (m *MockClient) Repost(ctx context.Context, sk, id, author, raw string) error {
 	args := m.Called(ctx, sk, id, author, raw)
 	return args.Error(0)
@@ -31,3 +42,32 @@ func (m *MockClient) Mention(ctx context.Context, sk, msg string, mentions []str
 	args := m.Called(ctx, sk, msg, mentions)
 	return args.Error(0)
 }
+
+func (m *MockClient) Note(ctx context.Context, sk, msg string) (string, error) {
+	args := m.Called(ctx, sk, msg)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockClient) SendMessage(ctx context.Context, sk, receiverPub, msg string) error {
+	args := m.Called(ctx, sk, receiverPub, msg)
+	return args.Error(0)
+}
+
+func (m *MockClient) GiftWrap(ctx context.Context, sk, receiverPub, msg string) error {
+	args := m.Called(ctx, sk, receiverPub, msg)
+	return args.Error(0)
+}
+
+func (m *MockClient) Decrypt(ctx context.Context, sk, senderPub, content string) (string, error) {
+	args := m.Called(ctx, sk, senderPub, content)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockClient) RevokeDelegation(channelPub string) {
+	m.Called(channelPub)
+}
+
+func (m *MockClient) LongForm(ctx context.Context, sk, identifier, title, summary, content string, mentions []string) error {
+	args := m.Called(ctx, sk, identifier, title, summary, content, mentions)
+	return args.Error(0)
+}
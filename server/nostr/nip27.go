@@ -0,0 +1,42 @@
+package nostr
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// indexMention is the deprecated NIP-08 mention, an index into the tags
+var indexMention = regexp.MustCompile(`#\[(\d+)\]`)
+
+// MigrateMentions rewrites deprecated '#[index]' mentions of content into
+// NIP-27 'nostr:' references to the tagged profile or note. Mentions of
+// missing or other tags are left as is, so that migrating twice is harmless.
+//
+// Message templates mention their recipients by index, they are migrated
+// when published. Events received in the old syntax are migrated before
+// being parsed.
+func MigrateMentions(content string, tags nostr.Tags) string {
+	return indexMention.ReplaceAllStringFunc(content, func(match string) string {
+		i, err := strconv.Atoi(indexMention.FindStringSubmatch(match)[1])
+		if err != nil || i >= len(tags) || len(tags[i]) < 2 {
+			return match
+		}
+
+		var ref string
+		switch tags[i][0] {
+		case "p":
+			ref, err = nip19.EncodePublicKey(tags[i][1])
+		case "e":
+			ref, err = nip19.EncodeNote(tags[i][1])
+		default:
+			return match
+		}
+		if err != nil {
+			return match
+		}
+		return "nostr:" + ref
+	})
+}
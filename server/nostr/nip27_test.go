@@ -0,0 +1,22 @@
+package nostr
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateMentions(t *testing.T) {
+	pub := "32e1827635450ebb3c5a7d12c1f8e7b2b514439ac10a67eef3d9fd9c5c68e245"
+	id := "37e092174c1b387203aa0c62fd302f8425aa0be4816c7ad2890c42a770c05f3f"
+	npub, _ := nip19.EncodePublicKey(pub)
+	note, _ := nip19.EncodeNote(id)
+	tags := nostr.Tags{{"p", pub}, {"e", id}, {"t", "nostr"}}
+
+	migrated := MigrateMentions("Hi #[0], see #[1] #[2] #[3]", tags)
+	assert.Equal(t, "Hi nostr:"+npub+", see nostr:"+note+" #[2] #[3]", migrated)
+	assert.Equal(t, migrated, MigrateMentions(migrated, tags))
+	assert.Equal(t, "no mentions", MigrateMentions("no mentions", nil))
+}
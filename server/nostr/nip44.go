@@ -0,0 +1,126 @@
+package nostr
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+// NIP-44 version 2 encryption, used by gift wraps. go-nostr doesn't ship it
+// yet.
+const nip44Version = 2
+
+// nip44ConversationKey derives the key shared by the two parties
+func nip44ConversationKey(pub, sk string) ([]byte, error) {
+	shared, err := nip04.ComputeSharedSecret(pub, sk)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Extract(sha256.New, shared, []byte("nip44-v2")), nil
+}
+
+func nip44MessageKeys(conversationKey, nonce []byte) (chachaKey, chachaNonce, hmacKey []byte, err error) {
+	keys := make([]byte, 76)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, conversationKey, nonce), keys); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[:32], keys[32:44], keys[44:], nil
+}
+
+// nip44PaddedLen hides the exact length of short messages
+func nip44PaddedLen(n int) int {
+	if n <= 32 {
+		return 32
+	}
+	next := 1 << bits.Len(uint(n-1))
+	chunk := 32
+	if next > 256 {
+		chunk = next / 8
+	}
+	return chunk * ((n-1)/chunk + 1)
+}
+
+func nip44Encrypt(plaintext string, conversationKey []byte) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return nip44EncryptWithNonce(plaintext, conversationKey, nonce)
+}
+
+func nip44EncryptWithNonce(plaintext string, conversationKey, nonce []byte) (string, error) {
+	if len(plaintext) < 1 || len(plaintext) > 65535 {
+		return "", fmt.Errorf("invalid plaintext length %d", len(plaintext))
+	}
+
+	chachaKey, chachaNonce, hmacKey, err := nip44MessageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	padded := make([]byte, 2+nip44PaddedLen(len(plaintext)))
+	binary.BigEndian.PutUint16(padded, uint16(len(plaintext)))
+	copy(padded[2:], plaintext)
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(padded))
+	cipher.XORKeyStream(ciphertext, padded)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+
+	payload := []byte{nip44Version}
+	payload = append(payload, nonce...)
+	payload = append(payload, ciphertext...)
+	payload = mac.Sum(payload)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+func nip44Decrypt(payload string, conversationKey []byte) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 99 || data[0] != nip44Version {
+		return "", fmt.Errorf("unsupported payload")
+	}
+
+	nonce, ciphertext, sum := data[1:33], data[33:len(data)-32], data[len(data)-32:]
+	chachaKey, chachaNonce, hmacKey, err := nip44MessageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	if !hmac.Equal(mac.Sum(nil), sum) {
+		return "", fmt.Errorf("invalid mac")
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	padded := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(padded, ciphertext)
+
+	n := int(binary.BigEndian.Uint16(padded))
+	if n < 1 || 2+nip44PaddedLen(n) != len(padded) {
+		return "", fmt.Errorf("invalid padding")
+	}
+	return string(padded[2 : 2+n]), nil
+}
@@ -0,0 +1,45 @@
+package nostr
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNip44(t *testing.T) {
+	sk1 := "0000000000000000000000000000000000000000000000000000000000000001"
+	sk2 := "0000000000000000000000000000000000000000000000000000000000000002"
+	pub1, _ := nostr.GetPublicKey(sk1)
+	pub2, _ := nostr.GetPublicKey(sk2)
+
+	key, err := nip44ConversationKey(pub2, sk1)
+	assert.NoError(t, err)
+	assert.Equal(t, "c41c775356fd92eadc63ff5a0dc1da211b268cbea22316767095b2871ea1412d", hex.EncodeToString(key))
+
+	// test vector of the NIP
+	nonce, _ := hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000001")
+	payload, err := nip44EncryptWithNonce("a", key, nonce)
+	assert.NoError(t, err)
+	assert.Equal(t, "AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABee0G5VSK0/9YypIObAtDKfYEAjD35uVkHyB0F4DwrcNaCXlCWZKaArsGrY6M9wnuTMxWfp1RTN9Xga8no+kF5Vsb", payload)
+
+	// both parties derive the same key
+	other, _ := nip44ConversationKey(pub1, sk2)
+	msg := "a longer message spanning several padding chunks, 🌱"
+	payload, err = nip44Encrypt(msg, key)
+	assert.NoError(t, err)
+	plain, err := nip44Decrypt(payload, other)
+	assert.NoError(t, err)
+	assert.Equal(t, msg, plain)
+
+	tampered := []byte(payload)
+	tampered[50] ^= 1
+	_, err = nip44Decrypt(string(tampered), other)
+	assert.Error(t, err)
+
+	assert.Equal(t, 32, nip44PaddedLen(1))
+	assert.Equal(t, 64, nip44PaddedLen(33))
+	assert.Equal(t, 320, nip44PaddedLen(257))
+	assert.Equal(t, 384, nip44PaddedLen(383))
+}
@@ -0,0 +1,302 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// NIP-46 request and response kind
+const nip46Kind = 24133
+
+var errSignerConnectionLost = errors.New("connection to remote signer lost")
+
+// IsBunkerURI tells if a key is the 'bunker://' URI of a NIP-46 remote
+// signer rather than a secret key
+func IsBunkerURI(key string) bool {
+	return strings.HasPrefix(key, "bunker://")
+}
+
+// PublicKey returns the public key of a secret key, or of the key held by
+// the remote signer of a bunker URI
+func PublicKey(key string) (string, error) {
+	if IsBunkerURI(key) {
+		b, err := parseBunkerURI(key)
+		if err != nil {
+			return "", err
+		}
+		return b.pub, nil
+	}
+	return nostr.GetPublicKey(key)
+}
+
+type bunker struct {
+	pub    string
+	relays []string
+	secret string
+}
+
+func parseBunkerURI(uri string) (*bunker, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "bunker" {
+		return nil, fmt.Errorf("invalid bunker scheme: %s", u.Scheme)
+	}
+
+	b := &bunker{
+		pub:    u.Host,
+		relays: u.Query()["relay"],
+		secret: u.Query().Get("secret"),
+	}
+	if len(b.pub) != 64 || len(b.relays) == 0 {
+		return nil, fmt.Errorf("bunker uri requires a pubkey and a relay")
+	}
+	return b, nil
+}
+
+// remoteSigner signs events and encrypts messages with a key held by a
+// NIP-46 remote signer. Requests fail after the configured timeout, and the
+// connection to the signer's relays is reestablished when it drops.
+type remoteSigner struct {
+	bunker    *bunker
+	clientSK  string
+	clientPub string
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	relay *nostr.Relay
+	// whether the signer accepted the connection of the client
+	connected bool
+}
+
+func newRemoteSigner(uri string, conf types.RemoteSignerConfig) (*remoteSigner, error) {
+	b, err := parseBunkerURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	clientSK := conf.ClientSK
+	if clientSK == "" {
+		clientSK = nostr.GeneratePrivateKey()
+	}
+	clientPub, err := nostr.GetPublicKey(clientSK)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := time.ParseDuration(conf.Timeout)
+	if err != nil {
+		timeout = 30 * time.Second
+	}
+	return &remoteSigner{bunker: b, clientSK: clientSK, clientPub: clientPub, timeout: timeout}, nil
+}
+
+// SignEvent has the signer sign the event
+func (s *remoteSigner) SignEvent(ctx context.Context, ev *nostr.Event) error {
+	ev.PubKey = s.bunker.pub
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	result, err := s.request(ctx, "sign_event", string(raw))
+	if err != nil {
+		return err
+	}
+	return applySignature(ev, result)
+}
+
+func (s *remoteSigner) Encrypt04(ctx context.Context, pub, plaintext string) (string, error) {
+	return s.request(ctx, "nip04_encrypt", pub, plaintext)
+}
+
+func (s *remoteSigner) Decrypt04(ctx context.Context, pub, ciphertext string) (string, error) {
+	return s.request(ctx, "nip04_decrypt", pub, ciphertext)
+}
+
+func (s *remoteSigner) Encrypt44(ctx context.Context, pub, plaintext string) (string, error) {
+	return s.request(ctx, "nip44_encrypt", pub, plaintext)
+}
+
+// request sends a request to the signer and waits for its result. A request
+// interrupted by a dropped connection is sent again once reconnected.
+func (s *remoteSigner) request(ctx context.Context, method string, params ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	result, err := s.send(ctx, method, params)
+	if errors.Is(err, errSignerConnectionLost) {
+		logger.Warn("connection to remote signer lost, retrying", "method", method)
+		result, err = s.send(ctx, method, params)
+	}
+	return result, err
+}
+
+func (s *remoteSigner) send(ctx context.Context, method string, params []string) (string, error) {
+	relay, err := s.conn(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// the signer is asked to accept the client once per connection
+	if method != "connect" && !s.isConnected() {
+		if _, err := s.send(ctx, "connect", []string{s.bunker.pub, s.bunker.secret}); err != nil {
+			return "", fmt.Errorf("remote signer refused connection: %w", err)
+		}
+		s.setConnected(relay)
+	}
+
+	sharedKey, err := nip04.ComputeSharedSecret(s.bunker.pub, s.clientSK)
+	if err != nil {
+		return "", err
+	}
+	id := nostr.GeneratePrivateKey()[:16]
+	payload, _ := json.Marshal(map[string]any{"id": id, "method": method, "params": params})
+	content, err := nip04.Encrypt(string(payload), sharedKey)
+	if err != nil {
+		return "", err
+	}
+
+	since := time.Now().Add(-time.Minute)
+	req := nostr.Event{
+		PubKey:    s.clientPub,
+		Kind:      nip46Kind,
+		Tags:      nostr.Tags{nostr.Tag{"p", s.bunker.pub}},
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	if err := req.Sign(s.clientSK); err != nil {
+		return "", err
+	}
+
+	sub := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{nip46Kind},
+		Authors: []string{s.bunker.pub},
+		Tags:    nostr.TagMap{"p": []string{s.clientPub}},
+		Since:   &since,
+	}})
+	defer sub.Unsub()
+
+	if status, err := relay.Publish(ctx, req); status == nostr.PublishStatusFailed {
+		s.drop(relay)
+		return "", fmt.Errorf("signer relay rejected request: %v", err)
+	}
+
+	for {
+		select {
+		case ev := <-sub.Events:
+			if ev == nil {
+				s.drop(relay)
+				return "", errSignerConnectionLost
+			}
+			plain, err := nip04.Decrypt(ev.Content, sharedKey)
+			if err != nil {
+				logger.Debug("failed to decrypt remote signer response", "id", ev.ID, "err", err)
+				continue
+			}
+			if result, ok, err := parseSignerResponse(plain, id); ok {
+				return result, err
+			}
+		case <-relay.ConnectionContext.Done():
+			s.drop(relay)
+			return "", errSignerConnectionLost
+		case <-ctx.Done():
+			return "", fmt.Errorf("no response from remote signer to %s: %w", method, ctx.Err())
+		}
+	}
+}
+
+// conn returns the connection to the first reachable relay of the signer,
+// reconnecting if it dropped
+func (s *remoteSigner) conn(ctx context.Context) (*nostr.Relay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.relay != nil && s.relay.ConnectionContext.Err() == nil {
+		return s.relay, nil
+	}
+
+	var lastErr error
+	for _, uri := range s.bunker.relays {
+		relay, err := nostr.RelayConnect(ctx, uri)
+		if err != nil {
+			logger.Warn("failed to connect to remote signer relay", "uri", uri, "err", err)
+			lastErr = err
+			continue
+		}
+		s.relay, s.connected = relay, false
+		return relay, nil
+	}
+	return nil, fmt.Errorf("remote signer unreachable: %w", lastErr)
+}
+
+func (s *remoteSigner) drop(relay *nostr.Relay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.relay == relay {
+		relay.Close()
+		s.relay, s.connected = nil, false
+	}
+}
+
+func (s *remoteSigner) isConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+func (s *remoteSigner) setConnected(relay *nostr.Relay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.relay == relay {
+		s.connected = true
+	}
+}
+
+// parseSignerResponse reads the result of the request id, ok is false if the
+// response is to another request or asks for authorization
+func parseSignerResponse(plain, id string) (result string, ok bool, err error) {
+	var resp struct {
+		ID     string `json:"id"`
+		Result string `json:"result"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(plain), &resp); err != nil || resp.ID != id {
+		return "", false, nil
+	}
+	if resp.Result == "auth_url" {
+		logger.Warn("remote signer asks for authorization", "url", resp.Error)
+		return "", false, nil
+	}
+	if resp.Error != "" {
+		return "", true, fmt.Errorf("remote signer: %s", resp.Error)
+	}
+	return resp.Result, true, nil
+}
+
+// applySignature copies the signature of the event signed by the signer,
+// which must be the very same event
+func applySignature(ev *nostr.Event, result string) error {
+	var signed nostr.Event
+	if err := json.Unmarshal([]byte(result), &signed); err != nil {
+		return fmt.Errorf("malformed signed event: %w", err)
+	}
+	if signed.GetID() != ev.GetID() {
+		return fmt.Errorf("remote signer signed a different event")
+	}
+	if ok, err := signed.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("remote signer returned an invalid signature")
+	}
+	ev.ID, ev.Sig = signed.ID, signed.Sig
+	return nil
+}
@@ -0,0 +1,75 @@
+package nostr
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicKeyOfBunker(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+
+	uri := fmt.Sprintf("bunker://%s?relay=wss://relay.one&relay=wss://relay.two&secret=s3cr3t", pub)
+	assert.True(t, IsBunkerURI(uri))
+	assert.False(t, IsBunkerURI(sk))
+
+	b, err := parseBunkerURI(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, pub, b.pub)
+	assert.Equal(t, []string{"wss://relay.one", "wss://relay.two"}, b.relays)
+	assert.Equal(t, "s3cr3t", b.secret)
+
+	got, err := PublicKey(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, pub, got)
+	got, err = PublicKey(sk)
+	assert.NoError(t, err)
+	assert.Equal(t, pub, got)
+
+	_, err = PublicKey("bunker://" + pub)
+	assert.Error(t, err)
+}
+
+func TestParseSignerResponse(t *testing.T) {
+	result, ok, err := parseSignerResponse(`{"id":"a","result":"ack"}`, "a")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "ack", result)
+
+	_, ok, _ = parseSignerResponse(`{"id":"b","result":"ack"}`, "a")
+	assert.False(t, ok)
+
+	_, ok, _ = parseSignerResponse(`{"id":"a","result":"auth_url","error":"https://signer"}`, "a")
+	assert.False(t, ok)
+
+	_, ok, err = parseSignerResponse(`{"id":"a","error":"rejected"}`, "a")
+	assert.True(t, ok)
+	assert.Error(t, err)
+}
+
+func TestApplySignature(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	ev := nostr.Event{PubKey: pub, Kind: 1, Tags: nostr.Tags{}, Content: "digest", CreatedAt: time.Now()}
+
+	// the signer signs a copy of the event
+	signed := ev
+	assert.NoError(t, signed.Sign(sk))
+	raw, _ := json.Marshal(signed)
+	assert.NoError(t, applySignature(&ev, string(raw)))
+	assert.Equal(t, signed.Sig, ev.Sig)
+	ok, _ := ev.CheckSignature()
+	assert.True(t, ok)
+
+	// a different event is refused
+	other := nostr.Event{PubKey: pub, Kind: 1, Tags: nostr.Tags{}, Content: "other", CreatedAt: time.Now()}
+	assert.NoError(t, other.Sign(sk))
+	raw, _ = json.Marshal(other)
+	unsigned := nostr.Event{PubKey: pub, Kind: 1, Tags: nostr.Tags{}, Content: "digest", CreatedAt: ev.CreatedAt}
+	assert.Error(t, applySignature(&unsigned, string(raw)))
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/dyng/nosdaily/database"
 	"github.com/dyng/nosdaily/types"
 	"github.com/ethereum/go-ethereum/log"
-	"github.com/nbd-wtf/go-nostr"
 )
 
 type NameServer struct {
@@ -19,11 +18,12 @@ type NameServer struct {
 }
 
 type NameResponse struct {
-	Names map[string]string `json:"names"`
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays,omitempty"`
 }
 
 func NewNameServer(config *types.Config, neo4j *database.Neo4jDb) *NameServer {
-	pub, err := nostr.GetPublicKey(config.Bot.SK)
+	pub, err := PublicKey(config.Bot.SK)
 	if err != nil {
 		log.Crit("failed to get public key", "err", err)
 	}
@@ -41,10 +41,11 @@ func NewNameServer(config *types.Config, neo4j *database.Neo4jDb) *NameServer {
 func (ns *NameServer) Serve(w http.ResponseWriter, r *http.Request) {
 	names := r.URL.Query()["name"]
 
-	resp := NameResponse{Names: make(map[string]string)}
+	resp := NameResponse{Names: make(map[string]string), Relays: make(map[string][]string)}
 	for _, name := range names {
 		if name == ns.mainName {
 			resp.Names[name] = ns.mainPub
+			resp.Relays[ns.mainPub] = ns.config.Bot.Relays
 		} else {
 			// TODO
 			log.Warn("name not found", "name", name)
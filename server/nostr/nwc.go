@@ -0,0 +1,200 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// NIP-47 request and response kinds
+const (
+	nwcRequestKind  = 23194
+	nwcResponseKind = 23195
+)
+
+// walletConnect pays invoices through a wallet connected with Nostr Wallet
+// Connect (NIP-47)
+type walletConnect struct {
+	walletPub string
+	relay     string
+	secret    string
+}
+
+func parseWalletConnect(uri string) (*walletConnect, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nostr+walletconnect" {
+		return nil, fmt.Errorf("invalid wallet connect scheme: %s", u.Scheme)
+	}
+
+	// the wallet pubkey is the host, or the opaque part without "//"
+	walletPub := u.Host
+	if walletPub == "" {
+		walletPub = u.Opaque
+	}
+	wc := &walletConnect{
+		walletPub: walletPub,
+		relay:     u.Query().Get("relay"),
+		secret:    u.Query().Get("secret"),
+	}
+	if wc.walletPub == "" || wc.relay == "" || wc.secret == "" {
+		return nil, fmt.Errorf("wallet connect uri requires a pubkey, relay and secret")
+	}
+	return wc, nil
+}
+
+// PayInvoice asks the wallet to pay a bolt11 invoice and waits for the result
+func (wc *walletConnect) PayInvoice(ctx context.Context, invoice string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	pub, err := nostr.GetPublicKey(wc.secret)
+	if err != nil {
+		return err
+	}
+	sharedKey, err := nip04.ComputeSharedSecret(wc.walletPub, wc.secret)
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"method": "pay_invoice",
+		"params": map[string]any{"invoice": invoice},
+	})
+	content, err := nip04.Encrypt(string(payload), sharedKey)
+	if err != nil {
+		return err
+	}
+
+	req := nostr.Event{
+		PubKey:    pub,
+		Kind:      nwcRequestKind,
+		Tags:      nostr.Tags{nostr.Tag{"p", wc.walletPub}},
+		Content:   content,
+		CreatedAt: time.Now(),
+	}
+	if err := req.Sign(wc.secret); err != nil {
+		return err
+	}
+
+	relay, err := nostr.RelayConnect(ctx, wc.relay)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	sub := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{nwcResponseKind},
+		Authors: []string{wc.walletPub},
+		Tags:    nostr.TagMap{"e": []string{req.ID}},
+	}})
+	defer sub.Unsub()
+
+	if status, err := relay.Publish(ctx, req); status == nostr.PublishStatusFailed {
+		return fmt.Errorf("wallet relay rejected payment request: %v", err)
+	}
+
+	select {
+	case ev := <-sub.Events:
+		if ev == nil {
+			return fmt.Errorf("wallet relay closed the subscription")
+		}
+		plain, err := nip04.Decrypt(ev.Content, sharedKey)
+		if err != nil {
+			return err
+		}
+		return parseWalletResponse(plain)
+	case <-ctx.Done():
+		return fmt.Errorf("no response from wallet: %w", ctx.Err())
+	}
+}
+
+func parseWalletResponse(plain string) error {
+	var resp struct {
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(plain), &resp); err != nil {
+		return fmt.Errorf("malformed wallet response: %w", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("wallet failed to pay: %s %s", resp.Error.Code, resp.Error.Message)
+	}
+	return nil
+}
+
+// requestInvoice gets an invoice of the amount from a lightning address
+// (LUD-16)
+func requestInvoice(ctx context.Context, address string, msats int64) (string, error) {
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return "", fmt.Errorf("invalid lightning address: %s", address)
+	}
+	scheme := "https"
+	if strings.HasPrefix(domain, "localhost") || strings.HasPrefix(domain, "127.0.0.1") {
+		scheme = "http"
+	}
+
+	var params struct {
+		Callback    string `json:"callback"`
+		MinSendable int64  `json:"minSendable"`
+		MaxSendable int64  `json:"maxSendable"`
+	}
+	if err := getJSON(ctx, fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, name), &params); err != nil {
+		return "", err
+	}
+	if msats < params.MinSendable || (params.MaxSendable > 0 && msats > params.MaxSendable) {
+		return "", fmt.Errorf("%d msats out of the range accepted by %s", msats, address)
+	}
+
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return "", err
+	}
+	query := callback.Query()
+	query.Set("amount", fmt.Sprint(msats))
+	callback.RawQuery = query.Encode()
+
+	var invoice struct {
+		PR     string `json:"pr"`
+		Reason string `json:"reason"`
+	}
+	if err := getJSON(ctx, callback.String(), &invoice); err != nil {
+		return "", err
+	}
+	if invoice.PR == "" {
+		return "", fmt.Errorf("no invoice from %s: %s", address, invoice.Reason)
+	}
+	return invoice.PR, nil
+}
+
+func getJSON(ctx context.Context, uri string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", uri, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,229 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dyng/nosdaily/types"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// invoicePayer pays bolt11 invoices, it's implemented by walletConnect
+type invoicePayer interface {
+	PayInvoice(ctx context.Context, invoice string) error
+}
+
+// admissions pays the admission fee of relays requiring payment, within the
+// configured budget. Relays that can't be paid for are marked unusable.
+type admissions struct {
+	mu        sync.Mutex
+	conf      types.PaymentsConfig
+	payer     invoicePayer
+	invoice   func(ctx context.Context, address string, msats int64) (string, error)
+	spentSats int64
+	admitted  map[string]bool
+	unusable  map[string]string
+	// relays whose admission is being paid
+	paying map[string]bool
+	// file spentSats and admitted are saved to, nothing is saved if empty
+	file string
+	// called once for each relay found unusable
+	onUnusable func(uri, reason string)
+}
+
+// ledger is what's saved of admissions, so the budget holds across restarts
+type ledger struct {
+	SpentSats int64    `json:"spent_sats"`
+	Admitted  []string `json:"admitted"`
+}
+
+func newAdmissions(conf types.PaymentsConfig, payer invoicePayer, file string) *admissions {
+	a := &admissions{
+		conf:     conf,
+		payer:    payer,
+		invoice:  requestInvoice,
+		admitted: make(map[string]bool),
+		unusable: make(map[string]string),
+		paying:   make(map[string]bool),
+		file:     file,
+	}
+	if err := a.load(); err != nil {
+		logger.Error("failed to load admission payments", "file", file, "err", err)
+	}
+	return a
+}
+
+// usable tells if the relay may be published to given its limits
+func (a *admissions) usable(uri string, limits relayLimits) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.unusable[uri]; ok {
+		return false
+	}
+	if a.admitted[uri] {
+		return !limits.AuthRequired && !limits.RestrictedWrites
+	}
+	return limits.writable()
+}
+
+// admit pays the admission fee of the relay if allowed, or marks it unusable.
+// The fee is taken from the budget and saved before paying, and is kept
+// spent if the wallet fails, as the payment may still go through.
+func (a *admissions) admit(ctx context.Context, uri string, limits relayLimits) error {
+	a.mu.Lock()
+	if _, ok := a.unusable[uri]; ok || a.paying[uri] {
+		a.mu.Unlock()
+		return nil
+	}
+	sats, address, err := a.reserve(uri, limits)
+	a.mu.Unlock()
+	if err != nil || sats == 0 {
+		return err
+	}
+
+	// the lock isn't held while talking to the relay and the wallet
+	invoice, err := a.invoice(ctx, address, limits.AdmissionFee)
+	if err == nil {
+		err = checkInvoice(invoice, limits.AdmissionFee)
+	}
+	if err != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.paying, uri)
+		a.spentSats -= sats
+		a.saveOrWarn()
+		return a.markUnusable(uri, fmt.Sprintf("failed to get admission invoice: %v", err))
+	}
+	payErr := a.payer.PayInvoice(ctx, invoice)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.paying, uri)
+	if payErr != nil {
+		return a.markUnusable(uri, fmt.Sprintf("failed to pay admission: %v", payErr))
+	}
+	a.admitted[uri] = true
+	a.saveOrWarn()
+	logger.Info("paid relay admission", "uri", uri, "sats", sats, "spent", a.spentSats, "budget", a.conf.Budget)
+	return nil
+}
+
+// reserve takes the admission fee of the relay from the budget, it returns 0
+// sats if the relay can't be paid for. Called with the lock held.
+func (a *admissions) reserve(uri string, limits relayLimits) (int64, string, error) {
+	if a.admitted[uri] {
+		return 0, "", a.markUnusable(uri, "payment still required after paying admission")
+	}
+	if !a.conf.Enabled || a.payer == nil {
+		return 0, "", a.markUnusable(uri, "payment required, automatic payment is disabled")
+	}
+
+	address := ""
+	for _, r := range a.conf.Relays {
+		if r.URL == uri {
+			address = r.LightningAddress
+		}
+	}
+	if address == "" {
+		return 0, "", a.markUnusable(uri, "payment required, no lightning address configured")
+	}
+	if limits.AdmissionFee <= 0 {
+		return 0, "", a.markUnusable(uri, "payment required, admission fee unknown")
+	}
+
+	sats := (limits.AdmissionFee + 999) / 1000
+	if a.spentSats+sats > a.conf.Budget {
+		return 0, "", a.markUnusable(uri, fmt.Sprintf("admission fee of %d sats exceeds the remaining budget of %d sats", sats, a.conf.Budget-a.spentSats))
+	}
+
+	a.spentSats += sats
+	if err := a.save(); err != nil {
+		a.spentSats -= sats
+		return 0, "", fmt.Errorf("failed to record admission payment: %w", err)
+	}
+	a.paying[uri] = true
+	return sats, address, nil
+}
+
+// checkInvoice makes sure the invoice doesn't ask for more than the fee
+func checkInvoice(invoice string, msats int64) error {
+	decoded, err := decodepay.Decodepay(invoice)
+	if err != nil {
+		return fmt.Errorf("malformed invoice: %w", err)
+	}
+	if decoded.MSatoshi <= 0 || decoded.MSatoshi > msats {
+		return fmt.Errorf("invoice of %d msats doesn't match the admission fee of %d msats", decoded.MSatoshi, msats)
+	}
+	return nil
+}
+
+func (a *admissions) load() error {
+	if a.file == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(a.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var l ledger
+	if err := json.Unmarshal(raw, &l); err != nil {
+		return err
+	}
+	a.spentSats = l.SpentSats
+	for _, uri := range l.Admitted {
+		a.admitted[uri] = true
+	}
+	return nil
+}
+
+// save writes the ledger, called with the lock held
+func (a *admissions) save() error {
+	if a.file == "" {
+		return nil
+	}
+	l := ledger{SpentSats: a.spentSats, Admitted: []string{}}
+	for uri := range a.admitted {
+		l.Admitted = append(l.Admitted, uri)
+	}
+	sort.Strings(l.Admitted)
+	raw, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.file), 0755); err != nil {
+		return err
+	}
+	tmp := a.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.file)
+}
+
+func (a *admissions) saveOrWarn() {
+	if err := a.save(); err != nil {
+		logger.Warn("failed to record admission payments", "file", a.file, "err", err)
+	}
+}
+
+func (a *admissions) markUnusable(uri, reason string) error {
+	a.unusable[uri] = reason
+	logger.Warn("relay marked unusable", "uri", uri, "reason", reason)
+	// notified asynchronously, as notifying may publish events itself
+	if a.onUnusable != nil {
+		go a.onUnusable(uri, reason)
+	}
+	return fmt.Errorf("relay %s unusable: %s", uri, reason)
+}
@@ -0,0 +1,194 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testInvoice asks for 1000 sats
+const testInvoice = "lnbc10u1p3unwfusp5t9r3yymhpfqculx78u027lxspgxcr2n2987mx2j55nnfs95nxnzqpp5jmrh92pfld78spqs78v9euf2385t83uvpwk9ldrlvf6ch7tpascqhp5zvkrmemgth3tufcvflmzjzfvjt023nazlhljz2n9hattj4f8jq8qxqyjw5qcqpjrzjqtc4fc44feggv7065fqe5m4ytjarg3repr5j9el35xhmtfexc42yczarjuqqfzqqqqqqqqlgqqqqqqgq9q9qxpqysgq079nkq507a5tw7xgttmj4u990j7wfggtrasah5gd4ywfr2pjcn29383tphp4t48gquelz9z78p4cq7ml3nrrphw5w6eckhjwmhezhnqpy6gyf0"
+
+type fakePayer struct {
+	paid []string
+	err  error
+	// called while paying
+	during func()
+}
+
+func (p *fakePayer) PayInvoice(ctx context.Context, invoice string) error {
+	if p.during != nil {
+		p.during()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.paid = append(p.paid, invoice)
+	return nil
+}
+
+func TestAdmissions(t *testing.T) {
+	ctx := context.Background()
+	payer := &fakePayer{}
+	a := newAdmissions(types.PaymentsConfig{
+		Enabled: true,
+		Budget:  3000,
+		Relays: []types.PaidRelay{
+			{URL: "wss://paid", LightningAddress: "relay@paid"},
+			{URL: "wss://pricey", LightningAddress: "relay@pricey"},
+			{URL: "wss://unpriced", LightningAddress: "relay@unpriced"},
+		},
+	}, payer, "")
+	addresses := []string{}
+	a.invoice = func(ctx context.Context, address string, msats int64) (string, error) {
+		addresses = append(addresses, address)
+		return testInvoice, nil
+	}
+	// the lock is released while paying
+	payer.during = func() { a.usable("wss://free", relayLimits{}) }
+
+	paid := relayLimits{PaymentRequired: true, AdmissionFee: 2100000}
+	assert.False(t, a.usable("wss://paid", paid))
+	assert.NoError(t, a.admit(ctx, "wss://paid", paid))
+	assert.True(t, a.usable("wss://paid", paid))
+	assert.Equal(t, []string{"relay@paid"}, addresses)
+	assert.Equal(t, []string{testInvoice}, payer.paid)
+	assert.Equal(t, int64(2100), a.spentSats)
+
+	// paying again means admission didn't work
+	assert.Error(t, a.admit(ctx, "wss://paid", paid))
+	assert.False(t, a.usable("wss://paid", paid))
+
+	assert.Error(t, a.admit(ctx, "wss://pricey", relayLimits{PaymentRequired: true, AdmissionFee: 1000000}))
+	assert.Error(t, a.admit(ctx, "wss://unpriced", relayLimits{PaymentRequired: true}))
+	assert.Error(t, a.admit(ctx, "wss://unknown", paid))
+	assert.Len(t, payer.paid, 1)
+	assert.Len(t, a.unusable, 4)
+
+	assert.True(t, a.usable("wss://free", relayLimits{}))
+}
+
+func TestAdmissionsDisabled(t *testing.T) {
+	notified := make(chan string, 1)
+	a := newAdmissions(types.PaymentsConfig{}, nil, "")
+	a.onUnusable = func(uri, reason string) { notified <- uri }
+
+	assert.Error(t, a.admit(context.Background(), "wss://paid", relayLimits{PaymentRequired: true, AdmissionFee: 1000}))
+	assert.Equal(t, "wss://paid", <-notified)
+
+	// notified only once
+	assert.NoError(t, a.admit(context.Background(), "wss://paid", relayLimits{PaymentRequired: true, AdmissionFee: 1000}))
+}
+
+func TestAdmissionInvoice(t *testing.T) {
+	ctx := context.Background()
+	payer := &fakePayer{}
+	a := newAdmissions(types.PaymentsConfig{
+		Enabled: true,
+		Budget:  3000,
+		Relays:  []types.PaidRelay{{URL: "wss://paid", LightningAddress: "relay@paid"}},
+	}, payer, "")
+	a.invoice = func(ctx context.Context, address string, msats int64) (string, error) {
+		return testInvoice, nil
+	}
+
+	// the invoice asks for more than the advertised fee
+	assert.Error(t, a.admit(ctx, "wss://paid", relayLimits{PaymentRequired: true, AdmissionFee: 21000}))
+	assert.Empty(t, payer.paid)
+	assert.Equal(t, int64(0), a.spentSats)
+	assert.Error(t, checkInvoice("lnbc1", 1000000))
+}
+
+func TestAdmissionsLedger(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "payments", "admissions.json")
+	conf := types.PaymentsConfig{
+		Enabled: true,
+		Budget:  1500,
+		Relays: []types.PaidRelay{
+			{URL: "wss://paid", LightningAddress: "relay@paid"},
+			{URL: "wss://other", LightningAddress: "relay@other"},
+		},
+	}
+	a := newAdmissions(conf, &fakePayer{}, file)
+	a.invoice = func(ctx context.Context, address string, msats int64) (string, error) {
+		return testInvoice, nil
+	}
+	paid := relayLimits{PaymentRequired: true, AdmissionFee: 1000000}
+	assert.NoError(t, a.admit(ctx, "wss://paid", paid))
+
+	// the budget spent before restarting is still spent
+	payer := &fakePayer{}
+	a = newAdmissions(conf, payer, file)
+	a.invoice = func(ctx context.Context, address string, msats int64) (string, error) {
+		return testInvoice, nil
+	}
+	assert.Equal(t, int64(1000), a.spentSats)
+	assert.True(t, a.usable("wss://paid", paid))
+	assert.Error(t, a.admit(ctx, "wss://other", paid))
+	assert.Empty(t, payer.paid)
+}
+
+func TestParseWalletConnect(t *testing.T) {
+	wc, err := parseWalletConnect("nostr+walletconnect://b889ff5b1513b641e2a139f661a661364979c5beee91842f8f0ef42ab558e9d4?relay=wss%3A%2F%2Frelay.getalby.com%2Fv1&secret=71a8c14c1407c113601079c4302dab36460f0ccd0ad506f1f2dc73b5100e4f3c")
+	assert.NoError(t, err)
+	assert.Equal(t, "b889ff5b1513b641e2a139f661a661364979c5beee91842f8f0ef42ab558e9d4", wc.walletPub)
+	assert.Equal(t, "wss://relay.getalby.com/v1", wc.relay)
+
+	_, err = parseWalletConnect("nostr+walletconnect://b889ff5b?relay=wss%3A%2F%2Frelay")
+	assert.Error(t, err)
+	_, err = parseWalletConnect("https://wallet")
+	assert.Error(t, err)
+
+	assert.NoError(t, parseWalletResponse(`{"result_type":"pay_invoice","result":{"preimage":"00"}}`))
+	assert.Error(t, parseWalletResponse(`{"result_type":"pay_invoice","error":{"code":"INSUFFICIENT_BALANCE","message":"not enough"}}`))
+}
+
+func TestRequestInvoice(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/lnurlp/relay":
+			json.NewEncoder(w).Encode(map[string]any{
+				"callback":    server.URL + "/callback?user=relay",
+				"minSendable": 1000,
+				"maxSendable": 10000000,
+				"tag":         "payRequest",
+			})
+		case "/callback":
+			assert.Equal(t, "relay", r.URL.Query().Get("user"))
+			json.NewEncoder(w).Encode(map[string]any{"pr": "lnbc" + r.URL.Query().Get("amount")})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	address := "relay@" + strings.TrimPrefix(server.URL, "http://")
+	invoice, err := requestInvoice(context.Background(), address, 21000)
+	assert.NoError(t, err)
+	assert.Equal(t, "lnbc21000", invoice)
+
+	_, err = requestInvoice(context.Background(), address, 100000000)
+	assert.Error(t, err)
+	_, err = requestInvoice(context.Background(), "unknown@"+strings.TrimPrefix(server.URL, "http://"), 21000)
+	assert.Error(t, err)
+}
+
+func TestPaymentRequired(t *testing.T) {
+	assert.Equal(t, int64(21000), admissionFee([]relayFee{{Amount: 50000, Unit: "msats"}, {Amount: 21, Unit: "sats"}, {Amount: 1, Unit: "usd"}}))
+	assert.Equal(t, int64(0), admissionFee(nil))
+
+	assert.True(t, isPaymentRequired(errors.New("msg: blocked: pay at https://relay/invoices")))
+	assert.True(t, isPaymentRequired(errors.New("msg: payment-required: admission")))
+	assert.False(t, isPaymentRequired(errors.New("msg: blocked: spam")))
+	assert.False(t, isPaymentRequired(nil))
+}
@@ -0,0 +1,120 @@
+package nostr
+
+import (
+	"context"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// signer signs events and encrypts messages on behalf of a key, which is
+// held either locally or by a remote signer
+type signer interface {
+	PubKey() string
+	SignEvent(ctx context.Context, ev *nostr.Event) error
+	Encrypt04(ctx context.Context, pub, plaintext string) (string, error)
+	Decrypt04(ctx context.Context, pub, ciphertext string) (string, error)
+	Encrypt44(ctx context.Context, pub, plaintext string) (string, error)
+}
+
+type localSigner struct {
+	sk  string
+	pub string
+}
+
+func newLocalSigner(sk string) (*localSigner, error) {
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &localSigner{sk: sk, pub: pub}, nil
+}
+
+func (s *localSigner) PubKey() string {
+	return s.pub
+}
+
+func (s *localSigner) SignEvent(ctx context.Context, ev *nostr.Event) error {
+	return ev.Sign(s.sk)
+}
+
+func (s *localSigner) Encrypt04(ctx context.Context, pub, plaintext string) (string, error) {
+	sharedKey, err := nip04.ComputeSharedSecret(pub, s.sk)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Encrypt(plaintext, sharedKey)
+}
+
+func (s *localSigner) Decrypt04(ctx context.Context, pub, ciphertext string) (string, error) {
+	sharedKey, err := nip04.ComputeSharedSecret(pub, s.sk)
+	if err != nil {
+		return "", err
+	}
+	return nip04.Decrypt(ciphertext, sharedKey)
+}
+
+func (s *localSigner) Encrypt44(ctx context.Context, pub, plaintext string) (string, error) {
+	key, err := nip44ConversationKey(pub, s.sk)
+	if err != nil {
+		return "", err
+	}
+	return nip44Encrypt(plaintext, key)
+}
+
+func (s *remoteSigner) PubKey() string {
+	return s.bunker.pub
+}
+
+// SetRemoteSigner configures how keys given as bunker URIs are connected to
+func (c *Client) SetRemoteSigner(conf types.RemoteSignerConfig) {
+	c.signersMu.Lock()
+	defer c.signersMu.Unlock()
+	c.signerConf = conf
+}
+
+// signer returns the signer of a key, remote signers are connected to once
+// and reused
+func (c *Client) signer(key string) (signer, error) {
+	if !IsBunkerURI(key) {
+		return newLocalSigner(key)
+	}
+
+	c.signersMu.Lock()
+	defer c.signersMu.Unlock()
+	if s, ok := c.signers[key]; ok {
+		return s, nil
+	}
+	s, err := newRemoteSigner(key, c.signerConf)
+	if err != nil {
+		return nil, err
+	}
+	if c.signers == nil {
+		c.signers = make(map[string]*remoteSigner)
+	}
+	c.signers[key] = s
+	return s, nil
+}
+
+// Decrypt decrypts a NIP-04 message sent to the key by senderPub
+func (c *Client) Decrypt(ctx context.Context, sk, senderPub, content string) (string, error) {
+	s, err := c.signer(sk)
+	if err != nil {
+		return "", err
+	}
+	return s.Decrypt04(ctx, senderPub, content)
+}
+
+// signAs signs the event with the key, or has the remote signer sign it if
+// the key is a bunker URI
+func (c *Client) signAs(ctx context.Context, ev *nostr.Event, sk string) error {
+	if !IsBunkerURI(sk) {
+		return ev.Sign(sk)
+	}
+	s, err := c.signer(sk)
+	if err != nil {
+		return err
+	}
+	return s.SignEvent(ctx, ev)
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// engagement arriving later than this after a cold post is ignored
+const coldEngagementHorizon = 24 * time.Hour
+
+// hotBoundary is the oldest point in time that is guaranteed to be present in
+// the graph, data before it may only exist in the archive
+func (s *Service) hotBoundary() time.Time {
+	window, err := time.ParseDuration(s.config.Archive.HotWindow)
+	if err != nil {
+		logger.Error("Invalid hot window, fallback to 168h", "window", s.config.Archive.HotWindow, "err", err)
+		window = 7 * 24 * time.Hour
+	}
+	return time.Now().Add(-window)
+}
+
+// splitWindow splits [start, end] into a cold part served by the archive and
+// a hot part served by the graph. Empty parts have start == end.
+func (s *Service) splitWindow(start, end time.Time) (coldEnd, hotStart time.Time) {
+	if s.archiver == nil {
+		return start, start
+	}
+
+	boundary := s.hotBoundary()
+	if !start.Before(boundary) {
+		return start, start
+	}
+	if end.Before(boundary) {
+		return end, end
+	}
+	return boundary, boundary
+}
+
+// getColdFeed ranks archived posts by the number of distinct users engaging
+// with them, which mirrors the base weight used in the graph.
+func (s *Service) getColdFeed(ctx context.Context, start, end time.Time, limit int) []types.FeedEntry {
+	events, err := s.archiver.Query(ctx, start, end.Add(coldEngagementHorizon), nil, []int{1, 5, 6, 7, 9735})
+	if err != nil {
+		correlation.Logger(ctx, logger).Error("Failed to query archive", "start", start, "end", end, "err", err)
+		return nil
+	}
+
+	posts := map[string]*nostr.Event{}
+	for _, ev := range events {
+		if ev.Kind == 1 && ev.CreatedAt.After(start) && ev.CreatedAt.Before(end) {
+			posts[ev.ID] = ev
+		}
+	}
+
+	// drop posts deleted by their author
+	for _, ev := range events {
+		if ev.Kind != 5 {
+			continue
+		}
+		for _, ref := range ev.Tags.GetAll([]string{"e"}) {
+			if post, ok := posts[ref.Value()]; ok && post.PubKey == ev.PubKey {
+				delete(posts, ref.Value())
+			}
+		}
+	}
+
+	engagers := map[string]map[string]bool{}
+	for _, ev := range events {
+		ref := ev.Tags.GetFirst([]string{"e"})
+		if ref == nil || ev.Kind == 5 {
+			continue
+		}
+		if _, ok := posts[ref.Value()]; !ok {
+			continue
+		}
+		if engagers[ref.Value()] == nil {
+			engagers[ref.Value()] = map[string]bool{}
+		}
+		engagers[ref.Value()][ev.PubKey] = true
+	}
+
+	feed := make([]types.FeedEntry, 0, len(engagers))
+	for id, users := range engagers {
+		post := posts[id]
+		raw, err := post.MarshalJSON()
+		if err != nil {
+			continue
+		}
+		feed = append(feed, types.FeedEntry{
+			Id:        post.ID,
+			Kind:      post.Kind,
+			Pubkey:    post.PubKey,
+			CreatedAt: post.CreatedAt,
+			Score:     float64(len(users)),
+			Raw:       string(raw),
+		})
+	}
+
+	return topEntries(feed, limit)
+}
+
+// GetAuthorHistory returns events of an author created within [start, end],
+// newest first, transparently merging the graph with the archive.
+func (s *Service) GetAuthorHistory(ctx context.Context, pubkey string, start, end time.Time, limit int) ([]types.FeedEntry, error) {
+	coldEnd, hotStart := s.splitWindow(start, end)
+
+	entries := []types.FeedEntry{}
+	if coldEnd.After(start) {
+		events, err := s.archiver.Query(ctx, start, coldEnd, []string{pubkey}, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range events {
+			raw, err := ev.MarshalJSON()
+			if err != nil {
+				continue
+			}
+			entries = append(entries, types.FeedEntry{
+				Id:        ev.ID,
+				Kind:      ev.Kind,
+				Pubkey:    ev.PubKey,
+				CreatedAt: ev.CreatedAt,
+				Raw:       string(raw),
+			})
+		}
+	}
+
+	if end.After(hotStart) {
+		hot, err := s.getHotHistory(ctx, pubkey, hotStart, end, limit)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, hot...)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (s *Service) getHotHistory(ctx context.Context, pubkey string, start, end time.Time, limit int) ([]types.FeedEntry, error) {
+	posts, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post {author: $Pubkey})
+			WHERE p.created_at >= $Start AND p.created_at <= $End AND p.deleted_at IS NULL
+			RETURN p.id, p.kind, p.created_at
+			ORDER BY p.created_at DESC
+			LIMIT $Limit;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+				"Start":  start.Unix(),
+				"End":    end.Unix(),
+				"Limit":  limit,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		entries := []types.FeedEntry{}
+		for result.Next(ctx) {
+			record := result.Record()
+			entries = append(entries, types.FeedEntry{
+				Id:        record.Values[0].(string),
+				Kind:      int(record.Values[1].(int64)),
+				Pubkey:    pubkey,
+				CreatedAt: time.Unix(record.Values[2].(int64), 0),
+			})
+		}
+		return entries, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := posts.([]types.FeedEntry)
+	for i := range entries {
+		raw, err := s.readObject(entries[i].Id, entries[i].CreatedAt)
+		if err != nil {
+			logger.Warn("Failed to read object", "id", entries[i].Id, "err", err)
+			continue
+		}
+		entries[i].Raw = raw
+	}
+	return entries, nil
+}
+
+// topEntries sorts entries by score and keeps the first limit ones
+func topEntries(entries []types.FeedEntry, limit int) []types.FeedEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Score > entries[j].Score
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
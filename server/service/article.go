@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// long-form content as defined by NIP-23
+const articleKind = 30023
+
+// articleAddress returns the address a long-form article is replaced by,
+// empty if it has no d tag
+func articleAddress(event *nostr.Event) string {
+	d := event.Tags.GetFirst([]string{"d", ""})
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s:%s", event.Kind, event.PubKey, d.Value())
+}
+
+// publishedAt returns the time an article was first published, which stays
+// the same across edits
+func publishedAt(event *nostr.Event) time.Time {
+	if tag := event.Tags.GetFirst([]string{"published_at", ""}); tag != nil {
+		if ts, err := strconv.ParseInt(tag.Value(), 10, 64); err == nil && ts > 0 {
+			return time.Unix(ts, 0)
+		}
+	}
+	return event.CreatedAt
+}
+
+func tagValue(event *nostr.Event, name string) string {
+	if tag := event.Tags.GetFirst([]string{name, ""}); tag != nil {
+		return tag.Value()
+	}
+	return ""
+}
+
+// saveArticle keeps a single post per article address, pointing to the
+// latest version. Interactions with earlier versions stay with the post, and
+// it's dated by publication so that edits don't make it recent again.
+func (s *Service) saveArticle(ctx context.Context, tx neo4j.ManagedTransaction, event *nostr.Event) error {
+	address := articleAddress(event)
+	if address == "" {
+		return fmt.Errorf("article %s has no d tag", event.ID)
+	}
+
+	query := `
+		MERGE (p:Post {address: $Address})
+		ON CREATE SET p.id = $Id, p.kind = $Kind, p.author = $Author, p.created_at = $CreatedAt, p.content_type = $ContentType,
+			p.stored_at = timestamp() / 1000
+		WITH p WHERE coalesce(p.updated_at, 0) <= $UpdatedAt
+		SET
+			p.id = $Id,
+			p.updated_at = $UpdatedAt,
+			p.title = $Title,
+			p.summary = $Summary,
+			p.image = $Image;
+	`
+	_, err := tx.Run(ctx, query,
+		map[string]any{
+			"Address":     address,
+			"Id":          event.ID,
+			"Kind":        event.Kind,
+			"Author":      event.PubKey,
+			"CreatedAt":   publishedAt(event).Unix(),
+			"ContentType": ContentArticle,
+			"UpdatedAt":   event.CreatedAt.Unix(),
+			"Title":       tagValue(event, "title"),
+			"Summary":     tagValue(event, "summary"),
+			"Image":       tagValue(event, "image"),
+		})
+	return err
+}
+
+// refId returns the id of the post an event refers to. References to an
+// article by address resolve to its latest version, others to the first e
+// tag.
+func refId(ctx context.Context, tx neo4j.ManagedTransaction, event *nostr.Event) (string, error) {
+	id, err := addressRef(ctx, tx, event)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	if ref := event.Tags.GetFirst([]string{"e"}); ref != nil {
+		return ref.Value(), nil
+	}
+	return "", nil
+}
+
+// addressRef returns the id of the latest version of the article an event
+// refers to by address, empty if none
+func addressRef(ctx context.Context, tx neo4j.ManagedTransaction, event *nostr.Event) (string, error) {
+	a := event.Tags.GetFirst([]string{"a", fmt.Sprintf("%d:", articleKind)})
+	if a == nil {
+		return "", nil
+	}
+
+	result, err := tx.Run(ctx, "match (p:Post {address: $Address}) return p.id;",
+		map[string]any{
+			"Address": a.Value(),
+		})
+	if err != nil {
+		return "", err
+	}
+	if result.Next(ctx) {
+		return result.Record().Values[0].(string), nil
+	}
+	return "", result.Err()
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArticleAddress(t *testing.T) {
+	edited := time.Unix(1690000000, 0)
+	article := &nostr.Event{
+		Kind:      articleKind,
+		PubKey:    "author",
+		CreatedAt: edited,
+		Tags: nostr.Tags{
+			{"d", "my-article"},
+			{"title", "My article"},
+			{"published_at", "1680000000"},
+		},
+	}
+
+	assert.Equal(t, "30023:author:my-article", articleAddress(article))
+	assert.Equal(t, time.Unix(1680000000, 0), publishedAt(article))
+	assert.Equal(t, "My article", tagValue(article, "title"))
+	assert.Equal(t, "", tagValue(article, "image"))
+
+	// an empty d tag is valid, and without published_at the event time is used
+	article.Tags = nostr.Tags{{"d", ""}}
+	assert.Equal(t, "30023:author:", articleAddress(article))
+	assert.Equal(t, edited, publishedAt(article))
+
+	article.Tags = nostr.Tags{}
+	assert.Equal(t, "", articleAddress(article))
+}
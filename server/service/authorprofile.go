@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// content types of posts, an author's profile lists the dominant ones
+const (
+	ContentBuilder = "builder"
+	ContentMeme    = "meme"
+	ContentMedia   = "media"
+	ContentArticle = "article"
+	ContentLink    = "link"
+	ContentText    = "text"
+)
+
+var contentTypes = []string{ContentBuilder, ContentMeme, ContentMedia, ContentArticle, ContentLink, ContentText}
+
+// notes at least this long read as articles
+const articleLength = 800
+
+// media posts with less text than this are memes
+const memeTextLength = 40
+
+var (
+	urlPattern   = regexp.MustCompile(`https?://\S+`)
+	mediaPattern = regexp.MustCompile(`(?i)\.(jpe?g|png|gif|webp|mp4|mov|webm)(\?\S*)?$`)
+	codePattern  = regexp.MustCompile(`(?i)https?://(www\.)?(github\.com|gitlab\.com|codeberg\.org|git\.)`)
+)
+
+// hashtags of posts about building software
+var builderTopics = []string{"dev", "programming", "coding", "opensource", "buildinpublic", "nostrdev", "golang", "rust", "python", "javascript"}
+
+// classifyContent tells the content type of a note or article, empty for
+// other kinds
+func classifyContent(event *nostr.Event) string {
+	switch event.Kind {
+	case articleKind:
+		return ContentArticle
+	case 1:
+	default:
+		return ""
+	}
+
+	content := event.Content
+	if strings.Contains(content, "```") || codePattern.MatchString(content) {
+		return ContentBuilder
+	}
+	for _, topic := range postTopics(event) {
+		if slices.Contains(builderTopics, topic) {
+			return ContentBuilder
+		}
+	}
+
+	urls := urlPattern.FindAllString(content, -1)
+	media, links := 0, 0
+	for _, url := range urls {
+		if mediaPattern.MatchString(url) {
+			media++
+		} else {
+			links++
+		}
+	}
+	text := strings.TrimSpace(urlPattern.ReplaceAllString(content, ""))
+	switch {
+	case media > 0 && utf8.RuneCountInString(text) < memeTextLength:
+		return ContentMeme
+	case media > 0:
+		return ContentMedia
+	case utf8.RuneCountInString(text) >= articleLength:
+		return ContentArticle
+	case links > 0:
+		return ContentLink
+	default:
+		return ContentText
+	}
+}
+
+// contentTypeParam is the content type stored on a post, null if it has none
+func contentTypeParam(event *nostr.Event) any {
+	if contentType := classifyContent(event); contentType != "" {
+		return contentType
+	}
+	return nil
+}
+
+// normalizeContentTypes keeps the known content types, accepting plurals
+// such as "builders" or "memes"
+func normalizeContentTypes(names []string) []string {
+	normalized := []string{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(contentTypes, name) {
+			name = strings.TrimSuffix(name, "s")
+		}
+		if slices.Contains(contentTypes, name) && !slices.Contains(normalized, name) {
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized
+}
+
+// authorProfile is the dominant content types and topics of an author's
+// recent posts
+type authorProfile struct {
+	contentTypes []string
+	topics       []string
+}
+
+// profileAuthor picks the content types making up at least minShare of the
+// posts, and the maxTopics topics tagged most, most frequent first
+func profileAuthor(typeCounts, topicCounts map[string]int64, minShare float64, maxTopics int) authorProfile {
+	var total int64
+	for _, count := range typeCounts {
+		total += count
+	}
+
+	profile := authorProfile{contentTypes: []string{}, topics: []string{}}
+	for contentType, count := range typeCounts {
+		if total > 0 && float64(count)/float64(total) >= minShare {
+			profile.contentTypes = append(profile.contentTypes, contentType)
+		}
+	}
+	sortByCount(profile.contentTypes, typeCounts)
+
+	for topic := range topicCounts {
+		profile.topics = append(profile.topics, topic)
+	}
+	sortByCount(profile.topics, topicCounts)
+	if len(profile.topics) > maxTopics {
+		profile.topics = profile.topics[:maxTopics]
+	}
+	return profile
+}
+
+func sortByCount(keys []string, counts map[string]int64) {
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// UpdateAuthorProfiles profiles authors with at least MinPosts posts within
+// the lookback by their dominant content types and topics, stored on User
+func (s *Service) UpdateAuthorProfiles() error {
+	conf := s.config.Authors
+	start := time.Now()
+	since := start.Add(-parseDurationOr(conf.Lookback, 30*24*time.Hour))
+
+	counts, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		params := map[string]any{
+			"Since":    since.Unix(),
+			"MinPosts": conf.MinPosts,
+		}
+		recent := `
+			MATCH (u:User)-[:CREATE]->(p:Post)
+			WHERE p.created_at >= $Since AND p.content_type IS NOT NULL
+			WITH u, collect(p) AS posts
+			WHERE size(posts) >= $MinPosts
+			UNWIND posts AS p
+		`
+		typeCounts := map[string]map[string]int64{}
+		topicCounts := map[string]map[string]int64{}
+		for _, c := range []struct {
+			query  string
+			counts map[string]map[string]int64
+		}{
+			{recent + "RETURN u.pubkey, p.content_type, count(*);", typeCounts},
+			{recent + "MATCH (p)-[:TAGGED]->(t:Topic) RETURN u.pubkey, t.name, count(*);", topicCounts},
+		} {
+			result, err := tx.Run(ctx, c.query, params)
+			if err != nil {
+				return nil, err
+			}
+			for result.Next(ctx) {
+				values := result.Record().Values
+				pubkey := values[0].(string)
+				if c.counts[pubkey] == nil {
+					c.counts[pubkey] = map[string]int64{}
+				}
+				c.counts[pubkey][values[1].(string)] = values[2].(int64)
+			}
+			if err := result.Err(); err != nil {
+				return nil, err
+			}
+		}
+		return [2]map[string]map[string]int64{typeCounts, topicCounts}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	typeCounts, topicCounts := counts.([2]map[string]map[string]int64)[0], counts.([2]map[string]map[string]int64)[1]
+	batch := make([]map[string]any, 0, reputationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				UNWIND $Profiles AS profile
+				MATCH (u:User {pubkey: profile.Pubkey})
+				SET u.content_types = profile.ContentTypes, u.topics = profile.Topics, u.profiled_at = $Now;
+			`
+			_, err := tx.Run(ctx, query, map[string]any{"Profiles": batch, "Now": start.Unix()})
+			return nil, err
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	for pubkey := range typeCounts {
+		profile := profileAuthor(typeCounts[pubkey], topicCounts[pubkey], conf.MinShare, conf.Topics)
+		batch = append(batch, map[string]any{"Pubkey": pubkey, "ContentTypes": profile.contentTypes, "Topics": profile.topics})
+		if len(batch) == reputationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// authors who fell below MinPosts keep no stale profile
+	_, err = s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User)
+			WHERE u.profiled_at < $Now
+			REMOVE u.content_types, u.topics, u.profiled_at;
+		`
+		_, err := tx.Run(ctx, query, map[string]any{"Now": start.Unix()})
+		return nil, err
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Updated author profiles", "authors", len(typeCounts), "elapsed", time.Since(start))
+	return nil
+}
+
+func (s *Service) startAuthorProfiler(ctx context.Context) {
+	interval := parseDurationOr(s.config.Authors.Interval, 24*time.Hour)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.UpdateAuthorProfiles(); err != nil {
+					logger.Error("Failed to update author profiles", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// SetContentPreferences sets the content types the subscriber wants to see
+// more and less of, e.g. "builders" and "memes"
+func (s *Service) SetContentPreferences(pubkey string, more, less []string) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.more_content = $More, s.less_content = $Less;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+				"More":   normalizeContentTypes(more),
+				"Less":   normalizeContentTypes(less),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// contentPreferences is what ranks posts by the profile of their author for
+// a subscriber
+type contentPreferences struct {
+	more []string
+	less []string
+	// interests, only matched against authors' topics while the subscriber
+	// follows nobody
+	coldInterests []string
+	profiles      map[string]authorProfile
+}
+
+func (s *Service) getContentPreferences(ctx context.Context, subscriberPub string, authors []string) (*contentPreferences, error) {
+	prefs, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			OPTIONAL MATCH (:User {pubkey: $Pubkey})-[f:FOLLOW]->(:User)
+			WITH s, count(f) AS follows
+			OPTIONAL MATCH (s)-[r:INTERESTED_IN]->(t:Topic)
+			WHERE r.weight > 0
+			WITH s, follows, collect(t.name) AS interests
+			OPTIONAL MATCH (u:User)
+			WHERE u.pubkey IN $Authors AND u.content_types IS NOT NULL
+			RETURN coalesce(s.more_content, []), coalesce(s.less_content, []), follows, interests,
+				collect([u.pubkey, u.content_types, coalesce(u.topics, [])]);
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":  subscriberPub,
+				"Authors": authors,
+			})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next(ctx) {
+			return &contentPreferences{}, result.Err()
+		}
+
+		values := result.Record().Values
+		prefs := &contentPreferences{
+			more:     toStrings(values[0]),
+			less:     toStrings(values[1]),
+			profiles: map[string]authorProfile{},
+		}
+		if values[2].(int64) == 0 {
+			prefs.coldInterests = toStrings(values[3])
+		}
+		for _, row := range values[4].([]any) {
+			profile := row.([]any)
+			if pubkey, ok := profile[0].(string); ok {
+				prefs.profiles[pubkey] = authorProfile{contentTypes: toStrings(profile[1]), topics: toStrings(profile[2])}
+			}
+		}
+		return prefs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefs.(*contentPreferences), nil
+}
+
+// applyAuthorProfiles boosts authors of the content types the subscriber
+// wants more of and penalizes those they want less of. Subscribers following
+// nobody yet are shown more of the authors writing about their interests.
+func (s *Service) applyAuthorProfiles(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" || !s.config.Authors.Enabled || len(feed) == 0 {
+		return feed
+	}
+
+	authors := make([]string, 0, len(feed))
+	for _, entry := range feed {
+		authors = append(authors, entry.Pubkey)
+	}
+	prefs, err := s.getContentPreferences(ctx, subscriberPub, authors)
+	if err != nil {
+		logger.Error("Failed to query content preferences", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+
+	for i := range feed {
+		if profile, ok := prefs.profiles[feed[i].Pubkey]; ok {
+			feed[i].Score *= profileBoost(s.config.Authors, prefs, profile)
+		}
+	}
+	return feed
+}
+
+// profileBoost is the score multiplier of a post by the author's profile
+func profileBoost(conf types.AuthorProfileConfig, prefs *contentPreferences, profile authorProfile) float64 {
+	boost := 1.0
+	for _, contentType := range profile.contentTypes {
+		if slices.Contains(prefs.more, contentType) {
+			boost *= conf.Boost
+		}
+		if slices.Contains(prefs.less, contentType) {
+			boost *= conf.Penalty
+		}
+	}
+
+	matches := 0
+	for _, topic := range profile.topics {
+		if slices.Contains(prefs.coldInterests, topic) {
+			matches++
+		}
+	}
+	return boost * (1 + conf.ColdStartBoost*float64(matches))
+}
+
+func toStrings(value any) []string {
+	values, _ := value.([]any)
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
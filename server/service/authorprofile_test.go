@@ -0,0 +1,67 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyContent(t *testing.T) {
+	cases := []struct {
+		kind     int
+		content  string
+		tags     nostr.Tags
+		expected string
+	}{
+		{1, "shipped a fix https://github.com/dyng/nosdaily/pull/1", nil, ContentBuilder},
+		{1, "today's progress", nostr.Tags{{"t", "buildinpublic"}}, ContentBuilder},
+		{1, "lol https://i.imgur.com/cat.gif", nil, ContentMeme},
+		{1, "the view from the summit this morning, worth every step https://example.com/peak.jpg", nil, ContentMedia},
+		{1, strings.Repeat("long form thoughts ", 50), nil, ContentArticle},
+		{1, "worth reading https://example.com/post", nil, ContentLink},
+		{1, "gm", nil, ContentText},
+		{articleKind, "short", nil, ContentArticle},
+		{7, "+", nil, ""},
+	}
+	for _, c := range cases {
+		event := &nostr.Event{Kind: c.kind, Content: c.content, Tags: c.tags}
+		assert.Equal(t, c.expected, classifyContent(event), c.content)
+	}
+
+	assert.Nil(t, contentTypeParam(&nostr.Event{Kind: 7, Content: "+"}))
+}
+
+func TestNormalizeContentTypes(t *testing.T) {
+	assert.Equal(t, []string{ContentBuilder, ContentMeme}, normalizeContentTypes([]string{"Builders", " memes ", "builder", "cats"}))
+	assert.Equal(t, []string{}, normalizeContentTypes(nil))
+}
+
+func TestProfileAuthor(t *testing.T) {
+	profile := profileAuthor(
+		map[string]int64{ContentBuilder: 6, ContentMeme: 3, ContentText: 1},
+		map[string]int64{"golang": 4, "nostr": 4, "bitcoin": 2, "food": 1},
+		0.3, 3)
+	assert.Equal(t, []string{ContentBuilder, ContentMeme}, profile.contentTypes)
+	assert.Equal(t, []string{"golang", "nostr", "bitcoin"}, profile.topics)
+
+	profile = profileAuthor(map[string]int64{}, nil, 0.3, 3)
+	assert.Empty(t, profile.contentTypes)
+	assert.Empty(t, profile.topics)
+}
+
+func TestProfileBoost(t *testing.T) {
+	conf := types.AuthorProfileConfig{Boost: 1.5, Penalty: 0.5, ColdStartBoost: 0.5}
+	prefs := &contentPreferences{more: []string{ContentBuilder}, less: []string{ContentMeme}}
+
+	assert.Equal(t, 1.5, profileBoost(conf, prefs, authorProfile{contentTypes: []string{ContentBuilder}}))
+	assert.Equal(t, 0.5, profileBoost(conf, prefs, authorProfile{contentTypes: []string{ContentMeme}}))
+	assert.Equal(t, 0.75, profileBoost(conf, prefs, authorProfile{contentTypes: []string{ContentBuilder, ContentMeme}}))
+	assert.Equal(t, 1.0, profileBoost(conf, prefs, authorProfile{contentTypes: []string{ContentText}}))
+
+	// only subscribers following nobody have their interests matched
+	prefs.coldInterests = []string{"golang", "nostr"}
+	assert.Equal(t, 3.0, profileBoost(conf, prefs, authorProfile{contentTypes: []string{ContentBuilder}, topics: []string{"golang", "nostr", "food"}}))
+}
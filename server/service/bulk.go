@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// bulkWrite holds the parameter lists of a batch written with UNWIND
+type bulkWrite struct {
+	events  []*nostr.Event
+	users   []string
+	posts   []map[string]any
+	tagged  []map[string]any
+	replies []map[string]any
+	roots   []map[string]any
+	likes   []map[string]any
+	zaps    []map[string]any
+	// reactions recorded as "less like this" feedback once written
+	dislikes []*nostr.Event
+}
+
+// planBulk splits events into those written in bulk and the indexes of those
+// stored one by one: events of other kinds, and those needing lookups while
+// written, i.e. references to articles by address and reactions of curators.
+// Zap receipts that can't be parsed or don't zap a post are dropped, as they
+// are by StoreZap.
+func planBulk(events []*nostr.Event, isCurator func(pubkey string) bool) (*bulkWrite, []int) {
+	b := &bulkWrite{}
+	singles := []int{}
+	users := map[string]bool{}
+
+	for i, ev := range events {
+		if ev.Tags.GetFirst([]string{"a", fmt.Sprintf("%d:", articleKind)}) != nil {
+			singles = append(singles, i)
+			continue
+		}
+
+		switch ev.Kind {
+		case 1:
+			for _, topic := range postTopics(ev) {
+				b.tagged = append(b.tagged, map[string]any{"id": ev.ID, "topic": topic})
+			}
+			root, parent := threadRefs(ev)
+			if parent != "" {
+				b.replies = append(b.replies, map[string]any{"id": ev.ID, "ref": parent})
+			}
+			if root != "" {
+				b.roots = append(b.roots, map[string]any{"id": ev.ID, "ref": root})
+			}
+		case 7:
+			if isCurator(ev.PubKey) {
+				singles = append(singles, i)
+				continue
+			}
+			if ref := ev.Tags.GetFirst([]string{"e"}); ref != nil {
+				polarity, emoji := parseReaction(ev.Content)
+				b.likes = append(b.likes, map[string]any{"id": ev.ID, "ref": ref.Value(), "polarity": polarity, "emoji": emoji})
+				if isNegativeReaction(ev.Content) {
+					b.dislikes = append(b.dislikes, ev)
+				}
+			}
+		case 9735:
+			receipt, err := ParseZapReceipt(ev)
+			ref := ev.Tags.GetFirst([]string{"e"})
+			if err != nil || ref == nil {
+				continue
+			}
+			// zap posts are only created for zaps of known posts, see below
+			b.events = append(b.events, ev)
+			b.zaps = append(b.zaps, map[string]any{
+				"id": ev.ID, "kind": ev.Kind, "author": ev.PubKey, "created_at": ev.CreatedAt.Unix(),
+				"ref": ref.Value(), "amount": receipt.Amount, "sender": receipt.Sender,
+			})
+			continue
+		default:
+			singles = append(singles, i)
+			continue
+		}
+
+		b.events = append(b.events, ev)
+		if !users[ev.PubKey] {
+			users[ev.PubKey] = true
+			b.users = append(b.users, ev.PubKey)
+		}
+		b.posts = append(b.posts, map[string]any{"id": ev.ID, "kind": ev.Kind, "author": ev.PubKey, "created_at": ev.CreatedAt.Unix(), "content_type": contentTypeParam(ev)})
+	}
+	return b, singles
+}
+
+// queries of a bulk write, run in this order so that relations between
+// posts of the same batch are created
+var bulkQueries = []struct {
+	param string
+	query string
+}{
+	{"Users", "UNWIND $Users AS pubkey MERGE (:User {pubkey: pubkey});"},
+	{"Posts", `
+		UNWIND $Posts AS e
+		MERGE (p:Post {id: e.id})
+		ON CREATE SET p.kind = e.kind, p.author = e.author, p.created_at = e.created_at, p.content_type = e.content_type,
+			p.stored_at = timestamp() / 1000
+		WITH p, e
+		MATCH (u:User {pubkey: e.author})
+		MERGE (u)-[:CREATE]->(p);
+	`},
+	{"Tagged", `
+		UNWIND $Tagged AS t
+		MATCH (p:Post {id: t.id})
+		MERGE (topic:Topic {name: t.topic})
+		MERGE (p)-[:TAGGED]->(topic);
+	`},
+	{"Replies", `
+		UNWIND $Replies AS r
+		MATCH (p:Post {id: r.id}), (parent:Post {id: r.ref})
+		MERGE (p)-[:REPLY_TO]->(parent);
+	`},
+	{"Roots", `
+		UNWIND $Roots AS r
+		MATCH (p:Post {id: r.id}), (root:Post {id: r.ref})
+		MERGE (p)-[:ROOT]->(root);
+	`},
+	{"Likes", `
+		UNWIND $Likes AS l
+		MATCH (p:Post {id: l.id}), (r:Post {id: l.ref})
+		MERGE (p)-[x:LIKE]->(r)
+		SET x.polarity = l.polarity, x.emoji = l.emoji;
+	`},
+	{"Zaps", `
+		UNWIND $Zaps AS z
+		MATCH (r:Post {id: z.ref})
+		MERGE (u:User {pubkey: z.author})
+		MERGE (p:Post {id: z.id})
+		ON CREATE SET p.kind = z.kind, p.author = z.author, p.created_at = z.created_at, p.stored_at = timestamp() / 1000
+		MERGE (u)-[:CREATE]->(p)
+		MERGE (p)-[x:ZAP {amount: z.amount}]->(r)
+		SET x.sender = z.sender;
+	`},
+}
+
+type bulkParam interface {
+	Len() int
+	Value() any
+}
+
+type stringsParam []string
+
+func (p stringsParam) Len() int   { return len(p) }
+func (p stringsParam) Value() any { return []string(p) }
+
+type mapsParam []map[string]any
+
+func (p mapsParam) Len() int   { return len(p) }
+func (p mapsParam) Value() any { return []map[string]any(p) }
+
+func (b *bulkWrite) params() map[string]bulkParam {
+	return map[string]bulkParam{
+		"Users":   stringsParam(b.users),
+		"Posts":   mapsParam(b.posts),
+		"Tagged":  mapsParam(b.tagged),
+		"Replies": mapsParam(b.replies),
+		"Roots":   mapsParam(b.roots),
+		"Likes":   mapsParam(b.likes),
+		"Zaps":    mapsParam(b.zaps),
+	}
+}
+
+// StoreEvents stores a batch of events, unless they were stored recently.
+// Notes, reactions and zaps are written together in a single transaction,
+// other events one by one.
+func (s *Service) StoreEvents(events []*nostr.Event) error {
+	kept := events
+	if s.dedup != nil {
+		kept = make([]*nostr.Event, 0, len(events))
+		for _, ev := range events {
+			if s.dedup.add(ev.ID) {
+				kept = append(kept, ev)
+			}
+		}
+	}
+
+	var first error
+	for i, err := range s.storeEvents(kept) {
+		if err == nil {
+			continue
+		}
+		// events failing are stored again when received again
+		if s.dedup != nil {
+			s.dedup.forget(kept[i].ID)
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// storeEvents returns the result of storing each event. Events go through
+// the same steps as those stored one by one, see prepareStore.
+func (s *Service) storeEvents(events []*nostr.Event) []error {
+	errs := make([]error, len(events))
+
+	write, err := s.prepareStore(events)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	// events kept and their indexes in events
+	kept := make([]*nostr.Event, 0, len(events))
+	indexes := make([]int, 0, len(events))
+	for i, ev := range events {
+		if write[i] {
+			kept = append(kept, ev)
+			indexes = append(indexes, i)
+		}
+	}
+
+	ctx := context.Background()
+	b, singles := planBulk(kept, func(pubkey string) bool { return s.curatorWeight(pubkey) > 0 })
+	for _, i := range singles {
+		errs[indexes[i]] = s.archiveAndStore(ctx, kept[i], s.writeEvent)
+	}
+	if len(b.events) == 0 {
+		return errs
+	}
+
+	if s.archiver != nil && s.config.Archive.Stage != "after" {
+		for _, ev := range b.events {
+			if err := s.archiver.Append(ctx, ev); err != nil {
+				logger.Error("Failed to archive event", "id", ev.ID, "err", err)
+			}
+		}
+	}
+
+	err = s.writeBulk(ctx, b)
+	if err == nil && s.archiver != nil && s.config.Archive.Stage == "after" {
+		for _, ev := range b.events {
+			if err = s.archiver.Append(ctx, ev); err != nil {
+				break
+			}
+		}
+	}
+
+	bulk := map[*nostr.Event]bool{}
+	for _, ev := range b.events {
+		bulk[ev] = true
+	}
+	for i, ev := range events {
+		if bulk[ev] {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (s *Service) writeBulk(ctx context.Context, b *bulkWrite) error {
+	for _, ev := range b.events {
+		if err := s.writeObject(ev); err != nil {
+			logger.Error("Failed to write object", "id", ev.ID, "err", err)
+			return err
+		}
+	}
+
+	params := b.params()
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		for _, q := range bulkQueries {
+			list := params[q.param]
+			if list.Len() == 0 {
+				continue
+			}
+			if _, err := tx.Run(ctx, q.query, map[string]any{q.param: list.Value()}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// negative reactions of subscribers are "less like this" feedback
+	if err := s.recordDislikes(ctx, b.dislikes); err != nil {
+		logger.Warn("Failed to record negative reactions", "reactions", len(b.dislikes), "err", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanBulk(t *testing.T) {
+	events := []*nostr.Event{
+		{ID: "note", Kind: 1, PubKey: "alice", Content: "gm #nostr"},
+		{ID: "reply", Kind: 1, PubKey: "bob", Tags: nostr.Tags{{"e", "note", "", "root"}}},
+		{ID: "like", Kind: 7, PubKey: "bob", Content: "+", Tags: nostr.Tags{{"e", "note"}}},
+		{ID: "dislike", Kind: 7, PubKey: "carol", Content: "-", Tags: nostr.Tags{{"e", "note"}}},
+		{ID: "curated", Kind: 7, PubKey: "curator", Content: "+", Tags: nostr.Tags{{"e", "note"}}},
+		{ID: "zap", Kind: 9735, PubKey: "lnurl"},
+		{ID: "comment", Kind: 1, PubKey: "alice", Tags: nostr.Tags{{"a", "30023:alice:post"}}},
+		{ID: "profile", Kind: 0, PubKey: "alice"},
+	}
+
+	b, singles := planBulk(events, func(pubkey string) bool { return pubkey == "curator" })
+
+	assert.Equal(t, []int{4, 6, 7}, singles)
+	assert.Len(t, b.events, 4)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, b.users)
+	assert.Len(t, b.posts, 4)
+	assert.Equal(t, []map[string]any{{"id": "note", "topic": "nostr"}}, b.tagged)
+	assert.Equal(t, []map[string]any{{"id": "reply", "ref": "note"}}, b.replies)
+	assert.Equal(t, []map[string]any{{"id": "reply", "ref": "note"}}, b.roots)
+	assert.Equal(t, []map[string]any{
+		{"id": "like", "ref": "note", "polarity": reactionUp, "emoji": ""},
+		{"id": "dislike", "ref": "note", "polarity": reactionDown, "emoji": ""},
+	}, b.likes)
+	assert.Equal(t, []*nostr.Event{events[3]}, b.dislikes)
+	assert.Empty(t, b.zaps)
+}
+
+func TestStoreEventsPrepared(t *testing.T) {
+	s := newSQLiteService(t)
+	s.dedup = newDedupCache(10)
+	s.heartbeats = newHeartbeats()
+	at := time.Unix(time.Now().Unix()-60, 0)
+	repost := &nostr.Event{ID: eventId(2), Kind: 6, PubKey: "bob", CreatedAt: at, Tags: nostr.Tags{{"e", eventId(1)}}}
+
+	// events stored in a batch go through the same steps as one by one
+	assert.NoError(t, s.StoreEvents([]*nostr.Event{
+		{ID: eventId(1), Kind: 0, PubKey: "alice", CreatedAt: at},
+		repost,
+		repost,
+	}))
+	assert.Equal(t, map[string]int64{"bob": at.Unix()}, s.heartbeats.drain())
+	assert.False(t, s.dedup.add(repost.ID))
+}
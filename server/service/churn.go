@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const (
+	heartbeatFlushInterval = time.Minute
+	// share of inactivity in the churn risk of surveyed subscribers, the
+	// rest is their dissatisfaction
+	churnIdleWeight = 0.7
+)
+
+// heartbeats buffers the latest activity of authors until it's flushed to
+// their subscriber node, if they have one
+type heartbeats struct {
+	mu   sync.Mutex
+	seen map[string]int64
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{seen: map[string]int64{}}
+}
+
+func (h *heartbeats) beat(pubkey string, at int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if at > h.seen[pubkey] {
+		h.seen[pubkey] = at
+	}
+}
+
+func (h *heartbeats) drain() map[string]int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	seen := h.seen
+	h.seen = map[string]int64{}
+	return seen
+}
+
+// isActivity tells if events of a kind show that their author is around
+func isActivity(kind int) bool {
+	switch kind {
+	case 1, articleKind, 6, 7, 16:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Service) recordHeartbeat(event *nostr.Event) {
+	if s.heartbeats == nil || !isActivity(event.Kind) {
+		return
+	}
+
+	// skewed clocks can't push the heartbeat into the future
+	at := event.CreatedAt.Unix()
+	if now := time.Now().Unix(); at > now {
+		at = now
+	}
+	s.heartbeats.beat(event.PubKey, at)
+}
+
+// flushHeartbeats stores the activity seen since the last flush on the
+// subscribers, activity of other users is dropped
+func (s *Service) flushHeartbeats() error {
+	defer s.subscribers.clear()
+	seen := s.heartbeats.drain()
+	if len(seen) == 0 {
+		return nil
+	}
+
+	beats := make([]map[string]any, 0, len(seen))
+	for pubkey, at := range seen {
+		beats = append(beats, map[string]any{"Pubkey": pubkey, "At": at})
+	}
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Beats AS b
+			MATCH (s:Subscriber {pubkey: b.Pubkey})
+			WHERE coalesce(s.last_seen_at, 0) < b.At
+			SET s.last_seen_at = b.At;
+		`
+		_, err := tx.Run(ctx, query, map[string]any{"Beats": beats})
+		return nil, err
+	})
+	if err != nil {
+		// keep them for the next flush
+		for pubkey, at := range seen {
+			s.heartbeats.beat(pubkey, at)
+		}
+	}
+	return err
+}
+
+func (s *Service) startHeartbeatFlusher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(heartbeatFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.flushHeartbeats(); err != nil {
+					logger.Error("Failed to flush heartbeats", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// churnRisk predicts how likely a subscriber is to leave, from 0 to 1. The
+// risk grows with the time the subscriber has been idle and is full after
+// horizon. If the subscriber rated the digest, a low rating adds to it.
+func churnRisk(idle, horizon time.Duration, surveyScore, scale int) float64 {
+	risk := 1.0
+	if horizon > 0 {
+		risk = math.Min(1, math.Max(0, float64(idle)/float64(horizon)))
+	}
+	if surveyScore <= 0 || scale <= 1 {
+		return risk
+	}
+
+	dissatisfaction := float64(scale-surveyScore) / float64(scale-1)
+	return churnIdleWeight*risk + (1-churnIdleWeight)*dissatisfaction
+}
+
+// UpdateChurnRisk predicts the churn risk of active subscribers and stores it
+// on them. Subscribers never seen active are idle since they subscribed. It
+// returns the number of subscribers scored.
+func (s *Service) UpdateChurnRisk(ctx context.Context, now time.Time) (int, error) {
+	defer s.subscribers.clear()
+	conf := s.config.Churn
+	horizon := parseDurationOr(conf.Horizon, 30*24*time.Hour)
+
+	risks, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber) WHERE s.unsubscribed_at IS NULL
+			OPTIONAL MATCH (s)-[:ANSWERED]->(r:SurveyResponse)
+			WITH s, r ORDER BY r.answered_at DESC
+			WITH s, head(collect(r.score)) AS score
+			RETURN s.pubkey, coalesce(s.last_seen_at, s.subscribed_at), coalesce(score, 0);
+		`
+		result, err := tx.Run(ctx, query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		risks := []map[string]any{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			idle := now.Sub(time.Unix(values[1].(int64), 0))
+			risks = append(risks, map[string]any{
+				"Pubkey": values[0].(string),
+				"Risk":   churnRisk(idle, horizon, int(values[2].(int64)), s.config.Survey.Scale),
+			})
+		}
+		return risks, result.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	scored := risks.([]map[string]any)
+	_, err = s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Risks AS r
+			MATCH (s:Subscriber {pubkey: r.Pubkey})
+			SET s.churn_risk = r.Risk, s.churn_scored_at = $Now;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Risks": scored,
+				"Now":   now.Unix(),
+			})
+		return nil, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slowed, atRisk := int64(0), int64(0)
+	for _, r := range scored {
+		risk := r["Risk"].(float64)
+		if risk >= conf.SlowdownRisk {
+			slowed++
+		}
+		if risk >= conf.ReengageRisk {
+			atRisk++
+		}
+	}
+	metrics.NewGauge("subscribers/churn/slowed").Update(slowed)
+	metrics.NewGauge("subscribers/churn/at_risk").Update(atRisk)
+
+	logger.Info("Updated churn risk", "subscribers", len(scored), "slowed", slowed, "atRisk", atRisk)
+	return len(scored), nil
+}
+
+// MarkReengaged records that a re-engagement message was sent to the
+// subscriber
+func (s *Service) MarkReengaged(ctx context.Context, pubkey string, reengagedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.last_reengaged_at = $ReengagedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":      pubkey,
+				"ReengagedAt": reengagedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// GetChurnRisks returns active subscribers with a churn risk of at least
+// minRisk, riskiest first
+func (s *Service) GetChurnRisks(minRisk float64) ([]types.ChurnRisk, error) {
+	risks, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber)
+			WHERE s.unsubscribed_at IS NULL AND coalesce(s.churn_risk, 0.0) >= $MinRisk
+			RETURN s.pubkey, coalesce(s.churn_risk, 0.0) AS risk, s.last_seen_at, s.last_reengaged_at
+			ORDER BY risk DESC;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"MinRisk": minRisk})
+		if err != nil {
+			return nil, err
+		}
+
+		risks := []types.ChurnRisk{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			risks = append(risks, types.ChurnRisk{
+				Pubkey:      values[0].(string),
+				Risk:        values[1].(float64),
+				LastSeenAt:  optionalTime(values[2]),
+				ReengagedAt: optionalTime(values[3]),
+			})
+		}
+		return risks, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return risks.([]types.ChurnRisk), nil
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChurnRisk(t *testing.T) {
+	horizon := 30 * 24 * time.Hour
+
+	assert.Equal(t, 0.0, churnRisk(0, horizon, 0, 5))
+	assert.InDelta(t, 0.5, churnRisk(horizon/2, horizon, 0, 5), 1e-9)
+	assert.Equal(t, 1.0, churnRisk(2*horizon, horizon, 0, 5))
+
+	// ratings weigh in: a delighted idle subscriber is safer than an idle one
+	assert.InDelta(t, 0.35, churnRisk(horizon/2, horizon, 5, 5), 1e-9)
+	assert.InDelta(t, 0.65, churnRisk(horizon/2, horizon, 1, 5), 1e-9)
+}
+
+func TestHeartbeats(t *testing.T) {
+	h := newHeartbeats()
+	h.beat("alice", 20)
+	h.beat("alice", 10)
+	h.beat("bob", 5)
+
+	assert.Equal(t, map[string]int64{"alice": 20, "bob": 5}, h.drain())
+	assert.Empty(t, h.drain())
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// curator votes may promote posts outside the graph's top list, so more
+// candidates than requested are fetched before reranking
+const curationCandidateFactor = 3
+
+// StoreLabel records NIP-32 boost labels issued by curators. Labels from
+// other users are ignored.
+func (s *Service) StoreLabel(event *nostr.Event) error {
+	if s.curatorWeight(event.PubKey) == 0 {
+		return nil
+	}
+
+	conf := s.config.Curation
+	boosted := false
+	for _, tag := range event.Tags.GetAll([]string{"l"}) {
+		if tag.Value() == conf.Label && (len(tag) < 3 || tag[2] == conf.Namespace) {
+			boosted = true
+		}
+	}
+	if !boosted {
+		return nil
+	}
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		return nil, s.saveCuratorBoost(ctx, tx, event)
+	})
+
+	return err
+}
+
+// saveCuratorBoost links the curator to every post referenced by the event
+func (s *Service) saveCuratorBoost(ctx context.Context, tx neo4j.ManagedTransaction, event *nostr.Event) error {
+	if _, err := tx.Run(ctx, "merge (u:User {pubkey: $Pubkey});",
+		map[string]any{
+			"Pubkey": event.PubKey,
+		}); err != nil {
+		return err
+	}
+
+	for _, ref := range event.Tags.GetAll([]string{"e"}) {
+		if _, err := tx.Run(ctx, "match (u:User), (p:Post) where u.pubkey = $Pubkey and p.id = $RefId merge (u)-[:BOOST]->(p);",
+			map[string]any{
+				"Pubkey": event.PubKey,
+				"RefId":  ref.Value(),
+			}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyCuratorVotes adds weighted curator boosts to the score of candidates, and merges posts voted by curators
+// within [start, end] that are not candidates yet.
+func (s *Service) applyCuratorVotes(ctx context.Context, feed []types.FeedEntry, start, end time.Time) []types.FeedEntry {
+	curators := s.config.Curation.Curators
+	if len(curators) == 0 {
+		return feed
+	}
+
+	pubkeys := make([]string, 0, len(curators))
+	for _, c := range curators {
+		pubkeys = append(pubkeys, c.Pubkey)
+	}
+
+	votes, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User)-[:BOOST]->(p:Post)
+			WHERE u.pubkey IN $Curators AND p.created_at > $Start AND p.created_at < $End AND p.deleted_at IS NULL
+			RETURN p.id, p.kind, p.author, p.created_at, u.pubkey;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Curators": pubkeys,
+				"Start":    start.Unix(),
+				"End":      end.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		votes := map[string][]string{}
+		posts := map[string]types.FeedEntry{}
+		for result.Next(ctx) {
+			record := result.Record()
+			id := record.Values[0].(string)
+			posts[id] = types.FeedEntry{
+				Id:        id,
+				Kind:      int(record.Values[1].(int64)),
+				Pubkey:    record.Values[2].(string),
+				CreatedAt: time.Unix(record.Values[3].(int64), 0),
+			}
+			votes[id] = append(votes[id], record.Values[4].(string))
+		}
+		return curatorVotes{votes: votes, posts: posts}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to query curator votes", "err", err)
+		return feed
+	}
+
+	cv := votes.(curatorVotes)
+	for id, post := range cv.posts {
+		if slices.IndexFunc(feed, func(e types.FeedEntry) bool { return e.Id == id }) >= 0 {
+			continue
+		}
+		raw, err := s.readObject(post.Id, post.CreatedAt)
+		if err != nil {
+			logger.Warn("Failed to read object", "id", post.Id, "err", err)
+			continue
+		}
+		post.Raw = raw
+		feed = append(feed, post)
+	}
+
+	for i := range feed {
+		for _, pubkey := range cv.votes[feed[i].Id] {
+			feed[i].Score += s.curatorWeight(pubkey)
+		}
+	}
+
+	return feed
+}
+
+type curatorVotes struct {
+	votes map[string][]string
+	posts map[string]types.FeedEntry
+}
+
+func (s *Service) curatorWeight(pubkey string) float64 {
+	for _, c := range s.config.Curation.Curators {
+		if c.Pubkey == pubkey {
+			return c.Weight
+		}
+	}
+	return 0
+}
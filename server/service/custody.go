@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// RequestChannelClaim records that the subscriber asked for the key of their
+// channel, which has to be confirmed before the channel is handed over
+func (s *Service) RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.RequestChannelClaim(ctx, pubkey, requestedAt)
+}
+
+// HandOverChannel forgets the secret of the subscriber's channel, keeping
+// only its public key and when it was handed over
+func (s *Service) HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.HandOverChannel(ctx, pubkey, channelPub, handedOverAt)
+}
+
+// GetHandedOverChannels returns the channels handed over to subscribers,
+// keyed by subscriber
+func (s *Service) GetHandedOverChannels(ctx context.Context) (map[string]string, error) {
+	return s.repo.GetHandedOverChannels(ctx)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/dyng/nosdaily/metrics"
+)
+
+// dedupCache remembers the events stored recently, so that duplicates
+// delivered by relays are dropped before reaching the database. The least
+// recently seen are evicted beyond its size, and may be stored again, which
+// writes nothing new. The relays each event was seen on are remembered as
+// well, and duplicates from new relays are queued to be recorded in batches.
+type dedupCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	keys  map[string]*list.Element
+	// relays events were seen on, waiting to be recorded
+	seen []map[string]any
+}
+
+type dedupEntry struct {
+	key    string
+	relays []string
+}
+
+func newDedupCache(size int) *dedupCache {
+	return &dedupCache{
+		size:  size,
+		order: list.New(),
+		keys:  map[string]*list.Element{},
+	}
+}
+
+// add records the key, and tells if it's new
+func (c *dedupCache) add(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.keys[key]; ok {
+		c.order.MoveToFront(e)
+		metrics.NewCounter("dedup/hits").Inc(1)
+		return false
+	}
+	c.keys[key] = c.order.PushFront(&dedupEntry{key: key})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(*dedupEntry).key)
+	}
+	metrics.NewCounter("dedup/misses").Inc(1)
+	return true
+}
+
+// addRelay records that the event of key was seen on relay, and tells if it
+// wasn't known to be before. Nothing is recorded for keys not cached.
+func (c *dedupCache) addRelay(key, relay string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.keys[key]
+	if !ok {
+		return true
+	}
+	entry := e.Value.(*dedupEntry)
+	for _, r := range entry.relays {
+		if r == relay {
+			return false
+		}
+	}
+	entry.relays = append(entry.relays, relay)
+	return true
+}
+
+// queueSeen queues relays events were seen on, and returns the number queued
+func (c *dedupCache) queueSeen(seen []map[string]any) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen = append(c.seen, seen...)
+	return len(c.seen)
+}
+
+// takeSeen returns the queued relays events were seen on, and empties the
+// queue
+func (c *dedupCache) takeSeen() []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := c.seen
+	c.seen = nil
+	return seen
+}
+
+// forget removes the key, so that an event failing to be stored is stored
+// again when delivered next
+func (c *dedupCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.keys[key]; ok {
+		c.order.Remove(e)
+		delete(c.keys, key)
+	}
+}
+
+// forgetOnError wraps ack to forget the key if the write failed
+func (c *dedupCache) forgetOnError(key string, ack func(error)) func(error) {
+	return func(err error) {
+		if err != nil {
+			c.forget(key)
+		}
+		if ack != nil {
+			ack(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupCache(t *testing.T) {
+	c := newDedupCache(2)
+	assert.True(t, c.add("a"))
+	assert.True(t, c.add("b"))
+	assert.False(t, c.add("a"))
+
+	// b is the least recently seen, so it's evicted first
+	assert.True(t, c.add("c"))
+	assert.True(t, c.add("b"))
+	assert.False(t, c.add("b"))
+
+	var acked error
+	ack := c.forgetOnError("b", func(err error) { acked = err })
+	ack(nil)
+	assert.False(t, c.add("b"))
+
+	// failed writes are let through next time
+	failed := errors.New("boom")
+	c.forgetOnError("b", func(err error) { acked = err })(failed)
+	assert.Equal(t, failed, acked)
+	assert.True(t, c.add("b"))
+}
+
+func TestDedupCacheRelays(t *testing.T) {
+	c := newDedupCache(2)
+	assert.True(t, c.add("a"))
+	assert.True(t, c.addRelay("a", "wss://one"))
+
+	// duplicates are only recorded once per relay
+	assert.False(t, c.add("a"))
+	assert.False(t, c.addRelay("a", "wss://one"))
+	assert.True(t, c.addRelay("a", "wss://two"))
+
+	assert.Equal(t, 1, c.queueSeen([]map[string]any{{"id": "a", "relay": "wss://two"}}))
+	assert.Len(t, c.takeSeen(), 1)
+	assert.Empty(t, c.takeSeen())
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// StoreDeletion honors a NIP-09 deletion request for posts referenced by id
+// or, for articles, by address. Only posts of the requesting author are
+// affected. Posts are marked deleted, or removed with their relations and
+// objects if hard deletion is configured. The ids and addresses are kept as
+// tombstones, so that deleted events received later aren't stored again.
+func (s *Service) StoreDeletion(event *nostr.Event) error {
+	ids, addresses := deletedRefs(event)
+	if len(ids) == 0 && len(addresses) == 0 {
+		return nil
+	}
+
+	deleted, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		tombstones := `
+			UNWIND $Refs AS ref
+			MERGE (t:Tombstone {ref: ref, author: $Author})
+			SET t.deleted_at = CASE
+				WHEN t.deleted_at > $DeletedAt THEN t.deleted_at
+				ELSE $DeletedAt
+			END;
+		`
+		if _, err := tx.Run(ctx, tombstones,
+			map[string]any{
+				"Refs":      append(append([]string{}, ids...), addresses...),
+				"Author":    event.PubKey,
+				"DeletedAt": event.CreatedAt.Unix(),
+			}); err != nil {
+			return nil, err
+		}
+
+		query := `
+			CALL {
+				MATCH (p:Post) WHERE p.id IN $Ids RETURN p
+				UNION
+				MATCH (p:Post) WHERE p.address IN $Addresses RETURN p
+			}
+			WITH p WHERE p.author = $Author AND p.deleted_at IS NULL
+			SET p.deleted_at = $DeletedAt
+			RETURN p.id;
+		`
+		if s.config.Deletion.Hard {
+			query = `
+				CALL {
+					MATCH (p:Post) WHERE p.id IN $Ids RETURN p
+					UNION
+					MATCH (p:Post) WHERE p.address IN $Addresses RETURN p
+				}
+				WITH p WHERE p.author = $Author
+				WITH p, p.id AS id
+				DETACH DELETE p
+				RETURN id;
+			`
+		}
+
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Ids":       ids,
+				"Addresses": addresses,
+				"Author":    event.PubKey,
+				"DeletedAt": event.CreatedAt.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		deleted := []string{}
+		for result.Next(ctx) {
+			deleted = append(deleted, result.Record().Values[0].(string))
+		}
+		return deleted, result.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range deleted.([]string) {
+		logger.Info("Deleted post", "id", id, "hard", s.config.Deletion.Hard)
+		if s.config.Deletion.Hard {
+			file, _ := s.objPath(id)
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				logger.Warn("Failed to remove object of deleted post", "id", id, "err", err)
+			}
+		}
+	}
+	return nil
+}
+
+// deletedRefs returns the ids and addresses a deletion request refers to
+func deletedRefs(event *nostr.Event) ([]string, []string) {
+	ids := []string{}
+	for _, tag := range event.Tags.GetAll([]string{"e"}) {
+		if tag.Value() != "" {
+			ids = append(ids, tag.Value())
+		}
+	}
+	addresses := []string{}
+	for _, tag := range event.Tags.GetAll([]string{"a"}) {
+		if tag.Value() != "" {
+			addresses = append(addresses, tag.Value())
+		}
+	}
+	return ids, addresses
+}
+
+// deletedEvents returns the ids of the events deleted by their author before
+// they were received: events whose id was deleted, and articles whose
+// address was deleted after they were created. Tombstones are only kept in
+// the graph.
+func (s *Service) deletedEvents(events []*nostr.Event) (map[string]bool, error) {
+	if !s.hasGraph() || len(events) == 0 {
+		return nil, nil
+	}
+
+	refs := make([]map[string]any, 0, len(events))
+	for _, ev := range events {
+		refs = append(refs, map[string]any{"id": ev.ID, "ref": ev.ID, "author": ev.PubKey, "created_at": ev.CreatedAt.Unix()})
+		if ev.Kind == articleKind {
+			if address := articleAddress(ev); address != "" {
+				refs = append(refs, map[string]any{"id": ev.ID, "ref": address, "author": ev.PubKey, "created_at": ev.CreatedAt.Unix()})
+			}
+		}
+	}
+
+	deleted, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Refs AS r
+			MATCH (t:Tombstone {ref: r.ref, author: r.author})
+			WHERE r.ref = r.id OR t.deleted_at >= r.created_at
+			RETURN DISTINCT r.id;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Refs": refs})
+		if err != nil {
+			return nil, err
+		}
+		deleted := map[string]bool{}
+		for result.Next(ctx) {
+			deleted[result.Record().Values[0].(string)] = true
+		}
+		return deleted, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted.(map[string]bool), nil
+}
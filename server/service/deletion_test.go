@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedRefs(t *testing.T) {
+	ids, addresses := deletedRefs(&nostr.Event{
+		Kind: 5,
+		Tags: nostr.Tags{
+			{"e", "note1"},
+			{"e", "note2"},
+			{"a", "30023:author:article"},
+			{"e"},
+			{"k", "1"},
+		},
+	})
+	assert.Equal(t, []string{"note1", "note2"}, ids)
+	assert.Equal(t, []string{"30023:author:article"}, addresses)
+
+	ids, addresses = deletedRefs(&nostr.Event{Kind: 5})
+	assert.Empty(t, ids)
+	assert.Empty(t, addresses)
+}
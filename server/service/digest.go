@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// RecordDigest keeps the metadata of a pushed digest. Digests are only kept
+// in the graph, with SQLite nothing is recorded.
+func (s *Service) RecordDigest(ctx context.Context, digest types.DigestMeta) error {
+	if !s.hasGraph() {
+		return nil
+	}
+
+	// null unless the digest asks for feedback
+	var feedbackNote any
+	if digest.FeedbackNote != "" {
+		feedbackNote = digest.FeedbackNote
+	}
+
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			CREATE (:Digest {
+				channel: $Channel,
+				subscriber: $Subscriber,
+				pushed_at: $PushedAt,
+				start: $Start,
+				end: $End,
+				widened: $Widened,
+				size: $Size,
+				feedback_note: $FeedbackNote
+			});
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Channel":      digest.Channel,
+				"Subscriber":   digest.Subscriber,
+				"PushedAt":     digest.PushedAt.Unix(),
+				"Start":        digest.Start.Unix(),
+				"End":          digest.End.Unix(),
+				"Widened":      digest.Widened,
+				"Size":         digest.Size,
+				"FeedbackNote": feedbackNote,
+			})
+		return nil, err
+	})
+	if err == nil {
+		s.feedback.add(digest.FeedbackNote, digest.PushedAt)
+	}
+	return err
+}
+
+// LastDigestAt returns when the last digest was pushed to the channel, or nil
+// if there was none or digests aren't recorded
+func (s *Service) LastDigestAt(ctx context.Context, channel string) (*time.Time, error) {
+	if !s.hasGraph() {
+		return nil, nil
+	}
+	last, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (d:Digest {channel: $Channel})
+			RETURN max(d.end);
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Channel": channel})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return optionalTime(record.Values[0]), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return last.(*time.Time), nil
+}
+
+// RecordReceipt keeps whether the digest of a subscriber was accepted in a
+// run. A digest that failed is pushed again by the next runs, which update
+// its receipt until it's accepted, so that attempts add up. Receipts are only
+// kept in the graph.
+func (s *Service) RecordReceipt(ctx context.Context, receipt types.DeliveryReceipt) error {
+	if !s.hasGraph() {
+		return nil
+	}
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			OPTIONAL MATCH (last:Receipt {subscriber: $Subscriber})
+			WITH last ORDER BY last.at DESC LIMIT 1
+			WITH CASE WHEN last.accepted = false OR last.run = $Run THEN last.run ELSE $Run END AS run
+			MERGE (r:Receipt {subscriber: $Subscriber, run: run})
+			SET
+				r.channel = $Channel,
+				r.accepted = $Accepted,
+				r.error = $Error,
+				r.attempts = coalesce(r.attempts, 0) + 1,
+				r.at = $At,
+				r.correlation_id = $CorrelationID;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Subscriber":    receipt.Subscriber,
+				"Run":           receipt.Run.Unix(),
+				"Channel":       receipt.Channel,
+				"Accepted":      receipt.Accepted,
+				"Error":         receipt.Error,
+				"At":            receipt.At.Unix(),
+				"CorrelationID": receipt.CorrelationID,
+			})
+		return nil, err
+	})
+	return err
+}
+
+// GetReceipts returns the latest receipts of a subscriber, newest first
+func (s *Service) GetReceipts(ctx context.Context, subscriber string, limit int) ([]types.DeliveryReceipt, error) {
+	receipts, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (r:Receipt {subscriber: $Subscriber})
+			RETURN r.channel, r.run, r.accepted, r.error, r.attempts, r.at, coalesce(r.correlation_id, '')
+			ORDER BY r.at DESC
+			LIMIT $Limit;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Subscriber": subscriber, "Limit": limit})
+		if err != nil {
+			return nil, err
+		}
+		receipts := []types.DeliveryReceipt{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			receipts = append(receipts, types.DeliveryReceipt{
+				Subscriber:    subscriber,
+				Channel:       values[0].(string),
+				Run:           time.Unix(values[1].(int64), 0),
+				Accepted:      values[2].(bool),
+				Error:         values[3].(string),
+				Attempts:      int(values[4].(int64)),
+				At:            time.Unix(values[5].(int64), 0),
+				CorrelationID: values[6].(string),
+			})
+		}
+		return receipts, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipts.([]types.DeliveryReceipt), nil
+}
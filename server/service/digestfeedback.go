@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const (
+	FeedbackSourceReaction = "reaction"
+	FeedbackSourceReply    = "reply"
+)
+
+// feedbackNotes keeps the notes asking to rate recent digests, so that
+// ingested reactions and replies are matched without reading the database.
+// Notes older than the window are dropped. A nil set matches nothing.
+type feedbackNotes struct {
+	window time.Duration
+
+	mu    sync.Mutex
+	notes map[string]time.Time
+	swept time.Time
+}
+
+func newFeedbackNotes(window time.Duration) *feedbackNotes {
+	return &feedbackNotes{window: window, notes: map[string]time.Time{}}
+}
+
+func (f *feedbackNotes) add(id string, pushedAt time.Time) {
+	if f == nil || id == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.notes[id] = pushedAt
+	f.sweep(time.Now())
+}
+
+// match returns the feedback note the event refers to, if it's within the
+// window
+func (f *feedbackNotes) match(event *nostr.Event) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		if pushedAt, ok := f.notes[tag[1]]; ok && event.CreatedAt.Sub(pushedAt) <= f.window {
+			return tag[1], true
+		}
+	}
+	return "", false
+}
+
+// sweep drops expired notes, at most once an hour
+func (f *feedbackNotes) sweep(now time.Time) {
+	if now.Sub(f.swept) < time.Hour {
+		return
+	}
+	f.swept = now
+	for id, pushedAt := range f.notes {
+		if now.Sub(pushedAt) > f.window {
+			delete(f.notes, id)
+		}
+	}
+}
+
+// loadFeedbackNotes restores the feedback notes of digests pushed within the
+// window
+func (s *Service) loadFeedbackNotes() error {
+	notes, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (d:Digest)
+			WHERE d.feedback_note IS NOT NULL AND d.pushed_at >= $Since
+			RETURN d.feedback_note, d.pushed_at;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since": time.Now().Add(-s.feedback.window).Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		notes := map[string]time.Time{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			notes[values[0].(string)] = time.Unix(values[1].(int64), 0)
+		}
+		return notes, result.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, pushedAt := range notes.(map[string]time.Time) {
+		s.feedback.add(id, pushedAt)
+	}
+	return nil
+}
+
+// recordDigestFeedback records a reaction or a reply to the feedback note of
+// a digest as a rating of the digest. Other events are ignored.
+func (s *Service) recordDigestFeedback(event *nostr.Event) {
+	if event.Kind != 1 && event.Kind != 7 {
+		return
+	}
+	note, ok := s.feedback.match(event)
+	if !ok {
+		return
+	}
+
+	score, source := parseFeedback(event, s.config.Digest.Feedback.Scale)
+	if score == 0 {
+		logger.Debug("Reply to digest is not a rating", "id", event.ID, "note", note)
+		return
+	}
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// the latest rating of each user counts, the channel's own
+		// events don't
+		query := `
+			MATCH (d:Digest {feedback_note: $Note})
+			WHERE d.channel <> $Pubkey
+			MERGE (f:DigestFeedback {note: $Note, pubkey: $Pubkey})
+			ON CREATE SET f.subscriber = d.subscriber, f.run = d.end
+			WITH f
+			WHERE coalesce(f.at, 0) <= $At
+			SET f.score = $Score, f.positive = $Positive, f.source = $Source, f.at = $At;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Note":     note,
+				"Pubkey":   event.PubKey,
+				"Score":    score,
+				"Positive": isPositiveRating(score, s.config.Digest.Feedback.Scale),
+				"Source":   source,
+				"At":       event.CreatedAt.Unix(),
+			})
+		return nil, err
+	})
+	if err != nil {
+		logger.Warn("Failed to record digest feedback", "id", event.ID, "note", note, "err", err)
+		return
+	}
+	metrics.NewCounter("digests/feedback/" + source).Inc(1)
+}
+
+// parseFeedback rates a digest from a reaction or a reply to its feedback
+// note. Likes count as the top of the scale and dislikes as 1, replies are
+// rated by their first number, or as a reaction if they're just one. The
+// score is 0 if the event isn't a rating.
+func parseFeedback(event *nostr.Event, scale int) (score int, source string) {
+	if event.Kind == 7 {
+		return reactionRating(event.Content, scale), FeedbackSourceReaction
+	}
+
+	content := strings.TrimSpace(event.Content)
+	field := strings.FieldsFunc(content, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	if len(field) > 0 {
+		score, err := strconv.Atoi(field[0])
+		if err != nil || score < 1 || score > scale {
+			return 0, FeedbackSourceReply
+		}
+		return score, FeedbackSourceReply
+	}
+	if content == "+" || content == "-" || content == "👍" || isNegativeReaction(content) {
+		return reactionRating(content, scale), FeedbackSourceReply
+	}
+	return 0, FeedbackSourceReply
+}
+
+func reactionRating(content string, scale int) int {
+	if polarity, _ := parseReaction(content); polarity == reactionDown {
+		return 1
+	}
+	return scale
+}
+
+// isPositiveRating tells if a rating is above the middle of the scale
+func isPositiveRating(score, scale int) bool {
+	return 2*score > scale+1
+}
+
+// GetDigestFeedback returns ratings of digests given since the time, latest
+// first
+func (s *Service) GetDigestFeedback(since time.Time) ([]types.DigestFeedback, error) {
+	feedback, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (f:DigestFeedback)
+			WHERE f.at >= $Since
+			RETURN f.note, f.subscriber, f.run, f.pubkey, f.score, f.source, f.at
+			ORDER BY f.at DESC;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since": since.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		feedback := []types.DigestFeedback{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			subscriber, _ := values[1].(string)
+			feedback = append(feedback, types.DigestFeedback{
+				Note:       values[0].(string),
+				Subscriber: subscriber,
+				Run:        time.Unix(values[2].(int64), 0),
+				Pubkey:     values[3].(string),
+				Score:      int(values[4].(int64)),
+				Source:     values[5].(string),
+				At:         time.Unix(values[6].(int64), 0),
+			})
+		}
+		return feedback, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return feedback.([]types.DigestFeedback), nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFeedback(t *testing.T) {
+	cases := []struct {
+		kind    int
+		content string
+		score   int
+		source  string
+	}{
+		{7, "+", 5, FeedbackSourceReaction},
+		{7, "🤙", 5, FeedbackSourceReaction},
+		{7, "-", 1, FeedbackSourceReaction},
+		{7, "👎", 1, FeedbackSourceReaction},
+		{1, "4, pretty good", 4, FeedbackSourceReply},
+		{1, "I'd say 9", 0, FeedbackSourceReply},
+		{1, "👍", 5, FeedbackSourceReply},
+		{1, " 👎 ", 1, FeedbackSourceReply},
+		{1, "great digest!", 0, FeedbackSourceReply},
+	}
+	for _, c := range cases {
+		score, source := parseFeedback(&nostr.Event{Kind: c.kind, Content: c.content}, 5)
+		assert.Equal(t, c.score, score, c.content)
+		assert.Equal(t, c.source, source, c.content)
+	}
+
+	assert.True(t, isPositiveRating(4, 5))
+	assert.False(t, isPositiveRating(3, 5))
+	assert.False(t, isPositiveRating(1, 5))
+}
+
+func TestFeedbackNotes(t *testing.T) {
+	now := time.Now()
+	notes := newFeedbackNotes(time.Hour)
+	notes.add("note", now)
+
+	// replies refer to the note as their root
+	reply := &nostr.Event{Kind: 1, CreatedAt: now.Add(time.Minute), Tags: nostr.Tags{{"e", "note", "", "root"}, {"p", "channel"}}}
+	note, ok := notes.match(reply)
+	assert.True(t, ok)
+	assert.Equal(t, "note", note)
+
+	// too late
+	reply.CreatedAt = now.Add(2 * time.Hour)
+	_, ok = notes.match(reply)
+	assert.False(t, ok)
+
+	_, ok = notes.match(&nostr.Event{Kind: 7, CreatedAt: now, Tags: nostr.Tags{{"e", "other"}}})
+	assert.False(t, ok)
+
+	var disabled *feedbackNotes
+	disabled.add("note", now)
+	_, ok = disabled.match(reply)
+	assert.False(t, ok)
+}
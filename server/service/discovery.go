@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// GetRisingAuthors returns the authors whose engagement grew fastest from
+// the period before the last one to the last one, e.g. from one month to the
+// next. Engagement is the number of distinct other users interacting
+// positively, so that a few keys can't make an author rise by interacting a
+// lot. With reputation enabled, only users of Discovery.MinReputation count.
+func (s *Service) GetRisingAuthors(ctx context.Context, now time.Time, period time.Duration, minEngagement, limit int) ([]types.RisingAuthor, error) {
+	authors, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User)-[:CREATE]->(r:Post)-[l:REPLY_TO|LIKE|REPOST|ZAP]->(p:Post)
+			WHERE r.created_at >= $PreviousStart AND r.created_at < $End
+				AND coalesce(l.polarity, 1) > 0 AND u.pubkey <> p.author
+				AND (NOT $Reputation OR u.reputation >= $MinReputation)
+			WITH p.author AS author,
+				count(DISTINCT CASE WHEN r.created_at >= $Start THEN u END) AS current,
+				count(DISTINCT CASE WHEN r.created_at < $Start THEN u END) AS previous
+			WHERE current >= $MinEngagement
+			OPTIONAL MATCH (a:User {pubkey: author})
+			RETURN author, current, previous,
+				CASE WHEN coalesce(a.display_name, '') <> '' THEN a.display_name ELSE coalesce(a.name, '') END;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"PreviousStart": now.Add(-2 * period).Unix(),
+				"Start":         now.Add(-period).Unix(),
+				"End":           now.Unix(),
+				"MinEngagement": minEngagement,
+				"Reputation":    s.config.Reputation.Enabled,
+				"MinReputation": s.config.Discovery.MinReputation,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		authors := []types.RisingAuthor{}
+		for result.Next(ctx) {
+			record := result.Record()
+			authors = append(authors, types.RisingAuthor{
+				Pubkey:             record.Values[0].(string),
+				Engagement:         record.Values[1].(int64),
+				PreviousEngagement: record.Values[2].(int64),
+				Label:              record.Values[3].(string),
+			})
+		}
+		return authors, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rankRising(authors.([]types.RisingAuthor), limit), nil
+}
+
+// rankRising sorts authors by the growth of their engagement. The growth of
+// authors not engaged with in the previous period is their engagement.
+func rankRising(authors []types.RisingAuthor, limit int) []types.RisingAuthor {
+	for i := range authors {
+		a := &authors[i]
+		previous := a.PreviousEngagement
+		if previous == 0 {
+			previous = 1
+		}
+		a.Growth = float64(a.Engagement-a.PreviousEngagement) / float64(previous)
+	}
+	sort.SliceStable(authors, func(i, j int) bool {
+		if authors[i].Growth != authors[j].Growth {
+			return authors[i].Growth > authors[j].Growth
+		}
+		return authors[i].Engagement > authors[j].Engagement
+	})
+	if len(authors) > limit {
+		authors = authors[:limit]
+	}
+	return authors
+}
+
+// GetFollowed tells which of the accounts the user follows
+func (s *Service) GetFollowed(ctx context.Context, pubkey string, pubkeys []string) (map[string]bool, error) {
+	followed, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (:User {pubkey: $Pubkey})-[:FOLLOW]->(f:User)
+			WHERE f.pubkey IN $Pubkeys
+			RETURN f.pubkey;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":  pubkey,
+				"Pubkeys": pubkeys,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		followed := map[string]bool{}
+		for result.Next(ctx) {
+			followed[result.Record().Values[0].(string)] = true
+		}
+		return followed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return followed.(map[string]bool), nil
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRankRising(t *testing.T) {
+	ranked := rankRising([]types.RisingAuthor{
+		{Pubkey: "steady", Engagement: 200, PreviousEngagement: 180},
+		{Pubkey: "new", Engagement: 30},
+		{Pubkey: "doubling", Engagement: 100, PreviousEngagement: 50},
+		{Pubkey: "falling", Engagement: 20, PreviousEngagement: 40},
+	}, 3)
+
+	assert.Len(t, ranked, 3)
+	assert.Equal(t, "new", ranked[0].Pubkey)
+	assert.Equal(t, 30.0, ranked[0].Growth)
+	assert.Equal(t, "doubling", ranked[1].Pubkey)
+	assert.Equal(t, 1.0, ranked[1].Growth)
+	assert.Equal(t, "steady", ranked[2].Pubkey)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/alert"
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+)
+
+// samples needed before a baseline is compared against
+const driftMinHistory = 3
+
+// SampleScores samples scores of the top posts of the last window and
+// compares their distribution with the median of recent samples. Shifts
+// beyond the threshold are reported to the operator.
+func (s *Service) SampleScores(ctx context.Context, now time.Time) types.ScoreDistribution {
+	conf := s.config.ScoreDrift
+	window := parseDurationOr(conf.Window, time.Hour)
+	posts := s.scorePosts(ctx, types.FeedQuery{Start: now.Add(-window), End: now, Limit: conf.Sample})
+
+	scores := make([]float64, len(posts))
+	for i, post := range posts {
+		scores[i] = post.Score
+	}
+	dist := summarizeScores(scores)
+	dist.Time = now
+
+	metrics.NewGauge("scores/count").Update(int64(dist.Count))
+	metrics.NewGaugeFloat64("scores/mean").Update(dist.Mean)
+	metrics.NewGaugeFloat64("scores/p50").Update(dist.P50)
+	metrics.NewGaugeFloat64("scores/p90").Update(dist.P90)
+	metrics.NewGaugeFloat64("scores/p99").Update(dist.P99)
+
+	s.driftMu.Lock()
+	if len(s.drift) >= driftMinHistory {
+		dist.Drift = scoreDrift(driftBaseline(s.drift), dist, conf.Threshold)
+	}
+	s.drift = append(s.drift, dist)
+	if history := conf.History; history > 0 && len(s.drift) > history {
+		s.drift = s.drift[len(s.drift)-history:]
+	}
+	s.driftMu.Unlock()
+
+	logger.Info("Sampled scores", "count", dist.Count, "p50", dist.P50, "p90", dist.P90, "drift", dist.Drift)
+	if len(dist.Drift) > 0 {
+		metrics.NewCounter("scores/drift").Inc(1)
+		s.alerter.Notify(ctx, alert.SeverityWarning, "score distribution drifted", strings.Join(dist.Drift, "; "))
+	}
+	return dist
+}
+
+// GetScoreDistributions returns recent samples of the score distribution,
+// oldest first
+func (s *Service) GetScoreDistributions() []types.ScoreDistribution {
+	s.driftMu.Lock()
+	defer s.driftMu.Unlock()
+	return append([]types.ScoreDistribution{}, s.drift...)
+}
+
+func (s *Service) startDriftMonitor(ctx context.Context) {
+	interval := parseDurationOr(s.config.ScoreDrift.Interval, time.Hour)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.SampleScores(ctx, now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func summarizeScores(scores []float64) types.ScoreDistribution {
+	dist := types.ScoreDistribution{Count: len(scores)}
+	if len(scores) == 0 {
+		return dist
+	}
+
+	sorted := append([]float64{}, scores...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, score := range sorted {
+		sum += score
+	}
+	dist.Mean = sum / float64(len(sorted))
+	dist.P50 = quantile(sorted, 0.5)
+	dist.P90 = quantile(sorted, 0.9)
+	dist.P99 = quantile(sorted, 0.99)
+	dist.Max = sorted[len(sorted)-1]
+	return dist
+}
+
+// quantile picks the nearest rank of q in sorted values
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// driftBaseline takes the median of each statistic over past samples, so a
+// single outlier doesn't move it
+func driftBaseline(history []types.ScoreDistribution) types.ScoreDistribution {
+	median := func(value func(types.ScoreDistribution) float64) float64 {
+		values := make([]float64, len(history))
+		for i, dist := range history {
+			values[i] = value(dist)
+		}
+		sort.Float64s(values)
+		return quantile(values, 0.5)
+	}
+	return types.ScoreDistribution{
+		Count: int(median(func(d types.ScoreDistribution) float64 { return float64(d.Count) })),
+		Mean:  median(func(d types.ScoreDistribution) float64 { return d.Mean }),
+		P50:   median(func(d types.ScoreDistribution) float64 { return d.P50 }),
+		P90:   median(func(d types.ScoreDistribution) float64 { return d.P90 }),
+		P99:   median(func(d types.ScoreDistribution) float64 { return d.P99 }),
+		Max:   median(func(d types.ScoreDistribution) float64 { return d.Max }),
+	}
+}
+
+// scoreDrift lists statistics deviating from the baseline by more than the
+// threshold, relative to the baseline
+func scoreDrift(baseline, dist types.ScoreDistribution, threshold float64) []string {
+	drift := []string{}
+	check := func(name string, base, value float64) {
+		if base == 0 {
+			return
+		}
+		if change := (value - base) / base; math.Abs(change) > threshold {
+			drift = append(drift, fmt.Sprintf("%s %.3g -> %.3g (%+.0f%%)", name, base, value, change*100))
+		}
+	}
+	check("count", float64(baseline.Count), float64(dist.Count))
+	check("p50", baseline.P50, dist.P50)
+	check("p90", baseline.P90, dist.P90)
+	check("p99", baseline.P99, dist.P99)
+	return drift
+}
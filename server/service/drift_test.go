@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeScores(t *testing.T) {
+	scores := make([]float64, 100)
+	for i := range scores {
+		scores[i] = float64(100 - i)
+	}
+
+	dist := summarizeScores(scores)
+	assert.Equal(t, 100, dist.Count)
+	assert.Equal(t, 50.5, dist.Mean)
+	assert.Equal(t, 50.0, dist.P50)
+	assert.Equal(t, 90.0, dist.P90)
+	assert.Equal(t, 99.0, dist.P99)
+	assert.Equal(t, 100.0, dist.Max)
+
+	assert.Equal(t, types.ScoreDistribution{}, summarizeScores(nil))
+}
+
+func TestScoreDrift(t *testing.T) {
+	history := []types.ScoreDistribution{
+		{Count: 500, P50: 10, P90: 40, P99: 80},
+		{Count: 480, P50: 11, P90: 42, P99: 90},
+		// an outlier doesn't move the baseline
+		{Count: 20, P50: 100, P90: 400, P99: 800},
+	}
+	baseline := driftBaseline(history)
+	assert.Equal(t, types.ScoreDistribution{Count: 480, P50: 11, P90: 42, P99: 90}, baseline)
+
+	assert.Empty(t, scoreDrift(baseline, types.ScoreDistribution{Count: 490, P50: 12, P90: 45, P99: 100}, 0.5))
+
+	// fewer posts scored, e.g. relays went down
+	assert.Equal(t, []string{"count 480 -> 100 (-79%)"}, scoreDrift(baseline, types.ScoreDistribution{Count: 100, P50: 11, P90: 42, P99: 90}, 0.5))
+
+	// a spam wave inflates the top scores
+	drift := scoreDrift(baseline, types.ScoreDistribution{Count: 480, P50: 11, P90: 90, P99: 300}, 0.5)
+	assert.Len(t, drift, 2)
+}
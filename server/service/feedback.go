@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	InterestSourceFeedback = "feedback"
+
+	// score multiplier applied for each "less" of an author or topic
+	lessPenalty = 0.5
+	// authors disliked this many times are excluded from the feed
+	lessExclusionThreshold = 3
+)
+
+// negative reactions understood as "less like this"
+var negativeReactions = []string{"-", "👎"}
+
+func isNegativeReaction(content string) bool {
+	return slices.Contains(negativeReactions, content)
+}
+
+type dislikes struct {
+	posts   map[string]bool
+	authors map[string]int
+	topics  []string
+}
+
+// RecordLess records that a subscriber wants to see less of a post, an
+// author or topics. A disliked post also counts against its author. Reposts
+// published by channels are resolved to the reposted post.
+func (s *Service) RecordLess(ctx context.Context, pubkey string, less types.LessFeedback) error {
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		now := time.Now().Unix()
+
+		author := less.Author
+		if less.PostId != "" {
+			query := `
+				MATCH (s:Subscriber {pubkey: $Pubkey}), (p:Post {id: $Id})
+				OPTIONAL MATCH (p)-[:REPOST]->(o:Post)
+				WITH s, CASE WHEN p.kind = 6 AND o IS NOT NULL THEN o ELSE p END AS target
+				MERGE (s)-[r:LESS]->(target)
+				ON CREATE SET r.at = $Now
+				RETURN target.author;
+			`
+			result, err := tx.Run(ctx, query,
+				map[string]any{
+					"Pubkey": pubkey,
+					"Id":     less.PostId,
+					"Now":    now,
+				})
+			if err != nil {
+				return nil, err
+			}
+			if result.Next(ctx) && author == "" {
+				author, _ = result.Record().Values[0].(string)
+			}
+		}
+
+		if author != "" {
+			// an explicit author request excludes the author right away
+			increment := 1
+			if less.Author != "" {
+				increment = lessExclusionThreshold
+			}
+
+			query := `
+				MATCH (s:Subscriber {pubkey: $Pubkey})
+				MERGE (u:User {pubkey: $Author})
+				MERGE (s)-[r:LESS_AUTHOR]->(u)
+				SET r.count = coalesce(r.count, 0) + $Increment, r.at = $Now;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Pubkey":    pubkey,
+					"Author":    author,
+					"Increment": increment,
+					"Now":       now,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(less.Topics) > 0 {
+			query := `
+				MATCH (s:Subscriber {pubkey: $Pubkey})
+				UNWIND $Topics AS topic
+				MERGE (t:Topic {name: topic})
+				MERGE (s)-[r:INTERESTED_IN]->(t)
+				SET r.weight = -1, r.source = $Source, r.updated_at = $Now;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Pubkey": pubkey,
+					"Topics": normalizeTopics(less.Topics),
+					"Source": InterestSourceFeedback,
+					"Now":    now,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	return err
+}
+
+// recordDislikes records negative reactions as "less like this" feedback,
+// as RecordLess does for a post, in a single transaction. Reactions of users
+// who aren't subscribers are skipped.
+func (s *Service) recordDislikes(ctx context.Context, events []*nostr.Event) error {
+	dislikes := make([]map[string]any, 0, len(events))
+	for _, ev := range events {
+		if ref := ev.Tags.GetFirst([]string{"e"}); ref != nil {
+			dislikes = append(dislikes, map[string]any{"pubkey": ev.PubKey, "id": ref.Value()})
+		}
+	}
+	if len(dislikes) == 0 {
+		return nil
+	}
+
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Dislikes AS d
+			MATCH (s:Subscriber {pubkey: d.pubkey}), (p:Post {id: d.id})
+			OPTIONAL MATCH (p)-[:REPOST]->(o:Post)
+			WITH s, CASE WHEN p.kind = 6 AND o IS NOT NULL THEN o ELSE p END AS target
+			MERGE (s)-[r:LESS]->(target)
+			ON CREATE SET r.at = $Now
+			WITH s, target WHERE target.author IS NOT NULL
+			MERGE (u:User {pubkey: target.author})
+			MERGE (s)-[a:LESS_AUTHOR]->(u)
+			SET a.count = coalesce(a.count, 0) + 1, a.at = $Now;
+		`
+		_, err := tx.Run(ctx, query, map[string]any{"Dislikes": dislikes, "Now": time.Now().Unix()})
+		return nil, err
+	})
+	return err
+}
+
+func (s *Service) getDislikes(ctx context.Context, pubkey string) (*dislikes, error) {
+	result, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		params := map[string]any{"Pubkey": pubkey}
+		d := &dislikes{
+			posts:   map[string]bool{},
+			authors: map[string]int{},
+			topics:  []string{},
+		}
+
+		result, err := tx.Run(ctx, "MATCH (:Subscriber {pubkey: $Pubkey})-[:LESS]->(p:Post) RETURN p.id;", params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			d.posts[result.Record().Values[0].(string)] = true
+		}
+
+		result, err = tx.Run(ctx, "MATCH (:Subscriber {pubkey: $Pubkey})-[r:LESS_AUTHOR]->(u:User) RETURN u.pubkey, r.count;", params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			d.authors[record.Values[0].(string)] = int(record.Values[1].(int64))
+		}
+
+		result, err = tx.Run(ctx, "MATCH (:Subscriber {pubkey: $Pubkey})-[r:INTERESTED_IN]->(t:Topic) WHERE r.weight < 0 RETURN t.name;", params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			d.topics = append(d.topics, result.Record().Values[0].(string))
+		}
+
+		return d, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*dislikes), nil
+}
+
+// applyDislikes drops disliked posts and excluded authors, and penalizes
+// authors and topics the subscriber asked to see less of
+func (s *Service) applyDislikes(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" {
+		return feed
+	}
+
+	d, err := s.getDislikes(ctx, subscriberPub)
+	if err != nil {
+		logger.Error("Failed to query dislikes", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+
+	filtered := make([]types.FeedEntry, 0, len(feed))
+	for _, entry := range feed {
+		if d.posts[entry.Id] || d.authors[entry.Pubkey] >= lessExclusionThreshold {
+			continue
+		}
+
+		penalties := d.authors[entry.Pubkey]
+		for _, topic := range extractTopics(entry.Raw) {
+			if slices.Contains(d.topics, topic) {
+				penalties++
+			}
+		}
+		entry.Score *= math.Pow(lessPenalty, float64(penalties))
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
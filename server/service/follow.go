@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// followGraph is the neighborhood of a subscriber in the follow graph
+type followGraph struct {
+	// accounts the subscriber follows
+	follows map[string]bool
+	// accounts followed by those, excluding direct follows
+	secondHop map[string]bool
+}
+
+func (s *Service) getFollowGraph(ctx context.Context, pubkey string) (*followGraph, error) {
+	result, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (:User {pubkey: $Pubkey})-[:FOLLOW]->(f:User)
+			OPTIONAL MATCH (f)-[:FOLLOW]->(ff:User)
+			RETURN f.pubkey, collect(ff.pubkey);
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Pubkey": pubkey})
+		if err != nil {
+			return nil, err
+		}
+
+		g := &followGraph{follows: map[string]bool{}, secondHop: map[string]bool{}}
+		for result.Next(ctx) {
+			record := result.Record()
+			g.follows[record.Values[0].(string)] = true
+			for _, ff := range record.Values[1].([]any) {
+				g.secondHop[ff.(string)] = true
+			}
+		}
+		for f := range g.follows {
+			delete(g.secondHop, f)
+		}
+		delete(g.secondHop, pubkey)
+		return g, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*followGraph), nil
+}
+
+// getEngagers returns the users who replied, liked, reposted or zapped each
+// of the posts
+func (s *Service) getEngagers(ctx context.Context, ids []string) (map[string][]string, error) {
+	result, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Ids AS id
+			MATCH (u:User)-[:CREATE]->(:Post)-[l:REPLY_TO|LIKE|REPOST|ZAP]->(p:Post {id: id})
+			WHERE coalesce(l.polarity, 1) > 0
+			RETURN id, collect(DISTINCT u.pubkey);
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Ids": ids})
+		if err != nil {
+			return nil, err
+		}
+
+		engagers := map[string][]string{}
+		for result.Next(ctx) {
+			record := result.Record()
+			for _, u := range record.Values[1].([]any) {
+				engagers[record.Values[0].(string)] = append(engagers[record.Values[0].(string)], u.(string))
+			}
+		}
+		return engagers, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(map[string][]string), nil
+}
+
+// applyFollowGraph boosts posts authored or engaged with by accounts the
+// subscriber follows, and to a lesser extent by accounts those follow
+func (s *Service) applyFollowGraph(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" || len(feed) == 0 {
+		return feed
+	}
+
+	g, err := s.getFollowGraph(ctx, subscriberPub)
+	if err != nil {
+		logger.Error("Failed to query follow graph", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+	if len(g.follows) == 0 {
+		return feed
+	}
+
+	ids := make([]string, 0, len(feed))
+	for _, entry := range feed {
+		ids = append(ids, entry.Id)
+	}
+	engagers, err := s.getEngagers(ctx, ids)
+	if err != nil {
+		logger.Error("Failed to query engagers", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+
+	for i := range feed {
+		feed[i].Score *= followBoost(s.config.Scoring, g, feed[i].Pubkey, engagers[feed[i].Id])
+	}
+	return feed
+}
+
+// followBoost is the score multiplier of a post. A followed author counts
+// like one followed engager, and engagers add up logarithmically so that a
+// few busy accounts can't dominate the feed.
+func followBoost(conf types.ScoringConfig, g *followGraph, author string, engagers []string) float64 {
+	var first, second float64
+	if g.follows[author] {
+		first++
+	} else if g.secondHop[author] {
+		second++
+	}
+	for _, u := range engagers {
+		if g.follows[u] {
+			first++
+		} else if g.secondHop[u] {
+			second++
+		}
+	}
+	return 1 + conf.FollowBoost*math.Log2(1+first) + conf.SecondHopBoost*math.Log2(1+second)
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+const maxGraphDepth = 3
+
+// GetNeighborhood exports the part of the engagement graph within depth hops
+// of a post or a user, at most limit paths are expanded.
+func (s *Service) GetNeighborhood(ctx context.Context, postId, pubkey string, depth, limit int) (*types.Graph, error) {
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > maxGraphDepth {
+		depth = maxGraphDepth
+	}
+
+	var center string
+	var params map[string]any
+	switch {
+	case postId != "":
+		center = "(c:Post {id: $Id})"
+		params = map[string]any{"Id": postId, "Limit": limit}
+	case pubkey != "":
+		center = "(c:User {pubkey: $Id})"
+		params = map[string]any{"Id": pubkey, "Limit": limit}
+	default:
+		return nil, fmt.Errorf("either post or pubkey is required")
+	}
+
+	graph, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// variable length bounds cannot be parameterized. Only posts, users
+		// and their interactions are exported, subscribers and deliveries
+		// would reveal channels and their secrets.
+		query := fmt.Sprintf(`
+			MATCH path = %s-[*1..%d]-()
+			WHERE all(n IN nodes(path) WHERE n:Post OR n:User)
+				AND none(r IN relationships(path) WHERE type(r) IN $Hidden)
+			RETURN path
+			LIMIT $Limit;
+		`, center, depth)
+		params["Hidden"] = hiddenGraphEdges
+		result, err := tx.Run(ctx, query, params)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := []neo4j.Path{}
+		for result.Next(ctx) {
+			if path, ok := result.Record().Values[0].(neo4j.Path); ok {
+				paths = append(paths, path)
+			}
+		}
+		return graphOf(paths), result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return graph.(*types.Graph), nil
+}
+
+// relationships left out of exported graphs
+var hiddenGraphEdges = []string{"DELIVERED"}
+
+// properties of nodes included in exported graphs
+var graphProperties = []string{"pubkey", "kind", "id"}
+
+// graphOf merges paths of posts and users into a graph. Paths through other
+// nodes or hidden relationships are skipped, and nodes only keep the
+// properties that are safe to publish.
+func graphOf(paths []neo4j.Path) *types.Graph {
+	graph := &types.Graph{
+		Nodes: []types.GraphNode{},
+		Edges: []types.GraphEdge{},
+	}
+	seenNodes := map[string]bool{}
+	seenEdges := map[string]bool{}
+	for _, path := range paths {
+		if !exportable(path) {
+			continue
+		}
+
+		for _, node := range path.Nodes {
+			if seenNodes[node.ElementId] {
+				continue
+			}
+			seenNodes[node.ElementId] = true
+
+			props := map[string]any{}
+			for _, key := range graphProperties {
+				if value, ok := node.Props[key]; ok {
+					props[key] = value
+				}
+			}
+			graph.Nodes = append(graph.Nodes, types.GraphNode{
+				Id:         node.ElementId,
+				Label:      node.Labels[0],
+				Properties: props,
+			})
+		}
+
+		for _, rel := range path.Relationships {
+			if seenEdges[rel.ElementId] {
+				continue
+			}
+			seenEdges[rel.ElementId] = true
+
+			graph.Edges = append(graph.Edges, types.GraphEdge{
+				Id:     rel.ElementId,
+				Source: rel.StartElementId,
+				Target: rel.EndElementId,
+				Type:   rel.Type,
+			})
+		}
+	}
+	return graph
+}
+
+func exportable(path neo4j.Path) bool {
+	for _, node := range path.Nodes {
+		if len(node.Labels) != 1 || (node.Labels[0] != "Post" && node.Labels[0] != "User") {
+			return false
+		}
+	}
+	for _, rel := range path.Relationships {
+		if slices.Contains(hiddenGraphEdges, rel.Type) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphOf(t *testing.T) {
+	user := neo4j.Node{ElementId: "u", Labels: []string{"User"}, Props: map[string]any{"pubkey": "alice", "name": "Alice"}}
+	post := neo4j.Node{ElementId: "p", Labels: []string{"Post"}, Props: map[string]any{"id": "post1", "kind": int64(1), "author": "alice"}}
+	subscriber := neo4j.Node{ElementId: "s", Labels: []string{"Subscriber"}, Props: map[string]any{"pubkey": "bob", "channel_secret": "sk"}}
+
+	graph := graphOf([]neo4j.Path{
+		{
+			Nodes:         []neo4j.Node{user, post},
+			Relationships: []neo4j.Relationship{{ElementId: "c", StartElementId: "u", EndElementId: "p", Type: "CREATE"}},
+		},
+		{
+			Nodes:         []neo4j.Node{post, subscriber},
+			Relationships: []neo4j.Relationship{{ElementId: "d", StartElementId: "s", EndElementId: "p", Type: "DELIVERED"}},
+		},
+		{
+			Nodes:         []neo4j.Node{user, {ElementId: "u2", Labels: []string{"User"}, Props: map[string]any{"pubkey": "carol"}}},
+			Relationships: []neo4j.Relationship{{ElementId: "d2", StartElementId: "u", EndElementId: "u2", Type: "DELIVERED"}},
+		},
+	})
+
+	assert.Len(t, graph.Nodes, 2)
+	assert.Equal(t, map[string]any{"pubkey": "alice"}, graph.Nodes[0].Properties)
+	assert.Equal(t, map[string]any{"id": "post1", "kind": int64(1)}, graph.Nodes[1].Properties)
+	assert.Len(t, graph.Edges, 1)
+	assert.Equal(t, "CREATE", graph.Edges[0].Type)
+
+	// no subscriber nor secret leaks into the export
+	exported, err := json.Marshal(graph)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(exported), "channel_secret")
+	assert.NotContains(t, string(exported), "Subscriber")
+	assert.NotContains(t, string(exported), "DELIVERED")
+}
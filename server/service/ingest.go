@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// validateIngested checks that an event pushed to the API is well formed,
+// signed by its author and not from the future
+func validateIngested(event *nostr.Event, maxSkew time.Duration, now time.Time) error {
+	if event.ID == "" || event.GetID() != event.ID {
+		return fmt.Errorf("id doesn't match the event")
+	}
+	if ok, err := event.CheckSignature(); !ok {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if event.CreatedAt.After(now.Add(maxSkew)) {
+		return fmt.Errorf("created in the future")
+	}
+	return nil
+}
+
+// IngestEvents stores events pushed by an ingestion source, as if crawled
+// from its relay. Events failing validation or not accepted by the crawler's
+// filter are rejected, the others take the same path as crawled events.
+func (s *Service) IngestEvents(ctx context.Context, source types.IngestSource, events []*nostr.Event, accepts func(event *nostr.Event) bool) types.IngestReport {
+	maxSkew := parseDurationOr(s.config.Ingest.MaxClockSkew, 15*time.Minute)
+	now := time.Now()
+
+	report := types.IngestReport{Rejected: []types.IngestRejected{}}
+	for _, event := range events {
+		err := validateIngested(event, maxSkew, now)
+		if err == nil && !accepts(event) {
+			err = fmt.Errorf("not accepted by the crawler filter")
+		}
+		if err == nil {
+			err = s.StoreEventFromRelay(ctx, event, source.Relay)
+		}
+		if err != nil {
+			report.Rejected = append(report.Rejected, types.IngestRejected{Id: event.ID, Reason: err.Error()})
+			continue
+		}
+		report.Accepted++
+	}
+
+	metrics.NewCounter("ingest/" + source.Name + "/accepted").Inc(int64(report.Accepted))
+	metrics.NewCounter("ingest/" + source.Name + "/rejected").Inc(int64(len(report.Rejected)))
+	correlation.Logger(ctx, logger).Info("Ingested events", "source", source.Name, "accepted", report.Accepted, "rejected", len(report.Rejected))
+	return report
+}
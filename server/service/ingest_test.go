@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateIngested(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	ev := &nostr.Event{PubKey: pub, Kind: 1, CreatedAt: now, Content: "gm", Tags: nostr.Tags{}}
+	assert.NoError(t, ev.Sign(sk))
+	assert.NoError(t, validateIngested(ev, time.Minute, now))
+
+	tampered := *ev
+	tampered.Content = "gn"
+	assert.Error(t, validateIngested(&tampered, time.Minute, now))
+
+	future := &nostr.Event{PubKey: pub, Kind: 1, CreatedAt: now.Add(time.Hour), Content: "gm", Tags: nostr.Tags{}}
+	assert.NoError(t, future.Sign(sk))
+	assert.Error(t, validateIngested(future, time.Minute, now))
+}
+
+func TestIngestEvents(t *testing.T) {
+	s := newSQLiteService(t)
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+
+	tagged := &nostr.Event{PubKey: pub, Kind: 1, CreatedAt: time.Now(), Content: "gm", Tags: nostr.Tags{{"t", "nostr"}}}
+	untagged := &nostr.Event{PubKey: pub, Kind: 1, CreatedAt: time.Now(), Content: "gn", Tags: nostr.Tags{}}
+	assert.NoError(t, tagged.Sign(sk))
+	assert.NoError(t, untagged.Sign(sk))
+
+	// events the crawler would have dropped are rejected
+	accepts := func(event *nostr.Event) bool { return len(event.Tags) > 0 }
+	report := s.IngestEvents(context.Background(), types.IngestSource{Name: "test", Relay: "wss://ingest"}, []*nostr.Event{tagged, untagged}, accepts)
+	assert.Equal(t, 1, report.Accepted)
+	if assert.Len(t, report.Rejected, 1) {
+		assert.Equal(t, untagged.ID, report.Rejected[0].Id)
+	}
+}
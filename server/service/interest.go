@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	InterestSourceCommand  = "command"
+	InterestSourceInferred = "inferred"
+
+	// score multiplier added for each matching interest
+	interestBoost = 0.5
+	// delivered posts of a topic the subscriber must engage with before it's
+	// inferred as an interest
+	interestInferenceMin = 3
+)
+
+// SetInterests adds or removes explicit interests of a subscriber.
+// Removed interests are kept with weight 0 so that they're not inferred again.
+func (s *Service) SetInterests(ctx context.Context, pubkey string, topics []string, interested bool) error {
+	weight := 0
+	if interested {
+		weight = 1
+	}
+
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			UNWIND $Topics AS topic
+			MERGE (t:Topic {name: topic})
+			MERGE (s)-[r:INTERESTED_IN]->(t)
+			SET r.weight = $Weight, r.source = $Source, r.updated_at = $Now;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+				"Topics": normalizeTopics(topics),
+				"Weight": weight,
+				"Source": InterestSourceCommand,
+				"Now":    time.Now().Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// GetInterests returns the topics a subscriber is interested in, explicit or
+// inferred
+func (s *Service) GetInterests(ctx context.Context, pubkey string) ([]types.Interest, error) {
+	interests, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (:Subscriber {pubkey: $Pubkey})-[r:INTERESTED_IN]->(t:Topic)
+			WHERE r.weight > 0
+			RETURN t.name, r.source
+			ORDER BY t.name;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Pubkey": pubkey})
+		if err != nil {
+			return nil, err
+		}
+
+		interests := []types.Interest{}
+		for result.Next(ctx) {
+			record := result.Record()
+			interests = append(interests, types.Interest{
+				Topic:  record.Values[0].(string),
+				Source: record.Values[1].(string),
+			})
+		}
+		return interests, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return interests.([]types.Interest), nil
+}
+
+// InferInterests derives interests from the topics of delivered posts that
+// the subscriber engaged with. Explicit choices are never overwritten.
+func (s *Service) InferInterests(ctx context.Context, pubkey string) error {
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})-[d:DELIVERED]->(p:Post)
+			WHERE EXISTS {
+				MATCH (:User {pubkey: $Pubkey})-[:CREATE]->(:Post)-[e:LIKE|REPOST|ZAP|REPLY_TO]->(p)
+				WHERE coalesce(e.polarity, 1) > 0
+			}
+			UNWIND d.topics AS topic
+			WITH s, topic, count(*) AS c
+			WHERE c >= $Min
+			MERGE (t:Topic {name: topic})
+			MERGE (s)-[r:INTERESTED_IN]->(t)
+			ON CREATE SET r.weight = 1, r.source = $Source, r.updated_at = $Now;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+				"Min":    interestInferenceMin,
+				"Source": InterestSourceInferred,
+				"Now":    time.Now().Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// applyInterests boosts posts tagged with topics the subscriber is
+// interested in
+func (s *Service) applyInterests(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" {
+		return feed
+	}
+
+	interests, err := s.GetInterests(ctx, subscriberPub)
+	if err != nil {
+		logger.Error("Failed to query interests", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+	if len(interests) == 0 {
+		return feed
+	}
+
+	topics := make([]string, 0, len(interests))
+	for _, interest := range interests {
+		topics = append(topics, interest.Topic)
+	}
+
+	for i := range feed {
+		matches := 0
+		for _, topic := range extractTopics(feed[i].Raw) {
+			if slices.Contains(topics, topic) {
+				matches++
+			}
+		}
+		feed[i].Score *= 1 + interestBoost*float64(matches)
+	}
+	return feed
+}
+
+func normalizeTopics(topics []string) []string {
+	normalized := []string{}
+	for _, topic := range topics {
+		topic = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(topic), "#"))
+		if topic != "" && !slices.Contains(normalized, topic) {
+			normalized = append(normalized, topic)
+		}
+	}
+	return normalized
+}
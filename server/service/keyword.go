@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+// keywordFilter is a compiled keyword rule
+type keywordFilter struct {
+	name      string
+	pattern   *regexp.Regexp
+	languages []string
+}
+
+// compileKeywordFilters joins the words and patterns of each rule into a
+// single case-insensitive expression. Invalid patterns are logged and left
+// out, rules left with nothing to match are dropped.
+func compileKeywordFilters(rules []types.KeywordRule) []keywordFilter {
+	filters := []keywordFilter{}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule%d", i)
+		}
+
+		alternatives := []string{}
+		if len(rule.Words) > 0 {
+			words := make([]string, 0, len(rule.Words))
+			for _, word := range rule.Words {
+				words = append(words, regexp.QuoteMeta(word))
+			}
+			alternatives = append(alternatives, `(?:^|[^\pL\pN_])(?:`+strings.Join(words, "|")+`)(?:$|[^\pL\pN_])`)
+		}
+		for _, pattern := range rule.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				logger.Error("Invalid keyword pattern", "rule", name, "pattern", pattern, "err", err)
+				continue
+			}
+			alternatives = append(alternatives, "(?:"+pattern+")")
+		}
+		if len(alternatives) == 0 {
+			continue
+		}
+
+		filters = append(filters, keywordFilter{
+			name:      name,
+			pattern:   regexp.MustCompile("(?i)" + strings.Join(alternatives, "|")),
+			languages: rule.Languages,
+		})
+	}
+	return filters
+}
+
+// postContent returns the content of a post, or of the reposted post
+func postContent(raw string) string {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return ""
+	}
+	if ev.Kind == 6 || ev.Kind == 16 {
+		var reposted nostr.Event
+		if err := json.Unmarshal([]byte(ev.Content), &reposted); err == nil {
+			return reposted.Content
+		}
+	}
+	return ev.Content
+}
+
+// filterKeywords drops posts matching a keyword rule of their language and
+// counts the posts each rule dropped
+func filterKeywords(filters []keywordFilter, feed []types.FeedEntry) []types.FeedEntry {
+	if len(filters) == 0 {
+		return feed
+	}
+
+	dropped := make([]int64, len(filters))
+	filtered := feed[:0]
+	for _, entry := range feed {
+		content := postContent(entry.Raw)
+		language := ""
+		match := slices.IndexFunc(filters, func(f keywordFilter) bool {
+			if len(f.languages) > 0 {
+				if language == "" {
+					language = detectLanguage(entry.Raw)
+				}
+				if !slices.Contains(f.languages, language) {
+					return false
+				}
+			}
+			return f.pattern.MatchString(content)
+		})
+		if match >= 0 {
+			dropped[match]++
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	for i, f := range filters {
+		if dropped[i] > 0 {
+			metrics.NewCounter(fmt.Sprintf("moderation/keywords/%s/filtered", f.name)).Inc(dropped[i])
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func contentEntry(id, content string) types.FeedEntry {
+	raw, _ := json.Marshal(nostr.Event{ID: id, Kind: 1, Content: content})
+	return types.FeedEntry{Id: id, Raw: string(raw)}
+}
+
+func TestFilterKeywords(t *testing.T) {
+	filters := compileKeywordFilters([]types.KeywordRule{
+		{Name: "spam", Words: []string{"airdrop", "free sats"}},
+		{Name: "ja", Patterns: []string{"詐欺"}, Languages: []string{"ja"}},
+		{Name: "invalid", Patterns: []string{"("}},
+	})
+	assert.Len(t, filters, 2)
+
+	repost, _ := json.Marshal(nostr.Event{ID: "repost", Kind: 6, Content: contentEntry("inner", "AIRDROP now").Raw})
+	feed := []types.FeedEntry{
+		contentEntry("word", "Claim your Airdrop!"),
+		contentEntry("phrase", "get free sats here"),
+		contentEntry("substring", "airdropped yesterday"),
+		{Id: "repost", Raw: string(repost)},
+		contentEntry("ja", "これは詐欺です"),
+		contentEntry("zh", "这是詐欺"),
+	}
+
+	ids := []string{}
+	for _, entry := range filterKeywords(filters, feed) {
+		ids = append(ids, entry.Id)
+	}
+	assert.Equal(t, []string{"substring", "zh"}, ids)
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"unicode"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// LanguageUnknown is used for posts whose language cannot be told, e.g. the
+// many languages written in Latin script without a NIP-32 language label
+const LanguageUnknown = "und"
+
+// detectLanguage prefers an ISO-639-1 NIP-32 label and falls back to guessing
+// by the dominant script of the content
+func detectLanguage(raw string) string {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return LanguageUnknown
+	}
+
+	for _, tag := range ev.Tags.GetAll([]string{"l"}) {
+		if len(tag) >= 3 && tag[2] == "ISO-639-1" && tag.Value() != "" {
+			return tag.Value()
+		}
+	}
+
+	return detectScript(ev.Content)
+}
+
+func detectScript(content string) string {
+	counts := map[string]int{}
+	kana := false
+	for _, r := range content {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana = true
+			counts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			counts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			counts["hi"]++
+		case unicode.Is(unicode.Latin, r):
+			counts[LanguageUnknown]++
+		}
+	}
+
+	// Han characters are shared by Japanese
+	if kana {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+
+	best, max := LanguageUnknown, 0
+	for lang, count := range counts {
+		if count > max || (count == max && lang < best) {
+			best, max = lang, count
+		}
+	}
+	return best
+}
+
+// balanceLanguages picks limit entries so that no language takes more than
+// its quota of the digest. Each language is ranked on its own with its
+// configured weight, quotas are relaxed only if there aren't enough posts in
+// other languages to fill the digest.
+func balanceLanguages(config types.LanguageConfig, feed []types.FeedEntry, limit int) []types.FeedEntry {
+	quotas := map[string]types.LanguageQuota{}
+	for _, q := range config.Quotas {
+		quotas[q.Language] = q
+	}
+
+	ranked := make([]types.FeedEntry, len(feed))
+	languages := make(map[string]string, len(feed))
+	for i, entry := range feed {
+		lang := detectLanguage(entry.Raw)
+		languages[entry.Id] = lang
+		if q, ok := quotas[lang]; ok && q.Weight > 0 {
+			entry.Score *= q.Weight
+		}
+		ranked[i] = entry
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	capOf := func(lang string) int {
+		share := config.DefaultQuota
+		if q, ok := quotas[lang]; ok && q.Quota > 0 {
+			share = q.Quota
+		}
+		return int(math.Ceil(share * float64(limit)))
+	}
+
+	picked := make([]types.FeedEntry, 0, limit)
+	var overflow []types.FeedEntry
+	counts := map[string]int{}
+	for _, entry := range ranked {
+		if len(picked) >= limit {
+			break
+		}
+		lang := languages[entry.Id]
+		if counts[lang] >= capOf(lang) {
+			overflow = append(overflow, entry)
+			continue
+		}
+		counts[lang]++
+		picked = append(picked, entry)
+	}
+
+	for _, entry := range overflow {
+		if len(picked) >= limit {
+			break
+		}
+		picked = append(picked, entry)
+	}
+
+	return picked
+}
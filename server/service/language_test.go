@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	raw := func(content string, tags nostr.Tags) string {
+		ev := nostr.Event{Content: content, Tags: tags}
+		b, _ := ev.MarshalJSON()
+		return string(b)
+	}
+
+	assert.Equal(t, "ja", detectLanguage(raw("今日はいい天気ですね", nil)))
+	assert.Equal(t, "zh", detectLanguage(raw("今天天气很好", nil)))
+	assert.Equal(t, "ru", detectLanguage(raw("Привет, мир", nil)))
+	assert.Equal(t, LanguageUnknown, detectLanguage(raw("hello world", nil)))
+	assert.Equal(t, "de", detectLanguage(raw("hallo welt", nostr.Tags{{"L", "ISO-639-1"}, {"l", "de", "ISO-639-1"}})))
+}
+
+func TestBalanceLanguages(t *testing.T) {
+	entry := func(i int, content string) types.FeedEntry {
+		ev := nostr.Event{Content: content}
+		b, _ := ev.MarshalJSON()
+		return types.FeedEntry{Id: fmt.Sprint(i), Score: float64(100 - i), Raw: string(b)}
+	}
+
+	feed := []types.FeedEntry{
+		entry(0, "今日は"), entry(1, "こんにちは"), entry(2, "ありがとう"), entry(3, "さようなら"),
+		entry(4, "hello"), entry(5, "Привет"),
+	}
+
+	config := types.LanguageConfig{
+		DefaultQuota: 0.5,
+		Quotas:       []types.LanguageQuota{{Language: "ru", Quota: 0.25, Weight: 2}},
+	}
+	picked := balanceLanguages(config, feed, 4)
+	ids := []string{}
+	for _, e := range picked {
+		ids = append(ids, e.Id)
+	}
+	// the weighted russian post ranks first, japanese is capped at 2 of 4
+	assert.Equal(t, []string{"5", "0", "1", "4"}, ids)
+
+	// quotas are relaxed when there aren't enough other posts
+	picked = balanceLanguages(config, feed[:4], 4)
+	assert.Len(t, picked, 4)
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// leaderboardWeek returns the last full week before now, from Monday 00:00
+// UTC to the next Monday
+func leaderboardWeek(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	end := time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+	return end.AddDate(0, 0, -7), end
+}
+
+// ComputeLeaderboard aggregates the leaderboards of the last full week before
+// now and stores them, replacing a previous computation of the same week.
+// Zaps exchanged within zap rings and downvotes are not counted.
+func (s *Service) ComputeLeaderboard(ctx context.Context, now time.Time) (*types.Leaderboard, error) {
+	conf := s.config.Bot.Leaderboard
+	start, end := leaderboardWeek(now)
+	newSince := end.Add(-parseDurationOr(conf.NewAuthorAge, 30*24*time.Hour))
+
+	board, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		params := map[string]any{
+			"Start":    start.Unix(),
+			"End":      end.Unix(),
+			"NewSince": newSince.Unix(),
+			"Limit":    conf.Size,
+		}
+
+		board := &types.Leaderboard{
+			Start:       start,
+			End:         end,
+			ZappedPosts: []types.RecapItem{},
+			NewAuthors:  []types.RecapItem{},
+			TopTopics:   []types.RecapItem{},
+		}
+
+		result, err := tx.Run(ctx, `
+			MATCH (p:Post) WHERE $Start <= p.created_at < $End
+			WITH p WHERE p.deleted_at IS NULL
+			MATCH (:Post)-[z:ZAP]->(p) WHERE NOT coalesce(z.ring, false)
+			WITH p, sum(coalesce(z.amount, 0)) AS sats
+			ORDER BY sats DESC LIMIT $Limit
+			RETURN p.id, sats;
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			board.ZappedPosts = append(board.ZappedPosts, types.RecapItem{
+				Key:   record.Values[0].(string),
+				Count: record.Values[1].(int64),
+			})
+		}
+
+		result, err = tx.Run(ctx, `
+			MATCH (p:Post) WHERE $Start <= p.created_at < $End
+			WITH p WHERE p.deleted_at IS NULL
+			MATCH (:Post)-[l:LIKE|REPOST|ZAP]->(p) WHERE coalesce(l.polarity, 1) > 0 AND NOT coalesce(l.ring, false)
+			WITH p.author AS author, count(l) AS appreciation
+			MATCH (u:User {pubkey: author})-[:CREATE]->(first:Post)
+			WITH u, appreciation, min(first.created_at) AS firstSeen
+			WHERE firstSeen >= $NewSince
+			RETURN u.pubkey, appreciation,
+				CASE WHEN coalesce(u.display_name, '') <> '' THEN u.display_name ELSE coalesce(u.name, '') END
+			ORDER BY appreciation DESC LIMIT $Limit;
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			board.NewAuthors = append(board.NewAuthors, types.RecapItem{
+				Key:   record.Values[0].(string),
+				Count: record.Values[1].(int64),
+				Label: record.Values[2].(string),
+			})
+		}
+
+		result, err = tx.Run(ctx, `
+			MATCH (p:Post)-[:TAGGED]->(t:Topic)
+			WHERE p.created_at >= $Start AND p.created_at < $End AND p.deleted_at IS NULL
+			RETURN t.name, count(p) AS c
+			ORDER BY c DESC LIMIT $Limit;
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			board.TopTopics = append(board.TopTopics, types.RecapItem{
+				Key:   record.Values[0].(string),
+				Count: record.Values[1].(int64),
+			})
+		}
+
+		data, err := json.Marshal(board)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Run(ctx, `
+			MERGE (l:Leaderboard {end: $End})
+			SET l.start = $Start, l.data = $Data, l.computed_at = $Now;
+		`, map[string]any{
+			"Start": start.Unix(),
+			"End":   end.Unix(),
+			"Data":  string(data),
+			"Now":   now.Unix(),
+		})
+		return board, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Computed leaderboard", "start", start, "end", end)
+	return board.(*types.Leaderboard), nil
+}
+
+// GetLeaderboard returns the latest leaderboard of a week ended by at, or nil
+// if none has been computed
+func (s *Service) GetLeaderboard(ctx context.Context, at time.Time) (*types.Leaderboard, error) {
+	data, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (l:Leaderboard) WHERE l.end <= $At
+			RETURN l.data
+			ORDER BY l.end DESC LIMIT 1;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"At": at.Unix()})
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next(ctx) {
+			return "", result.Err()
+		}
+		return result.Record().Values[0].(string), nil
+	})
+	if err != nil || data.(string) == "" {
+		return nil, err
+	}
+
+	board := &types.Leaderboard{}
+	if err := json.Unmarshal([]byte(data.(string)), board); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderboardWeek(t *testing.T) {
+	monday := time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC)
+
+	start, end := leaderboardWeek(monday)
+	assert.Equal(t, monday.AddDate(0, 0, -7), start)
+	assert.Equal(t, monday, end)
+
+	start, end = leaderboardWeek(monday.Add(6*24*time.Hour + 23*time.Hour))
+	assert.Equal(t, monday.AddDate(0, 0, -7), start)
+	assert.Equal(t, monday, end)
+
+	_, end = leaderboardWeek(monday.Add(7 * 24 * time.Hour))
+	assert.Equal(t, monday.AddDate(0, 0, 7), end)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+// AcquireLease takes the lease for ttl, or renews it if owner already holds
+// it, moving its cursor unless zero. The lease is held by owner if the
+// returned one names it.
+func (s *Service) AcquireLease(ctx context.Context, name, owner string, cursor time.Time, ttl time.Duration, now time.Time) (*types.Lease, error) {
+	return s.repo.AcquireLease(ctx, name, owner, cursor, now.Add(ttl), now)
+}
+
+// GetCheckpoint returns the checkpoint of a job, nil if it never saved one
+func (s *Service) GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error) {
+	return s.repo.GetCheckpoint(ctx, name)
+}
+
+// SaveCheckpoint records how far a job went
+func (s *Service) SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error {
+	return s.repo.SaveCheckpoint(ctx, checkpoint)
+}
+
+// MarkHandled records that the event was handled, and tells whether it's the
+// first time, so that events received again, e.g. from another relay, are
+// handled once
+func (s *Service) MarkHandled(ctx context.Context, id string) (bool, error) {
+	return s.repo.MarkHandled(ctx, id, time.Now())
+}
+
+// ReleaseLease frees the lease held by owner, so that another instance takes
+// it over from cursor without waiting for it to expire
+func (s *Service) ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error {
+	logger.Info("Releasing lease", "name", name, "owner", owner, "cursor", cursor)
+	return s.repo.ReleaseLease(ctx, name, owner, cursor)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"golang.org/x/exp/slices"
+)
+
+// reuse policies of an output
+const (
+	// keep every post
+	PolicyAny = "any"
+	// drop posts whose license disallows redistribution off nostr
+	PolicyUnrestricted = "unrestricted"
+	// keep only posts carrying a license that allows redistribution
+	PolicyLicensed = "licensed"
+)
+
+// licenses reads the licenses a post is published under, from license tags
+// or NIP-32 labels in the "license" namespace. Reposts carry the licenses of
+// the reposted post.
+func licenses(raw string) []string {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return nil
+	}
+	if ev.Kind == 6 || ev.Kind == 16 {
+		var reposted nostr.Event
+		if err := json.Unmarshal([]byte(ev.Content), &reposted); err == nil {
+			ev = reposted
+		}
+	}
+
+	found := []string{}
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[1] == "" {
+			continue
+		}
+		switch {
+		case tag[0] == "license":
+			found = append(found, strings.ToLower(tag[1]))
+		case tag[0] == "l" && len(tag) > 2 && tag[2] == "license":
+			found = append(found, strings.ToLower(tag[1]))
+		}
+	}
+	return found
+}
+
+// outputPolicy returns the reuse policy configured for the output
+func outputPolicy(conf types.LicensingConfig, output string) string {
+	for _, o := range conf.Outputs {
+		if o.Output == output {
+			return o.Policy
+		}
+	}
+	return PolicyAny
+}
+
+// FilterReuse drops the posts the output may not redistribute under its
+// policy
+func (s *Service) FilterReuse(output string, feed []types.FeedEntry) []types.FeedEntry {
+	return filterReuse(s.config.Licensing, output, feed)
+}
+
+func filterReuse(conf types.LicensingConfig, output string, feed []types.FeedEntry) []types.FeedEntry {
+	policy := outputPolicy(conf, output)
+	if policy == PolicyAny {
+		return feed
+	}
+
+	filtered := make([]types.FeedEntry, 0, len(feed))
+	for _, entry := range feed {
+		found := licenses(entry.Raw)
+		restricted := slices.ContainsFunc(found, func(l string) bool {
+			return slices.ContainsFunc(conf.Restricted, func(r string) bool { return strings.EqualFold(r, l) })
+		})
+		if restricted || (policy == PolicyLicensed && len(found) == 0) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
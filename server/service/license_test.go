@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func licensedEntry(id string, tags nostr.Tags) types.FeedEntry {
+	raw, _ := json.Marshal(nostr.Event{ID: id, Kind: 1, Tags: tags})
+	return types.FeedEntry{Id: id, Raw: string(raw)}
+}
+
+func TestLicenses(t *testing.T) {
+	entry := licensedEntry("a", nostr.Tags{{"license", "CC-BY-4.0"}, {"L", "license"}, {"l", "Nostr-Only", "license"}, {"l", "en", "ISO-639-1"}})
+	assert.Equal(t, []string{"cc-by-4.0", "nostr-only"}, licenses(entry.Raw))
+
+	repost, _ := json.Marshal(nostr.Event{Kind: 6, Content: entry.Raw})
+	assert.Equal(t, []string{"cc-by-4.0", "nostr-only"}, licenses(string(repost)))
+}
+
+func TestFilterReuse(t *testing.T) {
+	conf := types.LicensingConfig{
+		Restricted: []string{"nostr-only"},
+		Outputs: []types.OutputPolicy{
+			{Output: "rss", Policy: PolicyUnrestricted},
+			{Output: "email", Policy: PolicyLicensed},
+		},
+	}
+	feed := func() []types.FeedEntry {
+		return []types.FeedEntry{
+			licensedEntry("free", nostr.Tags{{"license", "CC0-1.0"}}),
+			licensedEntry("restricted", nostr.Tags{{"license", "NOSTR-ONLY"}}),
+			licensedEntry("none", nil),
+		}
+	}
+	ids := func(feed []types.FeedEntry) []string {
+		ids := []string{}
+		for _, e := range feed {
+			ids = append(ids, e.Id)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{"free", "restricted", "none"}, ids(filterReuse(conf, types.OutputDigest, feed())))
+	assert.Equal(t, []string{"free", "none"}, ids(filterReuse(conf, "rss", feed())))
+	assert.Equal(t, []string{"free"}, ids(filterReuse(conf, "email", feed())))
+
+	// the feed passed in is left as is
+	unfiltered := feed()
+	filterReuse(conf, "email", unfiltered)
+	assert.Equal(t, []string{"free", "restricted", "none"}, ids(unfiltered))
+}
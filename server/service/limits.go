@@ -0,0 +1,48 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ErrEventLimit is returned for events rejected by the configured limits
+var ErrEventLimit = errors.New("event exceeds limits")
+
+// limitEvent rejects events over the limits. Events aren't truncated, as a
+// modified event would be stored under the id and signature of the original.
+// Profiles, contact lists and relay lists are exempt, as they're routinely
+// large and the graph depends on them being complete.
+func limitEvent(conf types.EventLimitsConfig, event *nostr.Event) error {
+	if !conf.Enabled || isLimitExempt(event.Kind) {
+		return nil
+	}
+
+	if conf.MaxContent > 0 && len(event.Content) > conf.MaxContent {
+		metrics.NewCounter("limits/rejected/content").Inc(1)
+		return fmt.Errorf("%w: content of %d bytes", ErrEventLimit, len(event.Content))
+	}
+	if conf.MaxTags > 0 && len(event.Tags) > conf.MaxTags {
+		metrics.NewCounter("limits/rejected/tags").Inc(1)
+		return fmt.Errorf("%w: %d tags", ErrEventLimit, len(event.Tags))
+	}
+	if conf.MaxSize > 0 {
+		raw, err := event.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if len(raw) > conf.MaxSize {
+			metrics.NewCounter("limits/rejected/size").Inc(1)
+			return fmt.Errorf("%w: %d bytes", ErrEventLimit, len(raw))
+		}
+	}
+	return nil
+}
+
+// isLimitExempt tells if events of the kind are stored whatever their size
+func isLimitExempt(kind int) bool {
+	return kind == 0 || kind == 3 || kind == relayListKind
+}
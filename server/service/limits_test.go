@@ -0,0 +1,37 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitEvent(t *testing.T) {
+	conf := types.EventLimitsConfig{Enabled: true, MaxContent: 10, MaxTags: 2, MaxSize: 1000}
+	tags := nostr.Tags{{"p", "a"}, {"p", "b"}, {"p", "c"}}
+
+	// within limits the event is stored as is
+	assert.NoError(t, limitEvent(conf, &nostr.Event{Kind: 1, Content: "short", Tags: tags[:2]}))
+
+	// over limits the event is rejected, never truncated
+	ev := &nostr.Event{Kind: 1, Content: "123456789é", Tags: tags[:2]}
+	assert.True(t, errors.Is(limitEvent(conf, ev), ErrEventLimit))
+	assert.Equal(t, "123456789é", ev.Content)
+	assert.True(t, errors.Is(limitEvent(conf, &nostr.Event{Kind: 1, Tags: tags}), ErrEventLimit))
+
+	// profiles, contact lists and relay lists are kept whole
+	assert.NoError(t, limitEvent(conf, &nostr.Event{Kind: 3, Tags: tags}))
+	assert.NoError(t, limitEvent(conf, &nostr.Event{Kind: 0, Content: strings.Repeat("x", 2000)}))
+	assert.NoError(t, limitEvent(conf, &nostr.Event{Kind: relayListKind, Tags: tags}))
+
+	// oversized events are rejected
+	conf.MaxContent = 0
+	assert.True(t, errors.Is(limitEvent(conf, &nostr.Event{Kind: 1, Content: strings.Repeat("x", 1000)}), ErrEventLimit))
+
+	conf.Enabled = false
+	assert.NoError(t, limitEvent(conf, &nostr.Event{Kind: 1, Content: strings.Repeat("x", 1000)}))
+}
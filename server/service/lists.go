@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// list kinds as defined by NIP-51. Mute and pin lists used to be published
+// as parameterized lists with a "mute" or "pin" d tag, which are still read.
+const (
+	muteListKind       = 10000
+	pinListKind        = 10001
+	followSetKind      = 30000
+	legacyListKind     = 30001
+	muteListIdentifier = "mute"
+	pinListIdentifier  = "pin"
+)
+
+type userList struct {
+	// muteListKind or pinListKind, 0 if it's another list
+	Kind    int
+	Pubkeys []string
+	Topics  []string
+	Ids     []string
+}
+
+// parseList reads the public entries of a mute or pin list, encrypted
+// private entries are left out
+func parseList(event *nostr.Event) userList {
+	list := userList{Pubkeys: []string{}, Topics: []string{}, Ids: []string{}}
+	switch event.Kind {
+	case muteListKind, pinListKind:
+		list.Kind = event.Kind
+	case followSetKind, legacyListKind:
+		switch tagValue(event, "d") {
+		case muteListIdentifier:
+			list.Kind = muteListKind
+		case pinListIdentifier:
+			list.Kind = pinListKind
+		}
+	}
+
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[1] == "" {
+			continue
+		}
+		switch tag[0] {
+		case "p":
+			list.Pubkeys = append(list.Pubkeys, tag[1])
+		case "t":
+			list.Topics = append(list.Topics, strings.ToLower(tag[1]))
+		case "e":
+			list.Ids = append(list.Ids, tag[1])
+		}
+	}
+	return list
+}
+
+// StoreList stores a mute or pin list, replacing the previous one unless a
+// newer list was already stored. Mutes are kept as MUTES relations to the
+// muted users, and the muted hashtags and events on the user, pins as PINS
+// relations to the pinned posts already stored. Other lists are ignored.
+func (s *Service) StoreList(event *nostr.Event) error {
+	list := parseList(event)
+
+	var query string
+	switch list.Kind {
+	case muteListKind:
+		query = `
+			MERGE (u:User {pubkey: $Pubkey})
+			WITH u WHERE coalesce(u.mutes_updated_at, 0) < $UpdatedAt
+			SET u.mutes_updated_at = $UpdatedAt, u.muted_topics = $Topics, u.muted_events = $Ids
+			WITH u
+			OPTIONAL MATCH (u)-[old:MUTES]->(:User)
+			DELETE old
+			WITH DISTINCT u
+			FOREACH (pubkey IN $Pubkeys | MERGE (m:User {pubkey: pubkey}) MERGE (u)-[:MUTES]->(m));
+		`
+	case pinListKind:
+		query = `
+			MERGE (u:User {pubkey: $Pubkey})
+			WITH u WHERE coalesce(u.pins_updated_at, 0) < $UpdatedAt
+			SET u.pins_updated_at = $UpdatedAt
+			WITH u
+			OPTIONAL MATCH (u)-[old:PINS]->(:Post)
+			DELETE old
+			WITH DISTINCT u
+			MATCH (p:Post) WHERE p.id IN $Ids
+			MERGE (u)-[:PINS]->(p);
+		`
+	default:
+		return nil
+	}
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":    event.PubKey,
+				"Pubkeys":   list.Pubkeys,
+				"Topics":    list.Topics,
+				"Ids":       list.Ids,
+				"UpdatedAt": event.CreatedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+type mutes struct {
+	authors map[string]bool
+	topics  []string
+	// muted events, hiding the event and the thread below it
+	events map[string]bool
+}
+
+func (s *Service) getMutes(ctx context.Context, pubkey string) (*mutes, error) {
+	result, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User {pubkey: $Pubkey})
+			OPTIONAL MATCH (u)-[:MUTES]->(m:User)
+			RETURN coalesce(u.muted_topics, []), collect(m.pubkey), coalesce(u.muted_events, []);
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Pubkey": pubkey})
+		if err != nil {
+			return nil, err
+		}
+
+		m := &mutes{authors: map[string]bool{}, topics: []string{}, events: map[string]bool{}}
+		if result.Next(ctx) {
+			record := result.Record()
+			for _, topic := range record.Values[0].([]any) {
+				m.topics = append(m.topics, topic.(string))
+			}
+			for _, author := range record.Values[1].([]any) {
+				m.authors[author.(string)] = true
+			}
+			for _, id := range record.Values[2].([]any) {
+				m.events[id.(string)] = true
+			}
+		}
+		return m, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*mutes), nil
+}
+
+// applyMutes drops posts of authors, hashtags and threads the subscriber
+// muted in their NIP-51 mute list
+func (s *Service) applyMutes(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" || len(feed) == 0 {
+		return feed
+	}
+
+	m, err := s.getMutes(ctx, subscriberPub)
+	if err != nil {
+		logger.Error("Failed to query mutes", "pubkey", subscriberPub, "err", err)
+		return feed
+	}
+	if len(m.authors) == 0 && len(m.topics) == 0 && len(m.events) == 0 {
+		return feed
+	}
+	return filterMuted(m, feed)
+}
+
+func filterMuted(m *mutes, feed []types.FeedEntry) []types.FeedEntry {
+	filtered := make([]types.FeedEntry, 0, len(feed))
+	for _, entry := range feed {
+		if m.authors[entry.Pubkey] {
+			continue
+		}
+		if slices.ContainsFunc(extractTopics(entry.Raw), func(topic string) bool { return slices.Contains(m.topics, topic) }) {
+			continue
+		}
+		if m.events[entry.Id] || (len(m.events) > 0 && slices.ContainsFunc(extractReferences(entry.Raw), func(id string) bool { return m.events[id] })) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// extractReferences returns the events referenced by e tags of a raw event,
+// e.g. the root and parent of a reply
+func extractReferences(raw string) []string {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return []string{}
+	}
+
+	ids := []string{}
+	for _, tag := range ev.Tags.GetAll([]string{"e"}) {
+		if id := tag.Value(); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
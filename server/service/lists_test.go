@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseList(t *testing.T) {
+	mute := parseList(&nostr.Event{
+		Kind:    muteListKind,
+		Tags:    nostr.Tags{{"p", "spammer"}, {"t", "NSFW"}, {"word", "airdrop"}, {"e", "thread"}},
+		Content: "encrypted private entries",
+	})
+	assert.Equal(t, muteListKind, mute.Kind)
+	assert.Equal(t, []string{"spammer"}, mute.Pubkeys)
+	assert.Equal(t, []string{"nsfw"}, mute.Topics)
+	assert.Equal(t, []string{"thread"}, mute.Ids)
+
+	legacy := parseList(&nostr.Event{Kind: legacyListKind, Tags: nostr.Tags{{"d", "pin"}, {"e", "note"}}})
+	assert.Equal(t, pinListKind, legacy.Kind)
+	assert.Equal(t, []string{"note"}, legacy.Ids)
+
+	other := parseList(&nostr.Event{Kind: followSetKind, Tags: nostr.Tags{{"d", "friends"}, {"p", "friend"}}})
+	assert.Equal(t, 0, other.Kind)
+}
+
+func TestFilterMuted(t *testing.T) {
+	tagged, _ := json.Marshal(nostr.Event{Tags: nostr.Tags{{"t", "NSFW"}}})
+	reply, _ := json.Marshal(nostr.Event{Tags: nostr.Tags{{"e", "thread", "", "root"}}})
+	feed := []types.FeedEntry{
+		{Id: "a", Pubkey: "spammer", Raw: "{}"},
+		{Id: "b", Pubkey: "author", Raw: string(tagged)},
+		{Id: "c", Pubkey: "author", Raw: "{}"},
+		{Id: "thread", Pubkey: "author", Raw: "{}"},
+		{Id: "d", Pubkey: "author", Raw: string(reply)},
+	}
+
+	filtered := filterMuted(&mutes{
+		authors: map[string]bool{"spammer": true},
+		topics:  []string{"nsfw"},
+		events:  map[string]bool{"thread": true},
+	}, feed)
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "c", filtered[0].Id)
+}
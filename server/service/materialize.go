@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// states of materialized scores
+const (
+	scoresStale int32 = iota
+	scoresReady
+	scoresRescoring
+)
+
+const (
+	// engagement is rescanned a little before the last run, as events stored
+	// meanwhile may be committed late
+	materializeOverlap   = 10 * time.Minute
+	materializeBatchSize = 1000
+)
+
+// materializedScoreQuery ranks posts by their materialized score, decayed
+// by recency. It returns the same columns as scoreQuery.
+const materializedScoreQuery = `
+	MATCH (p:Post) WHERE $Start < p.created_at < $End
+	WITH p WHERE p.score IS NOT NULL AND p.deleted_at IS NULL
+		AND ($Topic = '' OR EXISTS { MATCH (p)-[:TAGGED]->(:Topic {name: $Topic}) })
+	WITH p, p.score * exp(-$RecencyDecay * ($End - p.created_at) / 3600.0) AS score
+	ORDER BY score DESC
+	WITH p.author AS author, collect([p, score]) AS ranked
+	UNWIND CASE WHEN $MaxPerAuthor > 0 THEN ranked[..$MaxPerAuthor] ELSE ranked END AS top
+	WITH top[0] AS p, top[1] AS score
+	ORDER BY score DESC LIMIT $Limit
+	RETURN p.id, p.kind, p.author, p.created_at, score;
+`
+
+// rescoreQuery stores the score a post would get in a feed that isn't
+// personalized, before recency decay. Posts excluded by reports are left
+// without score.
+const rescoreQuery = `
+	UNWIND $Ids AS id
+	MATCH (p:Post {id: id})
+	MATCH (u:User)-[:CREATE]->(r:Post)-[l:REPLY_TO|LIKE|REPOST|ZAP]->(p)
+` + relationWeight + `
+	WITH p, sum(weight * $DefaultWeight) AS score
+` + pinWeight + reportedPost + `
+	SET p.score = CASE
+			WHEN reported AND $ReportPenalty <= 0 THEN null
+			WHEN reported THEN score * $ReportPenalty
+			ELSE score
+		END,
+		p.scored_at = $Now;
+`
+
+// usesMaterializedScores tells if a feed can be ranked by materialized
+// scores: it isn't personalized and its window is kept scored
+func (s *Service) usesMaterializedScores(q types.FeedQuery) bool {
+	conf := s.config.Scoring
+	if !conf.Materialized || q.Subscriber != "" || atomic.LoadInt32(&s.scoreState) != scoresReady {
+		return false
+	}
+	window := parseDurationOr(conf.MaterializeWindow, 48*time.Hour)
+	return !q.Start.Before(time.Now().Add(-window))
+}
+
+// engagedSinceQuery returns posts of the window with engagement stored since
+// the given time. Engagement is selected by the time it was stored, not by
+// created_at, so that events reaching the crawler late, e.g. backfilled, are
+// accounted for.
+const engagedSinceQuery = `
+	MATCH (r:Post) WHERE r.stored_at >= $Since
+	MATCH (r)-[:REPLY_TO|LIKE|REPOST|ZAP|REPORTED]->(p:Post)
+	WHERE p.created_at >= $Oldest
+	RETURN DISTINCT p.id;
+`
+
+// engagedQuery returns all posts of the window with any engagement
+const engagedQuery = `
+	MATCH (p:Post) WHERE p.created_at >= $Oldest
+		AND EXISTS { MATCH (p)<-[:REPLY_TO|LIKE|REPOST|ZAP|REPORTED]-(:Post) }
+	RETURN p.id;
+`
+
+// MaterializeScores rescores posts of the window that had engagement stored
+// since the given time, and returns the number of posts rescored. The whole
+// window is rescored if since is before it. Only scores of feeds that aren't
+// personalized are materialized, subscriber digests are always ranked by the
+// live query.
+func (s *Service) MaterializeScores(since time.Time, now time.Time) (int, error) {
+	window := parseDurationOr(s.config.Scoring.MaterializeWindow, 48*time.Hour)
+	oldest := now.Add(-window)
+	query := engagedSinceQuery
+	if !since.After(oldest) {
+		query = engagedQuery
+	}
+
+	ids, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, query, map[string]any{
+			"Since":  since.Unix(),
+			"Oldest": oldest.Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		ids := []string{}
+		for result.Next(ctx) {
+			ids = append(ids, result.Record().Values[0].(string))
+		}
+		return ids, result.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pending := ids.([]string)
+	params := s.scoreParams(types.FeedQuery{})
+	params["Now"] = now.Unix()
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > materializeBatchSize {
+			n = materializeBatchSize
+		}
+		params["Ids"] = pending[:n]
+		_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			_, err := tx.Run(ctx, rescoreQuery, params)
+			return nil, err
+		})
+		if err != nil {
+			return 0, err
+		}
+		pending = pending[n:]
+	}
+	return len(ids.([]string)), nil
+}
+
+// startScoreMaterializer periodically rescores posts with new engagement.
+// The whole window is scored first, and again whenever weights change,
+// feeds are ranked by the live query meanwhile.
+func (s *Service) startScoreMaterializer(ctx context.Context) {
+	conf := s.config.Scoring
+	interval := parseDurationOr(conf.MaterializeInterval, time.Minute)
+	window := parseDurationOr(conf.MaterializeWindow, 48*time.Hour)
+	go func() {
+		var since time.Time
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			// weights changing during a full run leave it stale again
+			full := atomic.CompareAndSwapInt32(&s.scoreState, scoresStale, scoresRescoring)
+			if full {
+				since = now.Add(-window)
+			}
+			n, err := s.MaterializeScores(since, now)
+			if err != nil {
+				logger.Error("Failed to materialize scores", "err", err)
+				atomic.CompareAndSwapInt32(&s.scoreState, scoresRescoring, scoresStale)
+			} else {
+				logger.Debug("Materialized scores", "posts", n, "full", full)
+				since = now.Add(-materializeOverlap)
+				atomic.CompareAndSwapInt32(&s.scoreState, scoresRescoring, scoresReady)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsesMaterializedScores(t *testing.T) {
+	s := &Service{config: &types.Config{
+		Scoring: types.ScoringConfig{Materialized: true, MaterializeWindow: "48h"},
+	}}
+	now := time.Now()
+	recent := types.FeedQuery{Start: now.Add(-24 * time.Hour), End: now}
+
+	// not until the window is scored
+	assert.False(t, s.usesMaterializedScores(recent))
+
+	s.scoreState = scoresReady
+	assert.True(t, s.usesMaterializedScores(recent))
+	assert.True(t, s.usesMaterializedScores(types.FeedQuery{Start: recent.Start, End: now, Topic: "nostr"}))
+	assert.False(t, s.usesMaterializedScores(types.FeedQuery{Start: recent.Start, End: now, Subscriber: "pubkey"}))
+	assert.False(t, s.usesMaterializedScores(types.FeedQuery{Start: now.Add(-72 * time.Hour), End: now}))
+
+	// tuned weights invalidate scores
+	s.setWeights(types.ScoringWeights{Default: 2})
+	assert.False(t, s.usesMaterializedScores(recent))
+
+	s.scoreState = scoresReady
+	s.config.Scoring.Materialized = false
+	assert.False(t, s.usesMaterializedScores(recent))
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// StoreProfile stores the metadata of a kind 0 event on the user, unless a
+// newer one was already stored
+func (s *Service) StoreProfile(event *nostr.Event) error {
+	profile, err := parseProfile(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (u:User {pubkey: $Pubkey})
+			WITH u WHERE coalesce(u.profile_updated_at, 0) < $UpdatedAt
+			SET
+				u.name = $Name,
+				u.display_name = $DisplayName,
+				u.picture = $Picture,
+				u.nip05 = $Nip05,
+				u.lud16 = $Lud16,
+				u.profile_updated_at = $UpdatedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":      profile.Pubkey,
+				"Name":        profile.Name,
+				"DisplayName": profile.DisplayName,
+				"Picture":     profile.Picture,
+				"Nip05":       profile.Nip05,
+				"Lud16":       profile.Lud16,
+				"UpdatedAt":   profile.UpdatedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func parseProfile(event *nostr.Event) (*types.Profile, error) {
+	var content struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+		// used by some clients before display_name was standardized
+		DisplayNameAlt string `json:"displayName"`
+		Picture        string `json:"picture"`
+		Nip05          string `json:"nip05"`
+		Lud16          string `json:"lud16"`
+	}
+	if err := json.Unmarshal([]byte(event.Content), &content); err != nil {
+		return nil, fmt.Errorf("malformed metadata %s: %w", event.ID, err)
+	}
+
+	displayName := content.DisplayName
+	if displayName == "" {
+		displayName = content.DisplayNameAlt
+	}
+	return &types.Profile{
+		Pubkey:      event.PubKey,
+		Name:        content.Name,
+		DisplayName: displayName,
+		Picture:     content.Picture,
+		Nip05:       content.Nip05,
+		Lud16:       content.Lud16,
+		UpdatedAt:   event.CreatedAt,
+	}, nil
+}
+
+// GetProfile returns the latest metadata stored for the user, nil if none
+// was seen
+func (s *Service) GetProfile(ctx context.Context, pubkey string) (*types.Profile, error) {
+	profile, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User {pubkey: $Pubkey})
+			WHERE u.profile_updated_at IS NOT NULL
+			RETURN u.name, u.display_name, u.picture, u.nip05, u.lud16, u.profile_updated_at;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.Next(ctx) {
+			return (*types.Profile)(nil), result.Err()
+		}
+		values := result.Record().Values
+		return &types.Profile{
+			Pubkey:      pubkey,
+			Name:        values[0].(string),
+			DisplayName: values[1].(string),
+			Picture:     values[2].(string),
+			Nip05:       values[3].(string),
+			Lud16:       values[4].(string),
+			UpdatedAt:   time.Unix(values[5].(int64), 0),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return profile.(*types.Profile), nil
+}
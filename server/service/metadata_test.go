@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProfile(t *testing.T) {
+	at := time.Unix(1690000000, 0)
+	profile, err := parseProfile(&nostr.Event{
+		Kind:      0,
+		PubKey:    "alice",
+		CreatedAt: at,
+		Content:   `{"name":"alice","displayName":"Alice","picture":"https://example.com/a.png","nip05":"alice@example.com","lud16":"alice@getalby.com","about":"hi"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", profile.Pubkey)
+	assert.Equal(t, "Alice", profile.DisplayName)
+	assert.Equal(t, "alice@example.com", profile.Nip05)
+	assert.Equal(t, "alice@getalby.com", profile.Lud16)
+	assert.Equal(t, at, profile.UpdatedAt)
+
+	profile, err = parseProfile(&nostr.Event{Content: `{"display_name":"Bob","displayName":"bob"}`})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", profile.DisplayName)
+
+	_, err = parseProfile(&nostr.Event{Content: "not json"})
+	assert.Error(t, err)
+}
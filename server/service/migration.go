@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	bundleVersion  = 1
+	exportPageSize = 100
+)
+
+// ErrNoExportKey is returned when exporting or importing subscribers without
+// the export key configured
+var ErrNoExportKey = errors.New("no export key configured")
+
+// ExportSubscribers exports subscribers with their preferences, channel
+// secrets encrypted with Admin.ExportKey. If shards is greater than 1, only
+// subscribers assigned to shard are exported, so that the subscriber base can
+// be split across deployments.
+func (s *Service) ExportSubscribers(ctx context.Context, shard, shards int) (*types.SubscriberBundle, error) {
+	passphrase := s.config.Admin.ExportKey
+	if passphrase == "" {
+		return nil, ErrNoExportKey
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &types.SubscriberBundle{
+		Version:     bundleVersion,
+		ExportedAt:  time.Now(),
+		Salt:        base64.StdEncoding.EncodeToString(salt),
+		Subscribers: []types.SubscriberExport{},
+	}
+
+	for skip := 0; ; skip += exportPageSize {
+		subscribers, err := s.ListSubscribers(ctx, exportPageSize, skip)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subscriber := range subscribers {
+			if shards > 1 && types.ShardOf(subscriber.ShardKey, shards) != shard {
+				continue
+			}
+
+			exported, err := s.exportSubscriber(ctx, aead, subscriber)
+			if err != nil {
+				return nil, fmt.Errorf("failed to export subscriber %s: %w", subscriber.Pubkey, err)
+			}
+			bundle.Subscribers = append(bundle.Subscribers, *exported)
+		}
+
+		if len(subscribers) < exportPageSize {
+			break
+		}
+	}
+
+	logger.Info("Exported subscribers", "count", len(bundle.Subscribers), "shard", shard, "shards", shards)
+	return bundle, nil
+}
+
+func (s *Service) exportSubscriber(ctx context.Context, aead cipher.AEAD, subscriber types.Subscriber) (*types.SubscriberExport, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(subscriber.ChannelSecret), []byte(subscriber.Pubkey))
+
+	optOut, err := s.IsNotificationOptedOut(ctx, subscriber.Pubkey)
+	if err != nil {
+		return nil, err
+	}
+	interests, err := s.GetInterests(ctx, subscriber.Pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SubscriberExport{
+		Pubkey:                 subscriber.Pubkey,
+		EncryptedChannelSecret: base64.StdEncoding.EncodeToString(sealed),
+		SubscribedAt:           subscriber.SubscribedAt,
+		UnsubscribedAt:         subscriber.UnsubscribedAt,
+		Tier:                   subscriber.Tier,
+		TierExpiresAt:          subscriber.TierExpiresAt,
+		LastPushedAt:           subscriber.LastPushedAt,
+		NotifyOptOut:           optOut,
+		Interests:              interests,
+	}, nil
+}
+
+// ImportSubscribers restores a bundle exported with the same Admin.ExportKey.
+// Existing subscribers are left untouched unless overwrite is set.
+func (s *Service) ImportSubscribers(ctx context.Context, bundle *types.SubscriberBundle, overwrite bool) (*types.ImportReport, error) {
+	passphrase := s.config.Admin.ExportKey
+	if passphrase == "" {
+		return nil, ErrNoExportKey
+	}
+	if bundle.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(bundle.Salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &types.ImportReport{
+		Imported: []string{},
+		Skipped:  []string{},
+		Failed:   []string{},
+	}
+	for _, exported := range bundle.Subscribers {
+		if !overwrite && s.GetSubscriber(ctx, exported.Pubkey) != nil {
+			report.Skipped = append(report.Skipped, exported.Pubkey)
+			continue
+		}
+
+		if err := s.importSubscriber(aead, exported); err != nil {
+			logger.Warn("Failed to import subscriber", "pubkey", exported.Pubkey, "err", err)
+			report.Failed = append(report.Failed, exported.Pubkey)
+			continue
+		}
+		report.Imported = append(report.Imported, exported.Pubkey)
+	}
+
+	logger.Info("Imported subscribers", "imported", len(report.Imported), "skipped", len(report.Skipped), "failed", len(report.Failed))
+	return report, nil
+}
+
+func (s *Service) importSubscriber(aead cipher.AEAD, exported types.SubscriberExport) error {
+	defer s.subscribers.invalidate(exported.Pubkey)
+	sealed, err := base64.StdEncoding.DecodeString(exported.EncryptedChannelSecret)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("encrypted channel secret is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	channelSecret, err := aead.Open(nil, nonce, ciphertext, []byte(exported.Pubkey))
+	if err != nil {
+		return fmt.Errorf("cannot decrypt channel secret, wrong passphrase?")
+	}
+
+	unix := func(t *time.Time) any {
+		if t == nil {
+			return nil
+		}
+		return t.Unix()
+	}
+
+	_, err = s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (s:Subscriber {pubkey: $Pubkey})
+			SET
+				s.channel_secret = $ChannelSecret,
+				s.subscribed_at = $SubscribedAt,
+				s.unsubscribed_at = $UnsubscribedAt,
+				s.tier = $Tier,
+				s.tier_expires_at = $TierExpiresAt,
+				s.last_pushed_at = $LastPushedAt,
+				s.shard_key = $ShardKey
+			MERGE (u:User {pubkey: $Pubkey})
+			SET u.notify_opt_out = $OptOut;
+		`
+		subscribedAt := unix(exported.SubscribedAt)
+		if subscribedAt == nil {
+			subscribedAt = time.Now().Unix()
+		}
+		if _, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":         exported.Pubkey,
+				"ChannelSecret":  string(channelSecret),
+				"SubscribedAt":   subscribedAt,
+				"UnsubscribedAt": unix(exported.UnsubscribedAt),
+				"Tier":           exported.Tier,
+				"TierExpiresAt":  unix(exported.TierExpiresAt),
+				"LastPushedAt":   unix(exported.LastPushedAt),
+				"OptOut":         exported.NotifyOptOut,
+				"ShardKey":       int64(types.ShardKey(exported.Pubkey)),
+			}); err != nil {
+			return nil, err
+		}
+
+		for _, interest := range exported.Interests {
+			query := `
+				MATCH (s:Subscriber {pubkey: $Pubkey})
+				MERGE (t:Topic {name: $Topic})
+				MERGE (s)-[r:INTERESTED_IN]->(t)
+				SET r.weight = 1, r.source = $Source, r.updated_at = $Now;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Pubkey": exported.Pubkey,
+					"Topic":  interest.Topic,
+					"Source": interest.Source,
+					"Now":    time.Now().Unix(),
+				}); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// bundleCipher derives an AES-256-GCM cipher from the passphrase
+func bundleCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
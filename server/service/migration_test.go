@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundleCipher(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	aead, err := bundleCipher("correct horse", salt)
+	assert.NoError(t, err)
+
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nil, nonce, []byte("channel secret"), []byte("pubkey"))
+
+	other, err := bundleCipher("correct horse", salt)
+	assert.NoError(t, err)
+	plain, err := other.Open(nil, nonce, sealed, []byte("pubkey"))
+	assert.NoError(t, err)
+	assert.Equal(t, "channel secret", string(plain))
+
+	// secrets are bound to their subscriber
+	_, err = other.Open(nil, nonce, sealed, []byte("another pubkey"))
+	assert.Error(t, err)
+
+	wrong, err := bundleCipher("wrong", salt)
+	assert.NoError(t, err)
+	_, err = wrong.Open(nil, nonce, sealed, []byte("pubkey"))
+	assert.Error(t, err)
+}
@@ -12,8 +12,88 @@ type MockService struct {
 	mock.Mock
 }
 
-func (m *MockService) GetFeed(subscriberPub string, start time.Time, end time.Time, limit int) []types.FeedEntry {
-	args := m.Called(subscriberPub, start, end, limit)
+func (m *MockService) SyncPreferences(ctx context.Context, pubkey string, prefs types.Preferences, updatedAt time.Time) (bool, error) {
+	args := m.Called(ctx, pubkey, prefs, updatedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockService) FollowsChannel(ctx context.Context, pubkey, channelPub string) (bool, error) {
+	args := m.Called(ctx, pubkey, channelPub)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockService) MarkReminded(ctx context.Context, pubkey string, remindedAt time.Time) error {
+	args := m.Called(ctx, pubkey, remindedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) SetPrivateDigest(ctx context.Context, pubkey string, private bool) error {
+	args := m.Called(ctx, pubkey, private)
+	return args.Error(0)
+}
+
+func (m *MockService) SetChannelBranding(ctx context.Context, pubkey, name, picture string) error {
+	args := m.Called(ctx, pubkey, name, picture)
+	return args.Error(0)
+}
+
+func (m *MockService) RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error {
+	args := m.Called(ctx, pubkey, requestedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error {
+	args := m.Called(ctx, pubkey, channelPub, handedOverAt)
+	return args.Error(0)
+}
+
+func (m *MockService) QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error {
+	args := m.Called(ctx, pubkey, queuedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error {
+	args := m.Called(ctx, pubkey, welcomedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) GetPendingWelcomes(ctx context.Context) ([]string, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockService) MarkSurveyed(ctx context.Context, pubkey string, surveyedAt time.Time) error {
+	args := m.Called(ctx, pubkey, surveyedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) RecordSurveyResponse(ctx context.Context, pubkey string, score int, answeredAt time.Time) (bool, error) {
+	args := m.Called(ctx, pubkey, score, answeredAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockService) QueryFeed(ctx context.Context, query types.FeedQuery) []types.FeedEntry {
+	args := m.Called(ctx, query)
+	return args.Get(0).([]types.FeedEntry)
+}
+
+func (m *MockService) GetFeedByTopic(ctx context.Context, topic string, start time.Time, end time.Time, limit int) []types.FeedEntry {
+	args := m.Called(ctx, topic, start, end, limit)
+	return args.Get(0).([]types.FeedEntry)
+}
+
+func (m *MockService) GetFeed(ctx context.Context, subscriberPub string, start time.Time, end time.Time, limit, maxPerAuthor int) []types.FeedEntry {
+	args := m.Called(ctx, subscriberPub, start, end, limit, maxPerAuthor)
+	return args.Get(0).([]types.FeedEntry)
+}
+
+func (m *MockService) GetTrendingFeed(ctx context.Context, start time.Time, end time.Time, limit int) []types.FeedEntry {
+	args := m.Called(ctx, start, end, limit)
+	return args.Get(0).([]types.FeedEntry)
+}
+
+func (m *MockService) FilterReuse(output string, feed []types.FeedEntry) []types.FeedEntry {
+	args := m.Called(output, feed)
 	return args.Get(0).([]types.FeedEntry)
 }
 
@@ -22,22 +102,182 @@ func (m *MockService) ListSubscribers(ctx context.Context, limit, skip int) ([]t
 	return args.Get(0).([]types.Subscriber), args.Error(1)
 }
 
-func (m *MockService) GetSubscriber(pubkey string) *types.Subscriber {
-	args := m.Called(pubkey)
+func (m *MockService) ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error) {
+	args := m.Called(ctx, after, limit)
+	return args.Get(0).([]types.Subscriber), args.Error(1)
+}
+
+func (m *MockService) GetSubscriber(ctx context.Context, pubkey string) *types.Subscriber {
+	args := m.Called(ctx, pubkey)
 	return args.Get(0).(*types.Subscriber)
 }
 
-func (m *MockService) CreateSubscriber(pubkey, channelSK string, subscribedAt time.Time) error {
-	args := m.Called(pubkey, channelSK, subscribedAt)
+func (m *MockService) GetProfile(ctx context.Context, pubkey string) (*types.Profile, error) {
+	args := m.Called(ctx, pubkey)
+	return args.Get(0).(*types.Profile), args.Error(1)
+}
+
+func (m *MockService) GetReadRelays(ctx context.Context, pubkey string) ([]string, error) {
+	args := m.Called(ctx, pubkey)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockService) PruneRelations(ctx context.Context, now time.Time) (map[string]int64, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockService) PrunePosts(ctx context.Context, now time.Time) (map[string]int64, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (m *MockService) BackfillRawEvents(ctx context.Context, kinds []int) (*types.BackfillReport, error) {
+	args := m.Called(ctx, kinds)
+	return args.Get(0).(*types.BackfillReport), args.Error(1)
+}
+
+func (m *MockService) GetRawEvent(ctx context.Context, id string) (string, error) {
+	args := m.Called(ctx, id)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockService) CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error {
+	args := m.Called(ctx, pubkey, channelSK, subscribedAt)
 	return args.Error(0)
 }
 
-func (m *MockService) DeleteSubscriber(pubkey string, unsubscribedAt time.Time) error {
-	args := m.Called(pubkey, unsubscribedAt)
+func (m *MockService) SetChannelSecret(ctx context.Context, pubkey, channelSK string) error {
+	args := m.Called(ctx, pubkey, channelSK)
 	return args.Error(0)
 }
 
-func (m *MockService) RestoreSubscriber(pubkey string, subscribedAt time.Time) (bool, error) {
-	args := m.Called(pubkey, subscribedAt)
+func (m *MockService) DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error {
+	args := m.Called(ctx, pubkey, unsubscribedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) (bool, error) {
+	args := m.Called(ctx, pubkey, subscribedAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockService) IsNotificationOptedOut(ctx context.Context, pubkey string) (bool, error) {
+	args := m.Called(ctx, pubkey)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockService) SetNotificationOptOut(ctx context.Context, pubkey string, optOut bool) error {
+	args := m.Called(ctx, pubkey, optOut)
+	return args.Error(0)
+}
+
+func (m *MockService) GrantTier(ctx context.Context, pubkey, tier string, expiresAt *time.Time) error {
+	args := m.Called(ctx, pubkey, tier, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockService) MarkHandled(ctx context.Context, id string) (bool, error) {
+	args := m.Called(ctx, id)
 	return args.Bool(0), args.Error(1)
 }
+
+func (m *MockService) MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error {
+	args := m.Called(ctx, pubkey, pushedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error {
+	args := m.Called(ctx, pubkey, feed, deliveredAt)
+	return args.Error(0)
+}
+
+func (m *MockService) GetRecap(ctx context.Context, pubkey string, since time.Time) (*types.Recap, error) {
+	args := m.Called(ctx, pubkey, since)
+	return args.Get(0).(*types.Recap), args.Error(1)
+}
+
+func (m *MockService) GetRisingAuthors(ctx context.Context, now time.Time, period time.Duration, minEngagement, limit int) ([]types.RisingAuthor, error) {
+	args := m.Called(ctx, now, period, minEngagement, limit)
+	return args.Get(0).([]types.RisingAuthor), args.Error(1)
+}
+
+func (m *MockService) GetFollowed(ctx context.Context, pubkey string, pubkeys []string) (map[string]bool, error) {
+	args := m.Called(ctx, pubkey, pubkeys)
+	return args.Get(0).(map[string]bool), args.Error(1)
+}
+
+func (m *MockService) SetInterests(ctx context.Context, pubkey string, topics []string, interested bool) error {
+	args := m.Called(ctx, pubkey, topics, interested)
+	return args.Error(0)
+}
+
+func (m *MockService) GetInterests(ctx context.Context, pubkey string) ([]types.Interest, error) {
+	args := m.Called(ctx, pubkey)
+	return args.Get(0).([]types.Interest), args.Error(1)
+}
+
+func (m *MockService) InferInterests(ctx context.Context, pubkey string) error {
+	args := m.Called(ctx, pubkey)
+	return args.Error(0)
+}
+
+func (m *MockService) RecordLess(ctx context.Context, pubkey string, less types.LessFeedback) error {
+	args := m.Called(ctx, pubkey, less)
+	return args.Error(0)
+}
+
+func (m *MockService) RecordDigest(ctx context.Context, digest types.DigestMeta) error {
+	args := m.Called(ctx, digest)
+	return args.Error(0)
+}
+
+func (m *MockService) LastDigestAt(ctx context.Context, channel string) (*time.Time, error) {
+	args := m.Called(ctx, channel)
+	return args.Get(0).(*time.Time), args.Error(1)
+}
+
+func (m *MockService) RecordReceipt(ctx context.Context, receipt types.DeliveryReceipt) error {
+	args := m.Called(ctx, receipt)
+	return args.Error(0)
+}
+
+func (m *MockService) ProposeWeights(ctx context.Context) (*types.WeightProposal, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(*types.WeightProposal), args.Error(1)
+}
+
+func (m *MockService) ComputeLeaderboard(ctx context.Context, now time.Time) (*types.Leaderboard, error) {
+	args := m.Called(ctx, now)
+	return args.Get(0).(*types.Leaderboard), args.Error(1)
+}
+
+func (m *MockService) UpdateChurnRisk(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockService) MarkReengaged(ctx context.Context, pubkey string, reengagedAt time.Time) error {
+	args := m.Called(ctx, pubkey, reengagedAt)
+	return args.Error(0)
+}
+
+func (m *MockService) AcquireLease(ctx context.Context, name, owner string, cursor time.Time, ttl time.Duration, now time.Time) (*types.Lease, error) {
+	args := m.Called(ctx, name, owner, cursor, ttl, now)
+	return args.Get(0).(*types.Lease), args.Error(1)
+}
+
+func (m *MockService) ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error {
+	args := m.Called(ctx, name, owner, cursor)
+	return args.Error(0)
+}
+
+func (m *MockService) GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(*types.Checkpoint), args.Error(1)
+}
+
+func (m *MockService) SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error {
+	args := m.Called(ctx, checkpoint)
+	return args.Error(0)
+}
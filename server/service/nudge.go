@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// ErrContactsUnknown is returned for users whose contact list hasn't been
+// stored, so that who they follow can't be told
+var ErrContactsUnknown = errors.New("contact list unknown")
+
+// FollowsChannel tells if the subscriber's latest contact list includes the
+// channel, or returns ErrContactsUnknown if none was stored
+func (s *Service) FollowsChannel(ctx context.Context, pubkey, channelPub string) (bool, error) {
+	follows, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			OPTIONAL MATCH (u:User {pubkey: $Pubkey})
+			RETURN u.contacts_updated_at IS NOT NULL, EXISTS {
+				MATCH (:User {pubkey: $Pubkey})-[:FOLLOW]->(:User {pubkey: $ChannelPub})
+			};
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":     pubkey,
+				"ChannelPub": channelPub,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !record.Values[0].(bool) {
+			return nil, ErrContactsUnknown
+		}
+		return record.Values[1].(bool), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return follows.(bool), nil
+}
+
+// MarkReminded counts a reminder sent to follow the channel
+func (s *Service) MarkReminded(ctx context.Context, pubkey string, remindedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET
+				s.follow_reminders = coalesce(s.follow_reminders, 0) + 1,
+				s.last_reminded_at = $RemindedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":     pubkey,
+				"RemindedAt": remindedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func compressObject(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressObject returns the JSON of an object, which is stored either
+// gzipped or as is
+func decompressObject(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// createsPost tells if events of the kind are stored as a :Post, which
+// writes their object
+func createsPost(kind int) bool {
+	switch kind {
+	case 1, 6, 7, 16, reportKind, 9735, articleKind:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetRawEvent returns the original signed JSON of a stored event, after
+// checking that its signature still verifies. Events of any kind are read
+// from their local object, falling back to the raw archive of unsupported
+// kinds, and to the archive for posts whose object has been cleaned up.
+func (s *Service) GetRawEvent(ctx context.Context, id string) (string, error) {
+	if len(id) != 64 {
+		return "", fmt.Errorf("invalid event id %q", id)
+	}
+
+	raw, err := s.readLocalObject(id)
+	if errors.Is(err, os.ErrNotExist) && s.hasGraph() {
+		raw, err = s.readStoredEvent(ctx, id)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("event %s not found", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := verifyRaw(id, raw); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// readStoredEvent reads an event without local object from the graph: raw
+// events of unsupported kinds hold their JSON, posts are looked up in the
+// archive by creation time
+func (s *Service) readStoredEvent(ctx context.Context, id string) (string, error) {
+	found, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			OPTIONAL MATCH (p:Post {id: $Id})
+			OPTIONAL MATCH (e:Event {id: $Id})
+			RETURN coalesce(p.created_at, e.created_at), e.raw;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Id": id})
+		if err != nil {
+			return nil, err
+		}
+		if result.Next(ctx) {
+			return result.Record().Values, nil
+		}
+		return []any{nil, nil}, result.Err()
+	})
+	if err != nil {
+		return "", err
+	}
+
+	values := found.([]any)
+	if raw, ok := values[1].(string); ok {
+		return raw, nil
+	}
+	createdAt, ok := values[0].(int64)
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return s.readObject(id, time.Unix(createdAt, 0))
+}
+
+// verifyRaw checks that the JSON is the event with the id, signed by its
+// author
+func verifyRaw(id, raw string) error {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return err
+	}
+	if ev.ID != id || ev.GetID() != id {
+		return fmt.Errorf("object of %s holds a different event", id)
+	}
+	if ok, err := ev.CheckSignature(); !ok {
+		return fmt.Errorf("invalid signature of %s: %v", id, err)
+	}
+	return nil
+}
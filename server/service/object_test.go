@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjects(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	ev := &nostr.Event{PubKey: pub, Kind: 1, Content: "hello", CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	assert.NoError(t, ev.Sign(sk))
+
+	for _, compress := range []bool{true, false} {
+		s := &Service{config: &types.Config{Objects: types.ObjectsConfig{Root: t.TempDir(), Compress: compress}}}
+		assert.NoError(t, s.writeObject(ev))
+
+		raw, err := s.readObject(ev.ID, ev.CreatedAt)
+		assert.NoError(t, err)
+		assert.NoError(t, verifyRaw(ev.ID, raw))
+	}
+}
+
+func TestGetRawEvent(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	ev := &nostr.Event{PubKey: pub, Kind: 3, Content: "", CreatedAt: time.Now(), Tags: nostr.Tags{{"p", pub}}}
+	assert.NoError(t, ev.Sign(sk))
+
+	// events other than posts are served from their object too
+	s := &Service{config: &types.Config{Objects: types.ObjectsConfig{Root: t.TempDir(), Compress: true}}}
+	assert.NoError(t, s.writeObject(ev))
+	raw, err := s.GetRawEvent(context.Background(), ev.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, verifyRaw(ev.ID, raw))
+
+	_, err = s.GetRawEvent(context.Background(), strings.Repeat("0", 64))
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestVerifyRaw(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	ev := &nostr.Event{PubKey: pub, Kind: 0, Content: "{}", CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	assert.NoError(t, ev.Sign(sk))
+
+	raw, _ := ev.MarshalJSON()
+	assert.NoError(t, verifyRaw(ev.ID, string(raw)))
+	assert.Error(t, verifyRaw(nostr.GeneratePrivateKey(), string(raw)))
+
+	ev.Content = `{"name":"tampered"}`
+	tampered, _ := ev.MarshalJSON()
+	assert.Error(t, verifyRaw(ev.ID, string(tampered)))
+}
+
+func TestCleanObjects(t *testing.T) {
+	root := t.TempDir()
+	s := &Service{config: &types.Config{Objects: types.ObjectsConfig{Root: root}}}
+
+	sk := nostr.GeneratePrivateKey()
+	ev := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: time.Now(), Tags: nostr.Tags{}}
+	assert.NoError(t, ev.Sign(sk))
+	assert.NoError(t, s.writeObject(ev))
+	object, _ := s.objPath(ev.ID)
+
+	// state kept next to the objects must survive
+	kept := []string{
+		path.Join(root, "wal", "00000001.log"),
+		path.Join(root, "archive", "events", "2023", "03", "22", "1.jsonl.gz"),
+		path.Join(root, "retry", "deadletter.jsonl"),
+		path.Join(root, "nossence.db"),
+		path.Join(root, "crashes", "1.json"),
+	}
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for _, file := range append(kept, object) {
+		assert.NoError(t, os.MkdirAll(path.Dir(file), 0755))
+		if file != object {
+			assert.NoError(t, os.WriteFile(file, []byte("x"), 0644))
+		}
+		assert.NoError(t, os.Chtimes(file, old, old))
+	}
+
+	s.CleanObjects()
+
+	assert.NoFileExists(t, object)
+	for _, file := range kept {
+		assert.FileExists(t, file)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// SyncPreferences applies preferences a subscriber published. Preferences
+// older than the last synced ones are ignored, as relays may deliver replaced
+// events out of order. It returns whether the preferences were applied.
+func (s *Service) SyncPreferences(ctx context.Context, pubkey string, prefs types.Preferences, updatedAt time.Time) (bool, error) {
+	defer s.subscribers.invalidate(pubkey)
+	newer, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			WHERE coalesce(s.preferences_at, 0) < $UpdatedAt
+			SET s.preferences_at = $UpdatedAt
+			RETURN count(s);
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":    pubkey,
+				"UpdatedAt": updatedAt.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return record.Values[0].(int64) > 0, nil
+	})
+	if err != nil || !newer.(bool) {
+		return false, err
+	}
+
+	if len(prefs.Interests) > 0 {
+		if err := s.SetInterests(ctx, pubkey, prefs.Interests, true); err != nil {
+			return false, err
+		}
+	}
+	if len(prefs.Uninterested) > 0 {
+		if err := s.SetInterests(ctx, pubkey, prefs.Uninterested, false); err != nil {
+			return false, err
+		}
+	}
+	if prefs.NotificationOptOut != nil {
+		if err := s.SetNotificationOptOut(ctx, pubkey, *prefs.NotificationOptOut); err != nil {
+			return false, err
+		}
+	}
+	if prefs.PrivateDigest != nil {
+		if err := s.SetPrivateDigest(ctx, pubkey, *prefs.PrivateDigest); err != nil {
+			return false, err
+		}
+	}
+	if prefs.MoreContent != nil || prefs.LessContent != nil {
+		if err := s.SetContentPreferences(pubkey, prefs.MoreContent, prefs.LessContent); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// SetPrivateDigest chooses whether the subscriber's digests are delivered
+// privately
+func (s *Service) SetPrivateDigest(ctx context.Context, pubkey string, private bool) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.private_digest = $Private;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":  pubkey,
+				"Private": private,
+			})
+		return nil, err
+	})
+	return err
+}
+
+// SetChannelBranding stores the name and picture the subscriber chose for
+// their channel, empty values restore the defaults
+func (s *Service) SetChannelBranding(ctx context.Context, pubkey, name, picture string) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.channel_name = $Name, s.channel_picture = $Picture;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":  pubkey,
+				"Name":    name,
+				"Picture": picture,
+			})
+		return nil, err
+	})
+	return err
+}
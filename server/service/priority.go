@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+var (
+	priorityQueueGauge = metrics.NewGauge("priority/queue/length")
+	priorityLatency    = metrics.NewHistogram("priority/latency")
+	priorityEvents     = metrics.NewCounter("priority/events")
+	priorityOverflow   = metrics.NewCounter("priority/overflow")
+)
+
+type queuedEvent struct {
+	pendingEvent
+	ctx      context.Context
+	queuedAt time.Time
+}
+
+// priorityLane stores events of authors followed by any subscriber ahead of
+// the firehose. It bypasses the batch writer with its own bounded queue and
+// workers. When the queue is full events take the regular path.
+type priorityLane struct {
+	config types.PriorityConfig
+	store  func(ctx context.Context, event *nostr.Event, relay string) error
+
+	mu      sync.RWMutex
+	follows map[string]bool
+
+	queue chan queuedEvent
+}
+
+func newPriorityLane(config types.PriorityConfig, store func(ctx context.Context, event *nostr.Event, relay string) error) *priorityLane {
+	size := config.QueueSize
+	if size <= 0 {
+		size = 1000
+	}
+	return &priorityLane{
+		config:  config,
+		store:   store,
+		follows: map[string]bool{},
+		queue:   make(chan queuedEvent, size),
+	}
+}
+
+// Follows tells if any subscriber follows the author
+func (l *priorityLane) Follows(pubkey string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.follows[pubkey]
+}
+
+func (l *priorityLane) setFollows(follows map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.follows = follows
+}
+
+// Add queues an event to be stored with the correlation ID carried by ctx,
+// it returns false if the queue is full
+func (l *priorityLane) Add(ctx context.Context, event *nostr.Event, relay string, ack func(error)) bool {
+	select {
+	case l.queue <- queuedEvent{pendingEvent{event: event, relay: relay, ack: ack}, ctx, time.Now()}:
+		priorityQueueGauge.Update(int64(len(l.queue)))
+		return true
+	default:
+		priorityOverflow.Inc(1)
+		return false
+	}
+}
+
+// Start runs the workers until ctx is done
+func (l *priorityLane) Start(ctx context.Context) {
+	workers := l.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case q := <-l.queue:
+					priorityQueueGauge.Update(int64(len(l.queue)))
+					l.process(q)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (l *priorityLane) process(q queuedEvent) {
+	err := l.store(q.ctx, q.event, q.relay)
+	if q.ack != nil {
+		q.ack(err)
+	}
+	if err != nil {
+		correlation.Logger(q.ctx, logger).Error("Failed to store priority event", "id", q.event.ID, "err", err)
+		return
+	}
+
+	priorityEvents.Inc(1)
+	priorityLatency.Update(time.Since(q.queuedAt).Milliseconds())
+}
+
+// refreshFollowedAuthors reloads the authors followed by active subscribers
+func (s *Service) refreshFollowedAuthors() {
+	follows, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber) WHERE s.unsubscribed_at IS NULL
+			MATCH (:User {pubkey: s.pubkey})-[:FOLLOW]->(u:User)
+			RETURN DISTINCT u.pubkey;
+		`
+		result, err := tx.Run(ctx, query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		follows := map[string]bool{}
+		for result.Next(ctx) {
+			follows[result.Record().Values[0].(string)] = true
+		}
+		return follows, nil
+	})
+	if err != nil {
+		logger.Error("Failed to load followed authors", "err", err)
+		return
+	}
+
+	s.priority.setFollows(follows.(map[string]bool))
+	logger.Debug("Loaded followed authors", "count", len(follows.(map[string]bool)))
+}
+
+func (s *Service) startPriorityLane(ctx context.Context) {
+	s.refreshFollowedAuthors()
+	s.priority.Start(ctx)
+
+	interval := parseDurationOr(s.config.Priority.RefreshInterval, 5*time.Minute)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.refreshFollowedAuthors()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityLane(t *testing.T) {
+	stored := make(chan string, 2)
+	l := newPriorityLane(types.PriorityConfig{QueueSize: 1, Workers: 1}, func(ctx context.Context, event *nostr.Event, relay string) error {
+		stored <- event.ID
+		return nil
+	})
+	l.setFollows(map[string]bool{"alice": true})
+
+	assert.True(t, l.Follows("alice"))
+	assert.False(t, l.Follows("bob"))
+
+	// the queue is full until the workers start
+	assert.True(t, l.Add(context.Background(), &nostr.Event{ID: "1"}, "", nil))
+	assert.False(t, l.Add(context.Background(), &nostr.Event{ID: "2"}, "", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.Start(ctx)
+
+	select {
+	case id := <-stored:
+		assert.Equal(t, "1", id)
+	case <-time.After(time.Second):
+		t.Fatal("priority event not stored")
+	}
+}
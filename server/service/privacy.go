@@ -0,0 +1,51 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+
+	"github.com/dyng/nosdaily/types"
+)
+
+// privatize applies the Laplace mechanism to a count derived from subscriber
+// behavior, where a single subscriber changes the count by at most sensitivity.
+// Counts whose noisy value falls under the configured threshold are suppressed
+// so that small groups can't be singled out.
+func (s *Service) privatize(count int64, sensitivity float64) types.NoisyCount {
+	conf := s.config.Privacy
+	if conf.Epsilon <= 0 {
+		return types.NoisyCount{Value: count}
+	}
+
+	noisy := float64(count) + laplace(sensitivity/conf.Epsilon)
+	value := int64(math.Round(noisy))
+	if value < int64(conf.MinCount) {
+		return types.NoisyCount{Suppressed: true}
+	}
+	return types.NoisyCount{Value: value}
+}
+
+// laplace draws a sample from Laplace(0, scale) via inverse transform sampling
+func laplace(scale float64) float64 {
+	u := uniform() - 0.5
+	// the inverse is infinite at -0.5, draw again
+	for u == -0.5 {
+		u = uniform() - 0.5
+	}
+	sign := 1.0
+	if u < 0 {
+		sign = -1.0
+	}
+	return -scale * sign * math.Log(1-2*math.Abs(u))
+}
+
+// uniform returns a float64 in [0, 1) using a cryptographically secure source,
+// since predictable noise can be averaged out by an attacker
+func uniform() float64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return float64(binary.BigEndian.Uint64(b[:])>>11) / (1 << 53)
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivatize(t *testing.T) {
+	s := &Service{config: &types.Config{
+		Privacy: types.PrivacyConfig{Epsilon: 1.0, MinCount: 10},
+	}}
+
+	// small groups are suppressed
+	assert.True(t, s.privatize(1, 1).Suppressed)
+
+	// large counts are kept close to the true value
+	sum := int64(0)
+	for i := 0; i < 1000; i++ {
+		c := s.privatize(10000, 1)
+		assert.False(t, c.Suppressed)
+		sum += c.Value
+	}
+	assert.InDelta(t, 10000, float64(sum)/1000, 1)
+
+	// noise can be disabled
+	s.config.Privacy.Epsilon = 0
+	assert.Equal(t, types.NoisyCount{Value: 3}, s.privatize(3, 1))
+}
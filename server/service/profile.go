@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/alert"
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// db hits below this are never reported as a regression
+const profileMinDbHits = 1000
+
+// profiledQuery is a hot query profiled with representative parameters
+type profiledQuery struct {
+	name   string
+	query  string
+	params func(now time.Time) map[string]any
+}
+
+type planStats struct {
+	DbHits int64
+	Rows   int64
+	// operators scanning a label or all nodes instead of using an index
+	Scans []string
+}
+
+func (s *Service) hotQueries() []profiledQuery {
+	return []profiledQuery{
+		{
+			name:  "feed",
+			query: scoreQuery,
+			params: func(now time.Time) map[string]any {
+				return s.scoreParams(types.FeedQuery{Start: now.Add(-time.Hour), End: now, Limit: 10})
+			},
+		},
+		{
+			name:  "post",
+			query: "MATCH (p:Post {id: $Id}) RETURN p.id;",
+			params: func(now time.Time) map[string]any {
+				return map[string]any{"Id": ""}
+			},
+		},
+	}
+}
+
+// ProfileQueries runs the hot queries with PROFILE and logs their db hits
+// and rows. The first profile of each query is kept as its baseline, later
+// plans that scan where the baseline didn't, or whose db hits grow beyond the
+// regression factor, are reported to the operator.
+func (s *Service) ProfileQueries(ctx context.Context) {
+	now := time.Now()
+	for _, q := range s.hotQueries() {
+		stats, err := s.profile(ctx, q.query, q.params(now))
+		if err != nil {
+			logger.Error("Failed to profile query", "query", q.name, "err", err)
+			continue
+		}
+
+		logger.Info("Profiled query", "query", q.name, "dbHits", stats.DbHits, "rows", stats.Rows, "scans", stats.Scans)
+		metrics.NewGauge(fmt.Sprintf("query/%s/dbhits", q.name)).Update(stats.DbHits)
+
+		baseline, ok := s.profiles[q.name]
+		if !ok {
+			s.profiles[q.name] = *stats
+			continue
+		}
+		if reasons := planRegressions(baseline, *stats, s.config.Profiling.RegressionFactor); len(reasons) > 0 {
+			logger.Warn("Query plan regressed", "query", q.name, "reasons", reasons)
+			s.alerter.Notify(ctx, alert.SeverityWarning, "query plan regressed: "+q.name, strings.Join(reasons, "; "))
+		}
+	}
+}
+
+func (s *Service) profile(ctx context.Context, query string, params map[string]any) (*planStats, error) {
+	stats, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, "PROFILE "+query, params)
+		if err != nil {
+			return nil, err
+		}
+		summary, err := result.Consume(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if summary.Profile() == nil {
+			return nil, fmt.Errorf("no profile returned")
+		}
+		stats := summarizePlan(summary.Profile())
+		return &stats, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats.(*planStats), nil
+}
+
+func (s *Service) startQueryProfiler(ctx context.Context) {
+	interval := parseDurationOr(s.config.Profiling.Interval, time.Hour)
+	go func() {
+		s.ProfileQueries(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.ProfileQueries(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// summarizePlan sums up db hits of the whole plan
+func summarizePlan(root neo4j.ProfiledPlan) planStats {
+	stats := planStats{Rows: root.Records(), Scans: []string{}}
+	var walk func(p neo4j.ProfiledPlan)
+	walk = func(p neo4j.ProfiledPlan) {
+		stats.DbHits += p.DbHits()
+
+		// operators are reported as e.g. "NodeByLabelScan@neo4j"
+		op := strings.Split(p.Operator(), "@")[0]
+		if strings.HasSuffix(op, "Scan") && !strings.Contains(op, "Index") {
+			stats.Scans = append(stats.Scans, op)
+		}
+		for _, child := range p.Children() {
+			walk(child)
+		}
+	}
+	walk(root)
+	return stats
+}
+
+func planRegressions(baseline, current planStats, factor float64) []string {
+	reasons := []string{}
+	for _, scan := range current.Scans {
+		if !slices.Contains(baseline.Scans, scan) {
+			reasons = append(reasons, fmt.Sprintf("new %s, index may not be used", scan))
+		}
+	}
+	if factor > 0 && current.DbHits > profileMinDbHits && float64(current.DbHits) > factor*float64(baseline.DbHits) {
+		reasons = append(reasons, fmt.Sprintf("db hits grew from %d to %d", baseline.DbHits, current.DbHits))
+	}
+	return reasons
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePlan struct {
+	operator string
+	dbHits   int64
+	records  int64
+	children []neo4j.ProfiledPlan
+}
+
+func (p *fakePlan) Operator() string               { return p.operator }
+func (p *fakePlan) Arguments() map[string]any      { return nil }
+func (p *fakePlan) Identifiers() []string          { return nil }
+func (p *fakePlan) DbHits() int64                  { return p.dbHits }
+func (p *fakePlan) Records() int64                 { return p.records }
+func (p *fakePlan) Children() []neo4j.ProfiledPlan { return p.children }
+func (p *fakePlan) PageCacheMisses() int64         { return 0 }
+func (p *fakePlan) PageCacheHits() int64           { return 0 }
+func (p *fakePlan) PageCacheHitRatio() float64     { return 0 }
+func (p *fakePlan) Time() int64                    { return 0 }
+
+func TestPlanRegressions(t *testing.T) {
+	indexed := summarizePlan(&fakePlan{operator: "ProduceResults@neo4j", records: 1, children: []neo4j.ProfiledPlan{
+		&fakePlan{operator: "NodeUniqueIndexSeek@neo4j", dbHits: 2},
+	}})
+	assert.Equal(t, planStats{DbHits: 2, Rows: 1, Scans: []string{}}, indexed)
+
+	scanned := summarizePlan(&fakePlan{operator: "ProduceResults@neo4j", records: 1, children: []neo4j.ProfiledPlan{
+		&fakePlan{operator: "Filter@neo4j", dbHits: 5000, children: []neo4j.ProfiledPlan{
+			&fakePlan{operator: "NodeByLabelScan@neo4j", dbHits: 5000},
+		}},
+	}})
+	assert.Equal(t, int64(10000), scanned.DbHits)
+	assert.Equal(t, []string{"NodeByLabelScan"}, scanned.Scans)
+
+	assert.Empty(t, planRegressions(indexed, indexed, 2))
+	assert.Len(t, planRegressions(indexed, scanned, 2), 2)
+	assert.Empty(t, planRegressions(scanned, scanned, 2))
+
+	grown := scanned
+	grown.DbHits = 30000
+	assert.Equal(t, []string{"db hits grew from 10000 to 30000"}, planRegressions(scanned, grown, 2))
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// applyQualityFloor drops entries scoring below the configured minimum or
+// whose content is shorter than the minimum length, so that quiet periods
+// don't fill digests with barely engaged posts
+func applyQualityFloor(conf types.ScoringConfig, feed []types.FeedEntry) []types.FeedEntry {
+	if conf.MinScore <= 0 && conf.MinContentLength <= 0 {
+		return feed
+	}
+
+	filtered := feed[:0]
+	for _, entry := range feed {
+		if entry.Score < conf.MinScore {
+			continue
+		}
+		if conf.MinContentLength > 0 && contentLength(entry.Raw) < conf.MinContentLength {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// contentLength counts the characters of a post, reposts are measured by
+// the reposted post
+func contentLength(raw string) int {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return 0
+	}
+
+	if ev.Kind == 6 || ev.Kind == 16 {
+		var reposted nostr.Event
+		if err := json.Unmarshal([]byte(ev.Content), &reposted); err == nil {
+			ev = reposted
+		}
+	}
+	return utf8.RuneCountInString(strings.TrimSpace(ev.Content))
+}
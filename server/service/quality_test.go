@@ -0,0 +1,33 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyQualityFloor(t *testing.T) {
+	raw := func(kind int, content string) string {
+		b, _ := json.Marshal(nostr.Event{Kind: kind, Content: content})
+		return string(b)
+	}
+
+	feed := []types.FeedEntry{
+		{Id: "short", Score: 50, Raw: raw(1, " gm ")},
+		{Id: "quiet", Score: 5, Raw: raw(1, "a long enough post")},
+		{Id: "good", Score: 50, Raw: raw(1, "a long enough post")},
+		{Id: "repost", Score: 50, Raw: raw(6, raw(1, "一个足够长的帖子"))},
+	}
+
+	assert.Len(t, applyQualityFloor(types.ScoringConfig{}, feed), 4)
+
+	conf := types.ScoringConfig{MinScore: 10, MinContentLength: 8}
+	ids := []string{}
+	for _, e := range applyQualityFloor(conf, feed) {
+		ids = append(ids, e.Id)
+	}
+	assert.Equal(t, []string{"good", "repost"}, ids)
+}
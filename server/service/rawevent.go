@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// raw events backfilled per transaction
+const backfillBatchSize = 500
+
+// storeRawEvent keeps an event of an unsupported kind as a raw :Event node,
+// so that it can be backfilled once the kind is supported
+func (s *Service) storeRawEvent(event *nostr.Event) error {
+	raw, err := event.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (e:Event {id: $Id})
+			ON CREATE SET e.kind = $Kind, e.pubkey = $Pubkey, e.created_at = $CreatedAt, e.raw = $Raw;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Id":        event.ID,
+				"Kind":      event.Kind,
+				"Pubkey":    event.PubKey,
+				"CreatedAt": event.CreatedAt.Unix(),
+				"Raw":       string(raw),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// BackfillRawEvents stores raw events of the given kinds with their handler,
+// oldest first, and removes them from the raw archive. Only kinds supported
+// by now can be backfilled. Entries that can't be decoded or stored are left
+// in the archive and reported, the others are backfilled anyway.
+func (s *Service) BackfillRawEvents(ctx context.Context, kinds []int) (*types.BackfillReport, error) {
+	for _, kind := range kinds {
+		if s.storeFunc(ctx, kind) == nil {
+			return nil, fmt.Errorf("kind %d is not supported", kind)
+		}
+	}
+
+	report := &types.BackfillReport{Failed: []types.BackfillFailed{}}
+	failed := []string{}
+	for {
+		batch, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				MATCH (e:Event) WHERE e.kind IN $Kinds AND NOT e.id IN $Failed
+				RETURN e.id, e.raw
+				ORDER BY e.created_at
+				LIMIT $Limit;
+			`
+			result, err := tx.Run(ctx, query,
+				map[string]any{
+					"Kinds":  kinds,
+					"Failed": failed,
+					"Limit":  backfillBatchSize,
+				})
+			if err != nil {
+				return nil, err
+			}
+
+			rows := [][2]string{}
+			for result.Next(ctx) {
+				values := result.Record().Values
+				raw, _ := values[1].(string)
+				rows = append(rows, [2]string{values[0].(string), raw})
+			}
+			return rows, result.Err()
+		})
+		if err != nil {
+			return report, err
+		}
+
+		rows := batch.([][2]string)
+		ids := make([]string, 0, len(rows))
+		for _, row := range rows {
+			var ev nostr.Event
+			err := json.Unmarshal([]byte(row[1]), &ev)
+			if err == nil {
+				err = s.storeFunc(ctx, ev.Kind)(&ev)
+			}
+			if err != nil {
+				logger.Warn("Failed to backfill raw event", "id", row[0], "err", err)
+				report.Failed = append(report.Failed, types.BackfillFailed{Id: row[0], Reason: err.Error()})
+				failed = append(failed, row[0])
+				continue
+			}
+			ids = append(ids, row[0])
+		}
+
+		_, err = s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			_, err := tx.Run(ctx, "MATCH (e:Event) WHERE e.id IN $Ids DELETE e;", map[string]any{"Ids": ids})
+			return nil, err
+		})
+		if err != nil {
+			return report, err
+		}
+
+		report.Backfilled += len(ids)
+		if len(rows) < backfillBatchSize {
+			logger.Info("Backfilled raw events", "kinds", kinds, "total", report.Backfilled, "failed", len(report.Failed))
+			return report, nil
+		}
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreFunc(t *testing.T) {
+	s := &Service{config: &types.Config{}}
+	for _, kind := range []int{0, 1, 3, 5, 6, 7, 16, reportKind, 1985, 9735, muteListKind, relayListKind, articleKind} {
+		assert.NotNil(t, s.storeFunc(context.Background(), kind), "kind %d", kind)
+	}
+	for _, kind := range []int{4, 42, 30311} {
+		assert.Nil(t, s.storeFunc(context.Background(), kind), "kind %d", kind)
+	}
+
+	// unsupported kinds can't be backfilled
+	_, err := s.BackfillRawEvents(context.Background(), []int{42})
+	assert.Error(t, err)
+}
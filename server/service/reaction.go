@@ -0,0 +1,27 @@
+package service
+
+import "strings"
+
+// reaction polarities as stored on LIKE relations
+const (
+	reactionUp   = 1
+	reactionDown = -1
+)
+
+// parseReaction reads the content of a reaction (NIP-25): "+" or empty is a
+// like, "-" a dislike, anything else an emoji or a custom :shortcode: (NIP-30)
+// counting as a like unless it's a negative one. The emoji is empty for plain
+// likes and dislikes.
+func parseReaction(content string) (polarity int, emoji string) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "" || content == "+":
+		return reactionUp, ""
+	case content == "-":
+		return reactionDown, ""
+	case isNegativeReaction(content):
+		return reactionDown, content
+	default:
+		return reactionUp, content
+	}
+}
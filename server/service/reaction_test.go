@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReaction(t *testing.T) {
+	cases := []struct {
+		content  string
+		polarity int
+		emoji    string
+	}{
+		{"", reactionUp, ""},
+		{"+", reactionUp, ""},
+		{"-", reactionDown, ""},
+		{"👎", reactionDown, "👎"},
+		{"🤙", reactionUp, "🤙"},
+		{":soapbox:", reactionUp, ":soapbox:"},
+		{" + ", reactionUp, ""},
+	}
+	for _, c := range cases {
+		polarity, emoji := parseReaction(c.content)
+		assert.Equal(t, c.polarity, polarity, c.content)
+		assert.Equal(t, c.emoji, emoji, c.content)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const recapTopN = 5
+
+// RecordDeliveries links the subscriber to the posts delivered in a digest
+func (s *Service) RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error {
+	return s.repo.RecordDeliveries(ctx, pubkey, feed, deliveredAt)
+}
+
+// dropSeen removes the subscriber's own posts and posts already delivered to
+// them
+func (s *Service) dropSeen(ctx context.Context, subscriberPub string, feed []types.FeedEntry) []types.FeedEntry {
+	if subscriberPub == "" || len(feed) == 0 {
+		return feed
+	}
+
+	ids := make([]string, 0, len(feed))
+	for _, entry := range feed {
+		ids = append(ids, entry.Id)
+	}
+
+	delivered, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (:Subscriber {pubkey: $Pubkey})-[:DELIVERED]->(p:Post)
+			WHERE p.id IN $Ids
+			RETURN p.id;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": subscriberPub,
+				"Ids":    ids,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		delivered := map[string]bool{}
+		for result.Next(ctx) {
+			delivered[result.Record().Values[0].(string)] = true
+		}
+		return delivered, nil
+	})
+	if err != nil {
+		logger.Error("Failed to query delivered posts", "pubkey", subscriberPub, "err", err)
+		delivered = map[string]bool{}
+	}
+
+	filtered := feed[:0]
+	for _, entry := range feed {
+		if entry.Pubkey == subscriberPub || delivered.(map[string]bool)[entry.Id] {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// GetRecap summarizes what nossence delivered to the subscriber since the
+// given time: the number of featured posts, the most frequent topics, and the
+// delivered authors the subscriber has followed since.
+func (s *Service) GetRecap(ctx context.Context, pubkey string, since time.Time) (*types.Recap, error) {
+	recap, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		params := map[string]any{
+			"Pubkey": pubkey,
+			"Since":  since.Unix(),
+			"Limit":  recapTopN,
+		}
+
+		recap := &types.Recap{
+			Pubkey:     pubkey,
+			Since:      since,
+			TopTopics:  []types.RecapItem{},
+			TopAuthors: []types.RecapItem{},
+		}
+
+		result, err := tx.Run(ctx, `
+			MATCH (:Subscriber {pubkey: $Pubkey})-[r:DELIVERED]->(:Post)
+			WHERE r.at >= $Since
+			RETURN count(r);
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		recap.TotalFeatured = record.Values[0].(int64)
+
+		result, err = tx.Run(ctx, `
+			MATCH (:Subscriber {pubkey: $Pubkey})-[r:DELIVERED]->(:Post)
+			WHERE r.at >= $Since
+			UNWIND r.topics AS topic
+			RETURN topic, count(*) AS c
+			ORDER BY c DESC LIMIT $Limit;
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			recap.TopTopics = append(recap.TopTopics, types.RecapItem{
+				Key:   record.Values[0].(string),
+				Count: record.Values[1].(int64),
+			})
+		}
+
+		result, err = tx.Run(ctx, `
+			MATCH (:Subscriber {pubkey: $Pubkey})-[r:DELIVERED]->(p:Post)
+			WHERE r.at >= $Since
+			MATCH (:User {pubkey: $Pubkey})-[:FOLLOW]->(a:User {pubkey: p.author})
+			RETURN a.pubkey, count(p) AS c,
+				CASE WHEN coalesce(a.display_name, '') <> '' THEN a.display_name ELSE coalesce(a.name, '') END
+			ORDER BY c DESC LIMIT $Limit;
+		`, params)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next(ctx) {
+			record := result.Record()
+			recap.TopAuthors = append(recap.TopAuthors, types.RecapItem{
+				Key:   record.Values[0].(string),
+				Count: record.Values[1].(int64),
+				Label: record.Values[2].(string),
+			})
+		}
+
+		return recap, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recap.(*types.Recap), nil
+}
+
+// extractTopics returns the lowercased 't' tags of a raw event
+func extractTopics(raw string) []string {
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		return []string{}
+	}
+
+	topics := []string{}
+	for _, tag := range ev.Tags.GetAll([]string{"t"}) {
+		topic := strings.ToLower(tag.Value())
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
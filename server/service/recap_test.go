@@ -0,0 +1,15 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractTopics(t *testing.T) {
+	raw, _ := json.Marshal(nostr.Event{Tags: nostr.Tags{{"t", "Nostr"}, {"p", "someone"}, {"t", ""}, {"t", "bitcoin"}}})
+	assert.Equal(t, []string{"nostr", "bitcoin"}, extractTopics(string(raw)))
+	assert.Equal(t, []string{}, extractTopics("not json"))
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// StoreEventFromRelay stores an event and records the relay it was received
+// from. The first relay is kept separately from all relays it's seen on.
+// Events are logged to the WAL first if enabled. Events of followed authors
+// go through the priority lane if enabled, others are queued when the batch
+// writer is enabled. Events failing to be stored are retried later if
+// retries are enabled. Events stored right away are logged with the
+// correlation ID carried by ctx.
+func (s *Service) StoreEventFromRelay(ctx context.Context, event *nostr.Event, relay string) error {
+	s.storing.RLock()
+	defer s.storing.RUnlock()
+	if s.drained {
+		return ErrDrained
+	}
+
+	if err := limitEvent(s.config.Limits, event); err != nil {
+		return err
+	}
+	// the write isn't cancelled with the connection the event came from
+	ctx = correlation.Detach(ctx)
+
+	// an event delivered again by another relay is only recorded as seen
+	// there, in batches
+	if s.dedup != nil && !s.dedup.add(event.ID) {
+		if relay == "" || !s.dedup.addRelay(event.ID, nostr.NormalizeURL(relay)) {
+			return nil
+		}
+		if s.writer != nil {
+			s.writer.AddSeen(event, relay)
+			return nil
+		}
+		if s.dedup.queueSeen(seenOn(event, relay)) >= seenOnBatchSize {
+			return s.flushSeenOn(ctx)
+		}
+		return nil
+	}
+	if s.dedup != nil && relay != "" {
+		s.dedup.addRelay(event.ID, nostr.NormalizeURL(relay))
+	}
+	s.recordDigestFeedback(event)
+
+	ack := s.logEvent(event, relay)
+	if s.retries != nil {
+		ack = s.retries.ackOnRetry(event, relay, ack)
+	}
+	if s.dedup != nil {
+		ack = s.dedup.forgetOnError(event.ID, ack)
+	}
+
+	if s.priority != nil && s.priority.Follows(event.PubKey) && s.priority.Add(ctx, event, relay, ack) {
+		return nil
+	}
+
+	if s.writer != nil {
+		s.writer.Add(event, relay, ack)
+		return nil
+	}
+
+	err := s.storeEventFromRelay(ctx, event, relay)
+	if err != nil {
+		correlation.Logger(ctx, logger).Debug("Failed to store event from relay", "id", event.ID, "relay", relay, "err", err)
+	}
+	if ack != nil {
+		ack(err)
+	}
+	return err
+}
+
+// ErrDrained is returned for events received once the service is drained
+var ErrDrained = errors.New("service is shutting down")
+
+// Drain waits for events being stored from relays, until ctx is done, and
+// rejects those received later. Events queued by the batch writer are written
+// by Close.
+func (s *Service) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.storing.Lock()
+		s.drained = true
+		s.storing.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		logger.Info("Drained events being stored")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// storeEventFromRelay stores an event received from a relay, which is
+// deduplicated by StoreEventFromRelay already, and records the relay
+func (s *Service) storeEventFromRelay(ctx context.Context, event *nostr.Event, relay string) error {
+	if err := s.archiveAndStore(ctx, event, s.storeEvent); err != nil {
+		return err
+	}
+	return s.recordSeenOn(ctx, seenOn(event, relay))
+}
+
+const (
+	// relays duplicates were seen on are recorded once this many are queued,
+	// or every interval
+	seenOnBatchSize     = 500
+	seenOnFlushInterval = 10 * time.Second
+)
+
+// seenOn returns the relay an event was seen on as recorded by recordSeenOn,
+// nothing if the relay is unknown
+func seenOn(event *nostr.Event, relay string) []map[string]any {
+	if relay == "" {
+		return nil
+	}
+	return []map[string]any{{"id": event.ID, "relay": nostr.NormalizeURL(relay)}}
+}
+
+// flushSeenOn records the relays duplicates were seen on, queued by the
+// dedup cache
+func (s *Service) flushSeenOn(ctx context.Context) error {
+	return s.recordSeenOn(ctx, s.dedup.takeSeen())
+}
+
+func (s *Service) startSeenOnFlusher(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(seenOnFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.flushSeenOn(ctx); err != nil {
+					logger.Error("Failed to record relays events were seen on", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// recordSeenOn records the relays a batch of events was seen on
+func (s *Service) recordSeenOn(ctx context.Context, seen []map[string]any) error {
+	if len(seen) == 0 || !s.hasGraph() {
+		return nil
+	}
+
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			UNWIND $Seen AS e
+			MATCH (p:Post {id: e.id})
+			SET
+				p.first_seen_on = coalesce(p.first_seen_on, e.relay),
+				p.seen_on = CASE
+					WHEN e.relay IN coalesce(p.seen_on, []) THEN p.seen_on
+					ELSE coalesce(p.seen_on, []) + e.relay
+				END;
+		`
+		_, err := tx.Run(ctx, query, map[string]any{"Seen": seen})
+		return nil, err
+	})
+	return err
+}
+
+// attachSeenOn fills in the relays each entry was seen on, and drops entries
+// only seen on blacklisted relays
+func (s *Service) attachSeenOn(ctx context.Context, feed []types.FeedEntry) []types.FeedEntry {
+	if len(feed) == 0 {
+		return feed
+	}
+
+	ids := make([]string, 0, len(feed))
+	for _, e := range feed {
+		ids = append(ids, e.Id)
+	}
+
+	seen, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post) WHERE p.id IN $Ids AND p.seen_on IS NOT NULL
+			RETURN p.id, p.seen_on;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Ids": ids,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string][]string{}
+		for result.Next(ctx) {
+			record := result.Record()
+			relays := []string{}
+			for _, r := range record.Values[1].([]any) {
+				relays = append(relays, r.(string))
+			}
+			seen[record.Values[0].(string)] = relays
+		}
+		return seen, nil
+	})
+	if err != nil {
+		logger.Error("Failed to query seen relays", "err", err)
+		return feed
+	}
+
+	blacklist := make([]string, 0, len(s.config.Crawler.BlacklistRelays))
+	for _, r := range s.config.Crawler.BlacklistRelays {
+		blacklist = append(blacklist, nostr.NormalizeURL(r))
+	}
+
+	filtered := feed[:0]
+	for _, e := range feed {
+		e.SeenOn = seen.(map[string][]string)[e.Id]
+		if onlyBlacklisted(e.SeenOn, blacklist) {
+			logger.Debug("Skip post only seen on blacklisted relays", "id", e.Id, "relays", e.SeenOn)
+			continue
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+func onlyBlacklisted(relays, blacklist []string) bool {
+	if len(relays) == 0 || len(blacklist) == 0 {
+		return false
+	}
+	for _, r := range relays {
+		if !slices.Contains(blacklist, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetRelayStats counts posts created since the given time by the relay they
+// were first seen on, and by every relay they were seen on
+func (s *Service) GetRelayStats(ctx context.Context, since time.Time) ([]types.RelayStats, error) {
+	stats, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post) WHERE p.created_at >= $Since AND p.seen_on IS NOT NULL
+			UNWIND p.seen_on AS relay
+			RETURN relay, count(p) AS seen, sum(CASE WHEN p.first_seen_on = relay THEN 1 ELSE 0 END) AS first
+			ORDER BY seen DESC;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since": since.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		stats := []types.RelayStats{}
+		for result.Next(ctx) {
+			record := result.Record()
+			stats = append(stats, types.RelayStats{
+				URL:       record.Values[0].(string),
+				SeenPosts: record.Values[1].(int64),
+				FirstSeen: record.Values[2].(int64),
+			})
+		}
+		return stats, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats.([]types.RelayStats), nil
+}
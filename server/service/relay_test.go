@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlyBlacklisted(t *testing.T) {
+	blacklist := []string{"wss://spam.relay"}
+
+	assert.True(t, onlyBlacklisted([]string{"wss://spam.relay"}, blacklist))
+	assert.False(t, onlyBlacklisted([]string{"wss://spam.relay", "wss://relay.damus.io"}, blacklist))
+	// posts without attribution are kept
+	assert.False(t, onlyBlacklisted(nil, blacklist))
+	assert.False(t, onlyBlacklisted([]string{"wss://spam.relay"}, nil))
+}
+
+func TestDrain(t *testing.T) {
+	s := &Service{config: &types.Config{}}
+
+	// an event being stored holds the drain
+	s.storing.RLock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Drain(ctx), context.DeadlineExceeded)
+	s.storing.RUnlock()
+
+	assert.NoError(t, s.Drain(context.Background()))
+	assert.ErrorIs(t, s.StoreEventFromRelay(context.Background(), &nostr.Event{Kind: 1}, "wss://relay.damus.io"), ErrDrained)
+}
+
+func TestStoreEventFromRelays(t *testing.T) {
+	s := newSQLiteService(t)
+	s.dedup = newDedupCache(10)
+	note := &nostr.Event{ID: eventId(1), Kind: 1, PubKey: "alice", CreatedAt: time.Now()}
+	assert.NoError(t, s.StoreEventFromRelay(context.Background(), note, "wss://relay.damus.io"))
+
+	// the event is deduplicated by id, whichever relay delivers it
+	assert.NoError(t, s.StoreEventFromRelay(context.Background(), note, "wss://nos.lol"))
+	assert.False(t, s.dedup.add(note.ID))
+
+	// with the batch writer, it's queued to be recorded as seen only
+	var queued []pendingEvent
+	s.writer = newBatchWriter(types.WriterConfig{}, func(kind int, batch []pendingEvent) error {
+		queued = append(queued, batch...)
+		return nil
+	})
+	assert.NoError(t, s.StoreEventFromRelay(context.Background(), note, "wss://relay.nostr.band"))
+	s.writer.Flush()
+	if assert.Len(t, queued, 1) {
+		assert.True(t, queued[0].dup)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// relay list metadata as defined by NIP-65
+const relayListKind = 10002
+
+// parseRelayList returns the relays a user reads from and writes to. Relays
+// without a marker are used for both.
+func parseRelayList(event *nostr.Event) (read, write []string) {
+	read, write = []string{}, []string{}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[0] != "r" {
+			continue
+		}
+		if !strings.HasPrefix(tag[1], "wss://") && !strings.HasPrefix(tag[1], "ws://") {
+			continue
+		}
+		url := nostr.NormalizeURL(tag[1])
+
+		marker := ""
+		if len(tag) > 2 {
+			marker = tag[2]
+		}
+		if marker != "write" && !slices.Contains(read, url) {
+			read = append(read, url)
+		}
+		if marker != "read" && !slices.Contains(write, url) {
+			write = append(write, url)
+		}
+	}
+	return
+}
+
+// StoreRelayList replaces the relays a user declared to read from and write
+// to (their inbox and outbox), unless a newer list was already stored
+func (s *Service) StoreRelayList(event *nostr.Event) error {
+	read, write := parseRelayList(event)
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (u:User {pubkey: $Pubkey})
+			WITH u WHERE coalesce(u.relays_updated_at, 0) < $UpdatedAt
+			SET u.relays_updated_at = $UpdatedAt
+			WITH u
+			OPTIONAL MATCH (u)-[old:WRITES_TO|READS_FROM]->(:Relay)
+			DELETE old
+			WITH DISTINCT u
+			FOREACH (url IN $Write | MERGE (r:Relay {url: url}) MERGE (u)-[:WRITES_TO]->(r))
+			FOREACH (url IN $Read | MERGE (r:Relay {url: url}) MERGE (u)-[:READS_FROM]->(r));
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":    event.PubKey,
+				"Read":      read,
+				"Write":     write,
+				"UpdatedAt": event.CreatedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// GetReadRelays returns the relays a user reads from, i.e. where events
+// meant for them are best published to. Relay lists are only kept in the
+// graph.
+func (s *Service) GetReadRelays(ctx context.Context, pubkey string) ([]string, error) {
+	if !s.hasGraph() {
+		return []string{}, nil
+	}
+	relays, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (:User {pubkey: $Pubkey})-[:READS_FROM]->(r:Relay)
+			RETURN r.url;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Pubkey": pubkey})
+		if err != nil {
+			return nil, err
+		}
+
+		relays := []string{}
+		for result.Next(ctx) {
+			relays = append(relays, result.Record().Values[0].(string))
+		}
+		return relays, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return relays.([]string), nil
+}
+
+// GetMostFollowed returns the most-followed authors but the excluded ones,
+// along with the relays they write to. Only followers whose contact list was
+// updated since the given time are counted, so that the scan is bounded by
+// recently active users. If only is given, authors are picked among these.
+func (s *Service) GetMostFollowed(exclude, only []string, minFollowers, limit int, since time.Time) ([]types.FollowedAuthor, error) {
+	authors, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (u:User) WHERE u.contacts_updated_at >= $Since
+			MATCH (u)-[:FOLLOW]->(f:User)
+			WHERE u <> f AND NOT f.pubkey IN $Exclude AND (size($Only) = 0 OR f.pubkey IN $Only)
+			WITH f, count(u) AS followers
+			WHERE followers >= $MinFollowers
+			ORDER BY followers DESC
+			LIMIT $Limit
+			OPTIONAL MATCH (f)-[:WRITES_TO]->(r:Relay)
+			RETURN f.pubkey, followers, collect(r.url)
+			ORDER BY followers DESC;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since":        since.Unix(),
+				"Exclude":      exclude,
+				"Only":         only,
+				"MinFollowers": minFollowers,
+				"Limit":        limit,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		authors := []types.FollowedAuthor{}
+		for result.Next(ctx) {
+			record := result.Record()
+			author := types.FollowedAuthor{
+				Pubkey:    record.Values[0].(string),
+				Followers: int(record.Values[1].(int64)),
+			}
+			for _, url := range record.Values[2].([]any) {
+				author.Relays = append(author.Relays, url.(string))
+			}
+			authors = append(authors, author)
+		}
+		return authors, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return authors.([]types.FollowedAuthor), nil
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRelayList(t *testing.T) {
+	event := &nostr.Event{
+		Kind: relayListKind,
+		Tags: nostr.Tags{
+			{"r", "wss://both.relay/"},
+			{"r", "wss://inbox.relay", "read"},
+			{"r", "wss://outbox.relay", "write"},
+			{"r", "wss://both.relay"},
+			{"r", "https://not.a.relay"},
+			{"p", "pubkey"},
+		},
+	}
+
+	read, write := parseRelayList(event)
+	assert.Equal(t, []string{"wss://both.relay", "wss://inbox.relay"}, read)
+	assert.Equal(t, []string{"wss://both.relay", "wss://outbox.relay"}, write)
+
+	read, write = parseRelayList(&nostr.Event{Kind: relayListKind})
+	assert.Empty(t, read)
+	assert.Empty(t, write)
+}
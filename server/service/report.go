@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// report event kind as defined by NIP-56
+const reportKind = 1984
+
+type reportRef struct {
+	// id of the reported post or pubkey of the reported user
+	Target string
+	Type   string
+}
+
+// reportRefs returns the posts and users an event reports. The report type
+// is the third element of the e or p tag, "other" if missing.
+func reportRefs(event *nostr.Event) (posts, users []reportRef) {
+	for _, tag := range event.Tags {
+		if len(tag) < 2 || tag[1] == "" {
+			continue
+		}
+		ref := reportRef{Target: tag[1], Type: "other"}
+		if len(tag) > 2 && tag[2] != "" {
+			ref.Type = tag[2]
+		}
+		switch tag[0] {
+		case "e":
+			posts = append(posts, ref)
+		case "p":
+			users = append(users, ref)
+		}
+	}
+	return
+}
+
+// StoreReport records the posts and users a NIP-56 report flags, as
+// REPORTED relations from the report. Scoring penalizes posts reported by
+// enough distinct users, see ModerationConfig.
+func (s *Service) StoreReport(event *nostr.Event) error {
+	posts, users := reportRefs(event)
+	if len(posts) == 0 && len(users) == 0 {
+		return nil
+	}
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		if err := s.saveUserAndPost(ctx, tx, event); err != nil {
+			return nil, err
+		}
+
+		for _, ref := range posts {
+			query := `
+				MATCH (r:Post {id: $Id}), (p:Post {id: $Target})
+				MERGE (r)-[l:REPORTED]->(p)
+				SET l.type = $Type;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Id":     event.ID,
+					"Target": ref.Target,
+					"Type":   ref.Type,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, ref := range users {
+			query := `
+				MATCH (r:Post {id: $Id})
+				MERGE (u:User {pubkey: $Target})
+				MERGE (r)-[l:REPORTED]->(u)
+				SET l.type = $Type;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Id":     event.ID,
+					"Target": ref.Target,
+					"Type":   ref.Type,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	return err
+}
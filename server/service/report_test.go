@@ -0,0 +1,29 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportRefs(t *testing.T) {
+	event := &nostr.Event{
+		Kind: reportKind,
+		Tags: nostr.Tags{
+			{"e", "note", "spam"},
+			{"p", "author", "impersonation"},
+			{"p", "other"},
+			{"e", ""},
+			{"t", "topic"},
+		},
+	}
+
+	posts, users := reportRefs(event)
+	assert.Equal(t, []reportRef{{Target: "note", Type: "spam"}}, posts)
+	assert.Equal(t, []reportRef{{Target: "author", Type: "impersonation"}, {Target: "other", Type: "other"}}, users)
+
+	posts, users = reportRefs(&nostr.Event{Kind: reportKind})
+	assert.Empty(t, posts)
+	assert.Empty(t, users)
+}
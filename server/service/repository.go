@@ -0,0 +1,790 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/database"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// Repository stores posts, their interactions and subscribers, and ranks
+// posts for feeds. Features relying on the follow graph, reputation,
+// interests and the like query Neo4j directly and are only available with
+// the Neo4j repository.
+type Repository interface {
+	// Init creates the schema and migrates stored data
+	Init() error
+	// Ping checks whether the store is reachable
+	Ping(ctx context.Context) error
+	StorePost(event *nostr.Event) error
+	StoreLike(event *nostr.Event) error
+	StoreRepost(event *nostr.Event) error
+	StoreZap(event *nostr.Event) error
+	// ScorePosts ranks posts created within the query window, see scorePosts
+	ScorePosts(ctx context.Context, q types.FeedQuery) ([]scoredPost, error)
+	RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error
+	CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error
+	SetChannelSecret(ctx context.Context, pubkey, channelSK string) error
+	RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error
+	// HandOverChannel clears the channel secret, keeping its public key
+	HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error
+	GetHandedOverChannels(ctx context.Context) (map[string]string, error)
+	ListSubscribers(ctx context.Context, limit, skip int) ([]types.Subscriber, error)
+	ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error)
+	GetSubscriber(ctx context.Context, pubkey string) (*types.Subscriber, error)
+	DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error
+	RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) error
+	// AcquireLease takes the lease if it's free or expired, or renews it if
+	// owner holds it, moving its cursor unless zero. It returns the lease as
+	// stored afterwards.
+	AcquireLease(ctx context.Context, name, owner string, cursor, expiresAt, now time.Time) (*types.Lease, error)
+	// ReleaseLease frees the lease if owner holds it, leaving cursor to the
+	// next owner
+	ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error
+	// GetCheckpoint returns the checkpoint, nil if there's none
+	GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error
+	// MarkHandled records that the event was handled, and tells whether it
+	// wasn't before
+	MarkHandled(ctx context.Context, id string, handledAt time.Time) (bool, error)
+	MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error
+	QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error
+	MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error
+	// GetPendingWelcomes returns queued subscribers in the order they were
+	// queued
+	GetPendingWelcomes(ctx context.Context) ([]string, error)
+	// CountPublic counts active subscribers, subscribers since
+	// subscribedSince, and posts and their authors since postedSince
+	CountPublic(ctx context.Context, postedSince, subscribedSince time.Time) (publicCounts, error)
+}
+
+// hasGraph tells if the service is backed by Neo4j
+func (s *Service) hasGraph() bool {
+	_, ok := s.repo.(*neo4jRepository)
+	return ok
+}
+
+// neo4jRepository stores posts and subscribers in the graph
+type neo4jRepository struct {
+	s  *Service
+	db *database.Neo4jDb
+}
+
+func (r *neo4jRepository) Init() error {
+	err := r.s.migrateSchema(context.Background())
+
+	// restore tuned scoring weights
+	if err == nil {
+		r.s.loadWeights()
+	}
+
+	return err
+}
+
+func (r *neo4jRepository) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
+
+func (r *neo4jRepository) StorePost(event *nostr.Event) error {
+	_, err := r.db.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// create user & post
+		if err := r.s.saveUserAndPost(ctx, tx, event); err != nil {
+			return nil, err
+		}
+
+		// create reply relations, articles only mention other posts
+		if event.Kind == articleKind {
+			return nil, nil
+		}
+		root, parent := threadRefs(event)
+		if parent == "" {
+			// comments on articles may refer to them by address only
+			article, err := addressRef(ctx, tx, event)
+			if err != nil {
+				return nil, err
+			}
+			root, parent = article, article
+		}
+		if parent != "" {
+			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:REPLY_TO]->(r);",
+				map[string]any{
+					"Id":    event.ID,
+					"RefId": parent,
+				}); err != nil {
+				return nil, err
+			}
+		}
+		if root != "" {
+			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RootId merge (p)-[:ROOT]->(r);",
+				map[string]any{
+					"Id":     event.ID,
+					"RootId": root,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+func (r *neo4jRepository) StoreLike(event *nostr.Event) error {
+	_, err := r.db.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// create user & post
+		if err := r.s.saveUserAndPost(ctx, tx, event); err != nil {
+			return nil, err
+		}
+
+		// create like relation, carrying whether it's an up or down vote
+		ref, err := refId(ctx, tx, event)
+		if err != nil {
+			return nil, err
+		}
+		if ref != "" {
+			polarity, emoji := parseReaction(event.Content)
+			query := `
+				MATCH (p:Post {id: $Id}), (r:Post {id: $RefId})
+				MERGE (p)-[l:LIKE]->(r)
+				SET l.polarity = $Polarity, l.emoji = $Emoji;
+			`
+			if _, err := tx.Run(ctx, query,
+				map[string]any{
+					"Id":       event.ID,
+					"RefId":    ref,
+					"Polarity": polarity,
+					"Emoji":    emoji,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		// positive reactions of curators are boost votes
+		if r.s.curatorWeight(event.PubKey) > 0 && !isNegativeReaction(event.Content) {
+			if err := r.s.saveCuratorBoost(ctx, tx, event); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+func (r *neo4jRepository) StoreRepost(event *nostr.Event) error {
+	_, err := r.db.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// create user & post
+		if err := r.s.saveUserAndPost(ctx, tx, event); err != nil {
+			return nil, err
+		}
+
+		// create repost relation
+		ref, err := refId(ctx, tx, event)
+		if err != nil {
+			return nil, err
+		}
+		if ref != "" {
+			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:REPOST]->(r);",
+				map[string]any{
+					"Id":    event.ID,
+					"RefId": ref,
+				}); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+func (r *neo4jRepository) StoreZap(event *nostr.Event) error {
+	// decode zap amount
+	receipt, err := ParseZapReceipt(event)
+	if err != nil {
+		return err
+	}
+	amount := receipt.Amount
+
+	_, err = r.db.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// exit if not a zap to a post
+		ref, err := refId(ctx, tx, event)
+		if err != nil || ref == "" {
+			return nil, err
+		}
+
+		// create user & post
+		if err := r.s.saveUserAndPost(ctx, tx, event); err != nil {
+			return nil, err
+		}
+
+		// create zap relation
+		if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[z:ZAP {amount: $Amount}]->(r) set z.sender = $Sender;",
+			map[string]any{
+				"Id":     event.ID,
+				"RefId":  ref,
+				"Amount": amount,
+				"Sender": receipt.Sender,
+			}); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// RecordDeliveries keeps the hashtags of each post on the relation for later
+// retrospectives
+func (r *neo4jRepository) RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error {
+	if len(feed) == 0 {
+		return nil
+	}
+
+	deliveries := make([]map[string]any, 0, len(feed))
+	for _, entry := range feed {
+		deliveries = append(deliveries, map[string]any{
+			"Id":     entry.Id,
+			"Topics": extractTopics(entry.Raw),
+		})
+	}
+
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			UNWIND $Deliveries AS d
+			MATCH (p:Post {id: d.Id})
+			MERGE (s)-[r:DELIVERED]->(p)
+			ON CREATE SET r.at = $DeliveredAt, r.topics = d.Topics;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":      pubkey,
+				"Deliveries":  deliveries,
+				"DeliveredAt": deliveredAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (s:Subscriber {pubkey: $Pubkey}) ON CREATE
+			SET
+				s.channel_secret = $ChannelSecret,
+				s.subscribed_at = $SubscribedAt,
+				s.unsubscribed_at = null,
+				s.shard_key = $ShardKey;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":        pubkey,
+				"ChannelSecret": channelSK,
+				"SubscribedAt":  subscribedAt.Unix(),
+				"ShardKey":      int64(types.ShardKey(pubkey)),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) SetChannelSecret(ctx context.Context, pubkey, channelSK string) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.channel_secret = $ChannelSecret;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":        pubkey,
+				"ChannelSecret": channelSK,
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.claim_requested_at = $RequestedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":      pubkey,
+				"RequestedAt": requestedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.channel_secret = '', s.channel_pub = $ChannelPub, s.handed_over_at = $HandedOverAt
+			REMOVE s.claim_requested_at;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":       pubkey,
+				"ChannelPub":   channelPub,
+				"HandedOverAt": handedOverAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) GetHandedOverChannels(ctx context.Context) (map[string]string, error) {
+	channels, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber)
+			WHERE s.handed_over_at IS NOT NULL
+			RETURN s.pubkey, s.channel_pub;
+		`
+		result, err := tx.Run(ctx, query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		channels := map[string]string{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			channels[values[0].(string)] = values[1].(string)
+		}
+		return channels, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return channels.(map[string]string), nil
+}
+
+func (r *neo4jRepository) ListSubscribers(ctx context.Context, limit, skip int) ([]types.Subscriber, error) {
+	subscribers, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+		  MATCH (s:Subscriber)
+			RETURN s
+			ORDER BY s.pubkey
+			SKIP $Skip
+			LIMIT $Limit;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Limit": limit,
+				"Skip":  skip,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		var subscribers []types.Subscriber
+
+		for result.Next(ctx) {
+			record := result.Record()
+
+			rawItemNode, found := record.Get("s")
+			if !found {
+				return nil, fmt.Errorf("no s field")
+			}
+			itemNode := rawItemNode.(neo4j.Node)
+			props := itemNode.Props
+
+			subscriber := subscriberFromProps(props)
+
+			subscribers = append(subscribers, subscriber)
+		}
+
+		return subscribers, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return subscribers.([]types.Subscriber), nil
+}
+
+func (r *neo4jRepository) ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error) {
+	subscribers, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber) WHERE s.pubkey > $After
+			RETURN s
+			ORDER BY s.pubkey
+			LIMIT $Limit;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"After": after,
+				"Limit": limit,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		var subscribers []types.Subscriber
+		for result.Next(ctx) {
+			subscribers = append(subscribers, subscriberFromProps(result.Record().Values[0].(neo4j.Node).Props))
+		}
+		return subscribers, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscribers.([]types.Subscriber), nil
+}
+
+func (r *neo4jRepository) GetSubscriber(ctx context.Context, pubkey string) (*types.Subscriber, error) {
+	subscriber, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			RETURN s;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey": pubkey,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		rawItemNode, found := record.Get("s")
+		if !found {
+			return nil, fmt.Errorf("no s field")
+		}
+		itemNode := rawItemNode.(neo4j.Node)
+		props := itemNode.Props
+
+		subscriber := subscriberFromProps(props)
+
+		return subscriber, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := subscriber.(types.Subscriber)
+	return &result, nil
+}
+
+func (r *neo4jRepository) DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET
+				s.unsubscribed_at = $UnsubscribedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":         pubkey,
+				"UnsubscribedAt": unsubscribedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) error {
+	// remove unsubscribed_at timestamp and update subscribed_at timestamp
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET
+				s.unsubscribed_at = null, 
+				s.subscribed_at = $SubscribedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":       pubkey,
+				"SubscribedAt": subscribedAt.Unix(),
+			})
+
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) AcquireLease(ctx context.Context, name, owner string, cursor, expiresAt, now time.Time) (*types.Lease, error) {
+	lease, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		// setting the name locks the lease until the transaction ends, so
+		// that two instances can't both take it. The cursor is set first,
+		// while the owner is still the previous one.
+		query := `
+			MERGE (l:Lease {name: $Name})
+			SET l.name = $Name
+			WITH l, l.owner = $Owner OR coalesce(l.expires_at, 0) <= $Now AS acquired
+			SET
+				l.cursor = CASE WHEN acquired AND l.owner = $Owner THEN coalesce($Cursor, l.cursor) ELSE l.cursor END,
+				l.owner = CASE WHEN acquired THEN $Owner ELSE l.owner END,
+				l.expires_at = CASE WHEN acquired THEN $ExpiresAt ELSE l.expires_at END
+			RETURN l.owner, l.expires_at, l.cursor;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Name":      name,
+				"Owner":     owner,
+				"Cursor":    optionalUnix(cursor),
+				"ExpiresAt": expiresAt.Unix(),
+				"Now":       now.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values := record.Values
+		lease := types.Lease{Name: name, ExpiresAt: time.Unix(values[1].(int64), 0)}
+		if o, ok := values[0].(string); ok {
+			lease.Owner = o
+		}
+		if at, ok := values[2].(int64); ok {
+			lease.Cursor = time.Unix(at, 0)
+		}
+		return lease, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := lease.(types.Lease)
+	return &result, nil
+}
+
+func (r *neo4jRepository) ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (l:Lease {name: $Name, owner: $Owner})
+			SET l.owner = null, l.expires_at = 0, l.cursor = $Cursor;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Name":   name,
+				"Owner":  owner,
+				"Cursor": cursor.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error) {
+	checkpoint, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (c:Checkpoint {name: $Name})
+			RETURN c.end, c.window, c.offset, c.done, coalesce(c.after, '');
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Name": name})
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.Next(ctx) {
+			return (*types.Checkpoint)(nil), result.Err()
+		}
+		values := result.Record().Values
+		return &types.Checkpoint{
+			Name:   name,
+			End:    time.Unix(values[0].(int64), 0),
+			Window: time.Duration(values[1].(int64)) * time.Second,
+			Offset: int(values[2].(int64)),
+			Done:   values[3].(bool),
+			After:  values[4].(string),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint.(*types.Checkpoint), nil
+}
+
+func (r *neo4jRepository) SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (c:Checkpoint {name: $Name})
+			SET c.end = $End, c.window = $Window, c.offset = $Offset, c.after = $After, c.done = $Done;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Name":   checkpoint.Name,
+				"End":    checkpoint.End.Unix(),
+				"Window": int64(checkpoint.Window.Seconds()),
+				"Offset": checkpoint.Offset,
+				"After":  checkpoint.After,
+				"Done":   checkpoint.Done,
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) MarkHandled(ctx context.Context, id string, handledAt time.Time) (bool, error) {
+	first, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (h:HandledEvent {id: $Id})
+			ON CREATE SET h.handled_at = $HandledAt, h.new = true
+			WITH h, coalesce(h.new, false) AS first
+			REMOVE h.new
+			RETURN first;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Id":        id,
+				"HandledAt": handledAt.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return record.Values[0].(bool), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return first.(bool), nil
+}
+
+func (r *neo4jRepository) MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.last_pushed_at = $PushedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":   pubkey,
+				"PushedAt": pushedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.welcome_queued_at = $QueuedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":   pubkey,
+				"QueuedAt": queuedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error {
+	_, err := r.db.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.welcomed_at = $WelcomedAt, s.welcome_queued_at = null;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":     pubkey,
+				"WelcomedAt": welcomedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (r *neo4jRepository) GetPendingWelcomes(ctx context.Context) ([]string, error) {
+	pending, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber) WHERE s.welcome_queued_at IS NOT NULL
+			RETURN s.pubkey
+			ORDER BY s.welcome_queued_at;
+		`
+		result, err := tx.Run(ctx, query, map[string]any{})
+		if err != nil {
+			return nil, err
+		}
+
+		pending := []string{}
+		for result.Next(ctx) {
+			pending = append(pending, result.Record().Values[0].(string))
+		}
+		return pending, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending.([]string), nil
+}
+
+func (r *neo4jRepository) CountPublic(ctx context.Context, postedSince, subscribedSince time.Time) (publicCounts, error) {
+	counts, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			CALL {
+				MATCH (s:Subscriber) WHERE s.unsubscribed_at IS NULL RETURN count(s) AS subscribers
+			}
+			CALL {
+				MATCH (s:Subscriber) WHERE s.subscribed_at >= $WeekAgo RETURN count(s) AS newSubscribers
+			}
+			CALL {
+				MATCH (p:Post) WHERE p.created_at >= $Since RETURN count(p) AS posts, count(DISTINCT p.author) AS authors
+			}
+			RETURN subscribers, newSubscribers, posts, authors;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since":   postedSince.Unix(),
+				"WeekAgo": subscribedSince.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return publicCounts{
+			subscribers:    record.Values[0].(int64),
+			newSubscribers: record.Values[1].(int64),
+			posts:          record.Values[2].(int64),
+			authors:        record.Values[3].(int64),
+		}, nil
+	})
+	if err != nil {
+		return publicCounts{}, err
+	}
+	return counts.(publicCounts), nil
+}
+
+// optionalUnix converts a time to a property, null if it's zero
+func optionalUnix(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
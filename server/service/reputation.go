@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// users read or written back per transaction
+const reputationBatchSize = 5000
+
+// followPage is a page of users of the follow graph, with who they follow
+type followPage struct {
+	edges map[string][]string
+	last  string
+	users int
+}
+
+// UpdateReputation runs PageRank over the follow graph and stores each user's
+// rank as the reputation property, scaled so that the average user has a
+// reputation of 1. The graph is read in pages of users, so that no
+// transaction holds all of it.
+func (s *Service) UpdateReputation() error {
+	conf := s.config.Reputation
+	start := time.Now()
+
+	edges := map[string][]string{}
+	after := ""
+	for {
+		page, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				MATCH (u:User)-[:FOLLOW]->() WHERE u.pubkey > $After
+				WITH DISTINCT u ORDER BY u.pubkey LIMIT $Limit
+				OPTIONAL MATCH (u)-[:FOLLOW]->(f:User) WHERE u <> f
+				RETURN u.pubkey, collect(f.pubkey);
+			`
+			result, err := tx.Run(ctx, query, map[string]any{"After": after, "Limit": reputationBatchSize})
+			if err != nil {
+				return nil, err
+			}
+
+			page := followPage{edges: map[string][]string{}}
+			for result.Next(ctx) {
+				record := result.Record()
+				page.last = record.Values[0].(string)
+				page.users++
+				for _, to := range record.Values[1].([]any) {
+					page.edges[page.last] = append(page.edges[page.last], to.(string))
+				}
+			}
+			return page, result.Err()
+		})
+		if err != nil {
+			return err
+		}
+
+		p := page.(followPage)
+		for from, tos := range p.edges {
+			edges[from] = tos
+		}
+		if p.users < reputationBatchSize {
+			break
+		}
+		after = p.last
+	}
+
+	ranks := pageRank(edges, conf.Damping, conf.Iterations)
+	n := float64(len(ranks))
+	batch := make([]map[string]any, 0, reputationBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				UNWIND $Ranks AS r
+				MATCH (u:User {pubkey: r.Pubkey})
+				SET u.reputation = r.Reputation;
+			`
+			_, err := tx.Run(ctx, query, map[string]any{"Ranks": batch})
+			return nil, err
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	for pubkey, rank := range ranks {
+		batch = append(batch, map[string]any{"Pubkey": pubkey, "Reputation": rank * n})
+		if len(batch) == reputationBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	logger.Info("Updated reputation", "users", len(ranks), "elapsed", time.Since(start))
+	return nil
+}
+
+func (s *Service) startReputationUpdater(ctx context.Context) {
+	interval := parseDurationOr(s.config.Reputation.Interval, 24*time.Hour)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.UpdateReputation(); err != nil {
+					logger.Error("Failed to update reputation", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// pageRank computes the PageRank of every user in the follow graph by power
+// iteration. Ranks of users who follow no one are spread evenly over all
+// users. Ranks sum up to 1.
+func pageRank(follows map[string][]string, damping float64, iterations int) map[string]float64 {
+	nodes := map[string]bool{}
+	for from, tos := range follows {
+		nodes[from] = true
+		for _, to := range tos {
+			nodes[to] = true
+		}
+	}
+
+	n := float64(len(nodes))
+	ranks := make(map[string]float64, len(nodes))
+	for u := range nodes {
+		ranks[u] = 1 / n
+	}
+
+	for i := 0; i < iterations; i++ {
+		dangling := 0.0
+		for u := range nodes {
+			if len(follows[u]) == 0 {
+				dangling += ranks[u]
+			}
+		}
+
+		base := (1-damping)/n + damping*dangling/n
+		next := make(map[string]float64, len(nodes))
+		for u := range nodes {
+			next[u] = base
+		}
+		for from, tos := range follows {
+			share := damping * ranks[from] / float64(len(tos))
+			for _, to := range tos {
+				next[to] += share
+			}
+		}
+		ranks = next
+	}
+	return ranks
+}
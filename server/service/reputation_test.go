@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dyng/nosdaily/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageRank(t *testing.T) {
+	// everyone follows the hub, throwaway keys follow each other
+	follows := map[string][]string{
+		"alice": {"hub"},
+		"bob":   {"hub", "alice"},
+		"carol": {"hub"},
+		"hub":   {"alice"},
+		"spam1": {"spam2"},
+		"spam2": {"spam1"},
+	}
+
+	ranks := pageRank(follows, 0.85, 50)
+
+	sum := 0.0
+	for _, r := range ranks {
+		sum += r
+	}
+	assert.InDelta(t, 1.0, sum, 1e-9)
+
+	assert.Greater(t, ranks["hub"], ranks["alice"])
+	assert.Greater(t, ranks["alice"], ranks["bob"])
+	assert.Greater(t, ranks["hub"], ranks["spam1"])
+	assert.InDelta(t, ranks["spam1"], ranks["spam2"], 1e-9)
+
+	assert.Empty(t, pageRank(nil, 0.85, 10))
+}
+
+func TestPageRankSyntheticGraph(t *testing.T) {
+	g := fixtures.Generate(fixtures.DefaultConfig())
+	ranks := pageRank(g.Follows, 0.85, 20)
+
+	// the most followed users rank above the least followed ones
+	top, bottom := 0.0, 0.0
+	for _, u := range g.Users[:10] {
+		top += ranks[u]
+	}
+	for _, u := range g.Users[len(g.Users)-10:] {
+		bottom += ranks[u]
+	}
+	assert.Greater(t, top, 5*bottom)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// retentionTTLs returns the TTL of each relation type pruned, relation types
+// without a TTL are kept forever
+func retentionTTLs(conf types.RetentionConfig) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	for relation, value := range map[string]string{
+		"LIKE":     conf.Like,
+		"REPLY_TO": conf.Reply,
+		"REPOST":   conf.Repost,
+		"REPORTED": conf.Report,
+		"ZAP":      conf.Zap,
+		"FOLLOW":   conf.Follow,
+	} {
+		if value == "" {
+			continue
+		}
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTL of %s relations: %w", relation, err)
+		}
+		if ttl > 0 {
+			ttls[relation] = ttl
+		}
+	}
+	return ttls, nil
+}
+
+// PruneRelations deletes relations older than the TTL of their type. An
+// interaction is as old as the post it was made with. Follows are only
+// deleted once superseded by a newer contact list of the user, as old as the
+// newer list, so that users who don't update their list keep their follows.
+// Relations are deleted in batches, and the number deleted of each type is
+// returned.
+func (s *Service) PruneRelations(ctx context.Context, now time.Time) (map[string]int64, error) {
+	conf := s.config.Retention
+	ttls, err := retentionTTLs(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := map[string]int64{}
+	for relation, ttl := range ttls {
+		// relation types are never user input, see retentionTTLs
+		query := fmt.Sprintf(`
+			MATCH (r:Post)-[l:%s]->() WHERE r.created_at < $Before
+			WITH l LIMIT $BatchSize
+			DELETE l
+			RETURN count(*);
+		`, relation)
+		if relation == "FOLLOW" {
+			query = `
+				MATCH (u:User)-[l:FOLLOW]->(:User)
+				WHERE l.listed_at < u.contacts_updated_at AND u.contacts_updated_at < $Before
+				WITH l LIMIT $BatchSize
+				DELETE l
+				RETURN count(*);
+			`
+		}
+
+		deleted, err := s.deleteInBatches(ctx, query, conf.BatchSize, map[string]any{"Before": now.Add(-ttl).Unix()})
+		pruned[relation] = deleted
+		if err != nil {
+			return pruned, err
+		}
+		logger.Info("Pruned relations", "type", relation, "ttl", ttl, "count", pruned[relation])
+	}
+	return pruned, nil
+}
+
+// PrunePosts deletes posts older than the retention window with their
+// relations, then users left without any relation and not updated within
+// the window. Posts aren't archived before they're deleted. The number of
+// posts and users deleted is returned.
+func (s *Service) PrunePosts(ctx context.Context, now time.Time) (map[string]int64, error) {
+	conf := s.config.Retention
+	if conf.Posts == "" || !s.hasGraph() {
+		return map[string]int64{}, nil
+	}
+	window, err := time.ParseDuration(conf.Posts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention window of posts: %w", err)
+	}
+	if s.archiver != nil {
+		if hot := parseDurationOr(s.config.Archive.HotWindow, 0); window < hot {
+			logger.Warn("Posts are pruned before they're served from the archive", "retention", window, "hotWindow", hot)
+		}
+	}
+
+	before := map[string]any{"Before": now.Add(-window).Unix()}
+	pruned := map[string]int64{}
+	// each post is deleted with all its relations, hence smaller batches
+	pruned["Post"], err = s.deleteInBatches(ctx, `
+		MATCH (p:Post) WHERE p.created_at < $Before
+		WITH p LIMIT $BatchSize
+		DETACH DELETE p
+		RETURN count(*);
+	`, conf.PostBatchSize, before)
+	if err != nil {
+		return pruned, err
+	}
+
+	// users keep their lists and preferences until they're stale as well,
+	// opt-outs are kept forever
+	pruned["User"], err = s.deleteInBatches(ctx, `
+		MATCH (u:User) WHERE NOT (u)--() AND u.notify_opt_out IS NULL
+			AND all(t IN [u.profile_updated_at, u.contacts_updated_at, u.relays_updated_at, u.mutes_updated_at, u.pins_updated_at]
+				WHERE t IS NULL OR t < $Before)
+		WITH u LIMIT $BatchSize
+		DELETE u
+		RETURN count(*);
+	`, conf.BatchSize, before)
+	logger.Info("Pruned posts", "retention", window, "posts", pruned["Post"], "users", pruned["User"])
+	return pruned, err
+}
+
+// deleteInBatches runs a query deleting at most $BatchSize items and
+// returning how many it did, until it deletes less, so that no transaction
+// grows too large
+func (s *Service) deleteInBatches(ctx context.Context, query string, batchSize int, params map[string]any) (int64, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("invalid retention batch size %d", batchSize)
+	}
+	params["BatchSize"] = batchSize
+
+	var total int64
+	for {
+		deleted, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			result, err := tx.Run(ctx, query, params)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return record.Values[0].(int64), nil
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += deleted.(int64)
+		if deleted.(int64) < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
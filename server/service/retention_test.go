@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionTTLs(t *testing.T) {
+	ttls, err := retentionTTLs(types.RetentionConfig{Like: "24h", Zap: "720h", Follow: "0s"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"LIKE": 24 * time.Hour,
+		"ZAP":  720 * time.Hour,
+	}, ttls)
+
+	_, err = retentionTTLs(types.RetentionConfig{Reply: "a month"})
+	assert.Error(t, err)
+}
+
+func TestPrunePostsWindow(t *testing.T) {
+	s := newSQLiteService(t)
+	s.config.Retention.Posts = "a month"
+	s.repo = &neo4jRepository{s: s}
+	_, err := s.PrunePosts(context.Background(), time.Now())
+	assert.Error(t, err)
+
+	// posts are kept forever by default
+	s.config.Retention.Posts = ""
+	pruned, err := s.PrunePosts(context.Background(), time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, pruned)
+}
+
+func TestPruneBatchSize(t *testing.T) {
+	s := newSQLiteService(t)
+	s.config.Retention = types.RetentionConfig{Like: "24h"}
+
+	// a batch size that isn't positive would never finish
+	_, err := s.PruneRelations(context.Background(), time.Now())
+	assert.Error(t, err)
+
+	s.config.Retention = types.RetentionConfig{Posts: "720h", BatchSize: 10000}
+	s.repo = &neo4jRepository{s: s}
+	_, err = s.PrunePosts(context.Background(), time.Now())
+	assert.Error(t, err)
+}
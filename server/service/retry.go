@@ -0,0 +1,294 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// retryItem is an event that failed to be stored, due for its next attempt
+// at Next
+type retryItem struct {
+	Relay    string       `json:"relay"`
+	Event    *nostr.Event `json:"event"`
+	Attempts int          `json:"attempts"`
+	Next     time.Time    `json:"next"`
+	Error    string       `json:"error"`
+}
+
+// retryQueue retries events failing to be stored with exponential backoff.
+// The queue is kept in memory and saved to disk on every change, so queued
+// events survive restarts. Events are appended to the saved queue as they're
+// queued, before they're acknowledged. Events still failing after MaxAttempts are
+// appended to the dead-letter file.
+type retryQueue struct {
+	config     types.RetryConfig
+	file       string
+	deadLetter string
+	initial    time.Duration
+	max        time.Duration
+
+	mu    sync.Mutex
+	items []retryItem
+	dirty bool
+}
+
+func newRetryQueue(conf types.RetryConfig, root string) *retryQueue {
+	q := &retryQueue{
+		config:     conf,
+		file:       conf.File,
+		deadLetter: deadLetterFile(conf, root),
+		initial:    parseDurationOr(conf.InitialBackoff, time.Second),
+		max:        parseDurationOr(conf.MaxBackoff, 10*time.Minute),
+	}
+	if q.file == "" {
+		q.file = filepath.Join(root, "retry", "queue.jsonl")
+	}
+	return q
+}
+
+// deadLetterFile is where events that can't be stored end up
+func deadLetterFile(conf types.RetryConfig, root string) string {
+	if conf.DeadLetterFile != "" {
+		return conf.DeadLetterFile
+	}
+	return filepath.Join(root, "retry", "deadletter.jsonl")
+}
+
+// add queues a failed event, unless the queue is full or the event can't be
+// saved
+func (q *retryQueue) add(event *nostr.Event, relay string, cause error, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.config.QueueSize > 0 && len(q.items) >= q.config.QueueSize {
+		logger.Warn("Retry queue is full, event not retried", "id", event.ID)
+		return false
+	}
+	item := retryItem{Relay: relay, Event: event, Attempts: 1, Next: now.Add(q.initial), Error: cause.Error()}
+	if err := appendItem(q.file, item); err != nil {
+		logger.Error("Failed to save queued event, event not retried", "id", event.ID, "err", err)
+		return false
+	}
+	q.items = append(q.items, item)
+	metrics.NewGauge("retry/queued").Update(int64(len(q.items)))
+	return true
+}
+
+// ackOnRetry wraps ack so that failed events are queued for retries, and
+// acknowledged as processed once they're saved in the queue
+func (q *retryQueue) ackOnRetry(event *nostr.Event, relay string, ack func(error)) func(error) {
+	return func(err error) {
+		if err != nil && q.add(event, relay, err, time.Now()) {
+			err = nil
+		}
+		if ack != nil {
+			ack(err)
+		}
+	}
+}
+
+// due removes and returns the events due for their next attempt
+func (q *retryQueue) due(now time.Time) []retryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []retryItem
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if item.Next.After(now) {
+			kept = append(kept, item)
+		} else {
+			due = append(due, item)
+		}
+	}
+	q.items = kept
+	if len(due) > 0 {
+		q.dirty = true
+	}
+	return due
+}
+
+// failed requeues an event after a failed attempt with the backoff doubled,
+// or dead-letters it once it ran out of attempts
+func (q *retryQueue) failed(item retryItem, cause error, now time.Time) {
+	item.Error = cause.Error()
+	if item.Attempts >= q.config.MaxAttempts {
+		q.deadLetterItem(item)
+		return
+	}
+
+	backoff := q.initial << item.Attempts
+	if backoff > q.max || backoff <= 0 {
+		backoff = q.max
+	}
+	item.Attempts++
+	item.Next = now.Add(backoff)
+
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.dirty = true
+	q.mu.Unlock()
+}
+
+func (q *retryQueue) deadLetterItem(item retryItem) {
+	logger.Error("Event failed to be stored, moved to dead letters", "id", item.Event.ID, "attempts", item.Attempts, "err", item.Error)
+	metrics.NewCounter("retry/deadlettered").Inc(1)
+
+	if err := appendItem(q.deadLetter, item); err != nil {
+		logger.Error("Failed to write dead letter", "id", item.Event.ID, "err", err)
+	}
+}
+
+// appendItem appends the item to the file and syncs it to disk
+func appendItem(file string, item retryItem) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// save writes the queue to disk if it changed since last saved
+func (q *retryQueue) save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(q.file), 0755); err != nil {
+		return err
+	}
+	tmp := q.file + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, item := range q.items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			logger.Warn("Failed to encode queued event", "id", item.Event.ID, "err", err)
+			continue
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.file); err != nil {
+		return err
+	}
+	q.dirty = false
+	metrics.NewGauge("retry/queued").Update(int64(len(q.items)))
+	return nil
+}
+
+// load restores the queue saved by a previous run
+func (q *retryQueue) load() error {
+	file, err := os.Open(q.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var item retryItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil || item.Event == nil {
+			logger.Warn("Skip malformed queued event", "err", err)
+			continue
+		}
+		q.items = append(q.items, item)
+	}
+	return scanner.Err()
+}
+
+func (q *retryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// startRetrier retries queued events as they fall due. Attempts are
+// postponed without being counted while the database is unreachable, so
+// that an outage doesn't exhaust them.
+func (s *Service) startRetrier(ctx context.Context) {
+	if err := s.retries.load(); err != nil {
+		logger.Error("Failed to load retry queue", "err", err)
+	}
+	if n := s.retries.len(); n > 0 {
+		logger.Info("Resuming retries of failed events", "events", n)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.retryDue(ctx, now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *Service) retryDue(ctx context.Context, now time.Time) {
+	defer func() {
+		if err := s.retries.save(); err != nil {
+			logger.Error("Failed to save retry queue", "err", err)
+		}
+	}()
+
+	if s.retries.len() == 0 {
+		return
+	}
+	if err := s.repo.Ping(ctx); err != nil {
+		return
+	}
+
+	for _, item := range s.retries.due(now) {
+		if err := s.storeEventFromRelay(context.Background(), item.Event, item.Relay); err != nil {
+			logger.Warn("Retry of failed event failed", "id", item.Event.ID, "attempts", item.Attempts, "err", err)
+			s.retries.failed(item, err, now)
+			continue
+		}
+		logger.Debug("Stored event on retry", "id", item.Event.ID, "attempts", item.Attempts)
+	}
+}
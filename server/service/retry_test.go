@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryQueue(t *testing.T) {
+	dir := t.TempDir()
+	conf := types.RetryConfig{MaxAttempts: 3, InitialBackoff: "1s", MaxBackoff: "3s", QueueSize: 2}
+	q := newRetryQueue(conf, dir)
+	now := time.Unix(1000, 0)
+	event := &nostr.Event{ID: "a"}
+
+	// queued events are acknowledged as processed
+	var acked error = errors.New("not acked")
+	q.ackOnRetry(event, "wss://relay", func(err error) { acked = err })(errors.New("db down"))
+	assert.NoError(t, acked)
+	assert.True(t, q.add(&nostr.Event{ID: "b"}, "", errors.New("db down"), now))
+	assert.False(t, q.add(&nostr.Event{ID: "c"}, "", errors.New("db down"), now))
+
+	// queued events are saved before they're acknowledged
+	restored := newRetryQueue(conf, dir)
+	assert.NoError(t, restored.load())
+	assert.Equal(t, 2, restored.len())
+
+	// saved queues are restored
+	assert.NoError(t, q.save())
+	restored = newRetryQueue(conf, dir)
+	assert.NoError(t, restored.load())
+	assert.Equal(t, 2, restored.len())
+
+	q = newRetryQueue(conf, dir)
+	assert.True(t, q.add(event, "wss://relay", errors.New("db down"), now))
+	assert.Empty(t, q.due(now))
+
+	// backoff doubles up to the max
+	due := q.due(now.Add(time.Second))
+	assert.Len(t, due, 1)
+	q.failed(due[0], errors.New("still down"), now)
+	assert.Empty(t, q.due(now.Add(time.Second)))
+	due = q.due(now.Add(2 * time.Second))
+	assert.Len(t, due, 1)
+	assert.Equal(t, 2, due[0].Attempts)
+
+	q.failed(due[0], errors.New("still down"), now)
+	due = q.due(now.Add(3 * time.Second))
+	assert.Len(t, due, 1)
+
+	// out of attempts
+	q.failed(due[0], errors.New("malformed"), now)
+	assert.Equal(t, 0, q.len())
+	letters, err := os.ReadFile(q.deadLetter)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(letters), "\n"))
+	assert.Contains(t, string(letters), `"error":"malformed"`)
+}
+
+func TestRetryQueueUnsaved(t *testing.T) {
+	dir := t.TempDir()
+	blocked := dir + "/blocked"
+	assert.NoError(t, os.WriteFile(blocked, nil, 0644))
+	q := newRetryQueue(types.RetryConfig{MaxAttempts: 3, File: blocked + "/queue.jsonl"}, dir)
+
+	// events that can't be saved are left unacknowledged
+	var acked error
+	q.ackOnRetry(&nostr.Event{ID: "a"}, "wss://relay", func(err error) { acked = err })(errors.New("db down"))
+	assert.Error(t, acked)
+	assert.Equal(t, 0, q.len())
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const (
+	// lease keeping two instances from migrating the graph at once
+	schemaLease    = "schema"
+	schemaLeaseTTL = 10 * time.Minute
+)
+
+// schemaMigration changes the schema of the graph or the data stored in it.
+// Migrations are applied in order, each once, and the version reached is
+// stored on the :SchemaVersion node. Released migrations are never edited,
+// changes are appended as new ones. A migration interrupted before its
+// version is stored runs again, so it must be idempotent.
+type schemaMigration struct {
+	version     int64
+	description string
+	migrate     func(s *Service) error
+}
+
+var schemaMigrations = []schemaMigration{
+	{1, "create constraints and indexes", schemaStatements(
+		"CREATE CONSTRAINT post_id_uniq IF NOT EXISTS FOR (p:Post) REQUIRE p.id IS UNIQUE;",
+		"CREATE CONSTRAINT user_pk_uniq IF NOT EXISTS FOR (u:User) REQUIRE u.pubkey IS UNIQUE;",
+		"CREATE INDEX post_address IF NOT EXISTS FOR (p:Post) ON (p.address);",
+		"CREATE CONSTRAINT relay_url_uniq IF NOT EXISTS FOR (r:Relay) REQUIRE r.url IS UNIQUE;",
+		"CREATE CONSTRAINT event_id_uniq IF NOT EXISTS FOR (e:Event) REQUIRE e.id IS UNIQUE;",
+		"CREATE INDEX event_kind IF NOT EXISTS FOR (e:Event) ON (e.kind);",
+		// feeds, trending and cleanups select posts by time window
+		"CREATE RANGE INDEX post_created_at IF NOT EXISTS FOR (p:Post) ON (p.created_at);",
+		"CREATE RANGE INDEX delivered_at IF NOT EXISTS FOR ()-[d:DELIVERED]-() ON (d.at);",
+		"CREATE CONSTRAINT subscriber_pk_uniq IF NOT EXISTS FOR (s:Subscriber) REQUIRE s.pubkey IS UNIQUE;",
+		"CREATE CONSTRAINT lease_name_uniq IF NOT EXISTS FOR (l:Lease) REQUIRE l.name IS UNIQUE;",
+	)},
+	{2, "convert REPLY relations to REPLY_TO", (*Service).migrateReplies},
+	{3, "create checkpoint constraint", schemaStatements(
+		"CREATE CONSTRAINT checkpoint_name_uniq IF NOT EXISTS FOR (c:Checkpoint) REQUIRE c.name IS UNIQUE;",
+	)},
+	{4, "create receipt index", schemaStatements(
+		"CREATE INDEX receipt_subscriber_run IF NOT EXISTS FOR (r:Receipt) ON (r.subscriber, r.run);",
+	)},
+	{5, "create digest feedback indexes", schemaStatements(
+		"CREATE INDEX digest_feedback_note IF NOT EXISTS FOR (d:Digest) ON (d.feedback_note);",
+		"CREATE INDEX feedback_subscriber_run IF NOT EXISTS FOR (f:DigestFeedback) ON (f.subscriber, f.run);",
+	)},
+	{6, "create handled event constraint", schemaStatements(
+		"CREATE CONSTRAINT handled_event_id_uniq IF NOT EXISTS FOR (h:HandledEvent) REQUIRE h.id IS UNIQUE;",
+	)},
+	{7, "create tombstone index", schemaStatements(
+		"CREATE INDEX tombstone_ref_author IF NOT EXISTS FOR (t:Tombstone) ON (t.ref, t.author);",
+	)},
+	{8, "create post stored_at index", schemaStatements(
+		// materialized scores select engagement by the time it was stored
+		"CREATE RANGE INDEX post_stored_at IF NOT EXISTS FOR (p:Post) ON (p.stored_at);",
+	)},
+	{9, "create user contacts_updated_at index", schemaStatements(
+		// crawl expansion counts followers by recent contact lists
+		"CREATE RANGE INDEX user_contacts_updated_at IF NOT EXISTS FOR (u:User) ON (u.contacts_updated_at);",
+	)},
+	{10, "merge duplicate topics and create topic constraint", (*Service).migrateTopics},
+	{11, "create digest channel index", schemaStatements(
+		// catch-up looks up the last digest of the main channel
+		"CREATE INDEX digest_channel IF NOT EXISTS FOR (d:Digest) ON (d.channel);",
+	)},
+}
+
+// schemaStatements runs schema statements, e.g. creating indexes, in a
+// single transaction. They should be guarded by IF NOT EXISTS.
+func schemaStatements(statements ...string) func(s *Service) error {
+	return func(s *Service) error {
+		_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			for _, statement := range statements {
+				if _, err := tx.Run(ctx, statement, nil); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		return err
+	}
+}
+
+// SchemaVersion returns the version the graph is migrated to, 0 if it never
+// was
+func (s *Service) SchemaVersion() (int64, error) {
+	version, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, "MATCH (v:SchemaVersion) RETURN max(v.version);", nil)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if version, ok := record.Values[0].(int64); ok {
+			return version, nil
+		}
+		return int64(0), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version.(int64), nil
+}
+
+func (s *Service) setSchemaVersion(m schemaMigration, migratedAt time.Time) error {
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (v:SchemaVersion)
+			SET
+				v.version = $Version,
+				v.description = $Description,
+				v.migrated_at = $MigratedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Version":     m.version,
+				"Description": m.description,
+				"MigratedAt":  migratedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// migrateSchema applies the migrations newer than the version of the graph.
+// Instances starting meanwhile wait for it to be done.
+func (s *Service) migrateSchema(ctx context.Context) error {
+	owner := s.config.Handover.InstanceName()
+	if err := s.lockSchema(ctx, owner); err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.ReleaseLease(ctx, schemaLease, owner, time.Now()); err != nil {
+			logger.Warn("Failed to release schema lease", "err", err)
+		}
+	}()
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	latest := schemaMigrations[len(schemaMigrations)-1].version
+	if version > latest {
+		logger.Warn("Graph schema is newer than this version of nossence", "version", version, "latest", latest)
+	}
+
+	for _, m := range schemaMigrations {
+		if m.version <= version {
+			continue
+		}
+
+		// each migration may take up to the lease TTL
+		if err := s.lockSchema(ctx, owner); err != nil {
+			return err
+		}
+		logger.Info("Migrating graph schema", "version", m.version, "description", m.description)
+		if err := m.migrate(s); err != nil {
+			return fmt.Errorf("schema migration %d failed: %w", m.version, err)
+		}
+		if err := s.setSchemaVersion(m, time.Now()); err != nil {
+			return err
+		}
+		version = m.version
+	}
+
+	metrics.NewGauge("schema/version").Update(version)
+	logger.Info("Graph schema is up to date", "version", version)
+	return nil
+}
+
+// lockSchema takes or renews the schema lease, waiting for another instance
+// holding it
+func (s *Service) lockSchema(ctx context.Context, owner string) error {
+	for {
+		now := time.Now()
+		lease, err := s.AcquireLease(ctx, schemaLease, owner, time.Time{}, schemaLeaseTTL, now)
+		if err != nil {
+			return err
+		}
+		if lease.Owner == owner {
+			return nil
+		}
+		logger.Info("Waiting for another instance to migrate the graph schema", "holder", lease.Owner, "expiresAt", lease.ExpiresAt)
+		time.Sleep(5 * time.Second)
+	}
+}
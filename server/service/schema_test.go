@@ -0,0 +1,16 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaMigrationsOrdered(t *testing.T) {
+	// versions are stored, so they must start at 1 and never skip or repeat
+	for i, m := range schemaMigrations {
+		assert.Equal(t, int64(i+1), m.version)
+		assert.NotEmpty(t, m.description)
+		assert.NotNil(t, m.migrate)
+	}
+}
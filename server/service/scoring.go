@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/dyng/nosdaily/correlation"
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// configuredWeights are the affinity weights used until tuned ones are approved
+func configuredWeights(config types.ScoringConfig) types.ScoringWeights {
+	return types.ScoringWeights{
+		Similar: config.SimilarWeight,
+		Follow:  config.FollowWeight,
+		Default: config.DefaultWeight,
+	}
+}
+
+// scoredPost is a post ranked by the graph, without its raw event
+type scoredPost struct {
+	Id        string
+	Kind      int
+	Pubkey    string
+	CreatedAt time.Time
+	Score     float64
+}
+
+// Weights returns the scoring weights currently in use
+func (s *Service) Weights() types.ScoringWeights {
+	s.weightsMu.RLock()
+	defer s.weightsMu.RUnlock()
+	return s.weights
+}
+
+func (s *Service) setWeights(weights types.ScoringWeights) {
+	s.weightsMu.Lock()
+	defer s.weightsMu.Unlock()
+	s.weights = weights
+	// materialized scores are weighted, rescore them all
+	atomic.StoreInt32(&s.scoreState, scoresStale)
+}
+
+// baseReputation is the reputation of users outside the follow graph, who
+// UpdateReputation leaves without one: the share of PageRank a user gets
+// without any follower
+func baseReputation(conf types.ReputationConfig) float64 {
+	return 1 - conf.Damping
+}
+
+// relationWeight weighs the strongest relation l of user u to post p
+const relationWeight = `
+	WITH p, u, max(CASE type(l)
+		WHEN 'REPLY_TO' THEN $ReplyWeight
+		WHEN 'LIKE' THEN CASE WHEN l.polarity < 0 THEN -$DislikeWeight ELSE $LikeWeight END
+		WHEN 'REPOST' THEN $RepostWeight
+		ELSE $ZapWeight * (1 + $ZapAmountScale * log10(1 + coalesce(l.amount, 0)))
+			* CASE WHEN l.ring THEN $RingDiscount ELSE 1.0 END
+	END) AS weight
+	WITH p, u, weight * CASE
+		WHEN $Reputation THEN log(1 + coalesce(u.reputation, $BaseReputation)) / log(2)
+		ELSE 1.0
+	END AS weight
+`
+
+// pinWeight adds PinWeight for each user pinning post p, weighed by
+// reputation like engagement so that throwaway keys can't pin a post up.
+// Authors pinning their own posts don't count.
+const pinWeight = `
+	OPTIONAL MATCH (pinner:User)-[:PINS]->(p)
+	WHERE pinner.pubkey <> p.author
+	WITH p, score + sum(CASE
+		WHEN pinner IS NULL THEN 0.0
+		WHEN $Reputation THEN $PinWeight * log(1 + coalesce(pinner.reputation, $BaseReputation)) / log(2)
+		ELSE $PinWeight
+	END) AS score
+`
+
+// reportedPost tells if post p is reported by enough distinct users. Only
+// users with a reputation of at least MinReporterReputation count, so that
+// throwaway keys can't take posts down.
+const reportedPost = `
+	OPTIONAL MATCH (reporter:User)-[:CREATE]->(:Post)-[:REPORTED]->(p)
+	WHERE reporter.reputation >= $MinReputation
+	WITH p, score, $ReportThreshold > 0 AND count(DISTINCT reporter) >= $ReportThreshold AS reported
+`
+
+const scoreQuery = `
+	MATCH (p:Post) WHERE $Start < p.created_at < $End
+	WITH p WHERE p.deleted_at IS NULL
+		AND p.author <> $Pubkey
+		AND NOT EXISTS { MATCH (:Subscriber {pubkey: $Pubkey})-[:DELIVERED]->(p) }
+		AND ($Topic = '' OR EXISTS { MATCH (p)-[:TAGGED]->(:Topic {name: $Topic}) })
+	MATCH (u:User)-[:CREATE]->(r:Post)-[l:REPLY_TO|LIKE|REPOST|ZAP]->(p)
+` + relationWeight + `
+	OPTIONAL MATCH (:User {pubkey: $Pubkey})-[s:SIMILAR|FOLLOW]->(u)
+	WITH p, sum(weight * CASE
+		WHEN s:SIMILAR THEN s.score * $SimilarWeight
+		WHEN s:FOLLOW THEN $FollowWeight
+		ELSE $DefaultWeight
+	END) AS score
+` + pinWeight + `
+	WITH p, score * exp(-$RecencyDecay * ($End - p.created_at) / 3600.0) AS score
+` + reportedPost + `
+	WHERE NOT (reported AND $ReportPenalty <= 0)
+	WITH p, CASE WHEN reported THEN score * $ReportPenalty ELSE score END AS score
+	ORDER BY score DESC
+	WITH p.author AS author, collect([p, score]) AS ranked
+	UNWIND CASE WHEN $MaxPerAuthor > 0 THEN ranked[..$MaxPerAuthor] ELSE ranked END AS top
+	WITH top[0] AS p, top[1] AS score
+	ORDER BY score DESC LIMIT $Limit
+	RETURN p.id, p.kind, p.author, p.created_at, score;
+`
+
+func (s *Service) scoreParams(q types.FeedQuery) map[string]any {
+	conf := s.config.Scoring
+	weights := s.Weights()
+	return map[string]any{
+		"Start":           q.Start.Unix(),
+		"End":             q.End.Unix(),
+		"Pubkey":          q.Subscriber,
+		"Topic":           q.Topic,
+		"Limit":           q.Limit,
+		"MaxPerAuthor":    q.MaxPerAuthor,
+		"RingDiscount":    s.config.ZapRings.Discount,
+		"Reputation":      s.config.Reputation.Enabled,
+		"BaseReputation":  baseReputation(s.config.Reputation),
+		"ReplyWeight":     conf.ReplyWeight,
+		"LikeWeight":      conf.LikeWeight,
+		"DislikeWeight":   conf.DislikeWeight,
+		"PinWeight":       conf.PinWeight,
+		"RepostWeight":    conf.RepostWeight,
+		"ZapWeight":       conf.ZapWeight,
+		"ZapAmountScale":  conf.ZapAmountScale,
+		"RecencyDecay":    recencyDecay(conf),
+		"ReportThreshold": s.config.Moderation.ReportThreshold,
+		"ReportPenalty":   s.config.Moderation.ReportPenalty,
+		"MinReputation":   s.config.Moderation.MinReporterReputation,
+		"SimilarWeight":   weights.Similar,
+		"FollowWeight":    weights.Follow,
+		"DefaultWeight":   weights.Default,
+	}
+}
+
+// scorePosts ranks posts created within the query window by the users who
+// replied, liked, reposted or zapped them. Each user counts once with its strongest
+// relation to the post, scaled by its relation to the subscriber: similar
+// users by their similarity, followed users and everyone else by a constant.
+// Weights are read from the scoring config every time the query is built.
+//
+// Downvote-style reactions subtract DislikeWeight instead of adding
+// LikeWeight, and each other user pinning the post adds PinWeight, weighed
+// by reputation like engagement. Posts reported by ReportThreshold distinct
+// users of enough reputation are penalized, or excluded if ReportPenalty is 0. Zaps exchanged within a zap ring are
+// discounted. With reputation enabled, engagement is weighed by
+// log2(1 + reputation) of the user, so an average user counts as 1 and
+// throwaway keys close to 0. The subscriber's own posts and posts already
+// delivered to them are left out.
+// Only posts tagged with the topic are ranked if one is given, and if
+// MaxPerAuthor is positive, only the top MaxPerAuthor posts of each author.
+//
+// Feeds that aren't personalized are ranked by materialized scores if
+// enabled and the window is kept scored.
+func (s *Service) scorePosts(ctx context.Context, q types.FeedQuery) []scoredPost {
+	posts, err := s.repo.ScorePosts(ctx, q)
+	if err != nil {
+		correlation.Logger(ctx, logger).Error("Failed to get feed", "err", err)
+		return nil
+	}
+	return posts
+}
+
+func (r *neo4jRepository) ScorePosts(ctx context.Context, q types.FeedQuery) ([]scoredPost, error) {
+	query := scoreQuery
+	if r.s.usesMaterializedScores(q) {
+		query = materializedScoreQuery
+	}
+
+	posts, err := r.db.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, query, r.s.scoreParams(q))
+		if err != nil {
+			return nil, err
+		}
+
+		posts := []scoredPost{}
+		for result.Next(ctx) {
+			record := result.Record()
+			posts = append(posts, scoredPost{
+				Id:        record.Values[0].(string),
+				Kind:      int(record.Values[1].(int64)),
+				Pubkey:    record.Values[2].(string),
+				CreatedAt: time.Unix(record.Values[3].(int64), 0),
+				Score:     record.Values[4].(float64),
+			})
+		}
+		return posts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts.([]scoredPost), nil
+}
+
+// capPerAuthor keeps the top max entries of each author, entries merged from
+// different sources may exceed what the query allows
+func capPerAuthor(entries []types.FeedEntry, max int) []types.FeedEntry {
+	if max <= 0 {
+		return entries
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Score > entries[j].Score
+	})
+	counts := map[string]int{}
+	capped := entries[:0]
+	for _, e := range entries {
+		if counts[e.Pubkey] < max {
+			counts[e.Pubkey]++
+			capped = append(capped, e)
+		}
+	}
+	return capped
+}
+
+// recencyDecay returns the hourly decay constant, derived from the half-life
+// if one is configured
+func recencyDecay(conf types.ScoringConfig) float64 {
+	if conf.RecencyHalfLife == "" {
+		return conf.RecencyDecay
+	}
+
+	halfLife, err := time.ParseDuration(conf.RecencyHalfLife)
+	if err != nil || halfLife <= 0 {
+		logger.Error("Invalid recency half-life, fallback to decay constant", "halfLife", conf.RecencyHalfLife, "err", err)
+		return conf.RecencyDecay
+	}
+	return math.Ln2 / halfLife.Hours()
+}
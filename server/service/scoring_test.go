@@ -0,0 +1,55 @@
+package service
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecencyDecay(t *testing.T) {
+	assert.Equal(t, 0.1, recencyDecay(types.ScoringConfig{RecencyDecay: 0.1}))
+
+	decay := recencyDecay(types.ScoringConfig{RecencyDecay: 0.1, RecencyHalfLife: "6h"})
+	assert.InDelta(t, 0.5, math.Exp(-decay*6), 1e-9)
+
+	// a post liked 20 hours ago needs 4x the interactions of a fresh one at a
+	// 10h half-life
+	decay = recencyDecay(types.ScoringConfig{RecencyHalfLife: "10h"})
+	assert.InDelta(t, 0.25, math.Exp(-decay*20), 1e-9)
+
+	assert.Equal(t, 0.1, recencyDecay(types.ScoringConfig{RecencyDecay: 0.1, RecencyHalfLife: "bogus"}))
+}
+
+func TestFollowBoost(t *testing.T) {
+	conf := types.ScoringConfig{FollowBoost: 1, SecondHopBoost: 0.25}
+	g := &followGraph{
+		follows:   map[string]bool{"alice": true, "bob": true},
+		secondHop: map[string]bool{"carol": true},
+	}
+
+	assert.Equal(t, 1.0, followBoost(conf, g, "dave", []string{"erin"}))
+	assert.Equal(t, 2.0, followBoost(conf, g, "alice", nil))
+	assert.InDelta(t, 1+math.Log2(3), followBoost(conf, g, "alice", []string{"bob", "dave"}), 1e-9)
+	assert.Equal(t, 1.25, followBoost(conf, g, "carol", nil))
+	assert.Greater(t, followBoost(conf, g, "dave", []string{"alice"}), followBoost(conf, g, "dave", []string{"carol"}))
+}
+
+func TestCapPerAuthor(t *testing.T) {
+	entries := []types.FeedEntry{
+		{Id: "a1", Pubkey: "alice", Score: 10},
+		{Id: "b1", Pubkey: "bob", Score: 5},
+		{Id: "a2", Pubkey: "alice", Score: 9},
+		{Id: "a3", Pubkey: "alice", Score: 8},
+	}
+
+	capped := capPerAuthor(entries, 2)
+	ids := []string{}
+	for _, e := range capped {
+		ids = append(ids, e.Id)
+	}
+	assert.Equal(t, []string{"a1", "a2", "b1"}, ids)
+
+	assert.Len(t, capPerAuthor(capped, 0), 3)
+}
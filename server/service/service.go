@@ -3,19 +3,22 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/dyng/nosdaily/alert"
+	"github.com/dyng/nosdaily/archive"
+	"github.com/dyng/nosdaily/correlation"
 	"github.com/dyng/nosdaily/database"
 	"github.com/dyng/nosdaily/types"
-	algo "github.com/dyng/nossence-algo"
+	"github.com/dyng/nosdaily/wal"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/go-co-op/gocron"
 	"github.com/nbd-wtf/go-nostr"
-	decodepay "github.com/nbd-wtf/ln-decodepay"
 	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
 )
 
@@ -24,55 +27,332 @@ var logger = log.New("module", "service")
 type Service struct {
 	config    *types.Config
 	neo4j     *database.Neo4jDb
-	engine    *algo.Engine
+	repo      Repository
 	scheduler *gocron.Scheduler
+	archiver  *archive.Archiver
+	writer    *batchWriter
+	dedup     *dedupCache
+	// nil unless subscriber lookups are cached
+	subscribers *subscriberCache
+	priority    *priorityLane
+	wal         *wal.Log
+	retries     *retryQueue
+	keywords    []keywordFilter
+	// latest activity of users, flushed to subscribers
+	heartbeats *heartbeats
+	// nil unless digests ask for feedback
+	feedback *feedbackNotes
+	// held while an event from a relay is stored, see Drain
+	storing sync.RWMutex
+	drained bool
+
+	weightsMu sync.RWMutex
+	weights   types.ScoringWeights
+	tuning    *tuner
+	alerter   *alert.Alerter
+	dbDown    bool
+	// baseline query plans
+	profiles map[string]planStats
+	// recent samples of the feed score distribution
+	driftMu sync.Mutex
+	drift   []types.ScoreDistribution
+	// whether materialized scores cover the window with current weights
+	scoreState  int32
+	publicStats publicStatsCache
 }
 
 type IService interface {
-	GetFeed(subscriberPub string, start time.Time, end time.Time, limit int) []types.FeedEntry
+	QueryFeed(ctx context.Context, query types.FeedQuery) []types.FeedEntry
+	GetFeedByTopic(ctx context.Context, topic string, start time.Time, end time.Time, limit int) []types.FeedEntry
+	// Deprecated: use QueryFeed, GetFeed is kept for v1 API consumers
+	GetFeed(ctx context.Context, subscriberPub string, start time.Time, end time.Time, limit, maxPerAuthor int) []types.FeedEntry
+	GetTrendingFeed(ctx context.Context, start time.Time, end time.Time, limit int) []types.FeedEntry
+	FilterReuse(output string, feed []types.FeedEntry) []types.FeedEntry
 	ListSubscribers(ctx context.Context, limit, skip int) ([]types.Subscriber, error)
-	GetSubscriber(pubkey string) *types.Subscriber
-	CreateSubscriber(pubkey, channelSK string, subscribedAt time.Time) error
-	DeleteSubscriber(pubkey string, unsubscribedAt time.Time) error
-	RestoreSubscriber(pubkey string, subscribedAt time.Time) (bool, error)
+	ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error)
+	GetSubscriber(ctx context.Context, pubkey string) *types.Subscriber
+	GetProfile(ctx context.Context, pubkey string) (*types.Profile, error)
+	GetReadRelays(ctx context.Context, pubkey string) ([]string, error)
+	PruneRelations(ctx context.Context, now time.Time) (map[string]int64, error)
+	PrunePosts(ctx context.Context, now time.Time) (map[string]int64, error)
+	BackfillRawEvents(ctx context.Context, kinds []int) (*types.BackfillReport, error)
+	GetRawEvent(ctx context.Context, id string) (string, error)
+	CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error
+	SetChannelSecret(ctx context.Context, pubkey, channelSK string) error
+	DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error
+	RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) (bool, error)
+	IsNotificationOptedOut(ctx context.Context, pubkey string) (bool, error)
+	SetNotificationOptOut(ctx context.Context, pubkey string, optOut bool) error
+	SetPrivateDigest(ctx context.Context, pubkey string, private bool) error
+	SetChannelBranding(ctx context.Context, pubkey, name, picture string) error
+	RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error
+	HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error
+	GrantTier(ctx context.Context, pubkey, tier string, expiresAt *time.Time) error
+	MarkHandled(ctx context.Context, id string) (bool, error)
+	MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error
+	FollowsChannel(ctx context.Context, pubkey, channelPub string) (bool, error)
+	MarkReminded(ctx context.Context, pubkey string, remindedAt time.Time) error
+	QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error
+	MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error
+	GetPendingWelcomes(ctx context.Context) ([]string, error)
+	MarkSurveyed(ctx context.Context, pubkey string, surveyedAt time.Time) error
+	RecordSurveyResponse(ctx context.Context, pubkey string, score int, answeredAt time.Time) (bool, error)
+	RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error
+	GetRecap(ctx context.Context, pubkey string, since time.Time) (*types.Recap, error)
+	GetRisingAuthors(ctx context.Context, now time.Time, period time.Duration, minEngagement, limit int) ([]types.RisingAuthor, error)
+	GetFollowed(ctx context.Context, pubkey string, pubkeys []string) (map[string]bool, error)
+	SetInterests(ctx context.Context, pubkey string, topics []string, interested bool) error
+	SyncPreferences(ctx context.Context, pubkey string, prefs types.Preferences, updatedAt time.Time) (bool, error)
+	GetInterests(ctx context.Context, pubkey string) ([]types.Interest, error)
+	InferInterests(ctx context.Context, pubkey string) error
+	RecordLess(ctx context.Context, pubkey string, less types.LessFeedback) error
+	RecordDigest(ctx context.Context, digest types.DigestMeta) error
+	LastDigestAt(ctx context.Context, channel string) (*time.Time, error)
+	RecordReceipt(ctx context.Context, receipt types.DeliveryReceipt) error
+	ProposeWeights(ctx context.Context) (*types.WeightProposal, error)
+	ComputeLeaderboard(ctx context.Context, now time.Time) (*types.Leaderboard, error)
+	UpdateChurnRisk(ctx context.Context, now time.Time) (int, error)
+	MarkReengaged(ctx context.Context, pubkey string, reengagedAt time.Time) error
+	AcquireLease(ctx context.Context, name, owner string, cursor time.Time, ttl time.Duration, now time.Time) (*types.Lease, error)
+	ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error
+	GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error)
+	SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error
 }
 
 func NewService(config *types.Config, neo4j *database.Neo4jDb) *Service {
-	return &Service{
+	s := &Service{
 		config:    config,
 		neo4j:     neo4j,
 		scheduler: gocron.NewScheduler(time.UTC),
+		weights:   configuredWeights(config.Scoring),
+		tuning:    newTuner(config.Tuning),
+		profiles:  map[string]planStats{},
+		keywords:  compileKeywordFilters(config.Moderation.Keywords),
 	}
-}
 
-func (s *Service) Init() error {
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
-		if _, err := tx.Run(ctx, "CREATE CONSTRAINT post_id_uniq IF NOT EXISTS FOR (p:Post) REQUIRE p.id IS UNIQUE;", nil); err != nil {
-			return nil, err
+	if config.Database.Driver == types.DriverSQLite {
+		s.repo = newSQLiteRepository(s)
+	} else {
+		s.repo = &neo4jRepository{s: s, db: neo4j}
+	}
+
+	if config.Archive.Enabled {
+		s.archiver = archive.NewArchiver(config, archive.NewStore(config))
+	}
+
+	if config.WAL.Enabled {
+		dir := config.WAL.Dir
+		if dir == "" {
+			dir = path.Join(config.Objects.Root, "wal")
 		}
-		if _, err := tx.Run(ctx, "CREATE CONSTRAINT user_pk_uniq IF NOT EXISTS FOR (u:User) REQUIRE u.pubkey IS UNIQUE;", nil); err != nil {
-			return nil, err
+		walLog, err := wal.Open(dir, config.WAL.SegmentSize)
+		if err != nil {
+			logger.Crit("Failed to open write-ahead log", "dir", dir, "err", err)
 		}
-		return nil, nil
-	})
+		walLog.SetDeadLetter(config.Retry.MaxAttempts, s.deadLetterWAL)
+		s.wal = walLog
+	}
+
+	if config.Retry.Enabled {
+		s.retries = newRetryQueue(config.Retry, config.Objects.Root)
+	}
+
+	// batches and the priority lane write to or read from the graph
+	if config.Writer.Enabled && s.hasGraph() {
+		s.writer = newBatchWriter(config.Writer, s.writeBatch)
+	}
 
-	// init algo engine
-	s.engine = algo.NewEngine(s.neo4j.GetDriver())
+	if config.Dedup.Enabled && config.Dedup.Size > 0 {
+		s.dedup = newDedupCache(config.Dedup.Size)
+	}
+
+	if config.Subscribers.Enabled && config.Subscribers.Size > 0 {
+		s.subscribers = newSubscriberCache(config.Subscribers.Size, parseDurationOr(config.Subscribers.TTL, time.Minute))
+	}
+
+	if config.Priority.Enabled && s.hasGraph() {
+		s.priority = newPriorityLane(config.Priority, s.storeEventFromRelay)
+	}
+
+	if config.Churn.Enabled && s.hasGraph() {
+		s.heartbeats = newHeartbeats()
+	}
+
+	if config.Digest.Feedback.Enabled && s.hasGraph() {
+		s.feedback = newFeedbackNotes(parseDurationOr(config.Digest.Feedback.Window, 72*time.Hour))
+	}
+
+	return s
+}
+
+// SetAlerter sets where operator alerts raised by the service go
+func (s *Service) SetAlerter(alerter *alert.Alerter) {
+	s.alerter = alerter
+}
+
+// Init prepares the repository and starts background jobs, jobs
+// maintaining the graph only run on Neo4j
+func (s *Service) Init() error {
+	err := s.repo.Init()
 
 	// init cleanup task
 	s.scheduler.Every(1).Day().At("00:00").Do(s.CleanObjects)
 
+	// start archiver
+	if s.archiver != nil {
+		s.archiver.Start(context.Background())
+	}
+
+	// drain events left over in the write-ahead log
+	if s.wal != nil {
+		s.startWALReplayer(context.Background())
+	}
+
+	// retry events that failed to be stored
+	if s.retries != nil {
+		s.startRetrier(context.Background())
+	}
+
+	// start batch writer
+	if s.writer != nil {
+		s.writer.Start(context.Background())
+	}
+
+	// start priority lane for followed authors
+	if s.priority != nil {
+		s.startPriorityLane(context.Background())
+	}
+
+	if !s.hasGraph() {
+		return err
+	}
+
+	// record relays duplicates were seen on, the batch writer does otherwise
+	if s.dedup != nil && s.writer == nil {
+		s.startSeenOnFlusher(context.Background())
+	}
+
+	// rank users by the follow graph
+	if s.config.Reputation.Enabled {
+		s.startReputationUpdater(context.Background())
+	}
+
+	// profile authors by their content types and topics
+	if s.config.Authors.Enabled {
+		s.startAuthorProfiler(context.Background())
+	}
+
+	// flag zaps exchanged within rings
+	if s.config.ZapRings.Enabled {
+		s.startZapRingDetector(context.Background())
+	}
+
+	// match ratings of digests pushed before the restart
+	if s.feedback != nil {
+		if err := s.loadFeedbackNotes(); err != nil {
+			logger.Error("Failed to load digest feedback notes", "err", err)
+		}
+	}
+
+	// record when subscribers were last seen active
+	if s.heartbeats != nil {
+		s.startHeartbeatFlusher(context.Background())
+	}
+
+	// keep scores of recent posts up to date
+	if s.config.Scoring.Materialized {
+		s.startScoreMaterializer(context.Background())
+	}
+
+	// watch hot query plans
+	if s.config.Profiling.Enabled {
+		s.startQueryProfiler(context.Background())
+	}
+
+	// watch the feed score distribution
+	if s.config.ScoreDrift.Enabled {
+		s.startDriftMonitor(context.Background())
+	}
+
 	return err
 }
 
-func (s *Service) GetFeed(subscriberPub string, start time.Time, end time.Time, limit int) []types.FeedEntry {
-	posts := s.engine.GetFeed(subscriberPub, start, end, limit)
-	feed := make([]types.FeedEntry, 0, len(posts))
+// Close flushes pending batches and archive segments
+func (s *Service) Close() error {
+	if s.writer != nil {
+		s.writer.Flush()
+	}
+	if s.wal != nil {
+		s.wal.Rotate()
+	}
+	if s.retries != nil {
+		if err := s.retries.save(); err != nil {
+			logger.Error("Failed to save retry queue", "err", err)
+		}
+	}
+	if s.heartbeats != nil {
+		if err := s.flushHeartbeats(); err != nil {
+			logger.Error("Failed to flush heartbeats", "err", err)
+		}
+	}
+	if s.dedup != nil {
+		if err := s.flushSeenOn(context.Background()); err != nil {
+			logger.Error("Failed to record relays events were seen on", "err", err)
+		}
+	}
+	if s.archiver != nil {
+		return s.archiver.Flush(context.Background())
+	}
+	return nil
+}
+
+// GetFeed ranks posts created within (start, end). If maxPerAuthor is
+// positive, no author has more than maxPerAuthor posts in the result.
+//
+// Deprecated: use QueryFeed
+func (s *Service) GetFeed(ctx context.Context, subscriberPub string, start time.Time, end time.Time, limit, maxPerAuthor int) []types.FeedEntry {
+	return s.QueryFeed(ctx, types.FeedQuery{
+		Subscriber:   subscriberPub,
+		Start:        start,
+		End:          end,
+		Limit:        limit,
+		MaxPerAuthor: maxPerAuthor,
+	})
+}
+
+// QueryFeed ranks posts created within the query window for the subscriber.
+// Queries are cancelled once ctx is done.
+func (s *Service) QueryFeed(ctx context.Context, query types.FeedQuery) []types.FeedEntry {
+	subscriberPub, start, end := query.Subscriber, query.Start, query.End
+	limit, maxPerAuthor := query.Limit, query.MaxPerAuthor
+
+	// posts older than the hot window are only available in the archive
+	coldEnd, hotStart := s.splitWindow(start, end)
+	var cold []types.FeedEntry
+	if coldEnd.After(start) {
+		cold = s.dropSeen(ctx, subscriberPub, s.getColdFeed(ctx, start, coldEnd, limit))
+		if query.Topic != "" {
+			cold = filterTopic(cold, query.Topic)
+		}
+	}
+
+	var posts []scoredPost
+	if end.After(hotStart) {
+		// curator votes and interests rerank a larger candidate list
+		candidates := limit
+		if len(s.config.Curation.Curators) > 0 || subscriberPub != "" || s.balancesLanguages(subscriberPub) {
+			candidates = limit * curationCandidateFactor
+		}
+		hot := query
+		hot.Start, hot.Limit = hotStart, candidates
+		posts = s.scorePosts(ctx, hot)
+	}
+
+	feed := make([]types.FeedEntry, 0, len(posts)+len(cold))
 	for _, post := range posts {
-		raw, err := s.readObject(post.Id)
+		raw, err := s.readObject(post.Id, post.CreatedAt)
 		if err != nil {
-			log.Error("Failed to read object", "id", post.Id, "err", err)
+			correlation.Logger(ctx, log.Root()).Error("Failed to read object", "id", post.Id, "err", err)
 			continue
 		}
 
@@ -85,115 +365,224 @@ func (s *Service) GetFeed(subscriberPub string, start time.Time, end time.Time,
 			Raw:       raw,
 		})
 	}
-	return feed
+
+	// reranking by curators, interests and the follow graph needs the graph
+	if end.After(hotStart) && s.hasGraph() {
+		feed = s.applyCuratorVotes(ctx, feed, hotStart, end)
+		feed = s.applyInterests(ctx, subscriberPub, feed)
+		feed = s.applyFollowGraph(ctx, subscriberPub, feed)
+		feed = s.applyDislikes(ctx, subscriberPub, feed)
+		feed = s.applyAuthorProfiles(ctx, subscriberPub, feed)
+		feed = s.attachSeenOn(ctx, feed)
+	}
+
+	feed = applyQualityFloor(s.config.Scoring, append(feed, cold...))
+	feed = filterKeywords(s.keywords, feed)
+	feed = filterReuse(s.config.Licensing, query.Output, feed)
+	if s.hasGraph() {
+		feed = s.applyMutes(ctx, subscriberPub, feed)
+	}
+	feed = capPerAuthor(feed, maxPerAuthor)
+	if s.balancesLanguages(subscriberPub) {
+		return balanceLanguages(s.config.Digest.Languages, feed, limit)
+	}
+	return topEntries(feed, limit)
+}
+
+// language balancing only applies to the public digest
+func (s *Service) balancesLanguages(subscriberPub string) bool {
+	return subscriberPub == "" && s.config.Digest.Languages.Enabled
 }
 
+// StoreEvent stores an event, unless it was stored recently
 func (s *Service) StoreEvent(event *nostr.Event) error {
-	switch event.Kind {
-	case 1:
-		return s.StorePost(event)
-	case 6:
-		return s.StoreRepost(event)
-	case 7:
-		return s.StoreLike(event)
-	case 3:
-		return s.StoreContact(event)
-	case 9735:
-		return s.StoreZap(event)
-	default:
-		logger.Warn("Unsupported event kind", "kind", event.Kind)
-		return nil
+	if s.dedup != nil {
+		if !s.dedup.add(event.ID) {
+			return nil
+		}
+		err := s.archiveAndStore(context.Background(), event, s.storeEvent)
+		if err != nil {
+			s.dedup.forget(event.ID)
+		}
+		return err
 	}
+	return s.archiveAndStore(context.Background(), event, s.storeEvent)
 }
 
-func (s *Service) StorePost(event *nostr.Event) error {
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
+// archiveAndStore stores an event with store, archiving it before or after
+// as configured
+func (s *Service) archiveAndStore(ctx context.Context, event *nostr.Event, store func(context.Context, *nostr.Event) error) error {
+	if s.archiver == nil {
+		return store(ctx, event)
+	}
 
-		// create user & post
-		if err := s.saveUserAndPost(ctx, tx, event); err != nil {
-			return nil, err
+	if s.config.Archive.Stage == "after" {
+		if err := store(ctx, event); err != nil {
+			return err
 		}
+		return s.archiver.Append(ctx, event)
+	}
 
-		// create reply relation
-		refs := event.Tags.GetAll([]string{"e"})
-		if len(refs) > 0 {
-			ref := refs[0]
-			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:REPLY]->(r);",
-				map[string]any{
-					"Id":    event.ID,
-					"RefId": ref.Value(),
-				}); err != nil {
-				return nil, err
-			}
-		}
+	if err := s.archiver.Append(ctx, event); err != nil {
+		logger.Error("Failed to archive event", "id", event.ID, "err", err)
+	}
+	return store(ctx, event)
+}
 
-		return nil, nil
+// ReplayArchive stores archived events created within [from, to] again
+func (s *Service) ReplayArchive(ctx context.Context, from, to time.Time) (int, error) {
+	if s.archiver == nil {
+		return 0, fmt.Errorf("archive is not enabled")
+	}
+	return s.archiver.Replay(ctx, from, to, func(event *nostr.Event) error {
+		return s.storeEvent(ctx, event)
 	})
+}
 
-	return err
+func (s *Service) storeEvent(ctx context.Context, event *nostr.Event) error {
+	write, err := s.prepareStore([]*nostr.Event{event})
+	if err != nil || !write[0] {
+		return err
+	}
+	return s.writeEvent(ctx, event)
 }
 
-func (s *Service) StoreLike(event *nostr.Event) error {
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
+// prepareStore runs the steps every event goes through before it's written,
+// alone or in bulk, and tells which events are to be written: those kept by
+// the repository and not deleted by their author. The activity of their
+// authors is recorded.
+func (s *Service) prepareStore(events []*nostr.Event) ([]bool, error) {
+	write := make([]bool, len(events))
+	for i, event := range events {
+		// profiles, contacts, lists and the like only make sense in the graph
+		if !s.hasGraph() && !storedInSQLite(event.Kind) {
+			continue
+		}
+		s.recordHeartbeat(event)
+		write[i] = true
+	}
 
-		// create user & post
-		if err := s.saveUserAndPost(ctx, tx, event); err != nil {
-			return nil, err
+	deleted, err := s.deletedEvents(events)
+	if err != nil {
+		return nil, err
+	}
+	for i, event := range events {
+		if write[i] && deleted[event.ID] {
+			logger.Debug("Skip event deleted by its author", "id", event.ID)
+			write[i] = false
 		}
+	}
+	return write, nil
+}
 
-		// create like relation
-		refs := event.Tags.GetAll([]string{"e"})
-		if len(refs) > 0 {
-			ref := refs[0]
-			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:LIKE]->(r);",
-				map[string]any{
-					"Id":    event.ID,
-					"RefId": ref.Value(),
-				}); err != nil {
-				return nil, err
+// writeEvent writes an event prepared by prepareStore with the handler of
+// its kind
+func (s *Service) writeEvent(ctx context.Context, event *nostr.Event) error {
+	if store := s.storeFunc(ctx, event.Kind); store != nil {
+		// posts and interactions write their object along with the post
+		if s.config.Objects.AllKinds && !createsPost(event.Kind) {
+			if err := s.writeObject(event); err != nil {
+				log.Error("Failed to write object", "id", event.ID, "err", err)
+				return err
 			}
 		}
+		return store(event)
+	}
+	if s.config.RawEvents.Enabled {
+		return s.storeRawEvent(event)
+	}
+	logger.Warn("Unsupported event kind", "kind", event.Kind)
+	return nil
+}
 
-		return nil, nil
-	})
+// storeFunc returns the handler of an event kind, nil if it's unsupported
+func (s *Service) storeFunc(ctx context.Context, kind int) func(*nostr.Event) error {
+	switch kind {
+	case 1, articleKind:
+		return s.StorePost
+	case 6, 16:
+		return s.StoreRepost
+	case 7:
+		return s.StoreLike
+	case 0:
+		return s.StoreProfile
+	case 3:
+		return func(event *nostr.Event) error {
+			return s.StoreContact(ctx, event)
+		}
+	case relayListKind:
+		return s.StoreRelayList
+	case muteListKind, pinListKind, followSetKind, legacyListKind:
+		return s.StoreList
+	case 5:
+		return s.StoreDeletion
+	case reportKind:
+		return s.StoreReport
+	case 9735:
+		return s.StoreZap
+	case 1985:
+		return s.StoreLabel
+	default:
+		return nil
+	}
+}
 
-	return err
+func (s *Service) StorePost(event *nostr.Event) error {
+	return s.repo.StorePost(event)
 }
 
-func (s *Service) StoreRepost(event *nostr.Event) error {
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
+func (s *Service) StoreLike(event *nostr.Event) error {
+	if err := s.repo.StoreLike(event); err != nil {
+		return err
+	}
 
-		// create user & post
-		if err := s.saveUserAndPost(ctx, tx, event); err != nil {
-			return nil, err
+	// negative reactions of subscribers are "less like this" feedback
+	if isNegativeReaction(event.Content) && s.hasGraph() {
+		if err := s.recordDislikes(context.Background(), []*nostr.Event{event}); err != nil {
+			logger.Warn("Failed to record negative reaction", "id", event.ID, "err", err)
 		}
+	}
 
-		// create repost relation
-		refs := event.Tags.GetAll([]string{"e"})
-		if len(refs) > 0 {
-			ref := refs[0]
-			if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:REPOST]->(r);",
-				map[string]any{
-					"Id":    event.ID,
-					"RefId": ref.Value(),
-				}); err != nil {
-				return nil, err
-			}
+	return nil
+}
+
+// StoreRepost stores a repost (kind 6) or generic repost (kind 16). Reposted
+// notes and articles embedded in the content are stored as well, so that
+// reposts of posts missed by the crawler still count.
+func (s *Service) StoreRepost(event *nostr.Event) error {
+	if reposted := embeddedEvent(event); reposted != nil && (reposted.Kind == 1 || reposted.Kind == articleKind) {
+		if err := s.StorePost(reposted); err != nil {
+			logger.Warn("Failed to store reposted event", "id", reposted.ID, "err", err)
 		}
+	}
 
-		return nil, nil
-	})
+	return s.repo.StoreRepost(event)
+}
 
-	return err
+// embeddedEvent returns the reposted event embedded in a repost, if it's the
+// referenced one and properly signed
+func embeddedEvent(repost *nostr.Event) *nostr.Event {
+	ref := repost.Tags.GetFirst([]string{"e"})
+	if ref == nil || repost.Content == "" {
+		return nil
+	}
+
+	var ev nostr.Event
+	if err := json.Unmarshal([]byte(repost.Content), &ev); err != nil || ev.ID != ref.Value() {
+		return nil
+	}
+	if ok, _ := ev.CheckSignature(); !ok {
+		return nil
+	}
+	return &ev
 }
 
-func (s *Service) StoreContact(event *nostr.Event) error {
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
+func (s *Service) StoreZap(event *nostr.Event) error {
+	return s.repo.StoreZap(event)
+}
 
+func (s *Service) StoreContact(ctx context.Context, event *nostr.Event) error {
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
 		// delete old follow relations
 		if _, err := tx.Run(ctx, "match (u:User {pubkey: $Pubkey})-[r:FOLLOW]->() delete r;",
 			map[string]any{
@@ -202,13 +591,24 @@ func (s *Service) StoreContact(event *nostr.Event) error {
 			return nil, err
 		}
 
+		// follows are stamped with the contact list they come from, see
+		// PruneRelations
+		if _, err := tx.Run(ctx, "merge (u:User {pubkey: $Pubkey}) set u.contacts_updated_at = $UpdatedAt;",
+			map[string]any{
+				"Pubkey":    event.PubKey,
+				"UpdatedAt": event.CreatedAt.Unix(),
+			}); err != nil {
+			return nil, err
+		}
+
 		// create new follow relations
 		tags := event.Tags.GetAll([]string{"p"})
 		for _, pTag := range tags {
-			if _, err := tx.Run(ctx, "merge (u:User {pubkey: $Pubkey}) merge (p:User {pubkey: $P}) merge (u)-[:FOLLOW]->(p);",
+			if _, err := tx.Run(ctx, "merge (u:User {pubkey: $Pubkey}) merge (p:User {pubkey: $P}) merge (u)-[l:FOLLOW]->(p) set l.listed_at = $UpdatedAt;",
 				map[string]any{
-					"Pubkey": event.PubKey,
-					"P":      pTag.Value(),
+					"Pubkey":    event.PubKey,
+					"P":         pTag.Value(),
+					"UpdatedAt": event.CreatedAt.Unix(),
 				}); err != nil {
 				return nil, err
 			}
@@ -220,46 +620,6 @@ func (s *Service) StoreContact(event *nostr.Event) error {
 	return err
 }
 
-func (s *Service) StoreZap(event *nostr.Event) error {
-	// decode zap amount
-	bolt11 := event.Tags.GetLast([]string{"bolt11"})
-	invoice, err := decodepay.Decodepay(bolt11.Value())
-	if err != nil {
-		return err
-	}
-	amount := invoice.MSatoshi / 1000
-
-	_, err = s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
-
-		// exit if not a zap to a post
-		refs := event.Tags.GetAll([]string{"e"})
-		if len(refs) == 0 {
-			return nil, nil
-		}
-
-		// create user & post
-		if err := s.saveUserAndPost(ctx, tx, event); err != nil {
-			return nil, err
-		}
-
-		// create zap relation
-		ref := refs[0]
-		if _, err := tx.Run(ctx, "match (p:Post), (r:Post) where p.id = $Id and r.id = $RefId merge (p)-[:ZAP {amount: $Amount}]->(r);",
-			map[string]any{
-				"Id":     event.ID,
-				"RefId":  ref.Value(),
-				"Amount": amount,
-			}); err != nil {
-			return nil, err
-		}
-
-		return nil, nil
-	})
-
-	return err
-}
-
 func (s *Service) saveUserAndPost(ctx context.Context, tx neo4j.ManagedTransaction, event *nostr.Event) error {
 	if _, err := tx.Run(ctx, "merge (u:User {pubkey: $Pubkey});",
 		map[string]any{
@@ -274,12 +634,17 @@ func (s *Service) saveUserAndPost(ctx context.Context, tx neo4j.ManagedTransacti
 		return err
 	}
 
-	if _, err := tx.Run(ctx, "merge (p:Post {id: $Id, kind: $Kind, author: $Author, created_at: $CreatedAt});",
+	if event.Kind == articleKind {
+		if err := s.saveArticle(ctx, tx, event); err != nil {
+			return err
+		}
+	} else if _, err := tx.Run(ctx, "merge (p:Post {id: $Id}) on create set p.kind = $Kind, p.author = $Author, p.created_at = $CreatedAt, p.content_type = $ContentType, p.stored_at = timestamp() / 1000;",
 		map[string]any{
-			"Id":        event.ID,
-			"Kind":      event.Kind,
-			"Author":    event.PubKey,
-			"CreatedAt": event.CreatedAt.Unix(),
+			"Id":          event.ID,
+			"Kind":        event.Kind,
+			"Author":      event.PubKey,
+			"CreatedAt":   event.CreatedAt.Unix(),
+			"ContentType": contentTypeParam(event),
 		}); err != nil {
 		return err
 	}
@@ -292,22 +657,57 @@ func (s *Service) saveUserAndPost(ctx context.Context, tx neo4j.ManagedTransacti
 		return err
 	}
 
+	if event.Kind == 1 || event.Kind == articleKind {
+		if topics := postTopics(event); len(topics) > 0 {
+			if _, err := tx.Run(ctx, "match (p:Post {id: $Id}) unwind $Topics as topic merge (t:Topic {name: topic}) merge (p)-[:TAGGED]->(t);",
+				map[string]any{
+					"Id":     event.ID,
+					"Topics": topics,
+				}); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
+// CleanObjects deletes objects older than 7 days. Only the shard directories
+// of objects are cleaned, the archive, the write-ahead log, the retry queue,
+// the database and other state kept under Objects.Root are left alone.
 func (s *Service) CleanObjects() {
-	// delete files older than 7 days
-	filepath.Walk(s.config.Objects.Root, func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
-			return nil
+	root := path.Join(s.config.Objects.Root, "objects")
+	shards, err := os.ReadDir(root)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error("Failed to list objects", "dir", root, "err", err)
 		}
+		return
+	}
 
-		if time.Since(info.ModTime()) > 7*24*time.Hour {
-			_ = os.Remove(path)
+	for _, shard := range shards {
+		// shards are named after the first 3 characters of event ids
+		if !shard.IsDir() || len(shard.Name()) != 3 {
+			continue
 		}
-
-		return nil
-	})
+		dir := path.Join(root, shard.Name())
+		objects, err := os.ReadDir(dir)
+		if err != nil {
+			logger.Warn("Failed to list objects", "dir", dir, "err", err)
+			continue
+		}
+		for _, object := range objects {
+			info, err := object.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if time.Since(info.ModTime()) > 7*24*time.Hour {
+				if err := os.Remove(path.Join(dir, object.Name())); err != nil {
+					logger.Warn("Failed to delete object", "name", object.Name(), "err", err)
+				}
+			}
+		}
+	}
 }
 
 func (s *Service) writeObject(event *nostr.Event) error {
@@ -315,19 +715,48 @@ func (s *Service) writeObject(event *nostr.Event) error {
 	if err != nil {
 		return err
 	}
+	if s.config.Objects.Compress {
+		if raw, err = compressObject(raw); err != nil {
+			return err
+		}
+	}
 
 	path, dir := s.objPath(event.ID)
 	os.MkdirAll(dir, 0755)
 	return os.WriteFile(path, raw, 0644)
 }
 
-func (s *Service) readObject(id string) (string, error) {
+// readObject reads the raw event from local objects, falling back to the
+// archive for objects that have been cleaned up
+func (s *Service) readObject(id string, createdAt time.Time) (string, error) {
+	raw, err := s.readLocalObject(id)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return raw, err
+	}
+	if s.archiver == nil {
+		return "", err
+	}
+
+	ev, archiveErr := s.archiver.Find(context.Background(), id, createdAt)
+	if archiveErr != nil {
+		return "", err
+	}
+	archived, err := ev.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return string(archived), nil
+}
+
+// readLocalObject reads the raw event from local objects only
+func (s *Service) readLocalObject(id string) (string, error) {
 	file, _ := s.objPath(id)
 	bytes, err := os.ReadFile(file)
 	if err != nil {
 		return "", err
 	}
-	return string(bytes), nil
+	bytes, err = decompressObject(bytes)
+	return string(bytes), err
 }
 
 func (s *Service) objPath(id string) (file string, dir string) {
@@ -338,96 +767,142 @@ func (s *Service) objPath(id string) (file string, dir string) {
 	return
 }
 
-func (s *Service) CreateSubscriber(pubkey, channelSK string, subscribedAt time.Time) error {
+func (s *Service) CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
 	logger.Debug("Create subscriber", "pubkey", pubkey)
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		query := `
-			MERGE (s:Subscriber {pubkey: $Pubkey}) ON CREATE
-			SET
-				s.channel_secret = $ChannelSecret,
-				s.subscribed_at = $SubscribedAt,
-				s.unsubscribed_at = null;
-		`
-		_, err := tx.Run(context.Background(), query,
-			map[string]any{
-				"Pubkey":        pubkey,
-				"ChannelSecret": channelSK,
-				"SubscribedAt":  subscribedAt.Unix(),
-			})
-		return nil, err
-	})
-	return err
+	return s.repo.CreateSubscriber(ctx, pubkey, channelSK, subscribedAt)
+}
+
+// SetChannelSecret replaces the key of the subscriber's channel
+func (s *Service) SetChannelSecret(ctx context.Context, pubkey, channelSK string) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.SetChannelSecret(ctx, pubkey, channelSK)
 }
 
 func (s *Service) ListSubscribers(ctx context.Context, limit, skip int) ([]types.Subscriber, error) {
-	subscribers, err := s.neo4j.ExecuteRead(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
+	return s.repo.ListSubscribers(ctx, limit, skip)
+}
 
-		query := `
-		  MATCH (s:Subscriber)
-			RETURN s
-			ORDER BY s.pubkey
-			SKIP $Skip
-			LIMIT $Limit;
-		`
-		result, err := tx.Run(ctx, query,
-			map[string]any{
-				"Limit": limit,
-				"Skip":  skip,
-			})
-		if err != nil {
-			return nil, err
-		}
+// ListSubscribersAfter returns a page of subscribers ordered by pubkey,
+// starting after the given one. Unlike ListSubscribers, pages aren't shifted
+// by subscribers created or deleted meanwhile.
+func (s *Service) ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error) {
+	return s.repo.ListSubscribersAfter(ctx, after, limit)
+}
 
-		var subscribers []types.Subscriber
+func (s *Service) GetSubscriber(ctx context.Context, pubkey string) *types.Subscriber {
+	if subscriber, ok := s.subscribers.get(pubkey, time.Now()); ok {
+		return subscriber
+	}
+	subscriber, err := s.repo.GetSubscriber(ctx, pubkey)
+	if err != nil {
+		logger.Error("Failed to get subscriber", "err", err)
+		return nil
+	}
+	s.subscribers.put(subscriber, time.Now())
+	return subscriber
+}
 
-		for result.Next(ctx) {
-			record := result.Record()
+func (s *Service) DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	logger.Debug("Deleting subscriber", "pubkey", pubkey)
+	return s.repo.DeleteSubscriber(ctx, pubkey, unsubscribedAt)
+}
 
-			rawItemNode, found := record.Get("s")
-			if !found {
-				return nil, fmt.Errorf("no s field")
+func (s *Service) RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) (bool, error) {
+	defer s.subscribers.invalidate(pubkey)
+	logger.Debug("Restore subscriber", "pubkey", pubkey)
+
+	subscriber := s.GetSubscriber(ctx, pubkey)
+	// if unsubscribed_at is null, it means that the subscriber is still subscribed
+	if subscriber.UnsubscribedAt == nil {
+		return false, nil
+	}
+
+	if err := s.repo.RestoreSubscriber(ctx, pubkey, subscribedAt); err != nil {
+		return false, err
+	}
+
+	// if the restoring succeeded, return true
+	return true, nil
+}
+
+func subscriberFromProps(props map[string]any) types.Subscriber {
+	return types.Subscriber{
+		Pubkey:        props["pubkey"].(string),
+		ChannelSecret: props["channel_secret"].(string),
+		SubscribedAt: func() *time.Time {
+			t := time.Unix(props["subscribed_at"].(int64), 0)
+			return &t
+		}(),
+		UnsubscribedAt: optionalTime(props["unsubscribed_at"]),
+		Tier: func() string {
+			if v, ok := props["tier"].(string); ok {
+				return v
 			}
-			itemNode := rawItemNode.(neo4j.Node)
-			props := itemNode.Props
-
-			subscriber := types.Subscriber{
-				Pubkey:        props["pubkey"].(string),
-				ChannelSecret: props["channel_secret"].(string),
-				SubscribedAt: func() *time.Time {
-					t := time.Unix(props["subscribed_at"].(int64), 0)
-					return &t
-				}(),
-				UnsubscribedAt: func() *time.Time {
-					if v, ok := props["unsubscribed_at"].(int64); ok {
-						t := time.Unix(v, 0)
-						return &t
-					}
-
-					return nil
-				}(),
+
+			return types.TierFree
+		}(),
+		TierExpiresAt: optionalTime(props["tier_expires_at"]),
+		LastPushedAt:  optionalTime(props["last_pushed_at"]),
+		FollowReminders: func() int {
+			if v, ok := props["follow_reminders"].(int64); ok {
+				return int(v)
 			}
 
-			subscribers = append(subscribers, subscriber)
-		}
+			return 0
+		}(),
+		LastRemindedAt: optionalTime(props["last_reminded_at"]),
+		LastSurveyedAt: optionalTime(props["last_surveyed_at"]),
+		PrivateDigest: func() bool {
+			v, _ := props["private_digest"].(bool)
+			return v
+		}(),
+		ChannelName: func() string {
+			v, _ := props["channel_name"].(string)
+			return v
+		}(),
+		ChannelPicture: func() string {
+			v, _ := props["channel_picture"].(string)
+			return v
+		}(),
+		LastSeenAt: optionalTime(props["last_seen_at"]),
+		ChurnRisk: func() float64 {
+			v, _ := props["churn_risk"].(float64)
+			return v
+		}(),
+		LastReengagedAt: optionalTime(props["last_reengaged_at"]),
+		ChannelPub: func() string {
+			v, _ := props["channel_pub"].(string)
+			return v
+		}(),
+		ClaimRequestedAt: optionalTime(props["claim_requested_at"]),
+		HandedOverAt:     optionalTime(props["handed_over_at"]),
+		ShardKey: func() uint64 {
+			if v, ok := props["shard_key"].(int64); ok {
+				return uint64(v)
+			}
 
-		return subscribers, nil
-	})
+			// subscribers created before sharding
+			return types.ShardKey(props["pubkey"].(string))
+		}(),
+	}
+}
 
-	if err != nil {
-		return nil, err
+func optionalTime(v any) *time.Time {
+	if v, ok := v.(int64); ok {
+		t := time.Unix(v, 0)
+		return &t
 	}
 
-	return subscribers.([]types.Subscriber), nil
+	return nil
 }
 
-func (s *Service) GetSubscriber(pubkey string) *types.Subscriber {
-	subscriber, err := s.neo4j.ExecuteRead(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
-
+func (s *Service) IsNotificationOptedOut(ctx context.Context, pubkey string) (bool, error) {
+	optedOut, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
 		query := `
-			MATCH (s:Subscriber {pubkey: $Pubkey})
-			RETURN s;
+			MATCH (u:User {pubkey: $Pubkey})
+			RETURN coalesce(u.notify_opt_out, false);
 		`
 		result, err := tx.Run(ctx, query,
 			map[string]any{
@@ -437,98 +912,30 @@ func (s *Service) GetSubscriber(pubkey string) *types.Subscriber {
 			return nil, err
 		}
 
-		record, err := result.Single(ctx)
-		if err != nil {
-			return nil, err
-		}
-
-		rawItemNode, found := record.Get("s")
-		if !found {
-			return nil, fmt.Errorf("no s field")
+		if result.Next(ctx) {
+			return result.Record().Values[0].(bool), nil
 		}
-		itemNode := rawItemNode.(neo4j.Node)
-		props := itemNode.Props
-
-		subscriber := types.Subscriber{
-			Pubkey:        props["pubkey"].(string),
-			ChannelSecret: props["channel_secret"].(string),
-			SubscribedAt: func() *time.Time {
-				t := time.Unix(props["subscribed_at"].(int64), 0)
-				return &t
-			}(),
-			UnsubscribedAt: func() *time.Time {
-				if v, ok := props["unsubscribed_at"].(int64); ok {
-					t := time.Unix(v, 0)
-					return &t
-				}
-
-				return nil
-			}(),
-		}
-
-		return subscriber, nil
+		return false, nil
 	})
-
 	if err != nil {
-		logger.Error("Failed to get subscriber", "err", err)
-		return nil
-	}
-
-	if result, ok := subscriber.(types.Subscriber); ok {
-		return &result
+		return false, err
 	}
-
-	return nil
+	return optedOut.(bool), nil
 }
 
-func (s *Service) DeleteSubscriber(pubkey string, unsubscribedAt time.Time) error {
-	logger.Debug("Deleting subscriber", "pubkey", pubkey)
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
+func (s *Service) SetNotificationOptOut(ctx context.Context, pubkey string, optOut bool) error {
+	logger.Debug("Set notification opt-out", "pubkey", pubkey, "optOut", optOut)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
 		query := `
-			MATCH (s:Subscriber {pubkey: $Pubkey})
-			SET
-				s.unsubscribed_at = $UnsubscribedAt;
+			MERGE (u:User {pubkey: $Pubkey})
+			SET u.notify_opt_out = $OptOut;
 		`
-		_, err := tx.Run(context.Background(), query,
+		_, err := tx.Run(ctx, query,
 			map[string]any{
-				"Pubkey":         pubkey,
-				"UnsubscribedAt": unsubscribedAt.Unix(),
+				"Pubkey": pubkey,
+				"OptOut": optOut,
 			})
 		return nil, err
 	})
 	return err
 }
-
-func (s *Service) RestoreSubscriber(pubkey string, subscribedAt time.Time) (bool, error) {
-	logger.Debug("Restore subscriber", "pubkey", pubkey)
-
-	subscriber := s.GetSubscriber(pubkey)
-	// if unsubscribed_at is null, it means that the subscriber is still subscribed
-	if subscriber.UnsubscribedAt == nil {
-		return false, nil
-	}
-
-	// remove unsubscribed_at timestamp and update subscribed_at timestamp
-	_, err := s.neo4j.ExecuteWrite(func(tx neo4j.ManagedTransaction) (any, error) {
-		query := `
-			MATCH (s:Subscriber {pubkey: $Pubkey})
-			SET
-				s.unsubscribed_at = null, 
-				s.subscribed_at = $SubscribedAt;
-		`
-		_, err := tx.Run(context.Background(), query,
-			map[string]any{
-				"Pubkey":       pubkey,
-				"SubscribedAt": subscribedAt.Unix(),
-			})
-
-		return nil, err
-	})
-
-	if err != nil {
-		return false, err
-	}
-
-	// if the restoring succeeded, return true
-	return true, err
-}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dyng/nosdaily/database"
 	"github.com/dyng/nosdaily/types"
@@ -97,9 +99,7 @@ func TestStoreZap(t *testing.T) {
 
 	// verify
 	assert.NoError(t, err)
-	amount, err := neo4jdb.ExecuteRead(func(tx neo4j.ManagedTransaction) (any, error) {
-		ctx := context.Background()
-
+	amount, err := neo4jdb.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
 		result, err := tx.Run(ctx, "MATCH (:Post)-[z:ZAP]->(:Post) RETURN z.amount", nil)
 		if err != nil {
 			return nil, err
@@ -115,6 +115,102 @@ func TestStoreZap(t *testing.T) {
 	assert.Equal(t, int64(1000), amount)
 }
 
+// storing the same event again must not duplicate the post or its relations
+func TestStorePostTwice(t *testing.T) {
+	setup()
+	defer teardown()
+
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	post := &nostr.Event{Kind: 1, PubKey: pub, CreatedAt: time.Now(), Content: "gm #nostr", Tags: nostr.Tags{}}
+	post.Sign(sk)
+
+	assert.NoError(t, service.StorePost(post))
+	assert.NoError(t, service.StorePost(post))
+
+	counts, err := neo4jdb.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post {id: $Id})
+			OPTIONAL MATCH (:User)-[c:CREATE]->(p)
+			OPTIONAL MATCH (p)-[t:TAGGED]->(:Topic)
+			RETURN count(DISTINCT p), count(DISTINCT c), count(DISTINCT t);
+		`
+		result, err := tx.Run(ctx, query, map[string]any{"Id": post.ID})
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return record.Values, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []any{int64(1), int64(1), int64(1)}, counts)
+}
+
+// BenchmarkPostWindow compares scoring the posts of an hour selected by the
+// created_at range index against a label scan, over at least a million posts.
+// It needs a local neo4j, run with -bench PostWindow.
+func BenchmarkPostWindow(b *testing.B) {
+	setup()
+	defer teardown()
+
+	const posts = 1_000_000
+	end := time.Now().Unix()
+	seedPosts(b, posts, end)
+
+	params := service.scoreParams(types.FeedQuery{Start: time.Unix(end-3600, 0), End: time.Unix(end, 0), Limit: 50})
+	for _, bench := range []struct{ name, query string }{
+		{"index", scoreQuery},
+		{"scan", strings.Replace(scoreQuery, "MATCH (p:Post) WHERE", "MATCH (p:Post) USING SCAN p:Post WHERE", 1)},
+	} {
+		query := bench.query
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := neo4jdb.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+					result, err := tx.Run(ctx, query, params)
+					if err != nil {
+						return nil, err
+					}
+					return result.Collect(ctx)
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// seedPosts creates benchmark posts spread over the 30 days before end, one
+// in ten liked by a benchmark user. Posts are merged by id, so that later runs
+// reuse them.
+func seedPosts(b *testing.B, n int, end int64) {
+	const batch = 10000
+	service.Init()
+	for i := 0; i < n; i += batch {
+		_, err := neo4jdb.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				UNWIND range($From, $To - 1) AS i
+				MERGE (p:Post {id: 'bench-' + toString(i)})
+				ON CREATE SET p.kind = 1, p.author = 'bench', p.created_at = $End - (i * 2592000 / $N)
+				WITH p, i WHERE i % 10 = 0
+				MERGE (u:User {pubkey: 'bench-user-' + toString(i % 1000)})
+				MERGE (r:Post {id: 'bench-like-' + toString(i)})
+				ON CREATE SET r.kind = 7, r.author = u.pubkey
+				MERGE (u)-[:CREATE]->(r)
+				MERGE (r)-[:LIKE]->(p);
+			`
+			_, err := tx.Run(ctx, query, map[string]any{"From": i, "To": i + batch, "End": end, "N": n})
+			return nil, err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func setup() {
 	if neo4jdb == nil {
 		// TODO: use testcontainer
@@ -138,3 +234,31 @@ func setup() {
 
 func teardown() {
 }
+
+func TestEmbeddedEvent(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pub, _ := nostr.GetPublicKey(sk)
+	note := nostr.Event{Kind: 1, PubKey: pub, Content: "gm", Tags: nostr.Tags{}}
+	note.Sign(sk)
+	raw, _ := note.MarshalJSON()
+
+	repost := &nostr.Event{Kind: 6, Content: string(raw), Tags: nostr.Tags{{"e", note.ID}, {"p", pub}}}
+	embedded := embeddedEvent(repost)
+	assert.NotNil(t, embedded)
+	assert.Equal(t, note.ID, embedded.ID)
+
+	// generic reposts of other kinds embed the event as well
+	generic := &nostr.Event{Kind: 16, Content: string(raw), Tags: nostr.Tags{{"e", note.ID}, {"k", "1"}}}
+	assert.NotNil(t, embeddedEvent(generic))
+
+	// content not matching the referenced event
+	other := &nostr.Event{Kind: 6, Content: string(raw), Tags: nostr.Tags{{"e", "other"}}}
+	assert.Nil(t, embeddedEvent(other))
+
+	// tampered content
+	note.Content = "gn"
+	tampered, _ := note.MarshalJSON()
+	assert.Nil(t, embeddedEvent(&nostr.Event{Kind: 6, Content: string(tampered), Tags: nostr.Tags{{"e", note.ID}}}))
+
+	assert.Nil(t, embeddedEvent(&nostr.Event{Kind: 6, Tags: nostr.Tags{{"e", note.ID}}}))
+}
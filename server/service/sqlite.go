@@ -0,0 +1,634 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema keeps interactions as rows pointing to the post they engage
+// with, named after the relations of the graph
+const sqliteSchema = `
+	CREATE TABLE IF NOT EXISTS posts (
+		id TEXT PRIMARY KEY,
+		kind INTEGER NOT NULL,
+		author TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		deleted_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS posts_created_at ON posts (created_at);
+	CREATE TABLE IF NOT EXISTS topics (
+		post_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		PRIMARY KEY (post_id, name)
+	);
+	CREATE TABLE IF NOT EXISTS interactions (
+		id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		author TEXT NOT NULL,
+		ref TEXT NOT NULL,
+		polarity INTEGER NOT NULL DEFAULT 1,
+		amount INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (id, type)
+	);
+	CREATE INDEX IF NOT EXISTS interactions_ref ON interactions (ref);
+	CREATE TABLE IF NOT EXISTS subscribers (
+		pubkey TEXT PRIMARY KEY,
+		channel_secret TEXT NOT NULL,
+		subscribed_at INTEGER NOT NULL,
+		unsubscribed_at INTEGER,
+		shard_key INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS deliveries (
+		pubkey TEXT NOT NULL,
+		post_id TEXT NOT NULL,
+		delivered_at INTEGER NOT NULL,
+		PRIMARY KEY (pubkey, post_id)
+	);
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		owner TEXT,
+		expires_at INTEGER NOT NULL,
+		cursor INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS handled_events (
+		id TEXT PRIMARY KEY,
+		handled_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS subscriber_state (
+		pubkey TEXT PRIMARY KEY,
+		welcome_queued_at INTEGER,
+		welcomed_at INTEGER,
+		last_pushed_at INTEGER
+	);
+	CREATE TABLE IF NOT EXISTS checkpoints (
+		name TEXT PRIMARY KEY,
+		end_at INTEGER NOT NULL,
+		window INTEGER NOT NULL,
+		offset INTEGER NOT NULL,
+		done INTEGER NOT NULL
+	);
+`
+
+// sqliteColumns are added to tables created by earlier versions
+var sqliteColumns = []struct{ table, column, definition string }{
+	{"checkpoints", "after_pubkey", "TEXT NOT NULL DEFAULT ''"},
+	{"subscribers", "channel_pub", "TEXT NOT NULL DEFAULT ''"},
+	{"subscribers", "claim_requested_at", "INTEGER"},
+	{"subscribers", "handed_over_at", "INTEGER"},
+}
+
+// sqliteRepository is an embedded store for small deployments. It keeps
+// posts, replies, reactions, reposts, zaps and subscriptions, which is
+// enough to serve feeds without a Neo4j cluster.
+type sqliteRepository struct {
+	s  *Service
+	db *sql.DB
+}
+
+func newSQLiteRepository(s *Service) *sqliteRepository {
+	return &sqliteRepository{s: s}
+}
+
+// storedInSQLite tells if events of a kind are kept by the sqlite repository
+func storedInSQLite(kind int) bool {
+	switch kind {
+	case 1, articleKind, 6, 16, 7, 9735:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *sqliteRepository) Init() error {
+	file := r.s.config.Database.Path
+	if file == "" {
+		file = path.Join(r.s.config.Objects.Root, "nossence.db")
+	}
+
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return err
+	}
+	// sqlite allows a single writer, queue writes instead of failing them
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return err
+	}
+	for _, c := range sqliteColumns {
+		if err := addColumn(db, c.table, c.column, c.definition); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	r.db = db
+	return nil
+}
+
+// addColumn adds a column to a table unless it has it already
+func addColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?);", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
+}
+
+func (r *sqliteRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r *sqliteRepository) savePost(tx *sql.Tx, event *nostr.Event) error {
+	if err := r.s.writeObject(event); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO posts (id, kind, author, created_at) VALUES (?, ?, ?, ?);",
+		event.ID, event.Kind, event.PubKey, event.CreatedAt.Unix()); err != nil {
+		return err
+	}
+	for _, topic := range postTopics(event) {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO topics (post_id, name) VALUES (?, ?);", event.ID, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveInteraction links an event to the post it engages with
+func (r *sqliteRepository) saveInteraction(tx *sql.Tx, event *nostr.Event, relation, ref string, polarity int, amount int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO interactions (id, type, author, ref, polarity, amount) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id, type) DO UPDATE SET polarity = excluded.polarity;
+	`, event.ID, relation, event.PubKey, ref, polarity, amount)
+	return err
+}
+
+func (r *sqliteRepository) write(ctx context.Context, work func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := work(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqliteRepository) StorePost(event *nostr.Event) error {
+	return r.write(context.Background(), func(tx *sql.Tx) error {
+		if err := r.savePost(tx, event); err != nil {
+			return err
+		}
+
+		// articles only mention other posts
+		if event.Kind == articleKind {
+			return nil
+		}
+		if _, parent := threadRefs(event); parent != "" {
+			return r.saveInteraction(tx, event, "REPLY_TO", parent, 1, 0)
+		}
+		return nil
+	})
+}
+
+func (r *sqliteRepository) StoreLike(event *nostr.Event) error {
+	ref := event.Tags.GetFirst([]string{"e"})
+	if ref == nil {
+		return nil
+	}
+
+	polarity, _ := parseReaction(event.Content)
+	return r.write(context.Background(), func(tx *sql.Tx) error {
+		return r.saveInteraction(tx, event, "LIKE", ref.Value(), polarity, 0)
+	})
+}
+
+func (r *sqliteRepository) StoreRepost(event *nostr.Event) error {
+	ref := event.Tags.GetFirst([]string{"e"})
+	if ref == nil {
+		return nil
+	}
+
+	return r.write(context.Background(), func(tx *sql.Tx) error {
+		return r.saveInteraction(tx, event, "REPOST", ref.Value(), 1, 0)
+	})
+}
+
+func (r *sqliteRepository) StoreZap(event *nostr.Event) error {
+	receipt, err := ParseZapReceipt(event)
+	if err != nil {
+		return err
+	}
+
+	// exit if not a zap to a post
+	ref := event.Tags.GetFirst([]string{"e"})
+	if ref == nil {
+		return nil
+	}
+
+	return r.write(context.Background(), func(tx *sql.Tx) error {
+		return r.saveInteraction(tx, event, "ZAP", ref.Value(), 1, receipt.Amount)
+	})
+}
+
+// relationScore weighs a relation like relationWeight does, zap rings and
+// reputation are not tracked without the graph
+func relationScore(conf types.ScoringConfig, relation string, polarity int, amount int64) float64 {
+	switch relation {
+	case "REPLY_TO":
+		return conf.ReplyWeight
+	case "LIKE":
+		if polarity < 0 {
+			return -conf.DislikeWeight
+		}
+		return conf.LikeWeight
+	case "REPOST":
+		return conf.RepostWeight
+	default:
+		return conf.ZapWeight * (1 + conf.ZapAmountScale*math.Log10(1+float64(amount)))
+	}
+}
+
+// ScorePosts ranks posts like scoreQuery does, weighing every user the same
+// as there is no graph to relate them to the subscriber. Pins and reports
+// are not counted.
+func (r *sqliteRepository) ScorePosts(ctx context.Context, q types.FeedQuery) ([]scoredPost, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT p.id, p.kind, p.author, p.created_at, i.author, i.type, i.polarity, i.amount
+		FROM posts p JOIN interactions i ON i.ref = p.id
+		WHERE p.created_at > ? AND p.created_at < ? AND p.deleted_at IS NULL
+			AND p.author <> ?
+			AND NOT EXISTS (SELECT 1 FROM deliveries d WHERE d.pubkey = ? AND d.post_id = p.id)
+			AND (? = '' OR EXISTS (SELECT 1 FROM topics t WHERE t.post_id = p.id AND t.name = ?));
+	`, q.Start.Unix(), q.End.Unix(), q.Subscriber, q.Subscriber, q.Topic, q.Topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// each user counts once with its strongest relation to the post
+	posts := map[string]*scoredPost{}
+	weights := map[string]map[string]float64{}
+	for rows.Next() {
+		var post scoredPost
+		var createdAt, amount int64
+		var user, relation string
+		var polarity int
+		if err := rows.Scan(&post.Id, &post.Kind, &post.Pubkey, &createdAt, &user, &relation, &polarity, &amount); err != nil {
+			return nil, err
+		}
+		if _, ok := posts[post.Id]; !ok {
+			post.CreatedAt = time.Unix(createdAt, 0)
+			posts[post.Id] = &post
+			weights[post.Id] = map[string]float64{}
+		}
+
+		weight := relationScore(r.s.config.Scoring, relation, polarity, amount)
+		if prev, ok := weights[post.Id][user]; !ok || weight > prev {
+			weights[post.Id][user] = weight
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	decay := recencyDecay(r.s.config.Scoring)
+	defaultWeight := r.s.Weights().Default
+	ranked := make([]scoredPost, 0, len(posts))
+	for id, post := range posts {
+		for _, weight := range weights[id] {
+			post.Score += weight * defaultWeight
+		}
+		post.Score *= math.Exp(-decay * q.End.Sub(post.CreatedAt).Hours())
+		ranked = append(ranked, *post)
+	}
+	return rankPosts(ranked, q.MaxPerAuthor, q.Limit), nil
+}
+
+// rankPosts orders posts by score and keeps the top limit, at most
+// maxPerAuthor of each author if positive
+func rankPosts(posts []scoredPost, maxPerAuthor, limit int) []scoredPost {
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Score > posts[j].Score
+	})
+
+	counts := map[string]int{}
+	ranked := posts[:0]
+	for _, post := range posts {
+		if len(ranked) >= limit {
+			break
+		}
+		if maxPerAuthor > 0 && counts[post.Pubkey] >= maxPerAuthor {
+			continue
+		}
+		counts[post.Pubkey]++
+		ranked = append(ranked, post)
+	}
+	return ranked
+}
+
+func (r *sqliteRepository) RecordDeliveries(ctx context.Context, pubkey string, feed []types.FeedEntry, deliveredAt time.Time) error {
+	return r.write(ctx, func(tx *sql.Tx) error {
+		for _, entry := range feed {
+			if _, err := tx.Exec("INSERT OR IGNORE INTO deliveries (pubkey, post_id, delivered_at) VALUES (?, ?, ?);",
+				pubkey, entry.Id, deliveredAt.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *sqliteRepository) CreateSubscriber(ctx context.Context, pubkey, channelSK string, subscribedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO subscribers (pubkey, channel_secret, subscribed_at, shard_key) VALUES (?, ?, ?, ?)
+		ON CONFLICT (pubkey) DO NOTHING;
+	`, pubkey, channelSK, subscribedAt.Unix(), int64(types.ShardKey(pubkey)))
+	return err
+}
+
+func (r *sqliteRepository) SetChannelSecret(ctx context.Context, pubkey, channelSK string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE subscribers SET channel_secret = ? WHERE pubkey = ?;", channelSK, pubkey)
+	return err
+}
+
+func (r *sqliteRepository) RequestChannelClaim(ctx context.Context, pubkey string, requestedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE subscribers SET claim_requested_at = ? WHERE pubkey = ?;", requestedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) HandOverChannel(ctx context.Context, pubkey, channelPub string, handedOverAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE subscribers SET channel_secret = '', channel_pub = ?, handed_over_at = ?, claim_requested_at = NULL
+		WHERE pubkey = ?;
+	`, channelPub, handedOverAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) GetHandedOverChannels(ctx context.Context) (map[string]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT pubkey, channel_pub FROM subscribers WHERE handed_over_at IS NOT NULL;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := map[string]string{}
+	for rows.Next() {
+		var pubkey, channelPub string
+		if err := rows.Scan(&pubkey, &channelPub); err != nil {
+			return nil, err
+		}
+		channels[pubkey] = channelPub
+	}
+	return channels, rows.Err()
+}
+
+// scanSubscriber reads a subscriber row into the properties the graph would
+// return for it
+func scanSubscriber(rows interface{ Scan(...any) error }) (types.Subscriber, error) {
+	var pubkey, channelSecret, channelPub string
+	var subscribedAt, shardKey int64
+	var unsubscribedAt, claimRequestedAt, handedOverAt, lastPushedAt sql.NullInt64
+	if err := rows.Scan(&pubkey, &channelSecret, &subscribedAt, &unsubscribedAt, &shardKey,
+		&channelPub, &claimRequestedAt, &handedOverAt, &lastPushedAt); err != nil {
+		return types.Subscriber{}, err
+	}
+
+	props := map[string]any{
+		"pubkey":         pubkey,
+		"channel_secret": channelSecret,
+		"subscribed_at":  subscribedAt,
+		"shard_key":      shardKey,
+		"channel_pub":    channelPub,
+	}
+	if unsubscribedAt.Valid {
+		props["unsubscribed_at"] = unsubscribedAt.Int64
+	}
+	if claimRequestedAt.Valid {
+		props["claim_requested_at"] = claimRequestedAt.Int64
+	}
+	if handedOverAt.Valid {
+		props["handed_over_at"] = handedOverAt.Int64
+	}
+	if lastPushedAt.Valid {
+		props["last_pushed_at"] = lastPushedAt.Int64
+	}
+	return subscriberFromProps(props), nil
+}
+
+// subscribers are read along with their state
+const subscriberColumns = "s.pubkey, s.channel_secret, s.subscribed_at, s.unsubscribed_at, s.shard_key, s.channel_pub, s.claim_requested_at, s.handed_over_at, st.last_pushed_at FROM subscribers s LEFT JOIN subscriber_state st ON st.pubkey = s.pubkey"
+
+func (r *sqliteRepository) ListSubscribers(ctx context.Context, limit, skip int) ([]types.Subscriber, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+subscriberColumns+" ORDER BY s.pubkey LIMIT ? OFFSET ?;", limit, skip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []types.Subscriber
+	for rows.Next() {
+		subscriber, err := scanSubscriber(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, rows.Err()
+}
+
+func (r *sqliteRepository) ListSubscribersAfter(ctx context.Context, after string, limit int) ([]types.Subscriber, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+subscriberColumns+" WHERE s.pubkey > ? ORDER BY s.pubkey LIMIT ?;", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []types.Subscriber
+	for rows.Next() {
+		subscriber, err := scanSubscriber(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, rows.Err()
+}
+
+func (r *sqliteRepository) GetSubscriber(ctx context.Context, pubkey string) (*types.Subscriber, error) {
+	row := r.db.QueryRowContext(ctx, "SELECT "+subscriberColumns+" WHERE s.pubkey = ?;", pubkey)
+	subscriber, err := scanSubscriber(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscriber, nil
+}
+
+func (r *sqliteRepository) DeleteSubscriber(ctx context.Context, pubkey string, unsubscribedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE subscribers SET unsubscribed_at = ? WHERE pubkey = ?;", unsubscribedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) RestoreSubscriber(ctx context.Context, pubkey string, subscribedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE subscribers SET unsubscribed_at = NULL, subscribed_at = ? WHERE pubkey = ?;", subscribedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) AcquireLease(ctx context.Context, name, owner string, cursor, expiresAt, now time.Time) (*types.Lease, error) {
+	lease := types.Lease{Name: name}
+	err := r.write(ctx, func(tx *sql.Tx) error {
+		// the cursor is only moved by the owner renewing the lease
+		if _, err := tx.Exec(`
+			INSERT INTO leases (name, owner, expires_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET
+				cursor = CASE WHEN owner = excluded.owner THEN coalesce(?, cursor) ELSE cursor END,
+				owner = excluded.owner,
+				expires_at = excluded.expires_at
+			WHERE owner = excluded.owner OR expires_at <= ?;
+		`, name, owner, expiresAt.Unix(), optionalUnix(cursor), now.Unix()); err != nil {
+			return err
+		}
+
+		var leaseOwner sql.NullString
+		var leaseExpiresAt int64
+		var leaseCursor sql.NullInt64
+		row := tx.QueryRow("SELECT owner, expires_at, cursor FROM leases WHERE name = ?;", name)
+		if err := row.Scan(&leaseOwner, &leaseExpiresAt, &leaseCursor); err != nil {
+			return err
+		}
+		lease.Owner = leaseOwner.String
+		lease.ExpiresAt = time.Unix(leaseExpiresAt, 0)
+		if leaseCursor.Valid {
+			lease.Cursor = time.Unix(leaseCursor.Int64, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+func (r *sqliteRepository) ReleaseLease(ctx context.Context, name, owner string, cursor time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE leases SET owner = NULL, expires_at = 0, cursor = ? WHERE name = ? AND owner = ?;",
+		cursor.Unix(), name, owner)
+	return err
+}
+
+func (r *sqliteRepository) GetCheckpoint(ctx context.Context, name string) (*types.Checkpoint, error) {
+	checkpoint := types.Checkpoint{Name: name}
+	var endAt, window int64
+	row := r.db.QueryRowContext(ctx, "SELECT end_at, window, offset, after_pubkey, done FROM checkpoints WHERE name = ?;", name)
+	err := row.Scan(&endAt, &window, &checkpoint.Offset, &checkpoint.After, &checkpoint.Done)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.End = time.Unix(endAt, 0)
+	checkpoint.Window = time.Duration(window) * time.Second
+	return &checkpoint, nil
+}
+
+func (r *sqliteRepository) SaveCheckpoint(ctx context.Context, checkpoint types.Checkpoint) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO checkpoints (name, end_at, window, offset, after_pubkey, done) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET
+			end_at = excluded.end_at, window = excluded.window, offset = excluded.offset,
+			after_pubkey = excluded.after_pubkey, done = excluded.done;
+	`, checkpoint.Name, checkpoint.End.Unix(), int64(checkpoint.Window.Seconds()), checkpoint.Offset, checkpoint.After, checkpoint.Done)
+	return err
+}
+
+func (r *sqliteRepository) MarkHandled(ctx context.Context, id string, handledAt time.Time) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT OR IGNORE INTO handled_events (id, handled_at) VALUES (?, ?);", id, handledAt.Unix())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	return inserted == 1, err
+}
+
+func (r *sqliteRepository) QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO subscriber_state (pubkey, welcome_queued_at) SELECT pubkey, ? FROM subscribers WHERE pubkey = ?
+		ON CONFLICT (pubkey) DO UPDATE SET welcome_queued_at = excluded.welcome_queued_at;
+	`, queuedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE subscriber_state SET welcomed_at = ?, welcome_queued_at = NULL WHERE pubkey = ?;", welcomedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) GetPendingWelcomes(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT pubkey FROM subscriber_state WHERE welcome_queued_at IS NOT NULL ORDER BY welcome_queued_at;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []string{}
+	for rows.Next() {
+		var pubkey string
+		if err := rows.Scan(&pubkey); err != nil {
+			return nil, err
+		}
+		pending = append(pending, pubkey)
+	}
+	return pending, rows.Err()
+}
+
+func (r *sqliteRepository) MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO subscriber_state (pubkey, last_pushed_at) SELECT pubkey, ? FROM subscribers WHERE pubkey = ?
+		ON CONFLICT (pubkey) DO UPDATE SET last_pushed_at = excluded.last_pushed_at;
+	`, pushedAt.Unix(), pubkey)
+	return err
+}
+
+func (r *sqliteRepository) CountPublic(ctx context.Context, postedSince, subscribedSince time.Time) (publicCounts, error) {
+	var counts publicCounts
+	err := r.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT count(*) FROM subscribers WHERE unsubscribed_at IS NULL),
+			(SELECT count(*) FROM subscribers WHERE subscribed_at >= ?),
+			(SELECT count(*) FROM posts WHERE created_at >= ?),
+			(SELECT count(DISTINCT author) FROM posts WHERE created_at >= ?);
+	`, subscribedSince.Unix(), postedSince.Unix(), postedSince.Unix()).Scan(
+		&counts.subscribers, &counts.newSubscribers, &counts.posts, &counts.authors)
+	return counts, err
+}
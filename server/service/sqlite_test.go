@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSQLiteService(t *testing.T) *Service {
+	dir := t.TempDir()
+	s := NewService(&types.Config{
+		Database: types.DatabaseConfig{Driver: types.DriverSQLite},
+		Objects:  types.ObjectsConfig{Root: dir},
+		Scoring: types.ScoringConfig{
+			ReplyWeight:   15,
+			LikeWeight:    10,
+			DislikeWeight: 10,
+			RepostWeight:  20,
+			DefaultWeight: 1,
+		},
+	}, nil)
+	assert.False(t, s.hasGraph())
+	assert.NoError(t, s.repo.Init())
+	return s
+}
+
+func eventId(n int) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+func TestSQLiteScorePosts(t *testing.T) {
+	s := newSQLiteService(t)
+	now := time.Now()
+	at := now.Add(-time.Hour)
+
+	posts := []*nostr.Event{
+		{ID: eventId(1), Kind: 1, PubKey: "alice", CreatedAt: at, Tags: nostr.Tags{{"t", "nostr"}}},
+		{ID: eventId(2), Kind: 1, PubKey: "bob", CreatedAt: at},
+		{ID: eventId(3), Kind: 1, PubKey: "alice", CreatedAt: at},
+	}
+	for _, post := range posts {
+		assert.NoError(t, s.StoreEvent(post))
+	}
+
+	// carol's repost outweighs her like, dave dislikes the second post
+	interactions := []*nostr.Event{
+		{ID: eventId(10), Kind: 7, PubKey: "carol", CreatedAt: at, Content: "+", Tags: nostr.Tags{{"e", eventId(1)}}},
+		{ID: eventId(11), Kind: 6, PubKey: "carol", CreatedAt: at, Tags: nostr.Tags{{"e", eventId(1)}}},
+		{ID: eventId(12), Kind: 1, PubKey: "dave", CreatedAt: at, Tags: nostr.Tags{{"e", eventId(2)}}},
+		{ID: eventId(13), Kind: 7, PubKey: "carol", CreatedAt: at, Content: "+", Tags: nostr.Tags{{"e", eventId(3)}}},
+		{ID: eventId(14), Kind: 7, PubKey: "dave", CreatedAt: at, Content: "-", Tags: nostr.Tags{{"e", eventId(3)}}},
+		{ID: eventId(15), Kind: 3, PubKey: "dave", CreatedAt: at},
+	}
+	for _, ev := range interactions {
+		assert.NoError(t, s.StoreEvent(ev))
+	}
+
+	q := types.FeedQuery{Start: now.Add(-2 * time.Hour), End: now, Limit: 10}
+	ranked, err := s.repo.ScorePosts(context.Background(), q)
+	assert.NoError(t, err)
+	if assert.Len(t, ranked, 3) {
+		assert.Equal(t, []string{eventId(1), eventId(2), eventId(3)}, []string{ranked[0].Id, ranked[1].Id, ranked[2].Id})
+		assert.InDelta(t, 20, ranked[0].Score, 0.001)
+		assert.Equal(t, "alice", ranked[0].Pubkey)
+	}
+
+	q.MaxPerAuthor = 1
+	ranked, _ = s.repo.ScorePosts(context.Background(), q)
+	assert.Len(t, ranked, 2)
+
+	q.MaxPerAuthor, q.Topic = 0, "nostr"
+	ranked, _ = s.repo.ScorePosts(context.Background(), q)
+	if assert.Len(t, ranked, 1) {
+		assert.Equal(t, eventId(1), ranked[0].Id)
+	}
+
+	// delivered posts are left out of the subscriber's feed
+	q.Topic, q.Subscriber = "", "erin"
+	assert.NoError(t, s.RecordDeliveries(context.Background(), "erin", []types.FeedEntry{{Id: eventId(1)}}, now))
+	ranked, _ = s.repo.ScorePosts(context.Background(), q)
+	assert.Len(t, ranked, 2)
+
+	feed := s.QueryFeed(context.Background(), q)
+	if assert.Len(t, feed, 2) {
+		assert.Equal(t, eventId(2), feed[0].Id)
+		assert.NotEmpty(t, feed[0].Raw)
+	}
+}
+
+func TestSQLiteSubscribers(t *testing.T) {
+	s := newSQLiteService(t)
+	now := time.Unix(time.Now().Unix(), 0)
+
+	assert.Nil(t, s.GetSubscriber(context.Background(), "alice"))
+	assert.NoError(t, s.CreateSubscriber(context.Background(), "alice", "sk1", now))
+	assert.NoError(t, s.CreateSubscriber(context.Background(), "alice", "sk2", now))
+	assert.NoError(t, s.CreateSubscriber(context.Background(), "bob", "sk3", now))
+
+	subscriber := s.GetSubscriber(context.Background(), "alice")
+	if assert.NotNil(t, subscriber) {
+		assert.Equal(t, "sk1", subscriber.ChannelSecret)
+		assert.Equal(t, now, *subscriber.SubscribedAt)
+		assert.Nil(t, subscriber.UnsubscribedAt)
+		assert.Equal(t, types.ShardKey("alice"), subscriber.ShardKey)
+	}
+
+	assert.NoError(t, s.SetChannelSecret(context.Background(), "alice", "sk4"))
+	assert.NoError(t, s.DeleteSubscriber(context.Background(), "alice", now))
+	subscriber = s.GetSubscriber(context.Background(), "alice")
+	assert.Equal(t, "sk4", subscriber.ChannelSecret)
+	assert.NotNil(t, subscriber.UnsubscribedAt)
+
+	restored, err := s.RestoreSubscriber(context.Background(), "alice", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, restored)
+	assert.Nil(t, s.GetSubscriber(context.Background(), "alice").UnsubscribedAt)
+
+	// channels handed over keep only their public key
+	assert.NoError(t, s.RequestChannelClaim(context.Background(), "bob", now))
+	assert.NotNil(t, s.GetSubscriber(context.Background(), "bob").ClaimRequestedAt)
+	assert.NoError(t, s.HandOverChannel(context.Background(), "bob", "channel", now))
+	subscriber = s.GetSubscriber(context.Background(), "bob")
+	assert.Empty(t, subscriber.ChannelSecret)
+	assert.Equal(t, "channel", subscriber.ChannelPub)
+	assert.Nil(t, subscriber.ClaimRequestedAt)
+	assert.Equal(t, now, *subscriber.HandedOverAt)
+	channels, err := s.GetHandedOverChannels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"bob": "channel"}, channels)
+
+	subscribers, err := s.ListSubscribers(context.Background(), 1, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, subscribers, 1) {
+		assert.Equal(t, "bob", subscribers[0].Pubkey)
+	}
+
+	subscribers, err = s.ListSubscribersAfter(context.Background(), "alice", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, subscribers, 1) {
+		assert.Equal(t, "bob", subscribers[0].Pubkey)
+	}
+}
+
+func TestSQLiteLease(t *testing.T) {
+	s := newSQLiteService(t)
+	now := time.Unix(time.Now().Unix(), 0)
+
+	lease, err := s.AcquireLease(context.Background(), "bot", "a", time.Time{}, time.Minute, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", lease.Owner)
+	assert.True(t, lease.Cursor.IsZero())
+
+	// held by a until it expires
+	lease, _ = s.AcquireLease(context.Background(), "bot", "b", time.Time{}, time.Minute, now)
+	assert.Equal(t, "a", lease.Owner)
+
+	lease, _ = s.AcquireLease(context.Background(), "bot", "a", now, time.Minute, now.Add(30*time.Second))
+	assert.Equal(t, now, lease.Cursor)
+
+	// b takes over from the cursor a released
+	assert.NoError(t, s.ReleaseLease(context.Background(), "bot", "a", now.Add(time.Minute)))
+	lease, _ = s.AcquireLease(context.Background(), "bot", "b", time.Time{}, time.Minute, now.Add(time.Minute))
+	assert.Equal(t, "b", lease.Owner)
+	assert.Equal(t, now.Add(time.Minute), lease.Cursor)
+
+	lease, _ = s.AcquireLease(context.Background(), "bot", "a", time.Time{}, time.Minute, now.Add(3*time.Minute))
+	assert.Equal(t, "a", lease.Owner)
+}
+
+func TestSQLiteCheckpoint(t *testing.T) {
+	s := newSQLiteService(t)
+	end := time.Unix(time.Now().Unix(), 0)
+
+	checkpoint, err := s.GetCheckpoint(context.Background(), "worker")
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	assert.NoError(t, s.SaveCheckpoint(context.Background(), types.Checkpoint{Name: "worker", End: end, Window: time.Hour, Offset: 10}))
+	assert.NoError(t, s.SaveCheckpoint(context.Background(), types.Checkpoint{Name: "worker", End: end, Window: time.Hour, Offset: 20, Done: true}))
+
+	checkpoint, err = s.GetCheckpoint(context.Background(), "worker")
+	assert.NoError(t, err)
+	assert.Equal(t, &types.Checkpoint{Name: "worker", End: end, Window: time.Hour, Offset: 20, Done: true}, checkpoint)
+
+	assert.NoError(t, s.SaveCheckpoint(context.Background(), types.Checkpoint{Name: "worker", End: end, Window: time.Hour, Offset: 30, After: "bob"}))
+	checkpoint, _ = s.GetCheckpoint(context.Background(), "worker")
+	assert.Equal(t, "bob", checkpoint.After)
+}
+
+func TestSQLiteMarkHandled(t *testing.T) {
+	s := newSQLiteService(t)
+
+	first, err := s.MarkHandled(context.Background(), eventId(1))
+	assert.NoError(t, err)
+	assert.True(t, first)
+
+	// the same event from another relay is handled once
+	first, err = s.MarkHandled(context.Background(), eventId(1))
+	assert.NoError(t, err)
+	assert.False(t, first)
+}
+
+func TestSQLiteSubscriberState(t *testing.T) {
+	s := newSQLiteService(t)
+	now := time.Now()
+	assert.NoError(t, s.CreateSubscriber(context.Background(), "alice", "sk1", now))
+	assert.NoError(t, s.CreateSubscriber(context.Background(), "bob", "sk2", now))
+
+	assert.NoError(t, s.QueueWelcome(context.Background(), "bob", now))
+	assert.NoError(t, s.QueueWelcome(context.Background(), "alice", now.Add(time.Second)))
+	// only subscribers are queued
+	assert.NoError(t, s.QueueWelcome(context.Background(), "carol", now))
+
+	pending, err := s.GetPendingWelcomes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "alice"}, pending)
+
+	assert.NoError(t, s.MarkWelcomed(context.Background(), "bob", now))
+	pending, err = s.GetPendingWelcomes(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, pending)
+
+	pushedAt := time.Unix(now.Unix(), 0)
+	assert.Nil(t, s.GetSubscriber(context.Background(), "alice").LastPushedAt)
+	assert.NoError(t, s.MarkPushed(context.Background(), "alice", pushedAt))
+	assert.Equal(t, pushedAt, *s.GetSubscriber(context.Background(), "alice").LastPushedAt)
+	assert.NoError(t, s.MarkPushed(context.Background(), "bob", pushedAt))
+	assert.Equal(t, pushedAt, *s.GetSubscriber(context.Background(), "bob").LastPushedAt)
+
+	// digests aren't recorded without the graph
+	assert.NoError(t, s.RecordDigest(context.Background(), types.DigestMeta{Channel: "channel", PushedAt: now}))
+	last, err := s.LastDigestAt(context.Background(), "channel")
+	assert.NoError(t, err)
+	assert.Nil(t, last)
+}
+
+func TestSQLitePublicStats(t *testing.T) {
+	s := newSQLiteService(t)
+	s.config.Privacy = types.PrivacyConfig{Epsilon: 1.0, MinCount: 10, Period: "1h"}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.CreateSubscriber(context.Background(), fmt.Sprint("subscriber", i), "sk", now))
+	}
+	assert.NoError(t, s.StoreEvent(&nostr.Event{ID: eventId(1), Kind: 1, PubKey: "alice", CreatedAt: now.Add(-time.Hour)}))
+	assert.NoError(t, s.StoreEvent(&nostr.Event{ID: eventId(2), Kind: 1, PubKey: "alice", CreatedAt: now.Add(-time.Hour)}))
+
+	stats, err := s.GetPublicStats(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Posts24h)
+	assert.Equal(t, int64(1), stats.Authors24h)
+	assert.Empty(t, stats.Topics)
+
+	// the noise is drawn once per period
+	again, err := s.GetPublicStats(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, stats, again)
+}
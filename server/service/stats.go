@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// topics listed in public stats
+const publicTopicsN = 10
+
+// publicCounts are the aggregates behind public stats, before privatization
+type publicCounts struct {
+	subscribers    int64
+	newSubscribers int64
+	posts          int64
+	authors        int64
+}
+
+// publicStatsCache keeps privatized stats for a period. Fresh noise on every
+// request could be averaged away by asking repeatedly.
+type publicStatsCache struct {
+	mu        sync.Mutex
+	stats     *types.PublicStats
+	expiresAt time.Time
+}
+
+// GetPublicStats returns aggregates safe to publish. Subscriber-derived counts
+// are privatized, network-wide counts are public information and kept as is.
+// Stats are computed once per Privacy.Period.
+func (s *Service) GetPublicStats(ctx context.Context) (*types.PublicStats, error) {
+	s.publicStats.mu.Lock()
+	defer s.publicStats.mu.Unlock()
+
+	now := time.Now()
+	if s.publicStats.stats != nil && now.Before(s.publicStats.expiresAt) {
+		return s.publicStats.stats, nil
+	}
+
+	stats, err := s.computePublicStats(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	s.publicStats.stats = stats
+	s.publicStats.expiresAt = now.Add(parseDurationOr(s.config.Privacy.Period, time.Hour))
+	return stats, nil
+}
+
+func (s *Service) computePublicStats(ctx context.Context, now time.Time) (*types.PublicStats, error) {
+	counts, err := s.repo.CountPublic(ctx, now.Add(-24*time.Hour), now.AddDate(0, 0, -7))
+	if err != nil {
+		return nil, err
+	}
+
+	topics, err := s.getTopicStats(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.PublicStats{
+		Subscribers:    s.privatize(counts.subscribers, 1),
+		NewSubscribers: s.privatize(counts.newSubscribers, 1),
+		Posts24h:       counts.posts,
+		Authors24h:     counts.authors,
+		Topics:         topics,
+	}, nil
+}
+
+// getTopicStats returns the most posted topics since the given time, with the
+// privatized number of subscribers interested in each. Topics are picked by
+// posts, which are public, so that the choice reveals nothing of subscribers.
+// Interests are only kept in the graph.
+func (s *Service) getTopicStats(ctx context.Context, since time.Time) ([]types.TopicStats, error) {
+	if !s.hasGraph() {
+		return []types.TopicStats{}, nil
+	}
+
+	stats, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post)-[:TAGGED]->(t:Topic)
+			WHERE p.created_at >= $Since
+			WITH t, count(p) AS posts
+			ORDER BY posts DESC LIMIT $Limit
+			OPTIONAL MATCH (s:Subscriber)-[r:INTERESTED_IN]->(t)
+			WHERE r.weight > 0 AND s.unsubscribed_at IS NULL
+			RETURN t.name, posts, count(s) AS subscribers
+			ORDER BY posts DESC;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since": since.Unix(),
+				"Limit": publicTopicsN,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		stats := []types.TopicStats{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			stats = append(stats, types.TopicStats{
+				Topic:       values[0].(string),
+				Posts24h:    values[1].(int64),
+				Subscribers: s.privatize(values[2].(int64), 1),
+			})
+		}
+		return stats, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats.([]types.TopicStats), nil
+}
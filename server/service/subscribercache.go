@@ -0,0 +1,115 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+)
+
+// subscriberCache keeps subscribers looked up recently for a TTL, so that
+// bursts of mentions don't each read the database. Writes to a subscriber
+// invalidate it. A nil cache caches nothing.
+type subscriberCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+	hits    int64
+	misses  int64
+}
+
+type cachedSubscriber struct {
+	subscriber types.Subscriber
+	expiresAt  time.Time
+}
+
+func newSubscriberCache(size int, ttl time.Duration) *subscriberCache {
+	return &subscriberCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns a copy of the cached subscriber, if not expired
+func (c *subscriberCache) get(pubkey string, now time.Time) (*types.Subscriber, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[pubkey]
+	if ok && now.After(e.Value.(*cachedSubscriber).expiresAt) {
+		c.order.Remove(e)
+		delete(c.entries, pubkey)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.record("misses")
+		return nil, false
+	}
+	c.hits++
+	c.record("hits")
+	c.order.MoveToFront(e)
+	subscriber := e.Value.(*cachedSubscriber).subscriber
+	return &subscriber, true
+}
+
+func (c *subscriberCache) record(outcome string) {
+	metrics.NewCounter("subscribers/cache/" + outcome).Inc(1)
+	metrics.NewGaugeFloat64("subscribers/cache/hitrate").Update(float64(c.hits) / float64(c.hits+c.misses))
+}
+
+func (c *subscriberCache) put(subscriber *types.Subscriber, now time.Time) {
+	if c == nil || subscriber == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cachedSubscriber{subscriber: *subscriber, expiresAt: now.Add(c.ttl)}
+	if e, ok := c.entries[subscriber.Pubkey]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[subscriber.Pubkey] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedSubscriber).subscriber.Pubkey)
+	}
+}
+
+// invalidate drops the subscriber, so that the next lookup reads its writes
+func (c *subscriberCache) invalidate(pubkey string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[pubkey]; ok {
+		c.order.Remove(e)
+		delete(c.entries, pubkey)
+	}
+}
+
+// clear drops all subscribers, after writes to many of them
+func (c *subscriberCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
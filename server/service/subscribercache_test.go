@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberCache(t *testing.T) {
+	now := time.Now()
+	c := newSubscriberCache(2, time.Minute)
+
+	_, ok := c.get("a", now)
+	assert.False(t, ok)
+
+	c.put(&types.Subscriber{Pubkey: "a", Tier: "free"}, now)
+	got, ok := c.get("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, "free", got.Tier)
+
+	// callers get a copy
+	got.Tier = "premium"
+	got, _ = c.get("a", now)
+	assert.Equal(t, "free", got.Tier)
+
+	// expired after the TTL
+	_, ok = c.get("a", now.Add(2*time.Minute))
+	assert.False(t, ok)
+
+	// the least recently looked up is evicted first
+	c.put(&types.Subscriber{Pubkey: "a"}, now)
+	c.put(&types.Subscriber{Pubkey: "b"}, now)
+	c.get("a", now)
+	c.put(&types.Subscriber{Pubkey: "c"}, now)
+	_, ok = c.get("b", now)
+	assert.False(t, ok)
+	_, ok = c.get("a", now)
+	assert.True(t, ok)
+
+	c.invalidate("a")
+	_, ok = c.get("a", now)
+	assert.False(t, ok)
+
+	c.clear()
+	_, ok = c.get("c", now)
+	assert.False(t, ok)
+
+	// a nil cache caches nothing
+	var none *subscriberCache
+	none.put(&types.Subscriber{Pubkey: "a"}, now)
+	_, ok = none.get("a", now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// MarkSurveyed records that a satisfaction survey was sent to the subscriber
+func (s *Service) MarkSurveyed(ctx context.Context, pubkey string, surveyedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET s.last_surveyed_at = $SurveyedAt;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":     pubkey,
+				"SurveyedAt": surveyedAt.Unix(),
+			})
+		return nil, err
+	})
+	return err
+}
+
+// RecordSurveyResponse stores the subscriber's answer to the latest survey.
+// Only the first answer to each survey is kept, it returns false if the
+// subscriber was never surveyed or already answered.
+func (s *Service) RecordSurveyResponse(ctx context.Context, pubkey string, score int, answeredAt time.Time) (bool, error) {
+	defer s.subscribers.invalidate(pubkey)
+	recorded, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			WHERE s.last_surveyed_at IS NOT NULL
+			MERGE (s)-[:ANSWERED]->(r:SurveyResponse {pubkey: s.pubkey, asked_at: s.last_surveyed_at})
+			ON CREATE SET r.score = $Score, r.answered_at = $AnsweredAt
+			RETURN r.answered_at = $AnsweredAt AND r.score = $Score;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":     pubkey,
+				"Score":      score,
+				"AnsweredAt": answeredAt.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		if !result.Next(ctx) {
+			return false, result.Err()
+		}
+		return result.Record().Values[0].(bool), nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return recorded.(bool), nil
+}
+
+// GetSurveyResponses returns survey answers given since the time, latest
+// first
+func (s *Service) GetSurveyResponses(since time.Time) ([]types.SurveyResponse, error) {
+	responses, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (r:SurveyResponse)
+			WHERE r.answered_at >= $Since
+			RETURN r.pubkey, r.score, r.asked_at, r.answered_at
+			ORDER BY r.answered_at DESC;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since": since.Unix(),
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		responses := []types.SurveyResponse{}
+		for result.Next(ctx) {
+			values := result.Record().Values
+			responses = append(responses, types.SurveyResponse{
+				Pubkey:     values[0].(string),
+				Score:      int(values[1].(int64)),
+				AskedAt:    time.Unix(values[2].(int64), 0),
+				AnsweredAt: time.Unix(values[3].(int64), 0),
+			})
+		}
+		return responses, result.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return responses.([]types.SurveyResponse), nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// threadRefs returns the root of the thread a note belongs to and the note
+// it directly replies to, following NIP-10. Marked e tags take precedence,
+// otherwise the deprecated positional scheme applies: the first e tag is the
+// root and the last one the parent. Both are empty if the note isn't a reply.
+func threadRefs(event *nostr.Event) (root, parent string) {
+	refs := event.Tags.GetAll([]string{"e"})
+	if len(refs) == 0 {
+		return "", ""
+	}
+
+	marked := false
+	for _, ref := range refs {
+		if len(ref) < 4 || ref[3] == "" {
+			continue
+		}
+		marked = true
+		switch ref[3] {
+		case "root":
+			root = ref.Value()
+		case "reply":
+			parent = ref.Value()
+		}
+	}
+
+	if marked {
+		// a direct reply to the root only marks the root
+		if parent == "" {
+			parent = root
+		}
+		if root == "" {
+			root = parent
+		}
+		return root, parent
+	}
+
+	return refs[0].Value(), refs[len(refs)-1].Value()
+}
+
+// migrateReplies converts reply relations stored before the root was told
+// apart from the parent
+func (s *Service) migrateReplies() error {
+	for {
+		migrated, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+			query := `
+				MATCH (p:Post)-[r:REPLY]->(o:Post)
+				WITH p, r, o LIMIT 10000
+				MERGE (p)-[:REPLY_TO]->(o)
+				DELETE r
+				RETURN count(*);
+			`
+			result, err := tx.Run(ctx, query, nil)
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return record.Values[0].(int64), nil
+		})
+		if err != nil {
+			return err
+		}
+		if migrated.(int64) == 0 {
+			return nil
+		}
+		logger.Info("Migrated reply relations", "count", migrated)
+	}
+}
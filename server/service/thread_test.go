@@ -0,0 +1,33 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadRefs(t *testing.T) {
+	cases := []struct {
+		name   string
+		tags   nostr.Tags
+		root   string
+		parent string
+	}{
+		{"not a reply", nostr.Tags{{"p", "pub"}}, "", ""},
+		{"marked", nostr.Tags{{"e", "parent", "", "reply"}, {"e", "root", "wss://relay", "root"}}, "root", "parent"},
+		{"marked root only", nostr.Tags{{"e", "root", "", "root"}}, "root", "root"},
+		{"marked reply only", nostr.Tags{{"e", "parent", "", "reply"}}, "parent", "parent"},
+		{"mention only", nostr.Tags{{"e", "quoted", "", "mention"}}, "", ""},
+		{"marked with mention", nostr.Tags{{"e", "root", "", "root"}, {"e", "quoted", "", "mention"}, {"e", "parent", "", "reply"}}, "root", "parent"},
+		{"positional single", nostr.Tags{{"e", "root"}}, "root", "root"},
+		{"positional", nostr.Tags{{"e", "root"}, {"e", "mentioned"}, {"e", "parent"}}, "root", "parent"},
+		{"positional with relay", nostr.Tags{{"e", "root", "wss://relay"}, {"e", "parent", ""}}, "root", "parent"},
+	}
+
+	for _, c := range cases {
+		root, parent := threadRefs(&nostr.Event{Kind: 1, Tags: c.tags})
+		assert.Equal(t, c.root, root, c.name)
+		assert.Equal(t, c.parent, parent, c.name)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// ParseZapReceipt extracts sender, recipient and amount (in sats) of a NIP-57
+// zap receipt. The sender is the author of the embedded zap request.
+func ParseZapReceipt(event *nostr.Event) (*types.ZapReceipt, error) {
+	bolt11 := event.Tags.GetLast([]string{"bolt11"})
+	if bolt11 == nil {
+		return nil, fmt.Errorf("missing bolt11 tag")
+	}
+	invoice, err := decodepay.Decodepay(bolt11.Value())
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &types.ZapReceipt{
+		Amount: invoice.MSatoshi / 1000,
+	}
+
+	if p := event.Tags.GetFirst([]string{"p"}); p != nil {
+		receipt.Recipient = p.Value()
+	}
+	if e := event.Tags.GetFirst([]string{"e"}); e != nil {
+		receipt.EventId = e.Value()
+	}
+	if desc := event.Tags.GetLast([]string{"description"}); desc != nil {
+		var request nostr.Event
+		if err := json.Unmarshal([]byte(desc.Value()), &request); err == nil {
+			receipt.Sender = request.PubKey
+		}
+	}
+
+	return receipt, nil
+}
+
+// VerifyZapReceipt checks that a NIP-57 zap receipt was signed by zapper, the
+// LNURL server of the recipient, and that the zap request it embeds is signed
+// by its sender, zaps the same recipient and asks for the invoiced amount.
+// Anyone can publish a receipt, so only verified ones are worth anything.
+func VerifyZapReceipt(event *nostr.Event, zapper string) error {
+	if zapper == "" || event.PubKey != zapper {
+		return fmt.Errorf("zap receipt not signed by the zapper %q", zapper)
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return errors.New("invalid zap receipt signature")
+	}
+
+	desc := event.Tags.GetLast([]string{"description"})
+	if desc == nil {
+		return errors.New("missing zap request")
+	}
+	var request nostr.Event
+	if err := json.Unmarshal([]byte(desc.Value()), &request); err != nil {
+		return fmt.Errorf("invalid zap request: %w", err)
+	}
+	if request.Kind != 9734 {
+		return fmt.Errorf("zap request of kind %d", request.Kind)
+	}
+	if ok, err := request.CheckSignature(); err != nil || !ok {
+		return errors.New("invalid zap request signature")
+	}
+
+	recipient := event.Tags.GetFirst([]string{"p"})
+	requested := request.Tags.GetFirst([]string{"p"})
+	if recipient == nil || requested == nil || recipient.Value() != requested.Value() {
+		return errors.New("zap request for another recipient")
+	}
+
+	bolt11 := event.Tags.GetLast([]string{"bolt11"})
+	if bolt11 == nil {
+		return errors.New("missing bolt11 tag")
+	}
+	invoice, err := decodepay.Decodepay(bolt11.Value())
+	if err != nil {
+		return err
+	}
+	amount := request.Tags.GetFirst([]string{"amount"})
+	if amount == nil {
+		return errors.New("zap request without amount")
+	}
+	if msats, err := strconv.ParseInt(amount.Value(), 10, 64); err != nil || msats != invoice.MSatoshi {
+		return fmt.Errorf("zap request amount %s doesn't match invoice amount %d", amount.Value(), invoice.MSatoshi)
+	}
+	return nil
+}
+
+func (s *Service) GrantTier(ctx context.Context, pubkey, tier string, expiresAt *time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	logger.Info("Grant tier", "pubkey", pubkey, "tier", tier, "expiresAt", expiresAt)
+	_, err := s.neo4j.ExecuteWrite(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber {pubkey: $Pubkey})
+			SET
+				s.tier = $Tier,
+				s.tier_expires_at = $ExpiresAt;
+		`
+		var expires any
+		if expiresAt != nil {
+			expires = expiresAt.Unix()
+		}
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Pubkey":    pubkey,
+				"Tier":      tier,
+				"ExpiresAt": expires,
+			})
+		return nil, err
+	})
+	return err
+}
+
+func (s *Service) MarkPushed(ctx context.Context, pubkey string, pushedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.MarkPushed(ctx, pubkey, pushedAt)
+}
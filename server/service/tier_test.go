@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+// 1000 sats
+const testBolt11 = "lnbc10u1p3unwfusp5t9r3yymhpfqculx78u027lxspgxcr2n2987mx2j55nnfs95nxnzqpp5jmrh92pfld78spqs78v9euf2385t83uvpwk9ldrlvf6ch7tpascqhp5zvkrmemgth3tufcvflmzjzfvjt023nazlhljz2n9hattj4f8jq8qxqyjw5qcqpjrzjqtc4fc44feggv7065fqe5m4ytjarg3repr5j9el35xhmtfexc42yczarjuqqfzqqqqqqqqlgqqqqqqgq9q9qxpqysgq079nkq507a5tw7xgttmj4u990j7wfggtrasah5gd4ywfr2pjcn29383tphp4t48gquelz9z78p4cq7ml3nrrphw5w6eckhjwmhezhnqpy6gyf0"
+
+func zapReceipt(t *testing.T, zapperSK, senderSK, recipient, amount string, tamper func(*nostr.Event)) *nostr.Event {
+	request := nostr.Event{
+		Kind:      9734,
+		CreatedAt: time.Now(),
+		Tags:      nostr.Tags{{"p", recipient}, {"amount", amount}},
+	}
+	request.PubKey, _ = nostr.GetPublicKey(senderSK)
+	assert.NoError(t, request.Sign(senderSK))
+	if tamper != nil {
+		tamper(&request)
+	}
+
+	description, err := json.Marshal(request)
+	assert.NoError(t, err)
+	receipt := &nostr.Event{
+		Kind:      9735,
+		CreatedAt: time.Now(),
+		Tags:      nostr.Tags{{"p", recipient}, {"bolt11", testBolt11}, {"description", string(description)}},
+	}
+	receipt.PubKey, _ = nostr.GetPublicKey(zapperSK)
+	assert.NoError(t, receipt.Sign(zapperSK))
+	return receipt
+}
+
+func TestVerifyZapReceipt(t *testing.T) {
+	zapperSK, senderSK, otherSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	zapper, _ := nostr.GetPublicKey(zapperSK)
+	recipient, _ := nostr.GetPublicKey(otherSK)
+
+	receipt := zapReceipt(t, zapperSK, senderSK, recipient, "1000000", nil)
+	assert.NoError(t, VerifyZapReceipt(receipt, zapper))
+
+	parsed, err := ParseZapReceipt(receipt)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), parsed.Amount)
+
+	// receipts are only trusted from the zapper, with their signature intact
+	assert.Error(t, VerifyZapReceipt(receipt, ""))
+	assert.Error(t, VerifyZapReceipt(zapReceipt(t, otherSK, senderSK, recipient, "1000000", nil), zapper))
+	forged := *receipt
+	forged.Tags = append(nostr.Tags{}, receipt.Tags...)
+	forged.Tags[0] = nostr.Tag{"p", zapper}
+	assert.Error(t, VerifyZapReceipt(&forged, zapper))
+
+	// the zap request is signed by the sender and asks for the invoiced amount
+	assert.Error(t, VerifyZapReceipt(zapReceipt(t, zapperSK, senderSK, recipient, "1", nil), zapper))
+	assert.Error(t, VerifyZapReceipt(zapReceipt(t, zapperSK, senderSK, recipient, "1000000", func(request *nostr.Event) {
+		request.PubKey = recipient
+	}), zapper))
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+	"golang.org/x/exp/slices"
+)
+
+// inline hashtags start with a letter, which leaves out "#[0]" mentions and
+// "#1"
+var hashtagPattern = regexp.MustCompile(`(?:^|\s)#(\pL[\pL\pN_]*)`)
+
+// postTopics collects the t tags and inline hashtags of a post
+func postTopics(event *nostr.Event) []string {
+	topics := []string{}
+	for _, tag := range event.Tags.GetAll([]string{"t"}) {
+		topics = append(topics, tag.Value())
+	}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(event.Content, -1) {
+		topics = append(topics, match[1])
+	}
+	return normalizeTopics(topics)
+}
+
+// filterTopic keeps entries tagged with the topic, for entries not ranked by
+// the graph
+func filterTopic(feed []types.FeedEntry, topic string) []types.FeedEntry {
+	filtered := feed[:0]
+	for _, entry := range feed {
+		var ev nostr.Event
+		if err := json.Unmarshal([]byte(entry.Raw), &ev); err != nil {
+			continue
+		}
+		if slices.Contains(postTopics(&ev), topic) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// GetFeedByTopic ranks posts tagged with the topic created within
+// (start, end)
+func (s *Service) GetFeedByTopic(ctx context.Context, topic string, start time.Time, end time.Time, limit int) []types.FeedEntry {
+	topics := normalizeTopics([]string{topic})
+	if len(topics) == 0 {
+		return []types.FeedEntry{}
+	}
+
+	return s.QueryFeed(ctx, types.FeedQuery{
+		Topic:        topics[0],
+		Start:        start,
+		End:          end,
+		Limit:        limit,
+		MaxPerAuthor: s.config.Digest.MaxPerAuthor,
+	})
+}
+
+// migrateTopics merges topics created twice by concurrent writers, then makes
+// topic names unique
+func (s *Service) migrateTopics() error {
+	merged, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (t:Topic)
+			WITH t.name AS name, collect(t) AS topics
+			WHERE size(topics) > 1
+			WITH head(topics) AS kept, tail(topics) AS duplicates
+			UNWIND duplicates AS duplicate
+			CALL {
+				WITH kept, duplicate
+				MATCH (p:Post)-[:TAGGED]->(duplicate)
+				MERGE (p)-[:TAGGED]->(kept)
+			}
+			CALL {
+				WITH kept, duplicate
+				MATCH (s)-[r:INTERESTED_IN]->(duplicate)
+				MERGE (s)-[i:INTERESTED_IN]->(kept)
+				ON CREATE SET i = properties(r)
+			}
+			DETACH DELETE duplicate
+			RETURN count(*);
+		`
+		result, err := tx.Run(ctx, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		record, err := result.Single(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return record.Values[0].(int64), nil
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("Merged duplicate topics", "count", merged)
+
+	return schemaStatements(
+		"CREATE CONSTRAINT topic_name_uniq IF NOT EXISTS FOR (t:Topic) REQUIRE t.name IS UNIQUE;",
+	)(s)
+}
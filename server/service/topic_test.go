@@ -0,0 +1,17 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostTopics(t *testing.T) {
+	ev := &nostr.Event{
+		Kind:    1,
+		Content: "#[0] stacking sats #Bitcoin #1 and #art_daily, not an#anchor\n#日本",
+		Tags:    nostr.Tags{{"p", "x"}, {"t", "bitcoin"}, {"t", "Nostr"}},
+	}
+	assert.Equal(t, []string{"bitcoin", "nostr", "art_daily", "日本"}, postTopics(ev))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// posts younger than this are ranked as if they were this old, so that a
+// single early like doesn't make a post trend
+const trendingMinAgeHours = 1.0
+
+// GetTrendingFeed ranks posts created within (start, end) by their
+// interaction rate, i.e. the replies, likes, reposts and zaps they got per
+// hour since creation, rather than by total interactions
+func (s *Service) GetTrendingFeed(ctx context.Context, start time.Time, end time.Time, limit int) []types.FeedEntry {
+	posts, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (p:Post) WHERE $Start < p.created_at < $End
+			WITH p WHERE p.deleted_at IS NULL
+			MATCH (:Post)-[l:REPLY_TO|LIKE|REPOST|ZAP]->(p) WHERE coalesce(l.polarity, 1) > 0
+			WITH p, count(l) AS interactions
+			WITH p, interactions / CASE
+				WHEN ($End - p.created_at) / 3600.0 < $MinAge THEN $MinAge
+				ELSE ($End - p.created_at) / 3600.0
+			END AS velocity
+			ORDER BY velocity DESC LIMIT $Limit
+			RETURN p.id, p.kind, p.author, p.created_at, velocity;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Start":  start.Unix(),
+				"End":    end.Unix(),
+				"MinAge": trendingMinAgeHours,
+				"Limit":  limit,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		posts := []scoredPost{}
+		for result.Next(ctx) {
+			record := result.Record()
+			posts = append(posts, scoredPost{
+				Id:        record.Values[0].(string),
+				Kind:      int(record.Values[1].(int64)),
+				Pubkey:    record.Values[2].(string),
+				CreatedAt: time.Unix(record.Values[3].(int64), 0),
+				Score:     record.Values[4].(float64),
+			})
+		}
+		return posts, nil
+	})
+	if err != nil {
+		logger.Error("Failed to get trending feed", "err", err)
+		return nil
+	}
+
+	feed := []types.FeedEntry{}
+	for _, post := range posts.([]scoredPost) {
+		raw, err := s.readObject(post.Id, post.CreatedAt)
+		if err != nil {
+			logger.Error("Failed to read object", "id", post.Id, "err", err)
+			continue
+		}
+
+		feed = append(feed, types.FeedEntry{
+			Id:        post.Id,
+			Kind:      post.Kind,
+			Pubkey:    post.Pubkey,
+			CreatedAt: post.CreatedAt,
+			Score:     post.Score,
+			Raw:       raw,
+		})
+	}
+	return s.attachSeenOn(ctx, filterKeywords(s.keywords, feed))
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+const (
+	signalSimilar = "similar"
+	signalFollow  = "follow"
+	signalDefault = "default"
+)
+
+// tuner keeps the weight proposal waiting for operator approval
+type tuner struct {
+	config types.TuningConfig
+
+	mu      sync.Mutex
+	pending *types.WeightProposal
+}
+
+func newTuner(config types.TuningConfig) *tuner {
+	return &tuner{config: config}
+}
+
+// ProposeWeights evaluates how often subscribers engaged with delivered
+// posts, grouped by the strongest signal that ranked each post for its
+// subscriber: a similar user, a followed user or anyone. Each delivery is a
+// sample. Weights of signals performing above
+// average are raised and the others lowered, by at most MaxStep. A subscriber
+// rating their digest overrides their engagement with its posts. The proposal
+// replaces any pending one and is only applied once approved.
+func (s *Service) ProposeWeights(ctx context.Context) (*types.WeightProposal, error) {
+	conf := s.tuning.config
+	lookback := parseDurationOr(conf.Lookback, 7*24*time.Hour)
+
+	stats, err := s.neo4j.ExecuteRead(ctx, func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (s:Subscriber)-[d:DELIVERED]->(p:Post)
+			WHERE d.at >= $Since
+			MATCH (me:User {pubkey: s.pubkey})
+			OPTIONAL MATCH (me)-[:CREATE]->(:Post)-[e:LIKE|REPOST|ZAP|REPLY_TO]->(p)
+			WHERE coalesce(e.polarity, 1) > 0
+			WITH s, d, me, p, count(e) > 0 AS engaged
+			OPTIONAL MATCH (f:DigestFeedback {subscriber: s.pubkey, run: d.at, pubkey: s.pubkey})
+			WITH d, me, p, CASE WHEN f IS NULL THEN engaged ELSE f.positive END AS engaged
+			OPTIONAL MATCH (me)-[rel:SIMILAR|FOLLOW]->(:User)-[:CREATE]->(:Post)-[:REPLY_TO|LIKE|ZAP]->(p)
+			WITH d, engaged, collect(DISTINCT type(rel)) AS rels
+			WITH engaged, CASE
+				WHEN 'SIMILAR' IN rels THEN $Similar
+				WHEN 'FOLLOW' IN rels THEN $Follow
+				ELSE $Default
+			END AS signal
+			RETURN signal, count(*), sum(CASE WHEN engaged THEN 1 ELSE 0 END);
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since":   time.Now().Add(-lookback).Unix(),
+				"Similar": signalSimilar,
+				"Follow":  signalFollow,
+				"Default": signalDefault,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		samples := map[string]int64{}
+		engaged := map[string]int64{}
+		for result.Next(ctx) {
+			record := result.Record()
+			signal := record.Values[0].(string)
+			samples[signal] = record.Values[1].(int64)
+			engaged[signal] = record.Values[2].(int64)
+		}
+		return [2]map[string]int64{samples, engaged}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := stats.([2]map[string]int64)
+	proposal := proposeWeights(s.Weights(), counts[0], counts[1], conf.MaxStep, int64(conf.MinSamples))
+	proposal.CreatedAt = time.Now()
+
+	s.tuning.mu.Lock()
+	s.tuning.pending = proposal
+	s.tuning.mu.Unlock()
+
+	logger.Info("Proposed scoring weights", "current", proposal.Current, "proposed", proposal.Proposed, "rates", proposal.Rates)
+	return proposal, nil
+}
+
+// PendingWeights returns the proposal waiting for approval, if any
+func (s *Service) PendingWeights() *types.WeightProposal {
+	s.tuning.mu.Lock()
+	defer s.tuning.mu.Unlock()
+	return s.tuning.pending
+}
+
+// ApproveWeights applies the pending proposal and persists it so that it
+// survives restarts
+func (s *Service) ApproveWeights() (*types.ScoringWeights, error) {
+	s.tuning.mu.Lock()
+	proposal := s.tuning.pending
+	s.tuning.pending = nil
+	s.tuning.mu.Unlock()
+
+	if proposal == nil {
+		return nil, fmt.Errorf("no pending proposal")
+	}
+
+	_, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MERGE (w:ScoringWeights {id: 'current'})
+			SET w.similar = $Similar, w.follow = $Follow, w.default = $Default, w.approved_at = $Now;
+		`
+		_, err := tx.Run(ctx, query,
+			map[string]any{
+				"Similar": proposal.Proposed.Similar,
+				"Follow":  proposal.Proposed.Follow,
+				"Default": proposal.Proposed.Default,
+				"Now":     time.Now().Unix(),
+			})
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.setWeights(proposal.Proposed)
+	logger.Info("Applied scoring weights", "weights", proposal.Proposed)
+	return &proposal.Proposed, nil
+}
+
+// RejectWeights discards the pending proposal
+func (s *Service) RejectWeights() {
+	s.tuning.mu.Lock()
+	defer s.tuning.mu.Unlock()
+	s.tuning.pending = nil
+}
+
+// loadWeights restores approved weights
+func (s *Service) loadWeights() {
+	weights, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		result, err := tx.Run(ctx, "MATCH (w:ScoringWeights {id: 'current'}) RETURN w.similar, w.follow, w.default;", nil)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Next(ctx) {
+			return nil, nil
+		}
+		record := result.Record()
+		return &types.ScoringWeights{
+			Similar: record.Values[0].(float64),
+			Follow:  record.Values[1].(float64),
+			Default: record.Values[2].(float64),
+		}, nil
+	})
+	if err != nil {
+		logger.Error("Failed to load scoring weights", "err", err)
+		return
+	}
+	if w, ok := weights.(*types.ScoringWeights); ok && w != nil {
+		s.setWeights(*w)
+	}
+}
+
+// proposeWeights scales each weight by the engagement rate of its signal
+// relative to the overall rate, bounded by maxStep
+func proposeWeights(current types.ScoringWeights, samples, engaged map[string]int64, maxStep float64, minSamples int64) *types.WeightProposal {
+	proposal := &types.WeightProposal{
+		Current:  current,
+		Proposed: current,
+		Rates:    map[string]float64{},
+		Samples:  samples,
+	}
+
+	var totalSamples, totalEngaged int64
+	for signal, n := range samples {
+		totalSamples += n
+		totalEngaged += engaged[signal]
+		if n > 0 {
+			proposal.Rates[signal] = float64(engaged[signal]) / float64(n)
+		}
+	}
+	if totalSamples == 0 || totalEngaged == 0 {
+		return proposal
+	}
+	overall := float64(totalEngaged) / float64(totalSamples)
+
+	scale := func(signal string, weight float64) float64 {
+		if samples[signal] < minSamples {
+			return weight
+		}
+		factor := proposal.Rates[signal] / overall
+		if factor > 1+maxStep {
+			factor = 1 + maxStep
+		}
+		if factor < 1-maxStep {
+			factor = 1 - maxStep
+		}
+		return weight * factor
+	}
+
+	proposal.Proposed = types.ScoringWeights{
+		Similar: scale(signalSimilar, current.Similar),
+		Follow:  scale(signalFollow, current.Follow),
+		Default: scale(signalDefault, current.Default),
+	}
+	return proposal
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProposeWeights(t *testing.T) {
+	current := types.ScoringWeights{Similar: 200, Follow: 20, Default: 1}
+	samples := map[string]int64{signalSimilar: 100, signalFollow: 100, signalDefault: 10}
+	engaged := map[string]int64{signalSimilar: 40, signalFollow: 10, signalDefault: 5}
+
+	proposal := proposeWeights(current, samples, engaged, 0.2, 50)
+	assert.Equal(t, current, proposal.Current)
+	// overall rate is 55/210, similar performs better and is capped at +20%
+	assert.InDelta(t, 240, proposal.Proposed.Similar, 0.001)
+	// follow performs worse and is capped at -20%
+	assert.InDelta(t, 16, proposal.Proposed.Follow, 0.001)
+	// too few samples to tell
+	assert.Equal(t, 1.0, proposal.Proposed.Default)
+	assert.Equal(t, 0.4, proposal.Rates[signalSimilar])
+
+	// nothing to learn without engagement
+	proposal = proposeWeights(current, samples, map[string]int64{}, 0.2, 50)
+	assert.Equal(t, current, proposal.Proposed)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dyng/nosdaily/alert"
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type walRecord struct {
+	Relay string       `json:"relay"`
+	Event *nostr.Event `json:"event"`
+}
+
+// logEvent appends the event to the write-ahead log and returns the function
+// acknowledging it, or nil if the event isn't logged
+func (s *Service) logEvent(event *nostr.Event, relay string) func(error) {
+	if s.wal == nil {
+		return nil
+	}
+
+	record, err := json.Marshal(walRecord{Relay: relay, Event: event})
+	if err != nil {
+		logger.Error("Failed to encode WAL record", "id", event.ID, "err", err)
+		return nil
+	}
+
+	seg, err := s.wal.Append(record)
+	if err != nil {
+		logger.Error("Failed to append to WAL", "id", event.ID, "err", err)
+		return nil
+	}
+
+	return func(err error) {
+		s.wal.Ack(seg, err)
+	}
+}
+
+// deadLetterWAL moves a WAL record that kept failing to be replayed to the
+// dead-letter file of the retry queue
+func (s *Service) deadLetterWAL(line []byte, cause error) error {
+	var record walRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return err
+	}
+	logger.Error("WAL record failed to be replayed, moved to dead letters", "id", record.Event.ID, "err", cause)
+	metrics.NewCounter("wal/deadlettered").Inc(1)
+
+	item := retryItem{Relay: record.Relay, Event: record.Event, Attempts: s.config.Retry.MaxAttempts, Next: time.Now(), Error: cause.Error()}
+	return appendItem(deadLetterFile(s.config.Retry, s.config.Objects.Root), item)
+}
+
+// startWALReplayer periodically replays segments holding events that failed
+// to be written, as soon as the database is reachable again
+func (s *Service) startWALReplayer(ctx context.Context) {
+	interval, err := time.ParseDuration(s.config.WAL.ReplayInterval)
+	if err != nil {
+		logger.Error("Invalid WAL replay interval, fallback to 30s", "interval", s.config.WAL.ReplayInterval, "err", err)
+		interval = 30 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.replayWAL(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *Service) replayWAL(ctx context.Context) {
+	// seal the active segment so that it's released once acknowledged
+	s.wal.Rotate()
+
+	pending, err := s.wal.Pending()
+	if err != nil {
+		logger.Error("Failed to list WAL segments", "err", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := s.repo.Ping(ctx); err != nil {
+		logger.Warn("Database unavailable, postpone WAL replay", "segments", len(pending), "err", err)
+		if !s.dbDown {
+			s.dbDown = true
+			s.alerter.Notify(ctx, alert.SeverityCritical, "database unavailable", fmt.Sprintf("%d WAL segments waiting for replay: %v", len(pending), err))
+		}
+		return
+	}
+	if s.dbDown {
+		s.dbDown = false
+		s.alerter.Notify(ctx, alert.SeverityInfo, "database recovered", fmt.Sprintf("replaying %d WAL segments", len(pending)))
+	}
+
+	for _, name := range pending {
+		total, err := s.wal.Replay(name, func(line []byte) error {
+			var record walRecord
+			if err := json.Unmarshal(line, &record); err != nil {
+				logger.Warn("Skip malformed WAL record", "segment", name, "err", err)
+				return nil
+			}
+			err := s.storeEventFromRelay(context.Background(), record.Event, record.Relay)
+			// the retry queue takes over failing records, so that one
+			// doesn't hold back the whole segment
+			if err != nil && s.retries != nil && s.retries.add(record.Event, record.Relay, err, time.Now()) {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			logger.Error("Failed to replay WAL segment", "segment", name, "replayed", total, "err", err)
+			return
+		}
+		logger.Info("Replayed WAL segment", "segment", name, "events", total)
+	}
+}
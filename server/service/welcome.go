@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"time"
+)
+
+// QueueWelcome records that the subscriber is waiting to be welcomed, so
+// that onboarding resumes after a restart
+func (s *Service) QueueWelcome(ctx context.Context, pubkey string, queuedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.QueueWelcome(ctx, pubkey, queuedAt)
+}
+
+// MarkWelcomed takes the subscriber off the welcome queue
+func (s *Service) MarkWelcomed(ctx context.Context, pubkey string, welcomedAt time.Time) error {
+	defer s.subscribers.invalidate(pubkey)
+	return s.repo.MarkWelcomed(ctx, pubkey, welcomedAt)
+}
+
+// GetPendingWelcomes returns the subscribers waiting to be welcomed, in the
+// order they were queued
+func (s *Service) GetPendingWelcomes(ctx context.Context) ([]string, error) {
+	return s.repo.GetPendingWelcomes(ctx)
+}
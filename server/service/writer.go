@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var (
+	batchLatency = metrics.NewHistogram("writer/batch/latency")
+	batchSize    = metrics.NewHistogram("writer/batch/size")
+	flushGauge   = metrics.NewGauge("writer/flush/interval")
+)
+
+type pendingEvent struct {
+	event *nostr.Event
+	relay string
+	// called with the result once the event is written, may be nil
+	ack func(error)
+	// stored already, only recorded as seen on the relay
+	dup bool
+}
+
+// batchWriter buffers events per kind and hands them over in batches. A kind
+// is flushed as soon as its batch is full, everything else is flushed on a
+// timer whose interval adapts to the volume: it doubles when batches fill up
+// before the timer fires, and halves when the timer finds less than half a
+// batch of any kind.
+type batchWriter struct {
+	minInterval time.Duration
+	maxInterval time.Duration
+	defaultSize int
+	kindSizes   map[int]int
+	write       func(kind int, batch []pendingEvent) error
+
+	mu       sync.Mutex
+	pending  map[int][]pendingEvent
+	interval time.Duration
+	full     chan int
+}
+
+func newBatchWriter(config types.WriterConfig, write func(kind int, batch []pendingEvent) error) *batchWriter {
+	minInterval := parseDurationOr(config.MinFlushInterval, time.Second)
+	maxInterval := parseDurationOr(config.MaxFlushInterval, 30*time.Second)
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	kindSizes := map[int]int{}
+	for _, k := range config.KindBatchSizes {
+		if k.Size > 0 {
+			kindSizes[k.Kind] = k.Size
+		}
+	}
+
+	defaultSize := config.BatchSize
+	if defaultSize <= 0 {
+		defaultSize = 500
+	}
+
+	return &batchWriter{
+		minInterval: minInterval,
+		maxInterval: maxInterval,
+		defaultSize: defaultSize,
+		kindSizes:   kindSizes,
+		write:       write,
+		pending:     make(map[int][]pendingEvent),
+		interval:    minInterval,
+		full:        make(chan int, 64),
+	}
+}
+
+func (w *batchWriter) batchSize(kind int) int {
+	if size, ok := w.kindSizes[kind]; ok {
+		return size
+	}
+	return w.defaultSize
+}
+
+// Add queues an event, relay is where it was received from and may be empty
+func (w *batchWriter) Add(event *nostr.Event, relay string, ack func(error)) {
+	w.add(pendingEvent{event: event, relay: relay, ack: ack})
+}
+
+// AddSeen queues an event stored already, to be recorded as seen on relay
+func (w *batchWriter) AddSeen(event *nostr.Event, relay string) {
+	if relay != "" {
+		w.add(pendingEvent{event: event, relay: relay, dup: true})
+	}
+}
+
+func (w *batchWriter) add(p pendingEvent) {
+	event := p.event
+	w.mu.Lock()
+	w.pending[event.Kind] = append(w.pending[event.Kind], p)
+	full := len(w.pending[event.Kind]) == w.batchSize(event.Kind)
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.full <- event.Kind:
+		default:
+			// a flush is already queued
+		}
+	}
+}
+
+// Start flushes batches until ctx is done, remaining events are flushed
+// before returning
+func (w *batchWriter) Start(ctx context.Context) {
+	go func() {
+		timer := time.NewTimer(w.currentInterval())
+		defer timer.Stop()
+
+		for {
+			select {
+			case kind := <-w.full:
+				w.flushKind(kind)
+				w.adapt(true)
+			case <-timer.C:
+				w.adapt(false)
+				w.Flush()
+				timer.Reset(w.currentInterval())
+			case <-ctx.Done():
+				w.Flush()
+				return
+			}
+		}
+	}()
+}
+
+// Flush writes all pending events
+func (w *batchWriter) Flush() {
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[int][]pendingEvent)
+	w.mu.Unlock()
+
+	for kind, batch := range pending {
+		w.writeBatch(kind, batch)
+	}
+}
+
+func (w *batchWriter) flushKind(kind int) {
+	w.mu.Lock()
+	batch := w.pending[kind]
+	delete(w.pending, kind)
+	w.mu.Unlock()
+
+	w.writeBatch(kind, batch)
+}
+
+func (w *batchWriter) writeBatch(kind int, batch []pendingEvent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+	err := w.write(kind, batch)
+	batchLatency.Update(time.Since(start).Milliseconds())
+	batchSize.Update(int64(len(batch)))
+	if err != nil {
+		logger.Error("Failed to write batch", "kind", kind, "size", len(batch), "err", err)
+	}
+}
+
+// adapt grows the flush interval under load and shrinks it when volume is low
+func (w *batchWriter) adapt(loaded bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !loaded {
+		for kind, batch := range w.pending {
+			if len(batch) >= w.batchSize(kind)/2 {
+				return
+			}
+		}
+	}
+
+	if loaded {
+		w.interval *= 2
+	} else {
+		w.interval /= 2
+	}
+	if w.interval > w.maxInterval {
+		w.interval = w.maxInterval
+	}
+	if w.interval < w.minInterval {
+		w.interval = w.minInterval
+	}
+	flushGauge.Update(w.interval.Milliseconds())
+}
+
+func (w *batchWriter) currentInterval() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.interval
+}
+
+// writeBatch stores a batch of events of the same kind
+func (s *Service) writeBatch(kind int, batch []pendingEvent) error {
+	events := make([]*nostr.Event, 0, len(batch))
+	for _, p := range batch {
+		if !p.dup {
+			events = append(events, p.event)
+		}
+	}
+	stored := s.storeEvents(events)
+
+	errs := make([]error, len(batch))
+	seen := []map[string]any{}
+	for i, p := range batch {
+		if !p.dup {
+			errs[i], stored = stored[0], stored[1:]
+		}
+		if errs[i] == nil {
+			seen = append(seen, seenOn(p.event, p.relay)...)
+		}
+	}
+	if err := s.recordSeenOn(context.Background(), seen); err != nil {
+		for i := range errs {
+			if errs[i] == nil && batch[i].relay != "" {
+				errs[i] = err
+			}
+		}
+	}
+
+	var lastErr error
+	for i, p := range batch {
+		if p.ack != nil {
+			p.ack(errs[i])
+		}
+		if errs[i] != nil {
+			lastErr = fmt.Errorf("failed to store event %s: %w", p.event.ID, errs[i])
+		}
+	}
+	return lastErr
+}
+
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchWriterKindSizes(t *testing.T) {
+	var mu sync.Mutex
+	written := map[int]int{}
+	w := newBatchWriter(types.WriterConfig{
+		MinFlushInterval: "1s",
+		MaxFlushInterval: "4s",
+		BatchSize:        10,
+		KindBatchSizes:   []types.KindBatchSize{{Kind: 7, Size: 2}},
+	}, func(kind int, batch []pendingEvent) error {
+		mu.Lock()
+		defer mu.Unlock()
+		written[kind] += len(batch)
+		return nil
+	})
+
+	assert.Equal(t, 2, w.batchSize(7))
+	assert.Equal(t, 10, w.batchSize(1))
+
+	w.Add(&nostr.Event{Kind: 7}, "", nil)
+	w.Add(&nostr.Event{Kind: 7}, "", nil)
+	w.Add(&nostr.Event{Kind: 1}, "wss://relay", nil)
+
+	assert.Equal(t, 7, <-w.full)
+	w.flushKind(7)
+	assert.Equal(t, 2, written[7])
+	assert.Equal(t, 0, written[1])
+
+	w.Flush()
+	assert.Equal(t, 1, written[1])
+}
+
+func TestBatchWriterAdapt(t *testing.T) {
+	w := newBatchWriter(types.WriterConfig{
+		MinFlushInterval: "1s",
+		MaxFlushInterval: "4s",
+		BatchSize:        4,
+	}, func(kind int, batch []pendingEvent) error { return nil })
+
+	w.adapt(true)
+	w.adapt(true)
+	w.adapt(true)
+	assert.Equal(t, 4*time.Second, w.currentInterval())
+
+	// half a batch pending keeps the interval
+	w.Add(&nostr.Event{Kind: 1}, "", nil)
+	w.Add(&nostr.Event{Kind: 1}, "", nil)
+	w.adapt(false)
+	assert.Equal(t, 4*time.Second, w.currentInterval())
+
+	w.Flush()
+	w.adapt(false)
+	assert.Equal(t, 2*time.Second, w.currentInterval())
+	w.adapt(false)
+	w.adapt(false)
+	assert.Equal(t, time.Second, w.currentInterval())
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/neo4j/neo4j-go-driver/v5/neo4j"
+)
+
+// zapPair are two users who zapped each other at least MinZaps times each way
+type zapPair struct {
+	a, b string
+}
+
+// DetectZapRings finds small groups of users who repeatedly zap each other,
+// flags the zaps exchanged within each group so that scoring discounts them,
+// and records the groups for review. Previous detections are replaced.
+func (s *Service) DetectZapRings(now time.Time) ([]types.ZapRing, error) {
+	conf := s.config.ZapRings
+	since := now.Add(-parseDurationOr(conf.Lookback, 30*24*time.Hour))
+
+	rings, err := s.neo4j.ExecuteWrite(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (z:Post)-[l:ZAP]->(t:Post)
+			WHERE z.created_at >= $Since AND l.sender IS NOT NULL AND l.sender <> t.author
+			WITH l.sender AS a, t.author AS b, count(l) AS zaps
+			WHERE zaps >= $MinZaps AND a < b
+			MATCH (z:Post)-[l:ZAP]->(:Post {author: a})
+			WHERE z.created_at >= $Since AND l.sender = b
+			WITH a, b, count(l) AS back
+			WHERE back >= $MinZaps
+			RETURN a, b;
+		`
+		result, err := tx.Run(ctx, query,
+			map[string]any{
+				"Since":   since.Unix(),
+				"MinZaps": conf.MinZaps,
+			})
+		if err != nil {
+			return nil, err
+		}
+
+		pairs := []zapPair{}
+		for result.Next(ctx) {
+			record := result.Record()
+			pairs = append(pairs, zapPair{record.Values[0].(string), record.Values[1].(string)})
+		}
+
+		if _, err := tx.Run(ctx, "MATCH ()-[l:ZAP]->() WHERE l.ring SET l.ring = false;", nil); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Run(ctx, "MATCH (r:ZapRing) DELETE r;", nil); err != nil {
+			return nil, err
+		}
+
+		rings := []types.ZapRing{}
+		for _, members := range groupRings(pairs, conf.MaxRingSize) {
+			query := `
+				MATCH (z:Post)-[l:ZAP]->(t:Post)
+				WHERE z.created_at >= $Since AND l.sender IN $Members AND t.author IN $Members AND l.sender <> t.author
+				SET l.ring = true
+				WITH count(l) AS zaps
+				CREATE (:ZapRing {id: $Id, members: $Members, zaps: zaps, detected_at: $Now})
+				RETURN zaps;
+			`
+			ring := types.ZapRing{Id: ringId(members), Members: members, DetectedAt: now}
+			result, err := tx.Run(ctx, query,
+				map[string]any{
+					"Since":   since.Unix(),
+					"Members": members,
+					"Id":      ring.Id,
+					"Now":     now.Unix(),
+				})
+			if err != nil {
+				return nil, err
+			}
+			record, err := result.Single(ctx)
+			if err != nil {
+				return nil, err
+			}
+			ring.Zaps = record.Values[0].(int64)
+			rings = append(rings, ring)
+		}
+		return rings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Detected zap rings", "rings", len(rings.([]types.ZapRing)))
+	return rings.([]types.ZapRing), nil
+}
+
+// GetZapRings returns the rings found by the last detection
+func (s *Service) GetZapRings() ([]types.ZapRing, error) {
+	rings, err := s.neo4j.ExecuteRead(context.Background(), func(ctx context.Context, tx neo4j.ManagedTransaction) (any, error) {
+		query := `
+			MATCH (r:ZapRing)
+			RETURN r.id, r.members, r.zaps, r.detected_at
+			ORDER BY r.zaps DESC;
+		`
+		result, err := tx.Run(ctx, query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		rings := []types.ZapRing{}
+		for result.Next(ctx) {
+			record := result.Record()
+			members := []string{}
+			for _, m := range record.Values[1].([]any) {
+				members = append(members, m.(string))
+			}
+			rings = append(rings, types.ZapRing{
+				Id:         record.Values[0].(string),
+				Members:    members,
+				Zaps:       record.Values[2].(int64),
+				DetectedAt: time.Unix(record.Values[3].(int64), 0),
+			})
+		}
+		return rings, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rings.([]types.ZapRing), nil
+}
+
+func (s *Service) startZapRingDetector(ctx context.Context) {
+	interval := parseDurationOr(s.config.ZapRings.Interval, 24*time.Hour)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if _, err := s.DetectZapRings(now); err != nil {
+					logger.Error("Failed to detect zap rings", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// groupRings joins users linked by reciprocal zaps into groups, groups larger
+// than maxSize are left out
+func groupRings(pairs []zapPair, maxSize int) [][]string {
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(u string) string {
+		if parent[u] == u {
+			return u
+		}
+		parent[u] = find(parent[u])
+		return parent[u]
+	}
+
+	for _, p := range pairs {
+		for _, u := range []string{p.a, p.b} {
+			if _, ok := parent[u]; !ok {
+				parent[u] = u
+			}
+		}
+		parent[find(p.a)] = find(p.b)
+	}
+
+	groups := map[string][]string{}
+	for u := range parent {
+		root := find(u)
+		groups[root] = append(groups[root], u)
+	}
+
+	rings := [][]string{}
+	for _, members := range groups {
+		if maxSize > 0 && len(members) > maxSize {
+			continue
+		}
+		sort.Strings(members)
+		rings = append(rings, members)
+	}
+	sort.Slice(rings, func(i, j int) bool {
+		return rings[i][0] < rings[j][0]
+	})
+	return rings
+}
+
+func ringId(members []string) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(members, ",")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupRings(t *testing.T) {
+	pairs := []zapPair{
+		{"a", "b"}, {"b", "c"},
+		{"x", "y"},
+		{"m1", "m2"}, {"m2", "m3"}, {"m3", "m4"},
+	}
+
+	rings := groupRings(pairs, 3)
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"x", "y"}}, rings)
+
+	assert.Len(t, groupRings(pairs, 0), 3)
+	assert.Empty(t, groupRings(nil, 3))
+	assert.Equal(t, ringId([]string{"a", "b", "c"}), ringId(rings[0]))
+}
@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dyng/nosdaily/metrics"
+	"github.com/dyng/nosdaily/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var logger = log.New("module", "supervisor")
+
+// Incident is a component stopping unexpectedly
+type Incident struct {
+	Component string    `json:"component"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+	// restarts of the component in a row, zero if it's not restarted
+	Restarts int    `json:"restarts"`
+	Backoff  string `json:"backoff,omitempty"`
+}
+
+// Supervisor runs long-lived components and restarts them with backoff when
+// they stop before their context is done, instead of letting them die
+// silently. Every stop is recorded as an incident.
+type Supervisor struct {
+	initial time.Duration
+	max     time.Duration
+	stable  time.Duration
+	history int
+
+	mu         sync.Mutex
+	incidents  []Incident
+	onIncident func(Incident)
+}
+
+func New(conf types.SupervisorConfig) *Supervisor {
+	history := conf.History
+	if history <= 0 {
+		history = 100
+	}
+	return &Supervisor{
+		initial: parseDurationOr(conf.InitialBackoff, time.Second),
+		max:     parseDurationOr(conf.MaxBackoff, 5*time.Minute),
+		stable:  parseDurationOr(conf.StableAfter, 10*time.Minute),
+		history: history,
+	}
+}
+
+// OnIncident registers a handler called on every incident
+func (s *Supervisor) OnIncident(handler func(Incident)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onIncident = handler
+}
+
+// Incidents returns the recent incidents, oldest first
+func (s *Supervisor) Incidents() []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Incident{}, s.incidents...)
+}
+
+// Go runs the component until ctx is done, restarting it whenever it returns
+// or panics. The returned channel is closed once it stopped for good.
+func (s *Supervisor) Go(ctx context.Context, name string, run func(ctx context.Context) error) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		backoff := s.initial
+		restarts := 0
+		for {
+			started := time.Now()
+			err := runSafely(ctx, run)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if time.Since(started) >= s.stable {
+				backoff, restarts = s.initial, 0
+			}
+			restarts++
+			reason := "stopped"
+			if err != nil {
+				reason = err.Error()
+			}
+			s.Record(Incident{Component: name, Reason: reason, At: time.Now(), Restarts: restarts, Backoff: backoff.String()})
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > s.max {
+				backoff = s.max
+			}
+		}
+	}()
+	return done
+}
+
+// Record records an incident, e.g. a job that panicked without stopping its
+// component
+func (s *Supervisor) Record(incident Incident) {
+	logger.Error("component stopped unexpectedly", "component", incident.Component, "reason", incident.Reason, "restarts", incident.Restarts, "backoff", incident.Backoff)
+	metrics.NewCounter("supervisor/incidents").Inc(1)
+
+	s.mu.Lock()
+	s.incidents = append(s.incidents, incident)
+	if len(s.incidents) > s.history {
+		s.incidents = s.incidents[len(s.incidents)-s.history:]
+	}
+	handler := s.onIncident
+	s.mu.Unlock()
+
+	if handler != nil {
+		handler(incident)
+	}
+}
+
+func runSafely(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+func parseDurationOr(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
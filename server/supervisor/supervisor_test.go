@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyng/nosdaily/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGo(t *testing.T) {
+	s := New(types.SupervisorConfig{InitialBackoff: "1ms", MaxBackoff: "2ms", StableAfter: "1h"})
+	var incidents []Incident
+	s.OnIncident(func(incident Incident) { incidents = append(incidents, incident) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	done := s.Go(ctx, "component", func(ctx context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			panic("boom")
+		case 3:
+			return nil
+		}
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("component not stopped")
+	}
+	assert.Equal(t, 4, runs)
+
+	// stopping on ctx done isn't an incident
+	assert.Equal(t, s.Incidents(), incidents)
+	if assert.Len(t, incidents, 3) {
+		assert.Equal(t, "component", incidents[0].Component)
+		assert.Equal(t, "failed", incidents[0].Reason)
+		assert.Equal(t, "panic: boom", incidents[1].Reason)
+		assert.Equal(t, "stopped", incidents[2].Reason)
+		assert.Equal(t, []int{1, 2, 3}, []int{incidents[0].Restarts, incidents[1].Restarts, incidents[2].Restarts})
+		assert.Equal(t, []string{"1ms", "2ms", "2ms"}, []string{incidents[0].Backoff, incidents[1].Backoff, incidents[2].Backoff})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	s := New(types.SupervisorConfig{History: 2})
+	for _, reason := range []string{"a", "b", "c"} {
+		s.Record(Incident{Component: "cron", Reason: reason})
+	}
+
+	incidents := s.Incidents()
+	assert.Len(t, incidents, 2)
+	assert.Equal(t, "b", incidents[0].Reason)
+	assert.Equal(t, "c", incidents[1].Reason)
+}
@@ -1,9 +1,169 @@
 package types
 
 type BotConfig struct {
+	// secret key of the bot, or the 'bunker://<pubkey>?relay=...&secret=...'
+	// URI of a NIP-46 remote signer holding it
 	SK       string
+	Signer   RemoteSignerConfig
 	Relays   []string
 	Metadata MetadataConfig
+	// number of lowest-latency relays to publish digests to, at least 2, 0
+	// for all
+	PublishFanout int `default:"2"`
+	// publish everything to this relay only, e.g. a self-hosted strfry
+	// relaying to the others. Relays are still used for reading, and for
+	// publishing if the proxy is unreachable at startup.
+	PublishProxy string
+	// admission to relays requiring payment
+	Payments PaymentsConfig
+	// channels publishing under delegation of the master key
+	Delegation DelegationConfig
+	Welcome    WelcomeConfig
+	Notify     NotifyConfig
+	Trending   TrendingConfig
+	// channels publishing the top posts of a single topic
+	TopicChannels []TopicChannel
+	// weekly leaderboards for the dashboard and public notes
+	Leaderboard LeaderboardConfig
+	// subscriber-chosen channel names and pictures
+	Branding BrandingConfig
+	// handing channel keys over to subscribers
+	Custody CustodyConfig
+	// schedules of the digest and recap jobs, and jobs not run
+	Jobs JobsConfig
+}
+
+type JobsConfig struct {
+	// digests to subscribers, the main channel and the topic channels
+	// without a schedule of their own. Each run covers the time since the
+	// previous scheduled run.
+	Digest string `default:"0 * * * *"`
+	// recaps of the year so far
+	Recap string `default:"0 0 1 * *"`
+	// names of the jobs this deployment doesn't run: digest, recap, trending,
+	// topic/<topic>, leaderboard, tuning, nudge, retention, survey, churn or
+	// discovery
+	Disabled []string
+}
+
+type RemoteSignerConfig struct {
+	// key requests to the remote signer are sent with, as authorized by the
+	// signer. A new one is generated on each start if empty.
+	ClientSK string
+	// requests not answered within this fail
+	Timeout string `default:"30s"`
+}
+
+type PaymentsConfig struct {
+	// pay admission fees of relays requiring payment, otherwise such relays
+	// are not published to and the operator is notified
+	Enabled bool
+	// Nostr Wallet Connect URI of the wallet paying fees
+	NWC string
+	// total sats that may be spent on fees
+	Budget int64
+	// lightning addresses admission fees are paid to, as relays don't
+	// advertise how to get an invoice
+	Relays []PaidRelay
+	// file keeping the sats spent and relays admitted across restarts,
+	// defaults to payments/admissions.json under the objects root
+	File string
+}
+
+type WelcomeConfig struct {
+	// pause between onboarding two new subscribers, so that a burst of
+	// subscriptions doesn't get the bot rate limited by relays
+	Interval string `default:"3s"`
+	// failed onboardings are retried with exponential backoff up to this
+	MaxBackoff string `default:"5m"`
+	// give up until the next restart after this many failures
+	MaxAttempts int `default:"5"`
+}
+
+type DelegationConfig struct {
+	// sign channel digests with a NIP-26 delegation from SK, so that clients
+	// can tell channels belong to nossence and channel keys can be rotated
+	Enabled bool
+	// validity of delegation tokens, revoked channel keys keep publishing
+	// validly until their token expires
+	Validity string `default:"168h"`
+}
+
+type PaidRelay struct {
+	URL              string
+	LightningAddress string
+}
+
+type TopicChannel struct {
+	Topic string
+	SK    string
+	// profile of the channel, named e.g. "nossence-art" after the topic if
+	// empty
+	Name    string
+	About   string
+	Picture string
+	// cron schedule of the channel, published along with hourly digests if
+	// empty
+	Schedule string
+	// posts created within this window are ranked, an hour if empty
+	Window string
+	// posts published each time, PushSize if 0
+	Size int
+}
+
+type TrendingConfig struct {
+	// publish posts gaining interactions fastest to a "rising now" channel
+	Enabled bool
+	// key of the trending channel
+	SK       string
+	Schedule string `default:"*/30 * * * *"`
+	// posts created within this window are ranked
+	Window string `default:"6h"`
+	Size   int    `default:"10"`
+}
+
+type CustodyConfig struct {
+	// let subscribers take the key of their channel into self-custody with
+	// '#claim', completed with '#confirmclaim' once they saved the key
+	Enabled bool
+	// claims not confirmed within this have to be made again
+	ConfirmWithin string `default:"24h"`
+}
+
+type BrandingConfig struct {
+	// let subscribers set the name and picture of their channel with
+	// '#brand'
+	Enabled bool
+	// only premium subscribers may brand their channel
+	PremiumOnly bool
+	// max length of a channel name in characters
+	MaxNameLength int `default:"32"`
+	// names containing these words, compared case-insensitively, are
+	// rejected
+	BlockedWords []string
+	// hosts pictures may be linked from, any https host if empty
+	PictureHosts []string
+}
+
+type LeaderboardConfig struct {
+	// aggregate weekly leaderboards of the most zapped posts, most
+	// appreciated new authors and top topics
+	Enabled  bool
+	Schedule string `default:"0 0 * * 1"`
+	// entries per leaderboard
+	Size int `default:"10"`
+	// authors whose first post is younger than this are considered new
+	NewAuthorAge string `default:"720h"`
+	// key publishing each leaderboard as a note, not published if empty
+	SK string
+}
+
+type NotifyConfig struct {
+	// notify authors when their post is featured in the public digest
+	Enabled bool
+	// "mention" or "dm"
+	Mode       string `default:"mention"`
+	MaxPerHour int    `default:"20"`
 }
 
 type MetadataConfig struct {
@@ -14,18 +174,194 @@ type MetadataConfig struct {
 	ChannelName    string `default:"nossence curator"`
 	ChannelAbout   string
 	ChannelPicture string
+	// NIP-89 handler information, not published if no kinds are given
+	HandlerIdentifier string `default:"nossence"`
+	HandlerKinds      []int
 }
 
 type CrawlerConfig struct {
 	Relays []string
 	Since  string `default:"-1h"`
 	Limit  int    `default:"0"`
+	// posts only seen on these relays are excluded from feeds
+	BlacklistRelays []string
+	Backfill        BackfillConfig
+	// kinds to crawl, defaults to all kinds the service stores, e.g. [30023]
+	// to run an instance of long-form articles only
+	Kinds []int
+	// only crawl events of these authors, as hex pubkeys or npubs, e.g. the
+	// members of a community
+	Authors []string
+	// only crawl events tagged with one of these hashtags, in any case.
+	// Events without hashtags, such as profiles and contact lists, are then
+	// left out.
+	Hashtags []string
+	// events crawled per second at most across all relays, 0 for no cap
+	RateLimit int
+	Expansion ExpansionConfig
+}
+
+type ExpansionConfig struct {
+	// periodically crawl the most-followed authors the seed relays don't
+	// cover from the relays they write to, growing coverage along the
+	// follow graph
+	Enabled  bool
+	Interval string `default:"6h"`
+	// authors added per round, most followed first
+	Batch int `default:"50"`
+	// authors with fewer followers aren't added
+	MinFollowers int `default:"10"`
+	// only followers whose contact list was updated within this are counted
+	Lookback string `default:"720h"`
+	// relays and authors crawled through expansion at most
+	MaxRelays  int `default:"20"`
+	MaxAuthors int `default:"1000"`
+}
+
+type BackfillConfig struct {
+	// page backward through the history of the crawled relays on first
+	// deployment, so that feeds can be ranked from the start
+	Enabled bool
+	// how far back, in the same format as Since
+	Since string `default:"-7d"`
+	// events requested per page
+	Limit int `default:"500"`
+	// pause between two pages of a relay
+	Pause string `default:"1s"`
+}
+
+type IngestConfig struct {
+	// accept signed events pushed to POST /v1/events by the sources below,
+	// e.g. partner relays or scrapers the crawler doesn't subscribe to
+	Enabled bool
+	Sources []IngestSource
+	// events accepted per request
+	MaxEvents int `default:"500"`
+	// events created further in the future are rejected
+	MaxClockSkew string `default:"15m"`
+}
+
+type EventLimitsConfig struct {
+	// enforce the limits below on crawled and ingested events, so that
+	// pathological events don't bloat the graph or digests
+	Enabled bool `default:"true"`
+	// bytes of content
+	MaxContent int `default:"65536"`
+	MaxTags    int `default:"2000"`
+	// bytes of the whole serialized event
+	MaxSize int `default:"262144"`
+}
+
+type IngestSource struct {
+	Name string
+	// sent by the source as a bearer token
+	Token string
+	// events of the source are recorded as seen on this relay, if any
+	Relay string
+}
+
+type RawEventsConfig struct {
+	// keep events of kinds not otherwise supported as raw :Event nodes, so
+	// that features added later can be backfilled without crawling again
+	Enabled bool
+	// additional kinds to crawl into the raw archive
+	Kinds []int
+}
+
+const (
+	DriverNeo4j  = "neo4j"
+	DriverSQLite = "sqlite"
+)
+
+type DatabaseConfig struct {
+	// neo4j, or sqlite for an embedded store serving feeds and subscriptions
+	// without the features relying on the graph
+	Driver string `default:"neo4j"`
+	// file of the sqlite database, nossence.db under the objects root if empty
+	Path string
+}
+
+type EnrichmentConfig struct {
+	// annotate posts of private digests with summaries, translations and
+	// link previews, fetched by a separate pool of workers
+	Enabled bool
+	Workers int `default:"4"`
+	// pending steps beyond this are dropped
+	QueueSize int `default:"200"`
+	// longest a digest waits for annotations, it's sent without those still
+	// missing, which are kept for the next digests
+	Wait     string `default:"2s"`
+	CacheTTL string `default:"24h"`
+	// OpenAI compatible chat completions API
+	Summary SummaryConfig
+	// LibreTranslate compatible API
+	Translation TranslationConfig
+	// title of the first link of posts
+	Unfurl EnrichmentLimits
+}
+
+// EnrichmentLimits keep a slow or failing API from using up the workers
+type EnrichmentLimits struct {
+	Enabled bool
+	Timeout string `default:"5s"`
+	// calls per hour, 0 for no limit
+	Budget int
+	// consecutive failures after which the step is skipped for
+	// BreakerCooldown
+	BreakerThreshold int    `default:"3"`
+	BreakerCooldown  string `default:"5m"`
+}
+
+type SummaryConfig struct {
+	Limits EnrichmentLimits
+	URL    string `default:"https://api.openai.com/v1/chat/completions"`
+	APIKey string
+	Model  string `default:"gpt-3.5-turbo"`
+	// shorter posts aren't summarized
+	MinLength int `default:"500"`
+}
+
+type TranslationConfig struct {
+	Limits EnrichmentLimits
+	URL    string
+	APIKey string
+	// language posts are translated to, unless written in it
+	Target string `default:"en"`
+}
+
+type HandoverConfig struct {
+	// hand the bot over between instances on rolling deploys: only the
+	// instance holding the bot lease listens to mentions and runs cron jobs
+	Enabled bool
+	// name of this instance, the hostname if empty
+	Instance string
+	LeaseTTL string `default:"30s"`
+	// how long a draining instance keeps handling events created before the
+	// handover, which relays may deliver late
+	DrainGrace string `default:"5s"`
+	// longest a drain waits for running cron jobs before giving up
+	DrainTimeout string `default:"2m"`
+	// longest shutdown waits for the crawler, events being stored and HTTP
+	// requests, each
+	ShutdownTimeout string `default:"30s"`
 }
 
 type Neo4jConfig struct {
 	Url      string
 	Username string
 	Password string
+	// transient errors of transactions are retried with exponential
+	// backoff, starting at RetryBackoff. Auto-commit queries aren't retried.
+	MaxRetries   int    `default:"5"`
+	RetryBackoff string `default:"500ms"`
+	// consecutive failures after which queries fail fast for BreakerCooldown
+	BreakerThreshold int    `default:"5"`
+	BreakerCooldown  string `default:"30s"`
+	// how often the connection is probed, and reopened when it's lost
+	ProbeInterval string `default:"10s"`
+	// queries taking longer are cancelled, on the server as well
+	ReadTimeout  string `default:"30s"`
+	WriteTimeout string `default:"1m"`
 }
 
 type LogConfig struct {
@@ -37,12 +373,560 @@ type LogConfig struct {
 
 type ObjectsConfig struct {
 	Root string `default:"/var/data/nossence"`
+	// gzip objects, uncompressed objects written before stay readable
+	Compress bool `default:"true"`
+	// keep the signed JSON of every stored event, e.g. profiles and contact
+	// lists, not only of posts and interactions
+	AllKinds bool `default:"true"`
+}
+
+type ArchiveConfig struct {
+	Enabled bool
+	// "file" or "s3"
+	Backend string `default:"file"`
+	// archive events "before" or "after" they are stored in neo4j
+	Stage         string `default:"before"`
+	SegmentSize   int    `default:"10000"`
+	FlushInterval string `default:"5m"`
+	// data older than this is served from the archive
+	HotWindow string `default:"168h"`
+	S3        S3Config
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string `default:"us-east-1"`
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+type PrivacyConfig struct {
+	// privacy budget of each published aggregate, 0 disables noise
+	Epsilon float64 `default:"1.0"`
+	// noisy counts below this are suppressed
+	MinCount int `default:"10"`
+	// published aggregates are computed once per period, so that the noise
+	// can't be averaged away by asking again
+	Period string `default:"1h"`
+}
+
+type LicensingConfig struct {
+	// license tag values whose authors disallow redistribution outside of
+	// nostr, compared case-insensitively
+	Restricted []string
+	// reuse policy of each output
+	Outputs []OutputPolicy
+}
+
+type OutputPolicy struct {
+	// "api", "digest", "trending", or the name a bridge queries feeds as
+	Output string
+	// "any" (default), "unrestricted" to drop restricted licenses, or
+	// "licensed" to only keep posts under a license not restricted
+	Policy string
+	// bearer token a bridge queries feeds as this output with, outputs
+	// without one can't be queried as
+	Token string
+}
+
+type CurationConfig struct {
+	Curators []CuratorConfig
+	// NIP-32 label curators use to boost a post
+	Namespace string `default:"nossence"`
+	Label     string `default:"boost"`
+}
+
+type CuratorConfig struct {
+	Pubkey string
+	// score added to a post boosted by this curator, defaults to 50
+	Weight float64
+}
+
+type TiersConfig struct {
+	Free    TierConfig
+	Premium TierConfig
+	// sats zapped to the bot to get premium for PremiumDays
+	PremiumPrice int64 `default:"5000"`
+	PremiumDays  int   `default:"30"`
+	// nostrPubkey of the LNURL server of the bot's lightning address, the
+	// only signer of zap receipts granting premium. No zap grants premium
+	// without it.
+	ZapperPubkey string
+}
+
+type TierConfig struct {
+	// number of posts in each digest
+	DigestSize int
+	// minimum time between two digests
+	MinInterval string
+	// 0 for the global feed, 1 for the feed personalized by the follow graph
+	PersonalizationDepth int
+	// requests per minute to the feed API
+	APIRateLimit int
+}
+
+type WriterConfig struct {
+	// buffer crawled events and write them to neo4j in batches
+	Enabled bool
+	// flush interval adapts between these bounds depending on volume
+	MinFlushInterval string `default:"1s"`
+	MaxFlushInterval string `default:"30s"`
+	// default batch size, overridden per kind by KindBatchSizes
+	BatchSize      int `default:"500"`
+	KindBatchSizes []KindBatchSize
+}
+
+type DedupConfig struct {
+	// drop events stored recently before they reach the database, relays
+	// deliver many events several times
+	Enabled bool `default:"true"`
+	// events remembered, the least recently seen are forgotten first
+	Size int `default:"100000"`
+}
+
+type SubscriberCacheConfig struct {
+	// cache subscriber lookups, which every mention and command does. Writes
+	// of this instance invalidate them, those of others show after TTL.
+	Enabled bool   `default:"true"`
+	TTL     string `default:"1m"`
+	// subscribers cached, the least recently looked up are evicted first
+	Size int `default:"10000"`
+}
+
+type WALConfig struct {
+	// log crawled events to local disk before writing them to neo4j
+	Enabled bool
+	// defaults to 'wal' under the objects root
+	Dir            string
+	SegmentSize    int    `default:"1000"`
+	ReplayInterval string `default:"30s"`
+}
+
+type RetryConfig struct {
+	// retry events failing to be stored with exponential backoff, events
+	// still failing after MaxAttempts go to the dead-letter file
+	Enabled        bool   `default:"true"`
+	MaxAttempts    int    `default:"8"`
+	InitialBackoff string `default:"1s"`
+	MaxBackoff     string `default:"10m"`
+	// events queued at most, further failures are left to the WAL if enabled
+	QueueSize int `default:"10000"`
+	// default to 'retry/queue.jsonl' and 'retry/deadletter.jsonl' under the
+	// objects root
+	File           string
+	DeadLetterFile string
+}
+
+type KindBatchSize struct {
+	Kind int
+	Size int
+}
+
+type DigestConfig struct {
+	// widen the window when it has fewer candidates than this, defaults to
+	// the digest size
+	MinCandidates int
+	// the window is doubled at most up to this
+	MaxWindow string `default:"24h"`
+	// runs missed during downtime are "skip"ped, sent as one "combined"
+	// digest, or "all" sent one by one
+	CatchUp string `default:"skip"`
+	// max posts of a single author in a digest, 0 for no limit
+	MaxPerAuthor int `default:"2"`
+	Languages    LanguageConfig
+	// digests of a batch pushed concurrently
+	Workers int `default:"4"`
+	// min gap between starting two digests, so that relays don't rate
+	// limit the bot
+	Pace     string `default:"100ms"`
+	Feedback DigestFeedbackConfig
+}
+
+type DigestFeedbackConfig struct {
+	// end channel digests with a note asking to rate them, reactions and
+	// replies to it are recorded as explicit feedback. Ratings only feed the
+	// weight tuner, nossence doesn't A/B test rankings.
+	Enabled bool
+	// replies are rated from 1 to Scale, likes count as Scale and dislikes
+	// as 1
+	Scale int `default:"5"`
+	// reactions and replies later than this after the digest are ignored
+	Window string `default:"72h"`
+}
+
+type LanguageConfig struct {
+	// balance languages of the public digest
+	Enabled bool
+	// max share of the digest for languages without a quota
+	DefaultQuota float64 `default:"0.5"`
+	Quotas       []LanguageQuota
+}
+
+type LanguageQuota struct {
+	// ISO-639-1 code, or 'und' for undetermined Latin script posts
+	Language string
+	// max share of the digest
+	Quota float64
+	// score multiplier within the language's ranking
+	Weight float64
+}
+
+type ScoringConfig struct {
+	// weight of each relation a user engages a post with
+	ReplyWeight  float64 `default:"15"`
+	LikeWeight   float64 `default:"10"`
+	RepostWeight float64 `default:"20"`
+	ZapWeight    float64 `default:"50"`
+	// subtracted for downvote-style reactions, e.g. "-" or 👎
+	DislikeWeight float64 `default:"10"`
+	// added for each user pinning a post in their NIP-51 pin list, weighed by
+	// their reputation if enabled
+	PinWeight float64 `default:"30"`
+	// zaps weigh ZapWeight * (1 + ZapAmountScale * log10(1 + sats))
+	ZapAmountScale float64
+	// scores decay by exp(-RecencyDecay * age in hours)
+	RecencyDecay float64
+	// alternatively, the age at which a score is halved, e.g. "6h". It takes
+	// precedence over RecencyDecay.
+	RecencyHalfLife string
+	// multipliers by the relation of the engaging user to the subscriber,
+	// SimilarWeight is multiplied by the similarity score
+	SimilarWeight float64 `default:"200"`
+	FollowWeight  float64 `default:"20"`
+	DefaultWeight float64 `default:"1"`
+	// personalized feeds boost posts by 1 + FollowBoost * log2(1 + n), where
+	// n counts the author and engagers the subscriber follows, and likewise
+	// with SecondHopBoost for accounts followed by those
+	FollowBoost    float64 `default:"1"`
+	SecondHopBoost float64 `default:"0.25"`
+	// posts scoring below MinScore or with fewer than MinContentLength
+	// characters are never returned, 0 to disable
+	MinScore         float64
+	MinContentLength int
+	// keep the engagement score of recent posts on the posts, updated as
+	// engagement is stored, and rank feeds that aren't personalized by it
+	// instead of aggregating engagement on every request. Subscriber digests
+	// are personalized, so they're always ranked by the live query.
+	Materialized bool
+	// how often posts with new engagement are rescored
+	MaterializeInterval string `default:"1m"`
+	// posts created within this period are kept scored
+	MaterializeWindow string `default:"48h"`
+}
+
+type ReputationConfig struct {
+	// rank users by PageRank over the follow graph and weigh their
+	// engagement by it
+	Enabled    bool
+	Interval   string  `default:"24h"`
+	Damping    float64 `default:"0.85"`
+	Iterations int     `default:"20"`
+}
+
+type AuthorProfileConfig struct {
+	// profile authors by the dominant content types and topics of their
+	// recent posts, so that subscribers can ask for more or less of them
+	Enabled  bool
+	Interval string `default:"24h"`
+	// posts within this period are profiled
+	Lookback string `default:"720h"`
+	// authors with fewer posts within the period aren't profiled
+	MinPosts int `default:"5"`
+	// content types below this share of an author's posts aren't dominant
+	MinShare float64 `default:"0.3"`
+	// topics kept per author
+	Topics int `default:"5"`
+	// score multipliers of authors of content types a subscriber wants more
+	// or less of
+	Boost   float64 `default:"1.5"`
+	Penalty float64 `default:"0.5"`
+	// score boost per topic of an author matching the interests of a
+	// subscriber following nobody yet
+	ColdStartBoost float64 `default:"0.5"`
+}
+
+type ZapRingConfig struct {
+	// detect small groups zapping each other and discount their zaps
+	Enabled  bool
+	Interval string `default:"24h"`
+	// zaps within this period are examined
+	Lookback string `default:"720h"`
+	// zaps each way for two users to be linked
+	MinZaps int `default:"3"`
+	// larger groups of linked users are considered a community, not a ring
+	MaxRingSize int `default:"8"`
+	// multiplier applied to the weight of zaps within a ring
+	Discount float64 `default:"0.1"`
+}
+
+type TuningConfig struct {
+	// propose scoring weight adjustments from engagement feedback
+	Enabled  bool
+	Schedule string `default:"0 4 * * 1"`
+	// delivered posts within this period are evaluated
+	Lookback string `default:"168h"`
+	// max relative change of a weight per proposal
+	MaxStep float64 `default:"0.2"`
+	// signals with fewer delivered posts are left untouched
+	MinSamples int `default:"50"`
+}
+
+type SafeModeConfig struct {
+	// start in safe mode after this many runs in a row exited before
+	// running for StableAfter, 0 disables
+	CrashThreshold int    `default:"3"`
+	StableAfter    string `default:"10m"`
+	// file counting the runs that crashed, under Objects.Root if empty
+	StateFile string
+}
+
+type SupervisorConfig struct {
+	// components stopping unexpectedly, e.g. the bot listener, the cron
+	// scheduler or a relay connection, are restarted after a backoff that
+	// doubles up to MaxBackoff
+	InitialBackoff string `default:"1s"`
+	MaxBackoff     string `default:"5m"`
+	// a component running this long before stopping restarts from the
+	// initial backoff
+	StableAfter string `default:"10m"`
+	// incidents kept for the /incidents endpoint
+	History int `default:"100"`
+}
+
+type AdminConfig struct {
+	// bearer token of the admin API. Without one, admin endpoints only
+	// answer requests from localhost.
+	Token string
+	// passphrase encrypting the channel secrets of exported subscribers,
+	// shared by the deployments exchanging them. Subscribers can't be
+	// exported or imported without it.
+	ExportKey string
+}
+
+type OperatorConfig struct {
+	Transports []TransportConfig
+}
+
+type TransportConfig struct {
+	// "nostr", "webhook", "email" or "stdout"
+	Type string
+	// alerts below "info", "warning" or "critical" are not sent
+	MinSeverity string
+	// nostr: pubkey receiving direct messages
+	Pubkey string
+	// webhook: endpoint receiving POSTed JSON
+	URL string
+	// email: SMTP server as host:port
+	SMTPHost     string
+	SMTPUser     string
+	SMTPPassword string
+	From         string
+	To           []string
+}
+
+type PriorityConfig struct {
+	// store events of authors followed by subscribers ahead of the firehose
+	Enabled bool
+	// events beyond this queue length take the regular path
+	QueueSize int `default:"1000"`
+	Workers   int `default:"2"`
+	// how often the set of followed authors is reloaded
+	RefreshInterval string `default:"5m"`
+}
+
+type NudgeConfig struct {
+	// remind subscribers who don't follow their channel
+	Enabled  bool
+	Schedule string `default:"0 12 * * *"`
+	// minimum time between two reminders
+	Interval     string `default:"168h"`
+	MaxReminders int    `default:"3"`
+}
+
+type SurveyConfig struct {
+	// periodically ask subscribers to rate the digest by direct message
+	Enabled  bool
+	Schedule string `default:"0 18 * * 0"`
+	// minimum time between two surveys of a subscriber
+	Interval string `default:"720h"`
+	// answers are rated from 1 to Scale
+	Scale int `default:"5"`
+	// replies later than this after the survey are ignored
+	AnswerWindow string `default:"72h"`
+}
+
+type RecapConfig struct {
+	// "year so far" recaps published to the channel of each subscriber, on
+	// the schedule of Bot.Jobs.Recap
+	Enabled bool
+}
+
+type DiscoveryConfig struct {
+	// monthly digest of the authors whose engagement grows fastest among
+	// those each subscriber doesn't follow yet
+	Enabled  bool
+	Schedule string `default:"0 12 1 * *"`
+	// engagement received within the last Period is compared to the one
+	// before
+	Period string `default:"720h"`
+	// authors featured in each digest
+	Size int `default:"5"`
+	// authors engaged with by fewer users within the period are left out,
+	// so that a few likes aren't a rise
+	MinEngagement int `default:"20"`
+	// reputation a user needs for its engagement to count, if reputation is
+	// enabled, so that throwaway keys can't make an author rise
+	MinReputation float64 `default:"1"`
+}
+
+type ChurnConfig struct {
+	// track when subscribers were last seen active and predict who is about
+	// to leave
+	Enabled  bool
+	Schedule string `default:"0 6 * * *"`
+	// subscribers idle for this long are at full risk
+	Horizon string `default:"720h"`
+	// digests of subscribers at or above this risk are sent SlowdownFactor
+	// times less often
+	SlowdownRisk   float64 `default:"0.5"`
+	SlowdownFactor float64 `default:"2"`
+	// subscribers at or above this risk are sent a re-engagement message, at
+	// most once per ReengageInterval
+	ReengageRisk     float64 `default:"0.8"`
+	ReengageInterval string  `default:"720h"`
+}
+
+type DeletionConfig struct {
+	// posts deleted by their author are marked deleted and kept out of feeds,
+	// or removed with their relations if Hard
+	Hard bool
+}
+
+type RetentionConfig struct {
+	// prune engagement relations older than the TTL of their type, so that
+	// the graph doesn't grow forever. Pruned events can still be replayed
+	// from the archive.
+	Enabled  bool
+	Schedule string `default:"0 4 * * *"`
+	// TTLs by relation type, empty to keep forever. Follows and zaps are
+	// stronger signals and kept longer, follows only age once superseded by
+	// a newer contact list.
+	Like   string `default:"720h"`
+	Reply  string `default:"720h"`
+	Repost string `default:"720h"`
+	Report string `default:"720h"`
+	Zap    string `default:"2160h"`
+	Follow string `default:"8760h"`
+	// posts older than this are deleted with their relations, and so are
+	// users left without any, empty to keep forever. Pruning doesn't archive
+	// posts, only those stored while the archive was enabled can be replayed.
+	Posts string
+	// relations or users deleted per transaction, must be positive
+	BatchSize int `default:"10000"`
+	// posts deleted per transaction along with all their relations, must be
+	// positive
+	PostBatchSize int `default:"500"`
+}
+
+type ModerationConfig struct {
+	// posts reported (NIP-56) by at least this many distinct users are
+	// penalized, 0 disables
+	ReportThreshold int `default:"3"`
+	// reputation a user needs for its reports to count. Users without
+	// reputation never count, so reports are ignored unless reputation is
+	// enabled.
+	MinReporterReputation float64 `default:"1"`
+	// score multiplier of posts over the threshold, 0 excludes them
+	ReportPenalty float64 `default:"0"`
+	// posts matching any of these rules are left out of feeds
+	Keywords []KeywordRule
+}
+
+type KeywordRule struct {
+	// name of the rule in metrics
+	Name string
+	// words matched case-insensitively as whole words
+	Words []string
+	// regular expressions matched against the content, e.g. for languages
+	// not separating words with spaces
+	Patterns []string
+	// ISO-639-1 codes, or 'und', of the posts the rule applies to, all if
+	// empty
+	Languages []string
+}
+
+type ProfilingConfig struct {
+	// periodically PROFILE hot queries and alert when their plans regress
+	Enabled  bool
+	Interval string `default:"1h"`
+	// db hits growing beyond this multiple of the first profile are reported
+	RegressionFactor float64 `default:"2"`
+}
+
+type ScoreDriftConfig struct {
+	// periodically sample feed scores and alert when their distribution
+	// shifts abruptly, e.g. on relay outages, spam waves or scoring bugs
+	Enabled  bool
+	Interval string `default:"1h"`
+	// posts of the last window sampled, top scored first
+	Window string `default:"1h"`
+	Sample int    `default:"500"`
+	// number of past samples the baseline is the median of
+	History int `default:"24"`
+	// relative change from the baseline reported as drift
+	Threshold float64 `default:"0.5"`
+}
+
+type ShardingConfig struct {
+	// total number of shards subscribers are split into
+	Shards int `default:"1"`
+	// shards handled by this instance, all if empty
+	Serve []int
 }
 
 type Config struct {
-	Log     LogConfig
-	Neo4j   Neo4jConfig
-	Crawler CrawlerConfig
-	Objects ObjectsConfig
-	Bot     BotConfig
+	Log         LogConfig
+	Database    DatabaseConfig
+	Neo4j       Neo4jConfig
+	Handover    HandoverConfig
+	Crawler     CrawlerConfig
+	RawEvents   RawEventsConfig
+	Ingest      IngestConfig
+	Limits      EventLimitsConfig
+	Deletion    DeletionConfig
+	Moderation  ModerationConfig
+	Retention   RetentionConfig
+	Objects     ObjectsConfig
+	Writer      WriterConfig
+	Dedup       DedupConfig
+	Subscribers SubscriberCacheConfig
+	Priority    PriorityConfig
+	WAL         WALConfig
+	Retry       RetryConfig
+	Archive     ArchiveConfig
+	Privacy     PrivacyConfig
+	Curation    CurationConfig
+	Licensing   LicensingConfig
+	Tiers       TiersConfig
+	Digest      DigestConfig
+	Scoring     ScoringConfig
+	ZapRings    ZapRingConfig
+	Reputation  ReputationConfig
+	Authors     AuthorProfileConfig
+	Tuning      TuningConfig
+	Nudge       NudgeConfig
+	Survey      SurveyConfig
+	Churn       ChurnConfig
+	Discovery   DiscoveryConfig
+	Recap       RecapConfig
+	Enrichment  EnrichmentConfig
+	Operator    OperatorConfig
+	Admin       AdminConfig
+	SafeMode    SafeModeConfig
+	Supervisor  SupervisorConfig
+	Profiling   ProfilingConfig
+	ScoreDrift  ScoreDriftConfig
+	Sharding    ShardingConfig
+	Bot         BotConfig
 }
@@ -1,12 +1,74 @@
 package types
 
-import "time"
+import (
+	"hash/fnv"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	TierFree    = "free"
+	TierPremium = "premium"
+)
 
 type Subscriber struct {
 	Pubkey         string
 	ChannelSecret  string
 	SubscribedAt   *time.Time
 	UnsubscribedAt *time.Time
+	Tier           string
+	TierExpiresAt  *time.Time
+	LastPushedAt   *time.Time
+	// stable hash of the pubkey used to assign the subscriber to a shard
+	ShardKey uint64
+	// reminders sent to follow the channel
+	FollowReminders int
+	LastRemindedAt  *time.Time
+	// last satisfaction survey sent
+	LastSurveyedAt *time.Time
+	// digests are sent as gift-wrapped direct messages instead of being
+	// reposted publicly by the channel
+	PrivateDigest bool
+	// channel branding chosen by the subscriber, the configured defaults
+	// apply if empty
+	ChannelName    string
+	ChannelPicture string
+	// latest post or reaction of the subscriber
+	LastSeenAt *time.Time
+	// predicted risk of leaving, from 0 to 1
+	ChurnRisk       float64
+	LastReengagedAt *time.Time
+	// channels handed over to the subscriber's custody keep only their
+	// public key, digests are sent privately by the bot instead
+	ChannelPub       string
+	ClaimRequestedAt *time.Time
+	HandedOverAt     *time.Time
+}
+
+// EffectiveTier returns the tier in force at the given time, an expired
+// premium subscription falls back to free
+func (s *Subscriber) EffectiveTier(now time.Time) string {
+	if s.Tier == "" || (s.TierExpiresAt != nil && s.TierExpiresAt.Before(now)) {
+		return TierFree
+	}
+	return s.Tier
+}
+
+// Of returns the feature limits of a subscriber's effective tier
+func (t TiersConfig) Of(subscriber *Subscriber, now time.Time) TierConfig {
+	if subscriber != nil && subscriber.EffectiveTier(now) == TierPremium {
+		return t.Premium
+	}
+	return t.Free
+}
+
+type ZapReceipt struct {
+	Sender    string
+	Recipient string
+	EventId   string
+	Amount    int64
 }
 
 type FeedEntry struct {
@@ -16,9 +78,399 @@ type FeedEntry struct {
 	CreatedAt time.Time `json:"created_at"`
 	Score     float64   `json:"score"`
 	Raw       string    `json:"raw"`
+	SeenOn    []string  `json:"seen_on,omitempty"`
+}
+
+type RelayStats struct {
+	URL       string `json:"url"`
+	SeenPosts int64  `json:"seen_posts"`
+	FirstSeen int64  `json:"first_seen"`
 }
 
 type RelayInfo struct {
 	URL     string `json:"url"`
 	Purpose string `json:"purpose"`
 }
+
+// NoisyCount is an aggregate protected by differential privacy, Value is
+// meaningless if Suppressed is true
+type NoisyCount struct {
+	Value      int64 `json:"value"`
+	Suppressed bool  `json:"suppressed,omitempty"`
+}
+
+type PublicStats struct {
+	Subscribers    NoisyCount   `json:"subscribers"`
+	NewSubscribers NoisyCount   `json:"new_subscribers"`
+	Posts24h       int64        `json:"posts_24h"`
+	Authors24h     int64        `json:"authors_24h"`
+	Topics         []TopicStats `json:"topics"`
+}
+
+type TopicStats struct {
+	Topic       string     `json:"topic"`
+	Posts24h    int64      `json:"posts_24h"`
+	Subscribers NoisyCount `json:"subscribers"`
+}
+
+// ScoreDistribution summarizes scores of a sample of the feed
+type ScoreDistribution struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+	Mean  float64   `json:"mean"`
+	P50   float64   `json:"p50"`
+	P90   float64   `json:"p90"`
+	P99   float64   `json:"p99"`
+	Max   float64   `json:"max"`
+	// deviations from the baseline beyond the threshold, if any
+	Drift []string `json:"drift,omitempty"`
+}
+
+// RisingAuthor is an author whose posts are engaged with increasingly
+type RisingAuthor struct {
+	Pubkey string `json:"pubkey"`
+	// human-readable name of the author, if any
+	Label string `json:"label,omitempty"`
+	// distinct users who engaged within the last period, and the one before
+	Engagement         int64 `json:"engagement"`
+	PreviousEngagement int64 `json:"previous_engagement"`
+	// relative growth of the engagement from one period to the next
+	Growth float64 `json:"growth"`
+}
+
+type Recap struct {
+	Pubkey        string      `json:"pubkey"`
+	Since         time.Time   `json:"since"`
+	TotalFeatured int64       `json:"total_featured"`
+	TopTopics     []RecapItem `json:"top_topics"`
+	TopAuthors    []RecapItem `json:"top_authors"`
+}
+
+type RecapItem struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	// human-readable name of the key, if any
+	Label string `json:"label,omitempty"`
+}
+
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	Id         string         `json:"id"`
+	Label      string         `json:"label"`
+	Properties map[string]any `json:"properties"`
+}
+
+type GraphEdge struct {
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+}
+
+// PreferencesKind is the NIP-78 application data kind subscribers publish
+// their preferences with, identified by the PreferencesIdentifier d tag
+const (
+	PreferencesKind       = 30078
+	PreferencesIdentifier = "nossence"
+)
+
+// Preferences are the settings a subscriber publishes in the content of a
+// preferences event. Omitted fields are left unchanged.
+type Preferences struct {
+	Interests          []string `json:"interests,omitempty"`
+	Uninterested       []string `json:"uninterested,omitempty"`
+	NotificationOptOut *bool    `json:"notification_opt_out,omitempty"`
+	PrivateDigest      *bool    `json:"private_digest,omitempty"`
+	// content types of authors to see more and less of, e.g. "builders"
+	// and "memes"
+	MoreContent []string `json:"more_content,omitempty"`
+	LessContent []string `json:"less_content,omitempty"`
+}
+
+// Leaderboard ranks posts, authors and topics of the week between Start and
+// End. Zapped posts are counted in sats, new authors in likes, reposts and
+// zaps received, topics in posts.
+type Leaderboard struct {
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	ZappedPosts []RecapItem `json:"zapped_posts"`
+	NewAuthors  []RecapItem `json:"new_authors"`
+	TopTopics   []RecapItem `json:"top_topics"`
+}
+
+// ZapRing is a group of users who repeatedly zap each other
+type ZapRing struct {
+	Id         string    `json:"id"`
+	Members    []string  `json:"members"`
+	Zaps       int64     `json:"zaps"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// FeedQuery selects and ranks a feed. New ranking options are added as
+// fields so that existing callers keep working.
+type FeedQuery struct {
+	// empty for the public feed
+	Subscriber string
+	Start      time.Time
+	End        time.Time
+	Limit      int
+	// max posts of a single author, 0 for no limit
+	MaxPerAuthor int
+	// only posts tagged with this topic if not empty
+	Topic string
+	// the output the feed is served to, deciding its reuse policy
+	Output string
+}
+
+// outputs feeds are served to, see LicensingConfig
+const (
+	OutputAPI      = "api"
+	OutputDigest   = "digest"
+	OutputTrending = "trending"
+)
+
+type Interest struct {
+	Topic  string `json:"topic"`
+	Source string `json:"source"`
+}
+
+// LessFeedback asks to see less of a post, an author or topics
+type LessFeedback struct {
+	PostId string   `json:"post_id,omitempty"`
+	Author string   `json:"author,omitempty"`
+	Topics []string `json:"topics,omitempty"`
+}
+
+// DigestMeta describes a digest pushed to a channel
+type DigestMeta struct {
+	Channel    string    `json:"channel"`
+	Subscriber string    `json:"subscriber,omitempty"`
+	PushedAt   time.Time `json:"pushed_at"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	// whether the window was widened for lack of candidates
+	Widened bool `json:"widened"`
+	Size    int  `json:"size"`
+	// note asking to rate the digest, if any
+	FeedbackNote string `json:"feedback_note,omitempty"`
+}
+
+// DigestFeedback is a rating of a digest, given by reacting or replying to
+// its feedback note
+type DigestFeedback struct {
+	Note       string `json:"note"`
+	Subscriber string `json:"subscriber,omitempty"`
+	// end of the run the digest was pushed in
+	Run    time.Time `json:"run"`
+	Pubkey string    `json:"pubkey"`
+	Score  int       `json:"score"`
+	// "reaction" or "reply"
+	Source string    `json:"source"`
+	At     time.Time `json:"at"`
+}
+
+// FollowedAuthor is an author with their number of followers and the relays
+// they write to
+type FollowedAuthor struct {
+	Pubkey    string   `json:"pubkey"`
+	Followers int      `json:"followers"`
+	Relays    []string `json:"relays"`
+}
+
+// DeliveryReceipt tells whether the digest of a run was accepted by a relay
+type DeliveryReceipt struct {
+	Subscriber string `json:"subscriber"`
+	Channel    string `json:"channel"`
+	// end of the run the digest was first pushed in
+	Run      time.Time `json:"run"`
+	Accepted bool      `json:"accepted"`
+	Error    string    `json:"error,omitempty"`
+	// publishes attempted, failed ones are retried by the next runs
+	Attempts int       `json:"attempts"`
+	At       time.Time `json:"at"`
+	// correlation ID of the last attempt, found in its logs
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+type ScoringWeights struct {
+	Similar float64 `json:"similar"`
+	Follow  float64 `json:"follow"`
+	Default float64 `json:"default"`
+}
+
+// WeightProposal is a weight adjustment waiting for operator approval
+type WeightProposal struct {
+	Current  ScoringWeights `json:"current"`
+	Proposed ScoringWeights `json:"proposed"`
+	// engagement rate of delivered posts by the signal that ranked them
+	Rates     map[string]float64 `json:"rates"`
+	Samples   map[string]int64   `json:"samples"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// SubscriberBundle carries subscribers between deployments. Channel secrets
+// are encrypted with a key derived from the operator's passphrase and Salt.
+type SubscriberBundle struct {
+	Version     int                `json:"version"`
+	ExportedAt  time.Time          `json:"exported_at"`
+	Salt        string             `json:"salt"`
+	Subscribers []SubscriberExport `json:"subscribers"`
+}
+
+type SubscriberExport struct {
+	Pubkey                 string     `json:"pubkey"`
+	EncryptedChannelSecret string     `json:"encrypted_channel_secret"`
+	SubscribedAt           *time.Time `json:"subscribed_at,omitempty"`
+	UnsubscribedAt         *time.Time `json:"unsubscribed_at,omitempty"`
+	Tier                   string     `json:"tier"`
+	TierExpiresAt          *time.Time `json:"tier_expires_at,omitempty"`
+	LastPushedAt           *time.Time `json:"last_pushed_at,omitempty"`
+	NotifyOptOut           bool       `json:"notify_opt_out"`
+	Interests              []Interest `json:"interests"`
+}
+
+// IngestReport tells which of the events pushed to the API were stored
+type IngestReport struct {
+	Accepted int              `json:"accepted"`
+	Rejected []IngestRejected `json:"rejected"`
+}
+
+type IngestRejected struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// BackfillReport tells how many raw events were backfilled and which were
+// left in the archive
+type BackfillReport struct {
+	Backfilled int              `json:"backfilled"`
+	Failed     []BackfillFailed `json:"failed"`
+}
+
+type BackfillFailed struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type ImportReport struct {
+	Imported []string `json:"imported"`
+	Skipped  []string `json:"skipped"`
+	Failed   []string `json:"failed"`
+}
+
+// ShardKey hashes a pubkey into the key used for shard assignment
+func ShardKey(pubkey string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(pubkey))
+	return h.Sum64()
+}
+
+// ShardOf assigns a key to one of n shards with jump consistent hashing, so
+// that growing the number of shards only moves keys to the new shards.
+// See https://arxiv.org/abs/1406.2294
+func ShardOf(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Serves tells whether this instance handles subscribers of the given key
+func (c ShardingConfig) Serves(key uint64) bool {
+	if c.Shards <= 1 || len(c.Serve) == 0 {
+		return true
+	}
+	return slices.Contains(c.Serve, ShardOf(key, c.Shards))
+}
+
+// EnterSafeMode turns off enrichment and the jobs that aren't needed to
+// deliver digests, e.g. after repeated crashes
+func (c *Config) EnterSafeMode() {
+	c.Enrichment.Enabled = false
+	c.Tuning.Enabled = false
+	c.Reputation.Enabled = false
+	c.Authors.Enabled = false
+	c.ZapRings.Enabled = false
+	c.Profiling.Enabled = false
+	c.ScoreDrift.Enabled = false
+	c.Retention.Enabled = false
+	c.Churn.Enabled = false
+	c.Survey.Enabled = false
+	c.Nudge.Enabled = false
+	c.Discovery.Enabled = false
+	c.Recap.Enabled = false
+	c.Bot.Trending.Enabled = false
+	c.Bot.Leaderboard.Enabled = false
+	c.Crawler.Backfill.Enabled = false
+	c.Crawler.Expansion.Enabled = false
+	c.Scoring.Materialized = false
+}
+
+// InstanceName names this instance in leases, the hostname if not
+// configured
+func (c HandoverConfig) InstanceName() string {
+	if c.Instance != "" {
+		return c.Instance
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "nossence"
+}
+
+// SurveyResponse is a subscriber's answer to a satisfaction survey
+type SurveyResponse struct {
+	Pubkey     string    `json:"pubkey"`
+	Score      int       `json:"score"`
+	AskedAt    time.Time `json:"askedAt"`
+	AnsweredAt time.Time `json:"answeredAt"`
+}
+
+// ChurnRisk is the predicted risk of a subscriber to leave
+type ChurnRisk struct {
+	Pubkey      string     `json:"pubkey"`
+	Risk        float64    `json:"risk"`
+	LastSeenAt  *time.Time `json:"lastSeenAt,omitempty"`
+	ReengagedAt *time.Time `json:"reengagedAt,omitempty"`
+}
+
+// Lease gives an instance exclusive ownership of a job until ExpiresAt,
+// unless it renews it. Events created before Cursor were handled by the
+// previous owners.
+type Lease struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Cursor    time.Time `json:"cursor"`
+}
+
+// Checkpoint records how far a job paging through subscribers went in the
+// run ending at End, so that it resumes there after a restart
+type Checkpoint struct {
+	Name   string        `json:"name"`
+	End    time.Time     `json:"end"`
+	Window time.Duration `json:"window"`
+	// subscribers, or events of a backfill, handled so far
+	Offset int `json:"offset"`
+	// pubkey of the last subscriber handled, the run resumes after it
+	After string `json:"after,omitempty"`
+	Done  bool   `json:"done"`
+}
+
+// Profile is the metadata a user publishes in kind 0 events
+type Profile struct {
+	Pubkey      string    `json:"pubkey"`
+	Name        string    `json:"name,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Picture     string    `json:"picture,omitempty"`
+	Nip05       string    `json:"nip05,omitempty"`
+	Lud16       string    `json:"lud16,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardOf(t *testing.T) {
+	moved := 0
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		key := ShardKey(fmt.Sprintf("pubkey-%d", i))
+		shard := ShardOf(key, 4)
+		counts[shard]++
+
+		// growing to 5 shards only moves keys to the new shard
+		if grown := ShardOf(key, 5); grown != shard {
+			assert.Equal(t, 4, grown)
+			moved++
+		}
+	}
+
+	for _, c := range counts {
+		assert.InDelta(t, 250, c, 60)
+	}
+	assert.InDelta(t, 200, moved, 60)
+}
+
+func TestServes(t *testing.T) {
+	key := ShardKey("pubkey")
+	assert.True(t, ShardingConfig{}.Serves(key))
+	assert.True(t, ShardingConfig{Shards: 2}.Serves(key))
+
+	shard := ShardOf(key, 2)
+	assert.True(t, ShardingConfig{Shards: 2, Serve: []int{shard}}.Serves(key))
+	assert.False(t, ShardingConfig{Shards: 2, Serve: []int{1 - shard}}.Serves(key))
+}
+
+func TestEnterSafeMode(t *testing.T) {
+	config := &Config{}
+	config.Enrichment.Enabled = true
+	config.Bot.Trending.Enabled = true
+	config.Writer.Enabled = true
+	config.Archive.Enabled = true
+	config.Crawler.Backfill.Enabled = true
+	config.Scoring.Materialized = true
+	config.Recap.Enabled = true
+
+	config.EnterSafeMode()
+	assert.False(t, config.Enrichment.Enabled)
+	assert.False(t, config.Bot.Trending.Enabled)
+	assert.False(t, config.Crawler.Backfill.Enabled)
+	assert.False(t, config.Scoring.Materialized)
+	assert.False(t, config.Recap.Enabled)
+	// storing events and delivering digests is left alone
+	assert.True(t, config.Writer.Enabled)
+	assert.True(t, config.Archive.Enabled)
+}
@@ -0,0 +1,286 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var logger = log.New("module", "wal")
+
+const (
+	segmentSuffix = ".log"
+	// the replay progress of a segment is kept next to it
+	offsetSuffix = ".offset"
+	// replay progress is saved every so many records
+	offsetInterval = 100
+)
+
+// Log is an append-only log of newline separated records split into segment
+// files. Records are synced to disk before Append returns. Every appended
+// record must be acknowledged once it's processed. A sealed segment is
+// deleted when all its records are acknowledged without error, otherwise it
+// stays on disk until it's replayed successfully.
+type Log struct {
+	dir         string
+	segmentSize int
+
+	mu       sync.Mutex
+	active   *Segment
+	inflight map[string]*Segment
+
+	// records failing to be replayed maxAttempts times are handed to
+	// deadLetter, so that they don't hold back their segment forever
+	maxAttempts int
+	deadLetter  func(record []byte, cause error) error
+}
+
+// replayState is the progress of a segment's replay: records before Offset
+// were replayed, the one at Offset failed Attempts times
+type replayState struct {
+	Offset   int `json:"offset"`
+	Attempts int `json:"attempts"`
+}
+
+type Segment struct {
+	name        string
+	file        *os.File
+	count       int
+	outstanding int
+	failed      bool
+	sealed      bool
+}
+
+func Open(dir string, segmentSize int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Log{
+		dir:         dir,
+		segmentSize: segmentSize,
+		inflight:    make(map[string]*Segment),
+	}, nil
+}
+
+// SetDeadLetter hands records failing to be replayed maxAttempts times to
+// fn, replay moves on past them once fn succeeds
+func (l *Log) SetDeadLetter(maxAttempts int, fn func(record []byte, cause error) error) {
+	l.maxAttempts = maxAttempts
+	l.deadLetter = fn
+}
+
+// Append writes a record to the active segment and syncs it to disk. The
+// record must not contain a newline.
+func (l *Log) Append(record []byte) (*Segment, error) {
+	if bytes.IndexByte(record, '\n') >= 0 {
+		return nil, fmt.Errorf("record contains newline")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active == nil {
+		name := fmt.Sprintf("%020d%s", time.Now().UnixNano(), segmentSuffix)
+		file, err := os.OpenFile(filepath.Join(l.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		l.active = &Segment{name: name, file: file}
+		l.inflight[name] = l.active
+	}
+
+	seg := l.active
+	line := make([]byte, 0, len(record)+1)
+	line = append(append(line, record...), '\n')
+	if _, err := seg.file.Write(line); err != nil {
+		return nil, err
+	}
+	if err := seg.file.Sync(); err != nil {
+		return nil, err
+	}
+	seg.count++
+	seg.outstanding++
+
+	if seg.count >= l.segmentSize {
+		l.seal(seg)
+	}
+	return seg, nil
+}
+
+// Ack marks a record of the segment as processed
+func (l *Log) Ack(seg *Segment, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	seg.outstanding--
+	if err != nil {
+		seg.failed = true
+	}
+	l.release(seg)
+}
+
+// Rotate seals the active segment so that a new one is started on the next
+// append
+func (l *Log) Rotate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active != nil {
+		l.seal(l.active)
+	}
+}
+
+// Pending lists sealed segments holding records that failed or were left
+// over by a previous run, oldest first
+func (l *Log) Pending() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		if seg, ok := l.inflight[name]; ok && (!seg.sealed || seg.outstanding > 0) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Replay feeds the records of a pending segment to fn in order, resuming
+// after those replayed before. It stops at the first record failing and
+// returns its error, the record is retried first by the next replay unless
+// it's dead-lettered. The segment is deleted once all records are replayed.
+func (l *Log) Replay(name string, fn func([]byte) error) (int, error) {
+	path := filepath.Join(l.dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	state, err := l.loadState(name)
+	if err != nil {
+		return 0, err
+	}
+
+	total, offset := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for ; scanner.Scan(); offset++ {
+		line := scanner.Bytes()
+		if offset < state.Offset || len(line) == 0 {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			if state.Offset == offset {
+				state.Attempts++
+			} else {
+				state.Offset, state.Attempts = offset, 1
+			}
+			if l.deadLetter == nil || state.Attempts < l.maxAttempts {
+				l.saveState(name, state)
+				return total, err
+			}
+			if dlErr := l.deadLetter(line, err); dlErr != nil {
+				l.saveState(name, state)
+				return total, fmt.Errorf("failed to dead-letter record: %w", dlErr)
+			}
+			logger.Warn("Dead-lettered WAL record", "segment", name, "offset", offset, "attempts", state.Attempts, "err", err)
+		} else {
+			total++
+		}
+
+		state.Offset, state.Attempts = offset+1, 0
+		if state.Offset%offsetInterval == 0 {
+			l.saveState(name, state)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		l.saveState(name, state)
+		return total, err
+	}
+
+	l.mu.Lock()
+	delete(l.inflight, name)
+	l.mu.Unlock()
+	if err := os.Remove(path); err != nil {
+		return total, err
+	}
+	if err := os.Remove(path + offsetSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Failed to remove segment offset", "name", name, "err", err)
+	}
+	return total, nil
+}
+
+func (l *Log) loadState(name string) (replayState, error) {
+	var state replayState
+	raw, err := os.ReadFile(filepath.Join(l.dir, name+offsetSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(raw, &state)
+}
+
+// saveState keeps the replay progress of the segment, a failure to save it
+// only means records are replayed again
+func (l *Log) saveState(name string, state replayState) {
+	raw, err := json.Marshal(state)
+	if err == nil {
+		path := filepath.Join(l.dir, name+offsetSuffix)
+		if err = os.WriteFile(path+".tmp", raw, 0644); err == nil {
+			err = os.Rename(path+".tmp", path)
+		}
+	}
+	if err != nil {
+		logger.Warn("Failed to save segment offset", "name", name, "err", err)
+	}
+}
+
+func (l *Log) seal(seg *Segment) {
+	if err := seg.file.Sync(); err != nil {
+		logger.Warn("Failed to sync segment", "name", seg.name, "err", err)
+	}
+	if err := seg.file.Close(); err != nil {
+		logger.Warn("Failed to close segment", "name", seg.name, "err", err)
+	}
+	seg.sealed = true
+	if l.active == seg {
+		l.active = nil
+	}
+	l.release(seg)
+}
+
+// release deletes a sealed segment once all its records are processed
+func (l *Log) release(seg *Segment) {
+	if !seg.sealed || seg.outstanding > 0 || seg.failed {
+		return
+	}
+	delete(l.inflight, seg.name)
+	if err := os.Remove(filepath.Join(l.dir, seg.name)); err != nil {
+		logger.Warn("Failed to remove segment", "name", seg.name, "err", err)
+	}
+}
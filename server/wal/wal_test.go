@@ -0,0 +1,129 @@
+package wal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckRemovesSegment(t *testing.T) {
+	l, err := Open(t.TempDir(), 2)
+	assert.NoError(t, err)
+
+	first, err := l.Append([]byte("a"))
+	assert.NoError(t, err)
+	second, err := l.Append([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	pending, _ := l.Pending()
+	assert.Empty(t, pending, "segment with outstanding records is not pending")
+
+	l.Ack(first, nil)
+	l.Ack(second, nil)
+	pending, _ = l.Pending()
+	assert.Empty(t, pending)
+}
+
+func TestReplayFailedSegment(t *testing.T) {
+	l, err := Open(t.TempDir(), 10)
+	assert.NoError(t, err)
+
+	seg, _ := l.Append([]byte("a"))
+	l.Ack(seg, errors.New("database unavailable"))
+	seg, _ = l.Append([]byte("b"))
+	l.Ack(seg, nil)
+
+	pending, _ := l.Pending()
+	assert.Empty(t, pending, "active segment is not pending")
+
+	l.Rotate()
+	pending, _ = l.Pending()
+	assert.Len(t, pending, 1)
+
+	// failed replay keeps the segment
+	_, err = l.Replay(pending[0], func(record []byte) error {
+		return errors.New("still down")
+	})
+	assert.Error(t, err)
+	pending, _ = l.Pending()
+	assert.Len(t, pending, 1)
+
+	records := []string{}
+	total, err := l.Replay(pending[0], func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []string{"a", "b"}, records)
+
+	pending, _ = l.Pending()
+	assert.Empty(t, pending)
+}
+
+func TestReplayResumes(t *testing.T) {
+	l, err := Open(t.TempDir(), 3)
+	assert.NoError(t, err)
+	for _, record := range []string{"a", "b", "c"} {
+		seg, _ := l.Append([]byte(record))
+		l.Ack(seg, errors.New("database unavailable"))
+	}
+	pending, _ := l.Pending()
+	assert.Len(t, pending, 1)
+
+	records := []string{}
+	_, err = l.Replay(pending[0], func(record []byte) error {
+		if string(record) == "b" {
+			return errors.New("still down")
+		}
+		records = append(records, string(record))
+		return nil
+	})
+	assert.Error(t, err)
+
+	// records replayed before aren't replayed again
+	total, err := l.Replay(pending[0], func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []string{"a", "b", "c"}, records)
+	pending, _ = l.Pending()
+	assert.Empty(t, pending)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	l, err := Open(t.TempDir(), 2)
+	assert.NoError(t, err)
+	deadLetters := []string{}
+	l.SetDeadLetter(2, func(record []byte, cause error) error {
+		deadLetters = append(deadLetters, string(record))
+		return nil
+	})
+	for _, record := range []string{"bad", "good"} {
+		seg, _ := l.Append([]byte(record))
+		l.Ack(seg, errors.New("invalid"))
+	}
+	pending, _ := l.Pending()
+
+	replay := func(record []byte) error {
+		if string(record) == "bad" {
+			return errors.New("invalid")
+		}
+		return nil
+	}
+	_, err = l.Replay(pending[0], replay)
+	assert.Error(t, err)
+	assert.Empty(t, deadLetters)
+
+	// the record failing again is moved out of the way
+	total, err := l.Replay(pending[0], replay)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []string{"bad"}, deadLetters)
+	pending, _ = l.Pending()
+	assert.Empty(t, pending)
+}